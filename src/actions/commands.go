@@ -0,0 +1,234 @@
+package actions
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/0h41/pulsekontrol/src/activity"
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/0h41/pulsekontrol/src/pulseaudio"
+)
+
+// The commands of the scripting interfaces, like the D-Bus service and the
+// control socket, checking their arguments before running the actions
+
+// State is the state reported to scripts: the controls with their sources,
+// the profiles and the scenes, and the default devices
+type State struct {
+	ActiveProfile string                            `json:"activeProfile"`
+	Profiles      []string                          `json:"profiles"`
+	Scenes        []string                          `json:"scenes"`
+	Controls      []configuration.ControlAssignment `json:"controls"`
+	ReadOnly      bool                              `json:"readOnly"`
+	DefaultOutput string                            `json:"defaultOutput,omitempty"` // By its alias or description
+	DefaultInput  string                            `json:"defaultInput,omitempty"`
+}
+
+// State returns the current State. The default devices are those PulseAudio
+// last reported, without asking it.
+func (e *Executor) State() State {
+	config := e.configManager.GetConfigSnapshot()
+	state := State{
+		ActiveProfile: e.configManager.ActiveProfile(),
+		Profiles:      e.configManager.ProfileNames(),
+		Scenes:        e.configManager.SceneNames(),
+		Controls:      config.Assignments(),
+		ReadOnly:      e.configManager.ReadOnly(),
+	}
+	for _, device := range e.paClient.CachedStreams() {
+		if !device.Default {
+			continue
+		}
+		name := device.Name
+		if alias := config.Alias(device.Type, device.Name); alias != "" {
+			name = alias
+		}
+		switch device.Type {
+		case configuration.OutputDevice:
+			state.DefaultOutput = name
+		case configuration.InputDevice:
+			state.DefaultInput = name
+		}
+	}
+	return state
+}
+
+// SetControl sets an existing slider or knob to a value (0-100) and the
+// volume of its sources
+func (e *Executor) SetControl(origin activity.Origin, controlType string, controlId string, value int) error {
+	config := e.configManager.GetConfigSnapshot()
+	if actual, ok := config.ControlType(controlId); !ok || actual != controlType {
+		return fmt.Errorf("no %s %s", controlType, controlId)
+	}
+	if value < 0 || value > 100 {
+		return fmt.Errorf("value %d must be between 0 and 100", value)
+	}
+	e.ApplyControlValue(origin, controlType, controlId, value)
+	return nil
+}
+
+// ToggleMuteSelector flips the muted state of a slider or knob, given by its
+// id, or of a source given as <type>:<name> (the name may be an alias), and
+// returns the new state
+func (e *Executor) ToggleMuteSelector(origin activity.Origin, selector string) (bool, error) {
+	config := e.configManager.GetConfigSnapshot()
+	if controlType, ok := config.ControlType(selector); ok {
+		return e.ToggleControlMute(origin, controlType, selector)
+	}
+
+	typeName, name, ok := strings.Cut(selector, ":")
+	if !ok || name == "" {
+		return false, fmt.Errorf("no slider or knob %s, sources are given as <type>:<name>", selector)
+	}
+	sourceType, ok := configuration.ParseTargetType(typeName)
+	if !ok {
+		return false, fmt.Errorf("unknown source type %q", typeName)
+	}
+	return e.ToggleSourceMute(origin, &configuration.TypedTarget{
+		Type: sourceType,
+		Name: config.ResolveAlias(sourceType, name),
+	})
+}
+
+// SwitchProfile activates a profile
+func (e *Executor) SwitchProfile(origin activity.Origin, name string) error {
+	e.Record(origin, "SwitchProfile", name, nil)
+	return e.configManager.SwitchProfile(name)
+}
+
+// selectSources returns the targets of a selector with the streams or
+// devices each one matches, leaving out targets without any. An exact name
+// may be an alias.
+func (e *Executor) selectSources(selector pulseaudio.Selector) ([]configuration.TypedTarget, map[configuration.TypedTarget][]pulseaudio.Stream, error) {
+	if selector.Mode == "" || selector.Mode == pulseaudio.MatchExact {
+		config := e.configManager.GetConfigSnapshot()
+		selector.Name = config.ResolveAlias(selector.Type, selector.Name)
+	}
+	targets, err := e.paClient.SelectTargets(selector)
+	if err != nil {
+		return nil, nil, err
+	}
+	matches := make(map[configuration.TypedTarget][]pulseaudio.Stream)
+	var matched []configuration.TypedTarget
+	for _, target := range targets {
+		if streams := e.paClient.MatchTarget(&target); len(streams) > 0 {
+			matches[target] = streams
+			matched = append(matched, target)
+		}
+	}
+	if len(matched) == 0 {
+		return nil, nil, fmt.Errorf("no %s matches %q", selector.Type, selector.Name)
+	}
+	return matched, matches, nil
+}
+
+// DescribeStreams names streams or devices with their program binaries
+func DescribeStreams(streams []pulseaudio.Stream) string {
+	names := make([]string, len(streams))
+	for i, stream := range streams {
+		names[i] = stream.Name
+		if stream.BinaryName != "" {
+			names[i] += " (" + stream.BinaryName + ")"
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// SetSourcesVolume sets the volume (0-100) of the streams or devices matching
+// a selector, which are not assigned to a control for it, and describes the
+// changes
+func (e *Executor) SetSourcesVolume(origin activity.Origin, selector pulseaudio.Selector, volume int) ([]string, error) {
+	if volume < 0 || volume > 100 {
+		return nil, fmt.Errorf("volume %d must be between 0 and 100", volume)
+	}
+	targets, matches, err := e.selectSources(selector)
+	if err != nil {
+		return nil, err
+	}
+	var changes []string
+	for _, target := range targets {
+		e.Record(origin, "SetSourceVolume", DescribeSource(configuration.Source{Type: target.Type, Name: target.Name, BinaryName: target.BinaryName}), volume)
+		action := configuration.Action{Type: configuration.SetVolume, Target: &target}
+		if err := e.paClient.ProcessVolumeAction(action, float32(volume)/100.0); err != nil {
+			return changes, err
+		}
+		changes = append(changes, fmt.Sprintf("Set volume of %s %s to %d%%", target.Type, DescribeStreams(matches[target]), volume))
+	}
+	return changes, nil
+}
+
+// StepSourcesVolume moves the volume of the streams or devices matching a
+// selector by a step in percent, down for a negative one, and describes the
+// changes
+func (e *Executor) StepSourcesVolume(origin activity.Origin, selector pulseaudio.Selector, step int) ([]string, error) {
+	if step == 0 || step < -configuration.MaxStepSize || step > configuration.MaxStepSize {
+		return nil, fmt.Errorf("step %d must be between 1 and %d", max(step, -step), configuration.MaxStepSize)
+	}
+	targets, matches, err := e.selectSources(selector)
+	if err != nil {
+		return nil, err
+	}
+	var changes []string
+	for _, target := range targets {
+		volume, err := e.StepSourceVolume(origin, &target, step)
+		if err != nil {
+			return changes, err
+		}
+		changes = append(changes, fmt.Sprintf("Set volume of %s %s to %d%%", target.Type, DescribeStreams(matches[target]), volume))
+	}
+	return changes, nil
+}
+
+// SetSourcesMuted sets the muted state of the streams or devices matching a
+// selector to what mute returns for their current state, following the
+// first stream of each target, and describes the changes
+func (e *Executor) SetSourcesMuted(origin activity.Origin, selector pulseaudio.Selector, mute func(muted bool) bool) ([]string, error) {
+	targets, matches, err := e.selectSources(selector)
+	if err != nil {
+		return nil, err
+	}
+	var changes []string
+	for _, target := range targets {
+		muted := mute(matches[target][0].Muted())
+		e.Record(origin, "SetSourceMuted", DescribeSource(configuration.Source{Type: target.Type, Name: target.Name, BinaryName: target.BinaryName}), muted)
+		if err := e.paClient.SetTargetMute(&target, muted); err != nil {
+			return changes, err
+		}
+		verb := "Unmuted"
+		if muted {
+			verb = "Muted"
+		}
+		changes = append(changes, fmt.Sprintf("%s %s %s", verb, target.Type, DescribeStreams(matches[target])))
+	}
+	return changes, nil
+}
+
+// SetDefaultDevice makes the only output or input device matching a selector
+// the default and describes the change
+func (e *Executor) SetDefaultDevice(origin activity.Origin, selector pulseaudio.Selector) (string, error) {
+	if selector.Type != configuration.OutputDevice && selector.Type != configuration.InputDevice {
+		return "", fmt.Errorf("only an OutputDevice or InputDevice can be the default, not a %s", selector.Type)
+	}
+	targets, matches, err := e.selectSources(selector)
+	if err != nil {
+		return "", err
+	}
+	var devices []pulseaudio.Stream
+	for _, target := range targets {
+		devices = append(devices, matches[target]...)
+	}
+	if len(devices) > 1 {
+		return "", fmt.Errorf("%q matches more than one device: %s", selector.Name, DescribeStreams(devices))
+	}
+
+	e.Record(origin, "SetDefaultDevice", DescribeSource(configuration.Source{Type: selector.Type, Name: devices[0].Name}), nil)
+	if selector.Type == configuration.InputDevice {
+		err = e.paClient.SetDefaultInput(configuration.Action{Type: configuration.SetDefaultInput, Target: &configuration.Target{Name: devices[0].Name}})
+	} else {
+		err = e.paClient.SetDefaultOutput(configuration.Action{Type: configuration.SetDefaultOutput, Target: &configuration.Target{Name: devices[0].Name}})
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Default %s is now %s", selector.Type, devices[0].Name), nil
+}
@@ -0,0 +1,248 @@
+package actions
+
+import (
+	"sync"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/activity"
+	"github.com/0h41/pulsekontrol/src/supervise"
+)
+
+// The fast path of control values. Handlers of messages that come in bursts,
+// like a MIDI fader being swept, only queue the value. A worker per control
+// sets the volumes of the newest value and hands it to a single writer, which
+// stores it in the configuration; storing notifies the web interface and
+// schedules the save. Neither slows down the volumes, and the last value of a
+// burst is always applied and stored.
+
+// volumeInterval bounds how often the volumes of a control are set, values
+// queued in between are replaced by the newest
+const volumeInterval = 5 * time.Millisecond
+
+// controlKey identifies a slider or knob
+type controlKey struct {
+	controlType string
+	controlId   string
+}
+
+// queuedValue is a control value waiting to be applied or stored
+type queuedValue struct {
+	origin     activity.Origin
+	value      int
+	generation uint64 // Generation of the control when the value was queued
+}
+
+// valueQueue holds the newest value of each control until its worker applies
+// it, and the applied values until the writer stores them. It holds at most
+// two values per control.
+type valueQueue struct {
+	mu          sync.Mutex
+	changed     *sync.Cond // Broadcast when a value was applied or stored
+	pending     map[controlKey]queuedValue
+	workers     map[controlKey]chan struct{}
+	applying    int
+	applied     map[controlKey]queuedValue
+	generations map[controlKey]uint64 // Bumped by every queued or directly set value
+	storing     bool
+	store       chan struct{}
+	started     bool
+	closed      bool
+	// storeMu keeps a value set directly and the writer from storing at the
+	// same time, so that a value queued before is never stored after it
+	storeMu sync.Mutex
+}
+
+func newValueQueue() *valueQueue {
+	q := &valueQueue{
+		pending:     make(map[controlKey]queuedValue),
+		workers:     make(map[controlKey]chan struct{}),
+		applied:     make(map[controlKey]queuedValue),
+		generations: make(map[controlKey]uint64),
+		store:       make(chan struct{}, 1),
+	}
+	q.changed = sync.NewCond(&q.mu)
+	return q
+}
+
+// QueueControlValue sets a control to a value (0-100) without waiting for
+// PulseAudio or the configuration: the worker of the control sets the
+// volumes of its sources, then the value is stored and recorded like with
+// ApplyControlValue. A value queued while the previous one is still waiting
+// replaces it. Values queued after Close are dropped.
+func (e *Executor) QueueControlValue(origin activity.Origin, controlType string, controlId string, value int) {
+	q := e.values
+	key := controlKey{controlType, controlId}
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	if !q.started {
+		q.started = true
+		supervise.Go("actions.store", e.storeValues)
+	}
+	q.generations[key]++
+	q.pending[key] = queuedValue{origin: origin, value: value, generation: q.generations[key]}
+	wake, ok := q.workers[key]
+	if !ok {
+		wake = make(chan struct{}, 1)
+		q.workers[key] = wake
+		supervise.Go("actions.volume."+controlType+"."+controlId, func() {
+			e.applyValues(key, wake)
+		})
+	}
+
+	select {
+	case wake <- struct{}{}:
+	default:
+		// The worker is already woken up and takes the newest value
+	}
+	q.mu.Unlock()
+}
+
+// applyValues is the worker of a control, setting the volumes of its newest
+// value at most every volumeInterval
+func (e *Executor) applyValues(key controlKey, wake chan struct{}) {
+	var last time.Time
+	for range wake {
+		if wait := volumeInterval - time.Since(last); wait > 0 {
+			time.Sleep(wait)
+		}
+		if e.applyValue(key) {
+			last = time.Now()
+		}
+	}
+}
+
+// applyValue sets the volumes of the newest value of a control, if there is
+// one, and passes it on to be stored unless a newer value was queued or set
+// directly meanwhile
+func (e *Executor) applyValue(key controlKey) bool {
+	q := e.values
+	q.mu.Lock()
+	queued, ok := q.pending[key]
+	if !ok {
+		q.mu.Unlock()
+		return false
+	}
+	delete(q.pending, key)
+	q.applying++
+	q.mu.Unlock()
+
+	defer func() {
+		q.mu.Lock()
+		q.applying--
+		if queued.generation == q.generations[key] {
+			q.applied[key] = queued
+		}
+		q.changed.Broadcast()
+		if !q.closed {
+			select {
+			case q.store <- struct{}{}:
+			default:
+			}
+		}
+		q.mu.Unlock()
+	}()
+	e.ApplyControlVolumes(key.controlType, key.controlId, queued.value)
+	return true
+}
+
+// storeValues is the writer, storing applied values in the configuration
+func (e *Executor) storeValues() {
+	q := e.values
+	for range q.store {
+		q.mu.Lock()
+		values := q.applied
+		q.applied = make(map[controlKey]queuedValue)
+		q.storing = true
+		q.mu.Unlock()
+
+		func() {
+			defer func() {
+				q.mu.Lock()
+				q.storing = false
+				q.changed.Broadcast()
+				q.mu.Unlock()
+			}()
+			for key, queued := range values {
+				e.storeValue(key, queued)
+			}
+		}()
+	}
+}
+
+// storeValue stores an applied value unless a newer one was queued or set
+// directly since it was queued
+func (e *Executor) storeValue(key controlKey, queued queuedValue) {
+	q := e.values
+	q.storeMu.Lock()
+	defer q.storeMu.Unlock()
+
+	q.mu.Lock()
+	stale := queued.generation != q.generations[key]
+	q.mu.Unlock()
+	if stale {
+		return
+	}
+	if e.configManager.UpdateControlValue(queued.origin, key.controlType, key.controlId, queued.value) {
+		e.Record(queued.origin, "SetControlValue", key.controlId, queued.value)
+	}
+}
+
+// setDirectly stores a value set directly instead of queued. The values of
+// the control that are still waiting are dropped, and those already taken by
+// its worker or the writer are no longer stored.
+func (e *Executor) setDirectly(origin activity.Origin, controlType string, controlId string, value int) {
+	q := e.values
+	key := controlKey{controlType, controlId}
+	q.storeMu.Lock()
+	defer q.storeMu.Unlock()
+
+	q.mu.Lock()
+	q.generations[key]++
+	delete(q.pending, key)
+	delete(q.applied, key)
+	q.changed.Broadcast()
+	q.mu.Unlock()
+
+	e.configManager.UpdateControlValue(origin, controlType, controlId, value)
+}
+
+// Close stops the workers of queued values, after WaitQueued at shutdown.
+// Values queued afterwards are dropped.
+func (e *Executor) Close() {
+	q := e.values
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	for _, wake := range q.workers {
+		close(wake)
+	}
+	close(q.store)
+}
+
+// WaitQueued waits until the queued control values are applied and stored,
+// for shutdown before the configuration is saved. It returns false if that
+// took longer than timeout.
+func (e *Executor) WaitQueued(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		q := e.values
+		q.mu.Lock()
+		for len(q.pending) > 0 || q.applying > 0 || len(q.applied) > 0 || q.storing {
+			q.changed.Wait()
+		}
+		q.mu.Unlock()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
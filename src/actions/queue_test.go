@@ -0,0 +1,173 @@
+package actions
+
+import (
+	"math"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/activity"
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/0h41/pulsekontrol/src/internal/testutil"
+)
+
+// newTestExecutor returns an executor of the default configuration with
+// Firefox assigned to slider1, on a fake audio system playing it
+func newTestExecutor(t testing.TB) (*Executor, *configuration.ConfigManager, *testutil.FakeBackend) {
+	t.Helper()
+	config := configuration.GetDefaultConfig()
+	slider := config.Controls.Sliders["slider1"]
+	slider.Sources = []configuration.Source{{Type: configuration.PlaybackStream, Name: "Firefox"}}
+	config.Controls.Sliders["slider1"] = slider
+
+	cm := configuration.NewConfigManager(config, filepath.Join(t.TempDir(), "config.yaml"))
+	cm.SetReadOnly(true)
+	backend := testutil.NewFakeBackend(testutil.FakeStream{Type: configuration.PlaybackStream, Name: "Firefox"})
+	executor := NewExecutor(backend, cm, activity.NewLog(10))
+	t.Cleanup(executor.Close)
+	return executor, cm, backend
+}
+
+// queueGoroutines counts the running workers and writers of queued values
+func queueGoroutines() int {
+	buf := make([]byte, 1<<20)
+	stacks := string(buf[:runtime.Stack(buf, true)])
+	return strings.Count(stacks, "actions.(*Executor).applyValues") + strings.Count(stacks, "actions.(*Executor).storeValues")
+}
+
+func TestQueuedValueIsApplied(t *testing.T) {
+	executor, cm, backend := newTestExecutor(t)
+
+	for value := 0; value <= 40; value++ {
+		executor.QueueControlValue(activity.Midi(), "slider", "slider1", value)
+	}
+	if !executor.WaitQueued(time.Second) {
+		t.Fatal("queued values not applied in time")
+	}
+
+	if value := cm.GetConfigSnapshot().Controls.Sliders["slider1"].Value; value != 40 {
+		t.Errorf("slider1 stored %d, want the last queued 40", value)
+	}
+	changes := backend.VolumeChanges()
+	if len(changes) == 0 || changes[len(changes)-1].Volume != 0.4 {
+		t.Errorf("got volume changes %v, want the last at 0.4", changes)
+	}
+}
+
+func TestDirectValueWinsOverQueued(t *testing.T) {
+	executor, cm, backend := newTestExecutor(t)
+
+	// The worker is still setting the volume of the queued value when the
+	// value is set directly
+	backend.SetTimeout(50*time.Millisecond, 0)
+	backend.SetHung(true)
+	executor.QueueControlValue(activity.Midi(), "slider", "slider1", 30)
+	time.Sleep(10 * time.Millisecond)
+	executor.ApplyControlValue(activity.Web("test"), "slider", "slider1", 70)
+
+	if !executor.WaitQueued(time.Second) {
+		t.Fatal("queued values not applied in time")
+	}
+	if value := cm.GetConfigSnapshot().Controls.Sliders["slider1"].Value; value != 70 {
+		t.Errorf("slider1 stored %d, want the value set directly", value)
+	}
+}
+
+func TestCloseStopsWorkers(t *testing.T) {
+	executor, _, backend := newTestExecutor(t)
+
+	before := queueGoroutines()
+	executor.QueueControlValue(activity.Midi(), "slider", "slider1", 10)
+	executor.QueueControlValue(activity.Midi(), "slider", "slider2", 20)
+	if !executor.WaitQueued(time.Second) {
+		t.Fatal("queued values not applied in time")
+	}
+	if running := queueGoroutines() - before; running != 3 {
+		t.Fatalf("got %d workers and writers, want 3", running)
+	}
+
+	executor.Close()
+	deadline := time.Now().Add(time.Second)
+	for queueGoroutines() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d workers and writers left after Close", queueGoroutines()-before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Values queued afterwards are dropped
+	changes := len(backend.VolumeChanges())
+	executor.QueueControlValue(activity.Midi(), "slider", "slider1", 50)
+	if !executor.WaitQueued(time.Second) {
+		t.Fatal("a value queued after Close is waiting")
+	}
+	if len(backend.VolumeChanges()) != changes {
+		t.Error("a value queued after Close set a volume")
+	}
+	executor.Close()
+}
+
+// sweepInterval is the time between the values of a benchmarked sweep, less
+// than a MIDI cable takes for a control change so that values queue up
+const sweepInterval = 500 * time.Microsecond
+
+// maxLatency is how long a value may wait for its volume at the 99th
+// percentile
+const maxLatency = 10 * time.Millisecond
+
+// BenchmarkQueuedValueLatency sweeps slider1 up and down and measures how
+// long each value waits until the volume of it, or of a later value of the
+// same sweep, is set
+func BenchmarkQueuedValueLatency(b *testing.B) {
+	executor, _, backend := newTestExecutor(b)
+
+	var latencies []time.Duration
+	b.ResetTimer()
+	for sent, sweep := 0, 0; sent < b.N; sweep++ {
+		up := sweep%2 == 0
+		value := func(step int) int {
+			if up {
+				return step
+			}
+			return 100 - step
+		}
+		start := len(backend.VolumeChanges())
+		var queued []time.Time
+		for step := 0; step <= 100 && sent < b.N; step++ {
+			queued = append(queued, time.Now())
+			executor.QueueControlValue(activity.Midi(), "slider", "slider1", value(step))
+			sent++
+			time.Sleep(sweepInterval)
+		}
+		if !executor.WaitQueued(time.Second) {
+			b.Fatal("queued values not applied in time")
+		}
+
+		// The values of a sweep only go one way, a volume set at or past a
+		// value is of that value or a later one
+		changes := backend.VolumeChanges()[start:]
+		for step, at := range queued {
+			reached := slices.IndexFunc(changes, func(change testutil.VolumeChange) bool {
+				applied := int(math.Round(float64(change.Volume) * 100))
+				return up && applied >= value(step) || !up && applied <= value(step)
+			})
+			if reached < 0 {
+				b.Fatalf("volume of %d never set, changes: %+v", value(step), changes)
+			}
+			latencies = append(latencies, changes[reached].At.Sub(at))
+		}
+	}
+	b.StopTimer()
+
+	slices.Sort(latencies)
+	p50 := latencies[len(latencies)/2]
+	p99 := latencies[(len(latencies)-1)*99/100]
+	b.ReportMetric(float64(p50.Microseconds())/1000, "p50-ms")
+	b.ReportMetric(float64(p99.Microseconds())/1000, "p99-ms")
+	if p99 > maxLatency {
+		b.Errorf("values waited %v for their volume at the 99th percentile, want under %v", p99, maxLatency)
+	}
+}
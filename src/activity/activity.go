@@ -0,0 +1,178 @@
+package activity
+
+import (
+	"sync"
+	"time"
+)
+
+// coalesceWindow merges repeated updates of the same target (e.g. a fader
+// being moved) into a single entry
+const coalesceWindow = time.Second
+
+// Origins of recorded actions
+const (
+	OriginMidi     = "midi"
+	OriginWeb      = "web"
+	OriginStartup  = "startup"
+	OriginAPI      = "api"
+	OriginDBus     = "dbus"
+	OriginOSC      = "osc"
+	OriginSocket   = "socket"
+	OriginHotkey   = "hotkey"
+	OriginDeck     = "streamdeck"
+	OriginSchedule = "schedule"
+)
+
+// Origin describes who triggered an action
+type Origin struct {
+	Kind   string
+	Client string // Remote address for web, api and osc origins
+}
+
+func Midi() Origin {
+	return Origin{Kind: OriginMidi}
+}
+
+func Startup() Origin {
+	return Origin{Kind: OriginStartup}
+}
+
+func Web(client string) Origin {
+	return Origin{Kind: OriginWeb, Client: client}
+}
+
+func API(client string) Origin {
+	return Origin{Kind: OriginAPI, Client: client}
+}
+
+func DBus() Origin {
+	return Origin{Kind: OriginDBus}
+}
+
+func OSC(client string) Origin {
+	return Origin{Kind: OriginOSC, Client: client}
+}
+
+func Socket() Origin {
+	return Origin{Kind: OriginSocket}
+}
+
+func Hotkey() Origin {
+	return Origin{Kind: OriginHotkey}
+}
+
+func StreamDeck() Origin {
+	return Origin{Kind: OriginDeck}
+}
+
+func Schedule() Origin {
+	return Origin{Kind: OriginSchedule}
+}
+
+// Entry is a single recorded action
+type Entry struct {
+	Time   time.Time   `json:"time"`
+	Origin string      `json:"origin"`
+	Client string      `json:"client,omitempty"`
+	Action string      `json:"action"`
+	Target string      `json:"target"`
+	Value  interface{} `json:"value,omitempty"`
+}
+
+// Log is a bounded in-memory ring of recent actions. A nil Log or one with a
+// size of zero records nothing.
+type Log struct {
+	mutex       sync.Mutex
+	entries     []Entry
+	next        int
+	full        bool
+	subscribers []func(Entry)
+}
+
+// NewLog creates a log holding at most size entries
+func NewLog(size int) *Log {
+	if size < 0 {
+		size = 0
+	}
+	return &Log{entries: make([]Entry, size)}
+}
+
+// Enabled reports whether the log records anything
+func (l *Log) Enabled() bool {
+	return l != nil && len(l.entries) > 0
+}
+
+// Record appends an action to the log and notifies subscribers
+func (l *Log) Record(origin Origin, action string, target string, value interface{}) {
+	if !l.Enabled() {
+		return
+	}
+
+	entry := Entry{
+		Time:   time.Now(),
+		Origin: origin.Kind,
+		Client: origin.Client,
+		Action: action,
+		Target: target,
+		Value:  value,
+	}
+
+	l.mutex.Lock()
+	last := l.last()
+	if last != nil && last.Origin == entry.Origin && last.Client == entry.Client &&
+		last.Action == entry.Action && last.Target == entry.Target &&
+		entry.Time.Sub(last.Time) < coalesceWindow {
+		*last = entry
+	} else {
+		l.entries[l.next] = entry
+		l.next = (l.next + 1) % len(l.entries)
+		if l.next == 0 {
+			l.full = true
+		}
+	}
+	subscribers := l.subscribers
+	l.mutex.Unlock()
+
+	for _, callback := range subscribers {
+		callback(entry)
+	}
+}
+
+// last returns the most recent entry. Must be called with mutex held.
+func (l *Log) last() *Entry {
+	if l.next == 0 && !l.full {
+		return nil
+	}
+	return &l.entries[(l.next-1+len(l.entries))%len(l.entries)]
+}
+
+// Entries returns up to limit of the most recent entries, oldest first.
+// A limit of zero or less returns everything.
+func (l *Log) Entries(limit int) []Entry {
+	if !l.Enabled() {
+		return []Entry{}
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	entries := []Entry{}
+	if l.full {
+		entries = append(entries, l.entries[l.next:]...)
+	}
+	entries = append(entries, l.entries[:l.next]...)
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	return entries
+}
+
+// Subscribe registers a callback for newly recorded entries
+func (l *Log) Subscribe(callback func(Entry)) {
+	if l == nil {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.subscribers = append(l.subscribers, callback)
+}
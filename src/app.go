@@ -0,0 +1,1284 @@
+package pulsekontrol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/actions"
+	"github.com/0h41/pulsekontrol/src/activity"
+	"github.com/0h41/pulsekontrol/src/clock"
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/0h41/pulsekontrol/src/control"
+	"github.com/0h41/pulsekontrol/src/dbusapi"
+	"github.com/0h41/pulsekontrol/src/hooks"
+	"github.com/0h41/pulsekontrol/src/hotkeys"
+	"github.com/0h41/pulsekontrol/src/logging"
+	"github.com/0h41/pulsekontrol/src/midi"
+	"github.com/0h41/pulsekontrol/src/notify"
+	"github.com/0h41/pulsekontrol/src/osc"
+	"github.com/0h41/pulsekontrol/src/pulseaudio"
+	"github.com/0h41/pulsekontrol/src/scheduler"
+	"github.com/0h41/pulsekontrol/src/streamdeck"
+	"github.com/0h41/pulsekontrol/src/supervise"
+	"github.com/0h41/pulsekontrol/src/suspend"
+	"github.com/0h41/pulsekontrol/src/systemd"
+	"github.com/0h41/pulsekontrol/src/webhooks"
+	"github.com/0h41/pulsekontrol/src/webui"
+	"github.com/godbus/dbus/v5"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Options configure an App. The zero value runs like pulsekontrol without
+// flags: the configuration is loaded from the searched locations and saved
+// back, and the web interface is started if the configuration enables it.
+type Options struct {
+	ConfigPath   string                // Configuration file to use instead of the searched locations
+	Config       *configuration.Config // Configuration to use instead of loading one, saved to ConfigPath if set
+	HostProfile  string                // Name of the override to apply instead of the one for the hostname
+	ReadOnly     bool                  // Never write the configuration, changes apply until the App stops
+	DryRun       bool                  // Log PulseAudio changes instead of applying them, implies ReadOnly
+	NoLock       bool                  // Run read-only if another instance holds the configuration lock, instead of failing
+	WebUI        *bool                 // Whether to start the web interface, overriding webUI.enabled
+	WebAddr      string                // Web interface address:port, overriding webUI.address
+	LogLevel     string                // Log level of all modules, overriding the logging section
+	LogFormat    string                // Log format, console or json, overriding the logging section
+	LogOutput    io.Writer             // Writer to log to instead of standard error or logging.file
+	Logger       *zerolog.Logger       // Logger of the App itself instead of the global one, the modules keep theirs
+	PulseAudio   pulseaudio.Backend    // Audio system to use instead of connecting to PulseAudio, like a fake one in tests
+	Midi         midi.Driver           // Opens the MIDI ports instead of the MIDI system, like fake ports in tests
+	Clock        clock.Clock           // Times polls and retries instead of the system clock
+	Systemd      bool                  // Report readiness and status to systemd and ping its watchdog
+	NoAutodetect bool                  // Don't look for a supported controller when the configured ports are missing
+	RequireMidi  bool                  // Fail when the MIDI device is not found instead of waiting for it
+}
+
+// logFlags returns the logging settings of the options
+func (options Options) logFlags() logFlags {
+	return logFlags{level: options.LogLevel, format: options.LogFormat, output: options.LogOutput}
+}
+
+// App is pulsekontrol connecting a MIDI device to PulseAudio, with the web
+// interface and the configuration kept in line. Create it with New, run it
+// with Start and stop it with Stop. The configuration path and host profile
+// are global, so one process should run one App.
+type App struct {
+	options       Options
+	log           zerolog.Logger
+	path          string                  // Where the configuration is saved
+	readOnly      bool                    // Whether the configuration is never saved
+	configLock    *configuration.FileLock // nil when read-only
+	configManager *configuration.ConfigManager
+	paClient      pulseaudio.Backend
+	clock         clock.Clock
+	executor      *actions.Executor
+	webServer     *webui.WebUIServer // nil without web interface
+	webAddr       string
+	advertisement *webui.Advertisement // nil unless the web interface is announced over mDNS
+	dbusService   *dbusapi.Service     // nil unless dbus.enabled and started
+	oscServer     *osc.Server          // nil unless osc.enabled and started
+	hotkeys       *hotkeys.Listener    // nil unless hotkeys.enabled
+	streamDeck    *streamdeck.Deck     // nil unless streamdeck.enabled
+	scheduler     *scheduler.Scheduler
+	suspend       *suspend.Watcher // nil without a system bus
+	controlServer *control.Server  // nil if the control socket could not be created
+	notifier      *notify.Notifier // nil unless notifications.enabled and connected
+	hooks         *hooks.Runner
+	webhooks      *webhooks.Dispatcher // nil without webhooks
+	midiDevice    configuration.MidiDevice
+	midiClient    *midi.MidiClient
+
+	stateMu   sync.Mutex
+	midiState connectionState // Last reported state of the MIDI device, see trackConnections
+	paState   connectionState
+	paErr     error       // Why the sound server could not be reached at startup
+	timeouts  int         // Operations PulseAudio did not answer in time
+	dumping   atomic.Bool // A state dump is being written
+
+	mu       sync.Mutex
+	started  bool
+	cancel   context.CancelFunc // Cancels the context everything was started with
+	midiDone chan struct{}      // Closed when the MIDI client has closed its ports
+	errs     chan error
+	stopOnce sync.Once
+	stopErr  error
+
+	subscriptions []configuration.Subscription // Removed from the configuration manager on Stop
+}
+
+// New loads the configuration and sets up the parts of pulsekontrol without
+// starting them. Unless read-only, the configuration is locked until Stop.
+func New(options Options) (*App, error) {
+	// Messages from outside of the modules go to the configured output too,
+	// unless the embedder passed a logger of its own
+	logger := logging.Logger()
+	if options.Logger != nil {
+		logger = *options.Logger
+	} else {
+		log.Logger = logger
+	}
+	flags := options.logFlags()
+	if err := flags.check(); err != nil {
+		return nil, err
+	}
+	applyLogging(configuration.LoggingConfig{}, flags)
+
+	if options.ConfigPath != "" {
+		configuration.SetPath(options.ConfigPath)
+	}
+	if options.HostProfile != "" {
+		configuration.SetHostProfile(options.HostProfile)
+	}
+
+	a := &App{
+		options:  options,
+		log:      logger,
+		paClient: options.PulseAudio,
+		clock:    options.Clock,
+		midiDone: make(chan struct{}),
+		errs:     make(chan error, 1),
+	}
+	if a.clock == nil {
+		a.clock = clock.System
+	}
+
+	a.notifyStatus("Loading configuration")
+	config, err := a.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	// The configuration says which sound server to connect to
+	if a.paClient == nil {
+		paClient, err := pulseaudio.ConnectBackend(config.PulseAudio.Backend)
+		if err != nil {
+			// The MIDI device and the web interface work without, volumes
+			// are controlled once the server is reached
+			a.log.Error().Err(err).Msg("Cannot connect to the sound server, retrying in the background")
+			a.paErr = err
+			paClient = pulseaudio.NewOfflineClient(config.PulseAudio.Backend, err)
+		}
+		a.paClient = paClient
+	}
+	if options.DryRun {
+		a.log.Info().Msg("Dry run, PulseAudio changes are only logged and the configuration is not saved")
+		a.paClient.SetDryRun(true)
+	}
+	a.paClient.SetTimeout(config.PulseAudio.Timeout, config.PulseAudio.ReconnectAfter)
+	a.paClient.SetMeterInterval(config.PulseAudio.MeterInterval)
+	a.paClient.SetExcludes(config.Excludes)
+	applyLogging(config.Logging, flags)
+	a.log.Info().Msgf("Loaded configuration from %s", a.path)
+	if !a.readOnly && !*config.Persistence.Enabled {
+		a.log.Info().Msg("Persistence is disabled, changes will not be saved")
+		a.readOnly = true
+	}
+
+	// Create configuration manager
+	configManager := configuration.NewConfigManager(config, a.path)
+	configManager.SetReadOnly(a.readOnly)
+	a.configManager = configManager
+	paClient := a.paClient
+
+	// Stream and device events reach subscribers like hooks as notifications
+	paClient.SetEventHandler(configManager.Notify)
+
+	// Remember when assigned sources were last present, for the stale source cleanup
+	if !a.readOnly {
+		configManager.TrackUnseenSources()
+		paClient.SetSourceSeenCallback(func(target configuration.TypedTarget) {
+			configManager.MarkSourceSeen(configuration.Source{
+				Type:       target.Type,
+				Name:       target.Name,
+				BinaryName: target.BinaryName,
+			})
+		})
+	}
+
+	// Shared action execution for the MIDI client and web UI
+	activityLog := activity.NewLog(*config.History.Size)
+	a.executor = actions.NewExecutor(paClient, configManager, activityLog)
+
+	// Options take precedence over the configuration
+	webUIEnabled := *config.WebUI.Enabled
+	if options.WebUI != nil {
+		webUIEnabled = *options.WebUI
+	}
+	a.webAddr = config.WebUI.Address
+	if options.WebAddr != "" {
+		a.webAddr = options.WebAddr
+	}
+	if webUIEnabled {
+		// With socket activation systemd owns the socket, which replaces the address
+		listener, err := systemd.Listener("webui")
+		if err != nil {
+			a.log.Warn().Err(err).Msgf("Cannot use the socket passed by systemd, listening on %s", a.webAddr)
+		} else if listener != nil {
+			a.webAddr = listener.Addr().String()
+		}
+		a.webServer = webui.NewWebUIServer(a.webAddr, paClient, configManager, a.executor)
+		if listener != nil {
+			a.webServer.SetListener(listener)
+		}
+		a.subscribeWebUI()
+	}
+
+	a.setupMidi(config)
+	a.subscribeMidi()
+
+	a.subscribe("config.merged", func(data interface{}) {
+		config := configManager.GetConfigSnapshot()
+		applyLogging(config.Logging, flags)
+		a.paClient.SetExcludes(config.Excludes)
+	})
+	return a, nil
+}
+
+// loadConfig locks and loads the configuration, or prepares the one of the
+// options. Without a path to save it to, that one is used read-only.
+func (a *App) loadConfig() (configuration.Config, error) {
+	a.readOnly = a.options.ReadOnly || a.options.DryRun
+	if a.options.Config != nil {
+		config, err := configuration.Prepare(*a.options.Config)
+		if err != nil {
+			return config, err
+		}
+		a.path = a.options.ConfigPath
+		if a.path == "" {
+			a.readOnly = true
+			return config, nil
+		}
+		return config, a.lock()
+	}
+
+	path, err := configuration.Path()
+	if err != nil {
+		return configuration.Config{}, err
+	}
+	a.path = path
+	if err := a.lock(); err != nil {
+		return configuration.Config{}, err
+	}
+
+	// Read-only instances must not write migrations or defaults
+	var config configuration.Config
+	if a.readOnly {
+		var result configuration.LoadResult
+		result, err = configuration.Inspect()
+		config, a.path = result.Config, result.Path
+	} else {
+		config, a.path, err = configuration.Load()
+	}
+	if err != nil {
+		a.unlock()
+		return config, err
+	}
+	return config, nil
+}
+
+// lock locks the configuration so two instances don't save over each other.
+// Read-only instances don't write anything, so they need no lock.
+func (a *App) lock() error {
+	if a.readOnly {
+		return nil
+	}
+	lock, err := configuration.Lock(a.path)
+	if err != nil {
+		var lockErr *configuration.LockError
+		if !errors.As(err, &lockErr) || !a.options.NoLock {
+			return err
+		}
+		a.log.Warn().Err(err).Msg("Running read-only, changes will not be saved")
+		a.readOnly = true
+		return nil
+	}
+	a.configLock = lock
+	return nil
+}
+
+func (a *App) unlock() {
+	if a.configLock == nil {
+		return
+	}
+	if err := a.configLock.Unlock(); err != nil {
+		a.log.Warn().Err(err).Msg("Failed to release configuration lock")
+	}
+	a.configLock = nil
+}
+
+// subscribe registers a callback for configuration changes that Stop removes
+func (a *App) subscribe(topic string, callback func(interface{})) {
+	a.subscriptions = append(a.subscriptions, a.configManager.Subscribe(topic, callback))
+}
+
+// ConfigManager returns the manager of the running configuration
+func (a *App) ConfigManager() *configuration.ConfigManager {
+	return a.configManager
+}
+
+// subscribeWebUI passes configuration changes on to the web clients
+func (a *App) subscribeWebUI() {
+	configManager, webServer := a.configManager, a.webServer
+
+	// Set up configuration update notifications to WebUI
+	a.subscribe("mapping.updated", func(data interface{}) {
+		// Convert to JSON and broadcast to clients
+		// This is a simplified example - in a real implementation,
+		// you would serialize the data and broadcast it
+		webServer.NotifyConfigUpdate(data)
+	})
+
+	// Fast path for control value updates
+	a.subscribe("control.value.updated", func(data interface{}) {
+		a.log.Debug().Interface("data", data).Msg("Received control.value.updated notification")
+		if updateMap, ok := data.(map[string]interface{}); ok {
+			if controlType, ok := updateMap["type"].(string); ok {
+				if controlId, ok := updateMap["id"].(string); ok {
+					if value, ok := updateMap["value"].(int); ok {
+						origin, _ := updateMap["origin"].(activity.Origin)
+						a.log.Debug().Str("controlType", controlType).Str("controlId", controlId).Int("value", value).Str("origin", origin.Kind).Msg("Sending fast path UI update")
+						webServer.NotifyControlValueUpdate(controlType, controlId, value, origin)
+					}
+				}
+			}
+		}
+	})
+
+	a.subscribe("control.muted.updated", func(data interface{}) {
+		if updateMap, ok := data.(map[string]interface{}); ok {
+			controlType, _ := updateMap["controlType"].(string)
+			controlId, _ := updateMap["controlId"].(string)
+			muted, _ := updateMap["muted"].(bool)
+			webServer.NotifyControlMutedUpdate(controlType, controlId, muted)
+		}
+	})
+
+	a.subscribe("source.duplicate", func(data interface{}) {
+		if updateMap, ok := data.(map[string]interface{}); ok {
+			controlType, _ := updateMap["controlType"].(string)
+			controlId, _ := updateMap["controlId"].(string)
+			source, _ := updateMap["source"].(configuration.Source)
+			conflicts, _ := updateMap["conflicts"].([]configuration.SourceConflict)
+			var otherControls []string
+			for _, conflict := range conflicts {
+				if !slices.Contains(otherControls, conflict.ControlID) {
+					otherControls = append(otherControls, conflict.ControlID)
+				}
+			}
+			webServer.NotifySourceDuplicate(controlType, controlId, source, otherControls)
+		}
+	})
+
+	a.subscribe("config.save.failed", func(data interface{}) {
+		if updateMap, ok := data.(map[string]interface{}); ok {
+			message, _ := updateMap["error"].(string)
+			webServer.NotifySaveStatus(false, message)
+		}
+	})
+	a.subscribe("config.save.recovered", func(data interface{}) {
+		webServer.NotifySaveStatus(true, "")
+	})
+
+	// Aliases change the displayed source names
+	a.subscribe("alias.updated", func(data interface{}) {
+		webServer.BroadcastState()
+	})
+
+	// Control values differ between profiles, resend the full state
+	a.subscribe("profile.switched", func(data interface{}) {
+		webServer.BroadcastState()
+	})
+
+	// Sources, labels, mutes and possibly values of two controls changed
+	a.subscribe("control.moved", func(data interface{}) {
+		webServer.BroadcastState()
+	})
+
+	// Without the MIDI device the web interface is the only mixer, say so
+	for _, topic := range []string{"midi.connected", "midi.disconnected"} {
+		a.subscribe(topic, func(data interface{}) {
+			config := configManager.GetConfigSnapshot()
+			if message, ok := webui.EventMessage(&config, topic, data); ok {
+				webServer.NotifyMidiStatus(message.(webui.MidiStatus))
+			}
+		})
+	}
+}
+
+// setupMidi finds the ports of the MIDI device and creates its client
+func (a *App) setupMidi(config configuration.Config) {
+	configManager := a.configManager
+
+	// Convert new config format to legacy format for MIDI client
+	// This is temporary compatibility code until the MIDI client is updated.
+	// Only the first device is opened for now.
+	device := config.PrimaryDevice()
+	midiDevice := configuration.MidiDevice{
+		Name:        device.Name,
+		Type:        device.Type,
+		MidiInName:  device.InPort,
+		MidiOutName: device.OutPort,
+		Transport:   device.Transport,
+		Host:        device.Host,
+		Port:        device.Port,
+		SessionName: device.SessionName,
+
+		RetryInterval: device.RetryInterval,
+	}
+	if len(config.Devices) > 1 {
+		a.log.Warn().Int("devices", len(config.Devices)).Msgf("Only the first MIDI device %s is used", device.Name)
+	}
+
+	// Port names change with the USB topology, find the device by its hardware identity
+	devices := config.Devices
+	if len(devices) == 0 {
+		devices = []configuration.DeviceConfig{device}
+	}
+	if device.Transport == configuration.RTPMIDITransport {
+		// A network session has no local ports to resolve
+		a.log.Info().Str("host", device.Host).Msgf("Device %s is reached over RTP-MIDI", device.Name)
+	} else if resolved, err := midi.ResolveDevices(devices); err != nil {
+		a.log.Warn().Err(err).Msg("Could not resolve MIDI ports, using the configured port names")
+	} else if ports, ok := resolved[device.Name]; ok {
+		if ports.Missing && !a.options.NoAutodetect && len(devices) == 1 {
+			ports = a.autodetect(device, ports)
+		}
+		midiDevice.MidiInName = ports.InPort
+		midiDevice.MidiOutName = ports.OutPort
+		if ports.Learned && !configManager.ReadOnly() {
+			identity := device.Identity
+			identity.Serial = ports.Serial
+			if err := configManager.SetDeviceIdentity(device.Name, identity); err != nil {
+				a.log.Warn().Err(err).Msg("Could not store device identity")
+			}
+		}
+	}
+
+	// Create rules from control assignments
+	rules := createRulesFromConfig(config, midiDevice)
+
+	a.midiDevice = midiDevice
+	a.midiClient = midi.NewMidiClient(a.paClient, midiDevice, rules, configManager, a.executor)
+	a.midiClient.SetRequired(a.options.RequireMidi)
+	a.midiClient.SetClock(a.clock)
+	if a.options.Midi != nil {
+		a.midiClient.SetDriver(a.options.Midi)
+	}
+	a.midiClient.SetConnectionHandler(func(connected bool, err error) {
+		if connected {
+			configManager.Notify("midi.connected", map[string]interface{}{"device": midiDevice.Name})
+			a.notifyStatus(fmt.Sprintf("Running with MIDI device %s", midiDevice.Name))
+			return
+		}
+		configManager.Notify("midi.disconnected", map[string]interface{}{"device": midiDevice.Name, "error": err.Error()})
+		a.notifyStatus(fmt.Sprintf("Waiting for MIDI device %s, the web interface is available", midiDevice.Name))
+	})
+
+	// Let the web UI flash the LEDs of a control
+	if a.webServer != nil {
+		a.webServer.SetIdentifyHandler(a.midiClient.IdentifyControl)
+	}
+}
+
+// autodetect looks for a supported controller when the configured ports of
+// the only device are missing. A single controller of the device's type is
+// used and its ports are stored; with several, the user has to pick one.
+func (a *App) autodetect(device configuration.DeviceConfig, ports midi.DevicePorts) midi.DevicePorts {
+	deviceType := device.Type
+	if deviceType == "" {
+		deviceType = configuration.KorgNanoKontrol2
+	}
+	candidates, err := midi.Detect()
+	if err != nil {
+		a.log.Warn().Err(err).Msg("Could not look for a supported MIDI controller")
+		return ports
+	}
+	var matching []midi.Candidate
+	for _, candidate := range candidates {
+		if candidate.Type == deviceType {
+			matching = append(matching, candidate)
+		}
+	}
+	candidates = matching
+
+	switch len(candidates) {
+	case 0:
+		a.log.Warn().Str("device", device.Name).Msgf("The MIDI ports of device %s were not found and no other %s is connected", device.Name, deviceType)
+		return ports
+	case 1:
+	default:
+		for _, candidate := range candidates {
+			a.log.Warn().Str("in", candidate.InPort).Str("out", candidate.OutPort).Msgf("Found a %s", candidate.Type)
+		}
+		a.log.Warn().Str("device", device.Name).Msgf("The MIDI ports of device %s were not found and %d controllers of its type are connected; set inPort and outPort of the device to one of them", device.Name, len(candidates))
+		return ports
+	}
+
+	candidate := candidates[0]
+	a.log.Info().
+		Str("device", device.Name).
+		Str("in", candidate.InPort).
+		Str("out", candidate.OutPort).
+		Msgf("The configured MIDI ports of device %s were not found, using the only connected %s", device.Name, deviceType)
+	ports.InPort = candidate.InPort
+	if candidate.OutPort != "" {
+		ports.OutPort = candidate.OutPort
+	}
+	ports.Missing = candidate.OutPort == ""
+	if a.configManager.ReadOnly() {
+		a.log.Info().Str("device", device.Name).Msg("The configuration is read-only, the detected ports are used until exit")
+	} else if err := a.configManager.SetDevicePorts(device.Name, ports.InPort, ports.OutPort); err != nil {
+		a.log.Warn().Err(err).Msg("Could not store the detected device ports")
+	}
+	return ports
+}
+
+// subscribeMidi updates the MIDI rules, LEDs and volumes on configuration changes
+func (a *App) subscribeMidi() {
+	configManager, webServer, midiClient, midiDevice := a.configManager, a.webServer, a.midiClient, a.midiDevice
+	paClient, executor := a.paClient, a.executor
+
+	// Subscribe to configuration changes to update rules dynamically
+	a.subscribe("source.assigned", func(data interface{}) {
+		// Regenerate rules when sources are assigned
+		a.log.Info().Msg("Source assigned, updating MIDI rules")
+
+		// Extract assignment details
+		assignData, ok := data.(map[string]interface{})
+		if !ok {
+			a.log.Error().Msg("Invalid data format from source.assigned event")
+			return
+		}
+
+		// Immediately set the volume of the newly assigned source to match the control's current value
+		if initialValue, hasValue := assignData["initialValue"].(int); hasValue {
+			source, hasSource := assignData["source"].(configuration.Source)
+			if hasSource {
+				controlType, _ := assignData["controlType"].(string)
+				controlId, _ := assignData["controlId"].(string)
+
+				// Process the volume action immediately
+				a.log.Info().
+					Str("sourceName", source.Name).
+					Str("sourceType", string(source.Type)).
+					Int("value", initialValue).
+					Msg("Setting initial volume for newly assigned source")
+
+				executor.ApplySourceValue(controlType, controlId, source, initialValue)
+
+				// Sources added to a muted control are muted as well
+				if muted, _ := assignData["muted"].(bool); muted {
+					target := source.Target()
+					if err := paClient.SetTargetMute(target, true); err != nil {
+						a.log.Error().Err(err).Str("sourceName", source.Name).Msg("Failed to mute newly assigned source")
+					}
+				}
+			}
+		}
+
+		// Recreate rules from current configuration - get the latest config!
+		currentConfig := configManager.GetConfigSnapshot()
+		newRules := createRulesFromConfig(currentConfig, midiDevice)
+
+		// Update the MIDI client with the new rules
+		midiClient.UpdateRules(newRules)
+
+		// Update LED indicators
+		if err := midiClient.UpdateLEDIndicators(); err != nil {
+			a.log.Error().Err(err).Msg("Failed to update LED indicators after source assignment")
+		}
+	})
+
+	a.subscribe("source.unassigned", func(data interface{}) {
+		// Regenerate rules when sources are unassigned
+		a.log.Info().Msg("Source unassigned, updating MIDI rules")
+
+		// Recreate rules from current configuration - get the latest config!
+		currentConfig := configManager.GetConfigSnapshot()
+		newRules := createRulesFromConfig(currentConfig, midiDevice)
+
+		// Update the MIDI client with the new rules
+		midiClient.UpdateRules(newRules)
+
+		// Update LED indicators
+		if err := midiClient.UpdateLEDIndicators(); err != nil {
+			a.log.Error().Err(err).Msg("Failed to update LED indicators after source unassignment")
+		}
+	})
+
+	a.subscribe("profile.switched", func(data interface{}) {
+		// A different set of mappings is active now
+		a.log.Info().Interface("data", data).Msg("Profile switched, updating MIDI rules and volumes")
+
+		currentConfig := configManager.GetConfigSnapshot()
+		midiClient.UpdateRules(createRulesFromConfig(currentConfig, midiDevice))
+
+		// Sync volumes to the control positions of the new profile
+		triggerStartupVolumeActions(paClient, configManager, executor)
+
+		if err := midiClient.UpdateLEDIndicators(); err != nil {
+			a.log.Error().Err(err).Msg("Failed to update LED indicators after profile switch")
+		}
+	})
+
+	a.subscribe("config.merged", func(data interface{}) {
+		// The file was edited while running, anything may have changed
+		a.log.Info().Interface("data", data).Msg("Configuration merged with changes on disk, updating MIDI rules and volumes")
+
+		currentConfig := configManager.GetConfigSnapshot()
+		midiClient.UpdateRules(createRulesFromConfig(currentConfig, midiDevice))
+		triggerStartupVolumeActions(paClient, configManager, executor)
+
+		if err := midiClient.UpdateLEDIndicators(); err != nil {
+			a.log.Error().Err(err).Msg("Failed to update LED indicators after merging the configuration")
+		}
+		if webServer != nil {
+			webServer.BroadcastState()
+		}
+	})
+
+	a.subscribe("button.action.assigned", func(data interface{}) {
+		// Regenerate rules when button actions change
+		a.log.Info().Msg("Button action assigned, updating MIDI rules")
+
+		currentConfig := configManager.GetConfigSnapshot()
+		midiClient.UpdateRules(createRulesFromConfig(currentConfig, midiDevice))
+	})
+
+	a.subscribe("control.muted.updated", func(data interface{}) {
+		// Mute buttons show the muted state of their control
+		if err := midiClient.UpdateLEDIndicators(); err != nil {
+			a.log.Error().Err(err).Msg("Failed to update LED indicators after mute change")
+		}
+	})
+
+	a.subscribe("sources.changed", func(data interface{}) {
+		// Microphone mute buttons follow their input device, also when it is
+		// muted elsewhere
+		if err := midiClient.UpdateMicMuteLEDs(); err != nil {
+			a.log.Debug().Err(err).Msg("Failed to update microphone mute LEDs")
+		}
+	})
+
+	a.subscribe("control.moved", func(data interface{}) {
+		// The sources of a control were moved to another or swapped with it
+		a.log.Info().Interface("data", data).Msg("Control sources moved, updating MIDI rules and volumes")
+
+		moveData, ok := data.(map[string]interface{})
+		if !ok {
+			a.log.Error().Msg("Invalid data format from control.moved event")
+			return
+		}
+
+		// Sources that stayed with their value already have the right volume,
+		// setting it again is harmless
+		if controls, ok := moveData["controls"].([]map[string]interface{}); ok {
+			for _, control := range controls {
+				controlType, _ := control["controlType"].(string)
+				controlId, _ := control["controlId"].(string)
+				value, _ := control["value"].(int)
+				muted, _ := control["muted"].(bool)
+				executor.ApplyControlVolumes(controlType, controlId, value)
+				executor.ApplyControlMute(controlType, controlId, muted)
+			}
+		}
+
+		currentConfig := configManager.GetConfigSnapshot()
+		midiClient.UpdateRules(createRulesFromConfig(currentConfig, midiDevice))
+
+		if err := midiClient.UpdateLEDIndicators(); err != nil {
+			a.log.Error().Err(err).Msg("Failed to update LED indicators after moving control sources")
+		}
+	})
+
+	a.subscribe("history.applied", func(data interface{}) {
+		// Undo/redo may have changed assignments and values of several controls
+		a.log.Info().Msg("Configuration history applied, updating MIDI rules and volumes")
+
+		historyData, ok := data.(map[string]interface{})
+		if !ok {
+			a.log.Error().Msg("Invalid data format from history.applied event")
+			return
+		}
+
+		// Restore volumes of the affected controls
+		if controls, ok := historyData["controls"].([]map[string]interface{}); ok {
+			for _, control := range controls {
+				controlType, _ := control["controlType"].(string)
+				controlId, _ := control["controlId"].(string)
+				value, _ := control["value"].(int)
+				executor.ApplyControlVolumes(controlType, controlId, value)
+			}
+		}
+
+		// Recreate rules from current configuration
+		currentConfig := configManager.GetConfigSnapshot()
+		midiClient.UpdateRules(createRulesFromConfig(currentConfig, midiDevice))
+
+		// Update LED indicators
+		if err := midiClient.UpdateLEDIndicators(); err != nil {
+			a.log.Error().Err(err).Msg("Failed to update LED indicators after undo/redo")
+		}
+	})
+}
+
+// Start starts the web interface, the MIDI client and the PulseAudio
+// monitoring and syncs volumes and controls. It returns once they are
+// started; cancelling ctx stops what runs in the background, Stop has to be
+// called anyway to save the configuration.
+func (a *App) Start(ctx context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.started {
+		return fmt.Errorf("pulsekontrol is already started")
+	}
+	a.started = true
+	ctx, a.cancel = context.WithCancel(ctx)
+	// A goroutine that keeps panicking stops the App like a failed part
+	supervise.SetFailureHandler(a.fail)
+
+	if a.webServer != nil {
+		go func() {
+			if err := a.webServer.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				a.log.Error().Err(err).Msg("Failed to start web server")
+			}
+		}()
+		a.log.Info().Msgf("Web interface available at http://%s", a.webAddr)
+	}
+	config := a.configManager.GetConfigSnapshot()
+	if a.webServer != nil && *config.WebUI.Advertise {
+		a.advertiseWebUI(config.WebUI.InstanceName)
+	}
+	if config.DBus.Enabled {
+		a.startDBus()
+	}
+	if config.OSC.Enabled {
+		a.startOSC(config.OSC.ListenAddress())
+	}
+	if config.Hotkeys.Enabled {
+		a.startHotkeys(config.Hotkeys.Devices)
+	}
+	if config.StreamDeck.Enabled {
+		a.startStreamDeck()
+	}
+	a.startScheduler()
+	a.startSuspendWatcher()
+	a.startControlSocket()
+	a.trackConnections()
+	watchPulseAudio(ctx, a.paClient, a.configManager, a.clock)
+	a.hooks = hooks.Start(a.configManager)
+	a.webhooks = webhooks.Start(a.configManager, config.Webhooks)
+	if config.Notifications.Enabled {
+		a.startNotifications()
+	}
+
+	a.notifyStatus(fmt.Sprintf("Opening MIDI device %s", a.midiDevice.Name))
+	supervise.Go("midi.client", func() {
+		if err := a.midiClient.Run(ctx); err != nil {
+			a.fail(fmt.Errorf("MIDI client failed: %w", err))
+		}
+		close(a.midiDone)
+	})
+
+	// Combined and virtual sinks come first, so the controls assigned to them
+	// find them
+	a.syncCombinedSinks()
+	a.syncVirtualSinks()
+	for _, topic := range []string{"combinedSinks.updated", "config.merged", "pulseaudio.reconnected"} {
+		a.subscribe(topic, func(data interface{}) {
+			a.syncCombinedSinks()
+		})
+	}
+	for _, topic := range []string{"profile.switched", "config.merged", "pulseaudio.reconnected"} {
+		a.subscribe(topic, func(data interface{}) {
+			a.syncVirtualSinks()
+		})
+	}
+
+	// Perform any needed config migrations and sync volumes and control
+	// positions in the configured direction
+	syncStartupVolumes(a.paClient, a.configManager, a.executor)
+
+	// Set up stream monitoring for automatic volume application and LED updates
+	setupStreamMonitoring(a.paClient, a.configManager, a.midiClient, a.executor, a.webServer)
+	a.subscribe("pulseaudio.reconnected", func(data interface{}) {
+		a.reconnected()
+	})
+
+	if a.options.Systemd {
+		a.setupWatchdog(ctx)
+	}
+
+	// PulseAudio is connected and the configuration loaded, startup is
+	// complete when the MIDI device is up or is being waited for, which
+	// reports its own status
+	go func() {
+		select {
+		case <-a.midiClient.Ready():
+		case <-a.midiClient.Waiting():
+		case <-ctx.Done():
+			return
+		}
+		if a.options.Systemd {
+			if err := systemd.Notify(systemd.Ready); err != nil {
+				a.log.Warn().Err(err).Msg("Failed to notify systemd of readiness")
+			}
+		}
+	}()
+	return nil
+}
+
+// advertiseWebUI announces the web interface on the local network, so it can
+// be found from phones without knowing the address
+func (a *App) advertiseWebUI(instance string) {
+	if instance == "" {
+		instance = webui.DefaultInstanceName()
+	}
+	advertisement, err := webui.Advertise(a.webAddr, instance)
+	if errors.Is(err, webui.ErrLoopback) {
+		a.log.Info().Str("address", a.webAddr).Msg("Not advertising the web interface over mDNS, it is only reachable from this machine")
+		return
+	}
+	if err != nil {
+		a.log.Warn().Err(err).Msg("Failed to advertise the web interface over mDNS")
+		return
+	}
+	a.advertisement = advertisement
+}
+
+// startDBus exports the session bus interface. Desktop integration is
+// optional, so pulsekontrol keeps running without it.
+func (a *App) startDBus() {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		a.log.Warn().Err(err).Msg("Cannot connect to the session bus, the D-Bus interface is disabled")
+		return
+	}
+	service, err := dbusapi.Start(conn, a.configManager, a.executor)
+	if err != nil {
+		conn.Close()
+		a.log.Warn().Err(err).Msg("Cannot start the D-Bus interface")
+		return
+	}
+	a.dbusService = service
+}
+
+// startOSC listens for control surfaces. Their buttons run the same actions
+// as those of the MIDI device.
+func (a *App) startOSC(address string) {
+	server := osc.NewServer(address, a.configManager, a.executor, func(origin activity.Origin, button configuration.ButtonConfig, pressed bool) {
+		var value uint8
+		if pressed {
+			value = 0x7f
+		}
+		a.midiClient.RunActions(origin, button.Path, button.Actions, value)
+	})
+	if err := server.Start(); err != nil {
+		a.log.Error().Err(err).Msg("Failed to start OSC listener")
+		return
+	}
+	a.oscServer = server
+}
+
+// startHotkeys reads the keyboards for the hotkeys, whose actions run like
+// those of the buttons of the MIDI device
+func (a *App) startHotkeys(devices []string) {
+	a.hotkeys = hotkeys.NewListener(a.configManager, devices, func(origin activity.Origin, path string, actions []configuration.Action, pressed bool) {
+		var value uint8
+		if pressed {
+			value = 0x7f
+		}
+		a.midiClient.RunActions(origin, path, actions, value)
+	})
+	a.hotkeys.Start()
+}
+
+// startStreamDeck uses the Stream Deck, whose keys run actions like the
+// buttons of the MIDI device
+func (a *App) startStreamDeck() {
+	a.streamDeck = streamdeck.NewDeck(a.configManager, a.clock, func(origin activity.Origin, path string, actions []configuration.Action, pressed bool) {
+		var value uint8
+		if pressed {
+			value = 0x7f
+		}
+		a.midiClient.RunActions(origin, path, actions, value)
+	})
+	a.streamDeck.Start()
+}
+
+// startScheduler runs the schedules, whose actions run like a press and
+// release of a button
+func (a *App) startScheduler() {
+	a.scheduler = scheduler.New(a.configManager, a.clock, func(schedule configuration.Schedule) {
+		origin := activity.Schedule()
+		if schedule.Scene != "" {
+			if err := a.executor.RecallScene(origin, schedule.Scene, 0); err != nil {
+				a.log.Error().Err(err).Str("schedule", schedule.Name).Msg("Failed to recall the scene of a schedule")
+			}
+		}
+		path := "schedule/" + schedule.Name
+		a.midiClient.RunActions(origin, path, schedule.Actions, 0x7f)
+		a.midiClient.RunActions(origin, path, schedule.Actions, 0)
+	})
+	a.scheduler.Start()
+}
+
+// startSuspendWatcher syncs again after the system resumed from suspend,
+// when devices may have been renumbered. It needs the system bus, without
+// one nothing is synced.
+func (a *App) startSuspendWatcher() {
+	watcher, err := suspend.Connect(a.resumed)
+	if err != nil {
+		a.log.Info().Err(err).Msg("Not watching for suspend, volumes are not synced again after resuming")
+		return
+	}
+	a.suspend = watcher
+}
+
+// resumed reconnects to PulseAudio, syncs the volumes in the configured
+// direction like at startup and restores the LEDs and the web interface
+func (a *App) resumed() {
+	defer supervise.Recover("suspend.resumed")
+	if err := a.paClient.Refresh(); err != nil {
+		a.log.Error().Err(err).Msg("Failed to refresh PulseAudio after resuming")
+		return
+	}
+	a.syncCombinedSinks()
+	a.syncVirtualSinks()
+	syncStartupVolumes(a.paClient, a.configManager, a.executor)
+	if err := a.midiClient.RestoreLEDs(); err != nil {
+		a.log.Warn().Err(err).Msg("Failed to restore LED indicators after resuming")
+	}
+	if a.webServer != nil {
+		a.webServer.BroadcastState()
+	}
+}
+
+// reconnected syncs the volumes and control values again after the
+// connection to PulseAudio was replaced, in the direction of startupSync like
+// at startup, since a restarted server forgot the volumes. The combined sinks
+// are created again on their own.
+func (a *App) reconnected() {
+	defer supervise.Recover("pulseaudio.reconnected")
+	switch a.configManager.GetConfigSnapshot().StartupSync {
+	case configuration.StartupSyncAdopt:
+		a.log.Info().Msg("Reconnected to PulseAudio, adopting the current volumes")
+		adoptVolumes(a.paClient, a.configManager, a.executor)
+	case configuration.StartupSyncOff:
+		a.log.Info().Msg("Reconnected to PulseAudio, startup volume sync is off, leaving volumes alone")
+	default:
+		a.log.Info().Msg("Reconnected to PulseAudio, applying the control values again")
+		applyControlValues(a.paClient, a.configManager, a.executor)
+	}
+	if err := a.midiClient.RestoreLEDs(); err != nil {
+		a.log.Warn().Err(err).Msg("Failed to restore LED indicators after reconnecting")
+	}
+	if a.webServer != nil {
+		a.webServer.BroadcastState()
+	}
+}
+
+// syncCombinedSinks creates and removes combined output devices to match the
+// configuration
+func (a *App) syncCombinedSinks() {
+	if err := a.paClient.SyncCombinedSinks(a.configManager.GetConfigSnapshot().CombinedSinks); err != nil {
+		a.log.Warn().Err(err).Msg("Failed to sync combined sinks")
+	}
+}
+
+// syncVirtualSinks creates and removes the virtual output devices of the
+// controls to match the configuration. The ones it created are set to the
+// values of their controls, they may come after the controls were applied.
+func (a *App) syncVirtualSinks() {
+	config := a.configManager.GetConfigSnapshot()
+	created, err := a.paClient.SyncVirtualSinks(config.VirtualSinks())
+	if err != nil {
+		a.log.Warn().Err(err).Msg("Failed to sync virtual sinks")
+	}
+	for _, name := range created {
+		applyNewStreamVolumes(pulseaudio.Stream{Name: name}, configuration.OutputDevice, a.configManager, a.executor)
+	}
+}
+
+// startControlSocket creates the local control socket for scripts and
+// pulsekontrol ctl. Like the D-Bus interface it is optional.
+func (a *App) startControlSocket() {
+	server := control.NewServer(control.SocketPath(), a.configManager, a.executor)
+	if err := server.Start(); err != nil {
+		a.log.Warn().Err(err).Msg("Cannot create the control socket")
+		return
+	}
+	a.controlServer = server
+}
+
+// pulseAudioPollInterval is how often PulseAudio is pinged to notice a lost connection
+const pulseAudioPollInterval = 5 * time.Second
+
+// watchPulseAudio pings PulseAudio and notifies pulseaudio.disconnected with
+// the error when it stops answering and pulseaudio.connected when it answers
+// again
+func watchPulseAudio(ctx context.Context, paClient pulseaudio.Backend, configManager *configuration.ConfigManager, clock clock.Clock) {
+	supervise.Go("pulseaudio.connection", func() {
+		connected := true
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-clock.After(pulseAudioPollInterval):
+			}
+			err := paClient.Ping()
+			if (err == nil) == connected {
+				continue
+			}
+			connected = err == nil
+			if connected {
+				configManager.Notify("pulseaudio.connected", map[string]interface{}{})
+			} else {
+				configManager.Notify("pulseaudio.disconnected", map[string]interface{}{"error": err.Error()})
+			}
+		}
+	})
+}
+
+// startNotifications shows desktop notifications for a lost MIDI device or
+// PulseAudio connection, failed saves and profile switches. Like the D-Bus
+// interface they are optional.
+func (a *App) startNotifications() {
+	notifier, err := notify.Connect()
+	if err != nil {
+		a.log.Warn().Err(err).Msg("Desktop notifications are disabled")
+		return
+	}
+	a.notifier = notifier
+	device := a.midiDevice.Name
+
+	a.subscribe("midi.disconnected", func(data interface{}) {
+		updateMap, _ := data.(map[string]interface{})
+		message, _ := updateMap["error"].(string)
+		notifier.Post(notify.Event{Topic: "midi", Severity: notify.Error, Summary: "MIDI device not connected", Body: device + ": " + message})
+	})
+	a.subscribe("midi.connected", func(data interface{}) {
+		notifier.Post(notify.Event{Topic: "midi", Severity: notify.Info, Summary: "MIDI device connected", Body: device + " is back", Recovery: true})
+	})
+
+	a.subscribe("config.save.failed", func(data interface{}) {
+		updateMap, _ := data.(map[string]interface{})
+		message, _ := updateMap["error"].(string)
+		notifier.Post(notify.Event{Topic: "config", Severity: notify.Error, Summary: "Configuration not saved", Body: message})
+	})
+	a.subscribe("config.save.recovered", func(data interface{}) {
+		notifier.Post(notify.Event{Topic: "config", Severity: notify.Info, Summary: "Configuration saved", Body: "Saving works again", Recovery: true})
+	})
+	a.subscribe("profile.switched", func(data interface{}) {
+		updateMap, _ := data.(map[string]interface{})
+		name, _ := updateMap["name"].(string)
+		notifier.Post(notify.Event{Topic: "profile", Severity: notify.Info, Summary: "Profile " + name})
+	})
+	a.subscribe("pulseaudio.disconnected", func(data interface{}) {
+		updateMap, _ := data.(map[string]interface{})
+		message, _ := updateMap["error"].(string)
+		notifier.Post(notify.Event{Topic: "pulseaudio", Severity: notify.Error, Summary: "PulseAudio connection lost", Body: message})
+	})
+	a.subscribe("pulseaudio.connected", func(data interface{}) {
+		notifier.Post(notify.Event{Topic: "pulseaudio", Severity: notify.Info, Summary: "PulseAudio connected", Body: "Volumes are controlled again", Recovery: true})
+	})
+}
+
+// Errors receives the error of a part that failed while running, after which
+// the App should be stopped
+func (a *App) Errors() <-chan error {
+	return a.errs
+}
+
+// fail reports an error on Errors, keeping the first one if nobody receives it
+func (a *App) fail(err error) {
+	select {
+	case a.errs <- err:
+	default:
+		a.log.Error().Err(err).Msg("Failure while another one is pending")
+	}
+}
+
+// shutdownTimeout bounds waiting for a part to stop, so that a stuck one
+// can't keep pulsekontrol from exiting
+const shutdownTimeout = 5 * time.Second
+
+// Stop stops everything in order: web clients first so nothing changes
+// anymore, then the MIDI ports and PulseAudio monitoring, and last the
+// configuration with any unsaved changes. It returns the error if those
+// can't be saved. Calling it again returns the same result.
+func (a *App) Stop() error {
+	a.stopOnce.Do(func() {
+		a.stopErr = a.stop()
+	})
+	return a.stopErr
+}
+
+func (a *App) stop() error {
+	if a.options.Systemd {
+		if err := systemd.Notify(systemd.Stopping); err != nil {
+			a.log.Warn().Err(err).Msg("Failed to notify systemd of shutdown")
+		}
+	}
+
+	a.mu.Lock()
+	started := a.started
+	if a.cancel != nil {
+		a.cancel()
+	}
+	a.mu.Unlock()
+
+	if started {
+		if a.advertisement != nil {
+			a.advertisement.Withdraw()
+		}
+		if a.webServer != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			if err := a.webServer.Shutdown(ctx); err != nil {
+				a.log.Warn().Err(err).Msg("Failed to stop web server")
+			}
+			cancel()
+		}
+		if a.dbusService != nil {
+			a.dbusService.Stop()
+		}
+		if a.oscServer != nil {
+			a.oscServer.Stop()
+		}
+		if a.hotkeys != nil {
+			a.hotkeys.Stop()
+		}
+		if a.streamDeck != nil {
+			a.streamDeck.Stop()
+		}
+		if a.scheduler != nil {
+			a.scheduler.Stop()
+		}
+		if a.suspend != nil {
+			a.suspend.Stop()
+		}
+		if a.controlServer != nil {
+			a.controlServer.Stop()
+		}
+
+		select {
+		case <-a.midiDone:
+		case <-time.After(shutdownTimeout):
+			a.log.Warn().Msg("MIDI client did not stop in time, its ports may stay open")
+		}
+
+		// The last values of a sweep are applied and stored before saving
+		if !a.executor.WaitQueued(shutdownTimeout) {
+			a.log.Warn().Msg("Control values were not applied in time, the latest may be lost")
+		}
+		a.executor.Close()
+
+		// Stop stream monitoring
+		a.paClient.StopStreamMonitoring()
+
+		if a.notifier != nil {
+			a.notifier.Stop()
+		}
+		if a.hooks != nil {
+			a.hooks.Stop()
+		}
+		if a.webhooks != nil {
+			a.webhooks.Stop()
+		}
+	}
+
+	// Leave no callbacks of this App on the configuration manager
+	for _, subscription := range a.subscriptions {
+		a.configManager.Unsubscribe(subscription)
+	}
+	a.subscriptions = nil
+
+	// Don't lose changes that are waiting for the debounced save or a retry
+	err := a.configManager.Flush()
+	if err != nil {
+		a.log.Error().Err(err).Msg("Failed to save configuration on shutdown, the latest changes are lost")
+	}
+	a.configManager.Close()
+	a.unlock()
+	return err
+}
+
+// ReloadLogging applies the logging section of the configuration file again,
+// for changes made to it while running
+func (a *App) ReloadLogging() error {
+	logging := a.configManager.GetConfigSnapshot().Logging
+	if a.options.Config == nil || a.options.ConfigPath != "" {
+		result, err := configuration.Inspect()
+		if err != nil {
+			return err
+		}
+		logging = result.Config.Logging
+	}
+	applyLogging(logging, a.options.logFlags())
+	return nil
+}
+
+// notifyStatus shows what pulsekontrol is doing in systemctl status
+func (a *App) notifyStatus(status string) {
+	if !a.options.Systemd {
+		return
+	}
+	if err := systemd.Status(status); err != nil {
+		a.log.Warn().Err(err).Msg("Failed to send status to systemd")
+	}
+}
+
+// setupWatchdog pings the systemd watchdog while the configuration can be
+// read, so that systemd restarts a hung pulsekontrol. PulseAudio is pinged
+// too, but only for the status: while it is down pulsekontrol keeps running
+// without it and reconnects in the background. Nothing is done unless the
+// service sets WatchdogSec.
+func (a *App) setupWatchdog(ctx context.Context) {
+	paClient, configManager := a.paClient, a.configManager
+	interval, ok := systemd.WatchdogInterval()
+	if !ok {
+		return
+	}
+	a.log.Info().Dur("interval", interval).Msg("Pinging the systemd watchdog")
+
+	supervise.Go("watchdog", func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		reachable := true
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			// Blocks when stuck, and then the pings stop
+			configManager.GetConfigSnapshot()
+			if err := systemd.Notify(systemd.Watchdog); err != nil {
+				a.log.Warn().Err(err).Msg("Failed to ping the systemd watchdog")
+			}
+
+			// Operations time out, so a hung PulseAudio doesn't hold this up
+			err := paClient.Ping()
+			if (err == nil) == reachable {
+				continue
+			}
+			reachable = err == nil
+			status := "PulseAudio is back"
+			if reachable {
+				a.log.Info().Msg(status)
+			} else {
+				status = "PulseAudio does not respond, retrying in the background"
+				a.log.Warn().Err(err).Msg(status)
+			}
+			if err := systemd.Status(status); err != nil {
+				a.log.Warn().Err(err).Msg("Failed to send status to systemd")
+			}
+		}
+	})
+}
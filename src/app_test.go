@@ -0,0 +1,615 @@
+package pulsekontrol
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/activity"
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/0h41/pulsekontrol/src/internal/testutil"
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+)
+
+// testConfig returns a configuration of a Generic device whose first fader
+// sends controller 7 on channel 1, on slider1
+func testConfig(sources ...configuration.Source) *configuration.Config {
+	channel := uint8(0)
+	return &configuration.Config{
+		Devices: []configuration.DeviceConfig{{
+			Name:    "mixer",
+			Type:    configuration.Generic,
+			InPort:  "Fake MIDI In",
+			OutPort: "Fake MIDI Out",
+			Channel: &channel,
+			ControlMap: configuration.ControlMap{
+				Sliders: map[string]configuration.ControlBinding{"Fader1": {Number: 7}},
+			},
+		}},
+		Controls: configuration.Controls{
+			Sliders: map[string]configuration.SliderConfig{
+				"slider1": {Path: "Fader1", Value: 80, Sources: sources},
+			},
+		},
+	}
+}
+
+// startApp runs an App with the fake audio system and MIDI device, without
+// web interface, and stops it at the end of the test
+func startApp(t *testing.T, config *configuration.Config, backend *testutil.FakeBackend, driver *testutil.FakeDriver) *App {
+	t.Helper()
+	// The control socket is created in the runtime directory
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	webUI := false
+	app, err := New(Options{
+		Config:       config,
+		PulseAudio:   backend,
+		Midi:         driver,
+		WebUI:        &webUI,
+		NoAutodetect: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := app.Stop(); err != nil {
+			t.Errorf("stop failed: %v", err)
+		}
+	})
+	return app
+}
+
+// waitForVolume waits until the fake audio system was asked to set the
+// volume of a stream
+func waitForVolume(t *testing.T, backend *testutil.FakeBackend, name string, volume float32) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		for _, change := range backend.VolumeChanges() {
+			if change.Target.Name == name && math.Abs(float64(change.Volume-volume)) < 0.005 {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("volume of %s never set to %.2f, changes: %+v", name, volume, backend.VolumeChanges())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStartupSyncAppliesStoredValues(t *testing.T) {
+	backend := testutil.NewFakeBackend(testutil.FakeStream{Type: configuration.PlaybackStream, Name: "Firefox", Volume: 0.3})
+	startApp(t, testConfig(configuration.Source{Type: configuration.PlaybackStream, Name: "Firefox"}), backend, testutil.NewFakeDriver(true))
+
+	waitForVolume(t, backend, "Firefox", 0.8)
+}
+
+func TestAssignmentSetsVolume(t *testing.T) {
+	backend := testutil.NewFakeBackend(testutil.FakeStream{Type: configuration.PlaybackStream, Name: "Spotify", Volume: 0.3})
+	app := startApp(t, testConfig(), backend, testutil.NewFakeDriver(true))
+
+	app.ConfigManager().AssignSource("slider", "slider1", configuration.Source{Type: configuration.PlaybackStream, Name: "Spotify"})
+	// The newly assigned source follows the slider at once
+	waitForVolume(t, backend, "Spotify", 0.8)
+}
+
+func TestControlChangeUpdatesConfigAndVolume(t *testing.T) {
+	backend := testutil.NewFakeBackend(testutil.FakeStream{Type: configuration.PlaybackStream, Name: "Firefox", Volume: 0.3})
+	driver := testutil.NewFakeDriver(true)
+	app := startApp(t, testConfig(configuration.Source{Type: configuration.PlaybackStream, Name: "Firefox"}), backend, driver)
+
+	select {
+	case <-driver.Opened():
+	case <-time.After(5 * time.Second):
+		t.Fatal("MIDI device never opened")
+	}
+	// The fader is listened to shortly after the ports are open
+	deadline := time.Now().Add(5 * time.Second)
+	for !driver.In().SendControlChange(0, 7, 127) {
+		if time.Now().After(deadline) {
+			t.Fatal("nobody listens to the MIDI device")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	waitForVolume(t, backend, "Firefox", 1)
+	deadline = time.Now().Add(5 * time.Second)
+	for app.ConfigManager().GetConfigSnapshot().Controls.Sliders["slider1"].Value != 100 {
+		if time.Now().After(deadline) {
+			t.Fatalf("slider1 is %d, want 100", app.ConfigManager().GetConfigSnapshot().Controls.Sliders["slider1"].Value)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStartupSyncRestoresMute(t *testing.T) {
+	backend := testutil.NewFakeBackend(testutil.FakeStream{Type: configuration.PlaybackStream, Name: "Firefox", Volume: 0.3})
+	config := testConfig(configuration.Source{Type: configuration.PlaybackStream, Name: "Firefox"})
+	slider := config.Controls.Sliders["slider1"]
+	slider.Muted = true
+	config.Controls.Sliders["slider1"] = slider
+	startApp(t, config, backend, testutil.NewFakeDriver(true))
+
+	waitForVolume(t, backend, "Firefox", 0.8)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		sources := backend.GetAudioSources()
+		if len(sources) == 1 && sources[0].Muted {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Firefox not muted again: %+v", sources)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestResumeSyncsAgain(t *testing.T) {
+	firefox := configuration.TypedTarget{Type: configuration.PlaybackStream, Name: "Firefox"}
+	// changeBehindBack sets the volume of Firefox once pushed at startup, like
+	// the sound server did while the system slept, and returns how many
+	// changes were asked for
+	changeBehindBack := func(backend *testutil.FakeBackend) int {
+		t.Helper()
+		waitForVolume(t, backend, "Firefox", 0.8)
+		backend.ProcessVolumeAction(configuration.Action{Type: configuration.SetVolume, Target: &firefox}, 0.4)
+		return len(backend.VolumeChanges())
+	}
+
+	t.Run("push", func(t *testing.T) {
+		backend := testutil.NewFakeBackend(testutil.FakeStream{Type: configuration.PlaybackStream, Name: "Firefox", Volume: 0.3})
+		app := startApp(t, testConfig(configuration.Source{Type: configuration.PlaybackStream, Name: "Firefox"}), backend, testutil.NewFakeDriver(true))
+		changes := changeBehindBack(backend)
+		refreshes := backend.Refreshes()
+
+		app.resumed()
+		if backend.Refreshes() != refreshes+1 {
+			t.Error("PulseAudio not refreshed after resuming")
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if stream, _ := backend.Stream(configuration.PlaybackStream, "Firefox"); math.Abs(float64(stream.Volume-0.8)) < 0.005 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("volume not set again, changes since resuming: %+v", backend.VolumeChanges()[changes:])
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	t.Run("adopt", func(t *testing.T) {
+		backend := testutil.NewFakeBackend(testutil.FakeStream{Type: configuration.PlaybackStream, Name: "Firefox", Volume: 0.3})
+		config := testConfig(configuration.Source{Type: configuration.PlaybackStream, Name: "Firefox"})
+		config.StartupSync = configuration.StartupSyncAdopt
+		app := startApp(t, config, backend, testutil.NewFakeDriver(true))
+		backend.ProcessVolumeAction(configuration.Action{Type: configuration.SetVolume, Target: &firefox}, 0.4)
+		changes := len(backend.VolumeChanges())
+
+		app.resumed()
+		if value := app.ConfigManager().GetConfigSnapshot().Controls.Sliders["slider1"].Value; value != 40 {
+			t.Errorf("slider1 is %d after resuming, want the volume 40 adopted", value)
+		}
+		if changed := backend.VolumeChanges()[changes:]; len(changed) != 0 {
+			t.Errorf("volumes changed after resuming: %+v", changed)
+		}
+	})
+
+	t.Run("no sound server", func(t *testing.T) {
+		backend := testutil.NewFakeBackend(testutil.FakeStream{Type: configuration.PlaybackStream, Name: "Firefox", Volume: 0.3})
+		app := startApp(t, testConfig(configuration.Source{Type: configuration.PlaybackStream, Name: "Firefox"}), backend, testutil.NewFakeDriver(true))
+		changes := changeBehindBack(backend)
+		backend.SetPingError(errors.New("connection refused"))
+
+		app.resumed()
+		time.Sleep(50 * time.Millisecond)
+		if changed := backend.VolumeChanges()[changes:]; len(changed) != 0 {
+			t.Errorf("volumes changed without a sound server: %+v", changed)
+		}
+		backend.SetPingError(nil)
+	})
+}
+
+func TestReconnectSyncsInStartupDirection(t *testing.T) {
+	firefox := configuration.TypedTarget{Type: configuration.PlaybackStream, Name: "Firefox"}
+	for _, test := range []struct {
+		mode   configuration.StartupSyncMode
+		value  int     // Value of slider1 after reconnecting
+		volume float32 // Volume of Firefox after reconnecting
+	}{
+		{configuration.StartupSyncPush, 80, 0.8},
+		{configuration.StartupSyncAdopt, 40, 0.4},
+		{configuration.StartupSyncOff, 80, 0.4},
+	} {
+		t.Run(string(test.mode), func(t *testing.T) {
+			backend := testutil.NewFakeBackend(testutil.FakeStream{Type: configuration.PlaybackStream, Name: "Firefox", Volume: 0.4})
+			config := testConfig(configuration.Source{Type: configuration.PlaybackStream, Name: "Firefox"})
+			config.StartupSync = test.mode
+			app := startApp(t, config, backend, testutil.NewFakeDriver(true))
+			// The restarted server has its own idea of the volume
+			backend.ProcessVolumeAction(configuration.Action{Type: configuration.SetVolume, Target: &firefox}, 0.4)
+			if test.mode == configuration.StartupSyncAdopt {
+				app.ConfigManager().UpdateControlValue(activity.Startup(), "slider", "slider1", 80)
+			}
+
+			app.reconnected()
+			if value := app.ConfigManager().GetConfigSnapshot().Controls.Sliders["slider1"].Value; value != test.value {
+				t.Errorf("slider1 is %d after reconnecting, want %d", value, test.value)
+			}
+			if stream, _ := backend.Stream(configuration.PlaybackStream, "Firefox"); math.Abs(float64(stream.Volume-test.volume)) > 0.005 {
+				t.Errorf("Firefox is at %.2f after reconnecting, want %.2f", stream.Volume, test.volume)
+			}
+		})
+	}
+}
+
+// freeAddress returns a local address nothing listens on
+func freeAddress(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+func TestStopShutsDownEverything(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	backend := testutil.NewFakeBackend(testutil.FakeStream{Type: configuration.PlaybackStream, Name: "Firefox", Volume: 0.3})
+	driver := testutil.NewFakeDriver(true)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	address := freeAddress(t)
+	webUI := true
+	app, err := New(Options{
+		Config:       testConfig(configuration.Source{Type: configuration.PlaybackStream, Name: "Firefox"}),
+		ConfigPath:   path,
+		PulseAudio:   backend,
+		Midi:         driver,
+		WebUI:        &webUI,
+		WebAddr:      address,
+		NoAutodetect: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// A web client is connected
+	var client *websocket.Conn
+	deadline := time.Now().Add(5 * time.Second)
+	for client == nil {
+		client, _, err = websocket.DefaultDialer.Dial("ws://"+address+"/ws", nil)
+		if err != nil && time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer client.Close()
+	closed := make(chan error, 1)
+	go func() {
+		for {
+			if _, _, err := client.ReadMessage(); err != nil {
+				closed <- err
+				return
+			}
+		}
+	}()
+
+	// A fader moves just before the signal, its save is still pending
+	select {
+	case <-driver.Opened():
+	case <-time.After(5 * time.Second):
+		t.Fatal("MIDI device never opened")
+	}
+	deadline = time.Now().Add(5 * time.Second)
+	for !driver.In().SendControlChange(0, 7, 127) {
+		if time.Now().After(deadline) {
+			t.Fatal("nobody listens to the MIDI device")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	waitForVolume(t, backend, "Firefox", 1)
+
+	if err := app.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-closed:
+		if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+			t.Errorf("web client disconnected with %v, want a going-away close frame", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("web client not disconnected")
+	}
+	if driver.In().IsOpen() || driver.Out().IsOpen() {
+		t.Error("MIDI ports left open")
+	}
+	if backend.Monitoring() {
+		t.Error("stream monitoring not stopped")
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved configuration.Config
+	if err := yaml.Unmarshal(content, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if value := saved.Profiles["default"].Controls.Sliders["slider1"].Value; value != 100 {
+		t.Errorf("slider1 saved as %d, want the last value 100", value)
+	}
+	if _, err := net.Dial("tcp", address); err == nil {
+		t.Error("web server still accepts connections")
+	}
+
+	// Stopping again returns the same result
+	if err := app.Stop(); err != nil {
+		t.Errorf("second stop returned %v", err)
+	}
+}
+
+// notifySocket creates a fake systemd notify socket for the test and returns
+// a channel receiving the messages sent to it
+func notifySocket(t *testing.T) <-chan string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("NOTIFY_SOCKET", path)
+	messages := make(chan string, 100)
+	go func() {
+		buffer := make([]byte, 4096)
+		for {
+			n, err := conn.Read(buffer)
+			if err != nil {
+				return
+			}
+			messages <- string(buffer[:n])
+		}
+	}()
+	t.Cleanup(func() { conn.Close() })
+	return messages
+}
+
+// awaitNotify waits for a message to systemd
+func awaitNotify(t *testing.T, messages <-chan string, want string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case message := <-messages:
+			if message == want {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("%s never sent", want)
+		}
+	}
+}
+
+func TestSystemdNotifications(t *testing.T) {
+	messages := notifySocket(t)
+	// Pings every 20ms
+	t.Setenv("WATCHDOG_USEC", "40000")
+	t.Setenv("WATCHDOG_PID", "")
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	backend := testutil.NewFakeBackend()
+	webUI := false
+	app, err := New(Options{
+		Config:       testConfig(),
+		PulseAudio:   backend,
+		Midi:         testutil.NewFakeDriver(true),
+		WebUI:        &webUI,
+		NoAutodetect: true,
+		Systemd:      true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	awaitNotify(t, messages, "READY=1")
+	awaitNotify(t, messages, "WATCHDOG=1")
+
+	// A sound server that doesn't respond shows in the status, the pings go
+	// on so that systemd doesn't restart us while we wait for it
+	backend.SetPingError(errors.New("no answer"))
+	awaitNotify(t, messages, "STATUS=PulseAudio does not respond, retrying in the background")
+	awaitNotify(t, messages, "WATCHDOG=1")
+	awaitNotify(t, messages, "WATCHDOG=1")
+	backend.SetPingError(nil)
+	awaitNotify(t, messages, "STATUS=PulseAudio is back")
+	awaitNotify(t, messages, "WATCHDOG=1")
+
+	if err := app.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	awaitNotify(t, messages, "STOPPING=1")
+}
+
+// syncWriter serializes writes, for logs written from several goroutines
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+// String returns what was written to a strings.Builder
+func (w *syncWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.(*strings.Builder).String()
+}
+
+// fileOptions returns options loading the configuration at path, with the
+// fake audio system and MIDI device and without web interface. The path is
+// reset when the test ends.
+func fileOptions(t *testing.T, path string, backend *testutil.FakeBackend, driver *testutil.FakeDriver) Options {
+	t.Helper()
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	t.Cleanup(func() { configuration.SetPath("") })
+	webUI := false
+	return Options{
+		ConfigPath:   path,
+		PulseAudio:   backend,
+		Midi:         driver,
+		WebUI:        &webUI,
+		NoAutodetect: true,
+	}
+}
+
+func TestAppLifecycle(t *testing.T) {
+	config, err := configuration.Prepare(*testConfig(configuration.Source{Type: configuration.PlaybackStream, Name: "Firefox"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := configuration.Marshal(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	backend := testutil.NewFakeBackend(testutil.FakeStream{Type: configuration.PlaybackStream, Name: "Firefox", Volume: 0.3})
+	options := fileOptions(t, path, backend, testutil.NewFakeDriver(true))
+	logs := &syncWriter{w: &strings.Builder{}}
+	options.LogOutput = logs
+
+	app, err := New(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manager := app.ConfigManager(); manager.Path() != path || manager.ReadOnly() {
+		t.Errorf("configuration loaded from %s, read-only %v", manager.Path(), manager.ReadOnly())
+	}
+	if !strings.Contains(logs.String(), "Loaded configuration from "+path) {
+		t.Errorf("nothing logged to the log output:\n%s", logs.String())
+	}
+
+	// The configuration is locked from New on. Logging is global, the
+	// output of the last App applies.
+	second := options
+	second.LogOutput = io.Discard
+	var lockErr *configuration.LockError
+	if _, err := New(second); !errors.As(err, &lockErr) {
+		t.Errorf("second App on the same configuration got %v", err)
+	}
+	second.NoLock = true
+	if readOnly, err := New(second); err != nil || !readOnly.ConfigManager().ReadOnly() {
+		t.Errorf("second App without lock failed with %v or can save", err)
+	} else {
+		readOnly.Stop()
+	}
+
+	if err := app.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Start(context.Background()); err == nil {
+		t.Error("started twice")
+	}
+	waitForVolume(t, backend, "Firefox", 0.8)
+	app.ConfigManager().AssignSource("slider", "slider1", configuration.Source{Type: configuration.PlaybackStream, Name: "mpv"})
+
+	if err := app.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Stop(); err != nil {
+		t.Errorf("second stop returned %v", err)
+	}
+	select {
+	case err := <-app.Errors():
+		t.Errorf("App failed with %v", err)
+	default:
+	}
+
+	// Stopping saved the change and released the lock
+	again, err := New(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again.Stop()
+	if again.ConfigManager().ReadOnly() {
+		t.Error("configuration still locked after stop")
+	}
+	if sources := again.ConfigManager().ControlSources("slider", "slider1"); len(sources) != 2 {
+		t.Errorf("slider1 loaded with sources %+v", sources)
+	}
+}
+
+func TestAppLoggerAndSubscriptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	options := fileOptions(t, path, testutil.NewFakeBackend(), testutil.NewFakeDriver(true))
+	logs := &syncWriter{w: &strings.Builder{}}
+	logger := zerolog.New(logs).With().Str("embedder", "test").Logger()
+	options.Logger = &logger
+
+	app, err := New(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logs.String(), `"embedder":"test"`) || !strings.Contains(logs.String(), "Loaded configuration from") {
+		t.Errorf("the App didn't log to its logger:\n%s", logs.String())
+	}
+
+	// Only the App itself listens to undo and redo
+	manager := app.ConfigManager()
+	if !manager.Subscribed("history.applied") {
+		t.Fatal("the App doesn't subscribe to history.applied")
+	}
+	if err := app.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if manager.Subscribed("history.applied") {
+		t.Error("callbacks of the App left subscribed after Stop")
+	}
+}
+
+func TestStopWithoutStart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	options := fileOptions(t, path, testutil.NewFakeBackend(), testutil.NewFakeDriver(false))
+	app, err := New(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The default configuration was written when loading
+	if _, err := os.Stat(path); err != nil {
+		t.Error(err)
+	}
+	if err := app.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := configuration.Lock(path)
+	if err != nil {
+		t.Fatalf("lock not released: %v", err)
+	}
+	lock.Unlock()
+}
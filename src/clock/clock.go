@@ -0,0 +1,25 @@
+// Package clock is the time as pulsekontrol sees it, so that tests can move
+// it instead of waiting for polls and retries.
+package clock
+
+import "time"
+
+// Clock tells the time and waits for it
+type Clock interface {
+	Now() time.Time
+	// After sends the time once d has passed
+	After(d time.Duration) <-chan time.Time
+}
+
+// System is the clock of the machine
+var System Clock = system{}
+
+type system struct{}
+
+func (system) Now() time.Time {
+	return time.Now()
+}
+
+func (system) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
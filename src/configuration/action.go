@@ -0,0 +1,246 @@
+package configuration
+
+import (
+	"fmt"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// UnmarshalYAML decodes an action and resolves its raw target into the
+// target type expected by the action type
+func (action *Action) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		Type   PulseAudioActionType `yaml:"type"`
+		Target yaml.Node            `yaml:"target"`
+	}
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	action.Type = raw.Type
+	action.RawTarget = raw.Target
+	target, err := decodeActionTarget(raw.Type, &raw.Target)
+	if err != nil {
+		return fmt.Errorf("line %d: action %s: %w", value.Line, raw.Type, err)
+	}
+	action.Target = target
+	return nil
+}
+
+// MarshalYAML encodes an action with its resolved target
+func (action Action) MarshalYAML() (interface{}, error) {
+	return struct {
+		Type   PulseAudioActionType `yaml:"type"`
+		Target interface{}          `yaml:"target,omitempty"`
+	}{
+		Type:   action.Type,
+		Target: action.Target,
+	}, nil
+}
+
+// IsMute returns whether the action mutes or unmutes: ToggleMute, Mute or
+// Unmute. They share their targets.
+func (action Action) IsMute() bool {
+	return action.Type == ToggleMute || action.Type == Mute || action.Type == Unmute
+}
+
+// IsLoopback returns whether the action loads or unloads a loopback:
+// ToggleLoopback, LoadLoopback or UnloadLoopback
+func (action Action) IsLoopback() bool {
+	return action.Type == ToggleLoopback || action.Type == LoadLoopback || action.Type == UnloadLoopback
+}
+
+// IsSuspend returns whether the action suspends or resumes an output device:
+// ToggleSuspendOutput, SuspendOutput or ResumeOutput
+func (action Action) IsSuspend() bool {
+	return action.Type == ToggleSuspendOutput || action.Type == SuspendOutput || action.Type == ResumeOutput
+}
+
+// IsVolumeStep returns whether the action steps the volume of a source:
+// VolumeUp or VolumeDown
+func (action Action) IsVolumeStep() bool {
+	return action.Type == VolumeUp || action.Type == VolumeDown
+}
+
+// VolumeStep returns the step in percent of a VolumeUp or VolumeDown action,
+// negative to step down
+func (action Action) VolumeStep() int {
+	step := DefaultStepSize
+	if target, ok := action.Target.(*VolumeStepTarget); ok && target != nil && target.Step != 0 {
+		step = target.Step
+	}
+	if action.Type == VolumeDown {
+		return -step
+	}
+	return step
+}
+
+// MicMuteInput returns the name of the input device a ToggleMicMute action
+// mutes, empty for the default input device
+func (action Action) MicMuteInput() string {
+	if target, ok := action.Target.(*Target); ok && target != nil {
+		return target.Name
+	}
+	return ""
+}
+
+// ButtonsWithAction returns the ids of the buttons with an action of a type,
+// sorted
+func (controls Controls) ButtonsWithAction(actionType PulseAudioActionType) []string {
+	var ids []string
+	for id, button := range controls.Buttons {
+		if slices.ContainsFunc(button.Actions, func(action Action) bool { return action.Type == actionType }) {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// decodeMuteTarget decodes the target of a mute action: a control
+// target mutes a slider or knob, a typed target a source, and a plain name
+// the slider or knob with that id
+func decodeMuteTarget(node *yaml.Node) (interface{}, error) {
+	var probe struct {
+		Type        PulseAudioTargetType `yaml:"type"`
+		ControlType string               `yaml:"controlType"`
+	}
+	if err := node.Decode(&probe); err != nil {
+		return nil, err
+	}
+	var target interface{}
+	switch {
+	case probe.ControlType != "":
+		target = &ControlTarget{}
+	case probe.Type != "":
+		target = &TypedTarget{}
+	default:
+		target = &Target{}
+	}
+	if err := node.Decode(target); err != nil {
+		return nil, err
+	}
+	return target, nil
+}
+
+// MuteControl returns the slider or knob a mute action mutes, given by a
+// control target or by its id as a plain target. Actions muting a source
+// directly have none.
+func (action Action) MuteControl(config *Config) (*ControlTarget, bool) {
+	if !action.IsMute() {
+		return nil, false
+	}
+	switch target := action.Target.(type) {
+	case *ControlTarget:
+		return target, target != nil
+	case *Target:
+		if target == nil {
+			return nil, false
+		}
+		if controlType, ok := config.ControlType(target.Name); ok {
+			return &ControlTarget{ControlType: controlType, ControlID: target.Name}, true
+		}
+	}
+	return nil, false
+}
+
+// decodeActionTarget decodes the target node of an action according to the
+// action type. Actions without a target return nil.
+func decodeActionTarget(actionType PulseAudioActionType, node *yaml.Node) (interface{}, error) {
+	if node.Kind == 0 || (node.Kind == yaml.ScalarNode && node.Tag == "!!null") {
+		return nil, nil
+	}
+
+	switch actionType {
+	case SetVolume:
+		target := &TypedTarget{}
+		if err := node.Decode(target); err != nil {
+			return nil, err
+		}
+		return target, nil
+	case SetDefaultOutput, SetDefaultInput, RecallScene, ToggleMicMute, ToggleSuspendOutput, SuspendOutput, ResumeOutput:
+		target := &Target{}
+		if err := node.Decode(target); err != nil {
+			return nil, err
+		}
+		return target, nil
+	case ToggleMute, Mute, Unmute:
+		return decodeMuteTarget(node)
+	case AssignFocusedWindowPlaybackStreams:
+		target := &ControlTarget{}
+		if err := node.Decode(target); err != nil {
+			return nil, err
+		}
+		return target, nil
+	case StepControl:
+		target := &StepTarget{}
+		if err := node.Decode(target); err != nil {
+			return nil, err
+		}
+		return target, nil
+	case VolumeUp, VolumeDown:
+		target := &VolumeStepTarget{}
+		if err := node.Decode(target); err != nil {
+			return nil, err
+		}
+		return target, nil
+	case MoveStreamToSink:
+		target := &MoveTarget{}
+		if err := node.Decode(target); err != nil {
+			return nil, err
+		}
+		return target, nil
+	case CycleDefaultOutput:
+		target := &CycleTarget{}
+		if err := node.Decode(target); err != nil {
+			return nil, err
+		}
+		return target, nil
+	case SetCardProfile:
+		target := &CardProfileTarget{}
+		if err := node.Decode(target); err != nil {
+			return nil, err
+		}
+		return target, nil
+	case ToggleLoopback, LoadLoopback, UnloadLoopback:
+		target := &LoopbackTarget{}
+		if err := node.Decode(target); err != nil {
+			return nil, err
+		}
+		return target, nil
+	case ToggleEchoCancel:
+		target := &EchoCancelTarget{}
+		if err := node.Decode(target); err != nil {
+			return nil, err
+		}
+		return target, nil
+	case MediaPlayPause:
+		// Optionally names the media player
+		target := &Target{}
+		if err := node.Decode(target); err != nil {
+			return nil, err
+		}
+		return target, nil
+	}
+
+	// Unknown action types: a target with a type is a typed target, anything else is a plain name
+	var probe struct {
+		Type PulseAudioTargetType `yaml:"type"`
+	}
+	if err := node.Decode(&probe); err != nil {
+		return nil, err
+	}
+	if probe.Type != "" {
+		target := &TypedTarget{}
+		if err := node.Decode(target); err != nil {
+			return nil, err
+		}
+		return target, nil
+	}
+	target := &Target{}
+	if err := node.Decode(target); err != nil {
+		return nil, err
+	}
+	return target, nil
+}
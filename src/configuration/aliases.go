@@ -0,0 +1,101 @@
+package configuration
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AliasKey returns the key of an audio source in the aliases map
+func AliasKey(sourceType PulseAudioTargetType, name string) string {
+	return string(sourceType) + ":" + name
+}
+
+// splitAliasKey splits an aliases map key into source type and name
+func splitAliasKey(key string) (PulseAudioTargetType, string, bool) {
+	sourceType, name, ok := strings.Cut(key, ":")
+	return PulseAudioTargetType(sourceType), name, ok
+}
+
+// Alias returns the display name of an audio source, or an empty string if it has none
+func (config *Config) Alias(sourceType PulseAudioTargetType, name string) string {
+	return config.Aliases[AliasKey(sourceType, name)]
+}
+
+// ResolveAlias returns the real name of an audio source given either its
+// alias or its real name. Real names take precedence over aliases.
+func (config *Config) ResolveAlias(sourceType PulseAudioTargetType, name string) string {
+	if _, ok := config.Aliases[AliasKey(sourceType, name)]; ok {
+		return name
+	}
+	for key, alias := range config.Aliases {
+		keyType, keyName, ok := splitAliasKey(key)
+		if ok && keyType == sourceType && alias == name {
+			return keyName
+		}
+	}
+	return name
+}
+
+// SetAlias sets the display name of an audio source. An empty alias removes it.
+func (cm *ConfigManager) SetAlias(sourceType PulseAudioTargetType, name string, alias string) error {
+	alias = strings.TrimSpace(alias)
+	if alias == "" {
+		return cm.RemoveAlias(sourceType, name)
+	}
+	if !validSourceTypes[sourceType] {
+		return fmt.Errorf("unknown source type %s", sourceType)
+	}
+	if name == "" {
+		return fmt.Errorf("source name must not be empty")
+	}
+
+	cm.saveMutex.Lock()
+	defer cm.unlock()
+
+	key := AliasKey(sourceType, name)
+	if cm.config.Aliases[key] == alias {
+		return nil
+	}
+	if cm.config.Aliases == nil {
+		cm.config.Aliases = make(map[string]string)
+	}
+	cm.config.Aliases[key] = alias
+	cm.journal(journalAlias, "", key)
+
+	log.Info().Str("source", key).Str("alias", alias).Msg("Set source alias")
+
+	cm.notifyLocked("alias.updated", map[string]interface{}{
+		"type":  sourceType,
+		"name":  name,
+		"alias": alias,
+	})
+
+	// Schedule save
+	cm.SaveWithDebounce()
+	return nil
+}
+
+// RemoveAlias removes the display name of an audio source
+func (cm *ConfigManager) RemoveAlias(sourceType PulseAudioTargetType, name string) error {
+	cm.saveMutex.Lock()
+	defer cm.unlock()
+
+	key := AliasKey(sourceType, name)
+	if _, ok := cm.config.Aliases[key]; !ok {
+		return fmt.Errorf("source %s has no alias", key)
+	}
+	delete(cm.config.Aliases, key)
+	cm.journal(journalAlias, "", key)
+
+	log.Info().Str("source", key).Msg("Removed source alias")
+
+	cm.notifyLocked("alias.updated", map[string]interface{}{
+		"type":  sourceType,
+		"name":  name,
+		"alias": "",
+	})
+
+	// Schedule save
+	cm.SaveWithDebounce()
+	return nil
+}
@@ -0,0 +1,172 @@
+package configuration
+
+import (
+	"fmt"
+)
+
+// ControlAssignment is a slider or knob with its sources, as listed by the
+// assignment commands and the assignments API
+type ControlAssignment struct {
+	ControlType string           `json:"controlType"`
+	ControlID   string           `json:"controlId"`
+	Label       string           `json:"label,omitempty"`
+	Value       int              `json:"value"`
+	Muted       bool             `json:"muted"`
+	Sources     []AssignedSource `json:"sources"`
+}
+
+// AssignedSource is a source of a ControlAssignment
+type AssignedSource struct {
+	Type       PulseAudioTargetType `json:"type"`
+	Name       string               `json:"name"`
+	BinaryName string               `json:"binaryName,omitempty"`
+	ProcessID  int                  `json:"processId,omitempty"`
+	Cgroup     string               `json:"cgroup,omitempty"`
+}
+
+// AssignmentRequest assigns a source to a control or unassigns it. The name
+// may be an alias. Without a binary name, unassigning removes the sources of
+// all binaries with the name.
+type AssignmentRequest struct {
+	Control    string               `json:"control"`
+	Type       PulseAudioTargetType `json:"type"`
+	Name       string               `json:"name"`
+	BinaryName string               `json:"binaryName,omitempty"`
+	All        bool                 `json:"all,omitempty"` // Unassign all sources of the control
+}
+
+// AssignmentChange is the result of Assign or Unassign
+type AssignmentChange struct {
+	Control   ControlAssignment `json:"control"`             // State of the control afterwards
+	Policy    DuplicatePolicy   `json:"policy,omitempty"`    // The duplicateSources policy applied, empty if no other control had the source
+	Conflicts []string          `json:"conflicts,omitempty"` // The other controls that had the source
+	Removed   []AssignedSource  `json:"removed,omitempty"`   // Unassigned sources
+}
+
+// ControlType returns whether a control id is a slider or a knob
+func (config *Config) ControlType(controlId string) (string, bool) {
+	if _, ok := config.Controls.Sliders[controlId]; ok {
+		return "slider", true
+	}
+	if _, ok := config.Controls.Knobs[controlId]; ok {
+		return "knob", true
+	}
+	return "", false
+}
+
+// Assignment returns the sources and state of a slider or knob
+func (config *Config) Assignment(controlId string) (ControlAssignment, bool) {
+	assignment := ControlAssignment{ControlID: controlId, Sources: []AssignedSource{}}
+	var sources []Source
+	if slider, ok := config.Controls.Sliders[controlId]; ok {
+		assignment.ControlType = "slider"
+		assignment.Label, assignment.Value, assignment.Muted = slider.Label, slider.Value, slider.Muted
+		sources = slider.Sources
+	} else if knob, ok := config.Controls.Knobs[controlId]; ok {
+		assignment.ControlType = "knob"
+		assignment.Label, assignment.Value, assignment.Muted = knob.Label, knob.Value, knob.Muted
+		sources = knob.Sources
+	} else {
+		return assignment, false
+	}
+	for _, source := range sources {
+		assignment.Sources = append(assignment.Sources, assignedSource(source))
+	}
+	return assignment, true
+}
+
+func assignedSource(source Source) AssignedSource {
+	return AssignedSource{Type: source.Type, Name: source.Name, BinaryName: source.BinaryName, ProcessID: source.ProcessID, Cgroup: source.Cgroup}
+}
+
+// Assignments returns all sliders, then all knobs, in the order they are saved
+func (config *Config) Assignments() []ControlAssignment {
+	var assignments []ControlAssignment
+	for _, id := range controlOrder(config.Controls.Sliders, "slider") {
+		assignment, _ := config.Assignment(id)
+		assignments = append(assignments, assignment)
+	}
+	for _, id := range controlOrder(config.Controls.Knobs, "knob") {
+		assignment, _ := config.Assignment(id)
+		assignments = append(assignments, assignment)
+	}
+	return assignments
+}
+
+// requestSource returns the control type and the source of a request, with
+// an alias resolved to the name
+func (config *Config) requestSource(request AssignmentRequest) (string, Source, error) {
+	controlType, ok := config.ControlType(request.Control)
+	if !ok {
+		return "", Source{}, fmt.Errorf("no slider or knob %s", request.Control)
+	}
+	if request.All {
+		return controlType, Source{}, nil
+	}
+	switch request.Type {
+	case PlaybackStream, RecordStream, OutputDevice, InputDevice:
+	default:
+		return "", Source{}, fmt.Errorf("unknown source type %q", request.Type)
+	}
+	if request.Name == "" {
+		return "", Source{}, fmt.Errorf("missing source name")
+	}
+	return controlType, Source{
+		Type:       request.Type,
+		Name:       config.ResolveAlias(request.Type, request.Name),
+		BinaryName: request.BinaryName,
+	}, nil
+}
+
+// Assign assigns the source of a request to its control
+func (cm *ConfigManager) Assign(request AssignmentRequest) (AssignmentChange, error) {
+	config := cm.GetConfigSnapshot()
+	if request.All {
+		return AssignmentChange{}, fmt.Errorf("only unassigning applies to all sources")
+	}
+	controlType, source, err := config.requestSource(request)
+	if err != nil {
+		return AssignmentChange{}, err
+	}
+	// Sources of a control the device can't send would never be adjusted
+	if err := config.CheckControlPath(controlType, request.Control); err != nil {
+		return AssignmentChange{}, err
+	}
+
+	result := cm.AssignSource(controlType, request.Control, source)
+	change := AssignmentChange{Policy: result.Policy}
+	for _, conflict := range result.Conflicts {
+		change.Conflicts = append(change.Conflicts, conflict.ControlID)
+	}
+	config = cm.GetConfigSnapshot()
+	change.Control, _ = config.Assignment(request.Control)
+	return change, nil
+}
+
+// Unassign removes the sources matching a request from its control
+func (cm *ConfigManager) Unassign(request AssignmentRequest) (AssignmentChange, error) {
+	config := cm.GetConfigSnapshot()
+	controlType, source, err := config.requestSource(request)
+	if err != nil {
+		return AssignmentChange{}, err
+	}
+	var sources []Source
+	if controlType == "slider" {
+		sources = config.Controls.Sliders[request.Control].Sources
+	} else {
+		sources = config.Controls.Knobs[request.Control].Sources
+	}
+
+	var change AssignmentChange
+	for _, assigned := range sources {
+		if !request.All && (assigned.Type != source.Type || assigned.Name != source.Name ||
+			(source.BinaryName != "" && assigned.BinaryName != source.BinaryName)) {
+			continue
+		}
+		cm.UnassignSource(controlType, request.Control, assigned)
+		change.Removed = append(change.Removed, assignedSource(assigned))
+	}
+	config = cm.GetConfigSnapshot()
+	change.Control, _ = config.Assignment(request.Control)
+	return change, nil
+}
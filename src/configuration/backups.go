@@ -0,0 +1,108 @@
+package configuration
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// DefaultBackupCount is the number of backups kept when not configured
+const DefaultBackupCount = 3
+
+// Backup is a numbered copy of the configuration made before a save
+type Backup struct {
+	Number  int       `json:"number"` // 1 is the most recent
+	Path    string    `json:"path"`
+	ModTime time.Time `json:"modTime"`
+}
+
+func backupPath(configPath string, number int) string {
+	return fmt.Sprintf("%s.bak.%d", configPath, number)
+}
+
+// rotateBackups shifts config.yaml.bak.N up by one, dropping the oldest, and
+// keeps the current config file as config.yaml.bak.1. Called right before the
+// config file is replaced.
+func rotateBackups(configPath string, count int) error {
+	if count <= 0 {
+		return nil
+	}
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	if err := os.Remove(backupPath(configPath, count)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for number := count - 1; number >= 1; number-- {
+		err := os.Rename(backupPath(configPath, number), backupPath(configPath, number+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	// A hard link keeps the old contents once the new file is renamed over
+	// the config, fall back to copying on filesystems without links
+	if err := os.Link(configPath, backupPath(configPath, 1)); err == nil {
+		return nil
+	}
+	return copyFile(configPath, backupPath(configPath, 1))
+}
+
+func copyFile(from string, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	// Backups hold the same secrets as the config, like the web token
+	out, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// ListBackups returns the existing backups of a config file, most recent first
+func ListBackups(configPath string) []Backup {
+	var backups []Backup
+	for number := 1; ; number++ {
+		path := backupPath(configPath, number)
+		info, err := os.Stat(path)
+		if err != nil {
+			break
+		}
+		backups = append(backups, Backup{Number: number, Path: path, ModTime: info.ModTime()})
+	}
+	return backups
+}
+
+// RestoreBackup replaces the config file by one of its backups. The current
+// config is rotated into the backups first, so a restore can be reverted.
+func RestoreBackup(configPath string, number int, count int) error {
+	content, err := os.ReadFile(backupPath(configPath, number))
+	if err != nil {
+		return fmt.Errorf("backup %d not found: %w", number, err)
+	}
+
+	if err := rotateBackups(configPath, count); err != nil {
+		log.Warn().Err(err).Msg("Failed to rotate configuration backups")
+	}
+
+	if err := writeFileDurable(configPath, content); err != nil {
+		return fmt.Errorf("failed to restore configuration file: %w", err)
+	}
+
+	log.Info().Int("backup", number).Str("path", configPath).Msg("Restored configuration backup")
+	return nil
+}
@@ -0,0 +1,284 @@
+package configuration
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// decodeConfig loads a configuration file's content like Load, without
+// touching the disk
+func decodeConfig(t *testing.T, content string) Config {
+	t.Helper()
+	result := LoadResult{Content: []byte(content)}
+	if err := result.decode(); err != nil {
+		t.Fatal(err)
+	}
+	return result.Config
+}
+
+func TestDefaultButtons(t *testing.T) {
+	buttons := GetDefaultConfig().Controls.Buttons
+	for group := 1; group <= 8; group++ {
+		for _, kind := range []string{"Solo", "Mute", "Record"} {
+			id := fmt.Sprintf("%s%d", strings.ToLower(kind), group)
+			button, ok := buttons[id]
+			if !ok {
+				t.Errorf("no default button %s", id)
+				continue
+			}
+			if want := fmt.Sprintf("Group%d/%s", group, kind); button.Path != want {
+				t.Errorf("%s has path %s, want %s", id, button.Path, want)
+			}
+		}
+	}
+	for _, id := range []string{"trackPrev", "trackNext", "cycle", "markerSet", "markerPrev", "markerNext", "rewind", "fastForward", "stop", "play", "rec"} {
+		if _, ok := buttons[id]; !ok {
+			t.Errorf("no default transport button %s", id)
+		}
+	}
+	mute := buttons["mute3"]
+	target, ok := mute.Actions[0].Target.(*ControlTarget)
+	if mute.Actions[0].Type != ToggleMute || !ok || *target != (ControlTarget{ControlType: "slider", ControlID: "slider3"}) {
+		t.Errorf("mute3 runs %+v, want ToggleMute of slider3", mute.Actions)
+	}
+}
+
+func TestButtonActionsRoundTrip(t *testing.T) {
+	content := `
+mode: toggle
+path: Group1/Solo
+state: true
+actions:
+  - type: SetVolume
+    target: {type: PlaybackStream, name: Firefox, binaryName: firefox}
+  - type: ToggleMute
+    target: {controlType: knob, controlId: knob2}
+  - type: ToggleMute
+    target: {type: InputDevice, name: Blue Yeti}
+  - type: SetDefaultOutput
+    target: {name: Headphones}
+  - type: MediaPlayPause
+`
+	check := func(button ButtonConfig) {
+		t.Helper()
+		if button.Mode != Toggle || button.State == nil || !*button.State || len(button.Actions) != 5 {
+			t.Fatalf("decoded %+v", button)
+		}
+		if target, ok := button.Actions[0].Target.(*TypedTarget); !ok || *target != (TypedTarget{Type: PlaybackStream, Name: "Firefox", BinaryName: "firefox"}) {
+			t.Errorf("SetVolume target is %#v", button.Actions[0].Target)
+		}
+		if target, ok := button.Actions[1].Target.(*ControlTarget); !ok || *target != (ControlTarget{ControlType: "knob", ControlID: "knob2"}) {
+			t.Errorf("ToggleMute of a control has target %#v", button.Actions[1].Target)
+		}
+		if target, ok := button.Actions[2].Target.(*TypedTarget); !ok || *target != (TypedTarget{Type: InputDevice, Name: "Blue Yeti"}) {
+			t.Errorf("ToggleMute of a source has target %#v", button.Actions[2].Target)
+		}
+		if target, ok := button.Actions[3].Target.(*Target); !ok || target.Name != "Headphones" {
+			t.Errorf("SetDefaultOutput target is %#v", button.Actions[3].Target)
+		}
+		if button.Actions[4].Target != nil {
+			t.Errorf("MediaPlayPause has target %#v", button.Actions[4].Target)
+		}
+	}
+
+	var button ButtonConfig
+	if err := yaml.Unmarshal([]byte(content), &button); err != nil {
+		t.Fatal(err)
+	}
+	check(button)
+
+	data, err := yaml.Marshal(button)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded ButtonConfig
+	if err := yaml.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	check(decoded)
+}
+
+func TestEnsureDefaultsKeepsCustomButtons(t *testing.T) {
+	config := decodeConfig(t, `
+version: 3
+activeProfile: default
+profiles:
+  default:
+    controls:
+      buttons:
+        mute1:
+          path: Group1/Mute
+          label: Mic
+          actions:
+            - type: ToggleMicMute
+`)
+	buttons := config.Controls.Buttons
+	mute := buttons["mute1"]
+	if mute.Label != "Mic" || len(mute.Actions) != 1 || mute.Actions[0].Type != ToggleMicMute {
+		t.Errorf("customized mute1 became %+v", mute)
+	}
+	if mute.Mode != Momentary {
+		t.Errorf("mode of mute1 is %q, want momentary", mute.Mode)
+	}
+	if len(buttons) != len(defaultButtons()) {
+		t.Errorf("got %d buttons, want the %d defaults", len(buttons), len(defaultButtons()))
+	}
+	if buttons["mute2"].Actions[0].Type != ToggleMute {
+		t.Errorf("default mute2 not backfilled: %+v", buttons["mute2"])
+	}
+}
+
+func TestLegacyButtonRules(t *testing.T) {
+	config := decodeConfig(t, `
+midiDevices:
+  - name: nanoKONTROL2
+    type: KorgNanoKontrol2
+    midiInName: nanoKONTROL2 MIDI 1
+    midiOutName: nanoKONTROL2 MIDI 1
+rules:
+  - midiMessage: {deviceName: nanoKONTROL2, deviceControlPath: Group1/Slider, type: ControlChange}
+    actions:
+      - type: SetVolume
+        target: {type: PlaybackStream, name: Firefox}
+  - midiMessage: {deviceName: nanoKONTROL2, deviceControlPath: Group1/Mute, type: Note}
+    actions:
+      - type: ToggleMute
+        target: {type: PlaybackStream, name: Firefox}
+  - midiMessage: {deviceName: nanoKONTROL2, deviceControlPath: Transport/Cycle, type: Note}
+    actions:
+      - type: SetDefaultOutput
+        target: {name: Headphones}
+`)
+	buttons := config.Controls.Buttons
+	mute := buttons["mute1"]
+	if len(mute.Actions) != 1 {
+		t.Fatalf("mute1 runs %+v", mute.Actions)
+	}
+	// The muted source is on slider1, so the button mutes the slider
+	if target, ok := mute.Actions[0].Target.(*ControlTarget); !ok || *target != (ControlTarget{ControlType: "slider", ControlID: "slider1"}) {
+		t.Errorf("mute1 mutes %#v, want slider1", mute.Actions[0].Target)
+	}
+	cycle := buttons["cycle"]
+	if len(cycle.Actions) != 1 || cycle.Actions[0].Type != SetDefaultOutput {
+		t.Fatalf("cycle runs %+v", cycle.Actions)
+	}
+	if target, ok := cycle.Actions[0].Target.(*Target); !ok || target.Name != "Headphones" {
+		t.Errorf("cycle sets %#v as default output", cycle.Actions[0].Target)
+	}
+}
+
+func TestButtonStateAndActions(t *testing.T) {
+	cm := newTestManager(t)
+	states := make(chan map[string]interface{}, 4)
+	cm.Subscribe("button.state.updated", func(data interface{}) {
+		states <- data.(map[string]interface{})
+	})
+	assigned := make(chan map[string]interface{}, 4)
+	cm.Subscribe("button.action.assigned", func(data interface{}) {
+		assigned <- data.(map[string]interface{})
+	})
+
+	cm.UpdateButtonState("rec", true)
+	state := <-states
+	if state["id"] != "rec" || state["state"] != true {
+		t.Errorf("state notification %+v", state)
+	}
+	// Storing the same state again notifies nobody
+	cm.UpdateButtonState("rec", true)
+	if button := cm.GetConfigSnapshot().Controls.Buttons["rec"]; button.State == nil || !*button.State {
+		t.Errorf("rec has state %v", button.State)
+	}
+
+	action := Action{Type: SetDefaultOutput, Target: &Target{Name: "Speakers"}}
+	if err := cm.AssignButtonAction("rec", action); err != nil {
+		t.Fatal(err)
+	}
+	if data := <-assigned; data["id"] != "rec" {
+		t.Errorf("assignment notification %+v", data)
+	}
+	if actions := cm.GetConfigSnapshot().Controls.Buttons["rec"].Actions; len(actions) != 1 || actions[0].Type != SetDefaultOutput {
+		t.Errorf("rec runs %+v", actions)
+	}
+	if err := cm.AssignButtonAction("nothing", action); err == nil {
+		t.Error("action assigned to an unknown button")
+	}
+
+	select {
+	case state := <-states:
+		t.Errorf("unchanged state notified: %+v", state)
+	default:
+	}
+}
+
+func TestMuteTargets(t *testing.T) {
+	config := decodeConfig(t, `
+version: 3
+activeProfile: default
+profiles:
+  default:
+    controls:
+      buttons:
+        mute1:
+          path: Group1/Mute
+          actions:
+            - type: ToggleMute
+              target: {controlType: knob, controlId: knob1}
+            - type: Mute
+              target: {type: RecordStream, name: Discord}
+            - type: ToggleMute
+              target: {name: slider2}
+`)
+	actions := config.Controls.Buttons["mute1"].Actions
+	if len(actions) != 3 {
+		t.Fatalf("mute1 runs %+v", actions)
+	}
+	if target, ok := actions[2].Target.(*Target); !ok || target.Name != "slider2" {
+		t.Errorf("ToggleMute by name has target %#v", actions[2].Target)
+	}
+
+	// The plain name keeps its form when saved
+	data, err := yaml.Marshal(actions[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "type: ToggleMute\ntarget:\n    name: slider2\n"; string(data) != want {
+		t.Errorf("marshalled as\n%s\nwant\n%s", data, want)
+	}
+
+	for i, want := range []*ControlTarget{
+		{ControlType: "knob", ControlID: "knob1"},
+		nil,
+		{ControlType: "slider", ControlID: "slider2"},
+	} {
+		control, ok := actions[i].MuteControl(&config)
+		if ok != (want != nil) || (ok && *control != *want) {
+			t.Errorf("action %d mutes control %+v, want %+v", i, control, want)
+		}
+	}
+	if _, ok := (Action{Type: SetDefaultOutput, Target: &Target{Name: "slider2"}}).MuteControl(&config); ok {
+		t.Error("SetDefaultOutput mutes a control")
+	}
+	if issues := Validate(&config, nil); len(issues) > 0 {
+		t.Errorf("valid mute targets refused: %v", issues)
+	}
+
+	// A source needs a name, a plain name a slider or knob
+	for _, test := range []struct {
+		target  interface{}
+		message string
+	}{
+		{&TypedTarget{Type: PlaybackStream}, "requires the name of the source"},
+		{&Target{Name: "slider99"}, `no slider or knob "slider99" to mute`},
+	} {
+		button := config.Controls.Buttons["mute1"]
+		button.Actions = []Action{{Type: ToggleMute, Target: test.target}}
+		config.Controls.Buttons["mute1"] = button
+		issues := Validate(&config, nil)
+		if len(issues) != 1 || !strings.HasSuffix(issues[0].Path, ".target.name") || !strings.Contains(issues[0].Message, test.message) {
+			t.Errorf("ToggleMute of %+v has issues %v, want %q", test.target, issues, test.message)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package configuration
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// naturalLess compares strings with runs of digits compared by their value,
+// so that slider2 comes before slider10
+func naturalLess(a string, b string) bool {
+	for a != "" && b != "" {
+		aDigits := isDigit(a[0])
+		bDigits := isDigit(b[0])
+		if aDigits != bDigits {
+			return aDigits
+		}
+		aRun, aRest := splitRun(a, aDigits)
+		bRun, bRest := splitRun(b, bDigits)
+		if aRun != bRun {
+			if aDigits {
+				aNumber := strings.TrimLeft(aRun, "0")
+				bNumber := strings.TrimLeft(bRun, "0")
+				if len(aNumber) != len(bNumber) {
+					return len(aNumber) < len(bNumber)
+				}
+				if aNumber != bNumber {
+					return aNumber < bNumber
+				}
+			}
+			return aRun < bRun
+		}
+		a, b = aRest, bRest
+	}
+	return len(a) < len(b)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// splitRun splits off the leading run of digits or non-digits
+func splitRun(s string, digits bool) (string, string) {
+	end := 0
+	for end < len(s) && isDigit(s[end]) == digits {
+		end++
+	}
+	return s[:end], s[end:]
+}
+
+// controlOrder sorts the ids of a control type: the numbered ids of the
+// controller, like slider1 to slider8, come first, then the others in natural
+// order. An empty prefix sorts all ids in natural order.
+func controlOrder[T any](values map[string]T, prefix string) []string {
+	numbered := func(id string) (int, bool) {
+		if prefix == "" || !strings.HasPrefix(id, prefix) {
+			return 0, false
+		}
+		number, err := strconv.Atoi(id[len(prefix):])
+		return number, err == nil && number > 0
+	}
+	ids := sortedKeys(values)
+	sort.SliceStable(ids, func(i, j int) bool {
+		iNumber, iNumbered := numbered(ids[i])
+		jNumber, jNumbered := numbered(ids[j])
+		if iNumbered != jNumbered {
+			return iNumbered
+		}
+		if iNumbered && iNumber != jNumber {
+			return iNumber < jNumber
+		}
+		return naturalLess(ids[i], ids[j])
+	})
+	return ids
+}
+
+// orderedMapping encodes a map with its keys in control order
+func orderedMapping[T any](values map[string]T, prefix string) (*yaml.Node, error) {
+	mapping := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for _, id := range controlOrder(values, prefix) {
+		key := &yaml.Node{}
+		if err := key.Encode(id); err != nil {
+			return nil, err
+		}
+		value := &yaml.Node{}
+		if err := value.Encode(values[id]); err != nil {
+			return nil, err
+		}
+		mapping.Content = append(mapping.Content, key, value)
+	}
+	return mapping, nil
+}
+
+// appendMapping adds a map under a key of a mapping node, leaving out empty maps like omitempty
+func appendMapping[T any](mapping *yaml.Node, key string, values map[string]T, prefix string) error {
+	if len(values) == 0 {
+		return nil
+	}
+	value, err := orderedMapping(values, prefix)
+	if err != nil {
+		return err
+	}
+	mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+	return nil
+}
+
+// MarshalYAML writes the controls in a stable order, slider1 to slider8 and
+// knob1 to knob8 before any others, so that saving the same controls always
+// gives the same file
+func (controls Controls) MarshalYAML() (interface{}, error) {
+	mapping := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	if err := appendMapping(mapping, "sliders", controls.Sliders, "slider"); err != nil {
+		return nil, err
+	}
+	if err := appendMapping(mapping, "knobs", controls.Knobs, "knob"); err != nil {
+		return nil, err
+	}
+	if err := appendMapping(mapping, "buttons", controls.Buttons, ""); err != nil {
+		return nil, err
+	}
+	return mapping, nil
+}
+
+// MarshalYAML writes the control values of a scene in the order of the controls
+func (scene SceneConfig) MarshalYAML() (interface{}, error) {
+	mapping := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	if err := appendMapping(mapping, "sliders", scene.Sliders, "slider"); err != nil {
+		return nil, err
+	}
+	if err := appendMapping(mapping, "knobs", scene.Knobs, "knob"); err != nil {
+		return nil, err
+	}
+	if len(scene.Volumes) > 0 {
+		volumes := &yaml.Node{}
+		if err := volumes.Encode(scene.Volumes); err != nil {
+			return nil, err
+		}
+		mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "volumes"}, volumes)
+	}
+	return mapping, nil
+}
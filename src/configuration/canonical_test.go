@@ -0,0 +1,105 @@
+package configuration
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"testing"
+
+	"github.com/0h41/pulsekontrol/src/activity"
+)
+
+func TestNaturalLess(t *testing.T) {
+	// Equal numbers are ordered by their digits
+	want := []string{"01", "1", "2", "10", "a", "a2", "a10", "a10b", "aux", "slider2", "slider10", "slider10a"}
+	ids := slices.Clone(want)
+	slices.Reverse(ids)
+	sort.SliceStable(ids, func(i, j int) bool { return naturalLess(ids[i], ids[j]) })
+	if !slices.Equal(ids, want) {
+		t.Errorf("sorted as %q, want %q", ids, want)
+	}
+}
+
+func TestControlOrder(t *testing.T) {
+	ids := map[string]int{"slider10": 0, "slider2": 0, "aux": 0, "slider1": 0, "slider0": 0, "slider02": 0, "Master": 0}
+	want := []string{"slider1", "slider02", "slider2", "slider10", "Master", "aux", "slider0"}
+	if order := controlOrder(ids, "slider"); !slices.Equal(order, want) {
+		t.Errorf("ordered as %q, want %q", order, want)
+	}
+	// Without a prefix only the natural order counts
+	want = []string{"Master", "aux", "slider0", "slider1", "slider02", "slider2", "slider10"}
+	if order := controlOrder(ids, ""); !slices.Equal(order, want) {
+		t.Errorf("ordered without prefix as %q, want %q", order, want)
+	}
+}
+
+// canonicalInput is a configuration written by hand, with its ids in no
+// particular order and extra controls on a second device
+const canonicalInput = "testdata/canonical/input.yaml"
+
+func TestCanonicalFormat(t *testing.T) {
+	content, err := os.ReadFile(canonicalInput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := decodeConfig(t, string(content))
+	data, err := Marshal(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Maps iterate in a different order every time
+	for i := 0; i < 20; i++ {
+		again, err := Marshal(&config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(again) != string(data) {
+			t.Fatalf("marshalled differently:\n%s\nthen\n%s", data, again)
+		}
+	}
+
+	golden := filepath.Join("testdata", "canonical", "controls.golden")
+	if *update {
+		if err := os.WriteFile(golden, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(want) {
+		t.Errorf("marshalled\n%s\nwant the content of %s", data, golden)
+	}
+}
+
+func TestNoOpSessionKeepsFile(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "canonical", "controls.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	writes := &writeCounter{t: t, path: path}
+	writes.count()
+
+	// A fader moved and back, and a source assigned and unassigned
+	cm := NewConfigManager(decodeConfig(t, string(content)), path)
+	cm.UpdateControlValue(activity.Midi(), "slider", "slider2", 80)
+	cm.UpdateControlValue(activity.Midi(), "slider", "slider2", 20)
+	discord := Source{Type: PlaybackStream, Name: "Discord"}
+	cm.AssignSource("knob", "knob1", discord)
+	cm.UnassignSource("knob", "knob1", discord)
+	if err := cm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if writes.count() != 0 {
+		t.Error("unchanged configuration written")
+	}
+	if saved, _ := os.ReadFile(path); string(saved) != string(content) {
+		t.Errorf("file became\n%s", saved)
+	}
+}
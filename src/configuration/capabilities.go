@@ -0,0 +1,166 @@
+package configuration
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ControlBinding is the MIDI message a control of a device sends
+type ControlBinding struct {
+	Type   MidiMessageType `yaml:"type,omitempty"` // ControlChange or, for buttons, Note; defaults to ControlChange
+	Number uint8           `yaml:"number"`         // Controller or note number
+}
+
+// MessageType returns the type of message the control sends
+func (binding ControlBinding) MessageType() MidiMessageType {
+	if binding.Type == None {
+		return ControlChange
+	}
+	return binding.Type
+}
+
+// ControlMap lists the control paths a device has, by control type
+type ControlMap struct {
+	Sliders map[string]ControlBinding `yaml:"sliders,omitempty"`
+	Knobs   map[string]ControlBinding `yaml:"knobs,omitempty"`
+	Buttons map[string]ControlBinding `yaml:"buttons,omitempty"`
+}
+
+// Binding returns the message of a control path, controlType is slider, knob or button
+func (controlMap ControlMap) Binding(controlType string, path string) (ControlBinding, bool) {
+	var bindings map[string]ControlBinding
+	switch controlType {
+	case "slider":
+		bindings = controlMap.Sliders
+	case "knob":
+		bindings = controlMap.Knobs
+	case "button":
+		bindings = controlMap.Buttons
+	}
+	binding, ok := bindings[path]
+	return binding, ok
+}
+
+// Paths returns the sorted control paths of a control type
+func (controlMap ControlMap) Paths(controlType string) []string {
+	var paths []string
+	switch controlType {
+	case "slider":
+		paths = sortedKeys(controlMap.Sliders)
+	case "knob":
+		paths = sortedKeys(controlMap.Knobs)
+	case "button":
+		paths = sortedKeys(controlMap.Buttons)
+	}
+	return paths
+}
+
+func (controlMap ControlMap) clone() ControlMap {
+	return ControlMap{
+		Sliders: maps.Clone(controlMap.Sliders),
+		Knobs:   maps.Clone(controlMap.Knobs),
+		Buttons: maps.Clone(controlMap.Buttons),
+	}
+}
+
+// deviceControlMaps holds the control maps device modules registered for their type
+var deviceControlMaps = make(map[MidiDeviceType]ControlMap)
+
+// RegisterControlMap sets the controls of a device type. Device modules call
+// it from init, so the map must not be changed afterwards.
+func RegisterControlMap(deviceType MidiDeviceType, controlMap ControlMap) {
+	deviceControlMaps[deviceType] = controlMap
+}
+
+// deviceSignatures holds the port name patterns device modules registered for their type
+var deviceSignatures = make(map[MidiDeviceType]*regexp.Regexp)
+
+// RegisterSignature sets the pattern the MIDI port names of a device type
+// match, used to find a controller whose configured ports are missing.
+// Device modules call it from init.
+func RegisterSignature(deviceType MidiDeviceType, portPattern *regexp.Regexp) {
+	deviceSignatures[deviceType] = portPattern
+}
+
+// Signature is the port name pattern of a device type
+type Signature struct {
+	Type        MidiDeviceType
+	PortPattern *regexp.Regexp
+}
+
+// Signatures returns the registered signatures sorted by device type
+func Signatures() []Signature {
+	signatures := make([]Signature, 0, len(deviceSignatures))
+	for deviceType, pattern := range deviceSignatures {
+		signatures = append(signatures, Signature{Type: deviceType, PortPattern: pattern})
+	}
+	sort.Slice(signatures, func(i, j int) bool { return signatures[i].Type < signatures[j].Type })
+	return signatures
+}
+
+// Capabilities returns the controls of the device: the configured control map
+// for Generic devices, otherwise the one registered for its type. It reports
+// false if the controls of the device are unknown.
+func (device DeviceConfig) Capabilities() (ControlMap, bool) {
+	deviceType := device.Type
+	if deviceType == "" {
+		deviceType = KorgNanoKontrol2
+	}
+	if deviceType == Generic {
+		return device.ControlMap, true
+	}
+	controlMap, ok := deviceControlMaps[deviceType]
+	return controlMap, ok
+}
+
+// missingPathHint completes the error for a control path a device doesn't have
+func (device DeviceConfig) missingPathHint(controlType string) string {
+	if device.Type == Generic {
+		return fmt.Sprintf(", add it to controlMap.%ss of the device", controlType)
+	}
+	controlMap, _ := device.Capabilities()
+	paths := controlMap.Paths(controlType)
+	if len(paths) == 0 || len(paths) > 8 {
+		return ""
+	}
+	return ", expected " + strings.Join(paths, ", ")
+}
+
+// CheckControlPath returns an error if the device of a slider or knob has no
+// control with its path, so that it could never be moved
+func (config *Config) CheckControlPath(controlType string, controlId string) error {
+	var deviceName, path string
+	switch controlType {
+	case "slider":
+		slider, ok := config.Controls.Sliders[controlId]
+		if !ok {
+			return fmt.Errorf("slider %s does not exist", controlId)
+		}
+		deviceName, path = slider.Device, slider.Path
+	case "knob":
+		knob, ok := config.Controls.Knobs[controlId]
+		if !ok {
+			return fmt.Errorf("knob %s does not exist", controlId)
+		}
+		deviceName, path = knob.Device, knob.Path
+	default:
+		return fmt.Errorf("unknown control type %s", controlType)
+	}
+
+	deviceName = config.ControlDevice(deviceName)
+	device, ok := config.DeviceByName(deviceName)
+	if !ok {
+		return fmt.Errorf("device %s of %s does not exist", deviceName, controlId)
+	}
+	controlMap, known := device.Capabilities()
+	if !known {
+		return nil
+	}
+	if _, ok := controlMap.Binding(controlType, path); !ok {
+		return fmt.Errorf("device %s has no %s %s, %s can't be moved", deviceName, controlType, path, controlId)
+	}
+	return nil
+}
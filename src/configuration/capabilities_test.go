@@ -0,0 +1,134 @@
+package configuration
+
+import (
+	"strings"
+	"testing"
+)
+
+// capabilityConfig has a nanoKONTROL2, a Generic device with one fader and a
+// device of a type without registered controls
+func capabilityConfig(sliders map[string]SliderConfig) Config {
+	return Config{
+		Devices: []DeviceConfig{
+			{Name: "nano", Type: KorgNanoKontrol2},
+			{Name: "mixer", Type: Generic, ControlMap: ControlMap{
+				Sliders: map[string]ControlBinding{"Fader1": {Number: 7}},
+			}},
+			{Name: "other", Type: "Unknown"},
+		},
+		Controls: Controls{Sliders: sliders},
+	}
+}
+
+func TestControlPathsAreValidated(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		slider SliderConfig
+		err    string // Part of the error on the path, empty if valid
+	}{
+		{name: "nanoKONTROL2", slider: SliderConfig{Path: "Group8/Slider"}},
+		{name: "nanoKONTROL2 without the group", slider: SliderConfig{Path: "Group12/Slider"}, err: `device "nano" has no slider "Group12/Slider"`},
+		{name: "knob path on a slider", slider: SliderConfig{Path: "Group1/Knob"}, err: "has no slider"},
+		{name: "generic", slider: SliderConfig{Device: "mixer", Path: "Fader1"}},
+		{name: "generic without the control", slider: SliderConfig{Device: "mixer", Path: "Fader2"}, err: "add it to controlMap.sliders of the device"},
+		{name: "unknown controls", slider: SliderConfig{Device: "other", Path: "Anything"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			config := capabilityConfig(map[string]SliderConfig{"slider": test.slider})
+			var errors []string
+			for _, issue := range Validate(&config, nil) {
+				if issue.Path == "controls.sliders.slider.path" {
+					errors = append(errors, issue.Message)
+				}
+			}
+			switch {
+			case test.err == "" && len(errors) > 0:
+				t.Errorf("valid path refused: %v", errors)
+			case test.err != "" && (len(errors) != 1 || !strings.Contains(errors[0], test.err)):
+				t.Errorf("got errors %q, want one containing %q", errors, test.err)
+			}
+
+			// The same check is made when assigning from the web interface
+			err := config.CheckControlPath("slider", "slider")
+			if (err != nil) != (test.err != "") {
+				t.Errorf("CheckControlPath returned %v", err)
+			}
+		})
+	}
+}
+
+func TestPathHintListsFewControls(t *testing.T) {
+	nano := DeviceConfig{Name: "nano", Type: KorgNanoKontrol2}
+	// Eight sliders are listed, the 35 buttons aren't
+	if hint := nano.missingPathHint("slider"); !strings.Contains(hint, "Group1/Slider") || !strings.Contains(hint, "Group8/Slider") {
+		t.Errorf("slider hint is %q", hint)
+	}
+	if hint := nano.missingPathHint("button"); hint != "" {
+		t.Errorf("button hint is %q", hint)
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	for _, test := range []struct {
+		device      DeviceConfig
+		controlType string
+		path        string
+		number      uint8
+		ok          bool
+	}{
+		{DeviceConfig{Type: KorgNanoKontrol2}, "slider", "Group3/Slider", 2, true},
+		{DeviceConfig{}, "knob", "Group1/Knob", 16, true}, // The default type
+		{DeviceConfig{Type: KorgNanoKontrol2}, "button", "Transport/Play", 41, true},
+		{DeviceConfig{Type: KorgNanoKontrol2}, "button", "Group9/Mute", 0, false},
+		{DeviceConfig{Type: Generic, ControlMap: ControlMap{Knobs: map[string]ControlBinding{"Pan": {Number: 10}}}}, "knob", "Pan", 10, true},
+		// Generic devices don't fall back to any registered map
+		{DeviceConfig{Type: Generic}, "slider", "Group1/Slider", 0, false},
+	} {
+		controlMap, known := test.device.Capabilities()
+		if !known {
+			t.Errorf("controls of %s unknown", test.device.Type)
+			continue
+		}
+		binding, ok := controlMap.Binding(test.controlType, test.path)
+		if ok != test.ok || binding.Number != test.number {
+			t.Errorf("%s %s of %q has binding %+v %v, want number %d %v", test.controlType, test.path, test.device.Type, binding, ok, test.number, test.ok)
+		}
+	}
+	if _, known := (DeviceConfig{Type: "Unknown"}).Capabilities(); known {
+		t.Error("controls of an unregistered type known")
+	}
+}
+
+func TestDefaultControlsFitTheDevice(t *testing.T) {
+	config := decodeConfig(t, `version: 3
+devices:
+  - name: mixer
+    type: Generic
+    controlMap:
+      sliders:
+        Fader1: {number: 7}
+        Group2/Slider: {number: 8}
+controls:
+  sliders:
+    slider1: {path: Fader1}
+`)
+	sliders := config.Controls.Sliders
+	if _, ok := sliders["slider2"]; !ok {
+		t.Error("default slider the device has not added")
+	}
+	if _, ok := sliders["slider3"]; ok || len(config.Controls.Knobs) != 0 || len(config.Controls.Buttons) != 0 {
+		t.Error("default controls the device doesn't have added")
+	}
+
+	// What is saved loads again
+	data, err := Marshal(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeConfig(t, string(data))
+
+	// A nanoKONTROL2 gets all of them
+	if controls := decodeConfig(t, "version: 3\n").Controls; len(controls.Sliders) != 8 || len(controls.Knobs) != 8 {
+		t.Errorf("nanoKONTROL2 got %d sliders and %d knobs", len(controls.Sliders), len(controls.Knobs))
+	}
+}
@@ -1,21 +1,101 @@
 package configuration
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/samber/lo"
 	"gopkg.in/yaml.v3"
 )
 
+// DefaultHistorySize is the number of recent actions kept when not configured
+const DefaultHistorySize = 200
+
+// DefaultWebUIAddress is the address the web interface listens on when not configured
+const DefaultWebUIAddress = "127.0.0.1:6080"
+
+// DefaultOSCAddress is the address the OSC listener binds when not configured.
+// Control surfaces run on other devices, so it listens on all interfaces.
+const DefaultOSCAddress = "0.0.0.0:9000"
+
+// DefaultStepSize is how much a slider or knob moves per step when not configured
+const DefaultStepSize = 5
+
+// MaxStepSize is the largest allowed step size
+const MaxStepSize = 50
+
+// DefaultMaxVolume is the volume in percent at the top of a slider or knob
+// when not configured
+const DefaultMaxVolume = 100
+
+// MaxVolumeLimit is the highest allowed maxVolume, boosting further mostly
+// clips
+const MaxVolumeLimit = 200
+
+// DefaultFloorDb is the volume in dB below the top at the bottom of a
+// control with the db scale when not configured
+const DefaultFloorDb = -60
+
+// MinFloorDb is the lowest allowed floorDb, below it is inaudible anyway
+const MinFloorDb = -120
+
+// DefaultStaleAfterDays is after how many days an unseen source counts as stale when not configured
+const DefaultStaleAfterDays = 90
+
+// DefaultPollInterval is how often the web interface polls audio sources when not configured
+const DefaultPollInterval = 2 * time.Second
+
+// userConfigDir returns the pulsekontrol directory below $XDG_CONFIG_HOME,
+// falling back to ~/.config
+func userConfigDir() string {
+	if configHome := os.Getenv("XDG_CONFIG_HOME"); filepath.IsAbs(configHome) {
+		return filepath.Join(configHome, "pulsekontrol")
+	}
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, ".config", "pulsekontrol")
+}
+
+// systemConfigDirs returns the pulsekontrol directories below $XDG_CONFIG_DIRS,
+// falling back to /etc/xdg
+func systemConfigDirs() []string {
+	configDirs := os.Getenv("XDG_CONFIG_DIRS")
+	if configDirs == "" {
+		configDirs = "/etc/xdg"
+	}
+	var dirs []string
+	for _, dir := range filepath.SplitList(configDirs) {
+		// Relative paths are invalid according to the spec and ignored
+		if filepath.IsAbs(dir) {
+			dirs = append(dirs, filepath.Join(dir, "pulsekontrol"))
+		}
+	}
+	return dirs
+}
+
+// DefaultChannel is the MIDI channel the nanoKONTROL2 sends on by default
+const DefaultChannel uint8 = 15
+
+// defaultDevice returns the settings of a KORG nanoKONTROL2
+func defaultDevice() DeviceConfig {
+	return DeviceConfig{
+		Name:    "KORG nanoKONTROL2",
+		Type:    KorgNanoKontrol2,
+		InPort:  "nanoKONTROL2 nanoKONTROL2 _ CTR",
+		OutPort: "nanoKONTROL2 nanoKONTROL2 _ CTR",
+		Channel: lo.ToPtr(DefaultChannel),
+	}
+}
+
 // Default KORG nanoKONTROL2 configuration
 func GetDefaultConfig() Config {
 	return Config{
-		Device: DeviceConfig{
-			Name:    "KORG nanoKONTROL2",
-			InPort:  "nanoKONTROL2 nanoKONTROL2 _ CTR",
-			OutPort: "nanoKONTROL2 nanoKONTROL2 _ CTR",
-		},
+		Devices: []DeviceConfig{defaultDevice()},
 		Controls: Controls{
 			Sliders: map[string]SliderConfig{
 				"slider1": {
@@ -101,54 +181,295 @@ func GetDefaultConfig() Config {
 					Sources: []Source{},
 				},
 			},
+			Buttons: defaultButtons(),
+		},
+		WebUI: WebUIConfig{
+			Enabled:      lo.ToPtr(true),
+			Address:      DefaultWebUIAddress,
+			PollInterval: DefaultPollInterval,
+			Advertise:    lo.ToPtr(true),
+		},
+		History: HistoryConfig{
+			Size: lo.ToPtr(DefaultHistorySize),
+		},
+		Backups: BackupConfig{
+			Count: lo.ToPtr(DefaultBackupCount),
 		},
 	}
 }
 
-func Load() (Config, string, error) {
-	var configPath string
-	var content []byte
-	var config Config
+// defaultButtons returns the nanoKONTROL2 buttons. Record and Solo assign the
+// focused window's playback streams to the group's slider and knob, Mute
+// toggles muting of the slider's sources, Play toggles media playback.
+func defaultButtons() map[string]ButtonConfig {
+	buttons := make(map[string]ButtonConfig)
+	for group := 1; group <= 8; group++ {
+		buttons[fmt.Sprintf("solo%d", group)] = ButtonConfig{
+			Path: fmt.Sprintf("Group%d/Solo", group),
+			Mode: Momentary,
+			Actions: []Action{
+				{
+					Type:   AssignFocusedWindowPlaybackStreams,
+					Target: &ControlTarget{ControlType: "knob", ControlID: fmt.Sprintf("knob%d", group)},
+				},
+			},
+		}
+		buttons[fmt.Sprintf("mute%d", group)] = ButtonConfig{
+			Path: fmt.Sprintf("Group%d/Mute", group),
+			Mode: Momentary,
+			Actions: []Action{
+				{
+					Type:   ToggleMute,
+					Target: &ControlTarget{ControlType: "slider", ControlID: fmt.Sprintf("slider%d", group)},
+				},
+			},
+		}
+		buttons[fmt.Sprintf("record%d", group)] = ButtonConfig{
+			Path: fmt.Sprintf("Group%d/Record", group),
+			Mode: Momentary,
+			Actions: []Action{
+				{
+					Type:   AssignFocusedWindowPlaybackStreams,
+					Target: &ControlTarget{ControlType: "slider", ControlID: fmt.Sprintf("slider%d", group)},
+				},
+			},
+		}
+	}
 
-	// Read configuration file
-	homeDir, _ := os.UserHomeDir()
-	paths := [...]string{
-		"./config.yaml",
-		fmt.Sprintf("%s/.config/pulsekontrol/config.yaml", homeDir),
+	transport := map[string]string{
+		"trackPrev":   "Transport/Track/Prev",
+		"trackNext":   "Transport/Track/Next",
+		"cycle":       "Transport/Cycle",
+		"markerSet":   "Transport/Marker/Set",
+		"markerPrev":  "Transport/Marker/Prev",
+		"markerNext":  "Transport/Marker/Next",
+		"rewind":      "Transport/Rewind",
+		"fastForward": "Transport/FastForward",
+		"stop":        "Transport/Stop",
+		"play":        "Transport/Play",
+		"rec":         "Transport/Rec",
+	}
+	for id, path := range transport {
+		buttons[id] = ButtonConfig{
+			Path:    path,
+			Mode:    Momentary,
+			Actions: []Action{},
+		}
 	}
 
-	// Ensure the config directory exists regardless of whether a config file exists
-	configDir := fmt.Sprintf("%s/.config/pulsekontrol", homeDir)
-	if err := os.MkdirAll(configDir, 0755); err != nil {
-		return config, "", fmt.Errorf("could not create config directory: %w", err)
+	play := buttons["play"]
+	play.Actions = []Action{{Type: MediaPlayPause}}
+	buttons["play"] = play
+
+	return buttons
+}
+
+// pathOverride is the configuration file given on the command line, see SetPath
+var pathOverride string
+
+// SetPath makes configurations loaded afterwards be read from and saved to
+// path instead of the searched locations
+func SetPath(path string) {
+	pathOverride = path
+}
+
+// locateConfig finds the configuration file and reads it. configPath is where
+// the configuration is saved, sourcePath where it was read from. content is
+// nil if no configuration file exists yet.
+func locateConfig() (configPath string, sourcePath string, content []byte, err error) {
+	if pathOverride != "" {
+		content, err = os.ReadFile(pathOverride)
+		if errors.Is(err, fs.ErrNotExist) {
+			return pathOverride, "", nil, nil
+		}
+		if err != nil {
+			return "", "", nil, err
+		}
+		return pathOverride, pathOverride, content, nil
+	}
+
+	// Search order: ./config.yaml for development, the XDG user config
+	// directory, then the XDG system config directories
+	userDir := userConfigDir()
+	userPath := filepath.Join(userDir, "config.yaml")
+	paths := []string{"./config.yaml", userPath}
+	for _, dir := range systemConfigDirs() {
+		paths = append(paths, filepath.Join(dir, "config.yaml"))
 	}
 
-	// Default path for creating a new config (the home directory path)
-	configPath = paths[1]
+	// Default path for creating a new config (the user directory path)
+	configPath = userPath
 
 	// Try to read from config paths
-	for _, path := range paths {
-		if content != nil {
-			break
-		}
+	for i, path := range paths {
 		fileContent, err := os.ReadFile(path)
-		if err == nil {
+		if err != nil {
+			continue
+		}
+		content = fileContent
+		sourcePath = path
+		if i < 2 {
 			configPath = path
-			content = fileContent
+		} else {
+			// System configs are read-only, runtime changes are saved for the user
+			log.Info().Str("path", path).Msgf("Using system configuration, changes will be saved to %s", userPath)
 		}
+		break
 	}
+	return configPath, sourcePath, content, nil
+}
 
-	// If no config found, create a default one
+// Path returns the path the configuration is saved to, without loading it
+func Path() (string, error) {
+	configPath, _, _, err := locateConfig()
+	return configPath, err
+}
+
+// LoadResult describes how the configuration was loaded
+type LoadResult struct {
+	Config      Config
+	Path        string   // Where the configuration is saved
+	SourcePath  string   // Where the configuration was read from, empty if none exists yet
+	Content     []byte   // File contents as read from SourcePath
+	FromVersion int      // Schema version of the file before migrations
+	Migrations  []string // Descriptions of the migrations applied
+	Host        string   // Name of the override merged over the file, empty if none
+}
+
+// Inspect loads the configuration like Load, applying migrations and
+// defaults, but without writing anything to disk
+func Inspect() (LoadResult, error) {
+	var result LoadResult
+
+	configPath, sourcePath, content, err := locateConfig()
+	if err != nil {
+		return result, err
+	}
+	result.Path = configPath
+	result.SourcePath = sourcePath
+	result.Content = content
+
+	// If no config found, use the defaults
 	if content == nil {
-		config = GetDefaultConfig()
+		result.Config = GetDefaultConfig()
+		result.FromVersion = CurrentVersion
+		ensureDefaults(&result.Config)
+		return result, nil
+	}
+
+	return result, result.decode()
+}
+
+// decode parses result.Content, applying migrations, defaults and variable
+// expansion, and fills in Config, FromVersion and Migrations
+func (result *LoadResult) decode() error {
+	content := result.Content
+
+	// Bring older configuration files up to the current schema version
+	doc := document{}
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		result.Config = GetDefaultConfig()
+		return fmt.Errorf("error parsing config: %w", err)
+	}
+	if doc == nil {
+		doc = document{}
+	}
+	version, err := documentVersion(doc)
+	if err != nil {
+		result.Config = GetDefaultConfig()
+		return err
+	}
+	result.FromVersion = version
+	doc, result.Migrations, err = migrateDocument(doc, version)
+	if err != nil {
+		result.Config = GetDefaultConfig()
+		return err
+	}
+	doc, layer, err := applyOverride(doc)
+	if err != nil {
+		result.Config = GetDefaultConfig()
+		return err
+	}
+
+	config := &result.Config
+	var root *yaml.Node
+	if len(result.Migrations) > 0 || layer != nil {
+		if err := remarshal(doc, config); err != nil {
+			*config = GetDefaultConfig()
+			return fmt.Errorf("error parsing migrated config: %w", err)
+		}
+	} else {
+		// Unmigrated files are validated against the parsed node for line numbers
+		root = &yaml.Node{}
+		if err := yaml.Unmarshal(content, root); err != nil {
+			return fmt.Errorf("error parsing config: %w", err)
+		}
+		if err := root.Decode(config); err != nil {
+			return fmt.Errorf("error parsing config: %w", err)
+		}
+	}
+	config.override = layer
+	if layer != nil {
+		result.Host = layer.host
+	}
+	if err := checkConfig(config, root); err != nil {
+		return err
+	}
+
+	// Set defaults for any missing fields
+	ensureDefaults(config)
+
+	// Expand environment variables once the device list is in its final shape
+	if err := expandConfig(config); err != nil {
+		return fmt.Errorf("error expanding config: %w", err)
+	}
+	if err := validateExpanded(config); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Prepare checks a configuration built in code instead of read from a file
+// and fills in defaults and variables like Load
+func Prepare(config Config) (Config, error) {
+	config = config.Clone()
+	if config.Version == 0 {
+		config.Version = CurrentVersion
+	}
+	if err := checkConfig(&config, nil); err != nil {
+		return config, err
+	}
+	ensureDefaults(&config)
+	if err := expandConfig(&config); err != nil {
+		return config, fmt.Errorf("error expanding config: %w", err)
+	}
+	if err := validateExpanded(&config); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+func Load() (Config, string, error) {
+	// Ensure the config directory exists regardless of whether a config file exists
+	if err := os.MkdirAll(userConfigDir(), 0755); err != nil {
+		return Config{}, "", fmt.Errorf("could not create config directory: %w", err)
+	}
 
-		// Marshal and save the default config
-		data, err := yaml.Marshal(config)
+	result, err := Inspect()
+	if err != nil {
+		return result.Config, result.Path, err
+	}
+	config := result.Config
+	configPath := result.Path
+
+	// If no config found, create a default one
+	if result.SourcePath == "" {
+		data, err := Marshal(&config)
 		if err != nil {
 			return config, "", fmt.Errorf("failed to marshal default config: %w", err)
 		}
 
-		if err := os.WriteFile(configPath, data, 0644); err != nil {
+		if err := writeFileDurable(configPath, data); err != nil {
 			return config, "", fmt.Errorf("failed to write default config: %w", err)
 		}
 
@@ -156,158 +477,395 @@ func Load() (Config, string, error) {
 		return config, configPath, nil
 	}
 
-	// First try parsing as new format
-	err := yaml.Unmarshal(content, &config)
-	if err == nil && config.Device.Name != "" {
-		// Looks like the new format
-		ensureDefaults(&config)
-		return config, configPath, nil
-	}
-
-	// If that fails, try as legacy format
-	var legacyConfig LegacyConfig
-	if err := yaml.Unmarshal(content, &legacyConfig); err != nil {
-		return GetDefaultConfig(), configPath, fmt.Errorf("error parsing config: %w", err)
-	}
+	if len(result.Migrations) > 0 {
+		log.Info().Int("from", result.FromVersion).Int("to", CurrentVersion).Strs("migrations", result.Migrations).Msg("Migrated configuration")
 
-	// Convert legacy format to new format
-	config = convertLegacyConfig(legacyConfig)
+		// The file is provisioned elsewhere, only migrate it in memory
+		if !*config.Persistence.Enabled {
+			return config, configPath, nil
+		}
 
-	// Set defaults for any missing fields
-	ensureDefaults(&config)
+		// Keep the original file, then save in the current format
+		if configPath == result.SourcePath {
+			backupPath, err := backupConfig(configPath, result.Content, result.FromVersion)
+			if err != nil {
+				return config, configPath, fmt.Errorf("failed to back up config before migration: %w", err)
+			}
+			log.Info().Str("path", backupPath).Msg("Backed up configuration before migration")
+		}
+		data, err := Marshal(&config)
+		if err != nil {
+			return config, configPath, fmt.Errorf("failed to marshal migrated config: %w", err)
+		}
+		if err := writeFileDurable(configPath, data); err != nil {
+			return config, configPath, fmt.Errorf("failed to write migrated config: %w", err)
+		}
+	}
 
-	// Save in new format
-	data, err := yaml.Marshal(config)
-	if err == nil {
-		// Create backup of old format
-		backupPath := configPath + ".legacy"
-		os.Rename(configPath, backupPath)
+	return config, configPath, nil
+}
 
-		// Write new format
-		os.WriteFile(configPath, data, 0644)
+// Marshal returns the canonical YAML of a configuration as it is saved to disk
+func Marshal(config *Config) ([]byte, error) {
+	saved, err := savedConfig(config)
+	if err != nil {
+		return nil, err
 	}
+	return yaml.Marshal(saved)
+}
 
-	return config, configPath, nil
+// checkConfig validates a loaded configuration, logging warnings and
+// returning a ValidationError if there are hard errors
+func checkConfig(config *Config, root *yaml.Node) error {
+	issues := Validate(config, root)
+	var errors []ValidationIssue
+	for _, issue := range issues {
+		if issue.Warning {
+			log.Warn().Msgf("Configuration %s", issue)
+		} else {
+			errors = append(errors, issue)
+		}
+	}
+	if len(errors) > 0 {
+		return &ValidationError{Issues: errors}
+	}
+	return nil
 }
 
-// Convert legacy config format to new format
+// Convert legacy config format to new format. Rules that cannot be expressed
+// in the new format are listed in a warning.
 func convertLegacyConfig(legacyConfig LegacyConfig) Config {
 	config := GetDefaultConfig()
+	var unconverted []string
 
 	// Process only the first MIDI device (as we're limiting to nanoKONTROL2)
 	if len(legacyConfig.MidiDevices) > 0 {
 		device := legacyConfig.MidiDevices[0]
 		if device.Type == KorgNanoKontrol2 {
-			config.Device.Name = device.Name
-			config.Device.InPort = device.MidiInName
-			config.Device.OutPort = device.MidiOutName
+			config.Devices[0].Name = device.Name
+			config.Devices[0].InPort = device.MidiInName
+			config.Devices[0].OutPort = device.MidiOutName
+		} else {
+			unconverted = append(unconverted, fmt.Sprintf("device %s of type %s", device.Name, device.Type))
+		}
+		for _, device := range legacyConfig.MidiDevices[1:] {
+			unconverted = append(unconverted, fmt.Sprintf("device %s, only one device is converted", device.Name))
 		}
 	}
 
-	// Extract control assignments from the rules
+	// Sliders and knobs first, mute buttons refer to their sources
+	controlMap, _ := defaultDevice().Capabilities()
+	hasPath := func(controlType string, path string) bool {
+		_, ok := controlMap.Binding(controlType, path)
+		return ok
+	}
+	var buttonRules []Rule
 	for _, rule := range legacyConfig.Rules {
-		if rule.MidiMessage.DeviceControlPath != "" {
-			// Extract path parts (e.g., "Group1/Slider" -> "Group1", "Slider")
-			parts := strings.Split(rule.MidiMessage.DeviceControlPath, "/")
-			if len(parts) != 2 {
-				continue
-			}
+		controlPath := rule.MidiMessage.DeviceControlPath
+		switch {
+		case hasPath("slider", controlPath) || hasPath("knob", controlPath):
+			unconverted = append(unconverted, convertLegacyControl(&config, rule)...)
+		case hasPath("button", controlPath):
+			buttonRules = append(buttonRules, rule)
+		case rule.MidiMessage.Type == Note && controlPath != "" && len(rule.Actions) > 0:
+			// Note-triggered rules are buttons, even on paths unknown to the nanoKONTROL2
+			buttonRules = append(buttonRules, rule)
+		default:
+			unconverted = append(unconverted, fmt.Sprintf("rule for %q (%s)", controlPath, rule.MidiMessage.Type))
+		}
+	}
+	for _, rule := range buttonRules {
+		unconverted = append(unconverted, convertLegacyButton(&config, rule)...)
+	}
 
-			// Skip non-standard controls for now
-			if !strings.HasPrefix(parts[0], "Group") {
-				continue
-			}
+	if len(unconverted) > 0 {
+		log.Warn().Strs("unconverted", unconverted).Msg("Parts of the legacy configuration could not be converted")
+	}
+	log.Info().Int("rules", len(legacyConfig.Rules)).Int("unconverted", len(unconverted)).Msg("Converted legacy configuration")
 
-			// Parse the group number
-			var groupNum int
-			_, err := fmt.Sscanf(parts[0], "Group%d", &groupNum)
-			if err != nil || groupNum < 1 || groupNum > 8 {
-				continue
-			}
+	return config
+}
+
+// convertLegacyControl lifts the SetVolume actions of a slider or knob rule
+// into the control's sources and returns what could not be converted
+func convertLegacyControl(config *Config, rule Rule) []string {
+	controlPath := rule.MidiMessage.DeviceControlPath
+	var groupNum int
+	var controlType string
+	fmt.Sscanf(controlPath, "Group%d/", &groupNum)
+	_, controlType, _ = strings.Cut(controlPath, "/")
+	controlId := fmt.Sprintf("%s%d", strings.ToLower(controlType), groupNum)
+
+	var sources []Source
+	var unconverted []string
+	for _, action := range rule.Actions {
+		typedTarget, ok := action.Target.(*TypedTarget)
+		if action.Type != SetVolume || !ok {
+			unconverted = append(unconverted, fmt.Sprintf("%s action on %s, sliders and knobs only set volumes", action.Type, controlPath))
+			continue
+		}
+		sources = append(sources, Source{
+			Type:       typedTarget.Type,
+			Name:       typedTarget.Name,
+			BinaryName: typedTarget.BinaryName,
+		})
+	}
 
-			controlPath := rule.MidiMessage.DeviceControlPath
-			controlType := parts[1]
-			controlId := fmt.Sprintf("%s%d", strings.ToLower(controlType), groupNum)
-
-			// Handle different control types
-			switch controlType {
-			case "Slider":
-				slider := config.Controls.Sliders[controlId]
-				slider.Path = controlPath
-
-				// Add sources from actions
-				for _, action := range rule.Actions {
-					if action.Type == SetVolume && action.Target != nil {
-						if typedTarget, ok := action.Target.(*TypedTarget); ok {
-							source := Source{
-								Type: typedTarget.Type,
-								Name: typedTarget.Name,
-							}
-							slider.Sources = append(slider.Sources, source)
-						}
-					}
-				}
-
-				config.Controls.Sliders[controlId] = slider
-
-			case "Knob":
-				knob := config.Controls.Knobs[controlId]
-				knob.Path = controlPath
-
-				// Add sources from actions
-				for _, action := range rule.Actions {
-					if action.Type == SetVolume && action.Target != nil {
-						if typedTarget, ok := action.Target.(*TypedTarget); ok {
-							source := Source{
-								Type: typedTarget.Type,
-								Name: typedTarget.Name,
-							}
-							knob.Sources = append(knob.Sources, source)
-						}
-					}
-				}
-
-				config.Controls.Knobs[controlId] = knob
+	switch controlType {
+	case "Slider":
+		slider := config.Controls.Sliders[controlId]
+		slider.Path = controlPath
+		slider.Sources = append(slider.Sources, sources...)
+		slider.MinValue = rule.MidiMessage.MinValue
+		slider.MaxValue = rule.MidiMessage.MaxValue
+		config.Controls.Sliders[controlId] = slider
+	case "Knob":
+		knob := config.Controls.Knobs[controlId]
+		knob.Path = controlPath
+		knob.Sources = append(knob.Sources, sources...)
+		knob.MinValue = rule.MidiMessage.MinValue
+		knob.MaxValue = rule.MidiMessage.MaxValue
+		config.Controls.Knobs[controlId] = knob
+	}
+	return unconverted
+}
+
+// convertLegacyButton turns a button rule into a button and returns what
+// could not be converted
+func convertLegacyButton(config *Config, rule Rule) []string {
+	controlPath := rule.MidiMessage.DeviceControlPath
+	var actions []Action
+	var unconverted []string
+	for _, action := range rule.Actions {
+		switch action.Type {
+		case SetDefaultOutput, SetDefaultInput, MediaPlayPause, RecallScene, AssignFocusedWindowPlaybackStreams, StepControl, MoveStreamToSink, CycleDefaultOutput, SetCardProfile, ToggleLoopback, LoadLoopback, UnloadLoopback, ToggleEchoCancel, ToggleMicMute, ToggleSuspendOutput, SuspendOutput, ResumeOutput, VolumeUp, VolumeDown:
+			actions = append(actions, action)
+		case ToggleMute:
+			// Legacy mute rules target a source, mute the control it is assigned to
+			if target, ok := legacyMuteTarget(config, action); ok {
+				actions = append(actions, Action{Type: ToggleMute, Target: target})
+			} else if target, ok := legacySourceTarget(action); ok {
+				// Sources that no control has are muted directly
+				actions = append(actions, Action{Type: ToggleMute, Target: target})
+			} else {
+				unconverted = append(unconverted, fmt.Sprintf("ToggleMute action on %s, it has no control or source as target", controlPath))
 			}
+		default:
+			unconverted = append(unconverted, fmt.Sprintf("%s action on %s", action.Type, controlPath))
 		}
 	}
+	if len(actions) == 0 {
+		return unconverted
+	}
 
-	return config
+	id := buttonIDForPath(config.Controls.Buttons, controlPath)
+	button := config.Controls.Buttons[id]
+	button.Path = controlPath
+	button.Mode = Momentary
+	button.Actions = actions
+	config.Controls.Buttons[id] = button
+	return unconverted
+}
+
+// legacyMuteTarget resolves the target of a legacy ToggleMute action to a control
+func legacyMuteTarget(config *Config, action Action) (*ControlTarget, bool) {
+	if target, ok := action.Target.(*ControlTarget); ok && target.ControlType != "" && target.ControlID != "" {
+		return target, true
+	}
+	var typedTarget TypedTarget
+	if action.RawTarget.Kind == 0 || action.RawTarget.Decode(&typedTarget) != nil || typedTarget.Name == "" {
+		return nil, false
+	}
+	matches := func(source Source) bool {
+		return source.Type == typedTarget.Type && source.Name == typedTarget.Name
+	}
+	for _, id := range sortedKeys(config.Controls.Sliders) {
+		if slices.ContainsFunc(config.Controls.Sliders[id].Sources, matches) {
+			return &ControlTarget{ControlType: "slider", ControlID: id}, true
+		}
+	}
+	for _, id := range sortedKeys(config.Controls.Knobs) {
+		if slices.ContainsFunc(config.Controls.Knobs[id].Sources, matches) {
+			return &ControlTarget{ControlType: "knob", ControlID: id}, true
+		}
+	}
+	return nil, false
+}
+
+// legacySourceTarget returns the source a legacy ToggleMute action targets
+func legacySourceTarget(action Action) (*TypedTarget, bool) {
+	if target, ok := action.Target.(*TypedTarget); ok && target.Name != "" {
+		return target, true
+	}
+	var typedTarget TypedTarget
+	if action.RawTarget.Kind == 0 || action.RawTarget.Decode(&typedTarget) != nil || typedTarget.Type == "" || typedTarget.Name == "" {
+		return nil, false
+	}
+	return &typedTarget, true
+}
+
+// buttonIDForPath returns the id of the button with the given control path,
+// deriving a new id from the path if no such button exists yet
+func buttonIDForPath(buttons map[string]ButtonConfig, path string) string {
+	for id, button := range buttons {
+		if button.Path == path {
+			return id
+		}
+	}
+	return strings.ToLower(strings.ReplaceAll(path, "/", ""))
 }
 
 // Set default values for any missing parts of the config
 func ensureDefaults(config *Config) {
+	// Migrate the single device format into the device list
+	if config.Device.Name != "" || config.Device.InPort != "" || config.Device.OutPort != "" {
+		// The validator warns if both formats are present, devices wins
+		if len(config.Devices) == 0 {
+			config.Devices = []DeviceConfig{config.Device}
+		}
+		config.Device = DeviceConfig{}
+	}
+	if len(config.Devices) == 0 {
+		config.Devices = []DeviceConfig{defaultDevice()}
+	}
+
 	// Ensure device settings
-	if config.Device.Name == "" {
-		config.Device.Name = "KORG nanoKONTROL2"
+	defaults := defaultDevice()
+	for i := range config.Devices {
+		device := &config.Devices[i]
+		if device.Type == "" {
+			device.Type = defaults.Type
+		}
+		if device.Name == "" {
+			device.Name = defaults.Name
+		}
+		if device.InPort == "" {
+			device.InPort = defaults.InPort
+		}
+		if device.OutPort == "" {
+			device.OutPort = defaults.OutPort
+		}
+		if device.Channel == nil {
+			device.Channel = lo.ToPtr(DefaultChannel)
+		}
+	}
+
+	// Saving always writes the current schema version
+	config.Version = CurrentVersion
+
+	// Ensure web UI settings
+	if config.WebUI.Enabled == nil {
+		config.WebUI.Enabled = lo.ToPtr(true)
+	}
+	if config.WebUI.Address == "" {
+		config.WebUI.Address = DefaultWebUIAddress
 	}
-	if config.Device.InPort == "" {
-		config.Device.InPort = "nanoKONTROL2 nanoKONTROL2 _ CTR"
+	if config.WebUI.PollInterval <= 0 {
+		config.WebUI.PollInterval = DefaultPollInterval
 	}
-	if config.Device.OutPort == "" {
-		config.Device.OutPort = "nanoKONTROL2 nanoKONTROL2 _ CTR"
+	if config.WebUI.Advertise == nil {
+		config.WebUI.Advertise = lo.ToPtr(true)
 	}
 
+	// Startup keeps pushing the stored values unless configured otherwise
+	if config.StartupSync == "" {
+		config.StartupSync = StartupSyncPush
+	}
+
+	// Ensure action history settings, keeping an explicit zero to disable it
+	if config.History.Size == nil {
+		config.History.Size = lo.ToPtr(DefaultHistorySize)
+	}
+
+	// Changes are saved unless persistence is turned off
+	if config.Persistence.Enabled == nil {
+		config.Persistence.Enabled = lo.ToPtr(true)
+	}
+
+	// Ensure backup settings, keeping an explicit zero to disable them
+	if config.Backups.Count == nil {
+		config.Backups.Count = lo.ToPtr(DefaultBackupCount)
+	}
+	if config.StaleSources.AfterDays == nil {
+		config.StaleSources.AfterDays = lo.ToPtr(DefaultStaleAfterDays)
+	}
+
+	// Single-profile configs are loaded as the default profile
+	normalizeProfiles(config)
+	for name, profile := range config.Profiles {
+		ensureControlDefaults(&profile.Controls, config.Devices)
+		config.Profiles[name] = profile
+	}
+	config.Controls = config.Profiles[config.ActiveProfile].Controls
+}
+
+// ensureControlDefaults backfills missing controls of a profile. The default
+// controls are those of a nanoKONTROL2 on the first device, so only the ones
+// that device has are added.
+func ensureControlDefaults(controls *Controls, devices []DeviceConfig) {
 	// Initialize maps if they're nil
-	if config.Controls.Sliders == nil {
-		config.Controls.Sliders = make(map[string]SliderConfig)
+	if controls.Sliders == nil {
+		controls.Sliders = make(map[string]SliderConfig)
 	}
-	if config.Controls.Knobs == nil {
-		config.Controls.Knobs = make(map[string]KnobConfig)
+	if controls.Knobs == nil {
+		controls.Knobs = make(map[string]KnobConfig)
+	}
+	if controls.Buttons == nil {
+		controls.Buttons = make(map[string]ButtonConfig)
+	}
+
+	// Devices with unknown controls get all of them
+	hasPath := func(controlType string, path string) bool {
+		if len(devices) == 0 {
+			return true
+		}
+		controlMap, known := devices[0].Capabilities()
+		if !known {
+			return true
+		}
+		_, ok := controlMap.Binding(controlType, path)
+		return ok
 	}
 
 	// Add default sliders if missing
 	defaultConfig := GetDefaultConfig()
 	for id, slider := range defaultConfig.Controls.Sliders {
-		if _, exists := config.Controls.Sliders[id]; !exists {
-			config.Controls.Sliders[id] = slider
+		if _, exists := controls.Sliders[id]; !exists && hasPath("slider", slider.Path) {
+			controls.Sliders[id] = slider
 		}
 	}
 
 	// Add default knobs if missing
 	for id, knob := range defaultConfig.Controls.Knobs {
-		if _, exists := config.Controls.Knobs[id]; !exists {
-			config.Controls.Knobs[id] = knob
+		if _, exists := controls.Knobs[id]; !exists && hasPath("knob", knob.Path) {
+			controls.Knobs[id] = knob
+		}
+	}
+
+	// Backfill step sizes of configs written before they existed
+	for id, slider := range controls.Sliders {
+		if slider.StepSize == 0 {
+			slider.StepSize = DefaultStepSize
+			controls.Sliders[id] = slider
+		}
+	}
+	for id, knob := range controls.Knobs {
+		if knob.StepSize == 0 {
+			knob.StepSize = DefaultStepSize
+			controls.Knobs[id] = knob
+		}
+	}
+
+	// Add default buttons if missing, leaving customized ones untouched
+	for id, button := range defaultConfig.Controls.Buttons {
+		if _, exists := controls.Buttons[id]; !exists && hasPath("button", button.Path) {
+			controls.Buttons[id] = button
+		}
+	}
+	for id, button := range controls.Buttons {
+		if button.Mode == "" {
+			button.Mode = Momentary
+			controls.Buttons[id] = button
 		}
 	}
 }
@@ -0,0 +1,285 @@
+package configuration
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+)
+
+// legacyConfig is a configuration of the original rules format using every
+// kind of rule it supported
+const legacyConfig = `
+midiDevices:
+  - name: nanoKONTROL2
+    type: KorgNanoKontrol2
+    midiInName: nanoKONTROL2 MIDI 1
+    midiOutName: nanoKONTROL2 MIDI 1
+rules:
+  - midiMessage: {deviceName: nanoKONTROL2, deviceControlPath: Group1/Slider, type: ControlChange}
+    actions:
+      - type: SetVolume
+        target: {type: PlaybackStream, name: Firefox, binaryName: firefox}
+  - midiMessage: {deviceName: nanoKONTROL2, deviceControlPath: Group2/Slider, type: ControlChange, minValue: 10, maxValue: 110}
+    actions:
+      - type: SetVolume
+        target: {type: PlaybackStream, name: Spotify}
+      - type: SetVolume
+        target: {type: PlaybackStream, name: mpv}
+  - midiMessage: {deviceName: nanoKONTROL2, deviceControlPath: Group8/Slider, type: ControlChange}
+    actions:
+      - type: SetVolume
+        target: {type: OutputDevice, name: Speakers}
+  - midiMessage: {deviceName: nanoKONTROL2, deviceControlPath: Group1/Knob, type: ControlChange, minValue: 5, maxValue: 120}
+    actions:
+      - type: SetVolume
+        target: {type: InputDevice, name: Blue Yeti}
+  - midiMessage: {deviceName: nanoKONTROL2, deviceControlPath: Group1/Mute, type: Note}
+    actions:
+      - type: ToggleMute
+        target: {type: PlaybackStream, name: Firefox}
+  - midiMessage: {deviceName: nanoKONTROL2, deviceControlPath: Group3/Mute, type: Note}
+    actions:
+      - type: ToggleMute
+        target: {type: RecordStream, name: Discord}
+  - midiMessage: {deviceName: nanoKONTROL2, deviceControlPath: Group1/Solo, type: Note}
+    actions:
+      - type: SetDefaultOutput
+        target: {name: Headphones}
+  - midiMessage: {deviceName: nanoKONTROL2, deviceControlPath: Group2/Solo, type: Note}
+    actions:
+      - type: SetDefaultInput
+        target: {name: Blue Yeti}
+  - midiMessage: {deviceName: nanoKONTROL2, deviceControlPath: Transport/Play, type: Note}
+    actions:
+      - type: MediaPlayPause
+  - midiMessage: {deviceName: nanoKONTROL2, deviceControlPath: Transport/Cycle, type: Note}
+    actions:
+      - type: SetDefaultOutput
+        target: {name: Speakers}
+`
+
+func TestLegacyConversionIsLossless(t *testing.T) {
+	config := decodeConfig(t, legacyConfig)
+	controls := config.Controls
+
+	for _, test := range []struct {
+		controlType string
+		id          string
+		sources     []Source
+		minValue    uint8
+		maxValue    uint8
+	}{
+		{"slider", "slider1", []Source{{Type: PlaybackStream, Name: "Firefox", BinaryName: "firefox"}}, 0, 0},
+		{"slider", "slider2", []Source{{Type: PlaybackStream, Name: "Spotify"}, {Type: PlaybackStream, Name: "mpv"}}, 10, 110},
+		{"slider", "slider8", []Source{{Type: OutputDevice, Name: "Speakers"}}, 0, 0},
+		{"knob", "knob1", []Source{{Type: InputDevice, Name: "Blue Yeti"}}, 5, 120},
+	} {
+		var sources []Source
+		var minValue, maxValue uint8
+		if test.controlType == "slider" {
+			slider := controls.Sliders[test.id]
+			sources, minValue, maxValue = slider.Sources, slider.MinValue, slider.MaxValue
+		} else {
+			knob := controls.Knobs[test.id]
+			sources, minValue, maxValue = knob.Sources, knob.MinValue, knob.MaxValue
+		}
+		if !slices.Equal(sources, test.sources) {
+			t.Errorf("%s has sources %+v, want %+v", test.id, sources, test.sources)
+		}
+		if minValue != test.minValue || maxValue != test.maxValue {
+			t.Errorf("%s has MIDI range %d-%d, want %d-%d", test.id, minValue, maxValue, test.minValue, test.maxValue)
+		}
+	}
+
+	// Actions are compared in their YAML form
+	for id, want := range map[string]string{
+		"mute1": "- type: ToggleMute\n  target:\n    controlType: slider\n    controlId: slider1\n",
+		"mute3": "- type: ToggleMute\n  target:\n    type: RecordStream\n    name: Discord\n",
+		"solo1": "- type: SetDefaultOutput\n  target:\n    name: Headphones\n",
+		"solo2": "- type: SetDefaultInput\n  target:\n    name: Blue Yeti\n",
+		"play":  "- type: MediaPlayPause\n",
+		"cycle": "- type: SetDefaultOutput\n  target:\n    name: Speakers\n",
+	} {
+		button, ok := controls.Buttons[id]
+		if !ok {
+			t.Errorf("no button %s", id)
+			continue
+		}
+		data, err := yaml.Marshal(button.Actions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("%s runs\n%s\nwant\n%s", id, data, want)
+		}
+	}
+
+	if device := config.Devices[0]; device.Name != "nanoKONTROL2" || device.InPort != "nanoKONTROL2 MIDI 1" || device.OutPort != "nanoKONTROL2 MIDI 1" {
+		t.Errorf("device is %+v", device)
+	}
+}
+
+func TestUnconvertedRulesAreListed(t *testing.T) {
+	var output bytes.Buffer
+	logger := log
+	log = zerolog.New(&output)
+	t.Cleanup(func() { log = logger })
+
+	var legacy LegacyConfig
+	if err := yaml.Unmarshal([]byte(legacyConfig+`
+  - midiMessage: {deviceName: nanoKONTROL2, deviceControlPath: Group3/Slider, type: ControlChange}
+    actions:
+      - type: ToggleMute
+        target: {type: PlaybackStream, name: Firefox}
+  - midiMessage: {deviceName: nanoKONTROL2, deviceControlPath: Pedal, type: ControlChange}
+    actions:
+      - type: SetVolume
+        target: {type: PlaybackStream, name: Firefox}
+  - midiMessage: {deviceName: nanoKONTROL2, deviceControlPath: Transport/Stop, type: Note}
+    actions:
+      - type: Unknown
+`), &legacy); err != nil {
+		t.Fatal(err)
+	}
+	legacy.MidiDevices = append(legacy.MidiDevices, MidiDevice{Name: "second", Type: KorgNanoKontrol2})
+	convertLegacyConfig(legacy)
+
+	var unconverted []string
+	for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		var entry struct {
+			Level       string          `json:"level"`
+			Unconverted json.RawMessage `json:"unconverted"`
+		}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+		// The summary at info level has the count
+		if entry.Level == "warn" {
+			if err := json.Unmarshal(entry.Unconverted, &unconverted); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	want := []string{
+		"device second, only one device is converted",
+		"ToggleMute action on Group3/Slider, sliders and knobs only set volumes",
+		`rule for "Pedal" (ControlChange)`,
+		"Unknown action on Transport/Stop",
+	}
+	if !slices.Equal(unconverted, want) {
+		t.Errorf("listed as unconverted:\n%s\nwant\n%s", strings.Join(unconverted, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestOSCConfigIsValidated(t *testing.T) {
+	var config Config
+	if err := yaml.Unmarshal([]byte(`
+version: 3
+activeProfile: default
+profiles:
+  default:
+    controls: {}
+  tablet:
+    controls:
+      sliders:
+        master: {path: Group8/Slider}
+osc:
+  enabled: true
+  address: 0.0.0.0:8000
+  addresses:
+    slider1: /1/fader1
+    master: /1/master
+    play: /transport/play
+`), &config); err != nil {
+		t.Fatal(err)
+	}
+	// Default controls and those of any profile have addresses
+	if issues := Validate(&config, nil); len(issues) > 0 {
+		t.Errorf("valid OSC settings have issues %v", issues)
+	}
+	if address := config.OSC.ListenAddress(); address != "0.0.0.0:8000" {
+		t.Errorf("listening on %s", address)
+	}
+	if address := (OSCConfig{}).ListenAddress(); address != DefaultOSCAddress {
+		t.Errorf("listening on %s by default", address)
+	}
+
+	for _, test := range []struct {
+		osc     OSCConfig
+		path    string
+		warning bool
+	}{
+		{OSCConfig{Address: "9000"}, "osc.address", false},
+		{OSCConfig{Address: "0.0.0.0:90000"}, "osc.address", false},
+		{OSCConfig{Addresses: map[string]string{"slider1": "fader1"}}, "osc.addresses.slider1", false},
+		{OSCConfig{Addresses: map[string]string{"slider1": "/fader", "slider2": "/fader"}}, "osc.addresses.slider2", false},
+		{OSCConfig{Addresses: map[string]string{"slider99": "/fader"}}, "osc.addresses.slider99", true},
+	} {
+		config.OSC = test.osc
+		issues := Validate(&config, nil)
+		if len(issues) != 1 || issues[0].Path != test.path || issues[0].Warning != test.warning {
+			t.Errorf("%+v has issues %v, want one on %s", test.osc, issues, test.path)
+		}
+	}
+}
+
+func TestStreamDeckConfigIsValidated(t *testing.T) {
+	var config Config
+	if err := yaml.Unmarshal([]byte(`
+version: 3
+activeProfile: default
+profiles:
+  default:
+    controls: {}
+  tablet:
+    controls:
+      sliders:
+        master: {path: Group8/Slider}
+streamdeck:
+  enabled: true
+  brightness: 80
+  keys:
+    0:
+      actions:
+        - type: ToggleMute
+          target: {name: slider1}
+    1:
+      label: All
+      actions:
+        - type: ToggleMute
+          target: {name: master}
+`), &config); err != nil {
+		t.Fatal(err)
+	}
+	// Keys mute the default controls and those of any profile
+	if issues := Validate(&config, nil); len(issues) > 0 {
+		t.Errorf("valid Stream Deck settings have issues %v", issues)
+	}
+	if key := config.StreamDeck.Keys[1]; key.Label != "All" || len(key.Actions) != 1 {
+		t.Errorf("key 1 decoded as %+v", key)
+	}
+
+	brightness := 101
+	mute := Action{Type: ToggleMute, Target: &Target{Name: "slider99"}}
+	for _, test := range []struct {
+		streamDeck StreamDeckConfig
+		path       string
+	}{
+		{StreamDeckConfig{Brightness: &brightness}, "streamdeck.brightness"},
+		{StreamDeckConfig{RetryInterval: -time.Second}, "streamdeck.retryInterval"},
+		{StreamDeckConfig{Keys: map[int]StreamDeckKey{-1: {}}}, "streamdeck.keys.-1"},
+		{StreamDeckConfig{Keys: map[int]StreamDeckKey{3: {Actions: []Action{mute}}}}, "streamdeck.keys.3.actions.0.target.name"},
+	} {
+		config.StreamDeck = test.streamDeck
+		issues := Validate(&config, nil)
+		if len(issues) != 1 || issues[0].Path != test.path || issues[0].Warning {
+			t.Errorf("%+v has issues %v, want an error on %s", test.streamDeck, issues, test.path)
+		}
+	}
+}
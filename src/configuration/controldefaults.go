@@ -0,0 +1,76 @@
+package configuration
+
+import (
+	"regexp"
+	"strings"
+)
+
+// DefaultControlPath is the path pattern of created controls, giving
+// Group1/Slider for slider1
+const DefaultControlPath = "Group{n}/{Type}"
+
+// controlPlaceholder matches the placeholders of control patterns
+var controlPlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// controlPlaceholders are the placeholders known in control patterns
+var controlPlaceholders = map[string]bool{"{id}": true, "{n}": true, "{type}": true, "{Type}": true}
+
+// expandControlPattern fills in a path or label pattern for a control:
+// {id} is the control id, {n} its number, {type} and {Type} the control type
+// in lower and title case
+func expandControlPattern(pattern string, controlType string, controlId string) string {
+	return strings.NewReplacer(
+		"{id}", controlId,
+		"{n}", strings.TrimPrefix(controlId, controlType),
+		"{type}", controlType,
+		"{Type}", strings.ToUpper(controlType[:1])+controlType[1:],
+	).Replace(pattern)
+}
+
+// CreateAllowed reports whether controls without configuration are created when moved
+func (defaults ControlDefaultsConfig) CreateAllowed() bool {
+	return defaults.Create == nil || *defaults.Create
+}
+
+// InitialValue returns the value of a created control, the value it sent
+// unless a value is configured
+func (defaults ControlDefaultsConfig) InitialValue(sent int) int {
+	if defaults.Value != nil {
+		return *defaults.Value
+	}
+	return sent
+}
+
+// PathFor returns the control path of a created control
+func (defaults ControlDefaultsConfig) PathFor(controlType string, controlId string) string {
+	pattern := defaults.Path
+	if pattern == "" {
+		pattern = DefaultControlPath
+	}
+	return expandControlPattern(pattern, controlType, controlId)
+}
+
+// LabelFor returns the display name of a created control, empty without a label pattern
+func (defaults ControlDefaultsConfig) LabelFor(controlType string, controlId string) string {
+	return expandControlPattern(defaults.Label, controlType, controlId)
+}
+
+func (v *validator) validateControlDefaults(config ControlDefaultsConfig) {
+	if config.Value != nil {
+		v.validateValue("controlDefaults.value", *config.Value)
+	}
+	v.checkPlaceholders("controlDefaults.path", config.Path)
+	v.checkPlaceholders("controlDefaults.label", config.Label)
+	if config.Path != "" && !strings.Contains(config.Path, "{n}") && !strings.Contains(config.Path, "{id}") {
+		v.warnf("controlDefaults.path", "path %q has no {n} or {id}, all created controls get the same path", config.Path)
+	}
+}
+
+// checkPlaceholders warns about unknown placeholders in a control pattern
+func (v *validator) checkPlaceholders(path string, pattern string) {
+	for _, placeholder := range controlPlaceholder.FindAllString(pattern, -1) {
+		if !controlPlaceholders[placeholder] {
+			v.warnf(path, "unknown placeholder %s, use {id}, {n}, {type} or {Type}", placeholder)
+		}
+	}
+}
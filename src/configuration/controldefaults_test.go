@@ -0,0 +1,135 @@
+package configuration
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/activity"
+	"github.com/samber/lo"
+)
+
+// createdControl returns the settings of a slider or knob
+func createdControl(cm *ConfigManager, controlType string, controlId string) (string, string, int, []Source) {
+	controls := cm.GetConfigSnapshot().Controls
+	if controlType == "slider" {
+		slider := controls.Sliders[controlId]
+		return slider.Path, slider.Label, slider.Value, slider.Sources
+	}
+	knob := controls.Knobs[controlId]
+	return knob.Path, knob.Label, knob.Value, knob.Sources
+}
+
+func TestControlCreation(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		defaults    ControlDefaultsConfig
+		controlType string
+		id          string
+		path        string
+		label       string
+		value       int
+	}{
+		{name: "defaults", controlType: "slider", id: "slider9", path: "Group9/Slider", value: 70},
+		{name: "knob", controlType: "knob", id: "knob12", path: "Group12/Knob", value: 70},
+		{name: "initial value", defaults: ControlDefaultsConfig{Value: lo.ToPtr(0)}, controlType: "slider", id: "slider9", path: "Group9/Slider", value: 0},
+		{
+			name:        "templates",
+			defaults:    ControlDefaultsConfig{Path: "Bank{n}/{type}", Label: "{Type} {n} ({id})"},
+			controlType: "knob",
+			id:          "knob10",
+			path:        "Bank10/knob",
+			label:       "Knob 10 (knob10)",
+			value:       70,
+		},
+		{name: "explicitly allowed", defaults: ControlDefaultsConfig{Create: lo.ToPtr(true)}, controlType: "slider", id: "slider9", path: "Group9/Slider", value: 70},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cm := newTestManager(t)
+			cm.GetConfig().ControlDefaults = test.defaults
+			if !cm.UpdateControlValue(activity.Midi(), test.controlType, test.id, 70) {
+				t.Fatal("control not created")
+			}
+
+			path, label, value, sources := createdControl(cm, test.controlType, test.id)
+			if path != test.path || label != test.label || value != test.value || sources == nil || len(sources) != 0 {
+				t.Errorf("created with path %q, label %q, value %d and sources %v, want %q, %q and %d without sources",
+					path, label, value, sources, test.path, test.label, test.value)
+			}
+
+			// The initial value only applies on creation
+			cm.UpdateControlValue(activity.Midi(), test.controlType, test.id, 30)
+			if _, _, value, _ := createdControl(cm, test.controlType, test.id); value != 30 {
+				t.Errorf("value of the created control is %d, want 30", value)
+			}
+		})
+	}
+}
+
+func TestUnmappedControlIsReported(t *testing.T) {
+	cm, writes := newSavingManager(t)
+	cm.GetConfig().ControlDefaults.Create = lo.ToPtr(false)
+	unmapped := make(chan map[string]interface{}, 1)
+	cm.Subscribe("control.unmapped", func(data interface{}) {
+		unmapped <- data.(map[string]interface{})
+	})
+
+	if cm.UpdateControlValue(activity.Midi(), "slider", "slider9", 70) {
+		t.Error("unmapped control reported as changed")
+	}
+	select {
+	case data := <-unmapped:
+		if data["type"] != "slider" || data["id"] != "slider9" || data["value"] != 70 || data["origin"] != activity.Midi() {
+			t.Errorf("unmapped control notified with %v", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("unmapped control not notified")
+	}
+
+	config, state := cm.Snapshot()
+	if _, ok := config.Controls.Sliders["slider9"]; ok {
+		t.Error("control created although creation is disabled")
+	}
+	if state.Dirty {
+		t.Error("unmapped control marked the configuration as changed")
+	}
+	if err := cm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if writes.count() != 0 {
+		t.Error("configuration written for an unmapped control")
+	}
+
+	// Configured controls still change
+	if !cm.UpdateControlValue(activity.Midi(), "slider", "slider1", 70) {
+		t.Error("configured control ignored")
+	}
+}
+
+func TestControlDefaultsAreValidated(t *testing.T) {
+	for _, test := range []struct {
+		defaults ControlDefaultsConfig
+		path     string // Path of the expected issue, empty if valid
+		message  string
+	}{
+		{ControlDefaultsConfig{Value: lo.ToPtr(50), Path: "Bank{n}/{Type}", Label: "{type} {id}"}, "", ""},
+		{ControlDefaultsConfig{Value: lo.ToPtr(101)}, "controlDefaults.value", ""},
+		{ControlDefaultsConfig{Label: "Channel {number}"}, "controlDefaults.label", "unknown placeholder {number}"},
+		{ControlDefaultsConfig{Path: "Fader"}, "controlDefaults.path", "all created controls get the same path"},
+	} {
+		config := GetDefaultConfig()
+		config.ControlDefaults = test.defaults
+		var issues []ValidationIssue
+		for _, issue := range Validate(&config, nil) {
+			if strings.HasPrefix(issue.Path, "controlDefaults") {
+				issues = append(issues, issue)
+			}
+		}
+		switch {
+		case test.path == "" && len(issues) > 0:
+			t.Errorf("%+v refused: %v", test.defaults, issues)
+		case test.path != "" && (len(issues) != 1 || issues[0].Path != test.path || !strings.Contains(issues[0].Message, test.message)):
+			t.Errorf("%+v has issues %v, want one on %s", test.defaults, issues, test.path)
+		}
+	}
+}
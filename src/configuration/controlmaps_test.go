@@ -0,0 +1,5 @@
+package configuration_test
+
+// Device modules register their controls, legacy configurations are
+// converted with the ones of the nanoKONTROL2
+import _ "github.com/0h41/pulsekontrol/src/device/korg/nanokontrol2"
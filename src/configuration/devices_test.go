@@ -0,0 +1,130 @@
+package configuration
+
+import (
+	"strings"
+	"testing"
+)
+
+// twoDevices is a configuration of a nanoKONTROL2 and a Generic device with
+// a knob of its own
+const twoDevices = `
+version: 3
+devices:
+  - name: nano
+    type: KorgNanoKontrol2
+    inPort: nano in
+    outPort: nano out
+  - name: mixer
+    type: Generic
+    inPort: mixer in
+    outPort: mixer out
+    channel: 2
+    options: {led: "off"}
+    controlMap:
+      knobs:
+        Pan1: {number: 10}
+activeProfile: default
+profiles:
+  default:
+    controls:
+      knobs:
+        pan:
+          device: mixer
+          path: Pan1
+          value: 30
+          sources: []
+`
+
+func TestSingleDeviceMigratesToList(t *testing.T) {
+	config := decodeConfig(t, `
+device:
+  name: nano
+  type: KorgNanoKontrol2
+  inPort: nano in
+  outPort: nano out
+`)
+	if len(config.Devices) != 1 || config.Devices[0].Name != "nano" || config.Devices[0].InPort != "nano in" {
+		t.Fatalf("got devices %+v", config.Devices)
+	}
+	if config.Device.Name != "" {
+		t.Errorf("single device kept as %+v", config.Device)
+	}
+
+	data, err := Marshal(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "devices:") || strings.Contains(string(data), "\ndevice:") {
+		t.Errorf("saved in the old shape:\n%s", data)
+	}
+}
+
+func TestSingleDeviceFlagKeepsOldShape(t *testing.T) {
+	config := decodeConfig(t, `
+version: 3
+singleDevice: true
+device:
+  name: nano
+  type: KorgNanoKontrol2
+  inPort: nano in
+  outPort: nano out
+`)
+	data, err := Marshal(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "\ndevice:") || strings.Contains(string(data), "devices:") {
+		t.Fatalf("not saved in the old shape:\n%s", data)
+	}
+
+	decoded := decodeConfig(t, string(data))
+	if len(decoded.Devices) != 1 || decoded.Devices[0].Name != "nano" || !decoded.SingleDevice {
+		t.Errorf("round trip gives devices %+v", decoded.Devices)
+	}
+}
+
+func TestDevicesRoundTrip(t *testing.T) {
+	config := decodeConfig(t, twoDevices)
+	check := func(config Config) {
+		t.Helper()
+		if len(config.Devices) != 2 {
+			t.Fatalf("got devices %+v", config.Devices)
+		}
+		mixer, ok := config.DeviceByName("mixer")
+		if !ok || mixer.Type != Generic || mixer.Channel == nil || *mixer.Channel != 2 || mixer.Options["led"] != "off" {
+			t.Errorf("mixer is %+v", mixer)
+		}
+		if _, ok := mixer.ControlMap.Binding("knob", "Pan1"); !ok {
+			t.Errorf("control map of mixer lost: %+v", mixer.ControlMap)
+		}
+		// Controls without a device belong to the first one
+		if device := config.ControlDevice(config.Controls.Knobs["pan"].Device); device != "mixer" {
+			t.Errorf("pan belongs to %s, want mixer", device)
+		}
+		if device := config.ControlDevice(config.Controls.Sliders["slider1"].Device); device != "nano" {
+			t.Errorf("slider1 belongs to %s, want nano", device)
+		}
+	}
+	check(config)
+
+	data, err := Marshal(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(decodeConfig(t, string(data)))
+}
+
+func TestDeviceDefaults(t *testing.T) {
+	config := decodeConfig(t, `
+version: 3
+devices:
+  - name: nano
+`)
+	device := config.Devices[0]
+	if device.Type != KorgNanoKontrol2 || device.InPort == "" || device.OutPort == "" {
+		t.Errorf("defaults not filled in: %+v", device)
+	}
+	if device.Channel == nil || *device.Channel != DefaultChannel {
+		t.Errorf("channel is %v, want %d", device.Channel, DefaultChannel)
+	}
+}
@@ -0,0 +1,112 @@
+package configuration
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Dump returns the effective configuration as canonical YAML, preceded by a
+// comment describing where it was loaded from. The host override is shown
+// merged into the configuration.
+func (result LoadResult) Dump() ([]byte, error) {
+	data, err := yaml.Marshal(diskConfig(&result.Config))
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.WriteString("# Effective configuration\n")
+	if result.SourcePath == "" {
+		out.WriteString("# Source: none, using defaults\n")
+	} else {
+		fmt.Fprintf(&out, "# Source: %s (version %d)\n", result.SourcePath, result.FromVersion)
+	}
+	if result.Path != result.SourcePath {
+		fmt.Fprintf(&out, "# Saved to: %s\n", result.Path)
+	}
+	if result.Host != "" {
+		fmt.Fprintf(&out, "# Override: %s\n", result.Host)
+	}
+	if len(result.Migrations) == 0 {
+		out.WriteString("# Migrations: none\n")
+	} else {
+		out.WriteString("# Migrations:\n")
+		for _, migration := range result.Migrations {
+			fmt.Fprintf(&out, "#   %s\n", migration)
+		}
+	}
+	out.Write(data)
+	return out.Bytes(), nil
+}
+
+// Diff returns the lines that differ between the file on disk and the
+// effective configuration, prefixed with - and + like a unified diff
+func (result LoadResult) Diff() ([]byte, error) {
+	data, err := yaml.Marshal(diskConfig(&result.Config))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(DiffLines(string(result.Content), string(data))), nil
+}
+
+// DiffLines compares two texts line by line and returns the removed lines
+// prefixed with "- " and the added lines prefixed with "+ ", each preceded by
+// a "@@ line N" marker of its position in the old text
+func DiffLines(from string, to string) string {
+	a := splitLines(from)
+	b := splitLines(to)
+
+	// Longest common subsequence table, lcs[i][j] is the length for a[i:], b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var out strings.Builder
+	inHunk := false
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			inHunk = false
+			i++
+			j++
+			continue
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			if !inHunk {
+				fmt.Fprintf(&out, "@@ line %d\n", i+1)
+				inHunk = true
+			}
+			fmt.Fprintf(&out, "- %s\n", a[i])
+			i++
+		default:
+			if !inHunk {
+				fmt.Fprintf(&out, "@@ line %d\n", i+1)
+				inHunk = true
+			}
+			fmt.Fprintf(&out, "+ %s\n", b[j])
+			j++
+		}
+	}
+	return out.String()
+}
+
+func splitLines(text string) []string {
+	text = strings.TrimSuffix(text, "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
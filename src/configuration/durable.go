@@ -0,0 +1,84 @@
+package configuration
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// defaultFileMode is the permission of new configuration files
+const defaultFileMode os.FileMode = 0644
+
+// writeTemp writes data to a temporary file next to path and syncs it to
+// disk. The file gets the permissions of path if it exists.
+func writeTemp(path string, data []byte) (string, error) {
+	mode := defaultFileMode
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tempPath := path + ".tmp"
+	file, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return "", err
+	}
+	fail := func(err error) (string, error) {
+		file.Close()
+		os.Remove(tempPath)
+		return "", err
+	}
+	// The umask applies on create, and an existing temp file keeps its mode
+	if err := file.Chmod(mode); err != nil {
+		return fail(err)
+	}
+	if _, err := file.Write(data); err != nil {
+		return fail(err)
+	}
+	if err := file.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tempPath)
+		return "", err
+	}
+	return tempPath, nil
+}
+
+// replaceFile renames a temporary file written by writeTemp over path and
+// syncs the directory, so that after a crash path has either the old or the
+// new contents. The temporary file is removed if that fails.
+func replaceFile(tempPath string, path string) error {
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("failed to sync directory of %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeFileDurable replaces the contents of a file, see writeTemp and replaceFile
+func writeFileDurable(path string, data []byte) error {
+	tempPath, err := writeTemp(path, data)
+	if err != nil {
+		return err
+	}
+	return replaceFile(tempPath, path)
+}
+
+// syncDir flushes a directory, making renames and new files in it durable
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	// Some filesystems can't sync directories, there is nothing more to do on those
+	if err := file.Sync(); err != nil && !errors.Is(err, syscall.EINVAL) && !errors.Is(err, syscall.ENOTSUP) {
+		return err
+	}
+	return nil
+}
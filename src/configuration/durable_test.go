@@ -0,0 +1,103 @@
+package configuration
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/0h41/pulsekontrol/src/activity"
+)
+
+// checkFile fails unless path has the contents and permissions, and no
+// temporary file is left next to it
+func checkFile(t *testing.T, path string, content string, mode os.FileMode) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != content {
+		t.Errorf("%s contains %q, want %q", path, data, content)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != mode {
+		t.Errorf("%s has mode %v, want %v", path, info.Mode().Perm(), mode)
+	}
+	if _, err := os.Lstat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left: %v", err)
+	}
+}
+
+func TestWriteFileDurable(t *testing.T) {
+	dir := t.TempDir()
+
+	// New files get the default mode
+	path := filepath.Join(dir, "new.yaml")
+	if err := writeFileDurable(path, []byte("version: 3\n")); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, path, "version: 3\n", defaultFileMode)
+
+	// Existing files keep theirs, even over a stale temporary file
+	path = filepath.Join(dir, "private.yaml")
+	if err := os.WriteFile(path, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path+".tmp", []byte("stale"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileDurable(path, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, path, "new", 0600)
+}
+
+func TestFailedWriteKeepsFile(t *testing.T) {
+	dir := t.TempDir()
+
+	// The temporary file can't be created
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(path+".tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileDurable(path, []byte("new")); err == nil {
+		t.Error("wrote through a directory in place of the temporary file")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "old" {
+		t.Errorf("file contains %q after a failed write", data)
+	}
+
+	// The rename fails, the temporary file is removed
+	path = filepath.Join(dir, "directory")
+	if err := os.MkdirAll(filepath.Join(path, "entry"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileDurable(path, []byte("new")); err == nil {
+		t.Error("replaced a directory")
+	}
+	if _, err := os.Lstat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left: %v", err)
+	}
+}
+
+func TestSaveKeepsMode(t *testing.T) {
+	cm, _ := newSavingManager(t)
+	if err := os.Chmod(cm.Path(), 0640); err != nil {
+		t.Fatal(err)
+	}
+	cm.UpdateControlValue(activity.Midi(), "slider", "slider1", 30)
+	if err := cm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := Marshal(cm.GetConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, cm.Path(), string(data), 0640)
+}
@@ -0,0 +1,49 @@
+package configuration
+
+import "path/filepath"
+
+// ExcludeRule leaves out the streams and devices matching all its set
+// fields, like the peak detection streams of pavucontrol or monitor sources.
+// Names, binary names and property values are shell patterns.
+type ExcludeRule struct {
+	Type       PulseAudioTargetType `yaml:"type,omitempty"`       // Any type when empty
+	Name       string               `yaml:"name,omitempty"`       // Pattern of the name
+	BinaryName string               `yaml:"binaryName,omitempty"` // Pattern of the binary name
+	Properties map[string]string    `yaml:"properties,omitempty"` // Patterns of property values by key, like device.class: monitor
+}
+
+// Matches reports whether a stream or device with a type, name, binary name
+// and properties is left out by the rule
+func (rule ExcludeRule) Matches(streamType PulseAudioTargetType, name string, binaryName string, properties map[string]string) bool {
+	if rule.Type != "" && rule.Type != streamType {
+		return false
+	}
+	if rule.Name != "" && !matchPattern(rule.Name, name) {
+		return false
+	}
+	if rule.BinaryName != "" && !matchPattern(rule.BinaryName, binaryName) {
+		return false
+	}
+	for key, pattern := range rule.Properties {
+		if !matchPattern(pattern, properties[key]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Excluded reports whether any of the rules leaves out a stream or device,
+// see ExcludeRule.Matches
+func Excluded(rules []ExcludeRule, streamType PulseAudioTargetType, name string, binaryName string, properties map[string]string) bool {
+	for _, rule := range rules {
+		if rule.Matches(streamType, name, binaryName, properties) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPattern(pattern string, value string) bool {
+	matched, err := filepath.Match(pattern, value)
+	return err == nil && matched
+}
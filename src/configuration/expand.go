@@ -0,0 +1,151 @@
+package configuration
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// envVarRe matches ${VAR} and ${VAR:-default}. A leading $ escapes the
+// reference, so $${VAR} is kept as the literal ${VAR}.
+var envVarRe = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// template is the raw value of a field that contained variable references
+// together with what it expanded to
+type template struct {
+	raw      string
+	expanded string
+}
+
+// expandableField is a string field of the config that supports ${VAR} expansion
+type expandableField struct {
+	path string // YAML path used in errors and as template key
+	get  func() string
+	set  func(string)
+}
+
+// expandableFields returns the fields where variables are expanded. Other
+// fields, like source names, are left alone since $ can be part of the data.
+func expandableFields(config *Config) []expandableField {
+	fields := []expandableField{
+		{"webui.address", func() string { return config.WebUI.Address }, func(value string) { config.WebUI.Address = value }},
+		{"webui.authToken", func() string { return config.WebUI.AuthToken }, func(value string) { config.WebUI.AuthToken = value }},
+		{"logging.file", func() string { return config.Logging.File }, func(value string) { config.Logging.File = value }},
+	}
+	for i := range config.Devices {
+		device := &config.Devices[i]
+		prefix := fmt.Sprintf("devices.%d", i)
+		fields = append(fields,
+			expandableField{prefix + ".name", func() string { return device.Name }, func(value string) { device.Name = value }},
+			expandableField{prefix + ".inPort", func() string { return device.InPort }, func(value string) { device.InPort = value }},
+			expandableField{prefix + ".outPort", func() string { return device.OutPort }, func(value string) { device.OutPort = value }},
+			expandableField{prefix + ".identity.id", func() string { return device.Identity.ID }, func(value string) { device.Identity.ID = value }},
+		)
+		keys := make([]string, 0, len(device.Options))
+		for key := range device.Options {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			key := key
+			fields = append(fields, expandableField{
+				prefix + ".options." + key,
+				func() string { return device.Options[key] },
+				func(value string) { device.Options[key] = value },
+			})
+		}
+	}
+	for _, name := range sortedKeys(config.Hooks) {
+		name := name
+		fields = append(fields, expandableField{
+			"hooks." + name + ".command",
+			func() string { return config.Hooks[name].Command },
+			func(value string) {
+				hook := config.Hooks[name]
+				hook.Command = value
+				config.Hooks[name] = hook
+			},
+		})
+	}
+	for i := range config.Webhooks {
+		webhook := &config.Webhooks[i]
+		prefix := fmt.Sprintf("webhooks.%d", i)
+		fields = append(fields,
+			expandableField{prefix + ".url", func() string { return webhook.URL }, func(value string) { webhook.URL = value }},
+			expandableField{prefix + ".secret", func() string { return webhook.Secret }, func(value string) { webhook.Secret = value }},
+		)
+		for _, key := range sortedKeys(webhook.Headers) {
+			key := key
+			fields = append(fields, expandableField{
+				prefix + ".headers." + key,
+				func() string { return webhook.Headers[key] },
+				func(value string) { webhook.Headers[key] = value },
+			})
+		}
+	}
+	return fields
+}
+
+// expandConfig replaces variable references in the expandable fields,
+// remembering the raw values so they can be written back on save
+func expandConfig(config *Config) error {
+	for _, field := range expandableFields(config) {
+		raw := field.get()
+		if !strings.Contains(raw, "${") {
+			continue
+		}
+		expanded, err := expandString(raw)
+		if err != nil {
+			return fmt.Errorf("%s: %w", field.path, err)
+		}
+		if config.templates == nil {
+			config.templates = make(map[string]template)
+		}
+		config.templates[field.path] = template{raw: raw, expanded: expanded}
+		field.set(expanded)
+	}
+	return nil
+}
+
+// restoreTemplates puts the raw values back into fields that still hold
+// their expanded value. Fields changed at runtime keep the new value.
+func restoreTemplates(config *Config) {
+	if len(config.templates) == 0 {
+		return
+	}
+	for _, field := range expandableFields(config) {
+		if template, ok := config.templates[field.path]; ok && field.get() == template.expanded {
+			field.set(template.raw)
+		}
+	}
+}
+
+// expandString expands ${VAR} and ${VAR:-default} references
+func expandString(value string) (string, error) {
+	var missing []string
+	expanded := envVarRe.ReplaceAllStringFunc(value, func(match string) string {
+		if strings.HasPrefix(match, "$$") {
+			return match[1:]
+		}
+		groups := envVarRe.FindStringSubmatch(match)
+		variable, ok := os.LookupEnv(groups[1])
+		if groups[2] != "" {
+			// Like the shell, :- also uses the default for empty variables
+			if ok && variable != "" {
+				return variable
+			}
+			return groups[3]
+		}
+		if ok {
+			return variable
+		}
+		missing = append(missing, groups[1])
+		return match
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s is not set and has no default", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
@@ -0,0 +1,103 @@
+package configuration
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/0h41/pulsekontrol/src/activity"
+)
+
+func TestExpandString(t *testing.T) {
+	t.Setenv("PK_TEST_HOST", "example.com")
+	t.Setenv("PK_TEST_EMPTY", "")
+	os.Unsetenv("PK_TEST_UNSET")
+
+	for _, test := range []struct {
+		value    string
+		expanded string
+		error    string
+	}{
+		{value: "${PK_TEST_HOST}:8080", expanded: "example.com:8080"},
+		{value: "${PK_TEST_HOST}/${PK_TEST_HOST}", expanded: "example.com/example.com"},
+		{value: "${PK_TEST_EMPTY}", expanded: ""},
+		{value: "${PK_TEST_HOST:-localhost}", expanded: "example.com"},
+		{value: "${PK_TEST_UNSET:-localhost}:8080", expanded: "localhost:8080"},
+		{value: "${PK_TEST_EMPTY:-localhost}", expanded: "localhost"},
+		{value: "${PK_TEST_UNSET:-}", expanded: ""},
+		{value: "${PK_TEST_UNSET}", error: "PK_TEST_UNSET is not set"},
+		{value: "${PK_TEST_UNSET}${PK_TEST_HOST}", error: "PK_TEST_UNSET is not set"},
+		{value: "$${PK_TEST_HOST}", expanded: "${PK_TEST_HOST}"},
+		{value: "$${PK_TEST_UNSET}", expanded: "${PK_TEST_UNSET}"},
+		{value: "$PK_TEST_HOST and $", expanded: "$PK_TEST_HOST and $"},
+	} {
+		expanded, err := expandString(test.value)
+		if test.error != "" {
+			if err == nil || !strings.Contains(err.Error(), test.error) {
+				t.Errorf("%q gave %q, %v, want error %q", test.value, expanded, err, test.error)
+			}
+			continue
+		}
+		if err != nil || expanded != test.expanded {
+			t.Errorf("%q expanded to %q, %v, want %q", test.value, expanded, err, test.expanded)
+		}
+	}
+}
+
+func TestSaveKeepsVariableReferences(t *testing.T) {
+	t.Setenv("PK_TEST_HOST", "example.com")
+	t.Setenv("PK_TEST_TOKEN", "hunter2")
+
+	config := GetDefaultConfig()
+	config.WebUI.AuthToken = "${PK_TEST_TOKEN}"
+	config.Logging.File = "${PK_TEST_LOGS:-/tmp}/pulsekontrol.log"
+	config.Hooks = map[string]HookConfig{
+		"notify": {Event: "profile.switched", Command: "curl ${PK_TEST_HOST}"},
+	}
+	config.Webhooks = []WebhookConfig{{
+		URL:     "https://${PK_TEST_HOST}/events",
+		Headers: map[string]string{"Authorization": "Bearer ${PK_TEST_TOKEN}"},
+		Secret:  "${PK_TEST_TOKEN}",
+	}}
+	config, err := Prepare(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The running configuration has the values
+	webhook := config.Webhooks[0]
+	if config.WebUI.AuthToken != "hunter2" || config.Logging.File != "/tmp/pulsekontrol.log" ||
+		config.Hooks["notify"].Command != "curl example.com" || webhook.URL != "https://example.com/events" ||
+		webhook.Headers["Authorization"] != "Bearer hunter2" || webhook.Secret != "hunter2" {
+		t.Errorf("expanded to %+v, %+v, %+v, %+v", config.WebUI, config.Logging, config.Hooks, webhook)
+	}
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	cm := NewConfigManager(config, path)
+	t.Cleanup(cm.Close)
+	cm.UpdateControlValue(activity.Midi(), "slider", "slider1", 42)
+	if err := cm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file keeps the references
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := string(data)
+	for _, reference := range []string{"${PK_TEST_TOKEN}", "${PK_TEST_LOGS:-/tmp}/pulsekontrol.log", "curl ${PK_TEST_HOST}", "https://${PK_TEST_HOST}/events", "Bearer ${PK_TEST_TOKEN}"} {
+		if !strings.Contains(saved, reference) {
+			t.Errorf("saved config lost %s:\n%s", reference, saved)
+		}
+	}
+	for _, value := range []string{"hunter2", "example.com"} {
+		if strings.Contains(saved, value) {
+			t.Errorf("saved config contains the value %s:\n%s", value, saved)
+		}
+	}
+	if token := cm.GetConfigSnapshot().WebUI.AuthToken; token != "hunter2" {
+		t.Errorf("saving changed the running token to %q", token)
+	}
+}
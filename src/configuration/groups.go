@@ -0,0 +1,80 @@
+package configuration
+
+import (
+	"fmt"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SourceGroup is a named list of sources assigned to sliders and knobs as a
+// whole, like the browsers. Members written as a plain name are playback
+// streams of that name.
+type SourceGroup []Source
+
+// UnmarshalYAML reads members written as plain names or as sources
+func (group *SourceGroup) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.SequenceNode {
+		return fmt.Errorf("line %d: a source group is a list of sources", node.Line)
+	}
+	members := make(SourceGroup, 0, len(node.Content))
+	for _, member := range node.Content {
+		if member.Kind == yaml.ScalarNode {
+			members = append(members, Source{Type: PlaybackStream, Name: member.Value})
+			continue
+		}
+		var source Source
+		if err := member.Decode(&source); err != nil {
+			return err
+		}
+		members = append(members, source)
+	}
+	*group = members
+	return nil
+}
+
+// MarshalYAML writes playback streams matched by name alone as plain names
+func (group SourceGroup) MarshalYAML() (interface{}, error) {
+	members := make([]interface{}, 0, len(group))
+	for _, member := range group {
+		if member.Same(Source{Type: PlaybackStream, Name: member.Name}) {
+			members = append(members, member.Name)
+		} else {
+			members = append(members, member)
+		}
+	}
+	return members, nil
+}
+
+// SliderSources returns the sources of a slider followed by the members of
+// its groups and its virtual sink
+func (config *Config) SliderSources(slider SliderConfig) []Source {
+	return config.withGroups(slider.Sources, slider.Groups, slider.VirtualSink)
+}
+
+// KnobSources returns the sources of a knob followed by the members of its
+// groups and its virtual sink
+func (config *Config) KnobSources(knob KnobConfig) []Source {
+	return config.withGroups(knob.Sources, knob.Groups, knob.VirtualSink)
+}
+
+// withGroups returns the sources followed by the members of the groups and
+// the output device of the virtual sink that are not among them already.
+// Unknown groups have no members.
+func (config *Config) withGroups(sources []Source, groups []string, virtualSink string) []Source {
+	all := slices.Clone(sources)
+	add := func(source Source) {
+		if !slices.ContainsFunc(all, source.Same) {
+			all = append(all, source)
+		}
+	}
+	for _, name := range groups {
+		for _, member := range config.Groups[name] {
+			add(member)
+		}
+	}
+	if virtualSink != "" {
+		add(Source{Type: OutputDevice, Name: virtualSink})
+	}
+	return all
+}
@@ -0,0 +1,339 @@
+package configuration
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+const (
+	// maxHistoryEntries bounds the undo (and redo) stack
+	maxHistoryEntries = 50
+	// valueCoalesceWindow merges rapid value changes (fader moves, scene ramps) into one entry
+	valueCoalesceWindow = time.Second
+)
+
+// controlState is the complete state of a single control, captured so that
+// a change can be reverted even if its sources are no longer active
+type controlState struct {
+	Exists   bool
+	Device   string
+	Path     string
+	Label    string
+	MinValue uint8
+	MaxValue uint8
+	Value    int
+	Sources  []Source
+}
+
+func (state controlState) equal(other controlState) bool {
+	return state.Exists == other.Exists &&
+		state.Device == other.Device &&
+		state.Path == other.Path &&
+		state.sameSettings(other) &&
+		state.Value == other.Value &&
+		sameSources(state.Sources, other.Sources)
+}
+
+// sameSettings compares the label and MIDI range, which move with the sources
+func (state controlState) sameSettings(other controlState) bool {
+	return state.Label == other.Label &&
+		state.MinValue == other.MinValue &&
+		state.MaxValue == other.MaxValue
+}
+
+// controlKey identifies a control by type and id
+type controlKey struct {
+	controlType string
+	controlID   string
+}
+
+// controlChange records the state of a control before and after a change.
+// A value change only holds the values, so restoring it leaves the sources
+// and settings of the control as they are.
+type controlChange struct {
+	key         controlKey
+	before      controlState
+	after       controlState
+	valueChange bool
+}
+
+// historyEntry is one undoable operation. Undo restores every before state,
+// redo restores every after state.
+type historyEntry struct {
+	description string
+	changes     []controlChange
+	valueOnly   bool
+	recordedAt  time.Time
+}
+
+// history holds the undo and redo stacks
+type history struct {
+	undo []historyEntry
+	redo []historyEntry
+}
+
+// controlStates captures the state of every control. Must be called with saveMutex held.
+func (cm *ConfigManager) controlStates() map[controlKey]controlState {
+	states := make(map[controlKey]controlState)
+	for id := range cm.config.Controls.Sliders {
+		key := controlKey{"slider", id}
+		states[key] = cm.controlState(key)
+	}
+	for id := range cm.config.Controls.Knobs {
+		key := controlKey{"knob", id}
+		states[key] = cm.controlState(key)
+	}
+	return states
+}
+
+// controlState captures the state of a single control. Must be called with saveMutex held.
+func (cm *ConfigManager) controlState(key controlKey) controlState {
+	switch key.controlType {
+	case "slider":
+		if slider, ok := cm.config.Controls.Sliders[key.controlID]; ok {
+			return controlState{
+				Exists:   true,
+				Device:   slider.Device,
+				Path:     slider.Path,
+				Label:    slider.Label,
+				MinValue: slider.MinValue,
+				MaxValue: slider.MaxValue,
+				Value:    slider.Value,
+				Sources:  slices.Clone(slider.Sources),
+			}
+		}
+	case "knob":
+		if knob, ok := cm.config.Controls.Knobs[key.controlID]; ok {
+			return controlState{
+				Exists:   true,
+				Device:   knob.Device,
+				Path:     knob.Path,
+				Label:    knob.Label,
+				MinValue: knob.MinValue,
+				MaxValue: knob.MaxValue,
+				Value:    knob.Value,
+				Sources:  slices.Clone(knob.Sources),
+			}
+		}
+	}
+	return controlState{}
+}
+
+// restoreControlState puts a control back into a captured state. Must be called with saveMutex held.
+func (cm *ConfigManager) restoreControlState(key controlKey, state controlState) {
+	cm.journal(journalValue, key.controlType, key.controlID)
+	cm.journal(journalSources, key.controlType, key.controlID)
+	cm.journal(journalSettings, key.controlType, key.controlID)
+	switch key.controlType {
+	case "slider":
+		if !state.Exists {
+			delete(cm.config.Controls.Sliders, key.controlID)
+			return
+		}
+		// Start from the current control to keep state that isn't tracked, like muting
+		slider := cm.config.Controls.Sliders[key.controlID]
+		slider.Device = state.Device
+		slider.Path = state.Path
+		slider.Label = state.Label
+		slider.MinValue = state.MinValue
+		slider.MaxValue = state.MaxValue
+		slider.Value = state.Value
+		slider.Sources = slices.Clone(state.Sources)
+		cm.config.Controls.Sliders[key.controlID] = slider
+	case "knob":
+		if !state.Exists {
+			delete(cm.config.Controls.Knobs, key.controlID)
+			return
+		}
+		knob := cm.config.Controls.Knobs[key.controlID]
+		knob.Device = state.Device
+		knob.Path = state.Path
+		knob.Label = state.Label
+		knob.MinValue = state.MinValue
+		knob.MaxValue = state.MaxValue
+		knob.Value = state.Value
+		knob.Sources = slices.Clone(state.Sources)
+		cm.config.Controls.Knobs[key.controlID] = knob
+	}
+}
+
+// restoreControlValue puts the value of a control back. A control that was
+// removed since stays removed. Must be called with saveMutex held.
+func (cm *ConfigManager) restoreControlValue(key controlKey, value int) {
+	cm.journal(journalValue, key.controlType, key.controlID)
+	switch key.controlType {
+	case "slider":
+		if slider, ok := cm.config.Controls.Sliders[key.controlID]; ok {
+			slider.Value = value
+			cm.config.Controls.Sliders[key.controlID] = slider
+		}
+	case "knob":
+		if knob, ok := cm.config.Controls.Knobs[key.controlID]; ok {
+			knob.Value = value
+			cm.config.Controls.Knobs[key.controlID] = knob
+		}
+	}
+}
+
+// recordChange compares the current controls with a previous capture and
+// pushes the difference onto the undo stack. Must be called with saveMutex held.
+func (cm *ConfigManager) recordChange(description string, before map[controlKey]controlState) {
+	after := cm.controlStates()
+	var changes []controlChange
+	valueOnly := true
+	for key, previous := range before {
+		current := after[key]
+		if previous.equal(current) {
+			continue
+		}
+		if !previous.Exists || !current.Exists || previous.Device != current.Device || previous.Path != current.Path || !previous.sameSettings(current) || !sameSources(previous.Sources, current.Sources) {
+			valueOnly = false
+		}
+		changes = append(changes, controlChange{key: key, before: previous, after: current})
+	}
+	for key, current := range after {
+		if _, existed := before[key]; existed {
+			continue
+		}
+		valueOnly = false
+		changes = append(changes, controlChange{key: key, before: controlState{}, after: current})
+	}
+	if len(changes) == 0 {
+		return
+	}
+	cm.pushHistory(description, changes, valueOnly)
+}
+
+// recordValueChange pushes the change of the value of an existing control
+// onto the undo stack without capturing anything else of the control, as
+// this runs for every fader move. Must be called with saveMutex held.
+func (cm *ConfigManager) recordValueChange(description string, key controlKey, before int, after int) {
+	cm.pushHistory(description, []controlChange{{
+		key:         key,
+		before:      controlState{Exists: true, Value: before},
+		after:       controlState{Exists: true, Value: after},
+		valueChange: true,
+	}}, true)
+}
+
+// pushHistory pushes changes onto the undo stack, merging changes of only
+// values into a value entry recorded just before. Must be called with
+// saveMutex held.
+func (cm *ConfigManager) pushHistory(description string, changes []controlChange, valueOnly bool) {
+	now := time.Now()
+	cm.history.redo = nil
+
+	// Merge rapid value changes into the previous entry
+	if valueOnly && len(cm.history.undo) > 0 {
+		last := &cm.history.undo[len(cm.history.undo)-1]
+		if last.valueOnly && now.Sub(last.recordedAt) < valueCoalesceWindow {
+			for _, change := range changes {
+				merged := false
+				for i := range last.changes {
+					if last.changes[i].key == change.key {
+						// Only the values differ, and a value change captured nothing else
+						if change.valueChange || last.changes[i].valueChange {
+							last.changes[i].after.Value = change.after.Value
+						} else {
+							last.changes[i].after = change.after
+						}
+						merged = true
+						break
+					}
+				}
+				if !merged {
+					last.changes = append(last.changes, change)
+				}
+			}
+			last.recordedAt = now
+			if len(last.changes) > 1 {
+				last.description = "change control values"
+			}
+			return
+		}
+	}
+
+	cm.history.undo = append(cm.history.undo, historyEntry{
+		description: description,
+		changes:     changes,
+		valueOnly:   valueOnly,
+		recordedAt:  now,
+	})
+	if len(cm.history.undo) > maxHistoryEntries {
+		cm.history.undo = cm.history.undo[len(cm.history.undo)-maxHistoryEntries:]
+	}
+}
+
+// Undo reverts the most recent change and returns its description
+func (cm *ConfigManager) Undo() (string, error) {
+	return cm.applyHistory(true)
+}
+
+// Redo re-applies the most recently undone change and returns its description
+func (cm *ConfigManager) Redo() (string, error) {
+	return cm.applyHistory(false)
+}
+
+// applyHistory pops an entry from the undo or redo stack, applies it and
+// pushes it onto the opposite stack
+func (cm *ConfigManager) applyHistory(undo bool) (string, error) {
+	cm.saveMutex.Lock()
+	defer cm.unlock()
+
+	from, to := &cm.history.undo, &cm.history.redo
+	if !undo {
+		from, to = to, from
+	}
+	if len(*from) == 0 {
+		if undo {
+			return "", fmt.Errorf("nothing to undo")
+		}
+		return "", fmt.Errorf("nothing to redo")
+	}
+
+	entry := (*from)[len(*from)-1]
+	*from = (*from)[:len(*from)-1]
+	entry.recordedAt = time.Time{} // Never merge into an entry that moved between stacks
+	*to = append(*to, entry)
+	if len(*to) > maxHistoryEntries {
+		*to = (*to)[len(*to)-maxHistoryEntries:]
+	}
+
+	controls := make([]map[string]interface{}, 0, len(entry.changes))
+	for _, change := range entry.changes {
+		state := change.after
+		if undo {
+			state = change.before
+		}
+		if change.valueChange {
+			cm.restoreControlValue(change.key, state.Value)
+		} else {
+			cm.restoreControlState(change.key, state)
+		}
+		controls = append(controls, map[string]interface{}{
+			"controlType": change.key.controlType,
+			"controlId":   change.key.controlID,
+			"value":       state.Value,
+		})
+	}
+
+	log.Info().Bool("undo", undo).Str("change", entry.description).Msg("Applied configuration history")
+
+	for _, control := range controls {
+		cm.notifyLocked("control.value.updated", map[string]interface{}{
+			"type":  control["controlType"],
+			"id":    control["controlId"],
+			"value": control["value"],
+		})
+	}
+	cm.notifyLocked("history.applied", map[string]interface{}{
+		"undo":        undo,
+		"description": entry.description,
+		"controls":    controls,
+	})
+
+	// Schedule save
+	cm.SaveWithDebounce()
+	return entry.description, nil
+}
@@ -0,0 +1,73 @@
+package configuration
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/0h41/pulsekontrol/src/activity"
+)
+
+func sliderValue(cm *ConfigManager, id string) int {
+	return cm.GetConfigSnapshot().Controls.Sliders[id].Value
+}
+
+func TestUndoValueChangesKeepsSources(t *testing.T) {
+	cm := newStaleTestManager(t)
+	original := sliderValue(cm, "slider1")
+
+	// A fader move is recorded as one change of the control
+	for _, value := range []int{10, 20, 30} {
+		cm.UpdateControlValue(activity.Midi(), "slider", "slider1", value)
+	}
+	if len(cm.history.undo) != 1 || len(cm.history.undo[0].changes) != 1 {
+		t.Fatalf("recorded %+v", cm.history.undo)
+	}
+	cm.AssignSource("slider", "slider1", Source{Type: PlaybackStream, Name: "Spotify"})
+
+	// Undoing the assignment and then the move restores the value, keeping
+	// the sources the control has then
+	for range 2 {
+		if _, err := cm.Undo(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	slider := cm.GetConfigSnapshot().Controls.Sliders["slider1"]
+	if slider.Value != original || len(slider.Sources) != 1 || slider.Sources[0].Name != "Firefox" {
+		t.Errorf("undo left slider1 at %d with %+v, want %d with Firefox", slider.Value, slider.Sources, original)
+	}
+
+	if _, err := cm.Redo(); err != nil {
+		t.Fatal(err)
+	}
+	if value := sliderValue(cm, "slider1"); value != 30 {
+		t.Errorf("redo set slider1 to %d, want 30", value)
+	}
+}
+
+func TestAdoptedValuesDontHideChanges(t *testing.T) {
+	cm := newStaleTestManager(t)
+	original := sliderValue(cm, "slider1")
+
+	// Values read back from volumes while the user moves another control
+	var adopting sync.WaitGroup
+	adopting.Add(1)
+	go func() {
+		defer adopting.Done()
+		for value := range 100 {
+			cm.AdoptControlValue(activity.Startup(), "slider", "slider2", value)
+		}
+	}()
+	cm.UpdateControlValue(activity.Midi(), "slider", "slider1", 60)
+	adopting.Wait()
+
+	description, err := cm.Undo()
+	if err != nil {
+		t.Fatalf("user change not recorded: %v", err)
+	}
+	if value := sliderValue(cm, "slider1"); value != original || description != "change slider1 value" {
+		t.Errorf("undo of %q left slider1 at %d, want %d", description, value, original)
+	}
+	if _, err := cm.Undo(); err == nil {
+		t.Error("adopted values were recorded")
+	}
+}
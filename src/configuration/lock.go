@@ -0,0 +1,88 @@
+package configuration
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// LockError is returned by Lock when another process holds the lock
+type LockError struct {
+	Path string
+	PID  int // Process holding the lock, 0 if unknown
+}
+
+func (e *LockError) Error() string {
+	if e.PID > 0 {
+		return fmt.Sprintf("configuration is in use by another pulsekontrol instance (pid %d, lock %s)", e.PID, e.Path)
+	}
+	return fmt.Sprintf("configuration is in use by another pulsekontrol instance (lock %s)", e.Path)
+}
+
+// FileLock is an advisory lock on the configuration held for the lifetime
+// of the process
+type FileLock struct {
+	file *os.File
+}
+
+// lockPath returns the path of the lock file of a configuration file
+func lockPath(configPath string) string {
+	return configPath + ".lock"
+}
+
+// Lock takes an exclusive lock next to the configuration file so that two
+// instances don't save over each other. The kernel drops the lock when the
+// process dies, so a lock file left behind by a crash is simply reused.
+func Lock(configPath string) (*FileLock, error) {
+	path := lockPath(configPath)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("could not create config directory: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open lock file: %w", err)
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, &LockError{Path: path, PID: lockHolder(path)}
+		}
+		return nil, fmt.Errorf("could not lock %s: %w", path, err)
+	}
+
+	// Record our PID for the message shown to a second instance
+	if err := file.Truncate(0); err == nil {
+		fmt.Fprintf(file, "%d\n", os.Getpid())
+	}
+	return &FileLock{file: file}, nil
+}
+
+// lockHolder reads the PID stored in a lock file
+func lockHolder(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+// Unlock releases the lock. The file is left in place, removing it could
+// race with another instance that is just taking the lock.
+func (lock *FileLock) Unlock() error {
+	if lock == nil || lock.file == nil {
+		return nil
+	}
+	lock.file.Truncate(0)
+	err := syscall.Flock(int(lock.file.Fd()), syscall.LOCK_UN)
+	lock.file.Close()
+	lock.file = nil
+	return err
+}
@@ -0,0 +1,89 @@
+package configuration
+
+import (
+	"fmt"
+
+	"github.com/0h41/pulsekontrol/src/logging"
+	"github.com/rs/zerolog"
+)
+
+// log is the logger of the configuration module
+var log = logging.Module("Configuration")
+
+// parseLevel parses a log level name like debug or info
+func parseLevel(name string) (zerolog.Level, error) {
+	level, err := zerolog.ParseLevel(name)
+	if err != nil || name == "" {
+		return zerolog.NoLevel, fmt.Errorf("unknown log level %q, use trace, debug, info, warn, error or disabled", name)
+	}
+	return level, nil
+}
+
+// Options returns the logging options of the configured levels and output
+func (config LoggingConfig) Options() (logging.Options, error) {
+	options := logging.Options{
+		Level:   logging.DefaultLevel,
+		Modules: make(map[string]zerolog.Level, len(config.PerModule)),
+		Format:  config.Format,
+		File:    config.File,
+		MaxSize: int64(config.MaxSizeMB) << 20,
+	}
+	if config.GlobalLevel != "" {
+		level, err := parseLevel(config.GlobalLevel)
+		if err != nil {
+			return options, err
+		}
+		options.Level = level
+	}
+	for name, levelName := range config.PerModule {
+		module, ok := logging.ModuleName(name)
+		if !ok {
+			return options, fmt.Errorf("unknown log module %q", name)
+		}
+		level, err := parseLevel(levelName)
+		if err != nil {
+			return options, err
+		}
+		options.Modules[module] = level
+	}
+	return options, nil
+}
+
+// Override returns the logging settings with the level and format replaced
+// where given, as by command line flags. The level applies to all modules.
+func (config LoggingConfig) Override(level string, format string) LoggingConfig {
+	if level != "" {
+		config.GlobalLevel = level
+		config.PerModule = nil
+	}
+	if format != "" {
+		config.Format = format
+	}
+	return config
+}
+
+func (v *validator) validateLogging(config LoggingConfig) {
+	if config.GlobalLevel != "" {
+		if _, err := parseLevel(config.GlobalLevel); err != nil {
+			v.errorf("logging.globalLevel", "%s", err)
+		}
+	}
+	for _, name := range sortedKeys(config.PerModule) {
+		path := "logging.perModule." + name
+		if _, ok := logging.ModuleName(name); !ok {
+			v.errorf(path, "unknown module %q, expected one of %v", name, logging.Modules)
+			continue
+		}
+		if _, err := parseLevel(config.PerModule[name]); err != nil {
+			v.errorf(path, "%s", err)
+		}
+	}
+	switch config.Format {
+	case "", logging.FormatConsole, logging.FormatJSON:
+	default:
+		v.errorf("logging.format", "unknown log format %q, use console or json", config.Format)
+	}
+	if config.MaxSizeMB < 0 {
+		v.errorf("logging.maxSizeMB", "log file size %d must not be negative", config.MaxSizeMB)
+	}
+}
@@ -1,23 +1,79 @@
 package configuration
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"os"
-	"strings"
+	"slices"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/rs/zerolog/log"
+	"github.com/0h41/pulsekontrol/src/activity"
+	"github.com/0h41/pulsekontrol/src/supervise"
 	"gopkg.in/yaml.v3"
 )
 
+// Variables so that tests can retry faster
+var (
+	// saveRetryDelay is the delay before retrying a failed save, doubled on each failure
+	saveRetryDelay = 5 * time.Second
+	// maxSaveRetryDelay caps the delay between retries of a failed save
+	maxSaveRetryDelay = 5 * time.Minute
+)
+
 // ConfigManager handles the runtime configuration with persistence
 type ConfigManager struct {
 	config        *Config
 	configPath    string
 	saveMutex     sync.Mutex
 	saveDebouncer *time.Timer
-	subscribers   map[string][]func(interface{})
+	subscribers   map[string][]subscriber
+	subscriberMu  sync.RWMutex
+	nextSubID     uint64
+	notifications notifyQueues // Pending asynchronous notifications, see enqueue
+	history       history
+	savedHash     [sha256.Size]byte         // Hash of the file contents last read or written
+	document      *yaml.Node                // Node tree of the file last read or written, nil if it had none
+	indent        int                       // Indentation used in the file
+	base          Config                    // The configuration as last saved, for merging external changes
+	changes       map[journalEntry]struct{} // Runtime changes since the last save
+	readOnly      bool                      // Changes are not saved, see SetReadOnly
+	warnedSave    bool                      // The skipped save has been logged in read-only mode
+	dirty         bool                      // There are changes that weren't saved yet
+	saveFailures  int                       // Failed saves since the last successful one
+	pending       []notification            // Notifications raised with saveMutex held, queued by unlock
+	unseenSince   time.Time                 // When sources without a last seen time were first looked for, see TrackUnseenSources
+
+	droppedNotifications uint64 // Accessed atomically
+	notifySeq            uint64 // Accessed atomically, numbers the notifications in the order raised
+}
+
+// subscriber is a callback registered for a topic
+type subscriber struct {
+	id       uint64
+	callback func(interface{})
+}
+
+// AllTopics subscribes to the notifications of every topic. Its callbacks
+// receive a TopicEvent instead of the data alone.
+const AllTopics = "*"
+
+// OptInTopics are only sent while someone subscribes to them by name, the
+// subscribers of AllTopics alone don't turn them on, see Subscribed
+var OptInTopics = []string{"midi.message"}
+
+// TopicEvent is a notification passed to the subscribers of AllTopics
+type TopicEvent struct {
+	Topic string
+	Data  interface{}
+}
+
+// Subscription identifies a registered callback for Unsubscribe
+type Subscription struct {
+	topic string
+	id    uint64
 }
 
 type sourceAssignment struct {
@@ -25,84 +81,373 @@ type sourceAssignment struct {
 	controlID   string
 }
 
+// SourceConflict is another control that has a source overlapping with one
+// being assigned
+type SourceConflict struct {
+	ControlType string
+	ControlID   string
+	Source      Source // The overlapping source of the other control
+}
+
+// AssignResult tells how AssignSource dealt with other controls having the source
+type AssignResult struct {
+	Policy    DuplicatePolicy  // The duplicateSources policy applied, empty if no other control has the source
+	Conflicts []SourceConflict // The other controls that had the source
+}
+
 // NewConfigManager creates a new configuration manager with the loaded configuration
 func NewConfigManager(config Config, configPath string) *ConfigManager {
-	return &ConfigManager{
+	cm := &ConfigManager{
 		config:      &config,
 		configPath:  configPath,
-		subscribers: make(map[string][]func(interface{})),
+		subscribers: make(map[string][]subscriber),
 	}
+
+	// Seed the hash from disk so an unchanged config isn't rewritten on the first save
+	cm.indent = defaultIndent
+	if data, err := os.ReadFile(configPath); err == nil {
+		cm.savedHash = sha256.Sum256(data)
+		cm.document = parseDocument(data)
+		cm.indent = detectIndent(data)
+	}
+	cm.base = config.Clone()
+	return cm
 }
 
-// GetConfig returns the current configuration
+// SetReadOnly disables saving, used when another instance owns the
+// configuration or persistence is turned off. Changes still apply until exit.
+func (cm *ConfigManager) SetReadOnly(readOnly bool) {
+	cm.saveMutex.Lock()
+	defer cm.unlock()
+	cm.readOnly = readOnly
+}
+
+// ReadOnly returns whether saving is disabled
+func (cm *ConfigManager) ReadOnly() bool {
+	cm.saveMutex.Lock()
+	defer cm.unlock()
+	return cm.readOnly
+}
+
+// GetConfig returns the live configuration. It is mutated under saveMutex,
+// so outside the manager use GetConfigSnapshot instead.
 func (cm *ConfigManager) GetConfig() *Config {
 	return cm.config
 }
 
+// Path returns the path the configuration is saved to
+func (cm *ConfigManager) Path() string {
+	return cm.configPath
+}
+
+// GetConfigSnapshot returns a deep copy of the configuration that is safe to
+// read while the configuration is being changed
+func (cm *ConfigManager) GetConfigSnapshot() Config {
+	cm.saveMutex.Lock()
+	defer cm.unlock()
+
+	return cm.config.Clone()
+}
+
+// SaveState tells whether the configuration on disk is behind
+type SaveState struct {
+	Path     string
+	ReadOnly bool
+	Dirty    bool // There are changes that weren't saved yet
+	Failures int  // Failed saves since the last successful one
+}
+
+// Snapshot returns a copy of the configuration together with its save
+// state, both from the same moment
+func (cm *ConfigManager) Snapshot() (Config, SaveState) {
+	cm.saveMutex.Lock()
+	defer cm.unlock()
+
+	return cm.config.Clone(), SaveState{
+		Path:     cm.configPath,
+		ReadOnly: cm.readOnly,
+		Dirty:    cm.dirty,
+		Failures: cm.saveFailures,
+	}
+}
+
 // Subscribe registers a callback for configuration changes
-func (cm *ConfigManager) Subscribe(topic string, callback func(interface{})) {
-	cm.subscribers[topic] = append(cm.subscribers[topic], callback)
+func (cm *ConfigManager) Subscribe(topic string, callback func(interface{})) Subscription {
+	cm.subscriberMu.Lock()
+	defer cm.subscriberMu.Unlock()
+
+	cm.nextSubID++
+	cm.subscribers[topic] = append(cm.subscribers[topic], subscriber{id: cm.nextSubID, callback: callback})
+	return Subscription{topic: topic, id: cm.nextSubID}
 }
 
-// Notify sends updates to subscribers
+// Unsubscribe removes a callback. It may be called from within a
+// notification; a notification already in progress still reaches it.
+func (cm *ConfigManager) Unsubscribe(subscription Subscription) {
+	cm.subscriberMu.Lock()
+	defer cm.subscriberMu.Unlock()
+
+	// Build a new slice, Notify may be iterating the old one
+	remaining := make([]subscriber, 0, len(cm.subscribers[subscription.topic]))
+	for _, sub := range cm.subscribers[subscription.topic] {
+		if sub.id != subscription.id {
+			remaining = append(remaining, sub)
+		}
+	}
+	if len(remaining) == 0 {
+		delete(cm.subscribers, subscription.topic)
+	} else {
+		cm.subscribers[subscription.topic] = remaining
+	}
+}
+
+// Subscribed reports whether a notification of topic would reach a
+// subscriber, so senders of frequent ones can skip building them. Only those
+// subscribing by name count for OptInTopics.
+func (cm *ConfigManager) Subscribed(topic string) bool {
+	cm.subscriberMu.RLock()
+	defer cm.subscriberMu.RUnlock()
+	if slices.Contains(OptInTopics, topic) {
+		return len(cm.subscribers[topic]) > 0
+	}
+	return len(cm.subscribers[topic]) > 0 || len(cm.subscribers[AllTopics]) > 0
+}
+
+// Notify queues an update for subscribers and returns without waiting for
+// them, unless the queue is full, see enqueue. Notifications are delivered in
+// order on a goroutine of their topic. With saveMutex held, notifyLocked is used
+// instead.
 func (cm *ConfigManager) Notify(topic string, data interface{}) {
-	for _, callback := range cm.subscribers[topic] {
-		callback(data)
+	cm.enqueue(topic, data, atomic.AddUint64(&cm.notifySeq, 1))
+}
+
+// NotifySync sends an update to subscribers and waits for them to return. A
+// subscriber that panics is logged and the others are still called. It must
+// not be called with saveMutex held if subscribers change the config.
+func (cm *ConfigManager) NotifySync(topic string, data interface{}) {
+	// Call outside the lock so callbacks can subscribe and unsubscribe
+	cm.subscriberMu.RLock()
+	subscribers := slices.Clone(cm.subscribers[topic])
+	all := slices.Clone(cm.subscribers[AllTopics])
+	cm.subscriberMu.RUnlock()
+
+	for _, sub := range subscribers {
+		deliver(topic, sub, data)
+	}
+	for _, sub := range all {
+		deliver(topic, sub, TopicEvent{Topic: topic, Data: data})
 	}
 }
 
+// deliver calls a subscriber, recovering from its panic
+func deliver(topic string, sub subscriber, data interface{}) {
+	defer supervise.Recover("notify." + topic)
+	sub.callback(data)
+}
+
 // SaveWithDebounce schedules a save after a brief delay, debouncing multiple rapid changes
 func (cm *ConfigManager) SaveWithDebounce() {
-	// Cancel existing timer if any
-	if cm.saveDebouncer != nil {
-		cm.saveDebouncer.Stop()
+	if cm.readOnly {
+		cm.skipSave()
+		return
 	}
+	cm.dirty = true
 
 	// Set new timer - save after 2 seconds of no changes
-	cm.saveDebouncer = time.AfterFunc(2*time.Second, func() {
+	cm.scheduleSave(2 * time.Second)
+}
+
+// scheduleSave replaces the pending save, if any, with one after delay. Must
+// be called with saveMutex held.
+func (cm *ConfigManager) scheduleSave(delay time.Duration) {
+	if cm.saveDebouncer != nil {
+		cm.saveDebouncer.Stop()
+	}
+	cm.saveDebouncer = time.AfterFunc(delay, func() {
 		cm.SaveNow()
 	})
 }
 
-// SaveNow immediately saves the configuration to disk
+// SaveNow immediately saves the configuration to disk. If that fails, the
+// save is retried with increasing delays until it succeeds.
 func (cm *ConfigManager) SaveNow() {
 	cm.saveMutex.Lock()
-	defer cm.saveMutex.Unlock()
+	defer cm.unlock()
+
+	if cm.readOnly {
+		cm.skipSave()
+		return
+	}
+	cm.saveOrRetry()
+}
+
+// Flush cancels the pending save and saves unsaved changes right away, for
+// shutdown. Unlike SaveNow it doesn't retry but returns the error.
+func (cm *ConfigManager) Flush() error {
+	cm.saveMutex.Lock()
+	defer cm.unlock()
 
+	if cm.saveDebouncer != nil {
+		cm.saveDebouncer.Stop()
+	}
+	if cm.readOnly || !cm.dirty {
+		return nil
+	}
+	if err := cm.save(); err != nil {
+		return err
+	}
+	cm.saved()
+	return nil
+}
+
+// saveOrRetry saves the configuration and schedules a retry if that fails.
+// Must be called with saveMutex held.
+func (cm *ConfigManager) saveOrRetry() {
+	err := cm.save()
+	if err == nil {
+		cm.saved()
+		return
+	}
+
+	cm.dirty = true
+	cm.saveFailures++
+	delay := saveRetryDelay
+	for i := 1; i < cm.saveFailures && delay < maxSaveRetryDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxSaveRetryDelay)
+	log.Error().Err(err).Str("path", cm.configPath).Int("attempt", cm.saveFailures).Dur("retryIn", delay).Msg("Failed to save configuration")
+	if cm.saveFailures == 1 {
+		cm.notifyLocked("config.save.failed", map[string]interface{}{
+			"path":  cm.configPath,
+			"error": err.Error(),
+		})
+	}
+	cm.scheduleSave(delay)
+}
+
+// saved records a successful save. Must be called with saveMutex held.
+func (cm *ConfigManager) saved() {
+	cm.dirty = false
+	if cm.saveFailures == 0 {
+		return
+	}
+	log.Info().Str("path", cm.configPath).Int("attempts", cm.saveFailures+1).Msg("Configuration saved after earlier failures")
+	cm.notifyLocked("config.save.recovered", map[string]interface{}{
+		"path":     cm.configPath,
+		"attempts": cm.saveFailures + 1,
+	})
+	cm.saveFailures = 0
+}
+
+// save writes the configuration to disk. Must be called with saveMutex held.
+func (cm *ConfigManager) save() error {
 	log.Debug().Msg("Saving configuration to disk")
 
-	// Marshal to YAML
-	data, err := yaml.Marshal(cm.config)
+	// Someone edited the file since we last read or wrote it, don't clobber their changes
+	if disk, err := os.ReadFile(cm.configPath); err == nil && sha256.Sum256(disk) != cm.savedHash {
+		if err := cm.mergeExternal(disk); err != nil {
+			// Keep the runtime changes and try again on the next save
+			return fmt.Errorf("configuration changed on disk and cannot be merged: %w", err)
+		}
+	}
+
+	// Marshal to YAML, keeping the comments and layout of the file
+	data, document, err := MarshalPreserving(cm.document, cm.indent, cm.config)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to marshal configuration")
-		return
+		return fmt.Errorf("failed to marshal configuration: %w", err)
+	}
+
+	// Unchanged values keep their nodes, so identical configs marshal to identical bytes
+	hash := sha256.Sum256(data)
+	if hash == cm.savedHash {
+		log.Debug().Str("path", cm.configPath).Msg("Configuration unchanged, skipping save")
+		cm.resetJournal()
+		return nil
 	}
 
-	// Write to temporary file first
-	tempPath := cm.configPath + ".tmp"
-	err = os.WriteFile(tempPath, data, 0644)
+	// Write to temporary file first, synced so that a crash can't leave an empty config
+	tempPath, err := writeTemp(cm.configPath, data)
 	if err != nil {
-		log.Error().Err(err).Str("path", tempPath).Msg("Failed to write temporary configuration file")
-		return
+		return fmt.Errorf("failed to write temporary configuration file: %w", err)
+	}
+
+	// Keep the previous versions, a failed rotation must not prevent saving
+	if err := rotateBackups(cm.configPath, *cm.config.Backups.Count); err != nil {
+		log.Warn().Err(err).Str("config", cm.configPath).Msg("Failed to rotate configuration backups")
 	}
 
 	// Rename to actual config file (atomic operation)
-	err = os.Rename(tempPath, cm.configPath)
-	if err != nil {
-		log.Error().Err(err).
-			Str("temp", tempPath).
-			Str("config", cm.configPath).
-			Msg("Failed to rename configuration file")
-		return
+	if err := replaceFile(tempPath, cm.configPath); err != nil {
+		return fmt.Errorf("failed to replace configuration file: %w", err)
 	}
 
+	cm.savedHash = hash
+	cm.document = document
+	cm.resetJournal()
 	log.Info().Str("path", cm.configPath).Msg("Configuration saved")
+	return nil
+}
+
+// skipSave logs once that changes are not saved in read-only mode
+func (cm *ConfigManager) skipSave() {
+	if !cm.warnedSave {
+		log.Warn().Str("path", cm.configPath).Msg("Configuration is read-only, changes will not be saved")
+		cm.warnedSave = true
+	}
+}
+
+// UpdateControlValue updates a control's value (0-100) and reports whether it
+// changed. Faders jitter and clients echo values back, so an unchanged value
+// is neither notified nor saved. The origin is passed on to subscribers so
+// they can ignore their own updates.
+func (cm *ConfigManager) UpdateControlValue(origin activity.Origin, controlType string, controlId string, value int) bool {
+	return cm.updateControlValue(origin, controlType, controlId, value, true)
 }
 
-// UpdateControlValue updates a control's value (0-100)
-func (cm *ConfigManager) UpdateControlValue(controlType string, controlId string, value int) {
+// updateControlValue updates a control's value, recording the change for
+// undo if record is set
+func (cm *ConfigManager) updateControlValue(origin activity.Origin, controlType string, controlId string, value int, record bool) bool {
 	cm.saveMutex.Lock()
-	defer cm.saveMutex.Unlock()
+	defer cm.unlock()
+
+	var previous int
+	existed := false
+	switch controlType {
+	case "slider":
+		if slider, ok := cm.config.Controls.Sliders[controlId]; ok {
+			if slider.Value == value {
+				return false
+			}
+			previous, existed = slider.Value, true
+		}
+	case "knob":
+		if knob, ok := cm.config.Controls.Knobs[controlId]; ok {
+			if knob.Value == value {
+				return false
+			}
+			previous, existed = knob.Value, true
+		}
+	default:
+		return false
+	}
+
+	// Controls that are moved without being configured (when no sources are
+	// assigned) are created from the control defaults, or only reported
+	defaults := cm.config.ControlDefaults
+	if !existed {
+		if !defaults.CreateAllowed() {
+			log.Debug().Str("type", controlType).Str("id", controlId).Int("value", value).Msg("Ignoring unmapped control")
+			cm.notifyLocked("control.unmapped", map[string]interface{}{
+				"type":   controlType,
+				"id":     controlId,
+				"value":  value,
+				"origin": origin,
+			})
+			return false
+		}
+		value = defaults.InitialValue(value)
+	}
 
 	switch controlType {
 	case "slider":
@@ -110,56 +455,159 @@ func (cm *ConfigManager) UpdateControlValue(controlType string, controlId string
 			slider.Value = value
 			cm.config.Controls.Sliders[controlId] = slider
 		} else {
-			// Create the slider if it doesn't exist (when no sources are assigned)
-			groupNumber := strings.TrimPrefix(controlId, "slider")
-			newSlider := SliderConfig{
-				Path:    fmt.Sprintf("Group%s/Slider", groupNumber),
+			cm.config.Controls.Sliders[controlId] = SliderConfig{
+				Path:    defaults.PathFor(controlType, controlId),
+				Label:   defaults.LabelFor(controlType, controlId),
 				Value:   value,
 				Sources: []Source{},
 			}
-			cm.config.Controls.Sliders[controlId] = newSlider
 		}
 	case "knob":
 		if knob, ok := cm.config.Controls.Knobs[controlId]; ok {
 			knob.Value = value
 			cm.config.Controls.Knobs[controlId] = knob
 		} else {
-			// Create the knob if it doesn't exist (when no sources are assigned)
-			groupNumber := strings.TrimPrefix(controlId, "knob")
-			newKnob := KnobConfig{
-				Path:    fmt.Sprintf("Group%s/Knob", groupNumber),
+			cm.config.Controls.Knobs[controlId] = KnobConfig{
+				Path:    defaults.PathFor(controlType, controlId),
+				Label:   defaults.LabelFor(controlType, controlId),
 				Value:   value,
 				Sources: []Source{},
 			}
-			cm.config.Controls.Knobs[controlId] = newKnob
 		}
 	}
 
+	if record {
+		key, description := controlKey{controlType, controlId}, fmt.Sprintf("change %s value", controlId)
+		if existed {
+			cm.recordValueChange(description, key, previous, value)
+		} else {
+			cm.pushHistory(description, []controlChange{{key: key, after: cm.controlState(key)}}, false)
+		}
+	}
+	cm.journal(journalValue, controlType, controlId)
+
 	// Notify subscribers immediately with real-time changes
-	cm.Notify("control.value.updated", map[string]interface{}{
-		"type":  controlType,
-		"id":    controlId,
-		"value": value,
+	cm.notifyLocked("control.value.updated", map[string]interface{}{
+		"type":   controlType,
+		"id":     controlId,
+		"value":  value,
+		"origin": origin,
 	})
 
 	// Schedule save - but don't let this slow down the UI updates
 	cm.SaveWithDebounce()
+	return true
+}
+
+// ControlSources returns the sources of a slider or knob with the members of
+// its groups, copying nothing else unlike GetConfigSnapshot
+func (cm *ConfigManager) ControlSources(controlType string, controlId string) []Source {
+	cm.saveMutex.Lock()
+	defer cm.unlock()
+
+	switch controlType {
+	case "slider":
+		return cm.config.SliderSources(cm.config.Controls.Sliders[controlId])
+	case "knob":
+		return cm.config.KnobSources(cm.config.Controls.Knobs[controlId])
+	}
+	return nil
+}
+
+// ControlVolume returns the volume in percent a value of a slider or knob
+// sets, see the maxVolume and scale of controls
+func (cm *ConfigManager) ControlVolume(controlType string, controlId string, value int) float64 {
+	cm.saveMutex.Lock()
+	defer cm.unlock()
+	switch controlType {
+	case "slider":
+		return cm.config.Controls.Sliders[controlId].VolumeMapping().Volume(value)
+	case "knob":
+		return cm.config.Controls.Knobs[controlId].VolumeMapping().Volume(value)
+	}
+	return float64(value)
+}
+
+// ControlOutput returns the sources of a slider or knob together with what
+// a value of it sets on them, SetVolume with the volume in percent or
+// BalanceControl, from a single look at the configuration. Workers of queued
+// values use it to hold the lock only briefly.
+func (cm *ConfigManager) ControlOutput(controlType string, controlId string, value int) ([]Source, PulseAudioActionType, float64) {
+	cm.saveMutex.Lock()
+	defer cm.unlock()
+	switch controlType {
+	case "slider":
+		slider := cm.config.Controls.Sliders[controlId]
+		return cm.config.SliderSources(slider), SetVolume, slider.VolumeMapping().Volume(value)
+	case "knob":
+		knob := cm.config.Controls.Knobs[controlId]
+		return cm.config.KnobSources(knob), cm.controlAction(controlType, controlId), knob.VolumeMapping().Volume(value)
+	}
+	return nil, SetVolume, float64(value)
+}
+
+// ControlAction returns what the value of a slider or knob sets, SetVolume or
+// BalanceControl
+func (cm *ConfigManager) ControlAction(controlType string, controlId string) PulseAudioActionType {
+	cm.saveMutex.Lock()
+	defer cm.unlock()
+	return cm.controlAction(controlType, controlId)
+}
+
+// controlAction is ControlAction with saveMutex held
+func (cm *ConfigManager) controlAction(controlType string, controlId string) PulseAudioActionType {
+	if controlType == "knob" && cm.config.Controls.Knobs[controlId].ControlsBalance() {
+		return BalanceControl
+	}
+	return SetVolume
+}
+
+// AdoptControlValue stores a control value read back from a volume. Nothing
+// was changed by the user, so there is nothing to undo.
+func (cm *ConfigManager) AdoptControlValue(origin activity.Origin, controlType string, controlId string, value int) {
+	cm.updateControlValue(origin, controlType, controlId, value, false)
+}
+
+// AssignSource assigns an audio source to a control. Other controls having the
+// source are handled according to the duplicateSources policy.
+func (cm *ConfigManager) AssignSource(controlType string, controlId string, source Source) AssignResult {
+	return cm.assignSource(controlType, controlId, source, true)
 }
 
-// AssignSource assigns an audio source to a control
-func (cm *ConfigManager) AssignSource(controlType string, controlId string, source Source) {
+// assignSource assigns an audio source to a control, recording the change
+// for undo if record is set
+func (cm *ConfigManager) assignSource(controlType string, controlId string, source Source, record bool) AssignResult {
 	cm.saveMutex.Lock()
-	defer cm.saveMutex.Unlock()
+	defer cm.unlock()
 
 	var currentValue int
+	var currentMuted bool
 	var assigned bool
 
-	removedAssignments := cm.removeSourceFromOtherControls(controlType, controlId, source)
+	var before map[controlKey]controlState
+	if record {
+		before = cm.controlStates()
+	}
+	var result AssignResult
+	var removedAssignments []sourceAssignment
+	policy, match := cm.config.DuplicateSources, source.Overlaps
+	if policy == "" {
+		// Without a policy only the same source moves, so that assigning a
+		// pattern doesn't take the specific sources of other controls
+		policy, match = DuplicateMove, source.Same
+	}
+	if conflicts := cm.sourceConflicts(controlType, controlId, match); len(conflicts) > 0 {
+		result = AssignResult{Policy: policy, Conflicts: conflicts}
+		if result.Policy == DuplicateMove {
+			removedAssignments = cm.removeSourceFromOtherControls(controlType, controlId, match)
+		}
+	}
 
 	switch controlType {
 	case "slider":
 		if slider, ok := cm.config.Controls.Sliders[controlId]; ok {
 			currentValue = slider.Value
+			currentMuted = slider.Muted
 			if !containsSource(slider.Sources, source) {
 				slider.Sources = append(slider.Sources, source)
 				cm.config.Controls.Sliders[controlId] = slider
@@ -169,6 +617,7 @@ func (cm *ConfigManager) AssignSource(controlType string, controlId string, sour
 	case "knob":
 		if knob, ok := cm.config.Controls.Knobs[controlId]; ok {
 			currentValue = knob.Value
+			currentMuted = knob.Muted
 			if !containsSource(knob.Sources, source) {
 				knob.Sources = append(knob.Sources, source)
 				cm.config.Controls.Knobs[controlId] = knob
@@ -178,11 +627,17 @@ func (cm *ConfigManager) AssignSource(controlType string, controlId string, sour
 	}
 
 	if !assigned && len(removedAssignments) == 0 {
-		return
+		return result
+	}
+
+	if record {
+		cm.recordChange(fmt.Sprintf("assign %s to %s", source.Name, controlId), before)
 	}
+	cm.journal(journalSources, controlType, controlId)
 
 	for _, removed := range removedAssignments {
-		cm.Notify("source.unassigned", map[string]interface{}{
+		cm.journal(journalSources, removed.controlType, removed.controlID)
+		cm.notifyLocked("source.unassigned", map[string]interface{}{
 			"controlType": removed.controlType,
 			"controlId":   removed.controlID,
 			"sourceType":  source.Type,
@@ -191,24 +646,48 @@ func (cm *ConfigManager) AssignSource(controlType string, controlId string, sour
 	}
 
 	if assigned {
-		cm.Notify("source.assigned", map[string]interface{}{
+		cm.notifyLocked("source.assigned", map[string]interface{}{
 			"controlType":  controlType,
 			"controlId":    controlId,
 			"source":       source,
 			"initialValue": currentValue, // Include the current value for immediate volume setting
+			"muted":        currentMuted,
 		})
+
+		if result.Policy == DuplicateWarn {
+			for _, conflict := range result.Conflicts {
+				log.Warn().Str("source", source.Name).Str("control", controlId).Str("other", conflict.ControlID).Msg("Source is assigned to two controls")
+			}
+			cm.notifyLocked("source.duplicate", map[string]interface{}{
+				"controlType": controlType,
+				"controlId":   controlId,
+				"source":      source,
+				"conflicts":   result.Conflicts,
+			})
+		}
 	}
 
 	// Schedule save
 	cm.SaveWithDebounce()
+	return result
 }
 
 // UnassignSource removes an audio source from a control
 func (cm *ConfigManager) UnassignSource(controlType string, controlId string, source Source) {
+	cm.unassignSource(controlType, controlId, source, true)
+}
+
+// unassignSource removes an audio source from a control, recording the
+// change for undo if record is set
+func (cm *ConfigManager) unassignSource(controlType string, controlId string, source Source, record bool) {
 	cm.saveMutex.Lock()
-	defer cm.saveMutex.Unlock()
+	defer cm.unlock()
 
 	removed := false
+	var before map[controlKey]controlState
+	if record {
+		before = cm.controlStates()
+	}
 
 	switch controlType {
 	case "slider":
@@ -235,8 +714,13 @@ func (cm *ConfigManager) UnassignSource(controlType string, controlId string, so
 		return
 	}
 
+	if record {
+		cm.recordChange(fmt.Sprintf("unassign %s from %s", source.Name, controlId), before)
+	}
+	cm.journal(journalSources, controlType, controlId)
+
 	// Notify subscribers
-	cm.Notify("source.unassigned", map[string]interface{}{
+	cm.notifyLocked("source.unassigned", map[string]interface{}{
 		"controlType": controlType,
 		"controlId":   controlId,
 		"sourceType":  source.Type,
@@ -247,15 +731,47 @@ func (cm *ConfigManager) UnassignSource(controlType string, controlId string, so
 	cm.SaveWithDebounce()
 }
 
-func (cm *ConfigManager) removeSourceFromOtherControls(targetControlType string, targetControlID string, source Source) []sourceAssignment {
+// sourceConflicts lists the sources of other sliders and knobs that match,
+// sorted by control. A balance knob doesn't conflict with a
+// volume control. Must be called with saveMutex held.
+func (cm *ConfigManager) sourceConflicts(targetControlType string, targetControlID string, match func(Source) bool) []SourceConflict {
+	var conflicts []SourceConflict
+	action := cm.controlAction(targetControlType, targetControlID)
+	for _, controlID := range sortedKeys(cm.config.Controls.Sliders) {
+		if targetControlType == "slider" && targetControlID == controlID || action != SetVolume {
+			continue
+		}
+		for _, other := range cm.config.Controls.Sliders[controlID].Sources {
+			if match(other) {
+				conflicts = append(conflicts, SourceConflict{ControlType: "slider", ControlID: controlID, Source: other})
+			}
+		}
+	}
+	for _, controlID := range sortedKeys(cm.config.Controls.Knobs) {
+		if targetControlType == "knob" && targetControlID == controlID || cm.controlAction("knob", controlID) != action {
+			continue
+		}
+		for _, other := range cm.config.Controls.Knobs[controlID].Sources {
+			if match(other) {
+				conflicts = append(conflicts, SourceConflict{ControlType: "knob", ControlID: controlID, Source: other})
+			}
+		}
+	}
+	return conflicts
+}
+
+// removeSourceFromOtherControls removes the sources that match from all
+// sliders and knobs except the target, of those setting the same
+func (cm *ConfigManager) removeSourceFromOtherControls(targetControlType string, targetControlID string, match func(Source) bool) []sourceAssignment {
 	var removedAssignments []sourceAssignment
+	action := cm.controlAction(targetControlType, targetControlID)
 
 	for controlID, slider := range cm.config.Controls.Sliders {
-		if targetControlType == "slider" && targetControlID == controlID {
+		if targetControlType == "slider" && targetControlID == controlID || action != SetVolume {
 			continue
 		}
 
-		filteredSources, removed := filterSource(slider.Sources, source)
+		filteredSources, removed := filterSourcesFunc(slider.Sources, match)
 		if !removed {
 			continue
 		}
@@ -269,11 +785,11 @@ func (cm *ConfigManager) removeSourceFromOtherControls(targetControlType string,
 	}
 
 	for controlID, knob := range cm.config.Controls.Knobs {
-		if targetControlType == "knob" && targetControlID == controlID {
+		if targetControlType == "knob" && targetControlID == controlID || cm.controlAction("knob", controlID) != action {
 			continue
 		}
 
-		filteredSources, removed := filterSource(knob.Sources, source)
+		filteredSources, removed := filterSourcesFunc(knob.Sources, match)
 		if !removed {
 			continue
 		}
@@ -289,9 +805,253 @@ func (cm *ConfigManager) removeSourceFromOtherControls(targetControlType string,
 	return removedAssignments
 }
 
+// UpdateButtonState stores the state of a toggle button
+func (cm *ConfigManager) UpdateButtonState(buttonId string, state bool) {
+	cm.saveMutex.Lock()
+	defer cm.unlock()
+
+	button, ok := cm.config.Controls.Buttons[buttonId]
+	if !ok {
+		log.Warn().Str("button", buttonId).Msg("Cannot update state of unknown button")
+		return
+	}
+	if button.State != nil && *button.State == state {
+		return
+	}
+	button.State = &state
+	cm.config.Controls.Buttons[buttonId] = button
+	cm.journal(journalButtonState, "button", buttonId)
+
+	cm.notifyLocked("button.state.updated", map[string]interface{}{
+		"id":    buttonId,
+		"state": state,
+	})
+
+	// Schedule save
+	cm.SaveWithDebounce()
+}
+
+// SetControlMuted stores whether the sources of a control are muted
+func (cm *ConfigManager) SetControlMuted(controlType string, controlId string, muted bool) error {
+	cm.saveMutex.Lock()
+	defer cm.unlock()
+
+	switch controlType {
+	case "slider":
+		slider, ok := cm.config.Controls.Sliders[controlId]
+		if !ok {
+			return fmt.Errorf("slider %s not found", controlId)
+		}
+		if slider.Muted == muted {
+			return nil
+		}
+		slider.Muted = muted
+		cm.config.Controls.Sliders[controlId] = slider
+	case "knob":
+		knob, ok := cm.config.Controls.Knobs[controlId]
+		if !ok {
+			return fmt.Errorf("knob %s not found", controlId)
+		}
+		if knob.Muted == muted {
+			return nil
+		}
+		knob.Muted = muted
+		cm.config.Controls.Knobs[controlId] = knob
+	case "button":
+		button, ok := cm.config.Controls.Buttons[controlId]
+		if !ok {
+			return fmt.Errorf("button %s not found", controlId)
+		}
+		if button.Muted == muted {
+			return nil
+		}
+		button.Muted = muted
+		cm.config.Controls.Buttons[controlId] = button
+	default:
+		return fmt.Errorf("unknown control type %s", controlType)
+	}
+	cm.journal(journalMuted, controlType, controlId)
+
+	cm.notifyLocked("control.muted.updated", map[string]interface{}{
+		"controlType": controlType,
+		"controlId":   controlId,
+		"muted":       muted,
+	})
+
+	// Schedule save
+	cm.SaveWithDebounce()
+	return nil
+}
+
+// SetDeviceIdentity stores the hardware identity of a device so that it is
+// found again when its port names change
+func (cm *ConfigManager) SetDeviceIdentity(deviceName string, identity DeviceIdentity) error {
+	cm.saveMutex.Lock()
+	defer cm.unlock()
+
+	device := &cm.config.Device
+	if len(cm.config.Devices) > 0 {
+		device = nil
+		for i := range cm.config.Devices {
+			if cm.config.Devices[i].Name == deviceName {
+				device = &cm.config.Devices[i]
+				break
+			}
+		}
+	}
+	if device == nil || device.Name != deviceName {
+		return fmt.Errorf("device %s not found", deviceName)
+	}
+	if device.Identity == identity {
+		return nil
+	}
+	device.Identity = identity
+	cm.journal(journalDeviceIdentity, "", deviceName)
+
+	log.Info().Str("device", deviceName).Str("serial", identity.Serial).Str("id", identity.ID).Msg("Stored device identity")
+
+	// Schedule save
+	cm.SaveWithDebounce()
+	return nil
+}
+
+// SetDevicePorts stores the MIDI ports of a device, when it was found on
+// other ports than the configured ones
+func (cm *ConfigManager) SetDevicePorts(deviceName string, inPort string, outPort string) error {
+	cm.saveMutex.Lock()
+	defer cm.unlock()
+
+	device := &cm.config.Device
+	if len(cm.config.Devices) > 0 {
+		device = nil
+		for i := range cm.config.Devices {
+			if cm.config.Devices[i].Name == deviceName {
+				device = &cm.config.Devices[i]
+				break
+			}
+		}
+	}
+	if device == nil || device.Name != deviceName {
+		return fmt.Errorf("device %s not found", deviceName)
+	}
+	if device.InPort == inPort && device.OutPort == outPort {
+		return nil
+	}
+	device.InPort = inPort
+	device.OutPort = outPort
+	cm.journal(journalDevicePorts, "", deviceName)
+
+	log.Info().Str("device", deviceName).Str("in", inPort).Str("out", outPort).Msg("Stored device ports")
+
+	// Schedule save
+	cm.SaveWithDebounce()
+	return nil
+}
+
+// AssignButtonAction adds an action to a button
+func (cm *ConfigManager) AssignButtonAction(buttonId string, action Action) error {
+	cm.saveMutex.Lock()
+	defer cm.unlock()
+
+	button, ok := cm.config.Controls.Buttons[buttonId]
+	if !ok {
+		return fmt.Errorf("unknown button %s", buttonId)
+	}
+	button.Actions = append(button.Actions, action)
+	cm.config.Controls.Buttons[buttonId] = button
+	cm.journal(journalButtonActions, "button", buttonId)
+
+	cm.notifyLocked("button.action.assigned", map[string]interface{}{
+		"id":     buttonId,
+		"action": action,
+	})
+
+	// Schedule save
+	cm.SaveWithDebounce()
+	return nil
+}
+
+// SaveScene captures the current value of every control under the given name.
+// Real volumes of assigned sources can optionally be stored alongside.
+func (cm *ConfigManager) SaveScene(name string, volumes []SceneVolume) {
+	cm.saveMutex.Lock()
+	defer cm.unlock()
+
+	scene := SceneConfig{
+		Sliders: make(map[string]int),
+		Knobs:   make(map[string]int),
+		Volumes: volumes,
+	}
+	for id, slider := range cm.config.Controls.Sliders {
+		scene.Sliders[id] = slider.Value
+	}
+	for id, knob := range cm.config.Controls.Knobs {
+		scene.Knobs[id] = knob.Value
+	}
+
+	if cm.config.Scenes == nil {
+		cm.config.Scenes = make(map[string]SceneConfig)
+	}
+	cm.config.Scenes[name] = scene
+	cm.journal(journalScene, "", name)
+
+	log.Info().Str("scene", name).Int("volumes", len(volumes)).Msg("Saved scene")
+
+	cm.notifyLocked("scene.saved", map[string]interface{}{
+		"name": name,
+	})
+
+	// Schedule save
+	cm.SaveWithDebounce()
+}
+
+// DeleteScene removes a saved scene, returning false if it didn't exist
+func (cm *ConfigManager) DeleteScene(name string) bool {
+	cm.saveMutex.Lock()
+	defer cm.unlock()
+
+	if _, ok := cm.config.Scenes[name]; !ok {
+		return false
+	}
+	delete(cm.config.Scenes, name)
+	cm.journal(journalScene, "", name)
+
+	log.Info().Str("scene", name).Msg("Deleted scene")
+
+	cm.notifyLocked("scene.deleted", map[string]interface{}{
+		"name": name,
+	})
+
+	// Schedule save
+	cm.SaveWithDebounce()
+	return true
+}
+
+// GetScene returns a saved scene
+func (cm *ConfigManager) GetScene(name string) (SceneConfig, bool) {
+	cm.saveMutex.Lock()
+	defer cm.unlock()
+
+	scene, ok := cm.config.Scenes[name]
+	return scene, ok
+}
+
+// SceneNames returns the names of all saved scenes in sorted order
+func (cm *ConfigManager) SceneNames() []string {
+	cm.saveMutex.Lock()
+	defer cm.unlock()
+
+	names := make([]string, 0, len(cm.config.Scenes))
+	for name := range cm.config.Scenes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func containsSource(sources []Source, target Source) bool {
 	for _, source := range sources {
-		if source == target {
+		if source.Same(target) {
 			return true
 		}
 	}
@@ -300,11 +1060,17 @@ func containsSource(sources []Source, target Source) bool {
 }
 
 func filterSource(sources []Source, target Source) ([]Source, bool) {
+	return filterSourcesFunc(sources, target.Same)
+}
+
+// filterSourcesFunc returns the sources for which match returns false and
+// reports whether any were left out
+func filterSourcesFunc(sources []Source, match func(Source) bool) ([]Source, bool) {
 	filteredSources := make([]Source, 0, len(sources))
 	removed := false
 
 	for _, source := range sources {
-		if source == target {
+		if match(source) {
 			removed = true
 			continue
 		}
@@ -316,21 +1082,23 @@ func filterSource(sources []Source, target Source) ([]Source, bool) {
 
 // MigrateSourceBinaryName updates an existing source to include binary name for specificity
 func (cm *ConfigManager) MigrateSourceBinaryName(controlType string, controlId string, sourceType PulseAudioTargetType, sourceName string, binaryName string) {
-	// First unassign the old source (without binary name)
 	oldSource := Source{
 		Type:       sourceType,
 		Name:       sourceName,
 		BinaryName: "", // Legacy source without binary name
 	}
-	cm.UnassignSource(controlType, controlId, oldSource)
-
-	// Then assign the new source (with binary name)
 	newSource := Source{
 		Type:       sourceType,
 		Name:       sourceName,
 		BinaryName: binaryName,
 	}
-	cm.AssignSource(controlType, controlId, newSource)
+
+	// Migration is bookkeeping, not a user change, so keep it out of the undo history
+	// First unassign the old source (without binary name)
+	cm.unassignSource(controlType, controlId, oldSource, false)
+
+	// Then assign the new source (with binary name)
+	cm.assignSource(controlType, controlId, newSource, false)
 
 	log.Info().
 		Str("controlType", controlType).
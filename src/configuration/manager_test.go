@@ -0,0 +1,479 @@
+package configuration
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/activity"
+)
+
+// writeCounter tells how often a file was written. Saves replace the file by
+// renaming a new one over it, so every write changes the file identity.
+type writeCounter struct {
+	t    *testing.T
+	path string
+	last os.FileInfo
+}
+
+// count returns the number of writes since the previous call
+func (w *writeCounter) count() int {
+	w.t.Helper()
+	info, err := os.Stat(w.path)
+	if err != nil {
+		w.t.Fatal(err)
+	}
+	writes := 0
+	if w.last == nil || !os.SameFile(w.last, info) {
+		writes = 1
+	}
+	w.last = info
+	return writes
+}
+
+// newSavingManager returns a manager of the default configuration, as Load
+// prepares it, saved once to a temporary file. Its pending save is run when
+// the test ends.
+func newSavingManager(t *testing.T) (*ConfigManager, *writeCounter) {
+	t.Helper()
+	config, err := Prepare(GetDefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	cm := NewConfigManager(config, path)
+	t.Cleanup(func() { cm.Flush() })
+	cm.UpdateControlValue(activity.Midi(), "slider", "slider1", 10)
+	if err := cm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	writes := &writeCounter{t: t, path: path}
+	if writes.count() != 1 {
+		t.Fatal("initial configuration not written")
+	}
+	return cm, writes
+}
+
+func TestWiggledValueIsNotWritten(t *testing.T) {
+	cm, writes := newSavingManager(t)
+
+	// Within the debounce window the fader snaps back to the saved value
+	for _, value := range []int{11, 12, 11, 10, 9, 10} {
+		cm.UpdateControlValue(activity.Midi(), "slider", "slider1", value)
+	}
+	// Let the debounced save run
+	deadline := time.Now().Add(5 * time.Second)
+	for _, state := cm.Snapshot(); state.Dirty; _, state = cm.Snapshot() {
+		if time.Now().After(deadline) {
+			t.Fatal("debounced save didn't run")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := writes.count(); n != 0 {
+		t.Errorf("got %d writes of an unchanged configuration", n)
+	}
+
+	// Ending elsewhere writes once
+	for _, value := range []int{11, 12, 11, 10, 9, 20} {
+		cm.UpdateControlValue(activity.Midi(), "slider", "slider1", value)
+	}
+	if err := cm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if n := writes.count(); n != 1 {
+		t.Errorf("got %d writes of a changed configuration, want 1", n)
+	}
+	if _, state := cm.Snapshot(); state.Dirty {
+		t.Error("configuration still dirty after saving")
+	}
+}
+
+func TestSavedHashIsSeededFromDisk(t *testing.T) {
+	cm, writes := newSavingManager(t)
+	content, err := os.ReadFile(cm.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A new manager of the same file doesn't rewrite it
+	restarted := NewConfigManager(decodeConfig(t, string(content)), cm.Path())
+	restarted.UpdateControlValue(activity.Midi(), "slider", "slider1", 50)
+	restarted.UpdateControlValue(activity.Midi(), "slider", "slider1", 10)
+	if err := restarted.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if n := writes.count(); n != 0 {
+		t.Errorf("got %d writes after a restart without changes", n)
+	}
+}
+
+// fill sets every exported field reachable from value to a non-zero value,
+// with one element in each map and slice
+func fill(value reflect.Value, depth int) {
+	if depth == 0 {
+		return
+	}
+	switch value.Kind() {
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			if value.Type().Field(i).IsExported() {
+				fill(value.Field(i), depth-1)
+			}
+		}
+	case reflect.Map:
+		key := reflect.New(value.Type().Key()).Elem()
+		fill(key, depth-1)
+		element := reflect.New(value.Type().Elem()).Elem()
+		fill(element, depth-1)
+		value.Set(reflect.MakeMap(value.Type()))
+		value.SetMapIndex(key, element)
+	case reflect.Slice:
+		value.Set(reflect.MakeSlice(value.Type(), 1, 1))
+		fill(value.Index(0), depth-1)
+	case reflect.Pointer:
+		value.Set(reflect.New(value.Type().Elem()))
+		fill(value.Elem(), depth-1)
+	case reflect.String:
+		value.SetString("x")
+	case reflect.Bool:
+		value.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value.SetInt(1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		value.SetUint(1)
+	case reflect.Float32, reflect.Float64:
+		value.SetFloat(1)
+	}
+}
+
+// sharedMemory returns the paths of the maps, slices and pointers that a copy
+// shares with the original. Actions are replaced as a whole, never changed in
+// place, so they may be shared.
+func sharedMemory(original reflect.Value, copied reflect.Value, path string) []string {
+	var shared []string
+	switch original.Kind() {
+	case reflect.Struct:
+		if original.Type() == reflect.TypeOf(Action{}) {
+			return nil
+		}
+		for i := 0; i < original.NumField(); i++ {
+			if field := original.Type().Field(i); field.IsExported() {
+				shared = append(shared, sharedMemory(original.Field(i), copied.Field(i), path+"."+field.Name)...)
+			}
+		}
+	case reflect.Map:
+		if original.Len() > 0 && original.UnsafePointer() == copied.UnsafePointer() {
+			return []string{path}
+		}
+		for _, key := range original.MapKeys() {
+			if element := copied.MapIndex(key); element.IsValid() {
+				shared = append(shared, sharedMemory(original.MapIndex(key), element, fmt.Sprintf("%s[%v]", path, key))...)
+			}
+		}
+	case reflect.Slice:
+		if original.Len() > 0 && original.UnsafePointer() == copied.UnsafePointer() {
+			return []string{path}
+		}
+		for i := 0; i < min(original.Len(), copied.Len()); i++ {
+			shared = append(shared, sharedMemory(original.Index(i), copied.Index(i), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case reflect.Pointer:
+		if original.IsNil() || copied.IsNil() {
+			return nil
+		}
+		if original.Pointer() == copied.Pointer() {
+			return []string{path}
+		}
+		shared = sharedMemory(original.Elem(), copied.Elem(), path)
+	case reflect.Interface:
+		if !original.IsNil() && !copied.IsNil() {
+			shared = sharedMemory(original.Elem(), copied.Elem(), path)
+		}
+	}
+	return shared
+}
+
+func TestCloneSharesNothing(t *testing.T) {
+	var config Config
+	fill(reflect.ValueOf(&config).Elem(), 8)
+	clone := config.Clone()
+	for _, path := range sharedMemory(reflect.ValueOf(config), reflect.ValueOf(clone), "Config") {
+		t.Errorf("%s is shared with the clone", path)
+	}
+	if !reflect.DeepEqual(clone, config) {
+		t.Error("clone differs from the configuration")
+	}
+}
+
+func TestSnapshotIsIndependent(t *testing.T) {
+	cm := newTestManager(t)
+	cm.AssignSource("slider", "slider1", firefox)
+	cm.UpdateButtonState("rec", false)
+
+	snapshot := cm.GetConfigSnapshot()
+	slider := snapshot.Controls.Sliders["slider1"]
+	slider.Sources[0].Name = "Changed"
+	slider.Sources = append(slider.Sources, Source{Type: PlaybackStream, Name: "Spotify"})
+	snapshot.Controls.Sliders["slider1"] = slider
+	delete(snapshot.Controls.Knobs, "knob1")
+	*snapshot.Controls.Buttons["rec"].State = true
+
+	live := cm.GetConfigSnapshot()
+	if sources := live.Controls.Sliders["slider1"].Sources; len(sources) != 1 || sources[0] != firefox {
+		t.Errorf("live sources became %+v", sources)
+	}
+	if _, ok := live.Controls.Knobs["knob1"]; !ok {
+		t.Error("knob1 removed from the live configuration")
+	}
+	if state := live.Controls.Buttons["rec"].State; state == nil || *state {
+		t.Error("rec state changed through a snapshot")
+	}
+
+	// Later changes don't reach the snapshot either
+	cm.UnassignSource("slider", "slider1", firefox)
+	if sources := snapshot.Controls.Sliders["slider1"].Sources; len(sources) != 2 {
+		t.Errorf("snapshot sources became %+v", sources)
+	}
+}
+
+func TestSnapshotsDuringChanges(t *testing.T) {
+	cm := newTestManager(t)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 1; i <= 4; i++ {
+		id := fmt.Sprintf("slider%d", i)
+		source := Source{Type: PlaybackStream, Name: fmt.Sprintf("App %d", i)}
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for value := 0; ; value = (value + 1) % 101 {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				cm.AssignSource("slider", id, source)
+				cm.UpdateControlValue(activity.Midi(), "slider", id, value)
+				cm.UpdateButtonState("rec", value%2 == 0)
+				cm.UnassignSource("slider", id, source)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				snapshot := cm.GetConfigSnapshot()
+				for _, slider := range snapshot.Controls.Sliders {
+					for _, source := range slider.Sources {
+						_ = source.Name
+					}
+				}
+				if _, err := Marshal(&snapshot); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	time.Sleep(200 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+}
+
+func TestMutedRoundTrip(t *testing.T) {
+	config := decodeConfig(t, `
+version: 3
+activeProfile: default
+profiles:
+  default:
+    controls:
+      sliders:
+        slider1: {path: Group1/Slider, value: 40, muted: true, sources: []}
+        slider2: {path: Group2/Slider, value: 40, sources: []}
+      knobs:
+        knob1: {path: Group1/Knob, value: 40, muted: true, sources: []}
+      buttons:
+        mute1: {path: Group1/Mute, mode: toggle, muted: true, actions: []}
+`)
+	check := func(config Config) {
+		t.Helper()
+		controls := config.Controls
+		if !controls.Sliders["slider1"].Muted || controls.Sliders["slider2"].Muted {
+			t.Errorf("slider1 muted %v, slider2 muted %v", controls.Sliders["slider1"].Muted, controls.Sliders["slider2"].Muted)
+		}
+		if !controls.Knobs["knob1"].Muted {
+			t.Error("knob1 not muted")
+		}
+		if !controls.Buttons["mute1"].Muted {
+			t.Error("mute1 not muted")
+		}
+	}
+	check(config)
+
+	data, err := Marshal(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Unmuted controls leave the field out
+	if count := strings.Count(string(data), "muted: true"); count != 3 || strings.Contains(string(data), "muted: false") {
+		t.Errorf("saved muted state as\n%s", data)
+	}
+	check(decodeConfig(t, string(data)))
+}
+
+func TestSetControlMuted(t *testing.T) {
+	cm, _ := newSavingManager(t)
+	updates := make(chan map[string]interface{}, 8)
+	cm.Subscribe("control.muted.updated", func(data interface{}) {
+		updates <- data.(map[string]interface{})
+	})
+
+	for _, control := range []struct{ controlType, id string }{
+		{"slider", "slider1"},
+		{"knob", "knob2"},
+		{"button", "mute3"},
+	} {
+		if err := cm.SetControlMuted(control.controlType, control.id, true); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case update := <-updates:
+			if update["controlType"] != control.controlType || update["controlId"] != control.id || update["muted"] != true {
+				t.Errorf("got notification %+v for %s", update, control.id)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("muting %s not notified", control.id)
+		}
+	}
+
+	controls := cm.GetConfigSnapshot().Controls
+	if !controls.Sliders["slider1"].Muted || !controls.Knobs["knob2"].Muted || !controls.Buttons["mute3"].Muted {
+		t.Error("muted state not stored")
+	}
+	if _, state := cm.Snapshot(); !state.Dirty {
+		t.Error("muting doesn't schedule a save")
+	}
+
+	// Unchanged states notify nobody, unknown controls are refused
+	if err := cm.SetControlMuted("slider", "slider1", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.SetControlMuted("slider", "slider99", true); err == nil {
+		t.Error("unknown slider muted")
+	}
+	if err := cm.SetControlMuted("fader", "slider1", true); err == nil {
+		t.Error("unknown control type muted")
+	}
+	select {
+	case update := <-updates:
+		t.Errorf("got notification %+v without a change", update)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDuplicateValuesAreNotNotified(t *testing.T) {
+	cm, _ := newSavingManager(t)
+	updates := make(chan map[string]interface{}, 16)
+	cm.Subscribe("control.value.updated", func(data interface{}) {
+		updates <- data.(map[string]interface{})
+	})
+	// The initial value may still be queued, skip past a marker behind it
+	cm.Notify("control.value.updated", map[string]interface{}{"type": "slider", "id": "marker"})
+	for marked := false; !marked; {
+		select {
+		case data := <-updates:
+			marked = data["id"] == "marker"
+		case <-time.After(time.Second):
+			t.Fatal("marker not delivered")
+		}
+	}
+
+	// A jittery fader and a web client echoing its value back. Notifications
+	// of a control are coalesced, so each one is awaited.
+	notified := 0
+	for _, update := range []struct {
+		origin  activity.Origin
+		value   int
+		changed bool
+	}{
+		{activity.Midi(), 10, false}, // Saved already
+		{activity.Midi(), 40, true},
+		{activity.Midi(), 40, false},
+		{activity.Web("client"), 40, false},
+		{activity.Web("client"), 41, true},
+		{activity.Midi(), 41, false},
+		{activity.Web("client"), 41, false},
+	} {
+		if changed := cm.UpdateControlValue(update.origin, "slider", "slider1", update.value); changed != update.changed {
+			t.Errorf("value %d from %s reported changed %v", update.value, update.origin.Kind, changed)
+		}
+		select {
+		case data := <-updates:
+			notified++
+			if !update.changed {
+				t.Errorf("duplicate value %d from %s notified", update.value, update.origin.Kind)
+			} else if data["value"] != update.value || data["origin"] != update.origin {
+				t.Errorf("got notification %+v, want %d from %s", data, update.value, update.origin.Kind)
+			}
+		case <-time.After(20 * time.Millisecond):
+			if update.changed {
+				t.Errorf("value %d not notified", update.value)
+			}
+		}
+	}
+	if notified != 2 {
+		t.Errorf("got %d notifications, want 2", notified)
+	}
+
+	// An unchanged value schedules no save
+	if err := cm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	cm.UpdateControlValue(activity.Web("client"), "slider", "slider1", 41)
+	if _, state := cm.Snapshot(); state.Dirty {
+		t.Error("unchanged value marked the configuration dirty")
+	}
+}
+
+func TestAssignWideSourceByPolicy(t *testing.T) {
+	narrow := Source{Type: PlaybackStream, Name: "Firefox", BinaryName: "firefox"}
+	wide := Source{Type: PlaybackStream, Name: "Firefox"}
+
+	for _, test := range []struct {
+		policy DuplicatePolicy
+		kept   bool
+	}{
+		{"", true},
+		{DuplicateMove, false},
+		{DuplicateWarn, true},
+	} {
+		cm := newTestManager(t)
+		cm.config.DuplicateSources = test.policy
+		cm.AssignSource("slider", "slider1", narrow)
+		cm.AssignSource("slider", "slider2", wide)
+
+		sources := cm.GetConfigSnapshot().Controls.Sliders["slider1"].Sources
+		if kept := slices.Contains(sources, narrow); kept != test.kept {
+			t.Errorf("policy %q: narrow source kept is %v, want %v", test.policy, kept, test.kept)
+		}
+	}
+
+	// Without a policy the same source still moves
+	cm := newTestManager(t)
+	cm.AssignSource("slider", "slider1", narrow)
+	cm.AssignSource("slider", "slider2", narrow)
+	if sources := cm.GetConfigSnapshot().Controls.Sliders["slider1"].Sources; slices.Contains(sources, narrow) {
+		t.Errorf("same source not moved, slider1 has %+v", sources)
+	}
+}
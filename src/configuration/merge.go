@@ -0,0 +1,375 @@
+package configuration
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// journalKind is the kind of runtime change recorded in the journal
+type journalKind int
+
+// Kinds are replayed in this order, profiles first so that control changes
+// find the profile they belong to
+const (
+	journalProfile journalKind = iota
+	journalActiveProfile
+	journalValue
+	journalMuted
+	journalSources
+	journalSettings // Label and MIDI range of a slider or knob
+	journalButtonState
+	journalButtonActions
+	journalScene
+	journalAlias
+	journalCombinedSink
+	journalDeviceIdentity
+	journalDevicePorts
+	journalLastSeen
+	journalScheduleRun
+)
+
+// journalEntry records that something was changed at runtime since the last
+// save. Only what changed is recorded; the new value is taken from the live
+// configuration when the journal is replayed.
+type journalEntry struct {
+	kind        journalKind
+	profile     string // Profile of a control change
+	controlType string // slider, knob or button
+	id          string // Control id, scene name, alias key, device, profile or schedule name
+}
+
+// journal records a runtime change. Must be called with saveMutex held.
+func (cm *ConfigManager) journal(kind journalKind, controlType string, id string) {
+	cm.journalIn(cm.config.ActiveProfile, kind, controlType, id)
+}
+
+// journalIn records a runtime change to a control of any profile. Must be called with saveMutex held.
+func (cm *ConfigManager) journalIn(profile string, kind journalKind, controlType string, id string) {
+	if cm.changes == nil {
+		cm.changes = make(map[journalEntry]struct{})
+	}
+	cm.changes[journalEntry{
+		kind:        kind,
+		profile:     profile,
+		controlType: controlType,
+		id:          id,
+	}] = struct{}{}
+}
+
+// resetJournal marks the live configuration as saved. Must be called with saveMutex held.
+func (cm *ConfigManager) resetJournal() {
+	cm.changes = nil
+	cm.base = cm.config.Clone()
+}
+
+// mergeExternal replaces the live configuration by a version changed on disk
+// and re-applies the runtime changes made since the last save. Where both
+// changed the sources or actions of the same control, the file wins.
+// Must be called with saveMutex held.
+func (cm *ConfigManager) mergeExternal(content []byte) error {
+	result := LoadResult{Path: cm.configPath, SourcePath: cm.configPath, Content: content}
+	if err := result.decode(); err != nil {
+		return err
+	}
+	theirs := result.Config
+
+	conflicts := replayJournal(cm.changes, &cm.base, cm.config, &theirs)
+	*cm.config = theirs
+	cm.savedHash = sha256.Sum256(content)
+	cm.document = parseDocument(content)
+	cm.indent = detectIndent(content)
+
+	// Undo entries refer to the replaced configuration
+	cm.history = history{}
+
+	for _, conflict := range conflicts {
+		log.Warn().Str("path", cm.configPath).Msgf("Kept the external change to %s, discarding the runtime change", conflict)
+	}
+	log.Info().Str("path", cm.configPath).Int("changes", len(cm.changes)).Int("conflicts", len(conflicts)).Msg("Merged configuration changed on disk")
+
+	cm.notifyLocked("config.merged", map[string]interface{}{
+		"conflicts": conflicts,
+	})
+	return nil
+}
+
+// replayJournal applies the journaled changes of mine on top of theirs. base
+// is the configuration as last saved, both mine and theirs descend from it.
+// It returns descriptions of the changes that conflicted.
+func replayJournal(changes map[journalEntry]struct{}, base *Config, mine *Config, theirs *Config) []string {
+	entries := make([]journalEntry, 0, len(changes))
+	for entry := range changes {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.kind != b.kind {
+			return a.kind < b.kind
+		}
+		if a.profile != b.profile {
+			return a.profile < b.profile
+		}
+		if a.controlType != b.controlType {
+			return a.controlType < b.controlType
+		}
+		return a.id < b.id
+	})
+
+	var conflicts []string
+	for _, entry := range entries {
+		switch entry.kind {
+		case journalProfile:
+			replayProfile(entry.id, mine, theirs)
+		case journalActiveProfile:
+			replayActiveProfile(mine, theirs)
+		case journalScene:
+			if scene, ok := mine.Scenes[entry.id]; ok {
+				if theirs.Scenes == nil {
+					theirs.Scenes = make(map[string]SceneConfig)
+				}
+				theirs.Scenes[entry.id] = scene
+			} else {
+				delete(theirs.Scenes, entry.id)
+			}
+		case journalAlias:
+			if alias, ok := mine.Aliases[entry.id]; ok {
+				if theirs.Aliases == nil {
+					theirs.Aliases = make(map[string]string)
+				}
+				theirs.Aliases[entry.id] = alias
+			} else {
+				delete(theirs.Aliases, entry.id)
+			}
+		case journalCombinedSink:
+			if sink, ok := mine.CombinedSinks[entry.id]; ok {
+				if theirs.CombinedSinks == nil {
+					theirs.CombinedSinks = make(map[string]CombinedSinkConfig)
+				}
+				theirs.CombinedSinks[entry.id] = sink
+			} else {
+				delete(theirs.CombinedSinks, entry.id)
+			}
+		case journalDeviceIdentity:
+			replayDeviceIdentity(entry.id, mine, theirs)
+		case journalDevicePorts:
+			replayDevicePorts(entry.id, base, mine, theirs)
+		case journalScheduleRun:
+			replayScheduleRun(entry.id, mine, theirs)
+		default:
+			if conflict := replayControl(entry, base, mine, theirs); conflict != "" {
+				conflicts = append(conflicts, conflict)
+			}
+		}
+	}
+	return conflicts
+}
+
+// controlsOf returns the controls of a profile. The active profile's controls
+// live at the top level.
+func controlsOf(config *Config, profile string) (Controls, bool) {
+	if profile == config.ActiveProfile {
+		return config.Controls, true
+	}
+	p, ok := config.Profiles[profile]
+	return p.Controls, ok
+}
+
+// replayProfile re-applies the creation or deletion of a profile
+func replayProfile(name string, mine *Config, theirs *Config) {
+	controls, inMine := controlsOf(mine, name)
+	_, inTheirs := theirs.Profiles[name]
+	switch {
+	case inMine && !inTheirs:
+		theirs.Profiles[name] = Profile{Controls: controls.Clone()}
+	case !inMine && inTheirs && name != theirs.ActiveProfile:
+		delete(theirs.Profiles, name)
+	}
+}
+
+// replayActiveProfile re-applies a profile switch
+func replayActiveProfile(mine *Config, theirs *Config) {
+	name := mine.ActiveProfile
+	profile, ok := theirs.Profiles[name]
+	if !ok || name == theirs.ActiveProfile {
+		return
+	}
+	theirs.Profiles[theirs.ActiveProfile] = Profile{Controls: theirs.Controls}
+	theirs.Controls = profile.Controls
+	theirs.ActiveProfile = name
+}
+
+// replayDeviceIdentity re-applies a learned device identity unless the file
+// configures one itself
+func replayDeviceIdentity(name string, mine *Config, theirs *Config) {
+	device, ok := mine.DeviceByName(name)
+	if !ok && mine.Device.Name == name {
+		device, ok = mine.Device, true
+	}
+	if !ok {
+		return
+	}
+	if len(theirs.Devices) == 0 {
+		if theirs.Device.Name == name && theirs.Device.Identity.IsZero() {
+			theirs.Device.Identity = device.Identity
+		}
+		return
+	}
+	for i := range theirs.Devices {
+		if theirs.Devices[i].Name == name && theirs.Devices[i].Identity.IsZero() {
+			theirs.Devices[i].Identity = device.Identity
+		}
+	}
+}
+
+// replayDevicePorts re-applies detected ports of a device, unless the ports
+// were changed in the file as well
+func replayDevicePorts(name string, base *Config, mine *Config, theirs *Config) {
+	device, ok := deviceIn(mine, name)
+	if !ok {
+		return
+	}
+	original, _ := deviceIn(base, name)
+	if len(theirs.Devices) == 0 {
+		if theirs.Device.Name == name && theirs.Device.InPort == original.InPort && theirs.Device.OutPort == original.OutPort {
+			theirs.Device.InPort, theirs.Device.OutPort = device.InPort, device.OutPort
+		}
+		return
+	}
+	for i := range theirs.Devices {
+		if theirs.Devices[i].Name == name && theirs.Devices[i].InPort == original.InPort && theirs.Devices[i].OutPort == original.OutPort {
+			theirs.Devices[i].InPort, theirs.Devices[i].OutPort = device.InPort, device.OutPort
+		}
+	}
+}
+
+// deviceIn returns a device of a configuration in either format
+func deviceIn(config *Config, name string) (DeviceConfig, bool) {
+	if device, ok := config.DeviceByName(name); ok {
+		return device, true
+	}
+	if config.Device.Name == name {
+		return config.Device, true
+	}
+	return DeviceConfig{}, false
+}
+
+// replayControl re-applies a change to one field of a control and returns a
+// description of the conflict if the file changed the same list
+func replayControl(entry journalEntry, base *Config, mine *Config, theirs *Config) string {
+	mineControls, ok := controlsOf(mine, entry.profile)
+	if !ok {
+		return ""
+	}
+	theirControls, ok := controlsOf(theirs, entry.profile)
+	if !ok {
+		// The profile was deleted on disk
+		return ""
+	}
+	baseControls, _ := controlsOf(base, entry.profile)
+	conflict := fmt.Sprintf("%s %s in profile %s", entry.controlType, entry.id, entry.profile)
+
+	switch entry.controlType {
+	case "slider":
+		slider, ok := mineControls.Sliders[entry.id]
+		if !ok {
+			return ""
+		}
+		their, exists := theirControls.Sliders[entry.id]
+		if !exists {
+			theirControls.Sliders[entry.id] = slider
+			return ""
+		}
+		switch entry.kind {
+		case journalValue:
+			their.Value = slider.Value
+		case journalMuted:
+			their.Muted = slider.Muted
+		case journalSources:
+			original := baseControls.Sliders[entry.id].Sources
+			if !sameSources(their.Sources, original) && !sameSources(their.Sources, slider.Sources) {
+				return "sources of " + conflict
+			}
+			their.Sources = slider.Sources
+		case journalSettings:
+			their.Label = slider.Label
+			their.MinValue = slider.MinValue
+			their.MaxValue = slider.MaxValue
+		case journalLastSeen:
+			their.Sources = mergeLastSeen(their.Sources, slider.Sources)
+		}
+		theirControls.Sliders[entry.id] = their
+	case "knob":
+		knob, ok := mineControls.Knobs[entry.id]
+		if !ok {
+			return ""
+		}
+		their, exists := theirControls.Knobs[entry.id]
+		if !exists {
+			theirControls.Knobs[entry.id] = knob
+			return ""
+		}
+		switch entry.kind {
+		case journalValue:
+			their.Value = knob.Value
+		case journalMuted:
+			their.Muted = knob.Muted
+		case journalSources:
+			original := baseControls.Knobs[entry.id].Sources
+			if !sameSources(their.Sources, original) && !sameSources(their.Sources, knob.Sources) {
+				return "sources of " + conflict
+			}
+			their.Sources = knob.Sources
+		case journalSettings:
+			their.Label = knob.Label
+			their.MinValue = knob.MinValue
+			their.MaxValue = knob.MaxValue
+		case journalLastSeen:
+			their.Sources = mergeLastSeen(their.Sources, knob.Sources)
+		}
+		theirControls.Knobs[entry.id] = their
+	case "button":
+		button, ok := mineControls.Buttons[entry.id]
+		if !ok {
+			return ""
+		}
+		their, exists := theirControls.Buttons[entry.id]
+		if !exists {
+			theirControls.Buttons[entry.id] = button
+			return ""
+		}
+		switch entry.kind {
+		case journalButtonState:
+			their.State = button.State
+		case journalMuted:
+			their.Muted = button.Muted
+		case journalButtonActions:
+			original := baseControls.Buttons[entry.id].Actions
+			if !sameActions(their.Actions, original) && !sameActions(their.Actions, button.Actions) {
+				return "actions of " + conflict
+			}
+			their.Actions = button.Actions
+		}
+		theirControls.Buttons[entry.id] = their
+	}
+	return ""
+}
+
+// sameActions compares action lists by their YAML form, targets are pointers
+func sameActions(a []Action, b []Action) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	left, err := yaml.Marshal(a)
+	if err != nil {
+		return false
+	}
+	right, err := yaml.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(left, right)
+}
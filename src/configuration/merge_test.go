@@ -0,0 +1,238 @@
+package configuration
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/activity"
+)
+
+var (
+	firefox = Source{Type: PlaybackStream, Name: "Firefox"}
+	spotify = Source{Type: PlaybackStream, Name: "Spotify"}
+	mpv     = Source{Type: PlaybackStream, Name: "mpv"}
+)
+
+// editFile changes the saved configuration like a user editing the file
+func editFile(t *testing.T, path string, edit func(config *Config)) {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := decodeConfig(t, string(content))
+	edit(&config)
+	data, err := Marshal(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// savedFile returns the configuration in the file
+func savedFile(t *testing.T, path string) Config {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decodeConfig(t, string(content))
+}
+
+// mergedConflicts returns the conflicts of the next config.merged notification
+func mergedConflicts(t *testing.T, merged chan []string) []string {
+	t.Helper()
+	select {
+	case conflicts := <-merged:
+		return conflicts
+	case <-time.After(time.Second):
+		t.Fatal("merge not notified")
+		return nil
+	}
+}
+
+// subscribeMerged returns a channel receiving the conflicts of each merge
+func subscribeMerged(cm *ConfigManager) chan []string {
+	merged := make(chan []string, 4)
+	cm.Subscribe("config.merged", func(data interface{}) {
+		conflicts, _ := data.(map[string]interface{})["conflicts"].([]string)
+		merged <- conflicts
+	})
+	return merged
+}
+
+func TestSaveMergesExternalEdit(t *testing.T) {
+	cm, _ := newSavingManager(t)
+	merged := subscribeMerged(cm)
+
+	// The file is edited, then a fader moves before the edit is reloaded
+	editFile(t, cm.Path(), func(config *Config) {
+		slider := config.Controls.Sliders["slider2"]
+		slider.Label = "Music"
+		slider.Sources = []Source{spotify}
+		config.Controls.Sliders["slider2"] = slider
+	})
+	cm.UpdateControlValue(activity.Midi(), "slider", "slider1", 70)
+	cm.AssignSource("knob", "knob1", firefox)
+	if err := cm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if conflicts := mergedConflicts(t, merged); len(conflicts) != 0 {
+		t.Errorf("got conflicts %v", conflicts)
+	}
+
+	for name, config := range map[string]Config{"live": cm.GetConfigSnapshot(), "saved": savedFile(t, cm.Path())} {
+		controls := config.Controls
+		if slider := controls.Sliders["slider2"]; slider.Label != "Music" || !sameSources(slider.Sources, []Source{spotify}) {
+			t.Errorf("%s: external edit of slider2 lost: %+v", name, slider)
+		}
+		if value := controls.Sliders["slider1"].Value; value != 70 {
+			t.Errorf("%s: slider1 is %d, want the runtime value 70", name, value)
+		}
+		if sources := controls.Knobs["knob1"].Sources; !sameSources(sources, []Source{firefox}) {
+			t.Errorf("%s: runtime assignment of knob1 lost: %+v", name, sources)
+		}
+	}
+}
+
+func TestExternalSourcesWinConflicts(t *testing.T) {
+	cm, _ := newSavingManager(t)
+	merged := subscribeMerged(cm)
+
+	cm.AssignSource("slider", "slider1", firefox)
+	cm.UpdateControlValue(activity.Midi(), "slider", "slider1", 30)
+	editFile(t, cm.Path(), func(config *Config) {
+		slider := config.Controls.Sliders["slider1"]
+		slider.Sources = []Source{mpv}
+		config.Controls.Sliders["slider1"] = slider
+	})
+	if err := cm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if conflicts := mergedConflicts(t, merged); len(conflicts) != 1 || conflicts[0] != "sources of slider slider1 in profile default" {
+		t.Errorf("got conflicts %q", conflicts)
+	}
+
+	slider := savedFile(t, cm.Path()).Controls.Sliders["slider1"]
+	if !sameSources(slider.Sources, []Source{mpv}) {
+		t.Errorf("saved sources %+v, want the external ones", slider.Sources)
+	}
+	// The value of the same control doesn't conflict
+	if slider.Value != 30 {
+		t.Errorf("saved value %d, want the runtime value 30", slider.Value)
+	}
+}
+
+func TestSameSourcesOnBothSidesDontConflict(t *testing.T) {
+	cm, _ := newSavingManager(t)
+	merged := subscribeMerged(cm)
+
+	cm.AssignSource("slider", "slider1", firefox)
+	editFile(t, cm.Path(), func(config *Config) {
+		slider := config.Controls.Sliders["slider1"]
+		slider.Sources = []Source{firefox}
+		config.Controls.Sliders["slider1"] = slider
+	})
+	if err := cm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if conflicts := mergedConflicts(t, merged); len(conflicts) != 0 {
+		t.Errorf("got conflicts %v", conflicts)
+	}
+}
+
+func TestMergeAcrossSaves(t *testing.T) {
+	cm, _ := newSavingManager(t)
+	merged := subscribeMerged(cm)
+
+	// First round: edit, change, save
+	editFile(t, cm.Path(), func(config *Config) {
+		slider := config.Controls.Sliders["slider2"]
+		slider.Label = "Music"
+		config.Controls.Sliders["slider2"] = slider
+	})
+	cm.UpdateControlValue(activity.Midi(), "slider", "slider1", 20)
+	if err := cm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	mergedConflicts(t, merged)
+
+	// A change saved without an edit in between merges nothing
+	cm.UpdateControlValue(activity.Midi(), "slider", "slider1", 25)
+	if err := cm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-merged:
+		t.Error("merged without an external edit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Second round: two edits before the save, the last one counts. Only
+	// the change since the last save is replayed.
+	editFile(t, cm.Path(), func(config *Config) {
+		slider := config.Controls.Sliders["slider1"]
+		slider.Value = 90
+		slider.Label = "Browser"
+		config.Controls.Sliders["slider1"] = slider
+	})
+	cm.AssignSource("slider", "slider3", spotify)
+	editFile(t, cm.Path(), func(config *Config) {
+		slider := config.Controls.Sliders["slider1"]
+		slider.Label = "Web"
+		config.Controls.Sliders["slider1"] = slider
+	})
+	if err := cm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	mergedConflicts(t, merged)
+
+	controls := savedFile(t, cm.Path()).Controls
+	if slider := controls.Sliders["slider1"]; slider.Label != "Web" || slider.Value != 90 {
+		t.Errorf("slider1 saved as %+v, want the edited label and value", slider)
+	}
+	if label := controls.Sliders["slider2"].Label; label != "Music" {
+		t.Errorf("first edit lost, slider2 label is %q", label)
+	}
+	if sources := controls.Sliders["slider3"].Sources; !sameSources(sources, []Source{spotify}) {
+		t.Errorf("slider3 sources %+v", sources)
+	}
+}
+
+func TestUnparsableEditIsNotOverwritten(t *testing.T) {
+	cm, _ := newSavingManager(t)
+	valid, err := os.ReadFile(cm.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broken := []byte("controls: [unclosed\n")
+	if err := os.WriteFile(cm.Path(), broken, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cm.UpdateControlValue(activity.Midi(), "slider", "slider1", 60)
+	if err := cm.Flush(); err == nil {
+		t.Fatal("saved over a file that can't be merged")
+	}
+	if content, _ := os.ReadFile(cm.Path()); string(content) != string(broken) {
+		t.Errorf("file became\n%s", content)
+	}
+	if _, state := cm.Snapshot(); !state.Dirty {
+		t.Error("runtime change dropped")
+	}
+
+	// Once the file is restored the change is saved
+	if err := os.WriteFile(cm.Path(), valid, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cm.UpdateControlValue(activity.Midi(), "slider", "slider1", 61)
+	if err := cm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if value := savedFile(t, cm.Path()).Controls.Sliders["slider1"].Value; value != 61 {
+		t.Errorf("slider1 saved as %d, want 61", value)
+	}
+}
@@ -0,0 +1,133 @@
+package configuration
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CurrentVersion is the configuration schema version written on save
+const CurrentVersion = 3
+
+// document is a configuration file decoded without a schema, which is what
+// migrations operate on
+type document map[string]interface{}
+
+// migration upgrades a document from version From to From+1
+type migration struct {
+	From        int
+	Description string
+	Migrate     func(doc document) (document, error)
+}
+
+// migrations must be ordered and cover every version below CurrentVersion
+var migrations = []migration{
+	{From: 0, Description: "convert legacy rules to controls", Migrate: migrateLegacyRules},
+	{From: 1, Description: "move the single device into the device list", Migrate: migrateDeviceList},
+	{From: 2, Description: "move controls into the default profile", Migrate: migrateProfiles},
+}
+
+// documentVersion returns the schema version of a document. Files written
+// before the version field existed are recognized by their top-level keys.
+func documentVersion(doc document) (int, error) {
+	if value, ok := doc["version"]; ok {
+		version, ok := value.(int)
+		if !ok || version < 0 {
+			return 0, fmt.Errorf("invalid configuration version %v", value)
+		}
+		return version, nil
+	}
+	for _, key := range []string{"device", "devices", "controls", "profiles"} {
+		if _, ok := doc[key]; ok {
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// migrateDocument applies all migrations needed to bring a document of the
+// given version up to CurrentVersion and returns their descriptions
+func migrateDocument(doc document, version int) (document, []string, error) {
+	if version > CurrentVersion {
+		return nil, nil, fmt.Errorf("configuration version %d is newer than the supported version %d, please upgrade pulsekontrol", version, CurrentVersion)
+	}
+	var applied []string
+	for _, m := range migrations {
+		if m.From < version {
+			continue
+		}
+		migrated, err := m.Migrate(doc)
+		if err != nil {
+			return nil, nil, fmt.Errorf("migration from version %d (%s) failed: %w", m.From, m.Description, err)
+		}
+		doc = migrated
+		doc["version"] = m.From + 1
+		applied = append(applied, fmt.Sprintf("v%d to v%d: %s", m.From, m.From+1, m.Description))
+	}
+	return doc, applied, nil
+}
+
+// backupConfig copies a configuration file next to itself before it is
+// rewritten by a migration
+func backupConfig(path string, content []byte, version int) (string, error) {
+	backupPath := fmt.Sprintf("%s.v%d-%s.bak", path, version, time.Now().Format("20060102-150405"))
+	if err := writeFileDurable(backupPath, content); err != nil {
+		return "", err
+	}
+	return backupPath, nil
+}
+
+// migrateLegacyRules converts the original midiDevices/rules format
+func migrateLegacyRules(doc document) (document, error) {
+	var legacyConfig LegacyConfig
+	if err := remarshal(doc, &legacyConfig); err != nil {
+		return nil, err
+	}
+	config := convertLegacyConfig(legacyConfig)
+
+	migrated := document{}
+	if err := remarshal(struct {
+		Device   DeviceConfig `yaml:"device"`
+		Controls Controls     `yaml:"controls"`
+	}{config.PrimaryDevice(), config.Controls}, &migrated); err != nil {
+		return nil, err
+	}
+	return migrated, nil
+}
+
+// migrateDeviceList replaces the device entry by a one-element devices list
+func migrateDeviceList(doc document) (document, error) {
+	device, ok := doc["device"]
+	if !ok {
+		return doc, nil
+	}
+	if _, exists := doc["devices"]; !exists {
+		doc["devices"] = []interface{}{device}
+	}
+	delete(doc, "device")
+	return doc, nil
+}
+
+// migrateProfiles moves the top-level controls into the default profile
+func migrateProfiles(doc document) (document, error) {
+	controls, ok := doc["controls"]
+	if !ok {
+		return doc, nil
+	}
+	if _, exists := doc["profiles"]; !exists {
+		doc["profiles"] = document{DefaultProfile: document{"controls": controls}}
+		doc["activeProfile"] = DefaultProfile
+		delete(doc, "controls")
+	}
+	return doc, nil
+}
+
+// remarshal converts between representations by round-tripping through YAML
+func remarshal(in interface{}, out interface{}) error {
+	data, err := yaml.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(data, out)
+}
@@ -0,0 +1,241 @@
+package configuration
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Fixtures of each schema version, all describing a nanoKONTROL2 whose first
+// slider controls Firefox
+const (
+	configV0 = `
+midiDevices:
+  - name: nano
+    type: KorgNanoKontrol2
+    midiInName: nano in
+    midiOutName: nano out
+rules:
+  - midiMessage: {deviceName: nano, deviceControlPath: Group1/Slider, type: ControlChange}
+    actions:
+      - type: SetVolume
+        target: {type: PlaybackStream, name: Firefox}
+`
+	configV1 = `
+device:
+  name: nano
+  type: KorgNanoKontrol2
+  inPort: nano in
+  outPort: nano out
+controls:
+  sliders:
+    slider1:
+      path: Group1/Slider
+      value: 40
+      sources:
+        - {type: PlaybackStream, name: Firefox}
+`
+	configV2 = `
+version: 2
+devices:
+  - name: nano
+    type: KorgNanoKontrol2
+    inPort: nano in
+    outPort: nano out
+controls:
+  sliders:
+    slider1:
+      path: Group1/Slider
+      value: 40
+      sources:
+        - {type: PlaybackStream, name: Firefox}
+`
+)
+
+// parseFixture decodes a fixture without a schema, like the migrations see it
+func parseFixture(t *testing.T, content string) document {
+	t.Helper()
+	doc := document{}
+	if err := yaml.Unmarshal([]byte(content), &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// firefoxOnSlider1 fails unless slider1 of the configuration controls Firefox
+func firefoxOnSlider1(t *testing.T, config Config) {
+	t.Helper()
+	sources := config.Controls.Sliders["slider1"].Sources
+	if len(sources) != 1 || sources[0] != (Source{Type: PlaybackStream, Name: "Firefox"}) {
+		t.Errorf("slider1 has sources %+v, want Firefox", sources)
+	}
+	if len(config.Devices) != 1 || config.Devices[0].Name != "nano" || config.Devices[0].InPort != "nano in" {
+		t.Errorf("got devices %+v", config.Devices)
+	}
+}
+
+func TestDocumentVersion(t *testing.T) {
+	for _, test := range []struct {
+		content string
+		version int
+	}{
+		{configV0, 0},
+		{configV1, 1},
+		{configV2, 2},
+		{"version: 3\n", 3},
+		{"{}\n", 0},
+	} {
+		version, err := documentVersion(parseFixture(t, test.content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != test.version {
+			t.Errorf("version %d for\n%s\nwant %d", version, test.content, test.version)
+		}
+	}
+	if _, err := documentVersion(document{"version": "three"}); err == nil {
+		t.Error("invalid version accepted")
+	}
+}
+
+func TestMigrateLegacyRules(t *testing.T) {
+	doc, err := migrateLegacyRules(parseFixture(t, configV0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := doc["rules"]; ok {
+		t.Error("legacy rules kept")
+	}
+	var migrated struct {
+		Device   DeviceConfig `yaml:"device"`
+		Controls Controls     `yaml:"controls"`
+	}
+	if err := remarshal(doc, &migrated); err != nil {
+		t.Fatal(err)
+	}
+	if migrated.Device.Name != "nano" || migrated.Device.InPort != "nano in" {
+		t.Errorf("device is %+v", migrated.Device)
+	}
+	firefoxOnSlider1(t, Config{Devices: []DeviceConfig{migrated.Device}, Controls: migrated.Controls})
+}
+
+func TestMigrateDeviceList(t *testing.T) {
+	doc, err := migrateDeviceList(parseFixture(t, configV1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := doc["device"]; ok {
+		t.Error("single device kept")
+	}
+	devices, ok := doc["devices"].([]interface{})
+	if !ok || len(devices) != 1 {
+		t.Fatalf("devices are %#v", doc["devices"])
+	}
+
+	// Files with both keep their device list
+	doc = parseFixture(t, configV2)
+	doc["device"] = map[string]interface{}{"name": "other"}
+	doc, err = migrateDeviceList(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var migrated struct {
+		Devices []DeviceConfig `yaml:"devices"`
+	}
+	if err := remarshal(doc, &migrated); err != nil {
+		t.Fatal(err)
+	}
+	if len(migrated.Devices) != 1 || migrated.Devices[0].Name != "nano" {
+		t.Errorf("devices are %+v", migrated.Devices)
+	}
+}
+
+func TestMigrateProfiles(t *testing.T) {
+	doc, err := migrateProfiles(parseFixture(t, configV2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := doc["controls"]; ok {
+		t.Error("top-level controls kept")
+	}
+	if doc["activeProfile"] != DefaultProfile {
+		t.Errorf("active profile is %v", doc["activeProfile"])
+	}
+	var migrated struct {
+		Profiles map[string]Profile `yaml:"profiles"`
+	}
+	if err := remarshal(doc, &migrated); err != nil {
+		t.Fatal(err)
+	}
+	if slider := migrated.Profiles[DefaultProfile].Controls.Sliders["slider1"]; slider.Value != 40 || len(slider.Sources) != 1 {
+		t.Errorf("slider1 of the default profile is %+v", slider)
+	}
+}
+
+func TestMigrateDocument(t *testing.T) {
+	for _, test := range []struct {
+		content    string
+		migrations int
+	}{
+		{configV0, 3},
+		{configV1, 2},
+		{configV2, 1},
+	} {
+		result := LoadResult{Content: []byte(test.content)}
+		if err := result.decode(); err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Migrations) != test.migrations {
+			t.Errorf("applied %q from version %d, want %d migrations", result.Migrations, result.FromVersion, test.migrations)
+		}
+		firefoxOnSlider1(t, result.Config)
+		if result.Config.Version != CurrentVersion {
+			t.Errorf("migrated to version %d", result.Config.Version)
+		}
+	}
+}
+
+func TestFutureVersionIsRefused(t *testing.T) {
+	result := LoadResult{Content: []byte("version: 99\n")}
+	err := result.decode()
+	if err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("got %v, want an error about a newer version", err)
+	}
+}
+
+func TestLoadBacksUpBeforeMigration(t *testing.T) {
+	isolatePaths(t, t.TempDir())
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte(configV1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	SetPath(path)
+
+	config, _, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	firefoxOnSlider1(t, config)
+
+	backups, err := filepath.Glob(filepath.Join(dir, "config.yaml.v1-*.bak"))
+	if err != nil || len(backups) != 1 {
+		t.Fatalf("got backups %v, want one of version 1", backups)
+	}
+	if content, _ := os.ReadFile(backups[0]); string(content) != configV1 {
+		t.Errorf("backup holds\n%s", content)
+	}
+
+	// Saved in the newest version
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version, _ := documentVersion(parseFixture(t, string(content))); version != CurrentVersion {
+		t.Errorf("saved as version %d, want %d", version, CurrentVersion)
+	}
+	firefoxOnSlider1(t, decodeConfig(t, string(content)))
+}
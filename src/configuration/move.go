@@ -0,0 +1,145 @@
+package configuration
+
+import (
+	"fmt"
+	"slices"
+)
+
+// assignmentSet is what moves between controls with MoveControlSources: the
+// sources with the settings that belong to them
+type assignmentSet struct {
+	Sources  []Source
+	Label    string
+	MinValue uint8
+	MaxValue uint8
+	Muted    bool
+	Value    int
+}
+
+// assignmentSet returns the assignment set of a slider or knob. Must be called with saveMutex held.
+func (cm *ConfigManager) assignmentSet(controlType string, controlId string) (assignmentSet, error) {
+	switch controlType {
+	case "slider":
+		slider, ok := cm.config.Controls.Sliders[controlId]
+		if !ok {
+			return assignmentSet{}, fmt.Errorf("slider %s not found", controlId)
+		}
+		return assignmentSet{
+			Sources:  slices.Clone(slider.Sources),
+			Label:    slider.Label,
+			MinValue: slider.MinValue,
+			MaxValue: slider.MaxValue,
+			Muted:    slider.Muted,
+			Value:    slider.Value,
+		}, nil
+	case "knob":
+		knob, ok := cm.config.Controls.Knobs[controlId]
+		if !ok {
+			return assignmentSet{}, fmt.Errorf("knob %s not found", controlId)
+		}
+		return assignmentSet{
+			Sources:  slices.Clone(knob.Sources),
+			Label:    knob.Label,
+			MinValue: knob.MinValue,
+			MaxValue: knob.MaxValue,
+			Muted:    knob.Muted,
+			Value:    knob.Value,
+		}, nil
+	}
+	return assignmentSet{}, fmt.Errorf("unknown control type %s", controlType)
+}
+
+// setAssignmentSet replaces the assignment set of an existing slider or knob,
+// its value only if withValue is set. Must be called with saveMutex held.
+func (cm *ConfigManager) setAssignmentSet(controlType string, controlId string, set assignmentSet, withValue bool) {
+	switch controlType {
+	case "slider":
+		slider := cm.config.Controls.Sliders[controlId]
+		slider.Sources = set.Sources
+		slider.Label = set.Label
+		slider.MinValue = set.MinValue
+		slider.MaxValue = set.MaxValue
+		slider.Muted = set.Muted
+		if withValue {
+			slider.Value = set.Value
+		}
+		cm.config.Controls.Sliders[controlId] = slider
+	case "knob":
+		knob := cm.config.Controls.Knobs[controlId]
+		knob.Sources = set.Sources
+		knob.Label = set.Label
+		knob.MinValue = set.MinValue
+		knob.MaxValue = set.MaxValue
+		knob.Muted = set.Muted
+		if withValue {
+			knob.Value = set.Value
+		}
+		cm.config.Controls.Knobs[controlId] = knob
+	}
+	cm.journal(journalSources, controlType, controlId)
+	cm.journal(journalSettings, controlType, controlId)
+	cm.journal(journalMuted, controlType, controlId)
+	if withValue {
+		cm.journal(journalValue, controlType, controlId)
+	}
+}
+
+// MoveControlSources moves the sources of a slider or knob, with their label,
+// MIDI range and muting, to another slider or knob, replacing what that one
+// had. With swap the two controls exchange them instead. With moveValues the
+// values go along, so the sources keep their volume; otherwise each control
+// keeps its value, which is the position of the physical control, and the
+// sources take the volume of their new control.
+func (cm *ConfigManager) MoveControlSources(fromType string, fromId string, toType string, toId string, swap bool, moveValues bool) error {
+	cm.saveMutex.Lock()
+	defer cm.unlock()
+
+	if fromType == toType && fromId == toId {
+		return fmt.Errorf("cannot move %s onto itself", fromId)
+	}
+	from, err := cm.assignmentSet(fromType, fromId)
+	if err != nil {
+		return err
+	}
+	to, err := cm.assignmentSet(toType, toId)
+	if err != nil {
+		return err
+	}
+
+	before := cm.controlStates()
+	cm.setAssignmentSet(toType, toId, from, moveValues)
+	description := fmt.Sprintf("move %s to %s", fromId, toId)
+	if swap {
+		cm.setAssignmentSet(fromType, fromId, to, moveValues)
+		description = fmt.Sprintf("swap %s and %s", fromId, toId)
+	} else {
+		// The value stays as the position of the physical control
+		cm.setAssignmentSet(fromType, fromId, assignmentSet{Sources: []Source{}}, false)
+	}
+	cm.recordChange(description, before)
+
+	// One notification for both controls, so that rules and volumes are updated once
+	var controls []map[string]interface{}
+	for _, key := range []controlKey{{fromType, fromId}, {toType, toId}} {
+		set, _ := cm.assignmentSet(key.controlType, key.controlID)
+		controls = append(controls, map[string]interface{}{
+			"controlType": key.controlType,
+			"controlId":   key.controlID,
+			"value":       set.Value,
+			"muted":       set.Muted,
+		})
+	}
+	cm.notifyLocked("control.moved", map[string]interface{}{
+		"fromType":   fromType,
+		"fromId":     fromId,
+		"toType":     toType,
+		"toId":       toId,
+		"swap":       swap,
+		"moveValues": moveValues,
+		"controls":   controls,
+	})
+
+	// Schedule save
+	cm.SaveWithDebounce()
+	return nil
+}
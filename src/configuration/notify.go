@@ -0,0 +1,247 @@
+package configuration
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/supervise"
+)
+
+// notifyQueueSize bounds the pending notifications of a topic that aren't
+// coalesced, coalesced ones are bounded by the number of controls
+const notifyQueueSize = 256
+
+// notifyBlockTimeout is how long a structural notification waits for room
+// in a full queue before it is dropped
+const notifyBlockTimeout = 100 * time.Millisecond
+
+// coalescedTopics are high-rate topics where a newer event supersedes a
+// pending one about the same control. They map to the key of that control.
+var coalescedTopics = map[string]func(data map[string]interface{}) string{
+	"control.value.updated": func(data map[string]interface{}) string {
+		return fmt.Sprint(data["type"], "/", data["id"])
+	},
+	"button.state.updated": func(data map[string]interface{}) string {
+		return fmt.Sprint(data["id"])
+	},
+}
+
+// droppableTopics are high-rate topics without a key to coalesce by, their
+// oldest pending event is dropped when the queue is full
+var droppableTopics = map[string]bool{
+	"midi.message": true,
+}
+
+// queuedNotification is a notification waiting for the dispatcher of its topic
+type queuedNotification struct {
+	data interface{}
+	seq  uint64 // When it was raised, see notification
+	key  string // Coalescing key, empty for topics that aren't coalesced
+}
+
+// notifyQueues holds a queue with its own dispatcher for each topic, so that
+// a slow subscriber only delays the notifications of its topic. Adding to a
+// queue only waits for structural topics while it is full: coalesced topics
+// hold at most one event per control and droppable ones lose their oldest
+// event instead.
+type notifyQueues struct {
+	mu      sync.Mutex
+	topics  map[string]*notifyQueue
+	closed  bool
+	running sync.WaitGroup // Dispatchers that didn't stop yet, see Close
+}
+
+// notifyQueue holds the notifications of a topic in the order they were raised
+type notifyQueue struct {
+	events  []*queuedNotification
+	keys    map[string]*queuedNotification // Pending event of each coalescing key
+	latest  map[string]uint64              // Newest event queued for each coalescing key
+	bounded int                            // Pending events counted against notifyQueueSize
+	wake    chan struct{}
+	room    chan struct{} // Closed and replaced whenever the dispatcher takes the events
+}
+
+// notification is a notification raised with saveMutex held
+type notification struct {
+	topic string
+	data  interface{}
+	seq   uint64 // Taken with saveMutex held, so in the order of the changes
+}
+
+// queue returns the queue of a topic, starting its dispatcher the first time.
+// Must be called with the queues locked.
+func (cm *ConfigManager) queue(topic string) *notifyQueue {
+	queues := &cm.notifications
+	if q, ok := queues.topics[topic]; ok {
+		return q
+	}
+	if queues.topics == nil {
+		queues.topics = make(map[string]*notifyQueue)
+	}
+	q := &notifyQueue{
+		keys:   make(map[string]*queuedNotification),
+		latest: make(map[string]uint64),
+		wake:   make(chan struct{}, 1),
+		room:   make(chan struct{}),
+	}
+	queues.topics[topic] = q
+	queues.running.Add(1)
+	supervise.Go("configuration.notify."+topic, func() { cm.dispatch(topic, q) })
+	return q
+}
+
+// dispatch delivers the queued notifications of a topic to its subscribers
+// until Close. Subscribers that panic are recovered by NotifySync.
+func (cm *ConfigManager) dispatch(topic string, q *notifyQueue) {
+	queues := &cm.notifications
+	for range q.wake {
+		queues.mu.Lock()
+		events := q.events
+		q.events = nil
+		clear(q.keys)
+		q.bounded = 0
+		close(q.room)
+		q.room = make(chan struct{})
+		queues.mu.Unlock()
+
+		for _, event := range events {
+			cm.NotifySync(topic, event.data)
+		}
+	}
+	// Not deferred, a restart after a panic keeps running
+	queues.running.Done()
+}
+
+// enqueue adds a notification to the queue of its topic, after the pending
+// ones raised before it. A pending event of a coalesced topic about the same
+// control is replaced, keeping its place, and one older than the last queued
+// for that control is left out.
+func (cm *ConfigManager) enqueue(topic string, data interface{}, seq uint64) {
+	queues := &cm.notifications
+	key := ""
+	if keyOf, ok := coalescedTopics[topic]; ok {
+		if fields, ok := data.(map[string]interface{}); ok {
+			key = keyOf(fields)
+		}
+	}
+
+	queues.mu.Lock()
+	if queues.closed {
+		queues.mu.Unlock()
+		return
+	}
+	q := cm.queue(topic)
+
+	switch {
+	case key != "":
+		if seq < q.latest[key] {
+			// A newer value of the control was raised first
+			queues.mu.Unlock()
+			return
+		}
+		q.latest[key] = seq
+		if pending, ok := q.keys[key]; ok {
+			pending.data, pending.seq = data, seq
+			queues.mu.Unlock()
+			return
+		}
+	case q.bounded >= notifyQueueSize && droppableTopics[topic]:
+		q.events = q.events[1:]
+		q.bounded--
+		dropped := atomic.AddUint64(&cm.droppedNotifications, 1)
+		log.Debug().Str("topic", topic).Uint64("dropped", dropped).Msg("Notification queue full, dropping oldest event")
+	case q.bounded >= notifyQueueSize:
+		if !cm.waitForRoom(q) {
+			dropped := atomic.AddUint64(&cm.droppedNotifications, 1)
+			log.Warn().Str("topic", topic).Uint64("dropped", dropped).Msg("Notification queue stayed full, dropping event")
+			queues.mu.Unlock()
+			return
+		}
+	}
+
+	event := &queuedNotification{data: data, seq: seq, key: key}
+	i := len(q.events)
+	for i > 0 && q.events[i-1].seq > seq {
+		i--
+	}
+	q.events = slices.Insert(q.events, i, event)
+	if key != "" {
+		q.keys[key] = event
+	} else {
+		q.bounded++
+	}
+	queues.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+		// The dispatcher is already due to pick the event up
+	}
+}
+
+// waitForRoom waits up to notifyBlockTimeout for the dispatcher to take the
+// events of a full queue. It is called and returns with the queues locked
+// and reports whether there is room.
+func (cm *ConfigManager) waitForRoom(q *notifyQueue) bool {
+	queues := &cm.notifications
+	timeout := time.NewTimer(notifyBlockTimeout)
+	defer timeout.Stop()
+	for q.bounded >= notifyQueueSize && !queues.closed {
+		room := q.room
+		queues.mu.Unlock()
+		select {
+		case <-room:
+		case <-timeout.C:
+			queues.mu.Lock()
+			return false
+		}
+		queues.mu.Lock()
+	}
+	return !queues.closed
+}
+
+// notifyLocked raises a notification with saveMutex held. It is queued when
+// the mutex is released by unlock, so that senders never wait for the queue
+// while others wait for the configuration.
+func (cm *ConfigManager) notifyLocked(topic string, data interface{}) {
+	cm.pending = append(cm.pending, notification{topic: topic, data: data, seq: atomic.AddUint64(&cm.notifySeq, 1)})
+}
+
+// unlock releases saveMutex and queues the notifications raised while it
+// was held. Their sequence numbers keep them in the order of the changes
+// even when another change is queued first.
+func (cm *ConfigManager) unlock() {
+	pending := cm.pending
+	cm.pending = nil
+	cm.saveMutex.Unlock()
+
+	for _, n := range pending {
+		cm.enqueue(n.topic, n.data, n.seq)
+	}
+}
+
+// Close stops delivering notifications once the queued ones are delivered.
+// Notifications raised afterwards are dropped, NotifySync still works.
+func (cm *ConfigManager) Close() {
+	queues := &cm.notifications
+	queues.mu.Lock()
+	defer queues.mu.Unlock()
+	if queues.closed {
+		return
+	}
+	queues.closed = true
+	for _, q := range queues.topics {
+		close(q.wake)
+		close(q.room)
+		q.room = make(chan struct{})
+	}
+}
+
+// DroppedNotifications returns the number of notifications dropped because
+// subscribers couldn't keep up
+func (cm *ConfigManager) DroppedNotifications() uint64 {
+	return atomic.LoadUint64(&cm.droppedNotifications)
+}
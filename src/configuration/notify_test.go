@@ -0,0 +1,465 @@
+package configuration
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/activity"
+)
+
+// newTestManager returns a manager of the default configuration that
+// doesn't save
+func newTestManager(t *testing.T) *ConfigManager {
+	t.Helper()
+	cm := NewConfigManager(GetDefaultConfig(), filepath.Join(t.TempDir(), "config.yaml"))
+	cm.SetReadOnly(true)
+	t.Cleanup(cm.Close)
+	return cm
+}
+
+func TestSlowSubscriberDoesNotDelayValues(t *testing.T) {
+	cm := newTestManager(t)
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var values []int
+	cm.Subscribe("control.value.updated", func(data interface{}) {
+		mu.Lock()
+		first := len(values) == 0
+		values = append(values, data.(map[string]interface{})["value"].(int))
+		mu.Unlock()
+		if first {
+			// Hold up the first delivery like a stalled client
+			<-release
+		}
+	})
+
+	// Senders never wait for the stalled subscriber
+	start := time.Now()
+	for value := 0; value <= 100; value++ {
+		for i := 0; i < 10; i++ {
+			cm.UpdateControlValue(activity.Midi(), "slider", "slider1", (value+i)%101)
+		}
+		cm.UpdateControlValue(activity.Midi(), "slider", "slider1", value)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("updates took %v with a stalled subscriber", elapsed)
+	}
+
+	// Once it returns, the latest value follows right away instead of a backlog
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for {
+		mu.Lock()
+		got := append([]int(nil), values...)
+		mu.Unlock()
+		if len(got) > 0 && got[len(got)-1] == 100 {
+			if len(got) > 3 {
+				t.Errorf("got %d deliveries, want the pending values coalesced: %v", len(got), got)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("latest value not delivered, got %v", got)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if dropped := cm.DroppedNotifications(); dropped != 0 {
+		t.Errorf("got %d dropped notifications, want coalescing instead", dropped)
+	}
+}
+
+func TestCoalescingKeepsControlsApart(t *testing.T) {
+	cm := newTestManager(t)
+
+	release := make(chan struct{})
+	delivered := make(chan map[string]interface{}, 10)
+	cm.Subscribe("control.value.updated", func(data interface{}) {
+		if data.(map[string]interface{})["id"] == "slider8" {
+			<-release
+			return
+		}
+		delivered <- data.(map[string]interface{})
+	})
+
+	cm.UpdateControlValue(activity.Midi(), "slider", "slider8", 1)
+	time.Sleep(10 * time.Millisecond) // Let the dispatcher stall on it
+	cm.UpdateControlValue(activity.Midi(), "slider", "slider1", 10)
+	cm.UpdateControlValue(activity.Midi(), "slider", "slider2", 20)
+	cm.UpdateControlValue(activity.Midi(), "slider", "slider1", 11)
+	close(release)
+
+	want := []string{"slider1=11", "slider2=20"}
+	for _, w := range want {
+		select {
+		case data := <-delivered:
+			if got := fmt.Sprintf("%v=%v", data["id"], data["value"]); got != w {
+				t.Errorf("got %s, want %s", got, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s not delivered", w)
+		}
+	}
+}
+
+func TestStructuralNotificationsWaitForRoom(t *testing.T) {
+	cm := newTestManager(t)
+
+	release := make(chan struct{})
+	stalled := make(chan struct{})
+	var mu sync.Mutex
+	var received []int
+	cm.Subscribe("scene.saved", func(data interface{}) {
+		mu.Lock()
+		received = append(received, data.(map[string]interface{})["index"].(int))
+		first := len(received) == 1
+		mu.Unlock()
+		if first {
+			close(stalled)
+			<-release
+		}
+	})
+
+	// The first is taken by the stalled subscriber, the rest fill the queue
+	cm.Notify("scene.saved", map[string]interface{}{"index": 0})
+	<-stalled
+	for i := 1; i <= notifyQueueSize; i++ {
+		cm.Notify("scene.saved", map[string]interface{}{"index": i})
+	}
+
+	// The next waits until the subscriber returns instead of being dropped
+	time.AfterFunc(notifyBlockTimeout/4, func() { close(release) })
+	start := time.Now()
+	cm.Notify("scene.saved", map[string]interface{}{"index": notifyQueueSize + 1})
+	if elapsed := time.Since(start); elapsed < notifyBlockTimeout/8 || elapsed > notifyBlockTimeout {
+		t.Errorf("notifying a full queue took %v, want it to wait for the subscriber", elapsed)
+	}
+
+	const events = notifyQueueSize + 2
+	deadline := time.Now().Add(time.Second)
+	for {
+		mu.Lock()
+		count := len(received)
+		mu.Unlock()
+		if count == events {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d of %d notifications", count, events)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for i, index := range received {
+		if index != i {
+			t.Fatalf("notification %d has index %d, want them in order", i, index)
+		}
+	}
+	if dropped := cm.DroppedNotifications(); dropped != 0 {
+		t.Errorf("got %d dropped notifications, want none", dropped)
+	}
+}
+
+func TestStructuralNotificationDroppedWhenQueueStaysFull(t *testing.T) {
+	cm := newTestManager(t)
+
+	release := make(chan struct{})
+	defer close(release)
+	stalled := make(chan struct{}, 1)
+	cm.Subscribe("scene.saved", func(data interface{}) {
+		select {
+		case stalled <- struct{}{}:
+		default:
+		}
+		<-release
+	})
+	cm.Notify("scene.saved", map[string]interface{}{"index": 0})
+	<-stalled
+	for i := 1; i <= notifyQueueSize; i++ {
+		cm.Notify("scene.saved", map[string]interface{}{"index": i})
+	}
+
+	start := time.Now()
+	cm.Notify("scene.saved", map[string]interface{}{"index": notifyQueueSize + 1})
+	if elapsed := time.Since(start); elapsed < notifyBlockTimeout || elapsed > 2*notifyBlockTimeout {
+		t.Errorf("notifying a full queue took %v, want about %v", elapsed, notifyBlockTimeout)
+	}
+	if dropped := cm.DroppedNotifications(); dropped != 1 {
+		t.Errorf("got %d dropped notifications, want 1", dropped)
+	}
+}
+
+func TestNotificationsKeepOrderWithinTopic(t *testing.T) {
+	cm := newTestManager(t)
+
+	delivered := make(chan int, 100)
+	cm.Subscribe("source.assigned", func(data interface{}) {
+		delivered <- data.(map[string]interface{})["index"].(int)
+	})
+	for i := 0; i < 50; i++ {
+		cm.Notify("source.assigned", map[string]interface{}{"index": i})
+	}
+	for want := 0; want < 50; want++ {
+		select {
+		case index := <-delivered:
+			if index != want {
+				t.Fatalf("got notification %d, want %d", index, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("notification %d not delivered", want)
+		}
+	}
+}
+
+func TestSlowSubscriberOnlyDelaysItsTopic(t *testing.T) {
+	cm := newTestManager(t)
+
+	release := make(chan struct{})
+	defer close(release)
+	stalled := make(chan struct{}, 1)
+	cm.Subscribe("scene.saved", func(interface{}) {
+		select {
+		case stalled <- struct{}{}:
+		default:
+		}
+		<-release
+	})
+	delivered := make(chan interface{}, 1)
+	cm.Subscribe("profile.switched", func(data interface{}) {
+		delivered <- data
+	})
+
+	cm.Notify("scene.saved", nil)
+	<-stalled
+	cm.Notify("scene.saved", nil)
+	cm.Notify("profile.switched", "gaming")
+	select {
+	case <-delivered:
+	case <-time.After(time.Second):
+		t.Fatal("a stalled subscriber of another topic held up the notification")
+	}
+}
+
+func TestConcurrentValuesEndWithTheStoredOne(t *testing.T) {
+	cm := newTestManager(t)
+
+	var mu sync.Mutex
+	last := -1
+	cm.Subscribe("control.value.updated", func(data interface{}) {
+		mu.Lock()
+		last = data.(map[string]interface{})["value"].(int)
+		mu.Unlock()
+	})
+
+	// Writers race for the same control, subscribers must end up with the
+	// value the configuration ended up with
+	for round := 0; round < 20; round++ {
+		var wg sync.WaitGroup
+		for writer := 0; writer < 4; writer++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for value := 0; value < 50; value++ {
+					cm.UpdateControlValue(activity.Midi(), "slider", "slider1", (writer*50+value+round)%101)
+				}
+			}()
+		}
+		wg.Wait()
+
+		stored := cm.GetConfigSnapshot().Controls.Sliders["slider1"].Value
+		deadline := time.Now().Add(time.Second)
+		for {
+			mu.Lock()
+			got := last
+			mu.Unlock()
+			if got == stored {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("round %d: subscribers got %d, the configuration has %d", round, got, stored)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func TestCloseStopsDispatcher(t *testing.T) {
+	cm := newTestManager(t)
+
+	delivered := make(chan struct{}, 2)
+	cm.Subscribe("test.event", func(interface{}) {
+		delivered <- struct{}{}
+	})
+	cm.Notify("test.event", nil)
+	cm.Close()
+
+	// Queued notifications are still delivered, later ones aren't
+	select {
+	case <-delivered:
+	case <-time.After(time.Second):
+		t.Fatal("notification queued before Close not delivered")
+	}
+	stopped := make(chan struct{})
+	go func() {
+		cm.notifications.running.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("dispatcher still running after Close")
+	}
+	cm.Notify("test.event", nil)
+	select {
+	case <-delivered:
+		t.Error("notification delivered after Close")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestNotifyAfterUnlock(t *testing.T) {
+	cm := newTestManager(t)
+
+	// A subscriber may use the manager, the source is assigned by then
+	assigned := make(chan []Source, 1)
+	cm.Subscribe("source.assigned", func(data interface{}) {
+		assigned <- cm.ControlSources("slider", "slider1")
+	})
+
+	source := Source{Type: "PlaybackStream", Name: "Firefox"}
+	cm.AssignSource("slider", "slider1", source)
+	select {
+	case sources := <-assigned:
+		if len(sources) != 1 || !sources[0].Same(source) {
+			t.Errorf("got sources %v in the notification", sources)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("source.assigned not delivered")
+	}
+	if len(cm.pending) != 0 {
+		t.Errorf("%d notifications left pending after unlock", len(cm.pending))
+	}
+}
+
+func TestConcurrentSubscriptions(t *testing.T) {
+	cm := newTestManager(t)
+
+	// Subscribers come and go while notifications are sent both ways
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				subscription := cm.Subscribe("test.event", func(interface{}) {})
+				all := cm.Subscribe(AllTopics, func(interface{}) {})
+				cm.Unsubscribe(subscription)
+				cm.Unsubscribe(all)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				cm.NotifySync("test.event", i)
+				cm.Notify("test.event", i)
+				cm.Subscribed("test.event")
+			}
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+
+	if cm.Subscribed("test.event") {
+		t.Error("subscribers left after every one unsubscribed")
+	}
+}
+
+func TestUnsubscribeWithinNotification(t *testing.T) {
+	cm := newTestManager(t)
+
+	var mu sync.Mutex
+	calls := map[string]int{}
+	call := func(name string) {
+		mu.Lock()
+		calls[name]++
+		mu.Unlock()
+	}
+	var self Subscription
+	self = cm.Subscribe("test.event", func(interface{}) {
+		call("self")
+		cm.Unsubscribe(self)
+	})
+	cm.Subscribe("test.event", func(interface{}) {
+		call("other")
+	})
+
+	cm.NotifySync("test.event", nil)
+	cm.NotifySync("test.event", nil)
+	if calls["self"] != 1 || calls["other"] != 2 {
+		t.Errorf("got calls %v, want self once and other twice", calls)
+	}
+
+	// The same from the asynchronous dispatcher
+	delivered := make(chan struct{}, 2)
+	var async Subscription
+	async = cm.Subscribe("test.async", func(interface{}) {
+		cm.Unsubscribe(async)
+		delivered <- struct{}{}
+	})
+	cm.Notify("test.async", nil)
+	select {
+	case <-delivered:
+	case <-time.After(time.Second):
+		t.Fatal("notification not delivered")
+	}
+	cm.Notify("test.async", nil)
+	select {
+	case <-delivered:
+		t.Error("notification delivered after unsubscribing")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if cm.Subscribed("test.async") {
+		t.Error("topic still has subscribers")
+	}
+}
+
+func TestPanickingSubscriberKeepsDispatcherRunning(t *testing.T) {
+	cm := newTestManager(t)
+
+	delivered := make(chan int, 4)
+	cm.Subscribe("test.event", func(data interface{}) {
+		if data.(int) == 1 {
+			panic("subscriber failed")
+		}
+	})
+	cm.Subscribe("test.event", func(data interface{}) {
+		delivered <- data.(int)
+	})
+	for i := 1; i <= 2; i++ {
+		cm.Notify("test.event", i)
+	}
+	for want := 1; want <= 2; want++ {
+		select {
+		case got := <-delivered:
+			if got != want {
+				t.Errorf("got notification %d, want %d", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("notification %d not delivered after a panic", want)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package configuration
+
+import (
+	"fmt"
+	"os"
+)
+
+// hostProfile selects the override instead of the hostname, see SetHostProfile
+var hostProfile string
+
+// SetHostProfile makes configurations loaded afterwards use the override with
+// the given name instead of the one for the hostname
+func SetHostProfile(name string) {
+	hostProfile = name
+}
+
+// overrideHost returns the name of the override to apply
+func overrideHost() string {
+	if hostProfile != "" {
+		return hostProfile
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return hostname
+}
+
+// overrideLayer is the override of the host, deep-merged over the base
+// configuration at load time and split off again on save
+type overrideLayer struct {
+	host     string
+	override map[string]interface{} // The override as loaded
+	base     map[string]interface{} // The configuration as loaded, before the override was merged
+}
+
+// applyOverride merges the override for this host over a document. It returns
+// nil if there is none.
+func applyOverride(doc document) (document, *overrideLayer, error) {
+	if _, exists := doc["overrides"]; !exists && hostProfile == "" {
+		return doc, nil, nil
+	}
+	// Nested mappings of a document decode as documents, make them plain maps
+	plain := map[string]interface{}{}
+	if err := remarshal(doc, &plain); err != nil {
+		return nil, nil, err
+	}
+
+	overrides, ok := plain["overrides"].(map[string]interface{})
+	if !ok {
+		if _, exists := doc["overrides"]; exists {
+			return nil, nil, fmt.Errorf("overrides must map host names to configuration sections")
+		}
+		if hostProfile != "" {
+			return doc, nil, fmt.Errorf("host profile %s does not exist, the configuration has no overrides", hostProfile)
+		}
+		return doc, nil, nil
+	}
+	host := overrideHost()
+	override, ok := overrides[host]
+	if !ok {
+		if hostProfile != "" {
+			return doc, nil, fmt.Errorf("host profile %s does not exist in overrides", hostProfile)
+		}
+		return doc, nil, nil
+	}
+	overrideMap, ok := override.(map[string]interface{})
+	if !ok {
+		return nil, nil, fmt.Errorf("overrides.%s must be a mapping", host)
+	}
+	for _, key := range []string{"version", "overrides"} {
+		if _, ok := overrideMap[key]; ok {
+			return nil, nil, fmt.Errorf("overrides.%s.%s cannot be overridden", host, key)
+		}
+	}
+
+	layer := &overrideLayer{
+		host:     host,
+		override: overrideMap,
+		base:     plain,
+	}
+	merged := mergeValue(plain, overrideMap).(map[string]interface{})
+	return document(merged), layer, nil
+}
+
+// copyValue deep-copies a value decoded without a schema
+func copyValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			copied[key] = copyValue(item)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(value))
+		for i, item := range value {
+			copied[i] = copyValue(item)
+		}
+		return copied
+	}
+	return value
+}
+
+// namedItems reports whether a value is a list of mappings with a name, like
+// the device list. Such lists are merged item by item.
+func namedItems(value interface{}) ([]interface{}, bool) {
+	list, ok := value.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, false
+	}
+	for _, item := range list {
+		if _, ok := itemName(item); !ok {
+			return nil, false
+		}
+	}
+	return list, true
+}
+
+func itemName(item interface{}) (string, bool) {
+	mapping, ok := item.(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	name, ok := mapping["name"].(string)
+	return name, ok
+}
+
+// findItem returns the index of the item with the given name, or -1
+func findItem(list []interface{}, name string) int {
+	for i, item := range list {
+		if itemName, ok := itemName(item); ok && itemName == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// mergeValue deep-merges override over base: mappings are merged key by key,
+// lists of named mappings item by item, and anything else is replaced
+func mergeValue(base interface{}, override interface{}) interface{} {
+	if overrideMap, ok := override.(map[string]interface{}); ok {
+		baseMap, ok := base.(map[string]interface{})
+		if !ok {
+			return copyValue(override)
+		}
+		merged := copyValue(baseMap).(map[string]interface{})
+		for key, value := range overrideMap {
+			merged[key] = mergeValue(baseMap[key], value)
+		}
+		return merged
+	}
+	if overrideItems, ok := namedItems(override); ok {
+		baseList, ok := base.([]interface{})
+		if !ok {
+			return copyValue(override)
+		}
+		merged := copyValue(baseList).([]interface{})
+		for _, item := range overrideItems {
+			name, _ := itemName(item)
+			if i := findItem(merged, name); i >= 0 {
+				merged[i] = mergeValue(merged[i], item)
+			} else {
+				merged = append(merged, copyValue(item))
+			}
+		}
+		return merged
+	}
+	return copyValue(override)
+}
+
+// splitValue divides the effective value of a part of the configuration the
+// override touches into what is saved in the base and in the override:
+//   - values the override sets are saved in the override, the base keeps its own
+//   - entries that only exist in the override are saved there entirely
+//   - anything else is saved in the base
+//
+// A value removed at runtime is removed from the override, so the base value
+// applies again. inBase and inDisk report whether the base has a value.
+func splitValue(effective interface{}, override interface{}, base interface{}, inBase bool) (disk interface{}, inDisk bool, saved interface{}) {
+	if !inBase {
+		return nil, false, effective
+	}
+
+	if overrideMap, ok := override.(map[string]interface{}); ok {
+		effectiveMap, isMap := effective.(map[string]interface{})
+		baseMap, baseIsMap := base.(map[string]interface{})
+		if isMap && baseIsMap {
+			diskMap := make(map[string]interface{}, len(effectiveMap))
+			for key, value := range effectiveMap {
+				diskMap[key] = value
+			}
+			savedMap := make(map[string]interface{})
+			for key, value := range overrideMap {
+				effectiveValue, ok := effectiveMap[key]
+				if !ok {
+					continue
+				}
+				baseValue, inBase := baseMap[key]
+				diskValue, inDisk, savedValue := splitValue(effectiveValue, value, baseValue, inBase)
+				if inDisk {
+					diskMap[key] = diskValue
+				} else {
+					delete(diskMap, key)
+				}
+				savedMap[key] = savedValue
+			}
+			return diskMap, true, savedMap
+		}
+	}
+
+	if overrideItems, ok := namedItems(override); ok {
+		effectiveList, isList := effective.([]interface{})
+		baseList, baseIsList := base.([]interface{})
+		if isList && baseIsList {
+			var diskList []interface{}
+			var savedList []interface{}
+			split := make(map[string]bool)
+			for _, item := range overrideItems {
+				name, _ := itemName(item)
+				split[name] = true
+			}
+			for _, effectiveItem := range effectiveList {
+				name, ok := itemName(effectiveItem)
+				if !ok || !split[name] {
+					diskList = append(diskList, effectiveItem)
+					continue
+				}
+				overrideItem := overrideItems[findItem(overrideItems, name)]
+				baseIndex := findItem(baseList, name)
+				var baseItem interface{}
+				if baseIndex >= 0 {
+					baseItem = baseList[baseIndex]
+				}
+				diskItem, inDisk, savedItem := splitValue(effectiveItem, overrideItem, baseItem, baseIndex >= 0)
+				if inDisk {
+					diskList = append(diskList, diskItem)
+				}
+				if savedMap, ok := savedItem.(map[string]interface{}); ok {
+					// The name identifies the item to merge into
+					savedMap["name"] = name
+				}
+				savedList = append(savedList, savedItem)
+			}
+			return diskList, true, savedList
+		}
+	}
+
+	return base, true, effective
+}
+
+// split returns the configuration to save in the base file, with the
+// override of the host updated from the effective configuration
+func (layer *overrideLayer) split(config Config) (Config, error) {
+	effective := map[string]interface{}{}
+	if err := remarshal(config, &effective); err != nil {
+		return config, err
+	}
+	delete(effective, "overrides")
+
+	diskValue, _, savedValue := splitValue(effective, layer.override, layer.base, true)
+	disk := diskValue.(map[string]interface{})
+
+	overrides := make(map[string]interface{}, len(config.Overrides))
+	for host, override := range config.Overrides {
+		overrides[host] = override
+	}
+	overrides[layer.host] = savedValue
+	disk["overrides"] = overrides
+
+	var split Config
+	if err := remarshal(disk, &split); err != nil {
+		return config, err
+	}
+	return split, nil
+}
@@ -0,0 +1,195 @@
+package configuration
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/0h41/pulsekontrol/src/activity"
+)
+
+// overriddenConfig is shared by two hosts whose overrides set some of the same
+// keys, and one the other doesn't
+const overriddenConfig = `version: 3
+devices:
+  - name: nano
+    type: KorgNanoKontrol2
+    inPort: nano in
+    outPort: nano out
+webui:
+  address: 127.0.0.1:6080
+profiles:
+  default:
+    controls:
+      sliders:
+        slider1:
+          path: Group1/Slider
+          value: 40
+        slider2:
+          path: Group2/Slider
+          value: 60
+overrides:
+  laptop:
+    devices:
+      - name: nano
+        inPort: nano laptop
+    webui:
+      address: 0.0.0.0:6080
+    profiles:
+      default:
+        controls:
+          sliders:
+            slider1:
+              value: 80
+  desktop:
+    devices:
+      - name: nano
+        inPort: nano desktop
+      - name: pedal
+        type: Generic
+        inPort: pedal in
+    profiles:
+      default:
+        controls:
+          sliders:
+            slider1:
+              value: 20
+`
+
+// inspectAs loads the configuration at path with the override of host
+func inspectAs(t *testing.T, path string, host string) LoadResult {
+	t.Helper()
+	override := pathOverride
+	SetPath(path)
+	SetHostProfile(host)
+	defer func() {
+		SetPath(override)
+		SetHostProfile("")
+	}()
+	result, err := Inspect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func writeOverriddenConfig(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(overriddenConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestOverridesAreMerged(t *testing.T) {
+	path := writeOverriddenConfig(t)
+	for _, test := range []struct {
+		host    string
+		inPort  string
+		outPort string
+		address string
+		devices []string
+		slider1 int
+	}{
+		{"laptop", "nano laptop", "nano out", "0.0.0.0:6080", []string{"nano"}, 80},
+		{"desktop", "nano desktop", "nano out", "127.0.0.1:6080", []string{"nano", "pedal"}, 20},
+	} {
+		t.Run(test.host, func(t *testing.T) {
+			result := inspectAs(t, path, test.host)
+			config := result.Config
+			if result.Host != test.host {
+				t.Errorf("override %q applied", result.Host)
+			}
+
+			var devices []string
+			for _, device := range config.Devices {
+				devices = append(devices, device.Name)
+			}
+			if strings.Join(devices, " ") != strings.Join(test.devices, " ") {
+				t.Errorf("devices %v, want %v", devices, test.devices)
+			}
+			// The device is merged key by key
+			if device := config.Devices[0]; device.InPort != test.inPort || device.OutPort != test.outPort || device.Type != KorgNanoKontrol2 {
+				t.Errorf("device is %+v", device)
+			}
+			if config.WebUI.Address != test.address {
+				t.Errorf("web address is %s, want %s", config.WebUI.Address, test.address)
+			}
+			sliders := config.Controls.Sliders
+			if sliders["slider1"].Value != test.slider1 || sliders["slider1"].Path != "Group1/Slider" {
+				t.Errorf("slider1 is %+v, want the value %d", sliders["slider1"], test.slider1)
+			}
+			if sliders["slider2"].Value != 60 {
+				t.Errorf("slider2 is %+v", sliders["slider2"])
+			}
+
+			// The dump shows the merged configuration
+			dump, err := result.Dump()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(dump), "# Override: "+test.host+"\n") || !strings.Contains(string(dump), "inPort: "+test.inPort+"\n") {
+				t.Errorf("dump doesn't show the override:\n%s", dump)
+			}
+		})
+	}
+}
+
+func TestUnknownHostProfile(t *testing.T) {
+	override := pathOverride
+	SetPath(writeOverriddenConfig(t))
+	SetHostProfile("server")
+	defer func() {
+		SetPath(override)
+		SetHostProfile("")
+	}()
+	if _, err := Inspect(); err == nil || !strings.Contains(err.Error(), "host profile server does not exist") {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestSavesGoToTheirLayer(t *testing.T) {
+	path := writeOverriddenConfig(t)
+	result := inspectAs(t, path, "laptop")
+	cm := NewConfigManager(result.Config, path)
+	t.Cleanup(func() { cm.Flush() })
+
+	// slider1 is set by the override, slider2 and the assignment only in the base
+	cm.UpdateControlValue(activity.Midi(), "slider", "slider1", 90)
+	cm.UpdateControlValue(activity.Midi(), "slider", "slider2", 70)
+	cm.AssignSource("slider", "slider2", spotify)
+	if err := cm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	saved := savedFile(t, path)
+	if value := saved.Controls.Sliders["slider1"].Value; value != 40 {
+		t.Errorf("shared slider1 saved as %d, want the value it had", value)
+	}
+	if slider := saved.Controls.Sliders["slider2"]; slider.Value != 70 || !sameSources(slider.Sources, []Source{spotify}) {
+		t.Errorf("shared slider2 saved as %+v", slider)
+	}
+	if address := saved.WebUI.Address; address != "127.0.0.1:6080" {
+		t.Errorf("shared web address saved as %s", address)
+	}
+	if inPort := saved.Devices[0].InPort; inPort != "nano in" {
+		t.Errorf("shared input port saved as %s", inPort)
+	}
+
+	// Each host sees its own override over the updated shared part
+	for host, slider1 := range map[string]int{"laptop": 90, "desktop": 20} {
+		config := inspectAs(t, path, host).Config
+		sliders := config.Controls.Sliders
+		if sliders["slider1"].Value != slider1 {
+			t.Errorf("%s: slider1 is %d, want %d", host, sliders["slider1"].Value, slider1)
+		}
+		if sliders["slider2"].Value != 70 || !sameSources(sliders["slider2"].Sources, []Source{spotify}) {
+			t.Errorf("%s: slider2 is %+v", host, sliders["slider2"])
+		}
+	}
+	if devices := inspectAs(t, path, "desktop").Config.Devices; len(devices) != 2 || devices[1].InPort != "pedal in" {
+		t.Errorf("desktop devices are %+v", devices)
+	}
+}
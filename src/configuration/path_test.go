@@ -0,0 +1,142 @@
+package configuration
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// isolatePaths makes home the home directory and points the other searched
+// locations at empty temporary directories
+func isolatePaths(t *testing.T, home string) {
+	t.Helper()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("XDG_CONFIG_DIRS", filepath.Join(t.TempDir(), "none"))
+
+	// ./config.yaml is looked for in the working directory
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(previous) })
+
+	override := pathOverride
+	SetPath("")
+	t.Cleanup(func() { SetPath(override) })
+}
+
+// writeConfigFile writes a minimal configuration to path
+func writeConfigFile(t *testing.T, path string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("version: 3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLocateConfig(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		configHome string   // XDG_CONFIG_HOME below the temporary directory, empty to leave it unset
+		files      []string // Existing files, relative to the temporary directory or ./ for the working directory
+		system     bool     // Whether a system configuration exists in XDG_CONFIG_DIRS
+		path       string   // Expected path to save to
+		source     string   // Expected path read from, empty if none
+	}{
+		{name: "nothing", path: "home/.config/pulsekontrol/config.yaml"},
+		{name: "home", files: []string{"home/.config/pulsekontrol/config.yaml"},
+			path: "home/.config/pulsekontrol/config.yaml", source: "home/.config/pulsekontrol/config.yaml"},
+		{name: "config home", configHome: "xdg", files: []string{"xdg/pulsekontrol/config.yaml", "home/.config/pulsekontrol/config.yaml"},
+			path: "xdg/pulsekontrol/config.yaml", source: "xdg/pulsekontrol/config.yaml"},
+		{name: "new file in config home", configHome: "xdg", path: "xdg/pulsekontrol/config.yaml"},
+		{name: "system", system: true,
+			path: "home/.config/pulsekontrol/config.yaml", source: "system/pulsekontrol/config.yaml"},
+		{name: "user before system", system: true, files: []string{"home/.config/pulsekontrol/config.yaml"},
+			path: "home/.config/pulsekontrol/config.yaml", source: "home/.config/pulsekontrol/config.yaml"},
+		{name: "working directory first", system: true, files: []string{"./config.yaml", "home/.config/pulsekontrol/config.yaml"},
+			path: "./config.yaml", source: "./config.yaml"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			isolatePaths(t, filepath.Join(root, "home"))
+			if test.configHome != "" {
+				t.Setenv("XDG_CONFIG_HOME", filepath.Join(root, test.configHome))
+			}
+			if test.system {
+				t.Setenv("XDG_CONFIG_DIRS", filepath.Join(root, "missing")+string(os.PathListSeparator)+filepath.Join(root, "system"))
+				writeConfigFile(t, filepath.Join(root, "system", "pulsekontrol", "config.yaml"))
+			}
+			resolve := func(path string) string {
+				if path == "" || filepath.Dir(path) == "." {
+					return path
+				}
+				return filepath.Join(root, path)
+			}
+			for _, file := range test.files {
+				writeConfigFile(t, resolve(file))
+			}
+
+			path, source, content, err := locateConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if path != resolve(test.path) {
+				t.Errorf("saves to %s, want %s", path, resolve(test.path))
+			}
+			if source != resolve(test.source) {
+				t.Errorf("reads from %q, want %q", source, resolve(test.source))
+			}
+			if (content != nil) != (test.source != "") {
+				t.Errorf("got content %q from %q", content, source)
+			}
+		})
+	}
+}
+
+func TestRelativeConfigHomeIsIgnored(t *testing.T) {
+	home := t.TempDir()
+	isolatePaths(t, home)
+	t.Setenv("XDG_CONFIG_HOME", "relative/config")
+	if dir, want := userConfigDir(), filepath.Join(home, ".config", "pulsekontrol"); dir != want {
+		t.Errorf("user directory is %s, want %s", dir, want)
+	}
+}
+
+func TestSetPathOverridesSearch(t *testing.T) {
+	isolatePaths(t, t.TempDir())
+	writeConfigFile(t, "config.yaml")
+	path := filepath.Join(t.TempDir(), "custom.yaml")
+	SetPath(path)
+
+	configPath, source, _, err := locateConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configPath != path || source != "" {
+		t.Errorf("got %s read from %q, want %s and no file yet", configPath, source, path)
+	}
+}
+
+func TestLoadCreatesConfigInConfigHome(t *testing.T) {
+	isolatePaths(t, t.TempDir())
+	configHome := filepath.Join(t.TempDir(), "xdg")
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+
+	_, path, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(configHome, "pulsekontrol", "config.yaml")
+	if path != want {
+		t.Errorf("created %s, want %s", path, want)
+	}
+	if _, err := os.Stat(want); err != nil {
+		t.Errorf("default configuration not written: %v", err)
+	}
+}
@@ -0,0 +1,245 @@
+package configuration
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Default indentation of yaml.Marshal, used when a file has no indented lines
+const defaultIndent = 4
+
+// parseDocument parses the configuration file into a node tree for
+// MarshalPreserving. It returns nil if the file isn't a YAML mapping.
+func parseDocument(content []byte) *yaml.Node {
+	document := &yaml.Node{}
+	if err := yaml.Unmarshal(content, document); err != nil {
+		return nil
+	}
+	if document.Kind != yaml.DocumentNode || len(document.Content) != 1 || document.Content[0].Kind != yaml.MappingNode {
+		return nil
+	}
+	return document
+}
+
+// detectIndent returns the indentation used in a YAML file: the smallest
+// indentation of a line with content
+func detectIndent(content []byte) int {
+	indent := 0
+	for _, line := range strings.Split(string(content), "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if width := len(line) - len(trimmed); width > 0 && (indent == 0 || width < indent) {
+			indent = width
+		}
+	}
+	if indent < 2 || indent > 8 {
+		return defaultIndent
+	}
+	return indent
+}
+
+// MarshalPreserving returns the YAML of a configuration written onto the node
+// tree of the file it was loaded from, so that comments, key order and the
+// formatting of unchanged values survive. Keys that are new are placed after
+// the key preceding them in the canonical order. It also returns the updated
+// tree for the next save. Without a tree it marshals like Marshal.
+func MarshalPreserving(document *yaml.Node, indent int, config *Config) ([]byte, *yaml.Node, error) {
+	saved, err := savedConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	fresh := &yaml.Node{}
+	if err := fresh.Encode(saved); err != nil {
+		return nil, nil, err
+	}
+
+	merged := &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{fresh}}
+	if document != nil {
+		updated := *document
+		updated.Content = []*yaml.Node{mergeNode(document.Content[0], fresh)}
+		merged = &updated
+	}
+
+	var buffer bytes.Buffer
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(indent)
+	if err := encoder.Encode(merged); err != nil {
+		return nil, nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, nil, err
+	}
+	return buffer.Bytes(), merged, nil
+}
+
+// mergeNode returns the new value of a node, reusing the old node where the
+// value is unchanged and keeping its comments where it changed
+func mergeNode(old *yaml.Node, fresh *yaml.Node) *yaml.Node {
+	if old.Kind != fresh.Kind {
+		replaced := *fresh
+		copyComments(&replaced, old)
+		return &replaced
+	}
+	if old.Style&yaml.FlowStyle != 0 && len(old.Content) == 0 {
+		// Written as {} or [], which would stay on one line when filled
+		emptied := *old
+		emptied.Style = fresh.Style
+		old = &emptied
+	}
+	switch old.Kind {
+	case yaml.ScalarNode:
+		return mergeScalar(old, fresh)
+	case yaml.MappingNode:
+		return mergeMapping(old, fresh)
+	case yaml.SequenceNode:
+		return mergeSequence(old, fresh)
+	}
+	return fresh
+}
+
+func copyComments(to *yaml.Node, from *yaml.Node) {
+	to.HeadComment = from.HeadComment
+	to.LineComment = from.LineComment
+	to.FootComment = from.FootComment
+}
+
+// mergeScalar keeps the old scalar if it means the same, like 0x10 and 16, and
+// otherwise takes the new value with the old comments and quoting
+func mergeScalar(old *yaml.Node, fresh *yaml.Node) *yaml.Node {
+	if sameScalar(old, fresh) {
+		return old
+	}
+	merged := *old
+	merged.Value = fresh.Value
+	merged.Tag = fresh.Tag
+	if old.ShortTag() != "!!str" || fresh.ShortTag() != "!!str" {
+		merged.Style = fresh.Style
+	}
+	return &merged
+}
+
+func sameScalar(a *yaml.Node, b *yaml.Node) bool {
+	if a.ShortTag() != b.ShortTag() {
+		return false
+	}
+	if a.Value == b.Value {
+		return true
+	}
+	var aValue, bValue interface{}
+	if a.Decode(&aValue) != nil || b.Decode(&bValue) != nil {
+		return false
+	}
+	return reflect.DeepEqual(aValue, bValue)
+}
+
+// mergeMapping keeps the order of the old keys, drops the keys that are gone
+// and inserts new keys after the key preceding them in the new mapping
+func mergeMapping(old *yaml.Node, fresh *yaml.Node) *yaml.Node {
+	freshValues := make(map[string]*yaml.Node, len(fresh.Content)/2)
+	for i := 0; i+1 < len(fresh.Content); i += 2 {
+		freshValues[fresh.Content[i].Value] = fresh.Content[i+1]
+	}
+
+	merged := *old
+	merged.Content = nil
+	kept := make(map[string]bool, len(old.Content)/2)
+	for i := 0; i+1 < len(old.Content); i += 2 {
+		key := old.Content[i]
+		value, ok := freshValues[key.Value]
+		if !ok || kept[key.Value] {
+			continue
+		}
+		kept[key.Value] = true
+		merged.Content = append(merged.Content, key, mergeNode(old.Content[i+1], value))
+	}
+
+	previous := ""
+	for i := 0; i+1 < len(fresh.Content); i += 2 {
+		key := fresh.Content[i]
+		if kept[key.Value] {
+			previous = key.Value
+			continue
+		}
+		position := 0
+		if previous != "" {
+			for j := 0; j+1 < len(merged.Content); j += 2 {
+				if merged.Content[j].Value == previous {
+					position = j + 2
+					break
+				}
+			}
+		}
+		merged.Content = append(merged.Content[:position], append([]*yaml.Node{key, fresh.Content[i+1]}, merged.Content[position:]...)...)
+		kept[key.Value] = true
+		previous = key.Value
+	}
+	return &merged
+}
+
+// mergeSequence takes the order of the new items. Each reuses an equal old
+// item, or else merges into the old item at the same position.
+func mergeSequence(old *yaml.Node, fresh *yaml.Node) *yaml.Node {
+	used := make([]bool, len(old.Content))
+	merged := *old
+	merged.Content = make([]*yaml.Node, len(fresh.Content))
+	for i, item := range fresh.Content {
+		for j, candidate := range old.Content {
+			if !used[j] && equalNodes(candidate, item) {
+				used[j] = true
+				merged.Content[i] = candidate
+				break
+			}
+		}
+	}
+	for i, item := range fresh.Content {
+		if merged.Content[i] != nil {
+			continue
+		}
+		if i < len(old.Content) && !used[i] {
+			used[i] = true
+			merged.Content[i] = mergeNode(old.Content[i], item)
+		} else {
+			merged.Content[i] = item
+		}
+	}
+	return &merged
+}
+
+// equalNodes reports whether two nodes hold the same value, ignoring
+// formatting, comments and the order of mapping keys
+func equalNodes(a *yaml.Node, b *yaml.Node) bool {
+	if a.Kind != b.Kind || len(a.Content) != len(b.Content) {
+		return false
+	}
+	switch a.Kind {
+	case yaml.ScalarNode:
+		return sameScalar(a, b)
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(a.Content); i += 2 {
+			found := false
+			for j := 0; j+1 < len(b.Content); j += 2 {
+				if a.Content[i].Value == b.Content[j].Value {
+					found = equalNodes(a.Content[i+1], b.Content[j+1])
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	case yaml.SequenceNode:
+		for i := range a.Content {
+			if !equalNodes(a.Content[i], b.Content[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
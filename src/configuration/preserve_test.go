@@ -0,0 +1,96 @@
+package configuration
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/0h41/pulsekontrol/src/activity"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// preserveInput is a configuration file with comments, its own key order and
+// indentation, as a previous save left it
+const preserveInput = "testdata/preserve/input.yaml"
+
+func TestUnchangedFileIsKept(t *testing.T) {
+	content, err := os.ReadFile(preserveInput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := decodeConfig(t, string(content))
+	data, _, err := MarshalPreserving(parseDocument(content), detectIndent(content), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(content) {
+		t.Errorf("unchanged configuration saved as\n%s", data)
+	}
+}
+
+func TestSavePreservesFile(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		change func(cm *ConfigManager) error
+	}{
+		{"value", func(cm *ConfigManager) error {
+			cm.UpdateControlValue(activity.Midi(), "slider", "slider1", 75)
+			return nil
+		}},
+		{"assign", func(cm *ConfigManager) error {
+			cm.AssignSource("slider", "slider2", Source{Type: PlaybackStream, Name: "mpv"})
+			cm.AssignSource("slider", "slider3", Source{Type: PlaybackStream, Name: "Discord"})
+			return nil
+		}},
+		{"unassign", func(cm *ConfigManager) error {
+			cm.UnassignSource("knob", "knob1", Source{Type: InputDevice, Name: "Blue Yeti"})
+			return nil
+		}},
+		{"button", func(cm *ConfigManager) error {
+			return cm.AssignButtonAction("rec", Action{Type: SetDefaultOutput, Target: &Target{Name: "Headphones"}})
+		}},
+		// Sections that don't exist yet go after the ones preceding them
+		{"sections", func(cm *ConfigManager) error {
+			cm.SaveScene("evening", []SceneVolume{{Source: Source{Type: PlaybackStream, Name: "Firefox"}, Volume: 20}})
+			return cm.SetAlias(PlaybackStream, "Firefox", "Browser")
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			content, err := os.ReadFile(preserveInput)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			cm := NewConfigManager(decodeConfig(t, string(content)), path)
+			if err := test.change(cm); err != nil {
+				t.Fatal(err)
+			}
+			if err := cm.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			saved, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			golden := filepath.Join("testdata", "preserve", test.name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, saved, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(saved) != string(want) {
+				t.Errorf("saved\n%s\nwant the content of %s", saved, golden)
+			}
+		})
+	}
+}
@@ -0,0 +1,360 @@
+package configuration
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+
+	"github.com/samber/lo"
+)
+
+// normalizeProfiles moves a single-profile config into the default profile
+// and makes sure the active profile exists
+func normalizeProfiles(config *Config) {
+	if len(config.Profiles) == 0 {
+		config.Profiles = map[string]Profile{
+			DefaultProfile: {Controls: config.Controls},
+		}
+	}
+	if _, ok := config.Profiles[config.ActiveProfile]; !ok {
+		if config.ActiveProfile != "" {
+			log.Warn().Str("profile", config.ActiveProfile).Msg("Active profile not found, falling back")
+		}
+		if _, ok := config.Profiles[DefaultProfile]; ok {
+			config.ActiveProfile = DefaultProfile
+		} else {
+			config.ActiveProfile = profileNames(config.Profiles)[0]
+		}
+	}
+}
+
+// diskConfig returns the config as it should be written to disk: the active
+// controls stored in their profile rather than at the top level, and a single
+// device in the old format if requested
+func diskConfig(config *Config) Config {
+	snapshot := *config
+	if len(config.templates) > 0 {
+		// Write back ${VAR} references instead of their values
+		snapshot = config.Clone()
+		restoreTemplates(&snapshot)
+	}
+	if config.SingleDevice && len(config.Devices) == 1 {
+		snapshot.Device = snapshot.Devices[0]
+		snapshot.Devices = nil
+	}
+	if len(config.Profiles) == 0 {
+		return snapshot
+	}
+	snapshot.Profiles = make(map[string]Profile, len(config.Profiles))
+	for name, profile := range config.Profiles {
+		snapshot.Profiles[name] = profile
+	}
+	snapshot.Profiles[config.ActiveProfile] = Profile{Controls: config.Controls}
+	snapshot.Controls = Controls{}
+	return snapshot
+}
+
+// savedConfig returns the configuration as it is written to the file, with
+// the changes to values of the host override moved back into the override
+func savedConfig(config *Config) (Config, error) {
+	snapshot := diskConfig(config)
+	if config.override == nil {
+		return snapshot, nil
+	}
+	return config.override.split(snapshot)
+}
+
+func profileNames(profiles map[string]Profile) []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Clone returns a deep copy of the controls
+func (controls Controls) Clone() Controls {
+	clone := Controls{
+		Sliders: make(map[string]SliderConfig, len(controls.Sliders)),
+		Knobs:   make(map[string]KnobConfig, len(controls.Knobs)),
+		Buttons: make(map[string]ButtonConfig, len(controls.Buttons)),
+	}
+	for id, slider := range controls.Sliders {
+		slider.Sources = slices.Clone(slider.Sources)
+		slider.Groups = slices.Clone(slider.Groups)
+		clone.Sliders[id] = slider
+	}
+	for id, knob := range controls.Knobs {
+		knob.Sources = slices.Clone(knob.Sources)
+		knob.Groups = slices.Clone(knob.Groups)
+		clone.Knobs[id] = knob
+	}
+	for id, button := range controls.Buttons {
+		button.Actions = slices.Clone(button.Actions)
+		if button.State != nil {
+			state := *button.State
+			button.State = &state
+		}
+		clone.Buttons[id] = button
+	}
+	return clone
+}
+
+// Clone returns a deep copy of the configuration
+func (config *Config) Clone() Config {
+	clone := *config
+	clone.Device = config.Device.clone()
+	if config.Devices != nil {
+		clone.Devices = make([]DeviceConfig, len(config.Devices))
+		for i, device := range config.Devices {
+			clone.Devices[i] = device.clone()
+		}
+	}
+	clone.Controls = config.Controls.Clone()
+	if config.Profiles != nil {
+		clone.Profiles = make(map[string]Profile, len(config.Profiles))
+		for name, profile := range config.Profiles {
+			clone.Profiles[name] = Profile{Controls: profile.Controls.Clone()}
+		}
+	}
+	if config.Scenes != nil {
+		clone.Scenes = make(map[string]SceneConfig, len(config.Scenes))
+		for name, scene := range config.Scenes {
+			clone.Scenes[name] = SceneConfig{
+				Sliders: maps.Clone(scene.Sliders),
+				Knobs:   maps.Clone(scene.Knobs),
+				Volumes: slices.Clone(scene.Volumes),
+			}
+		}
+	}
+	clone.Aliases = maps.Clone(config.Aliases)
+	if config.CombinedSinks != nil {
+		clone.CombinedSinks = make(map[string]CombinedSinkConfig, len(config.CombinedSinks))
+		for name, sink := range config.CombinedSinks {
+			clone.CombinedSinks[name] = CombinedSinkConfig{Outputs: slices.Clone(sink.Outputs)}
+		}
+	}
+	if config.Excludes != nil {
+		clone.Excludes = make([]ExcludeRule, len(config.Excludes))
+		for i, rule := range config.Excludes {
+			rule.Properties = maps.Clone(rule.Properties)
+			clone.Excludes[i] = rule
+		}
+	}
+	if config.Groups != nil {
+		clone.Groups = make(map[string]SourceGroup, len(config.Groups))
+		for name, group := range config.Groups {
+			clone.Groups[name] = slices.Clone(group)
+		}
+	}
+	if config.Overrides != nil {
+		clone.Overrides = copyValue(config.Overrides).(map[string]interface{})
+	}
+	if config.WebUI.Enabled != nil {
+		clone.WebUI.Enabled = lo.ToPtr(*config.WebUI.Enabled)
+	}
+	clone.WebUI.AllowedOrigins = slices.Clone(config.WebUI.AllowedOrigins)
+	if config.WebUI.Advertise != nil {
+		clone.WebUI.Advertise = lo.ToPtr(*config.WebUI.Advertise)
+	}
+	clone.OSC.Addresses = maps.Clone(config.OSC.Addresses)
+	clone.Hotkeys.Devices = slices.Clone(config.Hotkeys.Devices)
+	if config.Schedules != nil {
+		clone.Schedules = make([]Schedule, len(config.Schedules))
+		for i, schedule := range config.Schedules {
+			schedule.Actions = slices.Clone(schedule.Actions)
+			if schedule.Enabled != nil {
+				schedule.Enabled = lo.ToPtr(*schedule.Enabled)
+			}
+			clone.Schedules[i] = schedule
+		}
+	}
+	if config.StreamDeck.Brightness != nil {
+		clone.StreamDeck.Brightness = lo.ToPtr(*config.StreamDeck.Brightness)
+	}
+	if config.StreamDeck.Keys != nil {
+		clone.StreamDeck.Keys = make(map[int]StreamDeckKey, len(config.StreamDeck.Keys))
+		for number, key := range config.StreamDeck.Keys {
+			clone.StreamDeck.Keys[number] = StreamDeckKey{Label: key.Label, Actions: slices.Clone(key.Actions)}
+		}
+	}
+	if config.Hotkeys.Bindings != nil {
+		clone.Hotkeys.Bindings = make([]HotkeyBinding, len(config.Hotkeys.Bindings))
+		for i, binding := range config.Hotkeys.Bindings {
+			clone.Hotkeys.Bindings[i] = HotkeyBinding{Keys: binding.Keys, Actions: slices.Clone(binding.Actions)}
+		}
+	}
+	if config.Hooks != nil {
+		clone.Hooks = make(map[string]HookConfig, len(config.Hooks))
+		for name, hook := range config.Hooks {
+			hook.Match = maps.Clone(hook.Match)
+			clone.Hooks[name] = hook
+		}
+	}
+	if config.Webhooks != nil {
+		clone.Webhooks = make([]WebhookConfig, len(config.Webhooks))
+		for i, webhook := range config.Webhooks {
+			webhook.Events = slices.Clone(webhook.Events)
+			webhook.Headers = maps.Clone(webhook.Headers)
+			clone.Webhooks[i] = webhook
+		}
+	}
+	if config.History.Size != nil {
+		clone.History.Size = lo.ToPtr(*config.History.Size)
+	}
+	if config.ControlDefaults.Create != nil {
+		clone.ControlDefaults.Create = lo.ToPtr(*config.ControlDefaults.Create)
+	}
+	if config.ControlDefaults.Value != nil {
+		clone.ControlDefaults.Value = lo.ToPtr(*config.ControlDefaults.Value)
+	}
+	clone.Logging.PerModule = maps.Clone(config.Logging.PerModule)
+	if config.Persistence.Enabled != nil {
+		clone.Persistence.Enabled = lo.ToPtr(*config.Persistence.Enabled)
+	}
+	if config.Backups.Count != nil {
+		clone.Backups.Count = lo.ToPtr(*config.Backups.Count)
+	}
+	if config.StaleSources.AfterDays != nil {
+		clone.StaleSources.AfterDays = lo.ToPtr(*config.StaleSources.AfterDays)
+	}
+	return clone
+}
+
+func (device DeviceConfig) clone() DeviceConfig {
+	if device.Channel != nil {
+		device.Channel = lo.ToPtr(*device.Channel)
+	}
+	device.Options = maps.Clone(device.Options)
+	device.ControlMap = device.ControlMap.clone()
+	return device
+}
+
+// ProfileNames returns the names of all profiles in sorted order
+func (cm *ConfigManager) ProfileNames() []string {
+	cm.saveMutex.Lock()
+	defer cm.unlock()
+
+	return profileNames(cm.config.Profiles)
+}
+
+// ActiveProfile returns the name of the profile in use
+func (cm *ConfigManager) ActiveProfile() string {
+	cm.saveMutex.Lock()
+	defer cm.unlock()
+
+	return cm.config.ActiveProfile
+}
+
+// SwitchProfile makes another profile active
+func (cm *ConfigManager) SwitchProfile(name string) error {
+	cm.saveMutex.Lock()
+	profile, ok := cm.config.Profiles[name]
+	if !ok {
+		cm.unlock()
+		return fmt.Errorf("profile %s not found", name)
+	}
+	previous := cm.config.ActiveProfile
+	if previous == name {
+		cm.unlock()
+		return nil
+	}
+
+	// Store the working controls back into the profile being left
+	cm.config.Profiles[previous] = Profile{Controls: cm.config.Controls}
+	cm.config.Controls = profile.Controls
+	cm.config.ActiveProfile = name
+	cm.journal(journalActiveProfile, "", name)
+
+	// Undo entries refer to the controls of the previous profile
+	cm.history = history{}
+
+	// Schedule save
+	cm.SaveWithDebounce()
+	cm.unlock()
+
+	log.Info().Str("from", previous).Str("to", name).Msg("Switched profile")
+
+	// Notify outside the lock, subscribers re-apply volumes of the new profile
+	cm.Notify("profile.switched", map[string]interface{}{
+		"previous": previous,
+		"name":     name,
+	})
+	return nil
+}
+
+// CreateProfile adds a profile with default controls
+func (cm *ConfigManager) CreateProfile(name string) error {
+	defaults := GetDefaultConfig()
+	return cm.addProfile(name, defaults.Controls)
+}
+
+// CopyProfile adds a profile holding a copy of another profile's controls
+func (cm *ConfigManager) CopyProfile(source string, name string) error {
+	cm.saveMutex.Lock()
+	var controls Controls
+	if source == cm.config.ActiveProfile {
+		controls = cm.config.Controls.Clone()
+	} else if profile, ok := cm.config.Profiles[source]; ok {
+		controls = profile.Controls.Clone()
+	} else {
+		cm.unlock()
+		return fmt.Errorf("profile %s not found", source)
+	}
+	cm.unlock()
+
+	return cm.addProfile(name, controls)
+}
+
+func (cm *ConfigManager) addProfile(name string, controls Controls) error {
+	if name == "" {
+		return fmt.Errorf("profile name must not be empty")
+	}
+
+	cm.saveMutex.Lock()
+	defer cm.unlock()
+
+	if _, exists := cm.config.Profiles[name]; exists {
+		return fmt.Errorf("profile %s already exists", name)
+	}
+	ensureControlDefaults(&controls, cm.config.Devices)
+	cm.config.Profiles[name] = Profile{Controls: controls}
+	cm.journal(journalProfile, "", name)
+
+	log.Info().Str("profile", name).Msg("Created profile")
+
+	cm.notifyLocked("profiles.updated", map[string]interface{}{
+		"created": name,
+	})
+
+	// Schedule save
+	cm.SaveWithDebounce()
+	return nil
+}
+
+// DeleteProfile removes a profile other than the active one
+func (cm *ConfigManager) DeleteProfile(name string) error {
+	cm.saveMutex.Lock()
+	defer cm.unlock()
+
+	if _, ok := cm.config.Profiles[name]; !ok {
+		return fmt.Errorf("profile %s not found", name)
+	}
+	if name == cm.config.ActiveProfile {
+		return fmt.Errorf("cannot delete the active profile %s", name)
+	}
+	delete(cm.config.Profiles, name)
+	cm.journal(journalProfile, "", name)
+
+	log.Info().Str("profile", name).Msg("Deleted profile")
+
+	cm.notifyLocked("profiles.updated", map[string]interface{}{
+		"deleted": name,
+	})
+
+	// Schedule save
+	cm.SaveWithDebounce()
+	return nil
+}
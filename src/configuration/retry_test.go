@@ -0,0 +1,140 @@
+package configuration
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/activity"
+)
+
+// breakDirectory makes the directory of path unwritable, even for root, by
+// putting a file in its place. The returned function restores it.
+func breakDirectory(t *testing.T, path string) func() {
+	t.Helper()
+	dir := filepath.Dir(path)
+	moved := dir + ".moved"
+	if err := os.Rename(dir, moved); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	restored := false
+	restore := func() {
+		if restored {
+			return
+		}
+		restored = true
+		if err := os.Remove(dir); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(moved, dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(restore)
+	return restore
+}
+
+// fastRetries shortens the delays between save retries for the test
+func fastRetries(t *testing.T) {
+	delay, maxDelay := saveRetryDelay, maxSaveRetryDelay
+	saveRetryDelay, maxSaveRetryDelay = 10*time.Millisecond, 40*time.Millisecond
+	t.Cleanup(func() { saveRetryDelay, maxSaveRetryDelay = delay, maxDelay })
+}
+
+// subscribeSaves returns channels receiving the config.save.failed and
+// config.save.recovered notifications
+func subscribeSaves(cm *ConfigManager) (chan map[string]interface{}, chan map[string]interface{}) {
+	failed := make(chan map[string]interface{}, 4)
+	recovered := make(chan map[string]interface{}, 4)
+	cm.Subscribe("config.save.failed", func(data interface{}) {
+		failed <- data.(map[string]interface{})
+	})
+	cm.Subscribe("config.save.recovered", func(data interface{}) {
+		recovered <- data.(map[string]interface{})
+	})
+	return failed, recovered
+}
+
+func TestFailedSaveIsRetried(t *testing.T) {
+	fastRetries(t)
+	cm, _ := newSavingManager(t)
+	failed, recovered := subscribeSaves(cm)
+
+	restore := breakDirectory(t, cm.Path())
+	cm.UpdateControlValue(activity.Midi(), "slider", "slider1", 30)
+	cm.SaveNow()
+	select {
+	case data := <-failed:
+		if data["path"] != cm.Path() || data["error"] == "" {
+			t.Errorf("failure notified with %v", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("failure not notified")
+	}
+
+	// Retries keep failing, only the first failure is notified
+	deadline := time.Now().Add(5 * time.Second)
+	for _, state := cm.Snapshot(); state.Failures < 3; _, state = cm.Snapshot() {
+		if time.Now().After(deadline) {
+			t.Fatalf("save retried %d times", state.Failures)
+		}
+		if !state.Dirty {
+			t.Fatal("failed save cleared the dirty flag")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	select {
+	case <-failed:
+		t.Error("failure of a retry notified")
+	default:
+	}
+
+	// A retry succeeds once the directory can be written again
+	restore()
+	select {
+	case data := <-recovered:
+		if attempts := data["attempts"].(int); attempts < 4 {
+			t.Errorf("recovered after %d attempts", attempts)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("recovery not notified")
+	}
+	if _, state := cm.Snapshot(); state.Dirty || state.Failures != 0 {
+		t.Errorf("save state is %+v after recovering", state)
+	}
+	if value := savedFile(t, cm.Path()).Controls.Sliders["slider1"].Value; value != 30 {
+		t.Errorf("slider1 saved as %d, want 30", value)
+	}
+}
+
+func TestFlushReportsFailure(t *testing.T) {
+	cm, _ := newSavingManager(t)
+	_, recovered := subscribeSaves(cm)
+
+	restore := breakDirectory(t, cm.Path())
+	cm.UpdateControlValue(activity.Midi(), "slider", "slider1", 40)
+	if err := cm.Flush(); err == nil {
+		t.Fatal("flush into an unwritable directory succeeded")
+	}
+	if _, state := cm.Snapshot(); !state.Dirty {
+		t.Error("failed flush cleared the dirty flag")
+	}
+
+	// Nothing is retried behind the back of a flush, a later one saves
+	restore()
+	if err := cm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if value := savedFile(t, cm.Path()).Controls.Sliders["slider1"].Value; value != 40 {
+		t.Errorf("slider1 saved as %d, want 40", value)
+	}
+	select {
+	case <-recovered:
+		t.Error("recovery notified without an earlier failure")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,35 @@
+package configuration
+
+import "time"
+
+// MarkScheduleRun records when a schedule ran, so that a restart doesn't run
+// it again to catch up
+func (cm *ConfigManager) MarkScheduleRun(name string, at time.Time) {
+	cm.saveMutex.Lock()
+	defer cm.unlock()
+
+	for i, schedule := range cm.config.Schedules {
+		if schedule.Name != name {
+			continue
+		}
+		cm.config.Schedules[i].LastRun = at.Truncate(time.Second)
+		cm.journal(journalScheduleRun, "", name)
+		cm.SaveWithDebounce()
+		return
+	}
+}
+
+// replayScheduleRun keeps the later run of a schedule that still exists on disk
+func replayScheduleRun(name string, mine *Config, theirs *Config) {
+	var run time.Time
+	for _, schedule := range mine.Schedules {
+		if schedule.Name == name {
+			run = schedule.LastRun
+		}
+	}
+	for i := range theirs.Schedules {
+		if theirs.Schedules[i].Name == name && run.After(theirs.Schedules[i].LastRun) {
+			theirs.Schedules[i].LastRun = run
+		}
+	}
+}
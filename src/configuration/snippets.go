@@ -0,0 +1,196 @@
+package configuration
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SnippetKindProfile marks a snippet holding a single profile
+const SnippetKindProfile = "profile"
+
+// firstSnippetVersion is the configuration version snippets were introduced in
+const firstSnippetVersion = 3
+
+// ProfileSnippet is a profile exported for sharing, together with the aliases
+// of its sources. Version is the configuration schema version the controls
+// are written in, so that snippets are migrated on import like full configs.
+type ProfileSnippet struct {
+	Kind     string            `yaml:"kind"`
+	Version  int               `yaml:"version"`
+	Name     string            `yaml:"name"`
+	Controls Controls          `yaml:"controls"`
+	Aliases  map[string]string `yaml:"aliases,omitempty"`
+}
+
+// ExportProfile returns a profile as a self-contained YAML snippet
+func (cm *ConfigManager) ExportProfile(name string) ([]byte, error) {
+	cm.saveMutex.Lock()
+	controls, ok := controlsOf(cm.config, name)
+	if !ok {
+		cm.unlock()
+		return nil, fmt.Errorf("profile %s not found", name)
+	}
+	snippet := ProfileSnippet{
+		Kind:     SnippetKindProfile,
+		Version:  CurrentVersion,
+		Name:     name,
+		Controls: controls.Clone(),
+	}
+	aliases := cm.config.Aliases
+	cm.unlock()
+
+	addAlias := func(source Source) {
+		if alias, ok := aliases[AliasKey(source.Type, source.Name)]; ok {
+			if snippet.Aliases == nil {
+				snippet.Aliases = make(map[string]string)
+			}
+			snippet.Aliases[AliasKey(source.Type, source.Name)] = alias
+		}
+	}
+	// When sources were last seen is local history, not part of the mapping
+	for id, slider := range snippet.Controls.Sliders {
+		for i := range slider.Sources {
+			slider.Sources[i].LastSeen = time.Time{}
+			addAlias(slider.Sources[i])
+		}
+		snippet.Controls.Sliders[id] = slider
+	}
+	for id, knob := range snippet.Controls.Knobs {
+		for i := range knob.Sources {
+			knob.Sources[i].LastSeen = time.Time{}
+			addAlias(knob.Sources[i])
+		}
+		snippet.Controls.Knobs[id] = knob
+	}
+
+	return yaml.Marshal(snippet)
+}
+
+// parseProfileSnippet decodes a profile snippet, migrating it to the current
+// schema version by running it through the configuration migrations
+func parseProfileSnippet(data []byte) (ProfileSnippet, error) {
+	doc := document{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return ProfileSnippet{}, fmt.Errorf("error parsing snippet: %w", err)
+	}
+	if kind, _ := doc["kind"].(string); kind != SnippetKindProfile {
+		return ProfileSnippet{}, fmt.Errorf("not a profile snippet, kind is %q", kind)
+	}
+	version, ok := doc["version"].(int)
+	if !ok || version < firstSnippetVersion {
+		return ProfileSnippet{}, fmt.Errorf("invalid snippet version %v", doc["version"])
+	}
+	name, _ := doc["name"].(string)
+	if name == "" {
+		name = "imported"
+	}
+
+	// Wrap the profile in a configuration document for the migrations
+	wrapped := document{
+		"version":       version,
+		"activeProfile": name,
+		"profiles": map[string]interface{}{
+			name: map[string]interface{}{"controls": doc["controls"]},
+		},
+	}
+	if aliases, ok := doc["aliases"]; ok {
+		wrapped["aliases"] = aliases
+	}
+	migrated, _, err := migrateDocument(wrapped, version)
+	if err != nil {
+		return ProfileSnippet{}, err
+	}
+	var config Config
+	if err := remarshal(migrated, &config); err != nil {
+		return ProfileSnippet{}, fmt.Errorf("error parsing migrated snippet: %w", err)
+	}
+	profile, ok := config.Profiles[config.ActiveProfile]
+	if !ok {
+		return ProfileSnippet{}, fmt.Errorf("snippet has no profile")
+	}
+	return ProfileSnippet{
+		Kind:     SnippetKindProfile,
+		Version:  CurrentVersion,
+		Name:     config.ActiveProfile,
+		Controls: profile.Controls,
+		Aliases:  config.Aliases,
+	}, nil
+}
+
+// ImportProfile adds the profile of a snippet, named newName or, if that is
+// empty, the name it was exported with. A name that is taken gets a number
+// appended. Aliases of the snippet are added unless the source already has
+// one. It returns the name of the new profile.
+func (cm *ConfigManager) ImportProfile(data []byte, newName string) (string, error) {
+	snippet, err := parseProfileSnippet(data)
+	if err != nil {
+		return "", err
+	}
+	if newName == "" {
+		newName = snippet.Name
+	}
+
+	cm.saveMutex.Lock()
+	defer cm.unlock()
+
+	// Check the profile against the local devices, like a profile of the config file
+	check := Config{
+		Device:   cm.config.Device,
+		Devices:  cm.config.Devices,
+		Profiles: map[string]Profile{newName: {Controls: snippet.Controls}},
+		Aliases:  snippet.Aliases,
+	}
+	var errors []ValidationIssue
+	for _, issue := range Validate(&check, nil) {
+		if !issue.Warning {
+			errors = append(errors, issue)
+		}
+	}
+	if len(errors) > 0 {
+		return "", &ValidationError{Issues: errors}
+	}
+
+	name := newName
+	for i := 2; ; i++ {
+		if _, exists := cm.config.Profiles[name]; !exists {
+			break
+		}
+		name = newName + "-" + strconv.Itoa(i)
+	}
+
+	controls := snippet.Controls
+	ensureControlDefaults(&controls, cm.config.Devices)
+	cm.config.Profiles[name] = Profile{Controls: controls}
+	cm.journal(journalProfile, "", name)
+
+	added := 0
+	for key, alias := range snippet.Aliases {
+		if _, exists := cm.config.Aliases[key]; exists {
+			continue
+		}
+		if cm.config.Aliases == nil {
+			cm.config.Aliases = make(map[string]string)
+		}
+		cm.config.Aliases[key] = alias
+		cm.journal(journalAlias, "", key)
+		added++
+	}
+
+	log.Info().Str("profile", name).Int("aliases", added).Msg("Imported profile")
+
+	cm.notifyLocked("profiles.updated", map[string]interface{}{
+		"created": name,
+	})
+	if added > 0 {
+		cm.notifyLocked("alias.updated", map[string]interface{}{
+			"imported": added,
+		})
+	}
+
+	// Schedule save
+	cm.SaveWithDebounce()
+	return name, nil
+}
@@ -0,0 +1,212 @@
+package configuration
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+)
+
+// SourceSeenInterval is how often the last seen time of a source is updated,
+// so that matching streams doesn't cause a save on every volume change
+const SourceSeenInterval = time.Hour
+
+// StaleSource is an assigned source that wasn't seen for longer than the configured threshold
+type StaleSource struct {
+	Profile     string
+	ControlType string
+	ControlID   string
+	Source      Source
+}
+
+// sameSources compares source lists, ignoring when the sources were last seen
+func sameSources(a []Source, b []Source) bool {
+	return slices.EqualFunc(a, b, Source.Same)
+}
+
+// mergeLastSeen takes the later last seen times of mine into theirs
+func mergeLastSeen(theirs []Source, mine []Source) []Source {
+	merged := slices.Clone(theirs)
+	for i := range merged {
+		for _, source := range mine {
+			if source.Same(merged[i]) && source.LastSeen.After(merged[i].LastSeen) {
+				merged[i].LastSeen = source.LastSeen
+			}
+		}
+	}
+	return merged
+}
+
+// markSources sets the last seen time of the sources for which seen returns
+// true and reports whether any changed. The slice is copied so that
+// snapshots sharing it are left alone.
+func markSources(sources []Source, now time.Time, seen func(Source) bool) ([]Source, bool) {
+	var marked []Source
+	for i, source := range sources {
+		if !seen(source) {
+			continue
+		}
+		if marked == nil {
+			marked = slices.Clone(sources)
+		}
+		marked[i].LastSeen = now
+	}
+	if marked == nil {
+		return sources, false
+	}
+	return marked, true
+}
+
+// MarkSourceSeen records that a stream or device matching the source is
+// present. Each source is updated at most once per SourceSeenInterval.
+func (cm *ConfigManager) MarkSourceSeen(source Source) {
+	now := time.Now().Truncate(time.Second)
+	seen := func(assigned Source) bool {
+		return assigned.Same(source) && now.Sub(assigned.LastSeen) >= SourceSeenInterval
+	}
+
+	cm.saveMutex.Lock()
+	defer cm.unlock()
+
+	changed := false
+	for id, slider := range cm.config.Controls.Sliders {
+		if sources, ok := markSources(slider.Sources, now, seen); ok {
+			slider.Sources = sources
+			cm.config.Controls.Sliders[id] = slider
+			cm.journal(journalLastSeen, "slider", id)
+			changed = true
+		}
+	}
+	for id, knob := range cm.config.Controls.Knobs {
+		if sources, ok := markSources(knob.Sources, now, seen); ok {
+			knob.Sources = sources
+			cm.config.Controls.Knobs[id] = knob
+			cm.journal(journalLastSeen, "knob", id)
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+
+	log.Debug().Str("source", source.Name).Msg("Updated last seen time of source")
+
+	// Schedule save
+	cm.SaveWithDebounce()
+}
+
+// TrackUnseenSources starts the clock for sources that have no last seen
+// time yet, like those of configs written before it was recorded. The start
+// is kept in memory only, so that the configuration isn't rewritten for it:
+// such sources are stale once they weren't seen for longer than the
+// threshold since, and get a last seen time in the file when they are.
+func (cm *ConfigManager) TrackUnseenSources() {
+	cm.saveMutex.Lock()
+	defer cm.unlock()
+	if cm.unseenSince.IsZero() {
+		cm.unseenSince = time.Now().Truncate(time.Second)
+	}
+}
+
+// staleSources lists the assigned sources of all profiles that weren't seen
+// for longer than the configured threshold. Must be called with saveMutex held.
+func (cm *ConfigManager) staleSources(now time.Time) []StaleSource {
+	days := DefaultStaleAfterDays
+	if cm.config.StaleSources.AfterDays != nil {
+		days = *cm.config.StaleSources.AfterDays
+	}
+	cutoff := now.AddDate(0, 0, -days)
+	// Sources never seen count from when they were first looked for, which
+	// the entries report as their last seen time
+	lastSeen := func(source Source) Source {
+		if source.LastSeen.IsZero() {
+			source.LastSeen = cm.unseenSince
+		}
+		return source
+	}
+	stale := func(source Source) bool {
+		return !source.LastSeen.IsZero() && source.LastSeen.Before(cutoff)
+	}
+
+	var result []StaleSource
+	for _, profile := range profileNames(cm.config.Profiles) {
+		controls, _ := controlsOf(cm.config, profile)
+		for id, slider := range controls.Sliders {
+			for _, source := range slider.Sources {
+				if source = lastSeen(source); stale(source) {
+					result = append(result, StaleSource{Profile: profile, ControlType: "slider", ControlID: id, Source: source})
+				}
+			}
+		}
+		for id, knob := range controls.Knobs {
+			for _, source := range knob.Sources {
+				if source = lastSeen(source); stale(source) {
+					result = append(result, StaleSource{Profile: profile, ControlType: "knob", ControlID: id, Source: source})
+				}
+			}
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Profile != b.Profile {
+			return a.Profile < b.Profile
+		}
+		if a.ControlType != b.ControlType {
+			return a.ControlType < b.ControlType
+		}
+		return a.ControlID < b.ControlID
+	})
+	return result
+}
+
+// StaleSources lists the assigned sources that weren't seen for longer than
+// staleSources.afterDays
+func (cm *ConfigManager) StaleSources() []StaleSource {
+	cm.saveMutex.Lock()
+	defer cm.unlock()
+	return cm.staleSources(time.Now())
+}
+
+// RemoveStaleSources unassigns the sources that weren't seen for longer than
+// staleSources.afterDays and returns what was removed
+func (cm *ConfigManager) RemoveStaleSources() []StaleSource {
+	cm.saveMutex.Lock()
+	defer cm.unlock()
+
+	stale := cm.staleSources(time.Now())
+	if len(stale) == 0 {
+		return nil
+	}
+
+	before := cm.controlStates()
+	for _, entry := range stale {
+		controls, _ := controlsOf(cm.config, entry.Profile)
+		switch entry.ControlType {
+		case "slider":
+			slider := controls.Sliders[entry.ControlID]
+			slider.Sources, _ = filterSource(slider.Sources, entry.Source)
+			controls.Sliders[entry.ControlID] = slider
+		case "knob":
+			knob := controls.Knobs[entry.ControlID]
+			knob.Sources, _ = filterSource(knob.Sources, entry.Source)
+			controls.Knobs[entry.ControlID] = knob
+		}
+		cm.journalIn(entry.Profile, journalSources, entry.ControlType, entry.ControlID)
+
+		log.Info().Str("profile", entry.Profile).Str("control", entry.ControlID).Str("source", entry.Source.Name).Time("lastSeen", entry.Source.LastSeen).Msg("Removed stale source")
+
+		if entry.Profile == cm.config.ActiveProfile {
+			cm.notifyLocked("source.unassigned", map[string]interface{}{
+				"controlType": entry.ControlType,
+				"controlId":   entry.ControlID,
+				"sourceType":  entry.Source.Type,
+				"sourceName":  entry.Source.Name,
+			})
+		}
+	}
+	cm.recordChange(fmt.Sprintf("remove %d stale sources", len(stale)), before)
+
+	// Schedule save
+	cm.SaveWithDebounce()
+	return stale
+}
@@ -0,0 +1,70 @@
+package configuration
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newStaleTestManager returns a manager saving to a temporary file, with
+// Firefox assigned to slider1 and Spotify to slider2 without last seen times
+func newStaleTestManager(t *testing.T) *ConfigManager {
+	t.Helper()
+	config, err := Prepare(GetDefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, name := range map[string]string{"slider1": "Firefox", "slider2": "Spotify"} {
+		slider := config.Controls.Sliders[id]
+		slider.Sources = []Source{{Type: PlaybackStream, Name: name}}
+		config.Controls.Sliders[id] = slider
+	}
+	cm := NewConfigManager(config, filepath.Join(t.TempDir(), "config.yaml"))
+	t.Cleanup(cm.Close)
+	return cm
+}
+
+func TestTrackUnseenSourcesLeavesConfigAlone(t *testing.T) {
+	cm := newStaleTestManager(t)
+
+	cm.TrackUnseenSources()
+	config, state := cm.Snapshot()
+	if state.Dirty {
+		t.Error("starting to track unseen sources made the configuration dirty")
+	}
+	if lastSeen := config.Controls.Sliders["slider1"].Sources[0].LastSeen; !lastSeen.IsZero() {
+		t.Errorf("Firefox got last seen time %v without being seen", lastSeen)
+	}
+	if stale := cm.StaleSources(); len(stale) != 0 {
+		t.Errorf("got stale sources %+v right after starting to track", stale)
+	}
+}
+
+func TestUnseenSourcesBecomeStale(t *testing.T) {
+	cm := newStaleTestManager(t)
+	cm.TrackUnseenSources()
+
+	// Spotify is seen, Firefox isn't for longer than the threshold
+	cm.MarkSourceSeen(Source{Type: PlaybackStream, Name: "Spotify"})
+	since := time.Now().AddDate(0, 0, -DefaultStaleAfterDays-1)
+	cm.unseenSince = since
+
+	stale := cm.StaleSources()
+	if len(stale) != 1 || stale[0].ControlID != "slider1" || stale[0].Source.Name != "Firefox" {
+		t.Fatalf("got stale sources %+v, want Firefox of slider1", stale)
+	}
+	if !stale[0].Source.LastSeen.Equal(since) {
+		t.Errorf("Firefox reported last seen %v, want when tracking started %v", stale[0].Source.LastSeen, since)
+	}
+
+	config, state := cm.Snapshot()
+	if !state.Dirty {
+		t.Error("seeing Spotify didn't make the configuration dirty")
+	}
+	if config.Controls.Sliders["slider2"].Sources[0].LastSeen.IsZero() {
+		t.Error("Spotify has no last seen time after being seen")
+	}
+	if !config.Controls.Sliders["slider1"].Sources[0].LastSeen.IsZero() {
+		t.Error("Firefox got a last seen time in the configuration")
+	}
+}
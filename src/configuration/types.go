@@ -1,6 +1,12 @@
 package configuration
 
-import "gopkg.in/yaml.v3"
+import (
+	"math"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
 
 // Legacy types - keep for compatibility during transition
 type MidiDeviceType string
@@ -10,11 +16,28 @@ const (
 	KorgNanoKontrol2 MidiDeviceType = "KorgNanoKontrol2"
 )
 
+// MidiTransport is how a device is reached
+type MidiTransport string
+
+const (
+	LocalTransport   MidiTransport = "local"   // MIDI ports of this machine
+	RTPMIDITransport MidiTransport = "rtpmidi" // Network MIDI session (AppleMIDI)
+)
+
 type MidiDevice struct {
 	Name        string         `yaml:"name"`
 	Type        MidiDeviceType `yaml:"type"`
 	MidiInName  string         `yaml:"midiInName"`
 	MidiOutName string         `yaml:"midiOutName"`
+
+	// Network MIDI session of the rtpmidi transport
+	Transport   MidiTransport `yaml:"transport,omitempty"`
+	Host        string        `yaml:"host,omitempty"`
+	Port        int           `yaml:"port,omitempty"`
+	SessionName string        `yaml:"sessionName,omitempty"`
+
+	// How often a device that is not found is looked for, 0 for the default
+	RetryInterval time.Duration `yaml:"retryInterval,omitempty"`
 }
 
 type MidiMessageType string
@@ -43,8 +66,28 @@ type PulseAudioActionType string
 const (
 	SetVolume                          PulseAudioActionType = "SetVolume"
 	SetDefaultOutput                   PulseAudioActionType = "SetDefaultOutput"
+	SetDefaultInput                    PulseAudioActionType = "SetDefaultInput"
 	MediaPlayPause                     PulseAudioActionType = "MediaPlayPause"
 	AssignFocusedWindowPlaybackStreams PulseAudioActionType = "AssignFocusedWindowPlaybackStreams"
+	RecallScene                        PulseAudioActionType = "RecallScene"
+	ToggleMute                         PulseAudioActionType = "ToggleMute"
+	Mute                               PulseAudioActionType = "Mute"
+	Unmute                             PulseAudioActionType = "Unmute"
+	MoveStreamToSink                   PulseAudioActionType = "MoveStreamToSink"
+	StepControl                        PulseAudioActionType = "StepControl"
+	CycleDefaultOutput                 PulseAudioActionType = "CycleDefaultOutput"
+	SetCardProfile                     PulseAudioActionType = "SetCardProfile"
+	ToggleLoopback                     PulseAudioActionType = "ToggleLoopback"
+	LoadLoopback                       PulseAudioActionType = "LoadLoopback"
+	UnloadLoopback                     PulseAudioActionType = "UnloadLoopback"
+	ToggleEchoCancel                   PulseAudioActionType = "ToggleEchoCancel"
+	ToggleMicMute                      PulseAudioActionType = "ToggleMicMute"
+	ToggleSuspendOutput                PulseAudioActionType = "ToggleSuspendOutput"
+	SuspendOutput                      PulseAudioActionType = "SuspendOutput"
+	ResumeOutput                       PulseAudioActionType = "ResumeOutput"
+	VolumeUp                           PulseAudioActionType = "VolumeUp"
+	VolumeDown                         PulseAudioActionType = "VolumeDown"
+	BalanceControl                     PulseAudioActionType = "BalanceControl"
 )
 
 type Target struct {
@@ -55,6 +98,8 @@ type TypedTarget struct {
 	Type       PulseAudioTargetType `yaml:"type"`
 	Name       string               `yaml:"name"`
 	BinaryName string               `yaml:"binaryName,omitempty"`
+	ProcessID  int                  `yaml:"processId,omitempty"` // Only the streams of the process with this id
+	Cgroup     string               `yaml:"cgroup,omitempty"`    // Only the streams of processes in this cgroup, see Source
 }
 
 type Action struct {
@@ -66,6 +111,10 @@ type Action struct {
 type Rule struct {
 	MidiMessage MidiMessage `yaml:"midiMessage"`
 	Actions     []Action    `yaml:"actions"`
+	// The slider or knob the rule was created for, its value is queued
+	// with the executor instead of running the actions
+	ControlType string `yaml:"-"`
+	ControlID   string `yaml:"-"`
 }
 
 // Legacy Config structure
@@ -85,11 +134,58 @@ const (
 	InputDevice    PulseAudioTargetType = "InputDevice"
 )
 
+// ParseTargetType parses a source type case insensitively, also accepting
+// the short forms playback, record, output or sink, and input or source
+func ParseTargetType(name string) (PulseAudioTargetType, bool) {
+	switch strings.ToLower(name) {
+	case "playback", "playbackstream":
+		return PlaybackStream, true
+	case "record", "recordstream":
+		return RecordStream, true
+	case "output", "outputdevice", "sink":
+		return OutputDevice, true
+	case "input", "inputdevice", "source":
+		return InputDevice, true
+	}
+	return "", false
+}
+
 // Source represents an audio source or destination
 type Source struct {
 	Type       PulseAudioTargetType `yaml:"type"`
 	Name       string               `yaml:"name"`
 	BinaryName string               `yaml:"binaryName,omitempty"`
+	// Only the streams of the process with this id, for one of several
+	// instances of the same binary
+	ProcessID int `yaml:"processId,omitempty"`
+	// Only the streams of processes in this cgroup: its path like
+	// /user.slice/user-1000.slice/user@1000.service/app.slice/app-firefox-1234.scope,
+	// or one part of it like a systemd scope or unit
+	Cgroup   string    `yaml:"cgroup,omitempty"`
+	LastSeen time.Time `yaml:"lastSeen,omitempty"` // When a stream or device last matched the source
+}
+
+// Same reports whether two sources refer to the same audio source, regardless of when they were last seen
+func (source Source) Same(other Source) bool {
+	return source.Type == other.Type && source.Name == other.Name && source.BinaryName == other.BinaryName &&
+		source.ProcessID == other.ProcessID && source.Cgroup == other.Cgroup
+}
+
+// Overlaps reports whether two sources can match the same stream or device. A
+// source without a binary name matches the streams of all binaries, one
+// without a process id or cgroup those of all processes.
+func (source Source) Overlaps(other Source) bool {
+	if source.Type != other.Type || source.Name != other.Name {
+		return false
+	}
+	return (source.BinaryName == "" || other.BinaryName == "" || source.BinaryName == other.BinaryName) &&
+		(source.ProcessID == 0 || other.ProcessID == 0 || source.ProcessID == other.ProcessID) &&
+		(source.Cgroup == "" || other.Cgroup == "" || source.Cgroup == other.Cgroup)
+}
+
+// Target returns the target matching the streams or devices of the source
+func (source Source) Target() *TypedTarget {
+	return &TypedTarget{Type: source.Type, Name: source.Name, BinaryName: source.BinaryName, ProcessID: source.ProcessID, Cgroup: source.Cgroup}
 }
 
 // Button action types
@@ -109,39 +205,528 @@ type ButtonTarget struct {
 
 // ControlTarget identifies a slider or knob in the runtime configuration.
 type ControlTarget struct {
-	ControlType string
-	ControlID   string
+	ControlType string `yaml:"controlType"`
+	ControlID   string `yaml:"controlId"`
+}
+
+// StepTarget moves a slider or knob up or down by its step size
+type StepTarget struct {
+	ControlType string `yaml:"controlType"`
+	ControlID   string `yaml:"controlId"`
+	Direction   int    `yaml:"direction"`          // 1 to step up, -1 to step down
+	StepSize    int    `yaml:"stepSize,omitempty"` // Overrides the step size of the control
+}
+
+// VolumeStepTarget moves the volume of the streams or devices matching a
+// source up or down by a step
+type VolumeStepTarget struct {
+	TypedTarget `yaml:",inline"`
+	Step        int `yaml:"step,omitempty"` // Percent, defaults to DefaultStepSize
+}
+
+// MoveTarget moves the playback streams of an application to an output device
+type MoveTarget struct {
+	Name       string `yaml:"name"` // Playback stream, matched like the sources of a control
+	BinaryName string `yaml:"binaryName,omitempty"`
+	Sink       string `yaml:"sink"` // Name of the output device
+}
+
+// CycleTarget makes the next of its output devices the default, in order and
+// skipping those that are missing
+type CycleTarget struct {
+	Outputs []string `yaml:"outputs"` // Names of the output devices
+}
+
+// CardProfileTarget switches a sound card to the next of its profiles, in
+// order and skipping those that are unavailable. With a single profile it
+// just switches to it.
+type CardProfileTarget struct {
+	Card     string   `yaml:"card"`     // Name or description of the card
+	Profiles []string `yaml:"profiles"` // Names of the profiles, like a2dp-sink
+}
+
+// LoopbackTarget plays an input device on an output device, like a
+// microphone on headphones
+type LoopbackTarget struct {
+	Source    string `yaml:"source"`              // Name of the input device
+	Sink      string `yaml:"sink"`                // Name of the output device
+	LatencyMs int    `yaml:"latencyMs,omitempty"` // Defaults to the server's
+}
+
+// EchoCancelTarget records an input device without what an output device
+// plays on it, like a microphone picking up speakers, as an input device of
+// its own
+type EchoCancelTarget struct {
+	Source     string `yaml:"source"`               // Name of the input device
+	Sink       string `yaml:"sink"`                 // Name of the output device
+	SetDefault bool   `yaml:"setDefault,omitempty"` // Whether to make the echo-cancelled input device the default while it's there
 }
 
 // SliderConfig represents a slider on the MIDI controller
 type SliderConfig struct {
-	Path    string   `yaml:"path"`    // The MIDI control path (e.g., "Group1/Slider")
-	Value   int      `yaml:"value"`   // Current value (0-100)
-	Sources []Source `yaml:"sources"` // Audio sources controlled by this slider
+	Device   string   `yaml:"device,omitempty"`   // Name of the device, defaults to the first device
+	Path     string   `yaml:"path"`               // The MIDI control path (e.g., "Group1/Slider")
+	Label    string   `yaml:"label,omitempty"`    // Display name
+	Value    int      `yaml:"value"`              // Current value (0-100)
+	StepSize int      `yaml:"stepSize,omitempty"` // Change of the value per step (1-50)
+	MinValue uint8    `yaml:"minValue,omitempty"` // Lowest MIDI value the slider sends, defaults to 0
+	MaxValue uint8    `yaml:"maxValue,omitempty"` // Highest MIDI value the slider sends, defaults to 127
+	Muted    bool     `yaml:"muted,omitempty"`    // Whether the sources are muted
+	Sources  []Source `yaml:"sources"`            // Audio sources controlled by this slider
+	Groups   []string `yaml:"groups,omitempty"`   // Source groups controlled by this slider, see Config.Groups
+
+	VirtualSink string `yaml:"virtualSink,omitempty"` // Name of an output device created for this slider, see Config.VirtualSinks
+
+	MaxVolume int         `yaml:"maxVolume,omitempty"` // Volume in percent at the top of the slider, above 100 to boost, defaults to 100
+	Scale     VolumeScale `yaml:"scale,omitempty"`     // How the value maps to the volume, defaults to linear
+	FloorDb   int         `yaml:"floorDb,omitempty"`   // Volume in dB below the top at the bottom of the db scale, defaults to -60
 }
 
 // KnobConfig represents a knob on the MIDI controller
 type KnobConfig struct {
-	Path    string   `yaml:"path"`    // The MIDI control path (e.g., "Group1/Knob")
-	Value   int      `yaml:"value"`   // Current value (0-100)
-	Sources []Source `yaml:"sources"` // Audio sources controlled by this knob
+	Device   string   `yaml:"device,omitempty"`   // Name of the device, defaults to the first device
+	Path     string   `yaml:"path"`               // The MIDI control path (e.g., "Group1/Knob")
+	Label    string   `yaml:"label,omitempty"`    // Display name
+	Value    int      `yaml:"value"`              // Current value (0-100)
+	StepSize int      `yaml:"stepSize,omitempty"` // Change of the value per step (1-50)
+	MinValue uint8    `yaml:"minValue,omitempty"` // Lowest MIDI value the knob sends, defaults to 0
+	MaxValue uint8    `yaml:"maxValue,omitempty"` // Highest MIDI value the knob sends, defaults to 127
+	Muted    bool     `yaml:"muted,omitempty"`    // Whether the sources are muted
+	Sources  []Source `yaml:"sources"`            // Audio sources controlled by this knob
+	Groups   []string `yaml:"groups,omitempty"`   // Source groups controlled by this knob, see Config.Groups
+
+	VirtualSink string `yaml:"virtualSink,omitempty"` // Name of an output device created for this knob, see Config.VirtualSinks
+
+	MaxVolume int         `yaml:"maxVolume,omitempty"` // Volume in percent at the top of the knob, above 100 to boost, defaults to 100
+	Scale     VolumeScale `yaml:"scale,omitempty"`     // How the value maps to the volume, defaults to linear
+	FloorDb   int         `yaml:"floorDb,omitempty"`   // Volume in dB below the top at the bottom of the db scale, defaults to -60
+
+	// What the value sets: the volume (SetVolume, the default) or, with
+	// BalanceControl, the balance from left (0) over the center (50) to right (100)
+	Action PulseAudioActionType `yaml:"action,omitempty"`
+}
+
+// VolumeScale is how the value of a slider or knob maps to a volume
+type VolumeScale string
+
+const (
+	// LinearScale sets the volume in proportion to the value, the default
+	LinearScale VolumeScale = "linear"
+	// CubicScale sets the volume in proportion to the cube of the value,
+	// which spends more of the travel on quiet volumes
+	CubicScale VolumeScale = "cubic"
+	// DecibelScale spreads the value evenly over the decibels from floorDb
+	// up to the top, and silences at 0
+	DecibelScale VolumeScale = "db"
+)
+
+// Known reports whether the scale is one of the scales, empty counts as
+// LinearScale
+func (scale VolumeScale) Known() bool {
+	switch scale {
+	case "", LinearScale, CubicScale, DecibelScale:
+		return true
+	}
+	return false
+}
+
+// VolumeMapping maps the value (0-100) of a slider or knob to a volume in
+// percent and back
+type VolumeMapping struct {
+	MaxVolume int         // Volume in percent at the top, zero for DefaultMaxVolume
+	Scale     VolumeScale // Empty for LinearScale
+	FloorDb   int         // Bottom of the DecibelScale, zero for DefaultFloorDb
+}
+
+// Volume returns the volume in percent a value (0-100) sets
+func (mapping VolumeMapping) Volume(value int) float64 {
+	position := float64(min(max(value, 0), 100)) / 100
+	switch mapping.Scale {
+	case CubicScale:
+		position = position * position * position
+	case DecibelScale:
+		if value > 0 {
+			// Volumes are cubic, a third of the decibels is the factor of the
+			// volume in percent
+			position = math.Pow(10, mapping.floorDb()*(1-position)/60)
+		}
+	}
+	return position * float64(mapping.maxVolume())
+}
+
+// Value is the inverse of Volume, the value (0-100) a control needs for a
+// volume in percent
+func (mapping VolumeMapping) Value(volume float64) int {
+	fraction := volume / float64(mapping.maxVolume())
+	if fraction <= 0 {
+		return 0
+	}
+	switch mapping.Scale {
+	case CubicScale:
+		fraction = math.Cbrt(fraction)
+	case DecibelScale:
+		fraction = 1 - 60*math.Log10(fraction)/mapping.floorDb()
+	}
+	return min(max(int(math.Round(fraction*100)), 0), 100)
+}
+
+func (mapping VolumeMapping) maxVolume() int {
+	if mapping.MaxVolume == 0 {
+		return DefaultMaxVolume
+	}
+	return mapping.MaxVolume
+}
+
+func (mapping VolumeMapping) floorDb() float64 {
+	if mapping.FloorDb == 0 {
+		return DefaultFloorDb
+	}
+	return float64(mapping.FloorDb)
+}
+
+// VolumeMapping returns how the value of the slider maps to a volume
+func (slider SliderConfig) VolumeMapping() VolumeMapping {
+	return VolumeMapping{MaxVolume: slider.MaxVolume, Scale: slider.Scale, FloorDb: slider.FloorDb}
+}
+
+// VolumeMapping returns how the value of the knob maps to a volume
+func (knob KnobConfig) VolumeMapping() VolumeMapping {
+	return VolumeMapping{MaxVolume: knob.MaxVolume, Scale: knob.Scale, FloorDb: knob.FloorDb}
+}
+
+// ControlsBalance reports whether the knob sets the balance of its sources
+// instead of their volume
+func (knob KnobConfig) ControlsBalance() bool {
+	return knob.Action == BalanceControl
+}
+
+// DeviceIdentity identifies a physical controller independently of its port
+// names, which change with the USB topology
+type DeviceIdentity struct {
+	Serial string `yaml:"serial,omitempty"` // USB serial number, learned when the device is first found
+	ID     string `yaml:"id,omitempty"`     // ALSA card id, can be assigned with a udev rule for devices without a serial
+}
+
+// IsZero reports whether no identity is configured
+func (identity DeviceIdentity) IsZero() bool {
+	return identity.Serial == "" && identity.ID == ""
 }
 
 // DeviceConfig contains MIDI device settings
 type DeviceConfig struct {
-	Name    string `yaml:"name"`    // Display name for the device
-	InPort  string `yaml:"inPort"`  // MIDI input port name
-	OutPort string `yaml:"outPort"` // MIDI output port name
+	Name     string            `yaml:"name"`               // Display name for the device
+	Type     MidiDeviceType    `yaml:"type,omitempty"`     // Kind of controller, defaults to KorgNanoKontrol2
+	InPort   string            `yaml:"inPort"`             // MIDI input port name
+	OutPort  string            `yaml:"outPort"`            // MIDI output port name
+	Identity DeviceIdentity    `yaml:"identity,omitempty"` // Hardware identity, matched before the port names
+	Channel  *uint8            `yaml:"channel,omitempty"`  // MIDI channel the device sends on (0-15)
+	Options  map[string]string `yaml:"options,omitempty"`  // Device specific options
+
+	// Network MIDI instead of local ports
+	Transport   MidiTransport `yaml:"transport,omitempty"`   // local (the default) or rtpmidi
+	Host        string        `yaml:"host,omitempty"`        // rtpmidi: host of the session
+	Port        int           `yaml:"port,omitempty"`        // rtpmidi: control port of the session, defaults to 5004
+	SessionName string        `yaml:"sessionName,omitempty"` // rtpmidi: name to join the session with, defaults to pulsekontrol
+
+	RetryInterval time.Duration `yaml:"retryInterval,omitempty"` // How often the device is looked for while it is not found, defaults to 5s
+
+	// Control paths of a Generic device and the messages they send
+	ControlMap ControlMap `yaml:"controlMap,omitempty"`
+}
+
+// ButtonMode defines how a button triggers its actions
+type ButtonMode string
+
+const (
+	Momentary ButtonMode = "momentary" // Actions run on press
+	Toggle    ButtonMode = "toggle"    // Each press flips State
+	WhileHeld ButtonMode = "whileHeld" // Actions are active while the button is held down
+)
+
+// ButtonConfig represents a button on the MIDI controller
+type ButtonConfig struct {
+	Device  string     `yaml:"device,omitempty"` // Name of the device, defaults to the first device
+	Path    string     `yaml:"path"`             // The MIDI control path (e.g., "Group1/Solo")
+	Label   string     `yaml:"label,omitempty"`  // Display name
+	Mode    ButtonMode `yaml:"mode"`             // How the button triggers its actions
+	Actions []Action   `yaml:"actions"`          // Actions run by this button
+	State   *bool      `yaml:"state,omitempty"`  // Current state of toggle buttons
+	Muted   bool       `yaml:"muted,omitempty"`  // Whether the control muted by this button is muted
 }
 
 // Controls contains all controller mappings
 type Controls struct {
 	Sliders map[string]SliderConfig `yaml:"sliders,omitempty"`
 	Knobs   map[string]KnobConfig   `yaml:"knobs,omitempty"`
+	Buttons map[string]ButtonConfig `yaml:"buttons,omitempty"`
+}
+
+// SceneVolume is the real volume of an assigned source captured in a scene
+type SceneVolume struct {
+	Source Source `yaml:"source"`
+	Volume int    `yaml:"volume"` // Volume (0-100)
+}
+
+// Schedule runs actions or recalls a scene at set times, like quiet hours
+type Schedule struct {
+	Name    string        `yaml:"name"`              // Identifies the schedule in logs and the history
+	Enabled *bool         `yaml:"enabled,omitempty"` // Defaults to true
+	At      string        `yaml:"at"`                // A time like 23:00 or 07:30 Mon-Fri, or a cron expression like 0 23 * * *
+	Actions []Action      `yaml:"actions,omitempty"` // Run like a button press
+	Scene   string        `yaml:"scene,omitempty"`   // Recalled before the actions run
+	CatchUp time.Duration `yaml:"catchUp,omitempty"` // A run missed while pulsekontrol was not running happens at startup if it was due at most this long ago
+	LastRun time.Time     `yaml:"lastRun,omitempty"` // When it last ran, kept by pulsekontrol
+}
+
+// IsEnabled reports whether the schedule runs
+func (schedule Schedule) IsEnabled() bool {
+	return schedule.Enabled == nil || *schedule.Enabled
+}
+
+// SceneConfig is a named snapshot of all control values
+type SceneConfig struct {
+	Sliders map[string]int `yaml:"sliders,omitempty"` // Slider values (0-100)
+	Knobs   map[string]int `yaml:"knobs,omitempty"`   // Knob values (0-100)
+	Volumes []SceneVolume  `yaml:"volumes,omitempty"` // Optional real volumes of assigned sources
+}
+
+// CombinedSinkConfig is an output device that plays on several others at
+// once, like speakers and headphones
+type CombinedSinkConfig struct {
+	Outputs []string `yaml:"outputs"` // Names of the output devices
+}
+
+// WebUIConfig contains web interface settings
+type WebUIConfig struct {
+	Enabled        *bool         `yaml:"enabled,omitempty"`        // Whether the web interface is started, defaults to true
+	Address        string        `yaml:"address,omitempty"`        // Listen address:port
+	AuthToken      string        `yaml:"authToken,omitempty"`      // Token required by the WebSocket and API, empty allows everyone
+	AllowedOrigins []string      `yaml:"allowedOrigins,omitempty"` // Origins allowed to open the WebSocket, empty allows all
+	PollInterval   time.Duration `yaml:"pollInterval,omitempty"`   // How often audio sources are polled while clients are connected, without PulseAudio events
+	MaxClients     int           `yaml:"maxClients,omitempty"`     // Maximum number of WebSocket clients, 0 is unlimited
+	Advertise      *bool         `yaml:"advertise,omitempty"`      // Whether the web interface is announced over mDNS, defaults to true
+	InstanceName   string        `yaml:"instanceName,omitempty"`   // mDNS instance name, defaults to "pulsekontrol on <hostname>"
+}
+
+// DBusConfig contains settings of the session bus interface
+type DBusConfig struct {
+	Enabled bool `yaml:"enabled,omitempty"` // Whether org.pulsekontrol.Control1 is exported, defaults to false
+}
+
+// NotificationsConfig contains settings of the desktop notifications
+type NotificationsConfig struct {
+	Enabled bool `yaml:"enabled,omitempty"` // Whether important events are shown as desktop notifications, defaults to false
+}
+
+// PulseAudioConfig contains how pulsekontrol talks to the sound server and
+// how long it waits for it
+type PulseAudioConfig struct {
+	Backend        AudioBackend  `yaml:"backend,omitempty"`        // Sound server to control, defaults to pulseaudio
+	Timeout        time.Duration `yaml:"timeout,omitempty"`        // After which an operation fails, defaults to 2s
+	ReconnectAfter int           `yaml:"reconnectAfter,omitempty"` // Timeouts in a row after which the connection is replaced, defaults to 3
+	MeterInterval  time.Duration `yaml:"meterInterval,omitempty"`  // How often the levels of metered streams are measured, defaults to 50ms
+}
+
+// HookConfig runs a command when an event happens. The event details are
+// passed as environment variables: PK_EVENT and, for example, PK_SOURCE_NAME.
+type HookConfig struct {
+	Event   string            `yaml:"event"`             // Event name, like source.added or profile.switched
+	Match   map[string]string `yaml:"match,omitempty"`   // Shell patterns the event details must match, by variable name without PK_
+	Command string            `yaml:"command"`           // Shell command, run with sh -c
+	Timeout time.Duration     `yaml:"timeout,omitempty"` // After which the command is killed, defaults to 10s
+}
+
+// WebhookConfig posts events to an HTTP endpoint as JSON
+type WebhookConfig struct {
+	URL      string            `yaml:"url"`                // http or https URL to post to
+	Events   []string          `yaml:"events,omitempty"`   // Events to post, like profile.switched, all supported ones if empty
+	Headers  map[string]string `yaml:"headers,omitempty"`  // Extra request headers, like Authorization
+	Secret   string            `yaml:"secret,omitempty"`   // Key of the HMAC-SHA256 signature of the body, not signed if empty
+	Insecure bool              `yaml:"insecure,omitempty"` // Don't verify the TLS certificate of the endpoint
+}
+
+// OSCConfig contains settings of the OSC listener for control surface apps
+type OSCConfig struct {
+	Enabled   bool              `yaml:"enabled,omitempty"`   // Whether the OSC listener is started, defaults to false
+	Address   string            `yaml:"address,omitempty"`   // UDP listen address:port, defaults to DefaultOSCAddress
+	Addresses map[string]string `yaml:"addresses,omitempty"` // OSC addresses by control id, replacing the derived ones
+}
+
+// ListenAddress returns the address to listen on
+func (osc OSCConfig) ListenAddress() string {
+	if osc.Address == "" {
+		return DefaultOSCAddress
+	}
+	return osc.Address
+}
+
+// HotkeysConfig contains settings of the keyboard shortcuts, read from the
+// Linux input devices for laptops without a controller
+type HotkeysConfig struct {
+	Enabled  bool            `yaml:"enabled,omitempty"`  // Whether the keyboards are read, defaults to false
+	Devices  []string        `yaml:"devices,omitempty"`  // Input devices like /dev/input/event3, all keyboards without
+	Bindings []HotkeyBinding `yaml:"bindings,omitempty"` // Key chords and their actions
+}
+
+// HotkeyBinding runs actions when a key chord is pressed, like a button
+type HotkeyBinding struct {
+	Keys    string   `yaml:"keys"`    // Chord like Super+F7 or Ctrl+Alt+M
+	Actions []Action `yaml:"actions"` // Actions run by the chord
+}
+
+// StreamDeckConfig contains settings of an Elgato Stream Deck used as buttons
+// whose key images show the state of their control
+type StreamDeckConfig struct {
+	Enabled       bool                  `yaml:"enabled,omitempty"`       // Whether the Stream Deck is used, defaults to false
+	Serial        string                `yaml:"serial,omitempty"`        // Serial number of the Stream Deck, the first one found without
+	Brightness    *int                  `yaml:"brightness,omitempty"`    // Backlight in percent, defaults to DefaultStreamDeckBrightness
+	RetryInterval time.Duration         `yaml:"retryInterval,omitempty"` // How often a missing Stream Deck is looked for, defaults to 5s
+	Keys          map[int]StreamDeckKey `yaml:"keys,omitempty"`          // Keys by number, left to right and top to bottom from 0
+}
+
+// DefaultStreamDeckBrightness is the backlight without a configured brightness
+const DefaultStreamDeckBrightness = 60
+
+// StreamDeckKey is a key of the Stream Deck, which runs actions like a button
+type StreamDeckKey struct {
+	Label   string   `yaml:"label,omitempty"` // Text on the key, the label of the muted control without
+	Actions []Action `yaml:"actions"`         // Actions run by the key
+}
+
+// HistoryConfig contains settings for the recent action history
+type HistoryConfig struct {
+	Size *int `yaml:"size,omitempty"` // Number of actions kept in memory, 0 disables the history
+}
+
+// DefaultProfile is the name of the profile single-profile configs are loaded into
+const DefaultProfile = "default"
+
+// Profile is a named set of controller mappings
+type Profile struct {
+	Controls Controls `yaml:"controls"` // Controller mappings
 }
 
 // Config is the root configuration structure
 type Config struct {
-	Device   DeviceConfig `yaml:"device"`   // MIDI device settings
-	Controls Controls     `yaml:"controls"` // Controller mappings
+	Version          int                           `yaml:"version"`                    // Schema version, see CurrentVersion
+	Device           DeviceConfig                  `yaml:"device,omitempty"`           // Single MIDI device (old format, migrated into Devices)
+	Devices          []DeviceConfig                `yaml:"devices,omitempty"`          // MIDI device settings
+	SingleDevice     bool                          `yaml:"singleDevice,omitempty"`     // Keep writing the old single device format
+	StartupSync      StartupSyncMode               `yaml:"startupSync,omitempty"`      // Sync direction at startup, defaults to push
+	DuplicateSources DuplicatePolicy               `yaml:"duplicateSources,omitempty"` // What assigning a source another control has does, see AssignSource
+	ControlDefaults  ControlDefaultsConfig         `yaml:"controlDefaults,omitempty"`  // Settings of sliders and knobs created when first moved
+	Controls         Controls                      `yaml:"controls,omitempty"`         // Controller mappings of the active profile
+	Profiles         map[string]Profile            `yaml:"profiles,omitempty"`         // Named controller mappings
+	ActiveProfile    string                        `yaml:"activeProfile,omitempty"`    // Name of the profile in use
+	Scenes           map[string]SceneConfig        `yaml:"scenes,omitempty"`           // Saved mixer snapshots
+	Schedules        []Schedule                    `yaml:"schedules,omitempty"`        // Actions run at set times
+	Aliases          map[string]string             `yaml:"aliases,omitempty"`          // Display names of audio sources keyed by type:name
+	CombinedSinks    map[string]CombinedSinkConfig `yaml:"combinedSinks,omitempty"`    // Output devices playing on several others, by name
+	Groups           map[string]SourceGroup        `yaml:"groups,omitempty"`           // Sources assigned to controls as a whole, by name
+	Excludes         []ExcludeRule                 `yaml:"excludes,omitempty"`         // Streams and devices left out everywhere
+	WebUI            WebUIConfig                   `yaml:"webui,omitempty"`            // Web interface settings
+	DBus             DBusConfig                    `yaml:"dbus,omitempty"`             // Session bus interface
+	OSC              OSCConfig                     `yaml:"osc,omitempty"`              // OSC listener
+	Hotkeys          HotkeysConfig                 `yaml:"hotkeys,omitempty"`          // Keyboard shortcuts
+	StreamDeck       StreamDeckConfig              `yaml:"streamdeck,omitempty"`       // Elgato Stream Deck keys
+	Notifications    NotificationsConfig           `yaml:"notifications,omitempty"`    // Desktop notifications
+	PulseAudio       PulseAudioConfig              `yaml:"pulseaudio,omitempty"`       // Sound server and timeouts of its operations
+	Hooks            map[string]HookConfig         `yaml:"hooks,omitempty"`            // Commands run on events, by hook name
+	Webhooks         []WebhookConfig               `yaml:"webhooks,omitempty"`         // HTTP endpoints events are posted to
+	History          HistoryConfig                 `yaml:"history,omitempty"`          // Recent action history
+	Logging          LoggingConfig                 `yaml:"logging,omitempty"`          // Log levels and output
+	Persistence      PersistenceConfig             `yaml:"persistence,omitempty"`      // Saving of runtime changes
+	Backups          BackupConfig                  `yaml:"backups,omitempty"`          // Config backups
+	StaleSources     StaleSourcesConfig            `yaml:"staleSources,omitempty"`     // Cleanup of sources that are no longer seen
+	Overrides        map[string]interface{}        `yaml:"overrides,omitempty"`        // Sections merged over the configuration, by host name
+
+	templates map[string]template // Raw values of fields with ${VAR} references, by YAML path
+	override  *overrideLayer      // The override applied for this host, nil if none
+}
+
+// AudioBackend is the sound server pulsekontrol controls
+type AudioBackend string
+
+const (
+	PulseAudioBackend AudioBackend = "pulseaudio" // PulseAudio or pipewire-pulse, over the PulseAudio protocol
+	PipeWireBackend   AudioBackend = "pipewire"   // PipeWire itself, with pw-dump and wpctl
+)
+
+// StartupSyncMode is the direction volumes and control values are synced in at startup
+type StartupSyncMode string
+
+const (
+	StartupSyncPush  StartupSyncMode = "push"  // Set the volumes to the stored control values
+	StartupSyncAdopt StartupSyncMode = "adopt" // Store the current volumes as control values
+	StartupSyncOff   StartupSyncMode = "off"   // Leave both alone
+)
+
+// DuplicatePolicy decides what happens when a source is assigned to a control
+// while another control already has it, as both would set its volume
+type DuplicatePolicy string
+
+const (
+	DuplicateMove  DuplicatePolicy = "move"  // Remove the source from the other controls
+	DuplicateWarn  DuplicatePolicy = "warn"  // Keep both assignments and warn about it
+	DuplicateAllow DuplicatePolicy = "allow" // Keep both assignments
+)
+
+// ControlDefaultsConfig contains the settings of sliders and knobs that are
+// moved without being configured. Paths and labels are patterns, see
+// DefaultControlPath.
+type ControlDefaultsConfig struct {
+	Create *bool  `yaml:"create,omitempty"` // Whether such controls are added, otherwise they are reported as unmapped, defaults to true
+	Value  *int   `yaml:"value,omitempty"`  // Initial value (0-100), defaults to the value the control sent
+	Path   string `yaml:"path,omitempty"`   // Control path pattern, defaults to Group{n}/{Type}
+	Label  string `yaml:"label,omitempty"`  // Display name pattern, like "Channel {n}"
+}
+
+// StaleSourcesConfig contains settings for the cleanup of sources that are no longer seen
+type StaleSourcesConfig struct {
+	AfterDays *int `yaml:"afterDays,omitempty"` // Days after which a source that wasn't seen counts as stale
+}
+
+// LoggingConfig contains the log levels and where logs are written
+type LoggingConfig struct {
+	GlobalLevel string            `yaml:"globalLevel,omitempty"` // Level of the modules not in perModule, defaults to debug
+	PerModule   map[string]string `yaml:"perModule,omitempty"`   // Levels by module: Actions, Configuration, Control, DBus, Hooks, Midi, Notifications, OSC, PulseAudio, WebUI or Webhooks
+	Format      string            `yaml:"format,omitempty"`      // console or json, defaults to console
+	File        string            `yaml:"file,omitempty"`        // Log file, empty logs to standard error
+	MaxSizeMB   int               `yaml:"maxSizeMB,omitempty"`   // Size in MB at which the log file is rotated, 0 never rotates
+}
+
+// PersistenceConfig contains settings for saving runtime changes
+type PersistenceConfig struct {
+	Enabled *bool `yaml:"enabled,omitempty"` // Whether changes are saved to the config file, defaults to true
+}
+
+// BackupConfig contains settings for the backups made before each save
+type BackupConfig struct {
+	Count *int `yaml:"count,omitempty"` // Number of numbered backups kept, 0 disables backups
+}
+
+// PrimaryDevice returns the first configured device, which controls use by default
+func (config *Config) PrimaryDevice() DeviceConfig {
+	if len(config.Devices) == 0 {
+		return config.Device
+	}
+	return config.Devices[0]
+}
+
+// DeviceByName returns the configured device with the given name
+func (config *Config) DeviceByName(name string) (DeviceConfig, bool) {
+	for _, device := range config.Devices {
+		if device.Name == name {
+			return device, true
+		}
+	}
+	return DeviceConfig{}, false
+}
+
+// ControlDevice returns the name of the device a control with the given device reference belongs to
+func (config *Config) ControlDevice(device string) string {
+	if device != "" {
+		return device
+	}
+	return config.PrimaryDevice().Name
 }
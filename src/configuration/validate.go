@@ -0,0 +1,1034 @@
+package configuration
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/cron"
+	"gopkg.in/yaml.v3"
+)
+
+var validSourceTypes = map[PulseAudioTargetType]bool{
+	PlaybackStream: true,
+	RecordStream:   true,
+	OutputDevice:   true,
+	InputDevice:    true,
+}
+
+var validActionTypes = map[PulseAudioActionType]bool{
+	SetVolume:                          true,
+	SetDefaultOutput:                   true,
+	SetDefaultInput:                    true,
+	MediaPlayPause:                     true,
+	AssignFocusedWindowPlaybackStreams: true,
+	RecallScene:                        true,
+	ToggleMute:                         true,
+	Mute:                               true,
+	Unmute:                             true,
+	MoveStreamToSink:                   true,
+	StepControl:                        true,
+	CycleDefaultOutput:                 true,
+	SetCardProfile:                     true,
+	ToggleLoopback:                     true,
+	LoadLoopback:                       true,
+	UnloadLoopback:                     true,
+	ToggleEchoCancel:                   true,
+	ToggleMicMute:                      true,
+	ToggleSuspendOutput:                true,
+	SuspendOutput:                      true,
+	ResumeOutput:                       true,
+	VolumeUp:                           true,
+	VolumeDown:                         true,
+}
+
+var validDeviceTypes = map[MidiDeviceType]bool{
+	Generic:          true,
+	KorgNanoKontrol2: true,
+}
+
+var validStartupSyncModes = map[StartupSyncMode]bool{
+	StartupSyncPush:  true,
+	StartupSyncAdopt: true,
+	StartupSyncOff:   true,
+}
+
+var validDuplicatePolicies = map[DuplicatePolicy]bool{
+	DuplicateMove:  true,
+	DuplicateWarn:  true,
+	DuplicateAllow: true,
+}
+
+var validButtonModes = map[ButtonMode]bool{
+	Momentary: true,
+	Toggle:    true,
+	WhileHeld: true,
+}
+
+// ValidationIssue is a single problem found in the configuration
+type ValidationIssue struct {
+	Path    string // YAML path of the offending field, e.g. controls.sliders.slider1.value
+	Line    int    // Line in the configuration file, 0 if unknown
+	Message string
+	Warning bool // Warnings are reported but don't prevent startup
+}
+
+func (issue ValidationIssue) String() string {
+	level := "error"
+	if issue.Warning {
+		level = "warning"
+	}
+	if issue.Line > 0 {
+		return fmt.Sprintf("%s: %s (line %d): %s", level, issue.Path, issue.Line, issue.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", level, issue.Path, issue.Message)
+}
+
+// ValidationError is returned when the configuration has hard errors
+type ValidationError struct {
+	Issues []ValidationIssue
+}
+
+func (err *ValidationError) Error() string {
+	lines := make([]string, 0, len(err.Issues))
+	for _, issue := range err.Issues {
+		lines = append(lines, issue.String())
+	}
+	return fmt.Sprintf("invalid configuration:\n  %s", strings.Join(lines, "\n  "))
+}
+
+// validator collects issues while walking the configuration
+type validator struct {
+	root    *yaml.Node
+	issues  []ValidationIssue
+	devices map[string]DeviceConfig // Devices by name, with their type filled in
+	primary string                  // Name of the device controls use by default
+}
+
+func (v *validator) add(warning bool, path string, format string, args ...interface{}) {
+	v.issues = append(v.issues, ValidationIssue{
+		Path:    path,
+		Line:    lineOf(v.root, path),
+		Message: fmt.Sprintf(format, args...),
+		Warning: warning,
+	})
+}
+
+func (v *validator) errorf(path string, format string, args ...interface{}) {
+	v.add(false, path, format, args...)
+}
+
+func (v *validator) warnf(path string, format string, args ...interface{}) {
+	v.add(true, path, format, args...)
+}
+
+// Validate checks a configuration as it was read from disk. The optional
+// root node of the parsed file is used to report line numbers.
+func Validate(config *Config, root *yaml.Node) []ValidationIssue {
+	v := &validator{root: root}
+	v.validateDevices(config)
+
+	if len(config.Profiles) == 0 {
+		v.validateControls(config, "controls", config.Controls)
+	} else {
+		for _, name := range profileNames(config.Profiles) {
+			v.validateControls(config, "profiles."+name+".controls", config.Profiles[name].Controls)
+		}
+		if config.ActiveProfile != "" {
+			if _, ok := config.Profiles[config.ActiveProfile]; !ok {
+				v.warnf("activeProfile", "profile %q does not exist", config.ActiveProfile)
+			}
+		}
+	}
+
+	v.validateWebUI(config.WebUI)
+	v.validateOSC(config)
+	v.validateHotkeys(config)
+	v.validateStreamDeck(config)
+	v.validatePulseAudio(config.PulseAudio)
+	v.validateSchedules(config)
+	v.validateAliases(config.Aliases)
+	v.validateHooks(config.Hooks)
+	v.validateWebhooks(config.Webhooks)
+	v.validateLogging(config.Logging)
+	v.validateControlDefaults(config.ControlDefaults)
+
+	if config.StartupSync != "" && !validStartupSyncModes[config.StartupSync] {
+		v.errorf("startupSync", "unknown startup sync mode %q, use push, adopt or off", config.StartupSync)
+	}
+	if config.DuplicateSources != "" && !validDuplicatePolicies[config.DuplicateSources] {
+		v.errorf("duplicateSources", "unknown duplicate source policy %q, use move, warn or allow", config.DuplicateSources)
+	}
+	if config.Backups.Count != nil && *config.Backups.Count < 0 {
+		v.errorf("backups.count", "backup count %d must not be negative", *config.Backups.Count)
+	}
+	if config.StaleSources.AfterDays != nil && *config.StaleSources.AfterDays < 1 {
+		v.errorf("staleSources.afterDays", "stale source threshold %d must be at least one day", *config.StaleSources.AfterDays)
+	}
+
+	v.validateCombinedSinks(config.CombinedSinks)
+	v.validateGroups(config.Groups)
+	v.validateExcludes(config.Excludes)
+
+	for name, scene := range config.Scenes {
+		for i, volume := range scene.Volumes {
+			path := fmt.Sprintf("scenes.%s.volumes.%d", name, i)
+			v.validateSource(path+".source", volume.Source)
+			v.validateValue(path+".volume", volume.Volume)
+		}
+	}
+
+	return v.issues
+}
+
+// HasErrors reports whether any of the issues is a hard error
+func HasErrors(issues []ValidationIssue) bool {
+	for _, issue := range issues {
+		if !issue.Warning {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *validator) validateDevices(config *Config) {
+	v.devices = make(map[string]DeviceConfig)
+
+	prefix := "devices"
+	devices := config.Devices
+	if len(devices) == 0 {
+		// Old single device format, missing fields are filled in by the defaults
+		device := defaultDevice()
+		if config.Device.Name != "" {
+			device.Name = config.Device.Name
+		}
+		device.Type = config.Device.Type
+		device.Channel = config.Device.Channel
+		prefix = "device"
+		devices = []DeviceConfig{device}
+	} else if config.Device.Name != "" {
+		v.warnf("device", "both device and devices are configured, device is ignored")
+	}
+
+	identities := make(map[DeviceIdentity]string)
+	for i, device := range devices {
+		path := prefix
+		if prefix == "devices" {
+			path = fmt.Sprintf("devices.%d", i)
+		}
+		if !device.Identity.IsZero() {
+			if other, exists := identities[device.Identity]; exists {
+				v.errorf(path+".identity", "identity is already used by device %q", other)
+			}
+			identities[device.Identity] = device.Name
+		}
+		name := device.Name
+		if name == "" {
+			name = defaultDevice().Name
+		}
+		if _, exists := v.devices[name]; exists {
+			v.errorf(path+".name", "device name %q is already used", name)
+		}
+		deviceType := device.Type
+		if deviceType == "" {
+			deviceType = KorgNanoKontrol2
+		}
+		if !validDeviceTypes[deviceType] {
+			v.errorf(path+".type", "unknown device type %q, expected KorgNanoKontrol2 or Generic", device.Type)
+		}
+		if device.Channel != nil && *device.Channel > 15 {
+			v.errorf(path+".channel", "channel %d is out of range 0-15", *device.Channel)
+		}
+		switch device.Transport {
+		case "", LocalTransport:
+			if device.Host != "" || device.Port != 0 || device.SessionName != "" {
+				v.warnf(path+".transport", "host, port and sessionName are only used by the rtpmidi transport")
+			}
+		case RTPMIDITransport:
+			if device.Host == "" {
+				v.errorf(path+".host", "the rtpmidi transport needs the host of the session")
+			}
+			// The data port follows the control port
+			if device.Port < 0 || device.Port > 65534 {
+				v.errorf(path+".port", "port %d is out of range 1-65534", device.Port)
+			}
+		default:
+			v.errorf(path+".transport", "unknown transport %q, expected local or rtpmidi", device.Transport)
+		}
+		if device.RetryInterval < 0 {
+			v.errorf(path+".retryInterval", "retryInterval %s must not be negative", device.RetryInterval)
+		}
+		if deviceType == Generic {
+			v.validateControlMap(path+".controlMap", device.ControlMap)
+		} else if len(device.ControlMap.Sliders)+len(device.ControlMap.Knobs)+len(device.ControlMap.Buttons) > 0 {
+			v.warnf(path+".controlMap", "controlMap is only used by Generic devices, %s has fixed controls", deviceType)
+		}
+		device.Type = deviceType
+		v.devices[name] = device
+		if i == 0 {
+			v.primary = name
+		}
+	}
+}
+
+// validateControlMap checks the control paths a Generic device declares
+func (v *validator) validateControlMap(path string, controlMap ControlMap) {
+	for _, controlType := range []string{"slider", "knob", "button"} {
+		for _, controlPath := range controlMap.Paths(controlType) {
+			binding, _ := controlMap.Binding(controlType, controlPath)
+			fieldPath := path + "." + controlType + "s." + controlPath
+			switch binding.MessageType() {
+			case ControlChange:
+			case Note:
+				if controlType != "button" {
+					v.errorf(fieldPath+".type", "a %s must send ControlChange messages", controlType)
+				}
+			default:
+				v.errorf(fieldPath+".type", "unknown message type %q, expected ControlChange or Note", binding.Type)
+			}
+			if binding.Number > 127 {
+				v.errorf(fieldPath+".number", "number %d is out of range 0-127", binding.Number)
+			}
+		}
+	}
+}
+
+// checkDevice validates a control's device reference and its path against the
+// controls of the device, unless they are unknown. It reports whether the path
+// is valid, so that it can be checked for duplicates.
+func (v *validator) checkDevice(path string, controlType string, deviceName string, controlPath string) bool {
+	if deviceName == "" {
+		deviceName = v.primary
+	}
+	device, ok := v.devices[deviceName]
+	if !ok {
+		v.errorf(path+".device", "device %q does not exist", deviceName)
+		return false
+	}
+	controlMap, known := device.Capabilities()
+	if !known {
+		return true
+	}
+	if _, ok := controlMap.Binding(controlType, controlPath); !ok {
+		v.errorf(path+".path", "device %q has no %s %q%s", deviceName, controlType, controlPath, device.missingPathHint(controlType))
+		return false
+	}
+	return true
+}
+
+func (v *validator) validateControls(config *Config, prefix string, controls Controls) {
+	paths := make(map[string]string)
+	checkDuplicatePath := func(fieldPath string, device string, controlPath string) {
+		// Control paths only have to be unique per device
+		if device == "" {
+			device = v.primary
+		}
+		key := device + "\x00" + controlPath
+		if other, exists := paths[key]; exists {
+			v.errorf(fieldPath, "control path %s is already used by %s", controlPath, other)
+			return
+		}
+		paths[key] = fieldPath
+	}
+	virtualSinks := make(map[string]string)
+	checkVirtualSink := func(fieldPath string, name string) {
+		if name == "" {
+			return
+		}
+		if strings.TrimSpace(name) == "" || strings.ContainsAny(name, "'\"") {
+			v.errorf(fieldPath, "virtual sink name %q must not be empty or contain quotes", name)
+		}
+		if _, combined := config.CombinedSinks[name]; combined {
+			v.errorf(fieldPath, "virtual sink %q has the name of a combined sink", name)
+		}
+		if other, exists := virtualSinks[VirtualSinkName(name)]; exists {
+			v.errorf(fieldPath, "virtual sink %q is already the one of %s", name, other)
+			return
+		}
+		virtualSinks[VirtualSinkName(name)] = fieldPath
+	}
+
+	for _, id := range sortedKeys(controls.Sliders) {
+		slider := controls.Sliders[id]
+		path := prefix + ".sliders." + id
+		if v.checkDevice(path, "slider", slider.Device, slider.Path) {
+			checkDuplicatePath(path+".path", slider.Device, slider.Path)
+		}
+		v.validateValue(path+".value", slider.Value)
+		v.validateStepSize(path+".stepSize", slider.StepSize)
+		v.validateMidiRange(path, slider.MinValue, slider.MaxValue)
+		v.validateMaxVolume(path+".maxVolume", slider.MaxVolume)
+		v.validateScale(path, slider.Scale, slider.FloorDb)
+		for i, source := range slider.Sources {
+			v.validateSource(fmt.Sprintf("%s.sources.%d", path, i), source)
+		}
+		v.validateControlGroups(config, path, slider.Groups)
+		checkVirtualSink(path+".virtualSink", slider.VirtualSink)
+	}
+
+	for _, id := range sortedKeys(controls.Knobs) {
+		knob := controls.Knobs[id]
+		path := prefix + ".knobs." + id
+		if v.checkDevice(path, "knob", knob.Device, knob.Path) {
+			checkDuplicatePath(path+".path", knob.Device, knob.Path)
+		}
+		v.validateValue(path+".value", knob.Value)
+		v.validateStepSize(path+".stepSize", knob.StepSize)
+		v.validateMidiRange(path, knob.MinValue, knob.MaxValue)
+		v.validateMaxVolume(path+".maxVolume", knob.MaxVolume)
+		v.validateScale(path, knob.Scale, knob.FloorDb)
+		if knob.ControlsBalance() && knob.MaxVolume != 0 {
+			v.warnf(path+".maxVolume", "the knob sets the balance, maxVolume has no effect")
+		}
+		if knob.ControlsBalance() && knob.Scale != "" {
+			v.warnf(path+".scale", "the knob sets the balance, scale has no effect")
+		}
+		if knob.Action != "" && knob.Action != SetVolume && knob.Action != BalanceControl {
+			v.errorf(path+".action", "knobs set the volume (SetVolume) or the balance (BalanceControl), not %s", knob.Action)
+		}
+		for i, source := range knob.Sources {
+			v.validateSource(fmt.Sprintf("%s.sources.%d", path, i), source)
+		}
+		v.validateControlGroups(config, path, knob.Groups)
+		checkVirtualSink(path+".virtualSink", knob.VirtualSink)
+	}
+
+	if config.DuplicateSources != DuplicateAllow {
+		v.checkDuplicateSources(prefix, controls)
+	}
+
+	for _, id := range sortedKeys(controls.Buttons) {
+		button := controls.Buttons[id]
+		path := prefix + ".buttons." + id
+		if v.checkDevice(path, "button", button.Device, button.Path) {
+			checkDuplicatePath(path+".path", button.Device, button.Path)
+		}
+		if button.Mode != "" && !validButtonModes[button.Mode] {
+			v.errorf(path+".mode", "unknown button mode %q, expected momentary, toggle or whileHeld", button.Mode)
+		}
+		for i, action := range button.Actions {
+			v.validateAction(config, controls, fmt.Sprintf("%s.actions.%d", path, i), action)
+		}
+	}
+}
+
+// checkDuplicateSources warns about sources assigned to more than one slider
+// or knob, which would fight over the volume
+func (v *validator) checkDuplicateSources(prefix string, controls Controls) {
+	type assignment struct {
+		path    string
+		source  Source
+		balance bool
+	}
+	var assigned []assignment
+	check := func(path string, sources []Source, balance bool) {
+		for i, source := range sources {
+			for _, other := range assigned {
+				// A balance knob and a volume control of a source don't conflict
+				if other.balance == balance && other.source.Overlaps(source) {
+					what := "volume"
+					if balance {
+						what = "balance"
+					}
+					v.warnf(fmt.Sprintf("%s.sources.%d", path, i), "source %s is also assigned to %s, both set its %s", source.Name, other.path, what)
+					break
+				}
+			}
+		}
+		// Overlapping sources of the same control don't conflict
+		for _, source := range sources {
+			assigned = append(assigned, assignment{path: path, source: source, balance: balance})
+		}
+	}
+	for _, id := range sortedKeys(controls.Sliders) {
+		check(prefix+".sliders."+id, controls.Sliders[id].Sources, false)
+	}
+	for _, id := range sortedKeys(controls.Knobs) {
+		check(prefix+".knobs."+id, controls.Knobs[id].Sources, controls.Knobs[id].ControlsBalance())
+	}
+}
+
+func (v *validator) validateAliases(aliases map[string]string) {
+	for _, key := range sortedKeys(aliases) {
+		path := "aliases." + key
+		sourceType, name, ok := splitAliasKey(key)
+		if !ok || name == "" {
+			v.errorf(path, "invalid alias key %q, expected type:name", key)
+			continue
+		}
+		if !validSourceTypes[sourceType] {
+			v.errorf(path, "unknown source type %q, expected PlaybackStream, RecordStream, OutputDevice or InputDevice", sourceType)
+		}
+		if strings.TrimSpace(aliases[key]) == "" {
+			v.errorf(path, "alias must not be empty")
+		}
+	}
+}
+
+// validateExpanded checks the fields that could only be validated after
+// their variable references were expanded
+func validateExpanded(config *Config) error {
+	v := &validator{}
+	if _, ok := config.templates["webui.address"]; ok {
+		v.validateWebUI(WebUIConfig{Address: config.WebUI.Address})
+	}
+	for i, webhook := range config.Webhooks {
+		path := fmt.Sprintf("webhooks.%d.url", i)
+		if _, ok := config.templates[path]; ok {
+			v.validateWebhookURL(path, webhook.URL)
+		}
+	}
+	if HasErrors(v.issues) {
+		return &ValidationError{Issues: v.issues}
+	}
+	return nil
+}
+
+// validateListenAddress checks a host:port address to listen on
+func (v *validator) validateListenAddress(path string, address string) {
+	// Addresses with variable references are checked after expansion
+	if address == "" || strings.Contains(address, "${") {
+		return
+	}
+	if _, port, err := net.SplitHostPort(address); err != nil {
+		v.errorf(path, "invalid address %q, expected host:port: %s", address, err)
+	} else if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		v.errorf(path, "invalid port %q in address %q", port, address)
+	}
+}
+
+func (v *validator) validateWebUI(webUI WebUIConfig) {
+	v.validateListenAddress("webui.address", webUI.Address)
+	if webUI.PollInterval < 0 {
+		v.errorf("webui.pollInterval", "poll interval %s must not be negative", webUI.PollInterval)
+	}
+	if webUI.MaxClients < 0 {
+		v.errorf("webui.maxClients", "maximum number of clients %d must not be negative", webUI.MaxClients)
+	}
+}
+
+func (v *validator) validateOSC(config *Config) {
+	osc := config.OSC
+	v.validateListenAddress("osc.address", osc.Address)
+	// Addresses apply to every profile, and controls missing from the file
+	// are backfilled from the defaults
+	controls := []Controls{config.Controls, GetDefaultConfig().Controls}
+	for _, name := range profileNames(config.Profiles) {
+		controls = append(controls, config.Profiles[name].Controls)
+	}
+	known := func(id string) bool {
+		for _, controls := range controls {
+			_, isSlider := controls.Sliders[id]
+			_, isKnob := controls.Knobs[id]
+			_, isButton := controls.Buttons[id]
+			if isSlider || isKnob || isButton {
+				return true
+			}
+		}
+		return false
+	}
+	seen := make(map[string]string, len(osc.Addresses))
+	for _, id := range sortedKeys(osc.Addresses) {
+		path := "osc.addresses." + id
+		address := osc.Addresses[id]
+		if !strings.HasPrefix(address, "/") {
+			v.errorf(path, "OSC address %q must start with /", address)
+		} else if other, ok := seen[address]; ok {
+			v.errorf(path, "OSC address %q is also used by %s", address, other)
+		}
+		seen[address] = id
+		if !known(id) {
+			v.warnf(path, "no control %q", id)
+		}
+	}
+}
+
+func (v *validator) validateHotkeys(config *Config) {
+	for i, binding := range config.Hotkeys.Bindings {
+		path := fmt.Sprintf("hotkeys.bindings.%d", i)
+		if strings.TrimSpace(binding.Keys) == "" {
+			v.errorf(path+".keys", "hotkey has no keys")
+		}
+		if len(binding.Actions) == 0 {
+			v.warnf(path+".actions", "hotkey %s has no actions", binding.Keys)
+		}
+		for j, action := range binding.Actions {
+			v.validateAction(config, config.Controls, fmt.Sprintf("%s.actions.%d", path, j), action)
+		}
+	}
+	for i, device := range config.Hotkeys.Devices {
+		if !strings.HasPrefix(device, "/") {
+			v.errorf(fmt.Sprintf("hotkeys.devices.%d", i), "input device %q must be an absolute path like /dev/input/event3", device)
+		}
+	}
+}
+
+func (v *validator) validateSchedules(config *Config) {
+	names := make(map[string]bool)
+	for i, schedule := range config.Schedules {
+		path := fmt.Sprintf("schedules.%d", i)
+		switch {
+		case schedule.Name == "":
+			v.errorf(path+".name", "schedule has no name")
+		case names[schedule.Name]:
+			v.errorf(path+".name", "schedule %s is defined twice", schedule.Name)
+		}
+		names[schedule.Name] = true
+		if _, err := cron.Parse(schedule.At); err != nil {
+			v.errorf(path+".at", "%v", err)
+		}
+		if schedule.CatchUp < 0 {
+			v.errorf(path+".catchUp", "catch-up window %s must not be negative", schedule.CatchUp)
+		}
+		if len(schedule.Actions) == 0 && schedule.Scene == "" {
+			v.warnf(path, "schedule %s has neither actions nor a scene", schedule.Name)
+		}
+		if _, ok := config.Scenes[schedule.Scene]; schedule.Scene != "" && !ok {
+			v.warnf(path+".scene", "scene %q does not exist", schedule.Scene)
+		}
+		for j, action := range schedule.Actions {
+			v.validateAction(config, config.Controls, fmt.Sprintf("%s.actions.%d", path, j), action)
+		}
+	}
+}
+
+func (v *validator) validatePulseAudio(pulseAudio PulseAudioConfig) {
+	if pulseAudio.Backend != "" && pulseAudio.Backend != PulseAudioBackend && pulseAudio.Backend != PipeWireBackend {
+		v.errorf("pulseaudio.backend", "unknown backend %q, use pulseaudio or pipewire", pulseAudio.Backend)
+	}
+	if pulseAudio.Timeout < 0 {
+		v.errorf("pulseaudio.timeout", "timeout %s must not be negative", pulseAudio.Timeout)
+	}
+	if pulseAudio.ReconnectAfter < 0 {
+		v.errorf("pulseaudio.reconnectAfter", "reconnectAfter %d must not be negative", pulseAudio.ReconnectAfter)
+	}
+	if pulseAudio.MeterInterval != 0 && pulseAudio.MeterInterval < 10*time.Millisecond {
+		v.errorf("pulseaudio.meterInterval", "meter interval %s is shorter than 10ms", pulseAudio.MeterInterval)
+	}
+}
+
+func (v *validator) validateStreamDeck(config *Config) {
+	streamDeck := config.StreamDeck
+	if streamDeck.Brightness != nil && (*streamDeck.Brightness < 0 || *streamDeck.Brightness > 100) {
+		v.errorf("streamdeck.brightness", "brightness %d is out of range 0-100", *streamDeck.Brightness)
+	}
+	if streamDeck.RetryInterval < 0 {
+		v.errorf("streamdeck.retryInterval", "retry interval %s must not be negative", streamDeck.RetryInterval)
+	}
+	keys := make([]int, 0, len(streamDeck.Keys))
+	for key := range streamDeck.Keys {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+	// Keys apply to every profile
+	controls := everyProfileControls(config)
+	for _, key := range keys {
+		path := fmt.Sprintf("streamdeck.keys.%d", key)
+		if key < 0 {
+			v.errorf(path, "key %d must not be negative", key)
+		}
+		for i, action := range streamDeck.Keys[key].Actions {
+			v.validateAction(config, controls, fmt.Sprintf("%s.actions.%d", path, i), action)
+		}
+	}
+}
+
+// everyProfileControls returns the controls of the file together with those
+// of all profiles
+func everyProfileControls(config *Config) Controls {
+	controls := Controls{
+		Sliders: make(map[string]SliderConfig),
+		Knobs:   make(map[string]KnobConfig),
+		Buttons: make(map[string]ButtonConfig),
+	}
+	add := func(from Controls) {
+		maps.Copy(controls.Sliders, from.Sliders)
+		maps.Copy(controls.Knobs, from.Knobs)
+		maps.Copy(controls.Buttons, from.Buttons)
+	}
+	add(config.Controls)
+	for _, profile := range config.Profiles {
+		add(profile.Controls)
+	}
+	return controls
+}
+
+func (v *validator) validateHooks(hooks map[string]HookConfig) {
+	for _, name := range sortedKeys(hooks) {
+		hook := hooks[name]
+		path := "hooks." + name
+		if hook.Event == "" {
+			v.errorf(path+".event", "hook %s has no event", name)
+		}
+		if strings.TrimSpace(hook.Command) == "" {
+			v.errorf(path+".command", "hook %s has no command", name)
+		}
+		if hook.Timeout < 0 {
+			v.errorf(path+".timeout", "timeout %s must not be negative", hook.Timeout)
+		}
+		for _, key := range sortedKeys(hook.Match) {
+			if _, err := filepath.Match(hook.Match[key], ""); err != nil {
+				v.errorf(path+".match."+key, "invalid pattern %q: %v", hook.Match[key], err)
+			}
+		}
+	}
+}
+
+func (v *validator) validateWebhooks(webhooks []WebhookConfig) {
+	for i, webhook := range webhooks {
+		path := fmt.Sprintf("webhooks.%d", i)
+		var endpoint *url.URL
+		// URLs with variable references are checked after expansion
+		if !strings.Contains(webhook.URL, "${") {
+			endpoint = v.validateWebhookURL(path+".url", webhook.URL)
+		}
+		if webhook.Insecure && endpoint != nil && endpoint.Scheme == "https" {
+			v.warnf(path+".insecure", "the TLS certificate of %s is not verified", endpoint.Host)
+		}
+	}
+}
+
+// validateWebhookURL checks the url of a webhook and returns it parsed, nil
+// if it couldn't be parsed
+func (v *validator) validateWebhookURL(path string, rawURL string) *url.URL {
+	endpoint, err := url.Parse(rawURL)
+	switch {
+	case rawURL == "":
+		v.errorf(path, "webhook has no url")
+	case err != nil:
+		v.errorf(path, "invalid url %q: %v", rawURL, err)
+	case endpoint.Scheme != "http" && endpoint.Scheme != "https":
+		v.errorf(path, "url %q must start with http:// or https://", rawURL)
+	case endpoint.Host == "":
+		v.errorf(path, "url %q has no host", rawURL)
+	}
+	return endpoint
+}
+
+func (v *validator) validateValue(path string, value int) {
+	if value < 0 || value > 100 {
+		v.errorf(path, "value %d is out of range 0-100", value)
+	}
+}
+
+// validateStepSize checks a step size, zero means the default
+func (v *validator) validateStepSize(path string, stepSize int) {
+	if stepSize != 0 && (stepSize < 1 || stepSize > MaxStepSize) {
+		v.errorf(path, "step size %d is out of range 1-%d", stepSize, MaxStepSize)
+	}
+}
+
+// validateExcludes checks the patterns of the exclude rules
+func (v *validator) validateExcludes(rules []ExcludeRule) {
+	checkPattern := func(path string, pattern string) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			v.errorf(path, "invalid pattern %q: %v", pattern, err)
+		}
+	}
+	for i, rule := range rules {
+		path := fmt.Sprintf("excludes.%d", i)
+		if rule.Type != "" && !validSourceTypes[rule.Type] {
+			v.errorf(path+".type", "unknown source type %q, expected PlaybackStream, RecordStream, OutputDevice or InputDevice", rule.Type)
+		}
+		if rule.Name == "" && rule.BinaryName == "" && len(rule.Properties) == 0 {
+			v.errorf(path, "the rule leaves out everything, set a name, binaryName or properties")
+		}
+		checkPattern(path+".name", rule.Name)
+		checkPattern(path+".binaryName", rule.BinaryName)
+		for _, key := range sortedKeys(rule.Properties) {
+			checkPattern(path+".properties."+key, rule.Properties[key])
+		}
+	}
+}
+
+// validateGroups checks the members of the source groups
+func (v *validator) validateGroups(groups map[string]SourceGroup) {
+	for _, name := range sortedKeys(groups) {
+		path := "groups." + name
+		if name == "" {
+			v.errorf(path, "group name must not be empty")
+		}
+		if len(groups[name]) == 0 {
+			v.warnf(path, "group %s has no members", name)
+		}
+		for i, member := range groups[name] {
+			v.validateSource(fmt.Sprintf("%s.%d", path, i), member)
+		}
+	}
+}
+
+// validateControlGroups checks that the groups of a control exist
+func (v *validator) validateControlGroups(config *Config, path string, groups []string) {
+	for i, name := range groups {
+		if _, ok := config.Groups[name]; !ok {
+			v.errorf(fmt.Sprintf("%s.groups.%d", path, i), "group %q does not exist", name)
+		}
+	}
+}
+
+// validateCombinedSinks checks the combined output devices, which must not
+// combine each other
+func (v *validator) validateCombinedSinks(sinks map[string]CombinedSinkConfig) {
+	names := make([]string, 0, len(sinks))
+	for name := range sinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	sinkNames := make(map[string]string)
+	for _, name := range names {
+		path := "combinedSinks." + name
+		if strings.TrimSpace(name) == "" || strings.ContainsAny(name, "'\"") {
+			v.errorf(path, "combined sink name %q must not be empty or contain quotes", name)
+		}
+		if other, ok := sinkNames[CombinedSinkName(name)]; ok {
+			v.errorf(path, "combined sinks %q and %q differ only in case or punctuation", other, name)
+		}
+		sinkNames[CombinedSinkName(name)] = name
+		if len(sinks[name].Outputs) == 0 {
+			v.errorf(path+".outputs", "combined sink %q requires the names of the output devices", name)
+		}
+		for i, output := range sinks[name].Outputs {
+			switch _, combined := sinks[output]; {
+			case output == "":
+				v.errorf(fmt.Sprintf("%s.outputs.%d", path, i), "empty output device name")
+			case combined:
+				v.errorf(fmt.Sprintf("%s.outputs.%d", path, i), "combined sink %q can't play on combined sink %q", name, output)
+			}
+		}
+	}
+}
+
+// validateScale checks how the value of a control maps to a volume
+func (v *validator) validateScale(path string, scale VolumeScale, floorDb int) {
+	if !scale.Known() {
+		v.errorf(path+".scale", "unknown scale %s, expected %s, %s or %s", scale, LinearScale, CubicScale, DecibelScale)
+	}
+	if floorDb == 0 {
+		return
+	}
+	if floorDb > -1 || floorDb < MinFloorDb {
+		v.errorf(path+".floorDb", "floor %d dB is out of range %d to -1 dB", floorDb, MinFloorDb)
+	}
+	if scale != DecibelScale {
+		v.warnf(path+".floorDb", "floorDb only applies to the %s scale", DecibelScale)
+	}
+}
+
+// validateMaxVolume checks the volume at the top of a control, zero means the default
+func (v *validator) validateMaxVolume(path string, maxVolume int) {
+	if maxVolume != 0 && (maxVolume < 1 || maxVolume > MaxVolumeLimit) {
+		v.errorf(path, "maximum volume %d%% is out of range 1-%d%%", maxVolume, MaxVolumeLimit)
+	}
+}
+
+// validateMidiRange checks the MIDI value bounds of a control, zero means the default
+func (v *validator) validateMidiRange(path string, minValue uint8, maxValue uint8) {
+	if minValue > 127 {
+		v.errorf(path+".minValue", "minimum MIDI value %d is out of range 0-127", minValue)
+	}
+	if maxValue > 127 {
+		v.errorf(path+".maxValue", "maximum MIDI value %d is out of range 0-127", maxValue)
+	}
+	if maxValue != 0 && minValue >= maxValue {
+		v.errorf(path+".minValue", "minimum MIDI value %d must be below the maximum %d", minValue, maxValue)
+	}
+}
+
+func (v *validator) validateSource(path string, source Source) {
+	if !validSourceTypes[source.Type] {
+		v.errorf(path+".type", "unknown source type %q, expected PlaybackStream, RecordStream, OutputDevice or InputDevice", source.Type)
+	}
+	if source.Name == "" {
+		v.errorf(path+".name", "source name must not be empty")
+	}
+	v.validateProcess(path, source.Type, source.ProcessID, source.Cgroup)
+}
+
+// validateProcess checks the process id and cgroup streams are narrowed to
+func (v *validator) validateProcess(path string, sourceType PulseAudioTargetType, processID int, cgroup string) {
+	if processID < 0 {
+		v.errorf(path+".processId", "process id %d must not be negative", processID)
+	}
+	if (processID != 0 || cgroup != "") && (sourceType == OutputDevice || sourceType == InputDevice) {
+		v.errorf(path, "devices belong to no process, processId and cgroup only apply to streams")
+	}
+}
+
+func (v *validator) validateAction(config *Config, controls Controls, path string, action Action) {
+	if !validActionTypes[action.Type] {
+		v.warnf(path+".type", "unknown action type %q, the action will be ignored", action.Type)
+		return
+	}
+
+	switch target := action.Target.(type) {
+	case *TypedTarget:
+		if !validSourceTypes[target.Type] {
+			v.errorf(path+".target.type", "unknown target type %q", target.Type)
+		}
+		if action.IsMute() && target.Name == "" {
+			v.errorf(path+".target.name", "action %s requires the name of the source", action.Type)
+		}
+		v.validateProcess(path+".target", target.Type, target.ProcessID, target.Cgroup)
+	case *ControlTarget:
+		v.validateControlTarget(controls, path, target.ControlType, target.ControlID)
+	case *StepTarget:
+		v.validateControlTarget(controls, path, target.ControlType, target.ControlID)
+		if target.Direction != 1 && target.Direction != -1 {
+			v.errorf(path+".target.direction", "direction %d must be 1 or -1", target.Direction)
+		}
+		v.validateStepSize(path+".target.stepSize", target.StepSize)
+	case *VolumeStepTarget:
+		if !validSourceTypes[target.Type] {
+			v.errorf(path+".target.type", "unknown target type %q", target.Type)
+		}
+		if target.Name == "" {
+			v.errorf(path+".target.name", "action %s requires the name of the source", action.Type)
+		}
+		v.validateProcess(path+".target", target.Type, target.ProcessID, target.Cgroup)
+		v.validateStepSize(path+".target.step", target.Step)
+	case *MoveTarget:
+		if target.Name == "" {
+			v.errorf(path+".target.name", "action MoveStreamToSink requires the name of the playback stream")
+		}
+		if target.Sink == "" {
+			v.errorf(path+".target.sink", "action MoveStreamToSink requires the name of the output device")
+		}
+	case *CycleTarget:
+		if len(target.Outputs) == 0 {
+			v.errorf(path+".target.outputs", "action CycleDefaultOutput requires the names of the output devices")
+		}
+		for i, output := range target.Outputs {
+			if output == "" {
+				v.errorf(fmt.Sprintf("%s.target.outputs.%d", path, i), "empty output device name")
+			}
+		}
+	case *CardProfileTarget:
+		if target.Card == "" {
+			v.errorf(path+".target.card", "action SetCardProfile requires the name of the card")
+		}
+		if len(target.Profiles) == 0 {
+			v.errorf(path+".target.profiles", "action SetCardProfile requires the names of the profiles")
+		}
+		for i, profile := range target.Profiles {
+			if profile == "" {
+				v.errorf(fmt.Sprintf("%s.target.profiles.%d", path, i), "empty profile name")
+			}
+		}
+	case *LoopbackTarget:
+		if target.Source == "" {
+			v.errorf(path+".target.source", "action %s requires the name of the input device", action.Type)
+		}
+		if target.Sink == "" {
+			v.errorf(path+".target.sink", "action %s requires the name of the output device", action.Type)
+		}
+		if target.LatencyMs < 0 {
+			v.errorf(path+".target.latencyMs", "latency %d must not be negative", target.LatencyMs)
+		}
+	case *EchoCancelTarget:
+		if target.Source == "" {
+			v.errorf(path+".target.source", "action %s requires the name of the input device", action.Type)
+		}
+		if target.Sink == "" {
+			v.errorf(path+".target.sink", "action %s requires the name of the output device", action.Type)
+		}
+	case *Target:
+		if action.IsMute() {
+			// Controls missing from the file are backfilled from the defaults
+			defaults := GetDefaultConfig().Controls
+			_, isSlider := controls.Sliders[target.Name]
+			_, isKnob := controls.Knobs[target.Name]
+			_, isDefaultSlider := defaults.Sliders[target.Name]
+			_, isDefaultKnob := defaults.Knobs[target.Name]
+			if !isSlider && !isKnob && !isDefaultSlider && !isDefaultKnob {
+				v.errorf(path+".target.name", "no slider or knob %q to mute", target.Name)
+			}
+		}
+		if action.Type == RecallScene {
+			if _, ok := config.Scenes[target.Name]; !ok {
+				v.warnf(path+".target.name", "scene %q does not exist", target.Name)
+			}
+		}
+		if action.IsSuspend() && target.Name == "" {
+			v.errorf(path+".target.name", "action %s requires the name of the output device", action.Type)
+		}
+	case nil:
+		if action.Type == SetVolume || action.Type == AssignFocusedWindowPlaybackStreams || action.IsMute() || action.Type == StepControl || action.Type == MoveStreamToSink || action.Type == CycleDefaultOutput || action.Type == SetCardProfile || action.IsLoopback() || action.Type == ToggleEchoCancel || action.IsSuspend() || action.IsVolumeStep() {
+			v.errorf(path+".target", "action %s requires a target", action.Type)
+		}
+	}
+}
+
+// validateControlTarget checks that an action targets an existing slider or knob
+func (v *validator) validateControlTarget(controls Controls, path string, controlType string, controlId string) {
+	// Controls missing from the file are backfilled from the defaults
+	defaults := GetDefaultConfig().Controls
+	exists := false
+	switch controlType {
+	case "slider":
+		_, inConfig := controls.Sliders[controlId]
+		_, inDefaults := defaults.Sliders[controlId]
+		exists = inConfig || inDefaults
+	case "knob":
+		_, inConfig := controls.Knobs[controlId]
+		_, inDefaults := defaults.Knobs[controlId]
+		exists = inConfig || inDefaults
+	default:
+		v.errorf(path+".target.controlType", "unknown control type %q, expected slider or knob", controlType)
+		return
+	}
+	if !exists {
+		v.errorf(path+".target.controlId", "%s %s does not exist", controlType, controlId)
+	}
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// lineOf returns the line of the deepest node along a dotted YAML path
+func lineOf(root *yaml.Node, path string) int {
+	if root == nil {
+		return 0
+	}
+	node := root
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+
+	line := 0
+	for _, segment := range strings.Split(path, ".") {
+		var next *yaml.Node
+		switch node.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == segment {
+					line = node.Content[i].Line
+					next = node.Content[i+1]
+					break
+				}
+			}
+		case yaml.SequenceNode:
+			var index int
+			if _, err := fmt.Sscanf(segment, "%d", &index); err == nil && index >= 0 && index < len(node.Content) {
+				next = node.Content[index]
+				line = next.Line
+			}
+		}
+		if next == nil {
+			break
+		}
+		node = next
+	}
+	return line
+}
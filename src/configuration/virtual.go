@@ -0,0 +1,28 @@
+package configuration
+
+import "sort"
+
+// VirtualSinkName returns the sink name of the virtual output device with a
+// name, which is its description
+func VirtualSinkName(name string) string {
+	return "pulsekontrol_virtual_" + sinkNamePart(name)
+}
+
+// VirtualSinks returns the names of the virtual output devices of the sliders
+// and knobs, sorted. Each is an output device of its own that plays on the
+// default output device, so anything played on it follows its control.
+func (config *Config) VirtualSinks() []string {
+	var names []string
+	for _, slider := range config.Controls.Sliders {
+		if slider.VirtualSink != "" {
+			names = append(names, slider.VirtualSink)
+		}
+	}
+	for _, knob := range config.Controls.Knobs {
+		if knob.VirtualSink != "" {
+			names = append(names, knob.VirtualSink)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
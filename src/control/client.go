@@ -0,0 +1,87 @@
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// clientTimeout bounds sending a request and waiting for its reply
+const clientTimeout = 10 * time.Second
+
+// Send sends a request to the socket at path and returns the reply. A reply
+// that is not Ok is returned as it is, errors are about the connection.
+func Send(path string, request Request) (Reply, error) {
+	conn, err := net.DialTimeout("unix", path, clientTimeout)
+	if err != nil {
+		return Reply{}, fmt.Errorf("cannot connect to %s, is pulsekontrol running? %w", path, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(clientTimeout))
+
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return Reply{}, fmt.Errorf("cannot send request: %w", err)
+	}
+	line, err := bufio.NewReaderSize(conn, 64*1024).ReadBytes('\n')
+	if err != nil {
+		return Reply{}, fmt.Errorf("cannot read reply: %w", err)
+	}
+	var reply Reply
+	if err := json.Unmarshal(line, &reply); err != nil {
+		return Reply{}, fmt.Errorf("invalid reply: %w", err)
+	}
+	return reply, nil
+}
+
+// Stream is the connection of a monitor, reading its events
+type Stream struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// OpenMonitor sends a monitor request to the socket at path and returns the
+// stream of its events, or the error of the reply
+func OpenMonitor(path string, request Request) (*Stream, error) {
+	request.Command = Monitor
+	conn, err := net.DialTimeout("unix", path, clientTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to %s, is pulsekontrol running? %w", path, err)
+	}
+	conn.SetDeadline(time.Now().Add(clientTimeout))
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("cannot send request: %w", err)
+	}
+	stream := &Stream{conn: conn, reader: bufio.NewReaderSize(conn, 64*1024)}
+	line, err := stream.reader.ReadBytes('\n')
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("cannot read reply: %w", err)
+	}
+	var reply Reply
+	if err := json.Unmarshal(line, &reply); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("invalid reply: %w", err)
+	}
+	if !reply.Ok {
+		conn.Close()
+		return nil, errors.New(reply.Error)
+	}
+	// Events may be far apart
+	conn.SetDeadline(time.Time{})
+	return stream, nil
+}
+
+// Next returns the next event as the JSON line the server sent, with its
+// newline. It fails once the server or Close ended the stream.
+func (s *Stream) Next() ([]byte, error) {
+	return s.reader.ReadBytes('\n')
+}
+
+// Close ends the stream
+func (s *Stream) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,407 @@
+// Package control is the local control socket, a unix socket for scripts and
+// window manager key bindings. Each line sent to it is a JSON Request, each
+// is answered with a JSON Reply line:
+//
+//	{"command":"set-control","type":"slider","id":"slider1","value":40}
+//	{"command":"toggle-mute","selector":"PlaybackStream:Firefox"}
+//	{"command":"switch-profile","name":"gaming"}
+//	{"command":"recall-scene","name":"evening","rampMs":500}
+//	{"command":"get-state"}
+//	{"command":"set-volume","source":{"name":"Spotify"},"value":40}
+//	{"command":"volume-up","source":{"name":"Spotify"},"value":10}
+//	{"command":"mute","source":{"type":"record","name":"Fire*","match":"glob"}}
+//
+// A monitor request turns the connection into a stream of Event lines after
+// its reply, until the client closes it:
+//
+//	{"command":"monitor","topics":["control.*","source.*"],"raw":true}
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/actions"
+	"github.com/0h41/pulsekontrol/src/activity"
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/0h41/pulsekontrol/src/logging"
+	"github.com/0h41/pulsekontrol/src/pulseaudio"
+	"github.com/0h41/pulsekontrol/src/supervise"
+	"github.com/rs/zerolog"
+)
+
+// maxRequestSize limits a request line, longer lines end the connection
+const maxRequestSize = 1 << 20
+
+// Commands of the protocol
+const (
+	SetControl    = "set-control"
+	ToggleMute    = "toggle-mute"
+	SwitchProfile = "switch-profile"
+	RecallScene   = "recall-scene"
+	GetState      = "get-state"
+	Monitor       = "monitor"
+
+	// Commands changing streams or devices directly, like the one-shot
+	// commands of the same name
+	SetVolume        = "set-volume"
+	VolumeUp         = "volume-up"
+	VolumeDown       = "volume-down"
+	Mute             = "mute"
+	Unmute           = "unmute"
+	SetDefaultSink   = "set-default-sink"
+	SetDefaultSource = "set-default-source"
+)
+
+// Request is a command with its arguments, unused ones are left out
+type Request struct {
+	Command  string   `json:"command"`
+	Type     string   `json:"type,omitempty"`     // set-control: slider or knob
+	Id       string   `json:"id,omitempty"`       // set-control: control id
+	Value    int      `json:"value,omitempty"`    // set-control and set-volume: 0-100, volume-up and volume-down: step, 5 without
+	Selector string   `json:"selector,omitempty"` // toggle-mute: control id or <type>:<name>
+	Source   *Source  `json:"source,omitempty"`   // set-volume, volume-*, mute, unmute, set-default-*, and toggle-mute instead of selector
+	Name     string   `json:"name,omitempty"`     // switch-profile and recall-scene
+	RampMs   int      `json:"rampMs,omitempty"`   // recall-scene: fade duration
+	Topics   []string `json:"topics,omitempty"`   // monitor: topic patterns like control.*, all without
+	Raw      bool     `json:"raw,omitempty"`      // monitor: keep the unparsed payloads of events like midi.message
+}
+
+// Source selects streams or devices by name, like the one-shot commands
+type Source struct {
+	Type   string `json:"type,omitempty"`   // PlaybackStream, RecordStream, OutputDevice or InputDevice, or a short form
+	Name   string `json:"name"`             // Exact name or alias, or a pattern
+	Binary string `json:"binary,omitempty"` // Only streams of this program binary
+	Match  string `json:"match,omitempty"`  // exact (the default), glob or regex
+}
+
+// Reply is the answer to a request
+type Reply struct {
+	Ok      bool           `json:"ok"`
+	Error   string         `json:"error,omitempty"`
+	Muted   *bool          `json:"muted,omitempty"`   // toggle-mute: new state
+	State   *actions.State `json:"state,omitempty"`   // get-state
+	Changes []string       `json:"changes,omitempty"` // Commands with a source: what changed, a line per source
+}
+
+// SocketPath returns where the socket is created: pulsekontrol.sock in
+// $XDG_RUNTIME_DIR, or a file of the user in the temporary directory without it
+func SocketPath() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "pulsekontrol.sock")
+	}
+	return filepath.Join(os.TempDir(), "pulsekontrol-"+strconv.Itoa(os.Getuid())+".sock")
+}
+
+// Server answers requests on the socket
+type Server struct {
+	log           zerolog.Logger
+	path          string
+	configManager *configuration.ConfigManager
+	executor      *actions.Executor
+
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	connsMutex sync.Mutex
+	conns      map[net.Conn]struct{}
+	closed     bool // Stop was called, connections accepted afterwards are closed
+}
+
+// NewServer creates a server listening on the socket at path once started
+func NewServer(path string, configManager *configuration.ConfigManager, executor *actions.Executor) *Server {
+	return &Server{
+		log:           logging.Module("Control"),
+		path:          path,
+		configManager: configManager,
+		executor:      executor,
+		conns:         map[net.Conn]struct{}{},
+	}
+}
+
+// Start creates the socket, readable and writable by the user only, and
+// answers requests until Stop. A socket left behind by an instance that
+// didn't stop cleanly is replaced.
+func (s *Server) Start() error {
+	if conn, err := net.DialTimeout("unix", s.path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use, is another pulsekontrol running?", s.path)
+	} else if errors.Is(err, syscall.ECONNREFUSED) {
+		if err := os.Remove(s.path); err != nil {
+			return fmt.Errorf("cannot remove stale socket %s: %w", s.path, err)
+		}
+	}
+
+	// Nobody else may connect between creating the socket and the chmod
+	oldMask := syscall.Umask(0o177)
+	listener, err := net.Listen("unix", s.path)
+	syscall.Umask(oldMask)
+	if err != nil {
+		return fmt.Errorf("cannot listen on %s: %w", s.path, err)
+	}
+	if err := os.Chmod(s.path, 0o600); err != nil {
+		listener.Close()
+		return fmt.Errorf("cannot set permissions of %s: %w", s.path, err)
+	}
+	s.listener = listener
+	s.connsMutex.Lock()
+	s.closed = false
+	s.connsMutex.Unlock()
+
+	s.wg.Add(1)
+	supervise.Go("control.accept", func() {
+		s.accept()
+		s.wg.Done()
+	})
+	s.log.Info().Str("path", s.path).Msg("Listening on control socket")
+	return nil
+}
+
+// Stop closes the socket and its connections and removes the socket file
+func (s *Server) Stop() {
+	if s.listener == nil {
+		return
+	}
+	// Closing a unix listener removes its file
+	s.listener.Close()
+	s.connsMutex.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.connsMutex.Unlock()
+	s.wg.Wait()
+}
+
+func (s *Server) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.log.Error().Err(err).Msg("Failed to accept control connection")
+			}
+			return
+		}
+		s.connsMutex.Lock()
+		if s.closed {
+			// Accepted while stopping, Stop already closed the others
+			s.connsMutex.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.connsMutex.Unlock()
+		supervise.Go("control.serve", func() {
+			s.serve(conn)
+			s.wg.Done()
+		})
+	}
+}
+
+// serve answers the requests of a connection until it is closed
+func (s *Server) serve(conn net.Conn) {
+	defer func() {
+		s.connsMutex.Lock()
+		delete(s.conns, conn)
+		s.connsMutex.Unlock()
+		conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 4096), maxRequestSize)
+	encoder := json.NewEncoder(conn)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var request Request
+		var reply Reply
+		if err := json.Unmarshal(line, &request); err != nil {
+			reply = Reply{Error: fmt.Sprintf("invalid request: %v", err)}
+		} else if request.Command == Monitor {
+			s.monitor(scanner, encoder, request)
+			return
+		} else {
+			reply = s.handle(request)
+		}
+		if err := encoder.Encode(reply); err != nil {
+			s.log.Debug().Err(err).Msg("Failed to send control reply")
+			return
+		}
+	}
+	if errors.Is(scanner.Err(), bufio.ErrTooLong) {
+		encoder.Encode(Reply{Error: fmt.Sprintf("request longer than %d bytes", maxRequestSize)})
+	}
+}
+
+// handle runs a request
+func (s *Server) handle(request Request) Reply {
+	reply := Handle(s.executor, activity.Socket(), request)
+	if !reply.Ok {
+		s.log.Debug().Str("error", reply.Error).Str("command", request.Command).Msg("Control request failed")
+	}
+	return reply
+}
+
+// Handle runs a request of a client from origin and returns the reply. The
+// web interface runs the commands of pulsekontrol remote with it. Monitor
+// requests need a connection of their own and are refused.
+func Handle(executor *actions.Executor, origin activity.Origin, request Request) Reply {
+	var err error
+	switch request.Command {
+	case SetControl:
+		err = executor.SetControl(origin, request.Type, request.Id, request.Value)
+	case ToggleMute:
+		if request.Source != nil {
+			return sourceCommand(executor, origin, request)
+		}
+		var muted bool
+		if muted, err = executor.ToggleMuteSelector(origin, request.Selector); err == nil {
+			return Reply{Ok: true, Muted: &muted}
+		}
+	case SwitchProfile:
+		err = executor.SwitchProfile(origin, request.Name)
+	case RecallScene:
+		if request.RampMs < 0 {
+			err = fmt.Errorf("rampMs %d must not be negative", request.RampMs)
+		} else {
+			err = executor.RecallScene(origin, request.Name, time.Duration(request.RampMs)*time.Millisecond)
+		}
+	case GetState:
+		state := executor.State()
+		return Reply{Ok: true, State: &state}
+	case SetVolume, VolumeUp, VolumeDown, Mute, Unmute, SetDefaultSink, SetDefaultSource:
+		return sourceCommand(executor, origin, request)
+	case Monitor:
+		err = errors.New("monitor needs a connection of its own")
+	case "":
+		err = errors.New("request without a command")
+	default:
+		err = fmt.Errorf("unknown command %q", request.Command)
+	}
+	if err != nil {
+		return Reply{Error: err.Error()}
+	}
+	return Reply{Ok: true}
+}
+
+// sourceCommand runs a command changing the streams or devices of the source
+// of a request
+func sourceCommand(executor *actions.Executor, origin activity.Origin, request Request) Reply {
+	defaultType := configuration.PlaybackStream
+	switch request.Command {
+	case SetDefaultSink:
+		defaultType = configuration.OutputDevice
+	case SetDefaultSource:
+		defaultType = configuration.InputDevice
+	}
+	selector, err := request.Source.selector(defaultType)
+	if err != nil {
+		return Reply{Error: err.Error()}
+	}
+
+	var changes []string
+	switch request.Command {
+	case SetVolume:
+		changes, err = executor.SetSourcesVolume(origin, selector, request.Value)
+	case VolumeUp, VolumeDown:
+		step := request.Value
+		if step < 0 {
+			return Reply{Error: fmt.Sprintf("step %d must not be negative", step)}
+		}
+		if step == 0 {
+			step = configuration.DefaultStepSize
+		}
+		if request.Command == VolumeDown {
+			step = -step
+		}
+		changes, err = executor.StepSourcesVolume(origin, selector, step)
+	case Mute:
+		changes, err = executor.SetSourcesMuted(origin, selector, func(bool) bool { return true })
+	case Unmute:
+		changes, err = executor.SetSourcesMuted(origin, selector, func(bool) bool { return false })
+	case ToggleMute:
+		changes, err = executor.SetSourcesMuted(origin, selector, func(muted bool) bool { return !muted })
+	default:
+		if selector.Type != defaultType {
+			return Reply{Error: fmt.Sprintf("%s only works with an %s", request.Command, defaultType)}
+		}
+		var change string
+		if change, err = executor.SetDefaultDevice(origin, selector); err == nil {
+			changes = []string{change}
+		}
+	}
+	if err != nil {
+		return Reply{Error: err.Error(), Changes: changes}
+	}
+	return Reply{Ok: true, Changes: changes}
+}
+
+// selector returns the selector of a source, of defaultType without a type
+func (source *Source) selector(defaultType configuration.PulseAudioTargetType) (pulseaudio.Selector, error) {
+	if source == nil || source.Name == "" {
+		return pulseaudio.Selector{}, errors.New("source without a name")
+	}
+	selector := pulseaudio.Selector{Type: defaultType, Name: source.Name, BinaryName: source.Binary, Mode: pulseaudio.MatchExact}
+	if source.Type != "" {
+		targetType, ok := configuration.ParseTargetType(source.Type)
+		if !ok {
+			return pulseaudio.Selector{}, fmt.Errorf("unknown source type %q", source.Type)
+		}
+		selector.Type = targetType
+	}
+	switch mode := pulseaudio.MatchMode(source.Match); mode {
+	case "", pulseaudio.MatchExact:
+	case pulseaudio.MatchGlob, pulseaudio.MatchRegex:
+		selector.Mode = mode
+	default:
+		return pulseaudio.Selector{}, fmt.Errorf("unknown match %q, expected exact, glob or regex", source.Match)
+	}
+	return selector, nil
+}
+
+// monitor streams the notifications of the topics of a request until the
+// client closes the connection or the server stops
+func (s *Server) monitor(scanner *bufio.Scanner, encoder *json.Encoder, request Request) {
+	watcher, err := NewWatcher(s.configManager, request)
+	if err != nil {
+		encoder.Encode(Reply{Error: err.Error()})
+		return
+	}
+	defer watcher.Stop()
+	if err := encoder.Encode(Reply{Ok: true}); err != nil {
+		return
+	}
+	s.log.Debug().Strs("topics", request.Topics).Msg("Monitor connected")
+
+	// Nothing more is expected from the client, reading only notices it left
+	closed := make(chan struct{})
+	go func() {
+		for scanner.Scan() {
+		}
+		close(closed)
+	}()
+
+	for {
+		select {
+		case <-closed:
+			s.log.Debug().Msg("Monitor disconnected")
+			return
+		case event := <-watcher.Events():
+			if err := watcher.Encode(encoder, event); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/actions"
+	"github.com/0h41/pulsekontrol/src/activity"
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/0h41/pulsekontrol/src/internal/testutil"
+)
+
+// startServer starts a server on a socket in a temporary directory
+func startServer(t *testing.T) (*Server, *configuration.ConfigManager, *testutil.FakeBackend) {
+	t.Helper()
+	dir := t.TempDir()
+	cm := configuration.NewConfigManager(configuration.GetDefaultConfig(), filepath.Join(dir, "config.yaml"))
+	cm.SetReadOnly(true)
+	t.Cleanup(cm.Close)
+	backend := testutil.NewFakeBackend(
+		testutil.FakeStream{Type: configuration.PlaybackStream, Name: "Spotify", Volume: 1},
+		testutil.FakeStream{Type: configuration.OutputDevice, Name: "speakers"},
+	)
+	executor := actions.NewExecutor(backend, cm, activity.NewLog(10))
+	t.Cleanup(executor.Close)
+
+	server := NewServer(filepath.Join(dir, "control.sock"), cm, executor)
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Stop)
+	return server, cm, backend
+}
+
+func send(t *testing.T, server *Server, request Request) Reply {
+	t.Helper()
+	reply, err := Send(server.path, request)
+	if err != nil {
+		t.Fatalf("%s: %v", request.Command, err)
+	}
+	return reply
+}
+
+func TestCommands(t *testing.T) {
+	server, cm, backend := startServer(t)
+
+	if reply := send(t, server, Request{Command: SetControl, Type: "slider", Id: "slider1", Value: 40}); !reply.Ok {
+		t.Errorf("set-control failed: %s", reply.Error)
+	}
+	if value := cm.GetConfigSnapshot().Controls.Sliders["slider1"].Value; value != 40 {
+		t.Errorf("slider1 is at %d, want 40", value)
+	}
+
+	reply := send(t, server, Request{Command: SetVolume, Source: &Source{Name: "Spotify"}, Value: 30})
+	if !reply.Ok || len(reply.Changes) != 1 {
+		t.Errorf("set-volume replied %+v", reply)
+	}
+	if stream, _ := backend.Stream(configuration.PlaybackStream, "Spotify"); stream.Volume != 0.3 {
+		t.Errorf("Spotify is at %v, want 0.3", stream.Volume)
+	}
+
+	if reply := send(t, server, Request{Command: Mute, Source: &Source{Type: "playback", Name: "Spotify"}}); !reply.Ok {
+		t.Errorf("mute failed: %s", reply.Error)
+	}
+	if stream, _ := backend.Stream(configuration.PlaybackStream, "Spotify"); !stream.Muted {
+		t.Error("Spotify not muted")
+	}
+	first := send(t, server, Request{Command: ToggleMute, Selector: "slider1"})
+	second := send(t, server, Request{Command: ToggleMute, Selector: "slider1"})
+	if !first.Ok || !second.Ok || first.Muted == nil || second.Muted == nil || *first.Muted == *second.Muted {
+		t.Errorf("toggle-mute replied %+v, then %+v", first, second)
+	}
+
+	reply = send(t, server, Request{Command: GetState})
+	if !reply.Ok || reply.State == nil || len(reply.State.Controls) == 0 {
+		t.Errorf("get-state replied %+v", reply)
+	}
+}
+
+func TestCommandErrors(t *testing.T) {
+	server, _, _ := startServer(t)
+	for _, test := range []struct {
+		request Request
+		error   string
+	}{
+		{Request{}, "request without a command"},
+		{Request{Command: "explode"}, `unknown command "explode"`},
+		{Request{Command: SetControl, Type: "slider", Id: "slider99", Value: 40}, "no slider slider99"},
+		{Request{Command: SetControl, Type: "slider", Id: "slider1", Value: 101}, "must be between 0 and 100"},
+		{Request{Command: RecallScene, Name: "evening", RampMs: -1}, "must not be negative"},
+		{Request{Command: SetVolume, Value: 40}, "source without a name"},
+		{Request{Command: SetVolume, Source: &Source{Type: "speaker", Name: "x"}}, `unknown source type "speaker"`},
+		{Request{Command: SetVolume, Source: &Source{Name: "x", Match: "fuzzy"}}, `unknown match "fuzzy"`},
+		{Request{Command: VolumeDown, Source: &Source{Name: "Spotify"}, Value: -5}, "must not be negative"},
+		{Request{Command: SetDefaultSink, Source: &Source{Type: "playback", Name: "Spotify"}}, "only works with an OutputDevice"},
+		{Request{Command: Monitor}, "needs a connection of its own"},
+	} {
+		// Monitor requests on the socket are answered by monitor
+		reply := Handle(server.executor, activity.Socket(), test.request)
+		if test.request.Command != Monitor {
+			reply = send(t, server, test.request)
+		}
+		if reply.Ok || !strings.Contains(reply.Error, test.error) {
+			t.Errorf("%+v replied %+v, want error %q", test.request, reply, test.error)
+		}
+	}
+}
+
+func TestRequestLines(t *testing.T) {
+	server, _, _ := startServer(t)
+	conn, err := net.Dial("unix", server.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Several requests on one connection, empty lines are skipped
+	conn.Write([]byte("{not json\n\n" + `{"command":"get-state"}` + "\n"))
+	reader := bufio.NewReader(conn)
+	var replies []Reply
+	for range 2 {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		var reply Reply
+		if err := json.Unmarshal(line, &reply); err != nil {
+			t.Fatalf("reply %s: %v", line, err)
+		}
+		replies = append(replies, reply)
+	}
+	if replies[0].Ok || !strings.Contains(replies[0].Error, "invalid request") {
+		t.Errorf("invalid line replied %+v", replies[0])
+	}
+	if !replies[1].Ok || replies[1].State == nil {
+		t.Errorf("get-state replied %+v", replies[1])
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	server, cm, _ := startServer(t)
+	if _, err := OpenMonitor(server.path, Request{Topics: []string{"["}}); err == nil || !strings.Contains(err.Error(), "invalid topic pattern") {
+		t.Errorf("invalid pattern gave %v", err)
+	}
+
+	stream, err := OpenMonitor(server.path, Request{Topics: []string{"profile.*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	cm.NotifySync("control.value.updated", map[string]interface{}{"type": "slider", "id": "slider1", "value": 40})
+	cm.NotifySync("profile.switched", map[string]interface{}{"name": "gaming", "raw": "left out"})
+
+	line, err := stream.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var event Event
+	if err := json.Unmarshal(line, &event); err != nil {
+		t.Fatalf("event %s: %v", line, err)
+	}
+	data, _ := event.Data.(map[string]interface{})
+	if event.Topic != "profile.switched" || data["name"] != "gaming" || data["raw"] != nil {
+		t.Errorf("monitor sent %s", line)
+	}
+}
+
+func TestSocketInUse(t *testing.T) {
+	server, cm, _ := startServer(t)
+	other := NewServer(server.path, cm, server.executor)
+	if err := other.Start(); err == nil || !strings.Contains(err.Error(), "is in use") {
+		t.Errorf("second server started with %v", err)
+	}
+
+	// A socket left behind is replaced
+	server.Stop()
+	listener, err := net.Listen("unix", server.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+	if err := other.Start(); err != nil {
+		t.Fatalf("stale socket not replaced: %v", err)
+	}
+	other.Stop()
+}
+
+func TestMonitorTurnsOnOptInTopics(t *testing.T) {
+	server, cm, _ := startServer(t)
+	for _, topics := range [][]string{{"profile.*"}, nil, {"midi.*"}} {
+		stream, err := OpenMonitor(server.path, Request{Topics: topics})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := topics == nil || topics[0] == "midi.*"
+		if subscribed := cm.Subscribed("midi.message"); subscribed != want {
+			t.Errorf("monitor of %v turned on MIDI messages: %v", topics, subscribed)
+		}
+		stream.Close()
+		deadline := time.Now().Add(5 * time.Second)
+		for cm.Subscribed("midi.message") && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if cm.Subscribed("midi.message") {
+			t.Errorf("monitor of %v left MIDI messages on", topics)
+		}
+	}
+}
+
+// pendingListener returns a connection that arrived before it was closed
+type pendingListener struct {
+	net.Listener
+	conn net.Conn
+}
+
+func (l *pendingListener) Accept() (net.Conn, error) {
+	if conn := l.conn; conn != nil {
+		l.conn = nil
+		return conn, nil
+	}
+	return nil, net.ErrClosed
+}
+
+func TestConnectionAcceptedDuringStopIsClosed(t *testing.T) {
+	server, _, _ := startServer(t)
+	server.Stop()
+
+	client, conn := net.Pipe()
+	defer client.Close()
+	server.listener = &pendingListener{Listener: server.listener, conn: conn}
+	server.accept()
+
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := client.Read(make([]byte, 1)); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("connection left open after Stop: %v", err)
+	}
+	if len(server.conns) != 0 {
+		t.Errorf("%d connections tracked after Stop", len(server.conns))
+	}
+}
@@ -0,0 +1,144 @@
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sync/atomic"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/configuration"
+)
+
+// watcherQueueSize bounds the events waiting for a slow monitor, further ones are dropped
+const watcherQueueSize = 1024
+
+// MonitorDropped is the topic of the event telling a monitor how many events
+// it missed because it didn't read them fast enough
+const MonitorDropped = "monitor.dropped"
+
+// Event is a notification streamed to a monitor
+type Event struct {
+	Time  float64     `json:"time"` // Seconds since the monitor started, from a monotonic clock
+	Topic string      `json:"topic"`
+	Data  interface{} `json:"data,omitempty"`
+}
+
+// Watcher queues the notifications of a configuration manager that a
+// monitor request asks for. The socket streams them to pulsekontrol monitor,
+// which uses one itself when no instance runs.
+type Watcher struct {
+	configManager *configuration.ConfigManager
+	subscription  configuration.Subscription
+	optIns        []configuration.Subscription // Turn on the opt-in topics the monitor asks for
+	started       time.Time
+	events        chan Event
+	dropped       uint64 // Accessed atomically
+}
+
+// NewWatcher subscribes to the notifications matching the topic patterns of
+// request, all of them without patterns
+func NewWatcher(configManager *configuration.ConfigManager, request Request) (*Watcher, error) {
+	for _, pattern := range request.Topics {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid topic pattern %q: %w", pattern, err)
+		}
+	}
+	w := &Watcher{
+		configManager: configManager,
+		started:       time.Now(),
+		events:        make(chan Event, watcherQueueSize),
+	}
+	// The callback runs on the notification goroutines and must not wait
+	// for the monitor, overflowing events are counted instead
+	w.subscription = configManager.Subscribe(configuration.AllTopics, func(data interface{}) {
+		event, ok := data.(configuration.TopicEvent)
+		if !ok || !matchesTopic(request.Topics, event.Topic) {
+			return
+		}
+		payload := event.Data
+		if !request.Raw {
+			payload = withoutRaw(payload)
+		}
+		select {
+		case w.events <- Event{Time: time.Since(w.started).Seconds(), Topic: event.Topic, Data: payload}:
+		default:
+			atomic.AddUint64(&w.dropped, 1)
+		}
+	})
+	// The events themselves arrive through the subscription above
+	for _, topic := range configuration.OptInTopics {
+		if matchesTopic(request.Topics, topic) {
+			w.optIns = append(w.optIns, configManager.Subscribe(topic, func(interface{}) {}))
+		}
+	}
+	return w, nil
+}
+
+// Events receives the queued events
+func (w *Watcher) Events() <-chan Event {
+	return w.events
+}
+
+// Encode writes an event as a JSON line. Data that can't be encoded is
+// written as text. Once the queue is empty, a MonitorDropped event tells how
+// many events were dropped since it was full.
+func (w *Watcher) Encode(encoder *json.Encoder, event Event) error {
+	err := encoder.Encode(event)
+	var unsupportedType *json.UnsupportedTypeError
+	var unsupportedValue *json.UnsupportedValueError
+	if errors.As(err, &unsupportedType) || errors.As(err, &unsupportedValue) {
+		// Nothing was written yet
+		event.Data = fmt.Sprint(event.Data)
+		err = encoder.Encode(event)
+	}
+	if err != nil || len(w.events) > 0 {
+		return err
+	}
+	if missed := atomic.SwapUint64(&w.dropped, 0); missed > 0 {
+		return encoder.Encode(Event{Time: time.Since(w.started).Seconds(), Topic: MonitorDropped, Data: map[string]uint64{"events": missed}})
+	}
+	return nil
+}
+
+// Stop unsubscribes, queued events are still received
+func (w *Watcher) Stop() {
+	w.configManager.Unsubscribe(w.subscription)
+	for _, subscription := range w.optIns {
+		w.configManager.Unsubscribe(subscription)
+	}
+}
+
+// matchesTopic reports whether a topic matches one of the patterns, or
+// whether there are none
+func matchesTopic(patterns []string, topic string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, topic); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutRaw returns the data of an event without the unparsed payload that
+// events like midi.message carry in raw
+func withoutRaw(data interface{}) interface{} {
+	fields, ok := data.(map[string]interface{})
+	if !ok {
+		return data
+	}
+	if _, ok := fields["raw"]; !ok {
+		return data
+	}
+	copied := make(map[string]interface{}, len(fields)-1)
+	for key, value := range fields {
+		if key != "raw" {
+			copied[key] = value
+		}
+	}
+	return copied
+}
@@ -0,0 +1,217 @@
+// Package cron parses the times of schedules and finds when they are next
+// due. Times are wall clock times of a location, so that a schedule at 23:00
+// stays at 23:00 across daylight saving time changes.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxYears bounds the search for the next time, a spec like 0 0 30 2 * never matches
+const maxYears = 5
+
+// Spec is a set of minutes, as parsed from a cron expression or a time of day
+type Spec struct {
+	minutes  uint64 // Bit per minute 0-59
+	hours    uint64 // Bit per hour 0-23
+	days     uint64 // Bit per day of the month 1-31
+	months   uint64 // Bit per month 1-12
+	weekdays uint64 // Bit per weekday, 0 is Sunday
+	// With both days and weekdays restricted, either matches, like cron
+	anyDay     bool
+	anyWeekday bool
+}
+
+var weekdayNames = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+// Parse parses a time of day with optional weekdays, like 23:00, 7:30
+// Mon-Fri or 22:00 Sat,Sun, or a cron expression of five fields: minute,
+// hour, day of the month, month and weekday, like 0 23 * * 1-5.
+func Parse(text string) (Spec, error) {
+	fields := strings.Fields(text)
+	switch {
+	case len(fields) == 5:
+		return parseCron(fields)
+	case len(fields) >= 1 && len(fields) <= 2 && strings.Contains(fields[0], ":"):
+		return parseTimeOfDay(fields)
+	case len(fields) == 0:
+		return Spec{}, fmt.Errorf("empty time")
+	}
+	return Spec{}, fmt.Errorf("invalid time %q, expected a time like 23:00 Mon-Fri or a cron expression like 0 23 * * 1-5", text)
+}
+
+func parseTimeOfDay(fields []string) (Spec, error) {
+	hourText, minuteText, _ := strings.Cut(fields[0], ":")
+	hour, err := strconv.Atoi(hourText)
+	if err != nil || hour < 0 || hour > 23 {
+		return Spec{}, fmt.Errorf("invalid hour in %s", fields[0])
+	}
+	minute, err := strconv.Atoi(minuteText)
+	if err != nil || minute < 0 || minute > 59 || len(minuteText) != 2 {
+		return Spec{}, fmt.Errorf("invalid minute in %s", fields[0])
+	}
+	spec := Spec{minutes: 1 << minute, hours: 1 << hour, days: bits(1, 31), months: bits(1, 12), anyDay: true}
+	if len(fields) == 1 {
+		spec.weekdays, spec.anyWeekday = bits(0, 6), true
+		return spec, nil
+	}
+	spec.weekdays, err = parseField(fields[1], 0, 7, weekdayNames)
+	if err != nil {
+		return Spec{}, fmt.Errorf("invalid weekdays %s: %w", fields[1], err)
+	}
+	spec.weekdays = foldSunday(spec.weekdays)
+	return spec, nil
+}
+
+func parseCron(fields []string) (Spec, error) {
+	var spec Spec
+	var err error
+	parts := []struct {
+		name     string
+		target   *uint64
+		min, max int
+		names    map[string]int
+	}{
+		{"minute", &spec.minutes, 0, 59, nil},
+		{"hour", &spec.hours, 0, 23, nil},
+		{"day of the month", &spec.days, 1, 31, nil},
+		{"month", &spec.months, 1, 12, monthNames},
+		{"weekday", &spec.weekdays, 0, 7, weekdayNames},
+	}
+	for i, part := range parts {
+		if *part.target, err = parseField(fields[i], part.min, part.max, part.names); err != nil {
+			return Spec{}, fmt.Errorf("invalid %s %s: %w", part.name, fields[i], err)
+		}
+	}
+	spec.weekdays = foldSunday(spec.weekdays)
+	spec.anyDay = strings.HasPrefix(fields[2], "*")
+	spec.anyWeekday = strings.HasPrefix(fields[4], "*")
+	return spec, nil
+}
+
+// parseField parses a comma-separated list of values, ranges like 1-5 and
+// steps like */15 or 0-30/10 into bits
+func parseField(field string, min int, max int, names map[string]int) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(field, ",") {
+		rangeText, stepText, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %s", stepText)
+			}
+		}
+		low, high := min, max
+		if rangeText != "*" {
+			lowText, highText, isRange := strings.Cut(rangeText, "-")
+			var err error
+			if low, err = parseValue(lowText, min, max, names); err != nil {
+				return 0, err
+			}
+			high = low
+			if isRange {
+				if high, err = parseValue(highText, min, max, names); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				high = max
+			}
+			if high < low {
+				return 0, fmt.Errorf("range %s ends before it starts", rangeText)
+			}
+		}
+		for value := low; value <= high; value += step {
+			set |= 1 << value
+		}
+	}
+	return set, nil
+}
+
+func parseValue(text string, min int, max int, names map[string]int) (int, error) {
+	if value, ok := names[strings.ToLower(text)]; ok {
+		return value, nil
+	}
+	value, err := strconv.Atoi(text)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %s", text)
+	}
+	if value < min || value > max {
+		return 0, fmt.Errorf("%d is out of range %d-%d", value, min, max)
+	}
+	return value, nil
+}
+
+// foldSunday makes weekday 7 Sunday like 0
+func foldSunday(weekdays uint64) uint64 {
+	if weekdays&(1<<7) != 0 {
+		weekdays = weekdays&^(1<<7) | 1
+	}
+	return weekdays
+}
+
+func bits(low int, high int) uint64 {
+	var set uint64
+	for value := low; value <= high; value++ {
+		set |= 1 << value
+	}
+	return set
+}
+
+// matchesDay reports whether the spec runs on a day
+func (spec Spec) matchesDay(year int, month time.Month, day int, loc *time.Location) bool {
+	if spec.months&(1<<uint(month)) == 0 {
+		return false
+	}
+	dayMatches := spec.days&(1<<uint(day)) != 0
+	weekday := time.Date(year, month, day, 12, 0, 0, 0, loc).Weekday()
+	weekdayMatches := spec.weekdays&(1<<uint(weekday)) != 0
+	switch {
+	case spec.anyDay && spec.anyWeekday:
+		return true
+	case spec.anyDay:
+		return weekdayMatches
+	case spec.anyWeekday:
+		return dayMatches
+	default:
+		return dayMatches || weekdayMatches
+	}
+}
+
+// Next returns the first time after after at which the spec runs, in the
+// location of after, and false if there is none within a few years. Each
+// candidate is built from the wall clock of its day: a time that is skipped
+// when clocks go forward runs at the moved time instead, one that repeats
+// when clocks go back runs once.
+func (spec Spec) Next(after time.Time) (time.Time, bool) {
+	loc := after.Location()
+	year, month, day := after.Date()
+	for date := time.Date(year, month, day, 12, 0, 0, 0, loc); date.Year() <= year+maxYears; date = date.AddDate(0, 0, 1) {
+		year, month, day := date.Date()
+		if !spec.matchesDay(year, month, day, loc) {
+			continue
+		}
+		for hour := 0; hour < 24; hour++ {
+			if spec.hours&(1<<hour) == 0 {
+				continue
+			}
+			for minute := 0; minute < 60; minute++ {
+				if spec.minutes&(1<<minute) == 0 {
+					continue
+				}
+				if candidate := time.Date(year, month, day, hour, minute, 0, 0, loc); candidate.After(after) {
+					return candidate, true
+				}
+			}
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,132 @@
+package cron
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+// berlin is a time zone with daylight saving time: clocks go forward from
+// 02:00 to 03:00 on 2026-03-29 and back from 03:00 to 02:00 on 2026-10-25
+func berlin(t *testing.T) *time.Location {
+	t.Helper()
+	location, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, text := range []string{
+		"",
+		"23",
+		"24:00",
+		"23:60",
+		"23:5",
+		"ab:00",
+		"23:00 Someday",
+		"23:00 Fri-Mon",
+		"23:00 Mon Fri",
+		"0 23 * *",
+		"60 23 * * *",
+		"0 24 * * *",
+		"0 23 0 * *",
+		"0 23 * 13 *",
+		"0 23 * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"5-1 * * * *",
+		"0 23 * * * *",
+	} {
+		if _, err := Parse(text); err == nil {
+			t.Errorf("%q parsed", text)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	utc := func(text string) time.Time {
+		t.Helper()
+		parsed, err := time.Parse("2006-01-02 15:04 Mon", text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	// 2026-10-16 is a Friday
+	for _, test := range []struct {
+		spec  string
+		after string
+		next  string
+	}{
+		{"23:00", "2026-10-16 12:00 Fri", "2026-10-16 23:00 Fri"},
+		{"23:00", "2026-10-16 23:00 Fri", "2026-10-17 23:00 Sat"},
+		{"07:30 Mon-Fri", "2026-10-16 08:00 Fri", "2026-10-19 07:30 Mon"},
+		{"22:00 Sat,Sun", "2026-10-16 08:00 Fri", "2026-10-17 22:00 Sat"},
+		{"22:00 sun", "2026-10-16 08:00 Fri", "2026-10-18 22:00 Sun"},
+		{"0 23 * * 1-5", "2026-10-16 23:30 Fri", "2026-10-19 23:00 Mon"},
+		{"0 23 * * 7", "2026-10-16 23:30 Fri", "2026-10-18 23:00 Sun"},
+		{"*/15 * * * *", "2026-10-16 12:07 Fri", "2026-10-16 12:15 Fri"},
+		{"0-30/10 9 * * *", "2026-10-16 09:21 Fri", "2026-10-16 09:30 Fri"},
+		{"0 8 1 jan *", "2026-10-16 08:00 Fri", "2027-01-01 08:00 Fri"},
+		{"0 12 29 2 *", "2026-10-16 08:00 Fri", "2028-02-29 12:00 Tue"},
+		// With both days restricted either one matches
+		{"0 9 1 * mon", "2026-10-16 08:00 Fri", "2026-10-19 09:00 Mon"},
+		{"0 9 17 * mon", "2026-10-16 08:00 Fri", "2026-10-17 09:00 Sat"},
+	} {
+		spec, err := Parse(test.spec)
+		if err != nil {
+			t.Errorf("%q: %v", test.spec, err)
+			continue
+		}
+		next, ok := spec.Next(utc(test.after))
+		if want := utc(test.next); !ok || !next.Equal(want) {
+			t.Errorf("%q after %s is %s, want %s", test.spec, test.after, next.Format("2006-01-02 15:04 Mon"), test.next)
+		}
+	}
+
+	// Never
+	spec, _ := Parse("0 0 30 2 *")
+	if next, ok := spec.Next(utc("2026-10-16 08:00 Fri")); ok {
+		t.Errorf("30 February is %s", next)
+	}
+}
+
+func TestNextKeepsWallClock(t *testing.T) {
+	location := berlin(t)
+	spec, err := Parse("23:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 23:00 is 22:00 UTC in winter and 21:00 UTC in summer
+	next, _ := spec.Next(time.Date(2026, 3, 28, 23, 30, 0, 0, location))
+	if want := time.Date(2026, 3, 29, 21, 0, 0, 0, time.UTC); !next.Equal(want) {
+		t.Errorf("after the change to summer time runs at %s, want %s", next.UTC(), want)
+	}
+	next, _ = spec.Next(time.Date(2026, 10, 24, 23, 30, 0, 0, location))
+	if want := time.Date(2026, 10, 25, 22, 0, 0, 0, time.UTC); !next.Equal(want) {
+		t.Errorf("after the change to winter time runs at %s, want %s", next.UTC(), want)
+	}
+}
+
+func TestNextAcrossClockChanges(t *testing.T) {
+	location := berlin(t)
+	spec, err := Parse("02:30")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Skipped when clocks go forward, it runs at the moved time instead
+	next, ok := spec.Next(time.Date(2026, 3, 29, 0, 0, 0, 0, location))
+	if want := time.Date(2026, 3, 29, 1, 30, 0, 0, time.UTC); !ok || !next.Equal(want) {
+		t.Errorf("skipped time runs at %s, want %s", next.UTC(), want)
+	}
+
+	// Repeated when clocks go back, it runs once
+	first, _ := spec.Next(time.Date(2026, 10, 25, 0, 0, 0, 0, location))
+	second, _ := spec.Next(first)
+	if first.Day() != 25 || second.Day() != 26 {
+		t.Errorf("repeated time runs at %s and %s, want once on the 25th", first, second)
+	}
+}
@@ -0,0 +1,241 @@
+// Package dbusapi exports pulsekontrol on the session bus as
+// org.pulsekontrol.Control1, so desktop shortcuts and scripts can run the
+// same actions as the MIDI device and the web interface.
+package dbusapi
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/0h41/pulsekontrol/src/actions"
+	"github.com/0h41/pulsekontrol/src/activity"
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/0h41/pulsekontrol/src/logging"
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/rs/zerolog"
+)
+
+const (
+	Name      = "org.pulsekontrol.Control1" // Bus name of the service
+	Interface = "org.pulsekontrol.Control1" // Interface of the methods and signals
+	Path      = dbus.ObjectPath("/org/pulsekontrol/Control1")
+
+	errorName = Interface + ".Error"
+)
+
+// IntrospectXML describes the interface, as returned by Introspect
+const IntrospectXML = `<!DOCTYPE node PUBLIC "-//freedesktop//DTD D-BUS Object Introspection 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/introspect.dtd">
+<node>
+	<interface name="` + Interface + `">
+		<!-- Sets a slider or knob (0-100) and the volume of its sources -->
+		<method name="SetControlValue">
+			<arg name="controlType" direction="in" type="s"/>
+			<arg name="controlId" direction="in" type="s"/>
+			<arg name="value" direction="in" type="i"/>
+		</method>
+		<!-- Mutes or unmutes a control like slider3, or a source like PlaybackStream:Firefox -->
+		<method name="ToggleMute">
+			<arg name="selector" direction="in" type="s"/>
+			<arg name="muted" direction="out" type="b"/>
+		</method>
+		<method name="SwitchProfile">
+			<arg name="name" direction="in" type="s"/>
+		</method>
+		<method name="RecallScene">
+			<arg name="name" direction="in" type="s"/>
+		</method>
+		<!-- The controls with their sources, profiles and scenes as JSON -->
+		<method name="GetState">
+			<arg name="state" direction="out" type="s"/>
+		</method>
+		<signal name="ControlValueChanged">
+			<arg name="controlType" type="s"/>
+			<arg name="controlId" type="s"/>
+			<arg name="value" type="i"/>
+		</signal>
+		<signal name="ControlMutedChanged">
+			<arg name="controlType" type="s"/>
+			<arg name="controlId" type="s"/>
+			<arg name="muted" type="b"/>
+		</signal>
+		<!-- A source was assigned to or unassigned from a control -->
+		<signal name="SourcesChanged">
+			<arg name="controlType" type="s"/>
+			<arg name="controlId" type="s"/>
+		</signal>
+		<signal name="ProfileSwitched">
+			<arg name="name" type="s"/>
+		</signal>
+	</interface>` + introspect.IntrospectDataString + `</node>`
+
+// Service is the exported object. Its exported methods are the D-Bus methods.
+type Service struct {
+	log           zerolog.Logger
+	conn          *dbus.Conn
+	configManager *configuration.ConfigManager
+	executor      *actions.Executor
+	subscriptions []configuration.Subscription
+}
+
+// Start exports the service on a bus connection, requests its name and
+// emits signals for the changes of the configuration until Stop
+func Start(conn *dbus.Conn, configManager *configuration.ConfigManager, executor *actions.Executor) (*Service, error) {
+	s := &Service{
+		log:           logging.Module("DBus"),
+		conn:          conn,
+		configManager: configManager,
+		executor:      executor,
+	}
+	if err := conn.ExportMethodTable(s.methods(), Path, Interface); err != nil {
+		return nil, fmt.Errorf("cannot export %s: %w", Interface, err)
+	}
+	if err := conn.Export(introspect.Introspectable(IntrospectXML), Path, "org.freedesktop.DBus.Introspectable"); err != nil {
+		s.unexport()
+		return nil, fmt.Errorf("cannot export introspection data: %w", err)
+	}
+
+	reply, err := conn.RequestName(Name, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		s.unexport()
+		return nil, fmt.Errorf("cannot request bus name %s: %w", Name, err)
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		s.unexport()
+		return nil, fmt.Errorf("bus name %s is taken, is another pulsekontrol running?", Name)
+	}
+
+	s.subscribe()
+	s.log.Info().Str("name", Name).Msg("Exported on the session bus")
+	return s, nil
+}
+
+// methods returns the D-Bus methods. They are listed instead of exporting
+// all methods of Service, which would include Stop.
+func (s *Service) methods() map[string]interface{} {
+	return map[string]interface{}{
+		"SetControlValue": s.SetControlValue,
+		"ToggleMute":      s.ToggleMute,
+		"SwitchProfile":   s.SwitchProfile,
+		"RecallScene":     s.RecallScene,
+		"GetState":        s.GetState,
+	}
+}
+
+func (s *Service) unexport() {
+	s.conn.Export(nil, Path, Interface)
+	s.conn.Export(nil, Path, "org.freedesktop.DBus.Introspectable")
+}
+
+// Stop stops emitting signals, releases the bus name and closes the connection
+func (s *Service) Stop() {
+	for _, subscription := range s.subscriptions {
+		s.configManager.Unsubscribe(subscription)
+	}
+	s.subscriptions = nil
+	s.unexport()
+	if _, err := s.conn.ReleaseName(Name); err != nil {
+		s.log.Warn().Err(err).Msg("Failed to release bus name")
+	}
+	if err := s.conn.Close(); err != nil {
+		s.log.Warn().Err(err).Msg("Failed to close bus connection")
+	}
+}
+
+// failed returns the D-Bus error of a failed method call
+func failed(err error) *dbus.Error {
+	return dbus.NewError(errorName, []interface{}{err.Error()})
+}
+
+// SetControlValue sets a slider or knob to a value (0-100) and the volume of its sources
+func (s *Service) SetControlValue(controlType string, controlId string, value int32) *dbus.Error {
+	if err := s.executor.SetControl(activity.DBus(), controlType, controlId, int(value)); err != nil {
+		return failed(err)
+	}
+	return nil
+}
+
+// ToggleMute flips the muted state of a slider or knob, given by its id, or
+// of a source given as <type>:<name> (the name may be an alias), and returns
+// the new state
+func (s *Service) ToggleMute(selector string) (bool, *dbus.Error) {
+	muted, err := s.executor.ToggleMuteSelector(activity.DBus(), selector)
+	if err != nil {
+		return false, failed(err)
+	}
+	return muted, nil
+}
+
+// SwitchProfile activates a profile
+func (s *Service) SwitchProfile(name string) *dbus.Error {
+	if err := s.executor.SwitchProfile(activity.DBus(), name); err != nil {
+		return failed(err)
+	}
+	return nil
+}
+
+// RecallScene applies a saved scene at once
+func (s *Service) RecallScene(name string) *dbus.Error {
+	if err := s.executor.RecallScene(activity.DBus(), name, 0); err != nil {
+		return failed(err)
+	}
+	return nil
+}
+
+// GetState returns the state of actions.State as JSON
+func (s *Service) GetState() (string, *dbus.Error) {
+	data, err := json.Marshal(s.executor.State())
+	if err != nil {
+		return "", failed(err)
+	}
+	return string(data), nil
+}
+
+// subscribe emits the signals for the notifications of the configuration manager
+func (s *Service) subscribe() {
+	on := func(topic string, callback func(map[string]interface{})) {
+		s.subscriptions = append(s.subscriptions, s.configManager.Subscribe(topic, func(data interface{}) {
+			if updateMap, ok := data.(map[string]interface{}); ok {
+				callback(updateMap)
+			}
+		}))
+	}
+
+	on("control.value.updated", func(update map[string]interface{}) {
+		controlType, _ := update["type"].(string)
+		controlId, _ := update["id"].(string)
+		value, ok := update["value"].(int)
+		if !ok {
+			return
+		}
+		s.emit("ControlValueChanged", controlType, controlId, int32(value))
+	})
+	on("control.muted.updated", func(update map[string]interface{}) {
+		controlType, _ := update["controlType"].(string)
+		controlId, _ := update["controlId"].(string)
+		muted, _ := update["muted"].(bool)
+		if controlType == "button" {
+			// Buttons only mirror the state of the control they mute
+			return
+		}
+		s.emit("ControlMutedChanged", controlType, controlId, muted)
+	})
+	sourcesChanged := func(update map[string]interface{}) {
+		controlType, _ := update["controlType"].(string)
+		controlId, _ := update["controlId"].(string)
+		s.emit("SourcesChanged", controlType, controlId)
+	}
+	on("source.assigned", sourcesChanged)
+	on("source.unassigned", sourcesChanged)
+	on("profile.switched", func(update map[string]interface{}) {
+		name, _ := update["name"].(string)
+		s.emit("ProfileSwitched", name)
+	})
+}
+
+func (s *Service) emit(signal string, values ...interface{}) {
+	if err := s.conn.Emit(Path, Interface+"."+signal, values...); err != nil {
+		s.log.Warn().Err(err).Str("signal", signal).Msg("Failed to emit signal")
+	}
+}
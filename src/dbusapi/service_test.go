@@ -0,0 +1,243 @@
+package dbusapi
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/actions"
+	"github.com/0h41/pulsekontrol/src/activity"
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/0h41/pulsekontrol/src/internal/testutil"
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+)
+
+// connect opens a connection to the test bus, closed after the test
+func connect(t *testing.T, address string) *dbus.Conn {
+	t.Helper()
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// startService exports the service on a bus, with Firefox assigned to
+// slider1 and playing on a fake audio system with Spotify. It returns the connection of a
+// client of the service.
+func startService(t *testing.T, address string) (*dbus.Conn, *configuration.ConfigManager, *testutil.FakeBackend) {
+	t.Helper()
+
+	config := configuration.GetDefaultConfig()
+	slider := config.Controls.Sliders["slider1"]
+	slider.Sources = []configuration.Source{{Type: configuration.PlaybackStream, Name: "Firefox"}}
+	config.Controls.Sliders["slider1"] = slider
+	config.Scenes = map[string]configuration.SceneConfig{"quiet": {}}
+	config, err := configuration.Prepare(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm := configuration.NewConfigManager(config, filepath.Join(t.TempDir(), "config.yaml"))
+	cm.SetReadOnly(true)
+	backend := testutil.NewFakeBackend(
+		testutil.FakeStream{Type: configuration.PlaybackStream, Name: "Firefox"},
+		testutil.FakeStream{Type: configuration.PlaybackStream, Name: "Spotify"},
+	)
+	executor := actions.NewExecutor(backend, cm, activity.NewLog(10))
+	t.Cleanup(executor.Close)
+
+	service, err := Start(connect(t, address), cm, executor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(service.Stop)
+	return connect(t, address), cm, backend
+}
+
+// callFails fails unless a method call returns an error of the service
+// containing message
+func callFails(t *testing.T, object dbus.BusObject, message string, method string, args ...interface{}) {
+	t.Helper()
+	err := object.Call(Interface+"."+method, 0, args...).Err
+	var dbusErr dbus.Error
+	if !errors.As(err, &dbusErr) || dbusErr.Name != errorName || !strings.Contains(dbusErr.Error(), message) {
+		t.Errorf("%s%v returned %v, want an error about %q", method, args, err, message)
+	}
+}
+
+func TestMethods(t *testing.T) {
+	conn, cm, backend := startService(t, testutil.StartBus(t))
+	object := conn.Object(Name, Path)
+
+	if err := object.Call(Interface+".SetControlValue", 0, "slider", "slider1", int32(40)).Err; err != nil {
+		t.Fatal(err)
+	}
+	if value := cm.GetConfigSnapshot().Controls.Sliders["slider1"].Value; value != 40 {
+		t.Errorf("slider1 set to %d, want 40", value)
+	}
+	changes := backend.VolumeChanges()
+	if len(changes) == 0 || changes[len(changes)-1].Target.Name != "Firefox" || math.Abs(float64(changes[len(changes)-1].Volume-0.4)) > 0.005 {
+		t.Errorf("volume changes %+v, want Firefox at 0.4", changes)
+	}
+	callFails(t, object, "no knob slider1", "SetControlValue", "knob", "slider1", int32(40))
+	callFails(t, object, "between 0 and 100", "SetControlValue", "slider", "slider1", int32(101))
+
+	var muted bool
+	if err := object.Call(Interface+".ToggleMute", 0, "slider1").Store(&muted); err != nil || !muted {
+		t.Errorf("ToggleMute of slider1 returned %v, %v", muted, err)
+	}
+	if slider := cm.GetConfigSnapshot().Controls.Sliders["slider1"]; !slider.Muted {
+		t.Error("slider1 not muted")
+	}
+	if firefox, _ := backend.Stream(configuration.PlaybackStream, "Firefox"); !firefox.Muted {
+		t.Error("Firefox on slider1 not muted")
+	}
+	if err := object.Call(Interface+".ToggleMute", 0, "PlaybackStream:Spotify").Store(&muted); err != nil || !muted {
+		t.Errorf("ToggleMute of Spotify returned %v, %v", muted, err)
+	}
+	if spotify, _ := backend.Stream(configuration.PlaybackStream, "Spotify"); !spotify.Muted {
+		t.Error("Spotify not muted")
+	}
+	callFails(t, object, "<type>:<name>", "ToggleMute", "slider99")
+	callFails(t, object, "unknown source type", "ToggleMute", "Stream:Spotify")
+	callFails(t, object, "no PlaybackStream matches mpv", "ToggleMute", "PlaybackStream:mpv")
+
+	callFails(t, object, "gaming", "SwitchProfile", "gaming")
+	if err := cm.CreateProfile("gaming"); err != nil {
+		t.Fatal(err)
+	}
+	if err := object.Call(Interface+".SwitchProfile", 0, "gaming").Err; err != nil {
+		t.Fatal(err)
+	}
+	if profile := cm.ActiveProfile(); profile != "gaming" {
+		t.Errorf("active profile is %s, want gaming", profile)
+	}
+
+	if err := object.Call(Interface+".RecallScene", 0, "quiet").Err; err != nil {
+		t.Error(err)
+	}
+	callFails(t, object, "loud", "RecallScene", "loud")
+
+	var data string
+	if err := object.Call(Interface+".GetState", 0).Store(&data); err != nil {
+		t.Fatal(err)
+	}
+	var state actions.State
+	if err := json.Unmarshal([]byte(data), &state); err != nil {
+		t.Fatal(err)
+	}
+	if state.ActiveProfile != "gaming" || len(state.Profiles) != 2 || len(state.Scenes) != 1 || !state.ReadOnly {
+		t.Errorf("state is %s", data)
+	}
+
+	// Methods of Service that aren't listed are not exported
+	if err := object.Call(Interface+".Stop", 0).Err; err == nil {
+		t.Error("Stop called over the bus")
+	}
+}
+
+func TestSignals(t *testing.T) {
+	conn, cm, _ := startService(t, testutil.StartBus(t))
+	if err := conn.AddMatchSignal(dbus.WithMatchInterface(Interface)); err != nil {
+		t.Fatal(err)
+	}
+	signals := make(chan *dbus.Signal, 10)
+	conn.Signal(signals)
+	receive := func(name string, body ...interface{}) {
+		t.Helper()
+		select {
+		case signal := <-signals:
+			if signal.Name != Interface+"."+name || signal.Path != Path {
+				t.Fatalf("received %s on %s, want %s", signal.Name, signal.Path, name)
+			}
+			if len(signal.Body) != len(body) {
+				t.Fatalf("%s carries %v, want %v", name, signal.Body, body)
+			}
+			for i := range body {
+				if signal.Body[i] != body[i] {
+					t.Errorf("%s carries %v, want %v", name, signal.Body, body)
+				}
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no %s signal", name)
+		}
+	}
+
+	cm.UpdateControlValue(activity.Midi(), "slider", "slider2", 30)
+	receive("ControlValueChanged", "slider", "slider2", int32(30))
+	if err := cm.SetControlMuted("knob", "knob1", true); err != nil {
+		t.Fatal(err)
+	}
+	receive("ControlMutedChanged", "knob", "knob1", true)
+	cm.AssignSource("slider", "slider2", configuration.Source{Type: configuration.PlaybackStream, Name: "Spotify"})
+	receive("SourcesChanged", "slider", "slider2")
+	cm.UnassignSource("slider", "slider2", configuration.Source{Type: configuration.PlaybackStream, Name: "Spotify"})
+	receive("SourcesChanged", "slider", "slider2")
+	if err := cm.CreateProfile("gaming"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.SwitchProfile("gaming"); err != nil {
+		t.Fatal(err)
+	}
+	receive("ProfileSwitched", "gaming")
+}
+
+func TestIntrospection(t *testing.T) {
+	conn, _, _ := startService(t, testutil.StartBus(t))
+	object := conn.Object(Name, Path)
+	var data string
+	if err := object.Call("org.freedesktop.DBus.Introspectable.Introspect", 0).Store(&data); err != nil {
+		t.Fatal(err)
+	}
+	var node introspect.Node
+	if err := xml.Unmarshal([]byte(data), &node); err != nil {
+		t.Fatal(err)
+	}
+	var described map[string]bool
+	for _, iface := range node.Interfaces {
+		if iface.Name != Interface {
+			continue
+		}
+		described = map[string]bool{}
+		for _, method := range iface.Methods {
+			described[method.Name] = true
+		}
+		if len(iface.Signals) != 4 {
+			t.Errorf("%d signals described, want 4", len(iface.Signals))
+		}
+	}
+	if described == nil {
+		t.Fatalf("%s not described", Interface)
+	}
+	// The description matches the exported methods
+	methods := (&Service{}).methods()
+	if len(described) != len(methods) {
+		t.Errorf("described methods %v, exported %d", described, len(methods))
+	}
+	for name := range methods {
+		if !described[name] {
+			t.Errorf("method %s not described", name)
+		}
+	}
+}
+
+func TestNameTaken(t *testing.T) {
+	address := testutil.StartBus(t)
+	client, cm, _ := startService(t, address)
+	if _, err := Start(connect(t, address), cm, nil); err == nil || !strings.Contains(err.Error(), "is taken") {
+		t.Errorf("second service started with %v", err)
+	}
+
+	// The first one keeps running
+	var data string
+	if err := client.Object(Name, Path).Call(Interface+".GetState", 0).Store(&data); err != nil {
+		t.Error(err)
+	}
+}
@@ -12,9 +12,9 @@ import (
 	"github.com/0h41/pulsekontrol/src/configuration"
 	"github.com/0h41/pulsekontrol/src/device"
 	"github.com/0h41/pulsekontrol/src/device/korg"
+	"github.com/0h41/pulsekontrol/src/logging"
 	"github.com/0h41/pulsekontrol/src/pulseaudio"
 	"github.com/rs/zerolog"
-	"github.com/rs/zerolog/log"
 	"gitlab.com/gomidi/midi/v2"
 	"gitlab.com/gomidi/midi/v2/drivers"
 	"gitlab.com/gomidi/midi/v2/sysex"
@@ -27,7 +27,7 @@ type KorgNanoKontrol2 struct {
 
 func New(name string) *KorgNanoKontrol2 {
 	return &KorgNanoKontrol2{
-		log:        log.With().Str("device", "Korg nanoKontrol2").Logger(),
+		log:        logging.Module("Midi").With().Str("device", "Korg nanoKontrol2").Logger(),
 		DeviceName: name,
 	}
 }
@@ -373,10 +373,10 @@ func (d *KorgNanoKontrol2) UpdateRules(
 					}
 					updatedRules = append(updatedRules, rule)
 				} else {
-					log.Warn().Msgf("Unknown device control path %s", rule.MidiMessage.DeviceControlPath)
+					d.log.Warn().Msgf("Unknown device control path %s", rule.MidiMessage.DeviceControlPath)
 				}
 			} else {
-				log.Warn().Msgf("Unknown device control path %s", rule.MidiMessage.DeviceControlPath)
+				d.log.Warn().Msgf("Unknown device control path %s", rule.MidiMessage.DeviceControlPath)
 			}
 		} else {
 			updatedRules = append(updatedRules, rule)
@@ -467,8 +467,57 @@ func (d *KorgNanoKontrol2) SetButtonLED(out drivers.Out, controller uint8, state
 	return send(midiData)
 }
 
+// ControlLEDs returns the S/M/R button LED controllers belonging to the group of a control path
+func (d *KorgNanoKontrol2) ControlLEDs(controlPath string) ([]uint8, error) {
+	group, _, _ := strings.Cut(controlPath, "/")
+	var controllers []uint8
+	for _, button := range []string{"Solo", "Mute", "Record"} {
+		if binding, ok := Controls.Buttons[group+"/"+button]; ok {
+			controllers = append(controllers, binding.Number)
+		}
+	}
+	if len(controllers) == 0 {
+		return nil, fmt.Errorf("control %s has no LEDs on the nanoKONTROL2", controlPath)
+	}
+	return controllers, nil
+}
+
+// BlinkLEDs toggles the given LEDs until the duration expires or cancel is closed.
+// All LEDs are left switched off, restoring their real state is up to the caller.
+func (d *KorgNanoKontrol2) BlinkLEDs(out drivers.Out, controllers []uint8, duration time.Duration, cancel <-chan struct{}) error {
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	deadline := time.After(duration)
+
+	state := true
+	for {
+		for _, controller := range controllers {
+			if err := d.SetButtonLED(out, controller, state); err != nil {
+				return err
+			}
+		}
+		if !state {
+			select {
+			case <-deadline:
+				return nil
+			case <-cancel:
+				return nil
+			default:
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-cancel:
+			state = true // Make sure we end with the LEDs off
+		}
+		state = !state
+	}
+}
+
 // UpdateSourceIndicatorLEDs updates S/R button LEDs based on currently active streams
-func (d *KorgNanoKontrol2) UpdateSourceIndicatorLEDs(out drivers.Out, config configuration.Config, paClient *pulseaudio.PAClient) error {
+// and M button LEDs based on the muted state of their controls
+func (d *KorgNanoKontrol2) UpdateSourceIndicatorLEDs(out drivers.Out, config configuration.Config, paClient pulseaudio.Backend) error {
 	// Enable external LED mode first (in case device was power cycled)
 	if err := d.EnableExternalLEDMode(out); err != nil {
 		d.log.Warn().Err(err).Msg("Failed to enable external LED mode")
@@ -483,7 +532,7 @@ func (d *KorgNanoKontrol2) UpdateSourceIndicatorLEDs(out drivers.Out, config con
 		hasActiveStream := false
 		
 		if slider, exists := config.Controls.Sliders[sliderId]; exists {
-			for _, source := range slider.Sources {
+			for _, source := range config.SliderSources(slider) {
 				if d.hasMatchingActiveStream(paClient, source) {
 					hasActiveStream = true
 					d.log.Debug().Msgf("Group %d slider has ACTIVE stream for source %s - turning ON Record LED", 
@@ -493,6 +542,18 @@ func (d *KorgNanoKontrol2) UpdateSourceIndicatorLEDs(out drivers.Out, config con
 			}
 		}
 		d.SetButtonLED(out, recordController, hasActiveStream)
+
+		// Mute button LED shows whether the control it mutes is muted
+		muteController := uint8(48 + groupNum - 1) // M buttons: 48-55
+		mutePath := fmt.Sprintf("Group%d/Mute", groupNum)
+		muted := false
+		for _, button := range config.Controls.Buttons {
+			if button.Path == mutePath {
+				muted = button.Muted
+				break
+			}
+		}
+		d.SetButtonLED(out, muteController, muted)
 		
 		// Check knob (Solo button LED) - light up if ANY assigned source has an active stream
 		knobId := fmt.Sprintf("knob%d", groupNum)
@@ -500,7 +561,7 @@ func (d *KorgNanoKontrol2) UpdateSourceIndicatorLEDs(out drivers.Out, config con
 		hasActiveStream = false
 		
 		if knob, exists := config.Controls.Knobs[knobId]; exists {
-			for _, source := range knob.Sources {
+			for _, source := range config.KnobSources(knob) {
 				if d.hasMatchingActiveStream(paClient, source) {
 					hasActiveStream = true
 					d.log.Debug().Msgf("Group %d knob has ACTIVE stream for source %s - turning ON Solo LED", 
@@ -511,14 +572,34 @@ func (d *KorgNanoKontrol2) UpdateSourceIndicatorLEDs(out drivers.Out, config con
 		}
 		d.SetButtonLED(out, soloController, hasActiveStream)
 	}
-	
+
+	return d.UpdateMicMuteLEDs(out, config, paClient.CachedStreams())
+}
+
+// UpdateMicMuteLEDs lights the buttons with a ToggleMicMute action while their
+// input device is muted in the streams
+func (d *KorgNanoKontrol2) UpdateMicMuteLEDs(out drivers.Out, config configuration.Config, streams []pulseaudio.CachedStream) error {
+	for _, id := range config.Controls.ButtonsWithAction(configuration.ToggleMicMute) {
+		button := config.Controls.Buttons[id]
+		binding, ok := Controls.Buttons[button.Path]
+		if !ok {
+			continue
+		}
+		index := slices.IndexFunc(button.Actions, func(action configuration.Action) bool {
+			return action.Type == configuration.ToggleMicMute
+		})
+		muted, _ := pulseaudio.InputMuted(streams, button.Actions[index].MicMuteInput())
+		if err := d.SetButtonLED(out, binding.Number, muted); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 // hasMatchingActiveStream checks if there's an active stream that matches the given source configuration
 // Uses the same logic as the web UI: exact BinaryName match when specified, legacy name match otherwise
 // LEDs only turn on for streams (PlaybackStream/RecordStream), not devices (OutputDevice/InputDevice)
-func (d *KorgNanoKontrol2) hasMatchingActiveStream(paClient *pulseaudio.PAClient, source configuration.Source) bool {
+func (d *KorgNanoKontrol2) hasMatchingActiveStream(paClient pulseaudio.Backend, source configuration.Source) bool {
 	// Don't turn on LEDs for devices, only for streams
 	sourceTypeLower := strings.ToLower(string(source.Type))
 	if sourceTypeLower == "outputdevice" || sourceTypeLower == "inputdevice" {
@@ -0,0 +1,57 @@
+package korgNanokontrol2
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/0h41/pulsekontrol/src/configuration"
+)
+
+// Transport button controllers in external mode
+var transportControllers = map[string]uint8{
+	"Transport/Track/Prev":  58,
+	"Transport/Track/Next":  59,
+	"Transport/Cycle":       46,
+	"Transport/Marker/Set":  60,
+	"Transport/Marker/Prev": 61,
+	"Transport/Marker/Next": 62,
+	"Transport/Rewind":      43,
+	"Transport/FastForward": 44,
+	"Transport/Stop":        42,
+	"Transport/Play":        41,
+	"Transport/Rec":         45,
+}
+
+// Controls are the control paths of the nanoKONTROL2 and the controllers
+// they send in external mode
+var Controls = controlMap()
+
+func controlMap() configuration.ControlMap {
+	controls := configuration.ControlMap{
+		Sliders: make(map[string]configuration.ControlBinding),
+		Knobs:   make(map[string]configuration.ControlBinding),
+		Buttons: make(map[string]configuration.ControlBinding),
+	}
+	for group := 1; group <= 8; group++ {
+		prefix := fmt.Sprintf("Group%d/", group)
+		offset := uint8(group - 1)
+		controls.Sliders[prefix+"Slider"] = configuration.ControlBinding{Number: offset}
+		controls.Knobs[prefix+"Knob"] = configuration.ControlBinding{Number: 16 + offset}
+		controls.Buttons[prefix+"Solo"] = configuration.ControlBinding{Number: 32 + offset}
+		controls.Buttons[prefix+"Mute"] = configuration.ControlBinding{Number: 48 + offset}
+		controls.Buttons[prefix+"Record"] = configuration.ControlBinding{Number: 64 + offset}
+	}
+	for path, controller := range transportControllers {
+		controls.Buttons[path] = configuration.ControlBinding{Number: controller}
+	}
+	return controls
+}
+
+// PortPattern matches the MIDI port names of a nanoKONTROL2, like
+// "nanoKONTROL2:nanoKONTROL2 nanoKONTROL2 _ CTRL 20:0"
+var PortPattern = regexp.MustCompile(`(?i)nanokontrol2`)
+
+func init() {
+	configuration.RegisterControlMap(configuration.KorgNanoKontrol2, Controls)
+	configuration.RegisterSignature(configuration.KorgNanoKontrol2, PortPattern)
+}
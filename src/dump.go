@@ -0,0 +1,195 @@
+package pulsekontrol
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/configuration"
+)
+
+// dumpHistory is how many recent actions a state dump lists
+const dumpHistory = 50
+
+// connectionState is the last reported state of the MIDI device or PulseAudio
+type connectionState struct {
+	known     bool
+	connected bool
+	err       string
+	since     time.Time
+}
+
+func (state connectionState) String() string {
+	switch {
+	case !state.known:
+		return "unknown"
+	case state.connected:
+		return "connected since " + state.since.Format(time.RFC3339)
+	default:
+		return fmt.Sprintf("disconnected since %s: %s", state.since.Format(time.RFC3339), state.err)
+	}
+}
+
+// trackConnections keeps the state of the MIDI device and PulseAudio for
+// DumpState. PulseAudio counts as connected if it answered at startup.
+func (a *App) trackConnections() {
+	a.stateMu.Lock()
+	a.paState = connectionState{known: true, connected: a.paErr == nil, since: a.clock.Now()}
+	if a.paErr != nil {
+		a.paState.err = a.paErr.Error()
+	}
+	a.stateMu.Unlock()
+
+	track := func(topic string, state *connectionState, connected bool) {
+		a.subscribe(topic, func(data interface{}) {
+			update, _ := data.(map[string]interface{})
+			message, _ := update["error"].(string)
+			a.stateMu.Lock()
+			defer a.stateMu.Unlock()
+			*state = connectionState{known: true, connected: connected, err: message, since: a.clock.Now()}
+		})
+	}
+	track("midi.connected", &a.midiState, true)
+	track("midi.disconnected", &a.midiState, false)
+	track("pulseaudio.connected", &a.paState, true)
+	track("pulseaudio.reconnected", &a.paState, true)
+	track("pulseaudio.disconnected", &a.paState, false)
+	a.subscribe("pulseaudio.timeout", func(data interface{}) {
+		a.stateMu.Lock()
+		defer a.stateMu.Unlock()
+		a.timeouts++
+	})
+}
+
+// DumpState writes what pulsekontrol thinks is going on, for finding out why
+// it does something odd: connections, configuration and save state, the
+// rules of the controls, the streams as last seen, web clients, goroutines
+// and the recent actions. Each part is copied under its lock at once and
+// nothing is asked from PulseAudio or the device, so running it never gets
+// in the way.
+func (a *App) DumpState(w io.Writer) error {
+	config, saveState := a.configManager.Snapshot()
+	a.stateMu.Lock()
+	midiState, paState, timeouts := a.midiState, a.paState, a.timeouts
+	a.stateMu.Unlock()
+	streams := a.paClient.CachedStreams()
+	entries := a.executor.Activity().Entries(dumpHistory)
+
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "pulsekontrol state dump at %s\n", a.clock.Now().Format(time.RFC3339))
+
+	fmt.Fprintln(out, "== process ==")
+	fmt.Fprintf(out, "pid: %d\n", os.Getpid())
+	fmt.Fprintf(out, "goroutines: %d\n", runtime.NumGoroutine())
+
+	fmt.Fprintln(out, "== connections ==")
+	if a.midiClient != nil {
+		fmt.Fprintf(out, "midi %s: %s\n", a.midiDevice.Name, midiState)
+	} else {
+		fmt.Fprintln(out, "midi: no device")
+	}
+	fmt.Fprintf(out, "pulseaudio: %s\n", paState)
+	fmt.Fprintf(out, "pulseaudio timeouts: %d\n", timeouts)
+	fmt.Fprintf(out, "dry run: %t\n", a.paClient.DryRun())
+
+	fmt.Fprintln(out, "== configuration ==")
+	fmt.Fprintf(out, "path: %s\n", saveState.Path)
+	fmt.Fprintf(out, "read-only: %t\n", saveState.ReadOnly)
+	fmt.Fprintf(out, "unsaved changes: %t\n", saveState.Dirty)
+	fmt.Fprintf(out, "failed saves: %d\n", saveState.Failures)
+	fmt.Fprintf(out, "active profile: %s\n", config.ActiveProfile)
+
+	fmt.Fprintln(out, "== rules ==")
+	for _, rule := range createRulesFromConfig(config, a.midiDevice) {
+		fmt.Fprintln(out, ruleLine(rule))
+	}
+
+	fmt.Fprintln(out, "== streams ==")
+	for _, stream := range streams {
+		fmt.Fprintf(out, "%s id=%q name=%q binary=%q volume=%.2f muted=%t default=%t\n",
+			stream.Type, stream.ID, stream.Name, stream.BinaryName, stream.Volume, stream.Muted, stream.Default)
+	}
+
+	fmt.Fprintln(out, "== web clients ==")
+	if a.webServer != nil {
+		for _, client := range a.webServer.Clients() {
+			fmt.Fprintf(out, "%s history=%t\n", client.Remote, client.History)
+		}
+	}
+
+	fmt.Fprintln(out, "== recent actions ==")
+	for _, entry := range entries {
+		origin := entry.Origin
+		if entry.Client != "" {
+			origin += "(" + entry.Client + ")"
+		}
+		fmt.Fprintf(out, "%s %s %s %s", entry.Time.Format(time.RFC3339Nano), origin, entry.Action, entry.Target)
+		if entry.Value != nil {
+			fmt.Fprintf(out, " %v", entry.Value)
+		}
+		fmt.Fprintln(out)
+	}
+	fmt.Fprintln(out, "== end ==")
+	return out.Flush()
+}
+
+// ruleLine describes a rule with its MIDI message and the targets of its actions
+func ruleLine(rule configuration.Rule) string {
+	message := rule.MidiMessage
+	number := fmt.Sprintf("controller %d", message.Controller)
+	if message.Type == configuration.Note {
+		number = fmt.Sprintf("note %d", message.Note)
+	}
+	actions := make([]string, 0, len(rule.Actions))
+	for _, action := range rule.Actions {
+		switch target := action.Target.(type) {
+		case *configuration.TypedTarget:
+			actions = append(actions, fmt.Sprintf("%s %s:%s", action.Type, target.Type, target.Name))
+		case *configuration.Target:
+			actions = append(actions, fmt.Sprintf("%s %s", action.Type, target.Name))
+		default:
+			actions = append(actions, string(action.Type))
+		}
+	}
+	return fmt.Sprintf("%s %s channel %d %s -> %s", message.DeviceControlPath, message.Type, message.Channel, number, strings.Join(actions, ", "))
+}
+
+// writeStateDump writes DumpState to a file with the time in its name in the
+// temporary directory. If the file can't be written, the dump goes to the
+// log instead.
+func (a *App) writeStateDump() {
+	if !a.dumping.CompareAndSwap(false, true) {
+		a.log.Warn().Msg("A state dump is already being written")
+		return
+	}
+	defer a.dumping.Store(false)
+
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("pulsekontrol-state-%s.txt", a.clock.Now().Format("20060102-150405.000")))
+	err := writeFile(path, a.DumpState)
+	if err == nil {
+		a.log.Info().Str("path", path).Msg("Wrote state dump")
+		return
+	}
+	var dump bytes.Buffer
+	a.DumpState(&dump)
+	a.log.Warn().Err(err).Str("dump", dump.String()).Msg("Could not write state dump to a file")
+}
+
+// writeFile creates a file only the user can read and fills it with write
+func writeFile(path string, write func(io.Writer) error) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
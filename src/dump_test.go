@@ -0,0 +1,134 @@
+package pulsekontrol
+
+import (
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/0h41/pulsekontrol/src/internal/testutil"
+	"github.com/rs/zerolog"
+)
+
+// waitForDump waits until a complete state dump was written to dir and
+// returns it
+func waitForDump(t *testing.T, dir string) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		paths, _ := filepath.Glob(filepath.Join(dir, "pulsekontrol-state-*.txt"))
+		if len(paths) > 0 {
+			if data, _ := os.ReadFile(paths[0]); strings.HasSuffix(string(data), "== end ==\n") {
+				if info, err := os.Stat(paths[0]); err != nil || info.Mode().Perm() != 0o600 {
+					t.Errorf("dump has mode %v: %v", info.Mode().Perm(), err)
+				}
+				return string(data)
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no state dump in %s: %v", dir, paths)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStateDumpOnSignal(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+	backend := testutil.NewFakeBackend(testutil.FakeStream{Type: configuration.PlaybackStream, Name: "Firefox", Volume: 0.3})
+	driver := testutil.NewFakeDriver(true)
+	app := startApp(t, testConfig(configuration.Source{Type: configuration.PlaybackStream, Name: "Firefox"}), backend, driver)
+	select {
+	case <-driver.Opened():
+	case <-time.After(5 * time.Second):
+		t.Fatal("MIDI device never opened")
+	}
+	waitForVolume(t, backend, "Firefox", 0.8)
+	// The fader is listened to shortly after the ports are open
+	deadline := time.Now().Add(5 * time.Second)
+	for !driver.In().SendControlChange(0, 7, 127) {
+		if time.Now().After(deadline) {
+			t.Fatal("nobody listens to the MIDI device")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	waitForVolume(t, backend, "Firefox", 1)
+
+	// SIGUSR1 would terminate the test before the handler is installed
+	ignored := make(chan os.Signal, 1)
+	signal.Notify(ignored, syscall.SIGUSR1)
+	defer signal.Stop(ignored)
+	status := make(chan int, 1)
+	go func() { status <- waitForShutdown(app) }()
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+		if paths, _ := filepath.Glob(filepath.Join(dir, "pulsekontrol-state-*.txt")); len(paths) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("SIGUSR1 not handled")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	dump := waitForDump(t, dir)
+
+	for _, marker := range []string{
+		"pulsekontrol state dump at ",
+		"== process ==",
+		"goroutines: ",
+		"midi mixer: connected since ",
+		"pulseaudio: connected since ",
+		"unsaved changes: ",
+		"Fader1 ControlChange channel 0 controller 7 -> SetVolume PlaybackStream:Firefox\n",
+		`PlaybackStream id="Firefox" name="Firefox" binary="" volume=1.00 muted=false`,
+		"== web clients ==",
+		"== recent actions ==",
+		" midi SetControlValue slider1 100\n",
+		"== end ==",
+	} {
+		if !strings.Contains(dump, marker) {
+			t.Errorf("dump is missing %q:\n%s", marker, dump)
+		}
+	}
+
+	// The device is still listened to
+	driver.In().SendControlChange(0, 7, 0)
+	waitForVolume(t, backend, "Firefox", 0)
+
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	select {
+	case code := <-status:
+		if code != 0 {
+			t.Errorf("exited with status %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("SIGTERM not handled")
+	}
+}
+
+func TestStateDumpToLog(t *testing.T) {
+	backend := testutil.NewFakeBackend()
+	app := startApp(t, testConfig(), backend, testutil.NewFakeDriver(false))
+	logs := &syncWriter{w: &strings.Builder{}}
+	app.log = zerolog.New(logs)
+
+	// Without a writable temporary directory the dump is logged
+	t.Setenv("TMPDIR", filepath.Join(t.TempDir(), "missing"))
+	app.writeStateDump()
+	if logged := logs.String(); !strings.Contains(logged, "Could not write state dump to a file") || !strings.Contains(logged, "== end ==") {
+		t.Errorf("logged\n%s", logged)
+	}
+
+	// A dump being written is not started again
+	app.dumping.Store(true)
+	app.writeStateDump()
+	app.dumping.Store(false)
+	if !strings.Contains(logs.String(), "A state dump is already being written") {
+		t.Errorf("second dump not refused:\n%s", logs.String())
+	}
+}
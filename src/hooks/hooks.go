@@ -0,0 +1,334 @@
+// Package hooks runs the commands of the hooks section when their event
+// happens, like a script that switches the LEDs of a headset when it
+// connects. Every notification topic of the configuration manager is an
+// event, so new events can be hooked without changes here.
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode"
+
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/0h41/pulsekontrol/src/logging"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// DefaultTimeout is how long a command may run without a configured timeout
+	DefaultTimeout = 10 * time.Second
+	// maxRunning is how many commands of one hook may run at once, further events are dropped
+	maxRunning = 4
+	// maxOutput is how much of the output of a failed command is logged
+	maxOutput = 4096
+	// waitDelay is how long the output of a killed command is waited for
+	waitDelay = time.Second
+	// envPrefix starts the names of the variables with the event details
+	envPrefix = "PK_"
+)
+
+// Runner runs the hooks of the configuration and follows its changes
+type Runner struct {
+	log           zerolog.Logger
+	configManager *configuration.ConfigManager
+
+	mu            sync.Mutex
+	hooks         map[string]configuration.HookConfig
+	subscriptions []configuration.Subscription
+	merged        configuration.Subscription
+	running       map[string]chan struct{} // Slots of the running commands by hook name
+	stopped       bool
+}
+
+// Start subscribes to the events of the configured hooks
+func Start(configManager *configuration.ConfigManager) *Runner {
+	r := &Runner{
+		log:           logging.Module("Hooks"),
+		configManager: configManager,
+		running:       make(map[string]chan struct{}),
+	}
+	r.apply(configManager.GetConfigSnapshot().Hooks)
+	r.merged = configManager.Subscribe("config.merged", func(data interface{}) {
+		r.apply(configManager.GetConfigSnapshot().Hooks)
+	})
+	return r
+}
+
+// apply subscribes to the events of hooks instead of those of the hooks before
+func (r *Runner) apply(hooks map[string]configuration.HookConfig) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped || reflect.DeepEqual(hooks, r.hooks) {
+		return
+	}
+	for _, subscription := range r.subscriptions {
+		r.configManager.Unsubscribe(subscription)
+	}
+	r.subscriptions = nil
+	r.hooks = hooks
+
+	byEvent := make(map[string][]string)
+	for name, hook := range hooks {
+		byEvent[hook.Event] = append(byEvent[hook.Event], name)
+	}
+	for event, names := range byEvent {
+		sort.Strings(names)
+		r.subscriptions = append(r.subscriptions, r.configManager.Subscribe(event, func(data interface{}) {
+			r.dispatch(event, names, data)
+		}))
+	}
+	if len(hooks) > 0 {
+		r.log.Info().Int("hooks", len(hooks)).Int("events", len(byEvent)).Msg("Hooks ready")
+	}
+}
+
+// dispatch starts the commands of the hooks whose selectors match an event.
+// It runs on the notification goroutine of the configuration manager, so it
+// must never wait for a command.
+func (r *Runner) dispatch(event string, names []string, data interface{}) {
+	details := Details(data)
+	environment := Environment(event, details)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return
+	}
+	for _, name := range names {
+		hook, ok := r.hooks[name]
+		if !ok || hook.Event != event || !Matches(hook.Match, details) {
+			continue
+		}
+		slots, ok := r.running[name]
+		if !ok {
+			slots = make(chan struct{}, maxRunning)
+			r.running[name] = slots
+		}
+		select {
+		case slots <- struct{}{}:
+		default:
+			r.log.Warn().Str("hook", name).Str("event", event).Msg("Hook is still running, event dropped")
+			continue
+		}
+		go func() {
+			defer func() { <-slots }()
+			r.run(name, hook, event, environment)
+		}()
+	}
+}
+
+// run runs the command of a hook and logs its failure
+func (r *Runner) run(name string, hook configuration.HookConfig, event string, environment []string) {
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", hook.Command)
+	cmd.Env = append(os.Environ(), environment...)
+	// Kill the whole process group, not only the shell
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = waitDelay
+	output := &limitedBuffer{}
+	cmd.Stdout, cmd.Stderr = output, output
+
+	started := time.Now()
+	err := cmd.Run()
+	elapsed := time.Since(started)
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		r.log.Warn().Str("hook", name).Str("event", event).Dur("timeout", timeout).Str("output", output.String()).Msg("Hook timed out and was killed")
+	case err != nil:
+		r.log.Warn().Err(err).Str("hook", name).Str("event", event).Dur("duration", elapsed).Str("output", output.String()).Msg("Hook failed")
+	default:
+		r.log.Debug().Str("hook", name).Str("event", event).Dur("duration", elapsed).Msg("Ran hook")
+	}
+}
+
+// Stop stops running hooks for new events. Commands already running finish
+// or time out on their own.
+func (r *Runner) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stopped = true
+	for _, subscription := range r.subscriptions {
+		r.configManager.Unsubscribe(subscription)
+	}
+	r.subscriptions = nil
+	r.configManager.Unsubscribe(r.merged)
+}
+
+// Details flattens the data of a notification into variable names without
+// the prefix and their values. Struct fields and map keys are joined by
+// underscores, so the source of an event gives SOURCE_NAME and SOURCE_TYPE.
+func Details(data interface{}) map[string]string {
+	details := make(map[string]string)
+	flatten(details, "", reflect.ValueOf(data))
+	return details
+}
+
+func flatten(details map[string]string, name string, value reflect.Value) {
+	for value.Kind() == reflect.Interface || value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return
+		}
+		value = value.Elem()
+	}
+	if !value.IsValid() {
+		return
+	}
+	if !value.CanInterface() {
+		return
+	}
+	if stringer, ok := value.Interface().(fmt.Stringer); ok && !hasExportedFields(value) {
+		// Like a time, which has nothing else to show, unless it is unset
+		if value.Kind() != reflect.Struct || !value.IsZero() {
+			details[valueName(name)] = stringer.String()
+		}
+		return
+	}
+	switch value.Kind() {
+	case reflect.Map:
+		if value.Type().Key().Kind() != reflect.String {
+			return
+		}
+		iter := value.MapRange()
+		for iter.Next() {
+			flatten(details, join(name, EnvName(iter.Key().String())), iter.Value())
+		}
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			if field := value.Type().Field(i); field.IsExported() {
+				flatten(details, join(name, EnvName(field.Name)), value.Field(i))
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		var items []string
+		for i := 0; i < value.Len(); i++ {
+			if item := value.Index(i); item.Kind() == reflect.String {
+				items = append(items, item.String())
+			}
+		}
+		if len(items) > 0 {
+			details[valueName(name)] = strings.Join(items, ",")
+		}
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		details[valueName(name)] = fmt.Sprint(value.Interface())
+	}
+}
+
+func hasExportedFields(value reflect.Value) bool {
+	if value.Kind() != reflect.Struct {
+		return false
+	}
+	for i := 0; i < value.NumField(); i++ {
+		if value.Type().Field(i).IsExported() {
+			return true
+		}
+	}
+	return false
+}
+
+// valueName names the data of a notification that is not a map
+func valueName(name string) string {
+	if name == "" {
+		return "VALUE"
+	}
+	return name
+}
+
+func join(prefix string, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "_" + name
+}
+
+// EnvName turns a key like sourceName or BinaryName into SOURCE_NAME or BINARY_NAME
+func EnvName(key string) string {
+	runes := []rune(key)
+	var name strings.Builder
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			name.WriteRune('_')
+			continue
+		}
+		if i > 0 && unicode.IsUpper(r) {
+			previous := runes[i-1]
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(previous) || unicode.IsDigit(previous) || (unicode.IsUpper(previous) && nextIsLower) {
+				name.WriteRune('_')
+			}
+		}
+		name.WriteRune(unicode.ToUpper(r))
+	}
+	return name.String()
+}
+
+// Environment returns the variables of an event for its commands, sorted by name
+func Environment(event string, details map[string]string) []string {
+	environment := []string{envPrefix + "EVENT=" + event}
+	names := make([]string, 0, len(details))
+	for name := range details {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		environment = append(environment, envPrefix+name+"="+details[name])
+	}
+	return environment
+}
+
+// Matches reports whether the details of an event match all selectors. The
+// keys of the selectors may be written like the variables or in camel case.
+func Matches(selectors map[string]string, details map[string]string) bool {
+	for key, pattern := range selectors {
+		value, ok := details[EnvName(strings.TrimPrefix(key, envPrefix))]
+		if !ok {
+			return false
+		}
+		if matched, err := filepath.Match(pattern, value); err != nil || !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// limitedBuffer keeps the start of the output of a command
+type limitedBuffer struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+func (buffer *limitedBuffer) Write(p []byte) (int, error) {
+	buffer.mu.Lock()
+	defer buffer.mu.Unlock()
+	if room := maxOutput - len(buffer.data); room > 0 {
+		buffer.data = append(buffer.data, p[:min(room, len(p))]...)
+	}
+	return len(p), nil
+}
+
+func (buffer *limitedBuffer) String() string {
+	buffer.mu.Lock()
+	defer buffer.mu.Unlock()
+	return strings.TrimSpace(string(buffer.data))
+}
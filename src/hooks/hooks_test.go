@@ -0,0 +1,145 @@
+package hooks
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/0h41/pulsekontrol/src/logging"
+)
+
+// waitForFile returns the content of a file written by a hook
+func waitForFile(t *testing.T, path string) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if content, err := os.ReadFile(path); err == nil && len(content) > 0 {
+			return string(content)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s was not written", filepath.Base(path))
+	return ""
+}
+
+func TestHookRunsWithEventDetails(t *testing.T) {
+	dir := t.TempDir()
+	config := configuration.GetDefaultConfig()
+	config.Hooks = map[string]configuration.HookConfig{
+		"firefox": {
+			Event:   "source.added",
+			Match:   map[string]string{"sourceName": "Fire*"},
+			Command: "env | grep ^PK_ | sort > " + filepath.Join(dir, "firefox"),
+		},
+		"spotify": {
+			Event:   "source.added",
+			Match:   map[string]string{"PK_SOURCE_NAME": "Spotify"},
+			Command: "touch " + filepath.Join(dir, "spotify"),
+		},
+	}
+	cm := configuration.NewConfigManager(config, filepath.Join(dir, "config.yaml"))
+	cm.SetReadOnly(true)
+	t.Cleanup(cm.Close)
+	runner := Start(cm)
+	t.Cleanup(runner.Stop)
+
+	cm.NotifySync("source.added", map[string]interface{}{
+		"control": "slider1",
+		"source":  configuration.Source{Type: configuration.PlaybackStream, Name: "Firefox"},
+	})
+
+	environment := waitForFile(t, filepath.Join(dir, "firefox"))
+	for _, variable := range []string{"PK_EVENT=source.added", "PK_CONTROL=slider1", "PK_SOURCE_NAME=Firefox", "PK_SOURCE_TYPE=PlaybackStream"} {
+		if !strings.Contains(environment, variable+"\n") {
+			t.Errorf("%s missing from the environment:\n%s", variable, environment)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "spotify")); err == nil {
+		t.Error("hook ran although its selector doesn't match")
+	}
+}
+
+func TestHookStopsOnStop(t *testing.T) {
+	dir := t.TempDir()
+	config := configuration.GetDefaultConfig()
+	config.Hooks = map[string]configuration.HookConfig{
+		"any": {Event: "profile.switched", Command: "touch " + filepath.Join(dir, "ran")},
+	}
+	cm := configuration.NewConfigManager(config, filepath.Join(dir, "config.yaml"))
+	cm.SetReadOnly(true)
+	t.Cleanup(cm.Close)
+	runner := Start(cm)
+	runner.Stop()
+
+	cm.NotifySync("profile.switched", "gaming")
+	time.Sleep(200 * time.Millisecond)
+	if _, err := os.Stat(filepath.Join(dir, "ran")); err == nil {
+		t.Error("hook ran after Stop")
+	}
+}
+
+func TestHookTimeoutKillsProcessGroup(t *testing.T) {
+	dir := t.TempDir()
+	after := filepath.Join(dir, "after")
+	runner := &Runner{log: logging.Module("Hooks")}
+	hook := configuration.HookConfig{
+		Event: "profile.switched",
+		// The background command is only killed with the whole group
+		Command: "(sleep 0.5; touch " + after + ") & sleep 30",
+		Timeout: 100 * time.Millisecond,
+	}
+
+	started := time.Now()
+	runner.run("slow", hook, hook.Event, nil)
+	if elapsed := time.Since(started); elapsed > waitDelay+time.Second {
+		t.Errorf("run returned after %v", elapsed)
+	}
+
+	time.Sleep(time.Second)
+	if _, err := os.Stat(after); err == nil {
+		t.Error("background command survived the timeout")
+	}
+}
+
+func TestDetails(t *testing.T) {
+	details := Details(map[string]interface{}{
+		"control": "slider1",
+		"value":   42,
+		"source":  configuration.Source{Type: configuration.PlaybackStream, Name: "Firefox", BinaryName: "firefox"},
+		"tags":    []string{"a", "b"},
+	})
+	for name, want := range map[string]string{
+		"CONTROL":            "slider1",
+		"VALUE":              "42",
+		"SOURCE_NAME":        "Firefox",
+		"SOURCE_BINARY_NAME": "firefox",
+		"TAGS":               "a,b",
+	} {
+		if details[name] != want {
+			t.Errorf("%s is %q, want %q", name, details[name], want)
+		}
+	}
+	if details := Details("gaming"); details["VALUE"] != "gaming" {
+		t.Errorf("plain value gives %v", details)
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	for key, want := range map[string]string{
+		"sourceName":  "SOURCE_NAME",
+		"BinaryName":  "BINARY_NAME",
+		"processID":   "PROCESS_ID",
+		"HTTPServer":  "HTTP_SERVER",
+		"slider1":     "SLIDER1",
+		"control-id":  "CONTROL_ID",
+		"PK_EVENT":    "PK_EVENT",
+		"value2Level": "VALUE2_LEVEL",
+	} {
+		if name := EnvName(key); name != want {
+			t.Errorf("EnvName(%q) = %q, want %q", key, name, want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package hotkeys
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// eventKey is EV_KEY, the type of key presses and releases
+	eventKey = 0x01
+	// eventRepeat is EV_REP, only supported by keyboards
+	eventRepeat = 0x14
+
+	keyReleased = 0
+	keyPressed  = 1
+	keyRepeated = 2
+)
+
+// eventSize is the size of struct input_event: the time, then the type and
+// code as uint16 and the value as int32
+var eventSize = int(unsafe.Sizeof(syscall.Timeval{})) + 8
+
+// event is the part of an input event hotkeys need
+type event struct {
+	kind  uint16
+	code  uint16
+	value int32
+}
+
+// readEvents reads input events until the device fails or is closed
+func readEvents(reader io.Reader, handle func(event)) error {
+	buffer := make([]byte, eventSize*64)
+	offset := eventSize - 8
+	for {
+		n, err := io.ReadAtLeast(reader, buffer, eventSize)
+		if err != nil {
+			return err
+		}
+		for start := 0; start+eventSize <= n; start += eventSize {
+			data := buffer[start+offset:]
+			handle(event{
+				kind:  binary.NativeEndian.Uint16(data),
+				code:  binary.NativeEndian.Uint16(data[2:]),
+				value: int32(binary.NativeEndian.Uint32(data[4:])),
+			})
+		}
+	}
+}
+
+// devicesFile lists the input devices with their handlers and capabilities
+const devicesFile = "/proc/bus/input/devices"
+
+// DiscoverKeyboards returns the event devices of the keyboards, those that
+// have the kbd handler and support key repeat, which leaves out power
+// buttons and the like
+func DiscoverKeyboards() ([]string, error) {
+	file, err := os.Open(devicesFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseKeyboards(file), nil
+}
+
+// parseKeyboards returns the event devices of the keyboards in a devices file.
+// Each device is a block of lines like H: Handlers=sysrq kbd event3 and
+// B: EV=120013, separated by empty lines.
+func parseKeyboards(reader io.Reader) []string {
+	var keyboards []string
+	var handlers []string
+	var capabilities uint64
+	flush := func() {
+		if isKeyboard(handlers, capabilities) {
+			for _, handler := range handlers {
+				if strings.HasPrefix(handler, "event") {
+					keyboards = append(keyboards, filepath.Join("/dev/input", handler))
+				}
+			}
+		}
+		handlers, capabilities = nil, 0
+	}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			flush()
+		case strings.HasPrefix(line, "H: Handlers="):
+			handlers = strings.Fields(strings.TrimPrefix(line, "H: Handlers="))
+		case strings.HasPrefix(line, "B: EV="):
+			capabilities, _ = strconv.ParseUint(strings.TrimPrefix(line, "B: EV="), 16, 64)
+		}
+	}
+	flush()
+	return keyboards
+}
+
+func isKeyboard(handlers []string, capabilities uint64) bool {
+	hasKbd := false
+	for _, handler := range handlers {
+		hasKbd = hasKbd || handler == "kbd"
+	}
+	return hasKbd && capabilities&(1<<eventKey) != 0 && capabilities&(1<<eventRepeat) != 0
+}
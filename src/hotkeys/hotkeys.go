@@ -0,0 +1,256 @@
+// Package hotkeys turns key chords of the keyboard into actions, for laptops
+// without a controller. It reads the Linux input devices, which requires
+// membership of the input group, and never grabs them, so typing and the
+// shortcuts of the desktop keep working. The actions run like those of a
+// button, so a chord can step a slider, mute a knob or recall a scene.
+package hotkeys
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/activity"
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/0h41/pulsekontrol/src/logging"
+	"github.com/0h41/pulsekontrol/src/supervise"
+	"github.com/rs/zerolog"
+)
+
+// rescanInterval is how often devices that are missing or were unplugged
+// are looked for
+const rescanInterval = 5 * time.Second
+
+// ActionRunner runs the actions of a binding for a press or release, like
+// the MIDI client does for its buttons. path identifies the binding.
+type ActionRunner func(origin activity.Origin, path string, actions []configuration.Action, pressed bool)
+
+// Listener reads the keyboards and runs the actions of the bindings. The
+// bindings are looked up on each press, so changes apply right away.
+type Listener struct {
+	log           zerolog.Logger
+	configManager *configuration.ConfigManager
+	devices       []string // Configured devices, all keyboards without
+	run           ActionRunner
+
+	mu       sync.Mutex
+	open     map[string]*os.File
+	reported map[string]bool // Devices whose failure to open was logged
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewListener creates a listener for the devices, or for all keyboards if
+// there are none, reading once started
+func NewListener(configManager *configuration.ConfigManager, devices []string, run ActionRunner) *Listener {
+	return &Listener{
+		log:           logging.Module("Hotkeys"),
+		configManager: configManager,
+		devices:       devices,
+		run:           run,
+		open:          make(map[string]*os.File),
+		reported:      make(map[string]bool),
+	}
+}
+
+// Start opens the devices and looks for missing ones until Stop
+func (l *Listener) Start() {
+	for i, binding := range l.configManager.GetConfigSnapshot().Hotkeys.Bindings {
+		if _, err := ParseChord(binding.Keys); err != nil {
+			l.log.Error().Err(err).Int("binding", i).Str("keys", binding.Keys).Msg("Invalid hotkey, it is ignored")
+		}
+	}
+	l.stop = make(chan struct{})
+	l.scan()
+	l.wg.Add(1)
+	supervise.Go("hotkeys.scan", func() {
+		l.rescan()
+		l.wg.Done()
+	})
+}
+
+// Stop closes the devices and waits for the reading to end
+func (l *Listener) Stop() {
+	if l.stop == nil {
+		return
+	}
+	close(l.stop)
+	l.mu.Lock()
+	for _, file := range l.open {
+		file.Close()
+	}
+	l.mu.Unlock()
+	l.wg.Wait()
+}
+
+// rescan looks for missing devices until Stop
+func (l *Listener) rescan() {
+	ticker := time.NewTicker(rescanInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			l.scan()
+		}
+	}
+}
+
+// scan opens the devices that are not open yet
+func (l *Listener) scan() {
+	paths := l.devices
+	if len(paths) == 0 {
+		keyboards, err := DiscoverKeyboards()
+		if err != nil {
+			l.reportOnce(devicesFile, func() {
+				l.log.Error().Err(err).Msg("Cannot list the input devices, configure hotkeys.devices")
+			})
+			return
+		}
+		if len(keyboards) == 0 {
+			l.reportOnce(devicesFile, func() {
+				l.log.Warn().Msg("No keyboard found, hotkeys work once one is plugged in")
+			})
+		}
+		paths = keyboards
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-l.stop:
+		return
+	default:
+	}
+	for _, path := range paths {
+		if _, ok := l.open[path]; ok {
+			continue
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			l.reportOpenError(path, err)
+			continue
+		}
+		delete(l.reported, path)
+		l.open[path] = file
+		l.log.Info().Str("device", path).Msg("Reading hotkeys")
+		l.wg.Add(1)
+		supervise.Go("hotkeys.read", func() {
+			l.read(path, file)
+			l.wg.Done()
+		})
+	}
+}
+
+// reportOpenError logs why a device can't be opened, once until it opens.
+// Must be called with mu held.
+func (l *Listener) reportOpenError(path string, err error) {
+	if l.reported[path] {
+		return
+	}
+	l.reported[path] = true
+	switch {
+	case errors.Is(err, fs.ErrPermission):
+		l.log.Error().Str("device", path).Msg("Not allowed to read the input device, add the user to the input group with sudo usermod -aG input $USER and log in again")
+	case errors.Is(err, fs.ErrNotExist):
+		l.log.Warn().Str("device", path).Msg("Input device not found, hotkeys work once it is plugged in")
+	default:
+		l.log.Error().Err(err).Str("device", path).Msg("Cannot open input device")
+	}
+}
+
+func (l *Listener) reportOnce(key string, report func()) {
+	l.mu.Lock()
+	reported := l.reported[key]
+	l.reported[key] = true
+	l.mu.Unlock()
+	if !reported {
+		report()
+	}
+}
+
+// read handles the events of a device until it is unplugged or closed
+func (l *Listener) read(path string, file *os.File) {
+	keyboard := newKeyboard(l, path)
+	err := readEvents(file, keyboard.handle)
+	keyboard.releaseAll()
+
+	l.mu.Lock()
+	delete(l.open, path)
+	l.mu.Unlock()
+	file.Close()
+	select {
+	case <-l.stop:
+	default:
+		l.log.Info().Err(err).Str("device", path).Msg("Input device removed")
+	}
+}
+
+// keyboard tracks the modifiers of a device and the bindings whose keys are down
+type keyboard struct {
+	listener  *Listener
+	path      string
+	modifiers map[uint16]bool // Modifier keys held down, by code
+	held      map[uint16]configuration.HotkeyBinding
+}
+
+func newKeyboard(listener *Listener, path string) *keyboard {
+	return &keyboard{
+		listener:  listener,
+		path:      path,
+		modifiers: make(map[uint16]bool),
+		held:      make(map[uint16]configuration.HotkeyBinding),
+	}
+}
+
+// handle runs the binding of a pressed chord, and its release when the key
+// goes up. Key repeats are left out, StepControl repeats on its own.
+func (k *keyboard) handle(e event) {
+	if e.kind != eventKey || e.value == keyRepeated {
+		return
+	}
+	if _, ok := modifierCodes[e.code]; ok {
+		k.modifiers[e.code] = e.value == keyPressed
+		return
+	}
+	if e.value == keyReleased {
+		if binding, ok := k.held[e.code]; ok {
+			delete(k.held, e.code)
+			k.listener.run(activity.Hotkey(), bindingPath(binding), binding.Actions, false)
+		}
+		return
+	}
+
+	chord := Chord{Key: e.code}
+	for code, down := range k.modifiers {
+		if down {
+			chord.Modifiers |= modifierCodes[code]
+		}
+	}
+	for _, binding := range k.listener.configManager.GetConfigSnapshot().Hotkeys.Bindings {
+		if bound, err := ParseChord(binding.Keys); err != nil || bound != chord {
+			continue
+		}
+		k.listener.log.Debug().Str("keys", binding.Keys).Str("device", k.path).Msg("Hotkey pressed")
+		k.held[e.code] = binding
+		k.listener.run(activity.Hotkey(), bindingPath(binding), binding.Actions, true)
+		return
+	}
+}
+
+// releaseAll releases the bindings still held when a device goes away, so
+// that stepping stops
+func (k *keyboard) releaseAll() {
+	for code, binding := range k.held {
+		delete(k.held, code)
+		k.listener.run(activity.Hotkey(), bindingPath(binding), binding.Actions, false)
+	}
+}
+
+// bindingPath names a binding in the action history
+func bindingPath(binding configuration.HotkeyBinding) string {
+	return "hotkey/" + binding.Keys
+}
@@ -0,0 +1,136 @@
+package hotkeys
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/0h41/pulsekontrol/src/activity"
+	"github.com/0h41/pulsekontrol/src/configuration"
+)
+
+func TestParseChord(t *testing.T) {
+	for _, test := range []struct {
+		keys  string
+		chord Chord
+	}{
+		{"Super+F7", Chord{Super, 65}},
+		{"ctrl + alt + m", Chord{Ctrl | Alt, 50}},
+		{"Mod+Shift+1", Chord{Super | Shift, 2}},
+		{"Control+0", Chord{Ctrl, 11}},
+		{"VolumeUp", Chord{0, 115}},
+		{"Meta+F13", Chord{Super, 183}},
+		{"a", Chord{0, 30}},
+	} {
+		chord, err := ParseChord(test.keys)
+		if err != nil || chord != test.chord {
+			t.Errorf("%q parsed as %+v, %v, want %+v", test.keys, chord, err, test.chord)
+		}
+	}
+
+	for keys, message := range map[string]string{
+		"":          "empty key",
+		"Ctrl++M":   "empty key",
+		"Ctrl+":     "empty key",
+		"Hyper+M":   "Hyper is not a modifier",
+		"M+Ctrl":    "M is not a modifier",
+		"Super+Foo": "unknown key Foo",
+		"Ctrl+Alt":  "has no key besides the modifiers",
+	} {
+		if _, err := ParseChord(keys); err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("%q gave %v, want %q", keys, err, message)
+		}
+	}
+}
+
+func TestParseKeyboards(t *testing.T) {
+	devices := `I: Bus=0019 Vendor=0000 Product=0001 Version=0000
+N: Name="Power Button"
+H: Handlers=kbd event0
+B: EV=3
+
+I: Bus=0011 Vendor=0001 Product=0001 Version=ab83
+N: Name="AT Translated Set 2 keyboard"
+H: Handlers=sysrq kbd leds event3
+B: EV=120013
+
+I: Bus=0003 Vendor=046d Product=c52b Version=0111
+N: Name="Logitech Mouse"
+H: Handlers=mouse0 event5
+B: EV=17
+`
+	keyboards := parseKeyboards(strings.NewReader(devices))
+	if !slices.Equal(keyboards, []string{"/dev/input/event3"}) {
+		t.Errorf("found keyboards %v", keyboards)
+	}
+}
+
+// input encodes key events like a keyboard device
+func input(events ...event) *bytes.Buffer {
+	var buffer bytes.Buffer
+	for _, e := range events {
+		data := make([]byte, eventSize)
+		binary.NativeEndian.PutUint16(data[eventSize-8:], e.kind)
+		binary.NativeEndian.PutUint16(data[eventSize-6:], e.code)
+		binary.NativeEndian.PutUint32(data[eventSize-4:], uint32(e.value))
+		buffer.Write(data)
+	}
+	return &buffer
+}
+
+func key(code uint16, value int32) event {
+	return event{kind: eventKey, code: code, value: value}
+}
+
+func TestChordsRunBindings(t *testing.T) {
+	config := configuration.GetDefaultConfig()
+	config.Hotkeys.Bindings = []configuration.HotkeyBinding{
+		{Keys: "Super+F7", Actions: []configuration.Action{{Type: configuration.MediaPlayPause}}},
+		{Keys: "Ctrl+Shift+M", Actions: []configuration.Action{{Type: configuration.SetDefaultOutput}}},
+		{Keys: "Not+AKey", Actions: []configuration.Action{{Type: configuration.SetVolume}}},
+	}
+	cm := configuration.NewConfigManager(config, filepath.Join(t.TempDir(), "config.yaml"))
+	cm.SetReadOnly(true)
+	t.Cleanup(cm.Close)
+
+	var runs []string
+	listener := NewListener(cm, nil, func(origin activity.Origin, path string, actions []configuration.Action, pressed bool) {
+		if origin != activity.Hotkey() {
+			t.Errorf("%s ran with origin %+v", path, origin)
+		}
+		runs = append(runs, fmt.Sprintf("%s %s %v", path, actions[0].Type, pressed))
+	})
+	keyboard := newKeyboard(listener, "test")
+
+	const leftMeta, rightCtrl, leftShift, f7, m = 125, 97, 42, 65, 50
+	err := readEvents(input(
+		// Super+F7 with a repeat, released after Super
+		key(leftMeta, keyPressed), key(f7, keyPressed), key(f7, keyRepeated),
+		key(leftMeta, keyReleased), key(f7, keyReleased),
+		// F7 alone and Ctrl+M are not bound
+		key(f7, keyPressed), key(f7, keyReleased),
+		key(rightCtrl, keyPressed), key(m, keyPressed), key(m, keyReleased),
+		// Ctrl+Shift+M stays down until the device goes away
+		key(leftShift, keyPressed), key(m, keyPressed),
+		// Not a key event
+		event{kind: 0x02, code: 0, value: 1},
+	), keyboard.handle)
+	if err == nil {
+		t.Error("reading ended without an error")
+	}
+	keyboard.releaseAll()
+
+	want := []string{
+		"hotkey/Super+F7 MediaPlayPause true",
+		"hotkey/Super+F7 MediaPlayPause false",
+		"hotkey/Ctrl+Shift+M SetDefaultOutput true",
+		"hotkey/Ctrl+Shift+M SetDefaultOutput false",
+	}
+	if !slices.Equal(runs, want) {
+		t.Errorf("ran\n%s\nwant\n%s", strings.Join(runs, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,105 @@
+package hotkeys
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Modifier is a set of modifier keys held down, either side counts
+type Modifier uint8
+
+const (
+	Ctrl Modifier = 1 << iota
+	Shift
+	Alt
+	Super
+)
+
+// Key codes of linux/input-event-codes.h
+var keyCodes = map[string]uint16{
+	"esc": 1, "minus": 12, "equal": 13, "backspace": 14, "tab": 15,
+	"leftbrace": 26, "rightbrace": 27, "enter": 28, "semicolon": 39, "apostrophe": 40,
+	"grave": 41, "backslash": 43, "comma": 51, "dot": 52, "slash": 53, "space": 57,
+	"capslock": 58, "numlock": 69, "scrolllock": 70, "sysrq": 99,
+	"home": 102, "up": 103, "pageup": 104, "left": 105, "right": 106,
+	"end": 107, "down": 108, "pagedown": 109, "insert": 110, "delete": 111,
+	"mute": 113, "volumedown": 114, "volumeup": 115, "pause": 119, "menu": 139,
+	"nextsong": 163, "playpause": 164, "previoussong": 165, "stopcd": 166,
+	"micmute": 248,
+}
+
+// Key codes of the modifiers, left and right
+var modifierCodes = map[uint16]Modifier{
+	29: Ctrl, 97: Ctrl,
+	42: Shift, 54: Shift,
+	56: Alt, 100: Alt,
+	125: Super, 126: Super,
+}
+
+// Names of the modifiers in chords, Mod is the usual window manager modifier
+var modifierNames = map[string]Modifier{
+	"ctrl": Ctrl, "control": Ctrl,
+	"shift": Shift,
+	"alt":   Alt,
+	"super": Super, "meta": Super, "mod": Super, "win": Super, "logo": Super,
+}
+
+func init() {
+	for i, letter := range "qwertyuiop" {
+		keyCodes[string(letter)] = uint16(16 + i)
+	}
+	for i, letter := range "asdfghjkl" {
+		keyCodes[string(letter)] = uint16(30 + i)
+	}
+	for i, letter := range "zxcvbnm" {
+		keyCodes[string(letter)] = uint16(44 + i)
+	}
+	for digit := 1; digit <= 9; digit++ {
+		keyCodes[strconv.Itoa(digit)] = uint16(1 + digit)
+	}
+	keyCodes["0"] = 11
+	for f := 1; f <= 10; f++ {
+		keyCodes["f"+strconv.Itoa(f)] = uint16(58 + f)
+	}
+	keyCodes["f11"], keyCodes["f12"] = 87, 88
+	for f := 13; f <= 24; f++ {
+		keyCodes["f"+strconv.Itoa(f)] = uint16(170 + f)
+	}
+}
+
+// Chord is a key pressed while exactly the modifiers are held
+type Chord struct {
+	Modifiers Modifier
+	Key       uint16
+}
+
+// ParseChord parses keys like Super+F7, Ctrl+Alt+M or VolumeUp. Names are
+// those of linux/input-event-codes.h without KEY_, in any case; Mod is Super.
+func ParseChord(keys string) (Chord, error) {
+	var chord Chord
+	parts := strings.Split(keys, "+")
+	for i, part := range parts {
+		name := strings.ToLower(strings.TrimSpace(part))
+		if name == "" {
+			return Chord{}, fmt.Errorf("empty key in %q", keys)
+		}
+		if i < len(parts)-1 {
+			modifier, ok := modifierNames[name]
+			if !ok {
+				return Chord{}, fmt.Errorf("%s is not a modifier, use Ctrl, Shift, Alt or Super", part)
+			}
+			chord.Modifiers |= modifier
+			continue
+		}
+		code, ok := keyCodes[name]
+		if !ok {
+			if _, isModifier := modifierNames[name]; isModifier {
+				return Chord{}, fmt.Errorf("%q has no key besides the modifiers", keys)
+			}
+			return Chord{}, fmt.Errorf("unknown key %s", part)
+		}
+		chord.Key = code
+	}
+	return chord, nil
+}
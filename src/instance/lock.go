@@ -0,0 +1,150 @@
+// Package instance keeps a user from running two pulsekontrol processes,
+// which would fight over the MIDI ports and the configuration file.
+package instance
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// pollInterval is how often Takeover checks whether the lock is free
+const pollInterval = 100 * time.Millisecond
+
+// RunningError is returned when another live instance holds the lock
+type RunningError struct {
+	Path string
+	PID  int // 0 if unknown
+}
+
+func (e *RunningError) Error() string {
+	if e.PID > 0 {
+		return fmt.Sprintf("pulsekontrol is already running (pid %d, lock %s)", e.PID, e.Path)
+	}
+	return fmt.Sprintf("pulsekontrol is already running (lock %s)", e.Path)
+}
+
+// Lock is the runtime lock held while pulsekontrol runs
+type Lock struct {
+	file     *os.File
+	flocked  bool // False on file systems without flock
+	StalePID int  // Recorded PID of a crashed instance whose lock was reclaimed, 0 if none
+}
+
+// Path returns the lock file: pulsekontrol.lock in $XDG_RUNTIME_DIR, or a
+// file of the user in the temporary directory without it
+func Path() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "pulsekontrol.lock")
+	}
+	return filepath.Join(os.TempDir(), "pulsekontrol-"+strconv.Itoa(os.Getuid())+".lock")
+}
+
+// Acquire takes the lock at path and records the PID of this process in it.
+// The kernel releases the lock of a process that dies; on file systems
+// without flock, a lock is only honored while its recorded PID is alive.
+func Acquire(path string) (*Lock, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open lock file: %w", err)
+	}
+	recorded := readPID(file)
+
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	flocked := err == nil
+	switch {
+	case err == nil:
+	case errors.Is(err, syscall.EWOULDBLOCK):
+		file.Close()
+		if !alive(recorded) {
+			recorded = 0
+		}
+		return nil, &RunningError{Path: path, PID: recorded}
+	case errors.Is(err, syscall.ENOLCK), errors.Is(err, syscall.EOPNOTSUPP), errors.Is(err, syscall.EINVAL):
+		// No flock here, the recorded PID is all there is
+		if recorded != os.Getpid() && alive(recorded) {
+			file.Close()
+			return nil, &RunningError{Path: path, PID: recorded}
+		}
+	default:
+		file.Close()
+		return nil, fmt.Errorf("could not lock %s: %w", path, err)
+	}
+
+	lock := &Lock{file: file, flocked: flocked}
+	if recorded > 0 && recorded != os.Getpid() && !alive(recorded) {
+		lock.StalePID = recorded
+	}
+	if err := file.Truncate(0); err == nil {
+		file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return lock, nil
+}
+
+// Takeover takes the lock at path, asking a running instance to terminate
+// first and waiting up to timeout for it to release the lock
+func Takeover(path string, timeout time.Duration) (*Lock, error) {
+	lock, err := Acquire(path)
+	var running *RunningError
+	if !errors.As(err, &running) {
+		return lock, err
+	}
+	if running.PID == 0 {
+		return nil, fmt.Errorf("%w, its pid is unknown so it can't be terminated", err)
+	}
+	if err := syscall.Kill(running.PID, syscall.SIGTERM); err != nil && !errors.Is(err, syscall.ESRCH) {
+		return nil, fmt.Errorf("could not terminate pid %d: %w", running.PID, err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(pollInterval)
+		lock, err = Acquire(path)
+		if !errors.As(err, &running) {
+			return lock, err
+		}
+	}
+	return nil, fmt.Errorf("pid %d did not exit within %s", running.PID, timeout)
+}
+
+// Release releases the lock. The file is left in place, removing it could
+// race with another instance that is just taking the lock.
+func (lock *Lock) Release() error {
+	if lock == nil || lock.file == nil {
+		return nil
+	}
+	lock.file.Truncate(0)
+	var err error
+	if lock.flocked {
+		err = syscall.Flock(int(lock.file.Fd()), syscall.LOCK_UN)
+	}
+	lock.file.Close()
+	lock.file = nil
+	return err
+}
+
+// readPID reads the PID recorded in a lock file, 0 if there is none
+func readPID(file *os.File) int {
+	data := make([]byte, 32)
+	n, _ := file.ReadAt(data, 0)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data[:n])))
+	if err != nil || pid <= 0 {
+		return 0
+	}
+	return pid
+}
+
+// alive reports whether a process exists. EPERM means it exists but belongs
+// to another user.
+func alive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
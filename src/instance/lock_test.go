@@ -0,0 +1,268 @@
+package instance
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// holderEnv names the lock file a helper process holds, see TestLockHolder
+const holderEnv = "PULSEKONTROL_TEST_LOCK_HOLDER"
+
+// TestLockHolder is not a test but another instance for the tests: it takes
+// the lock and holds it until it is terminated, or forever if it ignores
+// SIGTERM.
+func TestLockHolder(t *testing.T) {
+	path := os.Getenv(holderEnv)
+	if path == "" {
+		t.Skip("helper process")
+	}
+	if os.Getenv(holderEnv+"_IGNORE_TERM") != "" {
+		signal.Ignore(syscall.SIGTERM)
+	}
+	lock, err := Acquire(path)
+	if err != nil {
+		os.Exit(1)
+	}
+	// Sleeping, as a process blocked for good is stopped as deadlocked
+	time.Sleep(time.Minute)
+	lock.Release()
+}
+
+// startHolder runs another process holding the lock at path and waits until
+// it took it. It returns its PID and a channel closed once it exited.
+func startHolder(t *testing.T, path string, ignoreTerm bool) (int, <-chan struct{}) {
+	t.Helper()
+	holder := exec.Command(os.Args[0], "-test.run=^TestLockHolder$")
+	holder.Env = append(os.Environ(), holderEnv+"="+path)
+	if ignoreTerm {
+		holder.Env = append(holder.Env, holderEnv+"_IGNORE_TERM=1")
+	}
+	if err := holder.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		holder.Wait()
+		close(exited)
+	}()
+	t.Cleanup(func() {
+		holder.Process.Kill()
+		<-exited
+	})
+
+	want := strconv.Itoa(holder.Process.Pid) + "\n"
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if data, _ := os.ReadFile(path); string(data) == want {
+			return holder.Process.Pid, exited
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("helper process did not take the lock")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// deadPID returns the PID of a process that exited
+func deadPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("cannot run a process: %v", err)
+	}
+	return cmd.Process.Pid
+}
+
+// recordedPID returns the PID in the lock file, 0 if there is none
+func recordedPID(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) == 0 {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatalf("lock file contains %q", data)
+	}
+	return pid
+}
+
+func TestPath(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+	if path := Path(); path != "/run/user/1000/pulsekontrol.lock" {
+		t.Errorf("lock at %s", path)
+	}
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	if path := Path(); filepath.Dir(path) != filepath.Clean(os.TempDir()) || !strings.Contains(filepath.Base(path), strconv.Itoa(os.Getuid())) {
+		t.Errorf("lock at %s without a runtime directory", path)
+	}
+}
+
+func TestAcquireFreshLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pulsekontrol.lock")
+	lock, err := Acquire(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock.StalePID != 0 {
+		t.Errorf("fresh lock reclaimed from pid %d", lock.StalePID)
+	}
+	if pid := recordedPID(t, path); pid != os.Getpid() {
+		t.Errorf("lock records pid %d, want %d", pid, os.Getpid())
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("lock file has mode %v: %v", info.Mode().Perm(), err)
+	}
+
+	if err := lock.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if pid := recordedPID(t, path); pid != 0 {
+		t.Errorf("released lock records pid %d", pid)
+	}
+	// Releasing twice does nothing
+	if err := lock.Release(); err != nil {
+		t.Error(err)
+	}
+	lock, err = Acquire(path)
+	if err != nil {
+		t.Fatalf("released lock not taken again: %v", err)
+	}
+	lock.Release()
+
+	if _, err := Acquire(filepath.Join(t.TempDir(), "missing", "pulsekontrol.lock")); err == nil {
+		t.Error("lock taken in a missing directory")
+	}
+}
+
+func TestAcquireContendedLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pulsekontrol.lock")
+	holder, _ := startHolder(t, path, false)
+
+	_, err := Acquire(path)
+	var running *RunningError
+	if !errors.As(err, &running) {
+		t.Fatalf("contended lock returned %v", err)
+	}
+	if running.PID != holder || running.Path != path {
+		t.Errorf("running instance reported as %+v, want pid %d", running, holder)
+	}
+	if !strings.Contains(err.Error(), "pid "+strconv.Itoa(holder)) {
+		t.Errorf("message %q does not name the pid", err)
+	}
+	// The attempt leaves the lock file of the running instance alone
+	if pid := recordedPID(t, path); pid != holder {
+		t.Errorf("lock records pid %d after a failed attempt", pid)
+	}
+
+	// Within the process a second lock is refused too
+	path = filepath.Join(t.TempDir(), "pulsekontrol.lock")
+	lock, err := Acquire(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Release()
+	if _, err := Acquire(path); !errors.As(err, &running) || running.PID != os.Getpid() {
+		t.Errorf("second lock returned %v", err)
+	}
+}
+
+func TestAcquireStaleLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pulsekontrol.lock")
+	stale := deadPID(t)
+	if err := os.WriteFile(path, []byte(strconv.Itoa(stale)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := Acquire(path)
+	if err != nil {
+		t.Fatalf("stale lock not reclaimed: %v", err)
+	}
+	defer lock.Release()
+	if lock.StalePID != stale {
+		t.Errorf("reclaimed from pid %d, want %d", lock.StalePID, stale)
+	}
+	if pid := recordedPID(t, path); pid != os.Getpid() {
+		t.Errorf("reclaimed lock records pid %d", pid)
+	}
+
+	// A dead PID in a file that is locked is not reported
+	other := filepath.Join(t.TempDir(), "pulsekontrol.lock")
+	held, err := Acquire(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Release()
+	if err := os.WriteFile(other, []byte(strconv.Itoa(stale)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var running *RunningError
+	if _, err := Acquire(other); !errors.As(err, &running) || running.PID != 0 {
+		t.Errorf("lock held with a dead pid returned %v", err)
+	} else if strings.Contains(err.Error(), "pid") {
+		t.Errorf("message %q names a pid", err)
+	}
+}
+
+func TestTakeover(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pulsekontrol.lock")
+	_, exited := startHolder(t, path, false)
+
+	lock, err := Takeover(path, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Release()
+	if pid := recordedPID(t, path); pid != os.Getpid() {
+		t.Errorf("lock records pid %d after the takeover", pid)
+	}
+	select {
+	case <-exited:
+	case <-time.After(time.Second):
+		t.Error("instance still running after the takeover")
+	}
+
+	// Without a running instance it is a plain Acquire
+	free := filepath.Join(t.TempDir(), "pulsekontrol.lock")
+	lock, err = Takeover(free, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock.Release()
+}
+
+func TestTakeoverFails(t *testing.T) {
+	// An instance that doesn't exit in time keeps its lock
+	path := filepath.Join(t.TempDir(), "pulsekontrol.lock")
+	holder, _ := startHolder(t, path, true)
+	if _, err := Takeover(path, 300*time.Millisecond); err == nil || !strings.Contains(err.Error(), "did not exit") {
+		t.Errorf("takeover of an instance ignoring SIGTERM returned %v", err)
+	}
+	if pid := recordedPID(t, path); pid != holder {
+		t.Errorf("lock records pid %d after a failed takeover", pid)
+	}
+
+	// Without a PID there is nobody to terminate
+	path = filepath.Join(t.TempDir(), "pulsekontrol.lock")
+	held, err := Acquire(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Release()
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Takeover(path, time.Second); err == nil || !strings.Contains(err.Error(), "pid is unknown") {
+		t.Errorf("takeover without a pid returned %v", err)
+	}
+}
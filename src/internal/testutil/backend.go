@@ -0,0 +1,741 @@
+package testutil
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/0h41/pulsekontrol/src/pulseaudio"
+)
+
+// FakeStream is a stream or device of the fake audio system
+type FakeStream struct {
+	Type       configuration.PulseAudioTargetType
+	Name       string
+	BinaryName string
+	Volume     float32
+	Muted      bool
+	Sink       string  // Output device a playback stream plays on
+	Balance    float64 // From -1 (left) to 1 (right)
+	Combined   bool    // Output device added by SyncCombinedSinks
+	Virtual    bool    // Output device added by SyncVirtualSinks
+	Suspended  bool    // Output device suspended by SetOutputSuspended
+	Corked     bool    // Stream paused by its application
+	ProcessID  int
+	Cgroup     string // Full cgroup path of the process
+}
+
+func (stream FakeStream) stream() pulseaudio.Stream {
+	return pulseaudio.Stream{Name: stream.Name, FullName: stream.Name, BinaryName: stream.BinaryName, ProcessID: stream.ProcessID}
+}
+
+// VolumeChange is a volume the fake audio system was asked to set
+type VolumeChange struct {
+	Target configuration.TypedTarget
+	Volume float32
+	At     time.Time // When the volume was set
+}
+
+// FakeBackend is an audio system that keeps its streams in memory and
+// records the changes it is asked for, instead of talking to PulseAudio
+type FakeBackend struct {
+	mu            sync.Mutex
+	streams       []FakeStream
+	changes       []VolumeChange
+	defaultOutput string
+	defaultInput  string
+	cards         []pulseaudio.Card
+	excludes      []configuration.ExcludeRule
+	loopbacks     map[[2]string]bool // Input and output devices playing on each other
+	echoCancels   map[[2]string]bool // Input and output devices whose echo is cancelled
+	playing       bool
+	dryRun        bool
+	eventHandler  pulseaudio.EventHandler
+	newStream     pulseaudio.StreamEventCallback
+	removedStream pulseaudio.StreamEventCallback
+	mediaStatus   pulseaudio.MediaStatusCallback
+	sourceSeen    pulseaudio.SourceSeenCallback
+	monitoring    bool
+	pingErr       error
+	refreshes     int
+	queries       int           // Calls of GetAudioSources
+	timeout       time.Duration // How long changes wait while hung, see SetHung
+	hung          bool
+}
+
+var _ pulseaudio.Backend = (*FakeBackend)(nil)
+
+// NewFakeBackend returns an audio system with the given streams and devices
+func NewFakeBackend(streams ...FakeStream) *FakeBackend {
+	return &FakeBackend{streams: streams}
+}
+
+// matches reports whether a stream is the one a target names, like
+// configured sources without a binary name match any binary
+func matches(stream FakeStream, target *configuration.TypedTarget) bool {
+	return stream.Type == target.Type && stream.Name == target.Name &&
+		(target.BinaryName == "" || stream.BinaryName == target.BinaryName) &&
+		(target.ProcessID == 0 || stream.ProcessID == target.ProcessID) &&
+		(target.Cgroup == "" || stream.Cgroup == target.Cgroup)
+}
+
+func (b *FakeBackend) Ping() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.pingErr
+}
+
+// Refresh fails like Ping, the streams are always up to date
+func (b *FakeBackend) Refresh() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refreshes++
+	return b.pingErr
+}
+
+// Refreshes returns how often Refresh was called
+func (b *FakeBackend) Refreshes() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.refreshes
+}
+
+// Queries returns how often the audio sources were listed
+func (b *FakeBackend) Queries() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.queries
+}
+
+// SetTimeout sets how long changes wait while hung
+func (b *FakeBackend) SetTimeout(timeout time.Duration, reconnectAfter int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.timeout = timeout
+}
+
+// SetHung makes changes fail like those of PAClient while PulseAudio does
+// not answer: after the timeout, with a TimeoutError
+func (b *FakeBackend) SetHung(hung bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.hung = hung
+}
+
+// hang waits the timeout and returns its error while hung
+func (b *FakeBackend) hang(operation string, target string) error {
+	b.mu.Lock()
+	hung, timeout := b.hung, b.timeout
+	b.mu.Unlock()
+	if !hung {
+		return nil
+	}
+	time.Sleep(timeout)
+	return &pulseaudio.TimeoutError{Operation: operation, Target: target, Timeout: timeout}
+}
+
+// SetPingError makes Ping fail with err, or succeed again with nil, as if
+// the audio system went away and came back
+func (b *FakeBackend) SetPingError(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pingErr = err
+}
+
+func (b *FakeBackend) SetDryRun(dryRun bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.dryRun = dryRun
+}
+
+func (b *FakeBackend) DryRun() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.dryRun
+}
+
+func (b *FakeBackend) GetAudioSources() []pulseaudio.AudioSource {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.queries++
+	sources := []pulseaudio.AudioSource{}
+	for _, stream := range b.visibleStreams() {
+		sources = append(sources, pulseaudio.AudioSource{
+			ID:         string(stream.Type) + ":" + stream.Name,
+			Name:       stream.Name,
+			RawName:    stream.Name,
+			BinaryName: stream.BinaryName,
+			Type:       string(stream.Type),
+			Volume:     int(stream.Volume * 100),
+			Muted:      stream.Muted,
+			Default: (stream.Type == configuration.OutputDevice && stream.Name == b.defaultOutput) ||
+				(stream.Type == configuration.InputDevice && stream.Name == b.defaultInput),
+			Suspended: stream.Suspended,
+			Corked:    stream.Corked,
+		})
+	}
+	return sources
+}
+
+func (b *FakeBackend) SetMeterInterval(interval time.Duration) {}
+
+func (b *FakeBackend) SetExcludes(rules []configuration.ExcludeRule) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.excludes = rules
+}
+
+// visibleStreams returns the streams not left out by the excludes. Must be
+// called with mu held.
+func (b *FakeBackend) visibleStreams() []FakeStream {
+	return slices.DeleteFunc(slices.Clone(b.streams), func(stream FakeStream) bool {
+		return configuration.Excluded(b.excludes, stream.Type, stream.Name, stream.BinaryName, nil)
+	})
+}
+
+func (b *FakeBackend) Meter(target *configuration.TypedTarget, callback pulseaudio.LevelCallback) (func(), error) {
+	return nil, fmt.Errorf("the fake audio system has no levels")
+}
+
+func (b *FakeBackend) GetFocusedWindowPlaybackStreams() ([]pulseaudio.Stream, error) {
+	return nil, fmt.Errorf("the fake audio system has no focused window")
+}
+
+func (b *FakeBackend) SmartMatchStreams(sourceType configuration.PulseAudioTargetType, sourceName string) ([]pulseaudio.Stream, *pulseaudio.Stream) {
+	return b.MatchTarget(&configuration.TypedTarget{Type: sourceType, Name: sourceName}), nil
+}
+
+func (b *FakeBackend) SelectTargets(selector pulseaudio.Selector) ([]configuration.TypedTarget, error) {
+	if selector.Mode != "" && selector.Mode != pulseaudio.MatchExact {
+		return nil, fmt.Errorf("the fake audio system only selects exact names")
+	}
+	return []configuration.TypedTarget{{Type: selector.Type, Name: selector.Name, BinaryName: selector.BinaryName}}, nil
+}
+
+func (b *FakeBackend) MatchTarget(target *configuration.TypedTarget) []pulseaudio.Stream {
+	b.mu.Lock()
+	var streams []pulseaudio.Stream
+	for _, stream := range b.visibleStreams() {
+		if matches(stream, target) {
+			streams = append(streams, stream.stream())
+		}
+	}
+	b.mu.Unlock()
+	b.seen(target, len(streams) > 0)
+	return streams
+}
+
+func (b *FakeBackend) CachedStreams() []pulseaudio.CachedStream {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var streams []pulseaudio.CachedStream
+	for _, stream := range b.visibleStreams() {
+		streams = append(streams, pulseaudio.CachedStream{
+			Type:       stream.Type,
+			ID:         stream.Name,
+			Name:       stream.Name,
+			BinaryName: stream.BinaryName,
+			Volume:     stream.Volume,
+			Muted:      stream.Muted,
+			Suspended:  stream.Suspended,
+			Corked:     stream.Corked,
+			Default: (stream.Type == configuration.OutputDevice && stream.Name == b.defaultOutput) ||
+				(stream.Type == configuration.InputDevice && stream.Name == b.defaultInput),
+		})
+	}
+	return streams
+}
+
+// seen calls the source seen callback if a target matched, like PulseAudio
+// does for each request
+func (b *FakeBackend) seen(target *configuration.TypedTarget, matched bool) {
+	b.mu.Lock()
+	callback := b.sourceSeen
+	b.mu.Unlock()
+	if matched && callback != nil {
+		callback(*target)
+	}
+}
+
+// ProcessVolumeAction records the volume and sets it on the matching streams
+func (b *FakeBackend) ProcessVolumeAction(action configuration.Action, volumePercent float32) error {
+	target, ok := action.Target.(*configuration.TypedTarget)
+	if !ok {
+		return nil
+	}
+	if err := b.hang("SetVolume", target.Name); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.changes = append(b.changes, VolumeChange{Target: *target, Volume: volumePercent, At: time.Now()})
+	matched := false
+	for i := range b.streams {
+		if matches(b.streams[i], target) {
+			matched = true
+			if !b.dryRun {
+				b.streams[i].Volume = volumePercent
+			}
+		}
+	}
+	b.mu.Unlock()
+	b.seen(target, matched)
+	return nil
+}
+
+func (b *FakeBackend) GetTargetVolume(target *configuration.TypedTarget) (float32, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, stream := range b.streams {
+		if matches(stream, target) {
+			return stream.Volume, true
+		}
+	}
+	return 0, false
+}
+
+func (b *FakeBackend) SetTargetMute(target *configuration.TypedTarget, muted bool) error {
+	if err := b.hang("SetMute", target.Name); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.dryRun {
+		return nil
+	}
+	for i := range b.streams {
+		if matches(b.streams[i], target) {
+			b.streams[i].Muted = muted
+		}
+	}
+	return nil
+}
+
+func (b *FakeBackend) SetOutputSuspended(name string, suspended bool) error {
+	if err := b.hang("SetOutputSuspended", name); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	index := slices.IndexFunc(b.streams, func(stream FakeStream) bool {
+		return stream.Type == configuration.OutputDevice && stream.Name == name
+	})
+	if index < 0 {
+		return fmt.Errorf("no output device %s", name)
+	}
+	if !b.dryRun {
+		b.streams[index].Suspended = suspended
+	}
+	return nil
+}
+
+func (b *FakeBackend) SetTargetBalance(target *configuration.TypedTarget, balance float64) error {
+	if err := b.hang("SetBalance", target.Name); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.dryRun {
+		return nil
+	}
+	for i := range b.streams {
+		if matches(b.streams[i], target) {
+			b.streams[i].Balance = balance
+		}
+	}
+	return nil
+}
+
+func (b *FakeBackend) MoveStreams(target *configuration.TypedTarget, sink string) error {
+	if err := b.hang("MoveStream", target.Name); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !slices.ContainsFunc(b.streams, func(stream FakeStream) bool {
+		return stream.Type == configuration.OutputDevice && stream.Name == sink
+	}) {
+		return fmt.Errorf("no output device %s", sink)
+	}
+	if b.dryRun {
+		return nil
+	}
+	moved := false
+	for i := range b.streams {
+		if matches(b.streams[i], target) {
+			b.streams[i].Sink = sink
+			moved = true
+		}
+	}
+	if !moved {
+		return fmt.Errorf("no playback stream matches %s", target.Name)
+	}
+	return nil
+}
+
+func (b *FakeBackend) SetDefaultOutput(action configuration.Action) error {
+	if target, ok := action.Target.(*configuration.Target); ok && !b.DryRun() {
+		if err := b.hang("SetDefaultSink", target.Name); err != nil {
+			return err
+		}
+		b.mu.Lock()
+		b.defaultOutput = target.Name
+		b.mu.Unlock()
+	}
+	return nil
+}
+
+func (b *FakeBackend) SetDefaultInput(action configuration.Action) error {
+	if target, ok := action.Target.(*configuration.Target); ok && !b.DryRun() {
+		if err := b.hang("SetDefaultSource", target.Name); err != nil {
+			return err
+		}
+		b.mu.Lock()
+		b.defaultInput = target.Name
+		b.mu.Unlock()
+	}
+	return nil
+}
+
+func (b *FakeBackend) GetCards() ([]pulseaudio.Card, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.cards), nil
+}
+
+func (b *FakeBackend) SetCardProfile(card string, profile string) error {
+	if err := b.hang("SetCardProfile", card); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	index := slices.IndexFunc(b.cards, func(c pulseaudio.Card) bool {
+		return c.Matches(card)
+	})
+	if index < 0 {
+		return fmt.Errorf("no card %s", card)
+	}
+	if _, ok := b.cards[index].Profile(profile); !ok {
+		return fmt.Errorf("card %s has no profile %s", card, profile)
+	}
+	if !b.dryRun {
+		b.cards[index].ActiveProfile = profile
+	}
+	return nil
+}
+
+// loopbackDevices fails unless the input and output device are present
+func (b *FakeBackend) loopbackDevices(source string, sink string) error {
+	if !slices.ContainsFunc(b.streams, func(stream FakeStream) bool {
+		return stream.Type == configuration.InputDevice && stream.Name == source
+	}) {
+		return fmt.Errorf("no input device %s", source)
+	}
+	if !slices.ContainsFunc(b.streams, func(stream FakeStream) bool {
+		return stream.Type == configuration.OutputDevice && stream.Name == sink
+	}) {
+		return fmt.Errorf("no output device %s", sink)
+	}
+	return nil
+}
+
+func (b *FakeBackend) LoopbackLoaded(source string, sink string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.loopbackDevices(source, sink); err != nil {
+		return false, err
+	}
+	return b.loopbacks[[2]string{source, sink}], nil
+}
+
+func (b *FakeBackend) LoadLoopback(source string, sink string, latency time.Duration) error {
+	if err := b.hang("LoadLoopback", source); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.loopbackDevices(source, sink); err != nil || b.dryRun {
+		return err
+	}
+	if b.loopbacks == nil {
+		b.loopbacks = make(map[[2]string]bool)
+	}
+	b.loopbacks[[2]string{source, sink}] = true
+	return nil
+}
+
+func (b *FakeBackend) UnloadLoopback(source string, sink string) error {
+	if err := b.hang("UnloadLoopback", source); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.loopbackDevices(source, sink); err != nil || b.dryRun {
+		return err
+	}
+	delete(b.loopbacks, [2]string{source, sink})
+	return nil
+}
+
+func (b *FakeBackend) EchoCancelLoaded(source string, sink string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.loopbackDevices(source, sink); err != nil {
+		return false, err
+	}
+	return b.echoCancels[[2]string{source, sink}], nil
+}
+
+// LoadEchoCancel adds an input device named like the input device with
+// " (echo cancelled)" appended
+func (b *FakeBackend) LoadEchoCancel(source string, sink string, setDefault bool) error {
+	if err := b.hang("LoadEchoCancel", source); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.loopbackDevices(source, sink); err != nil || b.dryRun || b.echoCancels[[2]string{source, sink}] {
+		return err
+	}
+	if b.echoCancels == nil {
+		b.echoCancels = make(map[[2]string]bool)
+	}
+	b.echoCancels[[2]string{source, sink}] = true
+	b.streams = append(b.streams, FakeStream{Type: configuration.InputDevice, Name: source + " (echo cancelled)", Volume: 1})
+	if setDefault {
+		b.defaultInput = source + " (echo cancelled)"
+	}
+	return nil
+}
+
+func (b *FakeBackend) UnloadEchoCancel(source string, sink string, setDefault bool) error {
+	if err := b.hang("UnloadEchoCancel", source); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.loopbackDevices(source, sink); err != nil || b.dryRun || !b.echoCancels[[2]string{source, sink}] {
+		return err
+	}
+	delete(b.echoCancels, [2]string{source, sink})
+	b.streams = slices.DeleteFunc(b.streams, func(stream FakeStream) bool {
+		return stream.Type == configuration.InputDevice && stream.Name == source+" (echo cancelled)"
+	})
+	if setDefault {
+		b.defaultInput = source
+	}
+	return nil
+}
+
+// SyncCombinedSinks adds an output device for each combined sink with an
+// output device that is present, and removes the others it added
+func (b *FakeBackend) SyncCombinedSinks(sinks map[string]configuration.CombinedSinkConfig) error {
+	if err := b.hang("SyncCombinedSinks", ""); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.dryRun {
+		return nil
+	}
+	b.streams = slices.DeleteFunc(b.streams, func(stream FakeStream) bool {
+		return stream.Combined
+	})
+	for name, sink := range sinks {
+		if slices.ContainsFunc(b.streams, func(stream FakeStream) bool {
+			return stream.Type == configuration.OutputDevice && slices.Contains(sink.Outputs, stream.Name)
+		}) {
+			b.streams = append(b.streams, FakeStream{Type: configuration.OutputDevice, Name: name, Volume: 1, Combined: true})
+		}
+	}
+	return nil
+}
+
+// SyncVirtualSinks adds an output device for each name that is missing and
+// removes the others it added
+func (b *FakeBackend) SyncVirtualSinks(names []string) ([]string, error) {
+	if err := b.hang("SyncVirtualSinks", ""); err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.dryRun {
+		return nil, nil
+	}
+	b.streams = slices.DeleteFunc(b.streams, func(stream FakeStream) bool {
+		return stream.Virtual && !slices.Contains(names, stream.Name)
+	})
+	var created []string
+	for _, name := range names {
+		if !slices.ContainsFunc(b.streams, func(stream FakeStream) bool { return stream.Virtual && stream.Name == name }) {
+			b.streams = append(b.streams, FakeStream{Type: configuration.OutputDevice, Name: name, Volume: 1, Virtual: true})
+			created = append(created, name)
+		}
+	}
+	return created, nil
+}
+
+// ProcessMediaControlAction toggles whether media plays
+func (b *FakeBackend) ProcessMediaControlAction(action configuration.Action) error {
+	if action.Type != configuration.MediaPlayPause {
+		return fmt.Errorf("unsupported media control action: %s", action.Type)
+	}
+	b.mu.Lock()
+	b.playing = !b.playing
+	playing, callback := b.playing, b.mediaStatus
+	b.mu.Unlock()
+	if callback != nil {
+		callback(playing)
+	}
+	return nil
+}
+
+func (b *FakeBackend) IsMediaPlaying() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.playing
+}
+
+func (b *FakeBackend) SetNewStreamCallback(callback pulseaudio.StreamEventCallback) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.newStream = callback
+}
+
+func (b *FakeBackend) SetRemovedStreamCallback(callback pulseaudio.StreamEventCallback) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.removedStream = callback
+}
+
+func (b *FakeBackend) SetMediaStatusCallback(callback pulseaudio.MediaStatusCallback) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.mediaStatus = callback
+}
+
+func (b *FakeBackend) SetSourceSeenCallback(callback pulseaudio.SourceSeenCallback) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sourceSeen = callback
+}
+
+func (b *FakeBackend) SetEventHandler(handler pulseaudio.EventHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.eventHandler = handler
+}
+
+func (b *FakeBackend) StartStreamMonitoring() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.monitoring = true
+	return nil
+}
+
+func (b *FakeBackend) StopStreamMonitoring() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.monitoring = false
+}
+
+func (b *FakeBackend) Monitoring() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.monitoring
+}
+
+func (b *FakeBackend) StartMediaStatusMonitoring() error {
+	return nil
+}
+
+// AddStream adds a stream and reports it like PulseAudio does while the
+// streams are monitored
+func (b *FakeBackend) AddStream(stream FakeStream) {
+	b.mu.Lock()
+	b.streams = append(b.streams, stream)
+	monitoring, newStream, handler := b.monitoring, b.newStream, b.eventHandler
+	b.mu.Unlock()
+	if !monitoring {
+		return
+	}
+	if newStream != nil {
+		newStream(stream.stream(), stream.Type)
+	}
+	if handler != nil {
+		handler("source.added", pulseaudio.StreamEvent(stream.stream(), stream.Type))
+		handler("sources.changed", map[string]interface{}{})
+	}
+}
+
+// RemoveStream removes the streams with a name and reports them
+func (b *FakeBackend) RemoveStream(streamType configuration.PulseAudioTargetType, name string) {
+	b.mu.Lock()
+	var removed []FakeStream
+	kept := b.streams[:0]
+	for _, stream := range b.streams {
+		if stream.Type == streamType && stream.Name == name {
+			removed = append(removed, stream)
+			continue
+		}
+		kept = append(kept, stream)
+	}
+	b.streams = kept
+	monitoring, removedStream, handler := b.monitoring, b.removedStream, b.eventHandler
+	b.mu.Unlock()
+	if !monitoring {
+		return
+	}
+	for _, stream := range removed {
+		if removedStream != nil {
+			removedStream(stream.stream(), stream.Type)
+		}
+		if handler != nil {
+			handler("source.removed", pulseaudio.StreamEvent(stream.stream(), stream.Type))
+		}
+	}
+	if handler != nil && len(removed) > 0 {
+		handler("sources.changed", map[string]interface{}{})
+	}
+}
+
+// Stream returns a stream or device by name, to see its volume and muted state
+func (b *FakeBackend) Stream(streamType configuration.PulseAudioTargetType, name string) (FakeStream, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, stream := range b.streams {
+		if stream.Type == streamType && stream.Name == name {
+			return stream, true
+		}
+	}
+	return FakeStream{}, false
+}
+
+// VolumeChanges returns the volumes the audio system was asked to set so far
+func (b *FakeBackend) VolumeChanges() []VolumeChange {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]VolumeChange(nil), b.changes...)
+}
+
+// DefaultOutput returns the name of the default output device
+func (b *FakeBackend) DefaultOutput() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.defaultOutput
+}
+
+// DefaultInput returns the name of the default input device
+func (b *FakeBackend) DefaultInput() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.defaultInput
+}
+
+// SetCards replaces the sound cards of the fake audio system
+func (b *FakeBackend) SetCards(cards ...pulseaudio.Card) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.cards = cards
+}
@@ -0,0 +1,69 @@
+// Package testutil has fakes of the audio system, the MIDI ports and the
+// clock, for running pulsekontrol in tests without PulseAudio or a device.
+// They are passed through the Options of the app.
+package testutil
+
+import (
+	"sync"
+	"time"
+)
+
+// FakeClock is a clock that only moves when Advance is called
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+type waiter struct {
+	at      time.Time
+	channel chan time.Time
+}
+
+// NewFakeClock returns a clock standing at start
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After sends the time once the clock was advanced by d
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	channel := make(chan time.Time, 1)
+	if d <= 0 {
+		channel <- c.now
+		return channel
+	}
+	c.waiters = append(c.waiters, waiter{at: c.now.Add(d), channel: channel})
+	return channel
+}
+
+// Advance moves the clock forward and wakes the waiters whose time came
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	waiting := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiting = append(waiting, w)
+			continue
+		}
+		w.channel <- c.now
+	}
+	c.waiters = waiting
+}
+
+// Waiters returns how many calls of After wait for the clock, so a test
+// can advance it once a loop is waiting
+func (c *FakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
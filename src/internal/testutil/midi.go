@@ -0,0 +1,191 @@
+package testutil
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"gitlab.com/gomidi/midi/v2/drivers"
+)
+
+// ErrNoDevice is returned by FakeDriver.Open while the device is unplugged
+var ErrNoDevice = errors.New("fake MIDI device is not plugged in")
+
+// FakeDriver opens fake ports instead of those of the MIDI system. It fits
+// the Driver of the midi package, so it can be passed as the Midi option.
+type FakeDriver struct {
+	mu      sync.Mutex
+	plugged bool
+	in      *FakeIn
+	out     *FakeOut
+	opened  chan struct{}
+}
+
+// NewFakeDriver returns a driver whose device is plugged in or not
+func NewFakeDriver(plugged bool) *FakeDriver {
+	return &FakeDriver{
+		plugged: plugged,
+		in:      &FakeIn{},
+		out:     &FakeOut{},
+		opened:  make(chan struct{}),
+	}
+}
+
+// Open opens the fake ports, or fails while the device is unplugged. Like
+// the system driver it returns what closes them.
+func (d *FakeDriver) Open(device configuration.MidiDevice, changed func(connected bool, err error)) (drivers.In, drivers.Out, func(), error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.plugged {
+		return nil, nil, nil, ErrNoDevice
+	}
+	d.in.Open()
+	d.out.Open()
+	select {
+	case <-d.opened:
+	default:
+		close(d.opened)
+	}
+	return d.in, d.out, func() {
+		d.out.Close()
+		d.in.Close()
+	}, nil
+}
+
+// Rescan does nothing, plugging the device is enough for Open to find it
+func (d *FakeDriver) Rescan() error {
+	return nil
+}
+
+// Plug makes the device appear for the next Open
+func (d *FakeDriver) Plug() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.plugged = true
+}
+
+// Opened is closed once the ports were opened
+func (d *FakeDriver) Opened() <-chan struct{} {
+	return d.opened
+}
+
+// In returns the in port, to send messages as if they came from the device
+func (d *FakeDriver) In() *FakeIn {
+	return d.in
+}
+
+// Out returns the out port, to see what was sent to the device
+func (d *FakeDriver) Out() *FakeOut {
+	return d.out
+}
+
+// FakeIn is an in port whose messages come from Send
+type FakeIn struct {
+	mu       sync.Mutex
+	open     bool
+	listener func(msg []byte, milliseconds int32)
+}
+
+func (p *FakeIn) Open() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.open = true
+	return nil
+}
+
+func (p *FakeIn) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.open = false
+	p.listener = nil
+	return nil
+}
+
+func (p *FakeIn) IsOpen() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.open
+}
+
+func (p *FakeIn) Number() int             { return 0 }
+func (p *FakeIn) String() string          { return "Fake MIDI In" }
+func (p *FakeIn) Underlying() interface{} { return nil }
+
+func (p *FakeIn) Listen(onMsg func(msg []byte, milliseconds int32), config drivers.ListenConfig) (func(), error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.listener = onMsg
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.listener = nil
+	}, nil
+}
+
+// Send passes a message to the listener as if the device sent it, and
+// reports whether someone listened
+func (p *FakeIn) Send(message []byte) bool {
+	p.mu.Lock()
+	listener := p.listener
+	p.mu.Unlock()
+	if listener == nil {
+		return false
+	}
+	listener(message, 0)
+	return true
+}
+
+// SendControlChange sends a control change, like a moved fader
+func (p *FakeIn) SendControlChange(channel uint8, controller uint8, value uint8) bool {
+	return p.Send([]byte{0xB0 | channel&0x0F, controller & 0x7F, value & 0x7F})
+}
+
+// FakeOut is an out port that keeps what is sent to it
+type FakeOut struct {
+	mu   sync.Mutex
+	open bool
+	sent [][]byte
+}
+
+func (p *FakeOut) Open() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.open = true
+	return nil
+}
+
+func (p *FakeOut) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.open = false
+	return nil
+}
+
+func (p *FakeOut) IsOpen() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.open
+}
+
+func (p *FakeOut) Number() int             { return 0 }
+func (p *FakeOut) String() string          { return "Fake MIDI Out" }
+func (p *FakeOut) Underlying() interface{} { return nil }
+
+func (p *FakeOut) Send(data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sent = append(p.sent, append([]byte(nil), data...))
+	return nil
+}
+
+// Sent returns the messages sent to the device so far
+func (p *FakeOut) Sent() [][]byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([][]byte(nil), p.sent...)
+}
+
+var (
+	_ drivers.In  = (*FakeIn)(nil)
+	_ drivers.Out = (*FakeOut)(nil)
+)
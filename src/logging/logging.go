@@ -0,0 +1,154 @@
+// Package logging builds the loggers of the modules. Their levels and output
+// can be changed while running, see Configure.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Modules with their own logger, whose level can be set separately
+var Modules = []string{"Actions", "Configuration", "Control", "DBus", "Hooks", "Hotkeys", "Midi", "Notifications", "OSC", "PulseAudio", "Scheduler", "StreamDeck", "Suspend", "WebUI", "Webhooks"}
+
+// DefaultLevel is the level of all modules when not configured
+const DefaultLevel = zerolog.DebugLevel
+
+// Formats of the log output
+const (
+	FormatConsole = "console" // Human readable, colored on terminals
+	FormatJSON    = "json"    // One JSON object per line
+)
+
+// Options configure the logging of all modules
+type Options struct {
+	Level   zerolog.Level            // Level of modules without their own
+	Modules map[string]zerolog.Level // Levels by module name
+	Format  string                   // FormatConsole or FormatJSON, defaults to console
+	File    string                   // Log file, empty logs to standard error
+	Output  io.Writer                // Writer to log to instead of standard error or File
+	MaxSize int64                    // Size in bytes after which the log file is rotated, 0 never rotates
+}
+
+var (
+	mu      sync.RWMutex
+	level   = DefaultLevel
+	modules = map[string]zerolog.Level{}
+	output  = &switchWriter{writer: consoleWriter(os.Stderr, false)}
+	closer  io.Closer
+)
+
+// Logger returns the logger for code outside of the modules, to be used as log.Logger
+func Logger() zerolog.Logger {
+	return zerolog.New(output).With().Timestamp().Logger().Hook(levelHook{})
+}
+
+// Module returns the logger of a module, one of Modules
+func Module(name string) zerolog.Logger {
+	return zerolog.New(output).With().Timestamp().Str("module", name).Logger().Hook(levelHook{module: name})
+}
+
+// ModuleName returns the name of a module as listed in Modules, matched case
+// insensitively, and whether it exists
+func ModuleName(name string) (string, bool) {
+	for _, module := range Modules {
+		if strings.EqualFold(module, name) {
+			return module, true
+		}
+	}
+	return "", false
+}
+
+// Configure changes the levels and the output of all loggers, including the
+// ones created before
+func Configure(options Options) error {
+	var writer io.Writer
+	var fileCloser io.Closer
+	if options.Output != nil {
+		writer = options.Output
+	} else if options.File != "" {
+		file, err := openRotating(options.File, options.MaxSize)
+		if err != nil {
+			return fmt.Errorf("cannot open log file: %w", err)
+		}
+		writer, fileCloser = file, file
+	} else {
+		writer = os.Stderr
+	}
+	switch options.Format {
+	case "", FormatConsole:
+		writer = consoleWriter(writer, options.File != "" || options.Output != nil)
+	case FormatJSON:
+	default:
+		if fileCloser != nil {
+			fileCloser.Close()
+		}
+		return fmt.Errorf("unknown log format %q, use console or json", options.Format)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	level = options.Level
+	modules = make(map[string]zerolog.Level, len(options.Modules))
+	lowest := level
+	for name, moduleLevel := range options.Modules {
+		if module, ok := ModuleName(name); ok {
+			name = module
+		}
+		modules[name] = moduleLevel
+		lowest = min(lowest, moduleLevel)
+	}
+	// Events below every level are dropped before they are built
+	zerolog.SetGlobalLevel(lowest)
+
+	output.set(writer)
+	if closer != nil {
+		closer.Close()
+	}
+	closer = fileCloser
+	return nil
+}
+
+func consoleWriter(out io.Writer, noColor bool) io.Writer {
+	return zerolog.ConsoleWriter{Out: out, TimeFormat: time.RFC3339, NoColor: noColor}
+}
+
+// levelHook drops the events below the level of their module
+type levelHook struct {
+	module string
+}
+
+func (hook levelHook) Run(event *zerolog.Event, eventLevel zerolog.Level, message string) {
+	mu.RLock()
+	threshold, ok := modules[hook.module]
+	if !ok {
+		threshold = level
+	}
+	mu.RUnlock()
+	if eventLevel < threshold {
+		event.Discard()
+	}
+}
+
+// switchWriter passes writes on to a writer that can be replaced
+type switchWriter struct {
+	mu     sync.Mutex
+	writer io.Writer
+}
+
+func (w *switchWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.writer.Write(p)
+}
+
+func (w *switchWriter) set(writer io.Writer) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writer = writer
+}
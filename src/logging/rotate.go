@@ -0,0 +1,78 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// rotatingFile is a log file that is renamed to <path>.1 when it grows beyond
+// maxSize, replacing the previous one
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	file    *os.File
+	size    int64
+}
+
+func openRotating(path string, maxSize int64) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file := &rotatingFile{path: path, maxSize: maxSize}
+	if err := file.open(); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		// Keep logging to the full file if it can't be rotated, and try again later
+		if err := f.rotate(); err != nil {
+			os.Stderr.WriteString("pulsekontrol: cannot rotate log file: " + err.Error() + "\n")
+			f.size = 0
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := os.Rename(f.path, f.path+".1"); err != nil {
+		return err
+	}
+	// Without a new file, keep writing to the renamed one
+	previous := f.file
+	if err := f.open(); err != nil {
+		return err
+	}
+	previous.Close()
+	return nil
+}
+
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
@@ -0,0 +1,88 @@
+package midi
+
+import (
+	"fmt"
+
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/0h41/pulsekontrol/src/rtpmidi"
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/drivers"
+
+	driver "gitlab.com/gomidi/midi/v2/drivers/portmididrv"
+)
+
+// Driver opens the ports of a device. The MIDI system of the machine is used
+// unless SetDriver gives another, like fake ports in tests.
+type Driver interface {
+	// Open finds and opens the ports of a device and returns what closes
+	// them. changed is called when a network session is lost or rejoined.
+	Open(device configuration.MidiDevice, changed func(connected bool, err error)) (drivers.In, drivers.Out, func(), error)
+	// Rescan makes Open find ports that appeared since the last call, while
+	// no port is open
+	Rescan() error
+}
+
+// systemDriver opens local ports through PortMidi and network sessions through rtpmidi
+type systemDriver struct{}
+
+// Open finds and opens local ports through PortMidi, or joins the RTP-MIDI
+// session of the device
+func (systemDriver) Open(device configuration.MidiDevice, changed func(connected bool, err error)) (drivers.In, drivers.Out, func(), error) {
+	var in drivers.In
+	var out drivers.Out
+	closeDriver := func() {}
+	if device.Transport == configuration.RTPMIDITransport {
+		// The session is both ports
+		session := rtpmidi.NewSession(device.Host, device.Port, device.SessionName)
+		session.Lost = func() {
+			changed(false, rtpmidi.ErrNotConnected)
+		}
+		session.Reconnected = func() {
+			changed(true, nil)
+		}
+		in, out = session, session
+	} else {
+		drv, err := driver.New()
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to create MIDI driver: %w", err)
+		}
+		closeDriver = func() { drv.Close() }
+
+		in, err = midi.FindInPort(device.MidiInName)
+		if err != nil {
+			closeDriver()
+			return nil, nil, nil, fmt.Errorf("could not find MIDI In %s: %w", device.MidiInName, err)
+		}
+
+		out, err = midi.FindOutPort(device.MidiOutName)
+		if err != nil {
+			closeDriver()
+			return nil, nil, nil, fmt.Errorf("could not find MIDI Out %s: %w", device.MidiOutName, err)
+		}
+
+		if in == nil || out == nil {
+			closeDriver()
+			return nil, nil, nil, fmt.Errorf("MIDI ports are nil")
+		}
+	}
+
+	if err := in.Open(); err != nil {
+		closeDriver()
+		return nil, nil, nil, fmt.Errorf("could not open MIDI In %s: %w", in, err)
+	}
+	if err := out.Open(); err != nil {
+		in.Close()
+		closeDriver()
+		return nil, nil, nil, fmt.Errorf("could not open MIDI Out %s: %w", out, err)
+	}
+	return in, out, func() {
+		out.Close()
+		in.Close()
+		closeDriver()
+	}, nil
+}
+
+// Rescan initializes PortMidi again so Open finds ports plugged in since
+func (systemDriver) Rescan() error {
+	return rescanPorts()
+}
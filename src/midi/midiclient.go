@@ -1,15 +1,20 @@
 package midi
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"time"
 
+	"github.com/0h41/pulsekontrol/src/actions"
+	"github.com/0h41/pulsekontrol/src/activity"
+	"github.com/0h41/pulsekontrol/src/clock"
 	"github.com/0h41/pulsekontrol/src/configuration"
 	korgNanokontrol2 "github.com/0h41/pulsekontrol/src/device/korg/nanokontrol2"
+	"github.com/0h41/pulsekontrol/src/logging"
 	"github.com/0h41/pulsekontrol/src/pulseaudio"
+	"github.com/0h41/pulsekontrol/src/supervise"
 	"github.com/rs/zerolog"
-	"github.com/rs/zerolog/log"
 	"github.com/samber/lo"
 	"gitlab.com/gomidi/midi/v2"
 	"gitlab.com/gomidi/midi/v2/drivers"
@@ -46,8 +51,18 @@ func listDevices() ([]string, []string, error) {
 	return inNames, outNames, nil
 }
 
+const (
+	// portPollInterval is how often the ports of a device are looked for while it runs
+	portPollInterval = 2 * time.Second
+	// DefaultRetryInterval is how often a device that was not found at
+	// startup is looked for without a configured interval
+	DefaultRetryInterval = 5 * time.Second
+)
+
+// log is the logger of the MIDI module
+var log = logging.Module("Midi")
+
 func List() {
-	log := log.Logger.With().Str("module", "Midi").Logger()
 	ins, outs, err := listDevices()
 	if err != nil {
 		panic(err)
@@ -62,6 +77,12 @@ func List() {
 	}
 }
 
+// Auto-repeat of StepControl actions while the button is held
+const (
+	repeatDelay    = 400 * time.Millisecond
+	repeatInterval = 100 * time.Millisecond
+)
+
 type VolumeRequest struct {
 	Rule      configuration.Rule
 	Value     uint8
@@ -70,25 +91,51 @@ type VolumeRequest struct {
 
 type MidiClient struct {
 	log            zerolog.Logger
-	PAClient       *pulseaudio.PAClient
+	PAClient       pulseaudio.Backend
 	MidiDevice     configuration.MidiDevice
 	Rules          []configuration.Rule
 	ConfigManager  *configuration.ConfigManager
+	Executor       *actions.Executor
 	volumeChannels map[string]chan VolumeRequest
 	channelsMutex  sync.RWMutex
 	// LED control support
 	midiOut    drivers.Out
 	nanoDevice *korgNanokontrol2.KorgNanoKontrol2
+	// Identify (LED blink) support
+	identifyMutex  sync.Mutex
+	identifyCancel chan struct{}
+	identifyDone   chan struct{}
+	// StepControl auto-repeat, keyed by control path
+	repeatMutex  sync.Mutex
+	repeatCancel map[string]chan struct{}
+	// Closed once the ports are open and the device is set up
+	ready chan struct{}
+	// Closed when the device was not found at startup and is being looked for
+	waiting chan struct{}
+	// Whether Run fails instead of waiting for a device that is not found
+	required bool
+	// Opens the ports of the device
+	driver Driver
+	// Times the retries of a device that is not found
+	clock clock.Clock
+	// Called when the device disconnects and when it is back
+	connectionHandler func(connected bool, err error)
 }
 
-func NewMidiClient(paClient *pulseaudio.PAClient, device configuration.MidiDevice, rules []configuration.Rule, configManager *configuration.ConfigManager) *MidiClient {
+func NewMidiClient(paClient pulseaudio.Backend, device configuration.MidiDevice, rules []configuration.Rule, configManager *configuration.ConfigManager, executor *actions.Executor) *MidiClient {
 	client := &MidiClient{
-		log:            log.With().Str("module", "Midi").Str("device", device.Name).Logger(),
+		log:            log.With().Str("device", device.Name).Logger(),
 		PAClient:       paClient,
 		MidiDevice:     device,
 		Rules:          rules,
 		ConfigManager:  configManager,
+		Executor:       executor,
 		volumeChannels: make(map[string]chan VolumeRequest),
+		repeatCancel:   make(map[string]chan struct{}),
+		ready:          make(chan struct{}),
+		waiting:        make(chan struct{}),
+		driver:         systemDriver{},
+		clock:          clock.System,
 	}
 	client.startVolumeWorkers()
 	return client
@@ -106,7 +153,9 @@ func (client *MidiClient) getOrCreateVolumeChannel(ruleKey string) chan VolumeRe
 		if ch, exists = client.volumeChannels[ruleKey]; !exists {
 			ch = make(chan VolumeRequest, 1) // Buffer size 1 for latest value
 			client.volumeChannels[ruleKey] = ch
-			go client.processVolumeRequests(ruleKey, ch)
+			supervise.Go("midi.volume."+ruleKey, func() {
+				client.processVolumeRequests(ruleKey, ch)
+			})
 		}
 		client.channelsMutex.Unlock()
 	}
@@ -116,7 +165,8 @@ func (client *MidiClient) getOrCreateVolumeChannel(ruleKey string) chan VolumeRe
 // startVolumeWorkers initializes volume processing for existing rules
 func (client *MidiClient) startVolumeWorkers() {
 	for _, rule := range client.Rules {
-		if len(rule.Actions) > 0 && rule.Actions[0].Type == configuration.SetVolume {
+		// Sliders and knobs use the workers of the executor
+		if rule.ControlID == "" && len(rule.Actions) > 0 && rule.Actions[0].Type == configuration.SetVolume {
 			ruleKey := rule.MidiMessage.DeviceControlPath
 			client.getOrCreateVolumeChannel(ruleKey)
 		}
@@ -160,14 +210,21 @@ func (client *MidiClient) processVolumeRequest(req VolumeRequest) {
 			}
 
 			if err := client.PAClient.ProcessVolumeAction(action, volumePercent); err != nil {
-				client.log.Error().Err(err)
+				client.log.Error().Err(err).Msg("Failed to set volume")
 			}
 		case configuration.SetDefaultOutput:
 			if req.Value == 0 {
 				return
 			}
 			if err := client.PAClient.SetDefaultOutput(action); err != nil {
-				client.log.Error().Err(err)
+				client.log.Error().Err(err).Msg("Failed to set default output")
+			}
+		case configuration.SetDefaultInput:
+			if req.Value == 0 {
+				return
+			}
+			if err := client.PAClient.SetDefaultInput(action); err != nil {
+				client.log.Error().Err(err).Msg("Failed to set default input")
 			}
 		default:
 			client.log.Error().Msgf("Unknown action type %s in rule %+v", action.Type, req.Rule)
@@ -175,6 +232,30 @@ func (client *MidiClient) processVolumeRequest(req VolumeRequest) {
 	}
 }
 
+// controlValue converts the MIDI value of a slider or knob rule to 0-100
+// within the range of the rule, like processVolumeRequest
+func controlValue(rule configuration.Rule, value uint8) int {
+	minValue, maxValue := rule.MidiMessage.MinValue, rule.MidiMessage.MaxValue
+	if maxValue == 0 {
+		maxValue = 0x7f
+	}
+	if maxValue <= minValue {
+		return 0
+	}
+	return min(max(int(float64(value)/float64(maxValue-minValue)*100), 0), 100)
+}
+
+// handledByRule reports whether one of the matched rules queues the value of
+// a control, so that the direct mapping doesn't queue it again
+func handledByRule(rules []configuration.Rule, controlType string, controlId string) bool {
+	for _, rule := range rules {
+		if rule.ControlType == controlType && rule.ControlID == controlId {
+			return true
+		}
+	}
+	return false
+}
+
 func (client *MidiClient) assignFocusedWindowPlaybackStreams(action configuration.Action) error {
 	if client.ConfigManager == nil {
 		return fmt.Errorf("no config manager available")
@@ -227,6 +308,398 @@ func (client *MidiClient) assignFocusedWindowPlaybackStreams(action configuratio
 	return nil
 }
 
+// RunActions runs the actions of a button other than SetVolume, for a press
+// (value above 0) or release. controlPath identifies the button, like
+// Group1/Mute. Other sources of button presses share it with MIDI.
+func (client *MidiClient) RunActions(origin activity.Origin, controlPath string, actions []configuration.Action, value uint8) {
+	for _, action := range actions {
+		if value > 0 {
+			client.Executor.Record(origin, string(action.Type), controlPath, nil)
+		}
+		switch action.Type {
+		case configuration.SetDefaultOutput:
+			if value == 0 {
+				return
+			}
+			if err := client.PAClient.SetDefaultOutput(action); err != nil {
+				client.log.Error().Err(err).Msg("Failed to set default output")
+			}
+		case configuration.SetDefaultInput:
+			if value == 0 {
+				return
+			}
+			if err := client.PAClient.SetDefaultInput(action); err != nil {
+				client.log.Error().Err(err).Msg("Failed to set default input")
+			}
+		case configuration.MediaPlayPause:
+			if value > 0 { // Only trigger on button press, not release
+				if err := client.PAClient.ProcessMediaControlAction(action); err != nil {
+					client.log.Error().Err(err).Msg("Failed to play/pause media")
+				}
+			}
+		case configuration.AssignFocusedWindowPlaybackStreams:
+			if value > 0 { // Only trigger on button press, not release
+				if err := client.assignFocusedWindowPlaybackStreams(action); err != nil {
+					client.log.Error().Err(err).Msg("Failed to assign focused window playback streams")
+				}
+			}
+		case configuration.RecallScene:
+			if value > 0 { // Only trigger on button press, not release
+				if err := client.recallScene(origin, action); err != nil {
+					client.log.Error().Err(err).Msg("Failed to recall scene")
+				}
+			}
+		case configuration.ToggleMute:
+			if value > 0 { // Only trigger on button press, not release
+				if err := client.toggleMute(origin, action); err != nil {
+					client.log.Error().Err(err).Msg("Failed to toggle mute")
+				}
+			}
+		case configuration.Mute, configuration.Unmute:
+			if value > 0 { // Only trigger on button press, not release
+				if err := client.setMute(origin, action, action.Type == configuration.Mute); err != nil {
+					client.log.Error().Err(err).Msg("Failed to set mute")
+				}
+			}
+		case configuration.MoveStreamToSink:
+			if value > 0 { // Only trigger on button press, not release
+				if err := client.moveStream(origin, action); err != nil {
+					client.log.Error().Err(err).Msg("Failed to move stream")
+				}
+			}
+		case configuration.CycleDefaultOutput:
+			if value > 0 { // Only trigger on button press, not release
+				if err := client.cycleDefaultOutput(origin, action); err != nil {
+					client.log.Error().Err(err).Msg("Failed to cycle default output")
+				}
+			}
+		case configuration.SetCardProfile:
+			if value > 0 { // Only trigger on button press, not release
+				if err := client.switchCardProfile(origin, action); err != nil {
+					client.log.Error().Err(err).Msg("Failed to switch card profile")
+				}
+			}
+		case configuration.ToggleLoopback, configuration.LoadLoopback, configuration.UnloadLoopback:
+			if value > 0 { // Only trigger on button press, not release
+				if err := client.loopback(origin, action); err != nil {
+					client.log.Error().Err(err).Msg("Failed to change loopback")
+				}
+			}
+		case configuration.ToggleMicMute:
+			if value > 0 { // Only trigger on button press, not release
+				if err := client.toggleMicMute(origin, action); err != nil {
+					client.log.Error().Err(err).Msg("Failed to toggle microphone mute")
+				}
+			}
+		case configuration.ToggleSuspendOutput, configuration.SuspendOutput, configuration.ResumeOutput:
+			if value > 0 { // Only trigger on button press, not release
+				if err := client.suspendOutput(origin, action); err != nil {
+					client.log.Error().Err(err).Msg("Failed to suspend or resume output")
+				}
+			}
+		case configuration.ToggleEchoCancel:
+			if value > 0 { // Only trigger on button press, not release
+				if err := client.echoCancel(origin, action); err != nil {
+					client.log.Error().Err(err).Msg("Failed to toggle echo cancellation")
+				}
+			}
+		case configuration.VolumeUp, configuration.VolumeDown:
+			// Once per press or encoder tick, encoders send no release
+			if value > 0 {
+				if err := client.stepVolume(origin, action); err != nil {
+					client.log.Error().Err(err).Msg("Failed to step volume")
+				}
+			}
+		case configuration.StepControl:
+			// Release stops the auto-repeat
+			if err := client.stepControl(origin, controlPath, action, value > 0); err != nil {
+				client.log.Error().Err(err).Msg("Failed to step control")
+			}
+		default:
+			client.log.Error().Msgf("Unknown action type %s on %s", action.Type, controlPath)
+		}
+	}
+}
+
+func (client *MidiClient) recallScene(origin activity.Origin, action configuration.Action) error {
+	if client.Executor == nil {
+		return fmt.Errorf("no action executor available")
+	}
+
+	target, ok := action.Target.(*configuration.Target)
+	if !ok || target == nil {
+		return fmt.Errorf("invalid scene target")
+	}
+
+	return client.Executor.RecallScene(origin, target.Name, 0)
+}
+
+// toggleMute flips muting of the target control or source
+func (client *MidiClient) toggleMute(origin activity.Origin, action configuration.Action) error {
+	source, control, err := client.muteTarget(action)
+	if err != nil {
+		return err
+	}
+	if source != nil {
+		_, err = client.Executor.ToggleSourceMute(origin, source)
+	} else {
+		_, err = client.Executor.ToggleControlMute(origin, control.ControlType, control.ControlID)
+	}
+	return err
+}
+
+// setMute mutes or unmutes the target control or source
+func (client *MidiClient) setMute(origin activity.Origin, action configuration.Action, muted bool) error {
+	source, control, err := client.muteTarget(action)
+	if err != nil {
+		return err
+	}
+	if source != nil {
+		return client.Executor.SetSourceMuted(origin, source, muted)
+	}
+	return client.Executor.SetControlMuted(origin, control.ControlType, control.ControlID, muted)
+}
+
+// muteTarget returns the source or else the control a mute action targets
+func (client *MidiClient) muteTarget(action configuration.Action) (*configuration.TypedTarget, *configuration.ControlTarget, error) {
+	if client.Executor == nil {
+		return nil, nil, fmt.Errorf("no action executor available")
+	}
+
+	if source, ok := action.Target.(*configuration.TypedTarget); ok && source != nil {
+		return source, nil, nil
+	}
+	if client.ConfigManager == nil {
+		return nil, nil, fmt.Errorf("no configuration manager available")
+	}
+	config := client.ConfigManager.GetConfigSnapshot()
+	target, ok := action.MuteControl(&config)
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid control target for mute")
+	}
+	return nil, target, nil
+}
+
+// toggleMicMute flips muting of the target input device, or of the default
+// one, and shows the new state on the LEDs of the buttons muting it
+func (client *MidiClient) toggleMicMute(origin activity.Origin, action configuration.Action) error {
+	if client.Executor == nil {
+		return fmt.Errorf("no action executor available")
+	}
+	muted, err := client.Executor.ToggleMicMute(origin, action.MicMuteInput())
+	if err != nil {
+		return err
+	}
+	client.log.Info().Str("input", action.MicMuteInput()).Bool("muted", muted).Msg("Toggled microphone mute")
+	if err := client.UpdateMicMuteLEDs(); err != nil {
+		client.log.Debug().Err(err).Msg("Failed to update microphone mute LEDs")
+	}
+	return nil
+}
+
+// moveStream moves the target playback streams to the target output device
+func (client *MidiClient) moveStream(origin activity.Origin, action configuration.Action) error {
+	if client.Executor == nil {
+		return fmt.Errorf("no action executor available")
+	}
+
+	target, ok := action.Target.(*configuration.MoveTarget)
+	if !ok || target == nil {
+		return fmt.Errorf("invalid move target")
+	}
+
+	source := &configuration.TypedTarget{Type: configuration.PlaybackStream, Name: target.Name, BinaryName: target.BinaryName}
+	return client.Executor.MoveStreams(origin, source, target.Sink)
+}
+
+func (client *MidiClient) cycleDefaultOutput(origin activity.Origin, action configuration.Action) error {
+	if client.Executor == nil {
+		return fmt.Errorf("no action executor available")
+	}
+
+	target, ok := action.Target.(*configuration.CycleTarget)
+	if !ok || target == nil || len(target.Outputs) == 0 {
+		return fmt.Errorf("invalid cycle target")
+	}
+
+	output, err := client.Executor.CycleDefaultOutput(origin, target.Outputs)
+	if err != nil {
+		return err
+	}
+	client.log.Info().Str("output", output).Msg("Cycled default output")
+	return nil
+}
+
+// switchCardProfile switches the target card to the next of its profiles
+func (client *MidiClient) switchCardProfile(origin activity.Origin, action configuration.Action) error {
+	if client.Executor == nil {
+		return fmt.Errorf("no action executor available")
+	}
+
+	target, ok := action.Target.(*configuration.CardProfileTarget)
+	if !ok || target == nil || len(target.Profiles) == 0 {
+		return fmt.Errorf("invalid card profile target")
+	}
+
+	profile, err := client.Executor.SwitchCardProfile(origin, target.Card, target.Profiles)
+	if err != nil {
+		return err
+	}
+	client.log.Info().Str("card", target.Card).Str("profile", profile).Msg("Switched card profile")
+	return nil
+}
+
+// loopback plays the target input device on the target output device, stops
+// it or toggles between both
+func (client *MidiClient) loopback(origin activity.Origin, action configuration.Action) error {
+	if client.Executor == nil {
+		return fmt.Errorf("no action executor available")
+	}
+
+	target, ok := action.Target.(*configuration.LoopbackTarget)
+	if !ok || target == nil {
+		return fmt.Errorf("invalid loopback target")
+	}
+
+	latency := time.Duration(target.LatencyMs) * time.Millisecond
+	loaded := action.Type == configuration.LoadLoopback
+	if action.Type == configuration.ToggleLoopback {
+		var err error
+		if loaded, err = client.Executor.ToggleLoopback(origin, target.Source, target.Sink, latency); err != nil {
+			return err
+		}
+	} else if err := client.Executor.SetLoopback(origin, target.Source, target.Sink, latency, loaded); err != nil {
+		return err
+	}
+	client.log.Info().Str("source", target.Source).Str("sink", target.Sink).Bool("loaded", loaded).Msg("Changed loopback")
+	return nil
+}
+
+// suspendOutput suspends the target output device, resumes it or toggles
+// between both
+func (client *MidiClient) suspendOutput(origin activity.Origin, action configuration.Action) error {
+	if client.Executor == nil {
+		return fmt.Errorf("no action executor available")
+	}
+
+	target, ok := action.Target.(*configuration.Target)
+	if !ok || target == nil {
+		return fmt.Errorf("invalid output device target")
+	}
+
+	suspended := action.Type == configuration.SuspendOutput
+	if action.Type == configuration.ToggleSuspendOutput {
+		var err error
+		if suspended, err = client.Executor.ToggleOutputSuspended(origin, target.Name); err != nil {
+			return err
+		}
+	} else if err := client.Executor.SetOutputSuspended(origin, target.Name, suspended); err != nil {
+		return err
+	}
+	client.log.Info().Str("output", target.Name).Bool("suspended", suspended).Msg("Changed output suspension")
+	return nil
+}
+
+// echoCancel switches the target input device between recording with and
+// without the echo of the target output device
+func (client *MidiClient) echoCancel(origin activity.Origin, action configuration.Action) error {
+	if client.Executor == nil {
+		return fmt.Errorf("no action executor available")
+	}
+
+	target, ok := action.Target.(*configuration.EchoCancelTarget)
+	if !ok || target == nil {
+		return fmt.Errorf("invalid echo cancellation target")
+	}
+
+	loaded, err := client.Executor.ToggleEchoCancel(origin, target.Source, target.Sink, target.SetDefault)
+	if err != nil {
+		return err
+	}
+	client.log.Info().Str("source", target.Source).Str("sink", target.Sink).Bool("loaded", loaded).Msg("Toggled echo cancellation")
+	return nil
+}
+
+// stepVolume moves the volume of the target source up or down by the step
+// of the action
+func (client *MidiClient) stepVolume(origin activity.Origin, action configuration.Action) error {
+	if client.Executor == nil {
+		return fmt.Errorf("no action executor available")
+	}
+
+	target, ok := action.Target.(*configuration.VolumeStepTarget)
+	if !ok || target == nil {
+		return fmt.Errorf("invalid volume step target")
+	}
+
+	volume, err := client.Executor.StepSourceVolume(origin, &target.TypedTarget, action.VolumeStep())
+	if err != nil {
+		return err
+	}
+	client.log.Info().Str("source", target.Name).Int("volume", volume).Msg("Stepped volume")
+	return nil
+}
+
+// stepControl moves the target control by one step on press and keeps
+// stepping while the button is held, stopping on release
+func (client *MidiClient) stepControl(origin activity.Origin, controlPath string, action configuration.Action, pressed bool) error {
+	if client.Executor == nil {
+		return fmt.Errorf("no action executor available")
+	}
+
+	target, ok := action.Target.(*configuration.StepTarget)
+	if !ok || target == nil {
+		return fmt.Errorf("invalid step target")
+	}
+
+	client.repeatMutex.Lock()
+	if cancel, ok := client.repeatCancel[controlPath]; ok {
+		close(cancel)
+		delete(client.repeatCancel, controlPath)
+	}
+	if !pressed {
+		client.repeatMutex.Unlock()
+		return nil
+	}
+	cancel := make(chan struct{})
+	client.repeatCancel[controlPath] = cancel
+	client.repeatMutex.Unlock()
+
+	step := func() bool {
+		value, err := client.Executor.StepControl(origin, target.ControlType, target.ControlID, target.Direction, target.StepSize)
+		if err != nil {
+			client.log.Error().Err(err).Msg("Failed to step control")
+			return false
+		}
+		// Nothing left to do at either end of the range
+		return value > 0 && value < 100
+	}
+	if !step() {
+		return nil
+	}
+
+	go func() {
+		select {
+		case <-cancel:
+			return
+		case <-time.After(repeatDelay):
+		}
+		ticker := time.NewTicker(repeatInterval)
+		defer ticker.Stop()
+		for {
+			if !step() {
+				return
+			}
+			select {
+			case <-cancel:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
 // UpdateRules updates the rules for the MIDI client dynamically
 func (client *MidiClient) UpdateRules(rules []configuration.Rule) {
 	client.log.Info().Msgf("Updating MIDI rules - previous: %d, new: %d", len(client.Rules), len(rules))
@@ -289,7 +762,7 @@ func (client *MidiClient) UpdateLEDIndicators() error {
 		return fmt.Errorf("MIDI device not initialized")
 	}
 
-	config := *client.ConfigManager.GetConfig()
+	config := client.ConfigManager.GetConfigSnapshot()
 	if err := client.nanoDevice.UpdateSourceIndicatorLEDs(client.midiOut, config, client.PAClient); err != nil {
 		client.log.Error().Err(err).Msg("Failed to update LED indicators")
 		return err
@@ -299,6 +772,44 @@ func (client *MidiClient) UpdateLEDIndicators() error {
 	return nil
 }
 
+// UpdateMicMuteLEDs lights the buttons with a ToggleMicMute action while
+// their input device is muted. Muting it elsewhere, like in pavucontrol,
+// shows as well.
+func (client *MidiClient) UpdateMicMuteLEDs() error {
+	if client.MidiDevice.Type != configuration.KorgNanoKontrol2 || client.ConfigManager == nil {
+		return nil
+	}
+	config := client.ConfigManager.GetConfigSnapshot()
+	if len(config.Controls.ButtonsWithAction(configuration.ToggleMicMute)) == 0 {
+		return nil
+	}
+	if client.nanoDevice == nil || client.midiOut == nil {
+		return fmt.Errorf("MIDI device not initialized")
+	}
+	// Reloads the streams, the cached ones may predate the last mute
+	client.PAClient.GetAudioSources()
+	return client.nanoDevice.UpdateMicMuteLEDs(client.midiOut, config, client.PAClient.CachedStreams())
+}
+
+// RestoreLEDs puts the device back into external LED mode and shows the
+// indicators again, for a device that lost its state while powered off, like
+// during a suspend
+func (client *MidiClient) RestoreLEDs() error {
+	if client.MidiDevice.Type != configuration.KorgNanoKontrol2 {
+		return nil
+	}
+	if client.nanoDevice == nil || client.midiOut == nil {
+		return fmt.Errorf("MIDI device not initialized")
+	}
+	if err := client.nanoDevice.EnableExternalLEDMode(client.midiOut); err != nil {
+		return err
+	}
+	if err := client.UpdateLEDIndicators(); err != nil {
+		return err
+	}
+	return client.UpdatePlayButtonLED(client.PAClient.IsMediaPlaying())
+}
+
 // UpdatePlayButtonLED updates only the play button LED based on media status
 func (client *MidiClient) UpdatePlayButtonLED(isPlaying bool) error {
 	if client.nanoDevice == nil || client.midiOut == nil {
@@ -322,46 +833,243 @@ func (client *MidiClient) UpdatePlayButtonLED(isPlaying bool) error {
 	return nil
 }
 
-func (client *MidiClient) Run() error {
-	drv, err := driver.New()
-	if err != nil {
-		return fmt.Errorf("failed to create MIDI driver: %w", err)
+// IdentifyControl blinks the LEDs of the group a control belongs to for a couple of seconds,
+// then restores the real LED state. A new identify request cancels the running one.
+func (client *MidiClient) IdentifyControl(controlType string, controlId string) error {
+	if client.ConfigManager == nil {
+		return fmt.Errorf("no config manager available")
 	}
 
-	// make sure to close all open ports at the end
-	defer drv.Close()
+	var controlPath string
+	config := client.ConfigManager.GetConfigSnapshot()
+	switch controlType {
+	case "slider":
+		if slider, ok := config.Controls.Sliders[controlId]; ok {
+			controlPath = slider.Path
+		}
+	case "knob":
+		if knob, ok := config.Controls.Knobs[controlId]; ok {
+			controlPath = knob.Path
+		}
+	}
+	if controlPath == "" {
+		return fmt.Errorf("unknown control %s %s", controlType, controlId)
+	}
 
-	in, err := midi.FindInPort(client.MidiDevice.MidiInName)
-	if err != nil {
-		client.log.Error().Msgf("Could not find MIDI In %s", client.MidiDevice.MidiInName)
-		return fmt.Errorf("could not find MIDI In %s: %w", client.MidiDevice.MidiInName, err)
+	if client.nanoDevice == nil || client.midiOut == nil {
+		return fmt.Errorf("MIDI device not initialized")
 	}
 
-	out, err := midi.FindOutPort(client.MidiDevice.MidiOutName)
+	leds, err := client.nanoDevice.ControlLEDs(controlPath)
 	if err != nil {
-		client.log.Error().Msgf("Could not find MIDI Out %s", client.MidiDevice.MidiOutName)
-		return fmt.Errorf("could not find MIDI Out %s: %w", client.MidiDevice.MidiOutName, err)
+		return err
 	}
 
-	if in == nil || out == nil {
-		return fmt.Errorf("MIDI ports are nil")
+	client.identifyMutex.Lock()
+	defer client.identifyMutex.Unlock()
+
+	// Cancel a running identify and wait until it has restored the LEDs
+	if client.identifyCancel != nil {
+		close(client.identifyCancel)
+		<-client.identifyDone
 	}
 
-	if err := in.Open(); err != nil {
-		panic(err)
+	cancel := make(chan struct{})
+	done := make(chan struct{})
+	client.identifyCancel = cancel
+	client.identifyDone = done
+
+	client.log.Info().Str("controlType", controlType).Str("controlId", controlId).Msg("Identifying control")
+
+	go func() {
+		defer close(done)
+
+		if err := client.nanoDevice.BlinkLEDs(client.midiOut, leds, 2*time.Second, cancel); err != nil {
+			client.log.Error().Err(err).Msg("Failed to blink LEDs")
+		}
+
+		// Restore the real LED state
+		if err := client.UpdateLEDIndicators(); err != nil {
+			client.log.Error().Err(err).Msg("Failed to restore LED indicators after identify")
+		}
+	}()
+
+	return nil
+}
+
+// stopBackground cancels the auto-repeats and a running identify, which write to the out port
+func (client *MidiClient) stopBackground() {
+	client.repeatMutex.Lock()
+	for controlPath, cancel := range client.repeatCancel {
+		close(cancel)
+		delete(client.repeatCancel, controlPath)
 	}
+	client.repeatMutex.Unlock()
 
-	if err := out.Open(); err != nil {
-		panic(err)
+	client.identifyMutex.Lock()
+	if client.identifyCancel != nil {
+		close(client.identifyCancel)
+		<-client.identifyDone
+		client.identifyCancel = nil
+	}
+	client.identifyMutex.Unlock()
+}
+
+// SetConnectionHandler sets what is called when the device is connected,
+// with the reason when it is not found or disconnects. Must be called before Run.
+func (client *MidiClient) SetConnectionHandler(handler func(connected bool, err error)) {
+	client.connectionHandler = handler
+}
+
+// SetDriver replaces the MIDI system the ports are opened through, like with
+// fake ports in tests. Must be called before Run.
+func (client *MidiClient) SetDriver(driver Driver) {
+	client.driver = driver
+}
+
+// SetClock replaces the clock that times the retries. Must be called before Run.
+func (client *MidiClient) SetClock(clock clock.Clock) {
+	client.clock = clock
+}
+
+// SetRequired makes Run fail when the device is not found instead of
+// waiting for it. Must be called before Run.
+func (client *MidiClient) SetRequired(required bool) {
+	client.required = required
+}
+
+// connectionChanged reports that the device is connected, or why it is not
+func (client *MidiClient) connectionChanged(connected bool, err error) {
+	if client.connectionHandler != nil {
+		client.connectionHandler(connected, err)
+	}
+}
+
+// watchPorts polls the ALSA sequencer for the in port of the device until ctx
+// is done, to report when it is unplugged and plugged in again. Without the
+// sequencer listing, disconnects go unnoticed.
+func (client *MidiClient) watchPorts(ctx context.Context) {
+	ticker := time.NewTicker(portPollInterval)
+	defer ticker.Stop()
+	connected := true
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		present, ok := portPresent(client.MidiDevice.MidiInName)
+		if !ok {
+			return
+		}
+		if present == connected {
+			continue
+		}
+		connected = present
+		if connected {
+			client.log.Info().Str("port", client.MidiDevice.MidiInName).Msg("MIDI device is connected again")
+		} else {
+			client.log.Warn().Str("port", client.MidiDevice.MidiInName).Msg("MIDI device disconnected")
+		}
+		var err error
+		if !connected {
+			err = fmt.Errorf("MIDI In %s disappeared", client.MidiDevice.MidiInName)
+		}
+		client.connectionChanged(connected, err)
+	}
+}
+
+// Ready is closed once Run has opened the ports and set up the device
+func (client *MidiClient) Ready() <-chan struct{} {
+	return client.ready
+}
+
+// Waiting is closed when Run did not find the device and looks for it until
+// it appears, while everything else keeps working
+func (client *MidiClient) Waiting() <-chan struct{} {
+	return client.waiting
+}
+
+// retryInterval returns how often a device that is not found is looked for
+func (client *MidiClient) retryInterval() time.Duration {
+	if client.MidiDevice.RetryInterval > 0 {
+		return client.MidiDevice.RetryInterval
+	}
+	return DefaultRetryInterval
+}
+
+// openPorts opens the ports of the device through the driver and returns
+// what closes them
+func (client *MidiClient) openPorts() (drivers.In, drivers.Out, func(), error) {
+	return client.driver.Open(client.MidiDevice, client.sessionChanged)
+}
+
+// sessionChanged reports a lost or rejoined network session, restoring the
+// LEDs after rejoining
+func (client *MidiClient) sessionChanged(connected bool, err error) {
+	client.connectionChanged(connected, err)
+	if !connected {
+		return
+	}
+	if err := client.UpdateLEDIndicators(); err != nil {
+		client.log.Error().Err(err).Msg("Failed to restore LED indicators after rejoining the session")
 	}
+}
 
-	defer in.Close()
-	defer out.Close()
+// waitForDevice looks for the device every retry interval until its ports
+// open, or returns ctx's error once it is done
+func (client *MidiClient) waitForDevice(ctx context.Context) (drivers.In, drivers.Out, func(), error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, nil, nil, ctx.Err()
+		case <-client.clock.After(client.retryInterval()):
+		}
+		if client.MidiDevice.Transport != configuration.RTPMIDITransport {
+			if err := client.driver.Rescan(); err != nil {
+				client.log.Debug().Err(err).Msg("Failed to rescan MIDI ports")
+				continue
+			}
+		}
+		in, out, closePorts, err := client.openPorts()
+		if err == nil {
+			return in, out, closePorts, nil
+		}
+		client.log.Debug().Err(err).Msg("MIDI device still not found")
+	}
+}
+
+// Run opens the ports of the device and handles its messages until ctx is
+// done, then closes the ports
+func (client *MidiClient) Run(ctx context.Context) error {
+	in, out, closePorts, err := client.openPorts()
+	if err != nil {
+		if client.required {
+			return err
+		}
+		// Without the device the web interface still works as a mixer
+		client.log.Warn().Err(err).Dur("retryInterval", client.retryInterval()).Msgf("No MIDI device %s found, the web interface remains available; looking for it until it is connected", client.MidiDevice.Name)
+		close(client.waiting)
+		client.connectionChanged(false, err)
+		if in, out, closePorts, err = client.waitForDevice(ctx); err != nil {
+			return nil
+		}
+		client.log.Info().Msgf("MIDI device %s found", client.MidiDevice.Name)
+	}
+	// Closed after the listener stops
+	defer closePorts()
 
 	onMessage := func(sysExChannel chan []byte) func(msg midi.Message, timestampMs int32) {
 		var doActions = func(rule configuration.Rule, value uint8) {
 			client.log.Debug().Msgf("Received action for rule: %s", rule.MidiMessage.DeviceControlPath)
 
+			// Sliders and knobs take the fast path of the executor, which
+			// also stores the value
+			if rule.ControlID != "" && client.Executor != nil {
+				client.Executor.QueueControlValue(activity.Midi(), rule.ControlType, rule.ControlID, controlValue(rule, value))
+				return
+			}
+
 			// Check if this rule has volume actions
 			hasVolumeAction := false
 			for _, action := range rule.Actions {
@@ -398,35 +1106,14 @@ func (client *MidiClient) Run() error {
 				}
 			} else {
 				// Handle non-volume actions immediately
-				for _, action := range rule.Actions {
-					switch action.Type {
-					case configuration.SetDefaultOutput:
-						if value == 0 {
-							return
-						}
-						if err := client.PAClient.SetDefaultOutput(action); err != nil {
-							client.log.Error().Err(err)
-						}
-					case configuration.MediaPlayPause:
-						if value > 0 { // Only trigger on button press, not release
-							if err := client.PAClient.ProcessMediaControlAction(action); err != nil {
-								client.log.Error().Err(err)
-							}
-						}
-					case configuration.AssignFocusedWindowPlaybackStreams:
-						if value > 0 { // Only trigger on button press, not release
-							if err := client.assignFocusedWindowPlaybackStreams(action); err != nil {
-								client.log.Error().Err(err).Msg("Failed to assign focused window playback streams")
-							}
-						}
-					default:
-						client.log.Error().Msgf("Unknown action type %s in rule %+v", action.Type, rule)
-					}
-				}
+				client.RunActions(activity.Midi(), rule.MidiMessage.DeviceControlPath, rule.Actions, value)
 			}
 		}
 		return func(message midi.Message, timestampMs int32) {
+			// A panic here would kill the process from the driver's goroutine
+			defer supervise.Recover("midi.listener")
 			client.log.Debug().Msgf("Received MIDI message (%s) from in port %v", message.String(), in)
+			publishMessage(client.ConfigManager, client.MidiDevice.Name, message)
 			switch message.Type() {
 			case midi.NoteOnMsg, midi.NoteOffMsg:
 				var channel uint8
@@ -499,7 +1186,7 @@ func (client *MidiClient) Run() error {
 				client.log.Debug().Msgf("Found %d matching CC rules", len(rules))
 
 				// First, update config values for sliders and knobs
-				if client.ConfigManager != nil {
+				if client.ConfigManager != nil && client.Executor != nil {
 					// Convert 0-127 MIDI value to 0-100 percentage
 					value := int((float64(ccValue) / 127.0) * 100.0)
 
@@ -521,7 +1208,9 @@ func (client *MidiClient) Run() error {
 								Int("value", value).
 								Msg("Updating slider value from MIDI via direct mapping")
 
-							client.ConfigManager.UpdateControlValue("slider", controlId, value)
+							if !handledByRule(rules, "slider", controlId) {
+								client.Executor.QueueControlValue(activity.Midi(), "slider", controlId, value)
+							}
 						} else if controller >= 16 && controller <= 23 {
 							// This is a knob (16-23 → knob1-8)
 							groupNumber := controller - 16 + 1
@@ -533,7 +1222,9 @@ func (client *MidiClient) Run() error {
 								Int("value", value).
 								Msg("Updating knob value from MIDI via direct mapping")
 
-							client.ConfigManager.UpdateControlValue("knob", controlId, value)
+							if !handledByRule(rules, "knob", controlId) {
+								client.Executor.QueueControlValue(activity.Midi(), "knob", controlId, value)
+							}
 						}
 					}
 				}
@@ -564,9 +1255,11 @@ func (client *MidiClient) Run() error {
 
 	sysExChannel := make(chan []byte)
 
-	if _, err = midi.ListenTo(in, onMessage(sysExChannel), midi.UseSysEx()); err != nil {
+	stopListening, err := midi.ListenTo(in, onMessage(sysExChannel), midi.UseSysEx())
+	if err != nil {
 		panic(err)
 	}
+	defer stopListening()
 
 	// Only support KORG nanoKONTROL2
 	if client.MidiDevice.Type == configuration.KorgNanoKontrol2 {
@@ -594,12 +1287,23 @@ func (client *MidiClient) Run() error {
 
 		// Initialize LED indicators based on current configuration
 		if client.ConfigManager != nil {
-			config := *client.ConfigManager.GetConfig()
+			config := client.ConfigManager.GetConfigSnapshot()
 			if err := device.UpdateSourceIndicatorLEDs(out, config, client.PAClient); err != nil {
 				client.log.Error().Err(err).Msg("Failed to initialize LED indicators")
 			}
 		}
 	}
 
-	select {}
+	close(client.ready)
+	client.connectionChanged(true, nil)
+	// Only the ports of the system are in the ALSA sequencer listing
+	if _, system := client.driver.(systemDriver); system && client.MidiDevice.Transport != configuration.RTPMIDITransport {
+		supervise.Go("midi.ports", func() { client.watchPorts(ctx) })
+	}
+
+	// Ports left open can wedge the nanoKONTROL2 until it is plugged in again
+	<-ctx.Done()
+	client.stopBackground()
+	client.log.Info().Msg("Closing MIDI ports")
+	return nil
 }
@@ -0,0 +1,79 @@
+package midi
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/0h41/pulsekontrol/src/logging"
+	"github.com/0h41/pulsekontrol/src/rtpmidi"
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/drivers"
+
+	driver "gitlab.com/gomidi/midi/v2/drivers/portmididrv"
+)
+
+// MessageTopic is the notification of a received MIDI message, only sent
+// while someone like pulsekontrol monitor subscribes to it by name, it is one
+// of configuration.OptInTopics
+const MessageTopic = "midi.message"
+
+// publishMessage notifies the subscribers of MessageTopic of a message
+func publishMessage(configManager *configuration.ConfigManager, device string, message midi.Message) {
+	if configManager == nil || !configManager.Subscribed(MessageTopic) {
+		return
+	}
+	configManager.Notify(MessageTopic, map[string]interface{}{
+		"device":  device,
+		"type":    message.Type().String(),
+		"message": message.String(),
+		"raw":     hex.EncodeToString(message), // The unparsed bytes
+	})
+}
+
+// Listen opens the in port of a device and notifies configManager of the
+// received messages until ctx is done. The out port is left alone and
+// nothing is sent, so the device keeps its state; used by pulsekontrol
+// monitor when no instance runs.
+func Listen(ctx context.Context, device configuration.DeviceConfig, configManager *configuration.ConfigManager) error {
+	log := logging.Module("Midi")
+	var in drivers.In
+	if device.Transport == configuration.RTPMIDITransport {
+		in = rtpmidi.NewSession(device.Host, device.Port, device.SessionName)
+	} else {
+		drv, err := driver.New()
+		if err != nil {
+			return fmt.Errorf("failed to create MIDI driver: %w", err)
+		}
+		defer drv.Close()
+
+		name := device.InPort
+		if resolved, err := ResolveDevices([]configuration.DeviceConfig{device}); err == nil {
+			if ports, ok := resolved[device.Name]; ok && ports.InPort != "" {
+				name = ports.InPort
+			}
+		}
+		in, err = midi.FindInPort(name)
+		if err != nil {
+			return fmt.Errorf("could not find MIDI In %s: %w", name, err)
+		}
+	}
+
+	if err := in.Open(); err != nil {
+		return fmt.Errorf("could not open MIDI In %s: %w", in, err)
+	}
+	defer in.Close()
+
+	stopListening, err := midi.ListenTo(in, func(message midi.Message, timestampMs int32) {
+		publishMessage(configManager, device.Name, message)
+	}, midi.UseSysEx())
+	if err != nil {
+		return fmt.Errorf("could not listen to MIDI In %s: %w", in, err)
+	}
+	defer stopListening()
+	log.Info().Str("device", device.Name).Msgf("Listening to MIDI In %s", in)
+
+	<-ctx.Done()
+	return nil
+}
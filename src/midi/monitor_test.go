@@ -0,0 +1,52 @@
+package midi
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"gitlab.com/gomidi/midi/v2"
+)
+
+func TestMessagesOnlyForTheirSubscribers(t *testing.T) {
+	cm := configuration.NewConfigManager(configuration.GetDefaultConfig(), filepath.Join(t.TempDir(), "config.yaml"))
+	cm.SetReadOnly(true)
+	t.Cleanup(cm.Close)
+
+	// Like the web interface, which subscribes to everything
+	events := make(chan configuration.TopicEvent, 4)
+	cm.Subscribe(configuration.AllTopics, func(data interface{}) {
+		if event, ok := data.(configuration.TopicEvent); ok && event.Topic == MessageTopic {
+			events <- event
+		}
+	})
+	if cm.Subscribed(MessageTopic) {
+		t.Error("subscribing to all topics turns on MIDI messages")
+	}
+	publishMessage(cm, "nanoKONTROL2", midi.ControlChange(0, 7, 100))
+	select {
+	case event := <-events:
+		t.Errorf("sent %+v without a subscriber", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Like pulsekontrol monitor
+	subscription := cm.Subscribe(MessageTopic, func(interface{}) {})
+	if !cm.Subscribed(MessageTopic) {
+		t.Error("MIDI messages not turned on by their subscriber")
+	}
+	publishMessage(cm, "nanoKONTROL2", midi.ControlChange(0, 7, 100))
+	select {
+	case event := <-events:
+		if data, _ := event.Data.(map[string]interface{}); data["device"] != "nanoKONTROL2" || data["raw"] != "b00764" {
+			t.Errorf("sent %+v", event.Data)
+		}
+	case <-time.After(time.Second):
+		t.Error("message not sent")
+	}
+	cm.Unsubscribe(subscription)
+	if cm.Subscribed(MessageTopic) {
+		t.Error("MIDI messages still on after unsubscribing")
+	}
+}
@@ -0,0 +1,342 @@
+package midi
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"gitlab.com/gomidi/midi/v2/drivers/portmididrv/imported/portmidi"
+)
+
+// PortInfo is a MIDI port together with the hardware it belongs to, as far
+// as it could be determined
+type PortInfo struct {
+	Name   string
+	Serial string // USB serial number of the device
+	CardID string // ALSA card id
+}
+
+// DevicePorts are the ports found for a configured device
+type DevicePorts struct {
+	InPort    string
+	OutPort   string
+	Serial    string // USB serial number of the device the ports belong to, if known
+	Learned   bool   // The serial is not configured yet and should be stored
+	Identity  bool   // The input port was matched by the configured identity
+	Ambiguous bool   // Several ports matched and the first one was picked
+	Missing   bool   // The input or output port was not found
+}
+
+// Candidate is a connected controller recognized by the signature of its type
+type Candidate struct {
+	Type    configuration.MidiDeviceType
+	InPort  string
+	OutPort string // Empty if the controller has no matching output
+}
+
+// seqClientsPath lists the clients and ports of the ALSA sequencer
+const seqClientsPath = "/proc/asound/seq/clients"
+
+// Kernel sequencer clients of sound cards start at 16, four per card
+const (
+	firstCardClient = 16
+	clientsPerCard  = 4
+)
+
+var (
+	seqClientRe  = regexp.MustCompile(`^Client\s+(\d+)\s*:\s*"(.*)"\s*\[(.*)\]`)
+	seqPortRe    = regexp.MustCompile(`^\s+Port\s+(\d+)\s*:\s*"(.*)"`)
+	seqCardRe    = regexp.MustCompile(`card[ =](\d+)`)
+	portNumberRe = regexp.MustCompile(`\s+(\d+):(\d+)$`)
+)
+
+// seqPort is a port listed in /proc/asound/seq/clients
+type seqPort struct {
+	client     int
+	port       int
+	card       int // -1 for software clients
+	clientName string
+	name       string
+}
+
+// readSeqPorts lists the ALSA sequencer ports in client order, which is the
+// order the MIDI driver lists them in
+func readSeqPorts(path string) []seqPort {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var ports []seqPort
+	client, card, clientName := -1, -1, ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if groups := seqClientRe.FindStringSubmatch(line); groups != nil {
+			client, _ = strconv.Atoi(groups[1])
+			clientName = groups[2]
+			card = -1
+			if match := seqCardRe.FindStringSubmatch(groups[3]); match != nil {
+				card, _ = strconv.Atoi(match[1])
+			} else if strings.HasPrefix(groups[3], "Kernel") && client >= firstCardClient {
+				// Older kernels don't print the card
+				card = (client - firstCardClient) / clientsPerCard
+			}
+			continue
+		}
+		if groups := seqPortRe.FindStringSubmatch(line); groups != nil && client >= 0 {
+			port, _ := strconv.Atoi(groups[1])
+			ports = append(ports, seqPort{client: client, port: port, card: card, clientName: clientName, name: groups[2]})
+		}
+	}
+	return ports
+}
+
+// matches reports whether a port name as listed by the MIDI driver refers to
+// this sequencer port
+func (port seqPort) matches(name string) bool {
+	if groups := portNumberRe.FindStringSubmatch(name); groups != nil {
+		return groups[1] == strconv.Itoa(port.client) && groups[2] == strconv.Itoa(port.port)
+	}
+	return name == port.name || strings.HasPrefix(name, port.clientName+":"+port.name)
+}
+
+// portPresent reports whether a port as listed by the MIDI driver is in the
+// ALSA sequencer listing, and false for ok if there is no listing
+func portPresent(name string) (present bool, ok bool) {
+	if _, err := os.Stat(seqClientsPath); err != nil {
+		return false, false
+	}
+	for _, port := range readSeqPorts(seqClientsPath) {
+		if port.matches(name) {
+			return true, true
+		}
+	}
+	return false, true
+}
+
+// rescanPorts makes the MIDI driver list the ports present now. PortMidi
+// reads the list once when it is initialized, so a device plugged in later
+// only shows up after initializing it again. No port may be open.
+func rescanPorts() error {
+	if err := portmidi.Terminate(); err != nil {
+		return err
+	}
+	return portmidi.Initialize()
+}
+
+// readSysValue reads a single line value from /proc or /sys
+func readSysValue(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// portInfos looks up the hardware behind each port name. The lookup is best
+// effort: ports that can't be traced to a sound card get an empty identity.
+// Identical names are told apart by their position in the list.
+func portInfos(names []string) []PortInfo {
+	seqPorts := readSeqPorts(seqClientsPath)
+	infos := make([]PortInfo, len(names))
+	seen := make(map[string]int)
+	for i, name := range names {
+		infos[i].Name = name
+		occurrence := seen[name]
+		seen[name]++
+		for _, port := range seqPorts {
+			if !port.matches(name) {
+				continue
+			}
+			if occurrence > 0 {
+				occurrence--
+				continue
+			}
+			if port.card >= 0 {
+				infos[i].CardID = readSysValue(fmt.Sprintf("/proc/asound/card%d/id", port.card))
+				// The card device is the USB interface, the serial belongs to its parent
+				infos[i].Serial = readSysValue(fmt.Sprintf("/sys/class/sound/card%d/device/../serial", port.card))
+			}
+			break
+		}
+	}
+	return infos
+}
+
+// normalizePortName strips the client:port numbers some drivers append, as
+// they change when devices are plugged in a different order
+func normalizePortName(name string) string {
+	return strings.ToLower(portNumberRe.ReplaceAllString(strings.TrimSpace(name), ""))
+}
+
+// matchesIdentity reports whether a port belongs to the device with the given identity
+func matchesIdentity(port PortInfo, identity configuration.DeviceIdentity) bool {
+	if identity.Serial != "" && port.Serial != identity.Serial {
+		return false
+	}
+	if identity.ID != "" && port.CardID != identity.ID {
+		return false
+	}
+	return true
+}
+
+// pickPort chooses the port for a device among the unclaimed ports: by
+// identity first, then by exact name, then by normalized name. Ports known to
+// belong to other hardware are never picked for a device with an identity.
+func pickPort(ports []PortInfo, claimed map[int]bool, identity configuration.DeviceIdentity, name string) (int, bool) {
+	var byIdentity, byName, byNormalized []int
+	for i, port := range ports {
+		if claimed[i] {
+			continue
+		}
+		known := port.Serial != "" || port.CardID != ""
+		if !identity.IsZero() && known {
+			if !matchesIdentity(port, identity) {
+				continue
+			}
+			byIdentity = append(byIdentity, i)
+		}
+		if port.Name == name {
+			byName = append(byName, i)
+		} else if name != "" && normalizePortName(port.Name) == normalizePortName(name) {
+			byNormalized = append(byNormalized, i)
+		}
+	}
+
+	// Among ports of the right hardware, prefer the configured name
+	if len(byIdentity) > 0 {
+		for _, candidates := range [][]int{byName, byNormalized} {
+			for _, i := range candidates {
+				for _, j := range byIdentity {
+					if i == j {
+						return i, false
+					}
+				}
+			}
+		}
+		return byIdentity[0], len(byIdentity) > 1
+	}
+	if len(byName) > 0 {
+		return byName[0], len(byName) > 1
+	}
+	if len(byNormalized) > 0 {
+		return byNormalized[0], len(byNormalized) > 1
+	}
+	return -1, false
+}
+
+// resolvePorts assigns ports to the configured devices. Devices with an
+// identity go first so that matching by name can't take their ports. Devices
+// that no port matches keep their configured port names.
+func resolvePorts(devices []configuration.DeviceConfig, ins []PortInfo, outs []PortInfo) map[string]DevicePorts {
+	ordered := slices.Clone(devices)
+	slices.SortStableFunc(ordered, func(a, b configuration.DeviceConfig) int {
+		if a.Identity.IsZero() == b.Identity.IsZero() {
+			return 0
+		}
+		if a.Identity.IsZero() {
+			return 1
+		}
+		return -1
+	})
+
+	result := make(map[string]DevicePorts, len(devices))
+	claimedIns := make(map[int]bool)
+	claimedOuts := make(map[int]bool)
+	for _, device := range ordered {
+		ports := DevicePorts{InPort: device.InPort, OutPort: device.OutPort}
+
+		if i, ambiguous := pickPort(ins, claimedIns, device.Identity, device.InPort); i >= 0 {
+			claimedIns[i] = true
+			ports.InPort = ins[i].Name
+			ports.Serial = ins[i].Serial
+			ports.Ambiguous = ambiguous
+			ports.Identity = !device.Identity.IsZero() && matchesIdentity(ins[i], device.Identity)
+		} else {
+			ports.Missing = true
+		}
+		identity := device.Identity
+		if identity.IsZero() && ports.Serial != "" {
+			// Keep the output on the same hardware as the input
+			identity.Serial = ports.Serial
+		}
+		if i, ambiguous := pickPort(outs, claimedOuts, identity, device.OutPort); i >= 0 {
+			claimedOuts[i] = true
+			ports.OutPort = outs[i].Name
+			ports.Ambiguous = ports.Ambiguous || ambiguous
+		} else {
+			ports.Missing = true
+		}
+
+		ports.Learned = device.Identity.Serial == "" && ports.Serial != ""
+		result[device.Name] = ports
+	}
+	return result
+}
+
+// ResolveDevices finds the ports of the configured devices among the
+// connected MIDI ports
+func ResolveDevices(devices []configuration.DeviceConfig) (map[string]DevicePorts, error) {
+	inNames, outNames, err := listDevices()
+	if err != nil {
+		return nil, err
+	}
+	resolved := resolvePorts(devices, portInfos(inNames), portInfos(outNames))
+	for _, device := range devices {
+		ports := resolved[device.Name]
+		if !device.Identity.IsZero() && !ports.Identity {
+			log.Warn().Str("device", device.Name).Msgf("No MIDI port has the identity of device %s, falling back to the port names", device.Name)
+		}
+		if ports.Ambiguous {
+			log.Warn().Str("device", device.Name).Msgf("Several MIDI ports match device %s, using %s; set identity.serial or identity.id to pick one", device.Name, ports.InPort)
+		}
+		if ports.InPort != device.InPort || ports.OutPort != device.OutPort {
+			log.Info().Str("device", device.Name).Str("in", ports.InPort).Str("out", ports.OutPort).Msg("Resolved MIDI ports of device")
+		}
+	}
+	return resolved, nil
+}
+
+// detect finds the controllers among the port names by the signatures of the
+// device types. The inputs and outputs of one type are paired in the order
+// they are listed, which is the order of the controllers.
+func detect(signatures []configuration.Signature, ins []string, outs []string) []Candidate {
+	var candidates []Candidate
+	for _, signature := range signatures {
+		var matchingOuts []string
+		for _, name := range outs {
+			if signature.PortPattern.MatchString(name) {
+				matchingOuts = append(matchingOuts, name)
+			}
+		}
+		n := 0
+		for _, name := range ins {
+			if !signature.PortPattern.MatchString(name) {
+				continue
+			}
+			candidate := Candidate{Type: signature.Type, InPort: name}
+			if n < len(matchingOuts) {
+				candidate.OutPort = matchingOuts[n]
+			}
+			candidates = append(candidates, candidate)
+			n++
+		}
+	}
+	return candidates
+}
+
+// Detect lists the connected controllers of the supported device types
+func Detect() ([]Candidate, error) {
+	inNames, outNames, err := listDevices()
+	if err != nil {
+		return nil, err
+	}
+	return detect(configuration.Signatures(), inNames, outNames), nil
+}
@@ -0,0 +1,185 @@
+package midi
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/0h41/pulsekontrol/src/configuration"
+)
+
+// Ports of two identical nanoKONTROL2s, whose client numbers swap between
+// boots. Their inputs and outputs have the same names.
+var (
+	nanoA     = PortInfo{Name: "nanoKONTROL2:nanoKONTROL2 MIDI 1 28:0", Serial: "A", CardID: "nanoA"}
+	nanoB     = PortInfo{Name: "nanoKONTROL2:nanoKONTROL2 MIDI 1 32:0", Serial: "B", CardID: "nanoB"}
+	unknownIn = PortInfo{Name: "nanoKONTROL2:nanoKONTROL2 MIDI 1 36:0"}
+)
+
+func TestResolvePorts(t *testing.T) {
+	device := func(name string, port string, identity configuration.DeviceIdentity) configuration.DeviceConfig {
+		return configuration.DeviceConfig{Name: name, InPort: port, OutPort: port, Identity: identity}
+	}
+	for _, test := range []struct {
+		name    string
+		devices []configuration.DeviceConfig
+		ins     []PortInfo
+		outs    []PortInfo
+		want    map[string]DevicePorts
+	}{
+		{
+			name:    "single device by name",
+			devices: []configuration.DeviceConfig{device("left", nanoA.Name, configuration.DeviceIdentity{})},
+			ins:     []PortInfo{nanoA},
+			outs:    []PortInfo{nanoA},
+			want:    map[string]DevicePorts{"left": {InPort: nanoA.Name, OutPort: nanoA.Name, Serial: "A", Learned: true}},
+		},
+		{
+			name:    "port numbers changed",
+			devices: []configuration.DeviceConfig{device("left", "nanoKONTROL2:nanoKONTROL2 MIDI 1 20:0", configuration.DeviceIdentity{})},
+			ins:     []PortInfo{nanoB},
+			outs:    []PortInfo{nanoB},
+			want:    map[string]DevicePorts{"left": {InPort: nanoB.Name, OutPort: nanoB.Name, Serial: "B", Learned: true}},
+		},
+		{
+			name:    "identical devices without identity",
+			devices: []configuration.DeviceConfig{device("left", "nanoKONTROL2:nanoKONTROL2 MIDI 1", configuration.DeviceIdentity{})},
+			ins:     []PortInfo{nanoA, nanoB},
+			outs:    []PortInfo{nanoA, nanoB},
+			want:    map[string]DevicePorts{"left": {InPort: nanoA.Name, OutPort: nanoA.Name, Serial: "A", Learned: true, Ambiguous: true}},
+		},
+		{
+			name: "serials after the numbers swapped",
+			devices: []configuration.DeviceConfig{
+				device("left", nanoA.Name, configuration.DeviceIdentity{Serial: "B"}),
+				device("right", nanoB.Name, configuration.DeviceIdentity{Serial: "A"}),
+			},
+			ins:  []PortInfo{nanoA, nanoB},
+			outs: []PortInfo{nanoA, nanoB},
+			want: map[string]DevicePorts{
+				"left":  {InPort: nanoB.Name, OutPort: nanoB.Name, Serial: "B", Identity: true},
+				"right": {InPort: nanoA.Name, OutPort: nanoA.Name, Serial: "A", Identity: true},
+			},
+		},
+		{
+			name:    "card id",
+			devices: []configuration.DeviceConfig{device("left", nanoA.Name, configuration.DeviceIdentity{ID: "nanoB"})},
+			ins:     []PortInfo{nanoA, nanoB},
+			outs:    []PortInfo{nanoA, nanoB},
+			want:    map[string]DevicePorts{"left": {InPort: nanoB.Name, OutPort: nanoB.Name, Serial: "B", Identity: true, Learned: true}},
+		},
+		{
+			name: "identity first",
+			devices: []configuration.DeviceConfig{
+				// Listed first and named like the port of the other device
+				device("left", nanoB.Name, configuration.DeviceIdentity{}),
+				device("right", nanoA.Name, configuration.DeviceIdentity{Serial: "B"}),
+			},
+			ins:  []PortInfo{nanoA, nanoB},
+			outs: []PortInfo{nanoA, nanoB},
+			want: map[string]DevicePorts{
+				"left":  {InPort: nanoA.Name, OutPort: nanoA.Name, Serial: "A", Learned: true},
+				"right": {InPort: nanoB.Name, OutPort: nanoB.Name, Serial: "B", Identity: true},
+			},
+		},
+		{
+			name:    "output on the hardware of the input",
+			devices: []configuration.DeviceConfig{device("left", "nanoKONTROL2:nanoKONTROL2 MIDI 1", configuration.DeviceIdentity{Serial: "B"})},
+			ins:     []PortInfo{nanoA, nanoB},
+			outs:    []PortInfo{nanoA, nanoB},
+			want:    map[string]DevicePorts{"left": {InPort: nanoB.Name, OutPort: nanoB.Name, Serial: "B", Identity: true}},
+		},
+		{
+			name:    "identity of unplugged device",
+			devices: []configuration.DeviceConfig{device("left", nanoA.Name, configuration.DeviceIdentity{Serial: "C"})},
+			ins:     []PortInfo{nanoA, nanoB},
+			outs:    []PortInfo{nanoA, nanoB},
+			want:    map[string]DevicePorts{"left": {InPort: nanoA.Name, OutPort: nanoA.Name, Missing: true}},
+		},
+		{
+			name:    "hardware unknown",
+			devices: []configuration.DeviceConfig{device("left", unknownIn.Name, configuration.DeviceIdentity{Serial: "C"})},
+			ins:     []PortInfo{unknownIn},
+			outs:    []PortInfo{unknownIn},
+			want:    map[string]DevicePorts{"left": {InPort: unknownIn.Name, OutPort: unknownIn.Name}},
+		},
+		{
+			name: "two devices without identity",
+			devices: []configuration.DeviceConfig{
+				device("left", "nanoKONTROL2:nanoKONTROL2 MIDI 1", configuration.DeviceIdentity{}),
+				device("right", "nanoKONTROL2:nanoKONTROL2 MIDI 1", configuration.DeviceIdentity{}),
+			},
+			ins:  []PortInfo{nanoA, nanoB},
+			outs: []PortInfo{nanoA, nanoB},
+			want: map[string]DevicePorts{
+				"left":  {InPort: nanoA.Name, OutPort: nanoA.Name, Serial: "A", Learned: true, Ambiguous: true},
+				"right": {InPort: nanoB.Name, OutPort: nanoB.Name, Serial: "B", Learned: true},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got := resolvePorts(test.devices, test.ins, test.outs)
+			for name, want := range test.want {
+				if got[name] != want {
+					t.Errorf("%s resolved to %+v, want %+v", name, got[name], want)
+				}
+			}
+			if len(got) != len(test.want) {
+				t.Errorf("resolved %d devices, want %d", len(got), len(test.want))
+			}
+		})
+	}
+}
+
+func TestReadSeqPorts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients")
+	listing := `Client info
+  cur  clients : 4
+  peak clients : 4
+  max  clients : 192
+
+Client   0 : "System" [Kernel]
+  Port   0 : "Timer" (Rwe-)
+  Port   1 : "Announce" (R-e-)
+Client  28 : "nanoKONTROL2" [Kernel card=2]
+  Port   0 : "nanoKONTROL2 MIDI 1" (RWeX)
+Client  32 : "nanoKONTROL2" [Kernel]
+  Port   0 : "nanoKONTROL2 MIDI 1" (RWeX)
+Client 128 : "pulsekontrol" [User UMP MIDI1 pid=1234]
+  Port   0 : "in" (-We-)
+`
+	if err := os.WriteFile(path, []byte(listing), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ports := readSeqPorts(path)
+	want := []seqPort{
+		{client: 0, port: 0, card: -1, clientName: "System", name: "Timer"},
+		{client: 0, port: 1, card: -1, clientName: "System", name: "Announce"},
+		{client: 28, port: 0, card: 2, clientName: "nanoKONTROL2", name: "nanoKONTROL2 MIDI 1"},
+		// Without the card printed it follows from the client number
+		{client: 32, port: 0, card: 4, clientName: "nanoKONTROL2", name: "nanoKONTROL2 MIDI 1"},
+		{client: 128, port: 0, card: -1, clientName: "pulsekontrol", name: "in"},
+	}
+	if len(ports) != len(want) {
+		t.Fatalf("got ports %+v", ports)
+	}
+	for i := range want {
+		if ports[i] != want[i] {
+			t.Errorf("port %d is %+v, want %+v", i, ports[i], want[i])
+		}
+	}
+
+	// Driver names with numbers match by number, others by name
+	if !ports[3].matches(nanoB.Name) || ports[2].matches(nanoB.Name) {
+		t.Error("numbered port name matched the wrong client")
+	}
+	if !ports[2].matches("nanoKONTROL2:nanoKONTROL2 MIDI 1") {
+		t.Error("port name without numbers not matched")
+	}
+}
+
+func TestNormalizePortName(t *testing.T) {
+	if a, b := normalizePortName(nanoA.Name), normalizePortName(" nanoKONTROL2:nanoKONTROL2 MIDI 1 "); a != b {
+		t.Errorf("normalized to %q and %q", a, b)
+	}
+}
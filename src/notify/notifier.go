@@ -0,0 +1,218 @@
+// Package notify shows desktop notifications for the events a user should
+// know about without watching the log, like a disconnected controller,
+// through org.freedesktop.Notifications on the session bus.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/logging"
+	"github.com/godbus/dbus/v5"
+	"github.com/rs/zerolog"
+)
+
+const (
+	Name   = "org.freedesktop.Notifications" // Bus name of the notification server
+	Path   = dbus.ObjectPath("/org/freedesktop/Notifications")
+	method = Name + ".Notify"
+
+	appName = "pulsekontrol"
+	appIcon = "audio-card"
+	// expireDefault lets the notification server choose how long a notification is shown
+	expireDefault = int32(-1)
+	// callTimeout bounds a call to the notification server
+	callTimeout = 5 * time.Second
+)
+
+const (
+	// DefaultDelay is how long a topic has to be quiet before its last event is shown
+	DefaultDelay = 2 * time.Second
+	// maxDelay bounds how long a topic that keeps changing is held back
+	maxDelay = 15 * time.Second
+)
+
+// Severity of an event, shown as the urgency of its notification
+type Severity int
+
+const (
+	Info Severity = iota
+	Warning
+	Error
+)
+
+// urgency returns the urgency hint of the notification specification
+func (severity Severity) urgency() byte {
+	switch severity {
+	case Warning:
+		return 1
+	case Error:
+		return 2
+	}
+	return 0
+}
+
+// Event is something to notify about
+type Event struct {
+	Topic    string // Events of a topic replace each other, like "midi"
+	Severity Severity
+	Summary  string
+	Body     string
+	Recovery bool // Ends a failure of the topic, dropped if the failure was not shown
+}
+
+// failure reports whether the event starts a failure that a recovery ends
+func (event Event) failure() bool {
+	return !event.Recovery && event.Severity >= Warning
+}
+
+// Bus is the notification server, the object at Path on the session bus
+type Bus interface {
+	CallWithContext(ctx context.Context, method string, flags dbus.Flags, args ...interface{}) *dbus.Call
+}
+
+// topic is the state of the notifications of one topic
+type topic struct {
+	pending *Event
+	since   time.Time // When pending was first set
+	timer   *time.Timer
+	id      uint32 // Notification the next one replaces, 0 for none
+	failed  bool   // The last shown event was a failure
+	summary string // Summary of the last shown event
+}
+
+// Notifier shows events as desktop notifications. Events of a topic are held
+// back until the topic was quiet for the delay and then only the last one is
+// shown, so a flapping USB cable doesn't flood the desktop.
+type Notifier struct {
+	log   zerolog.Logger
+	bus   Bus
+	conn  *dbus.Conn // Closed by Stop, nil for a bus given to New
+	delay time.Duration
+
+	mu      sync.Mutex
+	topics  map[string]*topic
+	stopped bool
+}
+
+// New returns a notifier that calls the notification server on bus
+func New(bus Bus, delay time.Duration) *Notifier {
+	return &Notifier{
+		log:    logging.Module("Notifications"),
+		bus:    bus,
+		delay:  delay,
+		topics: make(map[string]*topic),
+	}
+}
+
+// Connect returns a notifier for the notification server of the session bus
+func Connect() (*Notifier, error) {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to the session bus: %w", err)
+	}
+	n := New(conn.Object(Name, Path), DefaultDelay)
+	n.conn = conn
+	return n, nil
+}
+
+// Post queues an event. It returns at once, the notification is shown later.
+func (n *Notifier) Post(event Event) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.stopped {
+		return
+	}
+	t, ok := n.topics[event.Topic]
+	if !ok {
+		t = &topic{}
+		n.topics[event.Topic] = t
+	}
+	now := time.Now()
+	if t.pending == nil {
+		t.since = now
+	}
+	t.pending = &event
+
+	delay := min(n.delay, t.since.Add(maxDelay).Sub(now))
+	if t.timer == nil {
+		t.timer = time.AfterFunc(delay, func() { n.flush(event.Topic) })
+	} else {
+		t.timer.Reset(delay)
+	}
+}
+
+// flush shows the pending event of a topic, unless it would repeat what the
+// user was last told
+func (n *Notifier) flush(name string) {
+	n.mu.Lock()
+	t := n.topics[name]
+	event := t.pending
+	t.pending = nil
+	if n.stopped || event == nil {
+		n.mu.Unlock()
+		return
+	}
+	if event.Recovery && !t.failed {
+		// The failure was over before it was shown
+		n.mu.Unlock()
+		n.log.Debug().Str("topic", name).Str("summary", event.Summary).Msg("Dropped recovery of a failure that was not shown")
+		return
+	}
+	if event.failure() && t.failed && event.Summary == t.summary {
+		n.mu.Unlock()
+		return
+	}
+	t.failed = event.failure()
+	t.summary = event.Summary
+	replaces := t.id
+	n.mu.Unlock()
+
+	id, err := n.show(*event, replaces)
+	if err != nil {
+		n.log.Warn().Err(err).Str("summary", event.Summary).Msg("Failed to show notification")
+		return
+	}
+	n.mu.Lock()
+	t.id = id
+	n.mu.Unlock()
+}
+
+// show calls the notification server and returns the id of the notification
+func (n *Notifier) show(event Event, replaces uint32) (uint32, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+	hints := map[string]dbus.Variant{
+		"urgency":       dbus.MakeVariant(event.Severity.urgency()),
+		"desktop-entry": dbus.MakeVariant(appName),
+	}
+	var id uint32
+	err := n.bus.CallWithContext(ctx, method, 0,
+		appName, replaces, appIcon, event.Summary, event.Body, []string{}, hints, expireDefault,
+	).Store(&id)
+	if err != nil {
+		return 0, err
+	}
+	n.log.Debug().Str("topic", event.Topic).Str("summary", event.Summary).Uint32("id", id).Msg("Showed notification")
+	return id, nil
+}
+
+// Stop drops the pending events and closes the bus connection of Connect
+func (n *Notifier) Stop() {
+	n.mu.Lock()
+	n.stopped = true
+	for _, t := range n.topics {
+		if t.timer != nil {
+			t.timer.Stop()
+		}
+		t.pending = nil
+	}
+	n.mu.Unlock()
+	if n.conn != nil {
+		if err := n.conn.Close(); err != nil {
+			n.log.Warn().Err(err).Msg("Failed to close bus connection")
+		}
+	}
+}
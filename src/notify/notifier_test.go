@@ -0,0 +1,244 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/internal/testutil"
+	"github.com/godbus/dbus/v5"
+)
+
+// testDelay is the quiet time of the notifiers of the tests
+const testDelay = 20 * time.Millisecond
+
+// shown is a notification the fake server was asked to show
+type shown struct {
+	replaces uint32
+	summary  string
+	body     string
+	urgency  byte
+}
+
+// fakeServer is a notification server recording what it shows
+type fakeServer struct {
+	mu     sync.Mutex
+	lastID uint32
+	err    error
+	shown  chan shown
+}
+
+func newFakeServer() *fakeServer {
+	return &fakeServer{shown: make(chan shown, 10)}
+}
+
+// Notify implements org.freedesktop.Notifications.Notify
+func (s *fakeServer) Notify(appName string, replaces uint32, appIcon string, summary string, body string, actions []string, hints map[string]dbus.Variant, expire int32) (uint32, *dbus.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return 0, dbus.MakeFailedError(s.err)
+	}
+	urgency, _ := hints["urgency"].Value().(byte)
+	s.shown <- shown{replaces: replaces, summary: summary, body: body, urgency: urgency}
+	if replaces != 0 {
+		return replaces, nil
+	}
+	s.lastID++
+	return s.lastID, nil
+}
+
+// CallWithContext calls Notify like the server on the bus would
+func (s *fakeServer) CallWithContext(ctx context.Context, method string, flags dbus.Flags, args ...interface{}) *dbus.Call {
+	if method != Name+".Notify" || len(args) != 8 {
+		return &dbus.Call{Err: errors.New("unknown method")}
+	}
+	id, err := s.Notify(args[0].(string), args[1].(uint32), args[2].(string), args[3].(string), args[4].(string), args[5].([]string), args[6].(map[string]dbus.Variant), args[7].(int32))
+	if err != nil {
+		return &dbus.Call{Err: err}
+	}
+	return &dbus.Call{Body: []interface{}{id}}
+}
+
+// next returns the next notification shown
+func (s *fakeServer) next(t *testing.T) shown {
+	t.Helper()
+	select {
+	case notification := <-s.shown:
+		return notification
+	case <-time.After(time.Second):
+		t.Fatal("nothing shown")
+		return shown{}
+	}
+}
+
+// none fails if a notification is shown within a few delays
+func (s *fakeServer) none(t *testing.T) {
+	t.Helper()
+	select {
+	case notification := <-s.shown:
+		t.Errorf("showed %+v", notification)
+	case <-time.After(5 * testDelay):
+	}
+}
+
+var (
+	lost     = Event{Topic: "midi", Severity: Error, Summary: "MIDI device disconnected"}
+	restored = Event{Topic: "midi", Severity: Info, Summary: "MIDI device connected", Recovery: true}
+)
+
+func TestFlappingIsDebounced(t *testing.T) {
+	server := newFakeServer()
+	n := New(server, testDelay)
+	defer n.Stop()
+
+	// Only the state the topic settles in is shown
+	for i := 0; i < 5; i++ {
+		n.Post(lost)
+		n.Post(restored)
+	}
+	n.Post(lost)
+	if notification := server.next(t); notification.summary != lost.Summary || notification.replaces != 0 {
+		t.Errorf("showed %+v", notification)
+	}
+	server.none(t)
+
+	// A recovery after a flap ending where it started is not shown either
+	n.Post(restored)
+	n.Post(lost)
+	server.none(t)
+
+	// The recovery replaces the failure
+	n.Post(restored)
+	if notification := server.next(t); notification.summary != restored.Summary || notification.replaces != 1 {
+		t.Errorf("showed %+v", notification)
+	}
+}
+
+func TestRecoveryOfUnshownFailure(t *testing.T) {
+	server := newFakeServer()
+	n := New(server, testDelay)
+	defer n.Stop()
+
+	n.Post(lost)
+	n.Post(restored)
+	server.none(t)
+
+	// Informational events are shown on their own
+	n.Post(Event{Topic: "profile", Summary: "Profile gaming"})
+	if notification := server.next(t); notification.summary != "Profile gaming" {
+		t.Errorf("showed %+v", notification)
+	}
+	n.Post(Event{Topic: "profile", Summary: "Profile gaming"})
+	if notification := server.next(t); notification.replaces != 1 {
+		t.Errorf("repeated profile switch showed %+v, want it to replace 1", notification)
+	}
+}
+
+func TestRepeatedFailureIsShownOnce(t *testing.T) {
+	server := newFakeServer()
+	n := New(server, testDelay)
+	defer n.Stop()
+
+	saveFailed := Event{Topic: "config", Severity: Error, Summary: "Configuration not saved", Body: "disk full"}
+	n.Post(saveFailed)
+	server.next(t)
+	n.Post(saveFailed)
+	server.none(t)
+
+	// Another failure of the topic is shown
+	n.Post(Event{Topic: "config", Severity: Warning, Summary: "Configuration is read-only"})
+	if notification := server.next(t); notification.summary != "Configuration is read-only" || notification.replaces != 1 {
+		t.Errorf("showed %+v", notification)
+	}
+}
+
+func TestSeverityIsUrgency(t *testing.T) {
+	server := newFakeServer()
+	n := New(server, testDelay)
+	defer n.Stop()
+
+	for severity, urgency := range map[Severity]byte{Info: 0, Warning: 1, Error: 2} {
+		n.Post(Event{Topic: "test", Severity: severity, Summary: "Event", Body: "Details"})
+		notification := server.next(t)
+		if notification.urgency != urgency || notification.body != "Details" {
+			t.Errorf("severity %d showed %+v, want urgency %d", severity, notification, urgency)
+		}
+		// A failure is only shown once
+		n.Post(Event{Topic: "test", Summary: "Reset", Recovery: true})
+		if severity != Info {
+			server.next(t)
+		}
+	}
+}
+
+func TestFailedCallIsDropped(t *testing.T) {
+	server := newFakeServer()
+	server.err = errors.New("no notification daemon")
+	n := New(server, testDelay)
+	defer n.Stop()
+
+	n.Post(Event{Topic: "profile", Summary: "Profile gaming"})
+	time.Sleep(5 * testDelay)
+	server.mu.Lock()
+	server.err = nil
+	server.mu.Unlock()
+	server.none(t)
+
+	n.Post(Event{Topic: "profile", Summary: "Profile music"})
+	if notification := server.next(t); notification.summary != "Profile music" || notification.replaces != 0 {
+		t.Errorf("showed %+v", notification)
+	}
+}
+
+func TestStopDropsPending(t *testing.T) {
+	server := newFakeServer()
+	n := New(server, testDelay)
+	n.Post(lost)
+	n.Stop()
+	n.Post(Event{Topic: "profile", Summary: "Profile gaming"})
+	server.none(t)
+}
+
+func TestConnect(t *testing.T) {
+	address := testutil.StartBus(t)
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", address)
+
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	server := newFakeServer()
+	if err := conn.ExportMethodTable(map[string]interface{}{"Notify": server.Notify}, Path, Name); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := conn.RequestName(Name, dbus.NameFlagDoNotQueue); err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("failed to own %s: %v", Name, err)
+	}
+
+	n, err := Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.delay = testDelay
+	n.Post(lost)
+	if notification := server.next(t); notification.summary != lost.Summary || notification.urgency != 2 {
+		t.Errorf("showed %+v", notification)
+	}
+	n.Post(restored)
+	if notification := server.next(t); notification.replaces != 1 {
+		t.Errorf("recovery showed %+v, want it to replace 1", notification)
+	}
+	n.Stop()
+	if n.conn.Connected() {
+		t.Error("bus connection left open")
+	}
+
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", "unix:path=/nonexistent/bus")
+	if _, err := Connect(); err == nil {
+		t.Error("connected without a session bus")
+	}
+}
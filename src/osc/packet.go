@@ -0,0 +1,188 @@
+package osc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// maxBundleDepth limits nested bundles, so a crafted packet can't recurse deeply
+const maxBundleDepth = 8
+
+var errTruncated = errors.New("truncated packet")
+
+// Message is an OSC message. Arguments are float32, int32, float64, string
+// or bool values.
+type Message struct {
+	Address   string
+	Arguments []interface{}
+}
+
+// Parse parses a packet, a message or a bundle of them, and returns its messages
+func Parse(packet []byte) ([]Message, error) {
+	return parsePacket(packet, 0)
+}
+
+func parsePacket(packet []byte, depth int) ([]Message, error) {
+	if len(packet) == 0 || len(packet)%4 != 0 {
+		return nil, fmt.Errorf("packet size %d is not a multiple of 4", len(packet))
+	}
+	if bytes.HasPrefix(packet, []byte("#bundle\x00")) {
+		return parseBundle(packet, depth)
+	}
+	message, err := parseMessage(packet)
+	if err != nil {
+		return nil, err
+	}
+	return []Message{message}, nil
+}
+
+// parseBundle parses the elements of a bundle, ignoring its time tag
+func parseBundle(packet []byte, depth int) ([]Message, error) {
+	if depth >= maxBundleDepth {
+		return nil, fmt.Errorf("bundles nested deeper than %d", maxBundleDepth)
+	}
+	// #bundle\0 and the 8 byte time tag
+	rest := packet[8:]
+	if len(rest) < 8 {
+		return nil, errTruncated
+	}
+	rest = rest[8:]
+
+	var messages []Message
+	for len(rest) > 0 {
+		if len(rest) < 4 {
+			return nil, errTruncated
+		}
+		size := binary.BigEndian.Uint32(rest)
+		rest = rest[4:]
+		if uint64(size) > uint64(len(rest)) {
+			return nil, errTruncated
+		}
+		elements, err := parsePacket(rest[:size], depth+1)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, elements...)
+		rest = rest[size:]
+	}
+	return messages, nil
+}
+
+func parseMessage(packet []byte) (Message, error) {
+	address, rest, err := readString(packet)
+	if err != nil {
+		return Message{}, err
+	}
+	if !strings.HasPrefix(address, "/") {
+		return Message{}, fmt.Errorf("invalid address %q", address)
+	}
+	message := Message{Address: address}
+	// Old implementations send messages without a type tag string
+	if len(rest) == 0 {
+		return message, nil
+	}
+	tags, rest, err := readString(rest)
+	if err != nil {
+		return Message{}, err
+	}
+	if !strings.HasPrefix(tags, ",") {
+		return Message{}, fmt.Errorf("invalid type tags %q", tags)
+	}
+
+	for _, tag := range tags[1:] {
+		switch tag {
+		case 'f':
+			if len(rest) < 4 {
+				return Message{}, errTruncated
+			}
+			message.Arguments = append(message.Arguments, math.Float32frombits(binary.BigEndian.Uint32(rest)))
+			rest = rest[4:]
+		case 'i':
+			if len(rest) < 4 {
+				return Message{}, errTruncated
+			}
+			message.Arguments = append(message.Arguments, int32(binary.BigEndian.Uint32(rest)))
+			rest = rest[4:]
+		case 'd':
+			if len(rest) < 8 {
+				return Message{}, errTruncated
+			}
+			message.Arguments = append(message.Arguments, math.Float64frombits(binary.BigEndian.Uint64(rest)))
+			rest = rest[8:]
+		case 's':
+			var value string
+			value, rest, err = readString(rest)
+			if err != nil {
+				return Message{}, err
+			}
+			message.Arguments = append(message.Arguments, value)
+		case 'T':
+			message.Arguments = append(message.Arguments, true)
+		case 'F':
+			message.Arguments = append(message.Arguments, false)
+		default:
+			// The size of unknown types is unknown, so nothing after them can be read
+			return Message{}, fmt.Errorf("unsupported argument type %q", tag)
+		}
+	}
+	return message, nil
+}
+
+// readString reads a NUL terminated string padded to a multiple of 4 bytes
+func readString(data []byte) (string, []byte, error) {
+	end := bytes.IndexByte(data, 0)
+	if end < 0 {
+		return "", nil, errTruncated
+	}
+	size := (end + 4) &^ 3
+	if size > len(data) {
+		return "", nil, errTruncated
+	}
+	return string(data[:end]), data[size:], nil
+}
+
+// Marshal encodes a message. Arguments of other types than those of Message
+// are an error.
+func (message Message) Marshal() ([]byte, error) {
+	var buffer bytes.Buffer
+	writeString(&buffer, message.Address)
+	tags := []byte{','}
+	var arguments bytes.Buffer
+	for _, argument := range message.Arguments {
+		switch value := argument.(type) {
+		case float32:
+			tags = append(tags, 'f')
+			binary.Write(&arguments, binary.BigEndian, value)
+		case int32:
+			tags = append(tags, 'i')
+			binary.Write(&arguments, binary.BigEndian, value)
+		case float64:
+			tags = append(tags, 'd')
+			binary.Write(&arguments, binary.BigEndian, value)
+		case string:
+			tags = append(tags, 's')
+			writeString(&arguments, value)
+		case bool:
+			if value {
+				tags = append(tags, 'T')
+			} else {
+				tags = append(tags, 'F')
+			}
+		default:
+			return nil, fmt.Errorf("unsupported argument %T", argument)
+		}
+	}
+	writeString(&buffer, string(tags))
+	buffer.Write(arguments.Bytes())
+	return buffer.Bytes(), nil
+}
+
+func writeString(buffer *bytes.Buffer, value string) {
+	buffer.WriteString(value)
+	// At least one NUL, padded to a multiple of 4
+	buffer.Write(make([]byte, 4-len(value)%4))
+}
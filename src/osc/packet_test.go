@@ -0,0 +1,163 @@
+package osc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// bundle encodes messages as a bundle, each element prefixed by its size
+func bundle(t *testing.T, elements ...[]byte) []byte {
+	t.Helper()
+	var buffer bytes.Buffer
+	buffer.WriteString("#bundle\x00")
+	buffer.Write([]byte{0, 0, 0, 0, 0, 0, 0, 1}) // Immediately
+	for _, element := range elements {
+		binary.Write(&buffer, binary.BigEndian, uint32(len(element)))
+		buffer.Write(element)
+	}
+	return buffer.Bytes()
+}
+
+// marshal encodes a message, failing the test on errors
+func marshal(t *testing.T, message Message) []byte {
+	t.Helper()
+	packet, err := message.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packet
+}
+
+func TestMarshalParseRoundTrip(t *testing.T) {
+	for _, message := range []Message{
+		{Address: "/pulsekontrol/slider/3", Arguments: []interface{}{float32(0.5)}},
+		{Address: "/pulsekontrol/knob/1", Arguments: []interface{}{int32(-70)}},
+		{Address: "/a", Arguments: []interface{}{"abc", "abcd", "", float64(0.25), true, false}},
+		{Address: "/pulsekontrol/button/group2/mute"},
+	} {
+		packet := marshal(t, message)
+		if len(packet)%4 != 0 {
+			t.Errorf("%s encoded in %d bytes", message.Address, len(packet))
+		}
+		messages, err := Parse(packet)
+		if err != nil {
+			t.Errorf("%s: %v", message.Address, err)
+			continue
+		}
+		if len(messages) != 1 || messages[0].Address != message.Address || !reflect.DeepEqual(messages[0].Arguments, message.Arguments) {
+			t.Errorf("%+v parsed as %+v", message, messages)
+		}
+	}
+
+	if _, err := (Message{Address: "/a", Arguments: []interface{}{7}}).Marshal(); err == nil {
+		t.Error("int argument encoded")
+	}
+}
+
+func TestParseWithoutTypeTags(t *testing.T) {
+	messages, err := Parse([]byte("/play\x00\x00\x00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].Address != "/play" || len(messages[0].Arguments) != 0 {
+		t.Errorf("parsed as %+v", messages)
+	}
+}
+
+func TestParseBundle(t *testing.T) {
+	slider := marshal(t, Message{Address: "/pulsekontrol/slider/1", Arguments: []interface{}{float32(1)}})
+	button := marshal(t, Message{Address: "/pulsekontrol/button/play"})
+	messages, err := Parse(bundle(t, slider, bundle(t, button), bundle(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || messages[0].Address != "/pulsekontrol/slider/1" || messages[1].Address != "/pulsekontrol/button/play" {
+		t.Errorf("parsed as %+v", messages)
+	}
+
+	// Deeply nested bundles are refused
+	packet := button
+	for i := 0; i < maxBundleDepth; i++ {
+		packet = bundle(t, packet)
+	}
+	if _, err := Parse(packet); err != nil {
+		t.Errorf("bundle nested %d deep refused: %v", maxBundleDepth, err)
+	}
+	if _, err := Parse(bundle(t, packet)); err == nil {
+		t.Errorf("bundle nested %d deep parsed", maxBundleDepth+1)
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	valid := marshal(t, Message{Address: "/pulsekontrol/slider/3", Arguments: []interface{}{float32(0.5), "mute", float64(1)}})
+	for name, packet := range map[string][]byte{
+		"empty":               {},
+		"unaligned":           []byte("/a\x00"),
+		"no NUL":              []byte("/abc"),
+		"relative address":    []byte("a\x00\x00\x00,f\x00\x00\x00\x00\x00\x00"),
+		"tags without comma":  []byte("/a\x00\x00f\x00\x00\x00\x00\x00\x00\x00"),
+		"missing float":       []byte("/a\x00\x00,f\x00\x00"),
+		"missing int":         []byte("/a\x00\x00,ii\x00\x00\x00\x00\x00"),
+		"missing double":      []byte("/a\x00\x00,d\x00\x00\x00\x00\x00\x00"),
+		"unterminated string": []byte("/a\x00\x00,s\x00\x00abcd"),
+		"unknown type":        []byte("/a\x00\x00,b\x00\x00\x00\x00\x00\x04abcd"),
+		"short bundle":        []byte("#bundle\x00\x00\x00\x00\x00"),
+		"oversized element":   append(bundle(t), 0, 0, 1, 0),
+		"short element":       append(bundle(t), 0, 0, 0, 8, '/', 'a', 0, 0),
+		"truncated":           valid[:len(valid)-4],
+	} {
+		if messages, err := Parse(packet); err == nil {
+			t.Errorf("%s packet parsed as %+v", name, messages)
+		}
+	}
+}
+
+// TestParseGarbage parses corrupted and random packets, which must not
+// panic or hang
+func TestParseGarbage(t *testing.T) {
+	seeds := [][]byte{
+		marshal(t, Message{Address: "/pulsekontrol/slider/3", Arguments: []interface{}{float32(0.5), "mute", float64(1), int32(3), true}}),
+		bundle(t, marshal(t, Message{Address: "/b", Arguments: []interface{}{"x"}}), bundle(t)),
+	}
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		packet := bytes.Clone(seeds[i%len(seeds)])
+		switch i % 3 {
+		case 0:
+			// Flipped bytes
+			for n := random.Intn(4) + 1; n > 0; n-- {
+				packet[random.Intn(len(packet))] = byte(random.Intn(256))
+			}
+		case 1:
+			// Cut anywhere
+			packet = packet[:random.Intn(len(packet))]
+		case 2:
+			packet = make([]byte, random.Intn(16)*4)
+			random.Read(packet)
+			if random.Intn(2) == 0 {
+				packet = append([]byte("#bundle\x00"), packet...)
+			}
+		}
+		Parse(packet)
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	f.Add([]byte("/pulsekontrol/slider/3\x00\x00,f\x00\x00?\x00\x00\x00"))
+	f.Add([]byte("#bundle\x00\x00\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x08/a\x00\x00,\x00\x00\x00"))
+	f.Fuzz(func(t *testing.T, packet []byte) {
+		messages, err := Parse(packet)
+		if err != nil {
+			return
+		}
+		// Whatever parses encodes again
+		for _, message := range messages {
+			if _, err := message.Marshal(); err != nil {
+				t.Errorf("%+v not encoded: %v", message, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,274 @@
+// Package osc lets control surface apps like TouchOSC act as controllers. It
+// listens for OSC messages over UDP and sends control values back to the
+// last client, so two-way surfaces stay in sync.
+//
+// Sliders and knobs are /pulsekontrol/slider/3 or /pulsekontrol/knob/1 (by
+// the number in their id) with a float from 0 to 1 or an int from 0 to 100.
+// Buttons are /pulsekontrol/button/ followed by their lowercased control path,
+// like /pulsekontrol/button/group2/mute, pressed by a non-zero or missing
+// argument and released by zero. osc.addresses replaces these per control id.
+package osc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/0h41/pulsekontrol/src/actions"
+	"github.com/0h41/pulsekontrol/src/activity"
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/0h41/pulsekontrol/src/logging"
+	"github.com/0h41/pulsekontrol/src/supervise"
+	"github.com/rs/zerolog"
+)
+
+// Prefix is the start of the derived addresses
+const Prefix = "/pulsekontrol"
+
+// maxPacketSize is the largest UDP payload
+const maxPacketSize = 65535
+
+// ButtonHandler runs the actions of a button for a press or release, like
+// the MIDI client does for its buttons
+type ButtonHandler func(origin activity.Origin, button configuration.ButtonConfig, pressed bool)
+
+// control is a slider, knob or button an address stands for
+type control struct {
+	controlType string
+	id          string
+}
+
+// Server receives OSC messages and applies them to the controls
+type Server struct {
+	log           zerolog.Logger
+	address       string
+	configManager *configuration.ConfigManager
+	executor      *actions.Executor
+	buttons       ButtonHandler
+
+	conn         *net.UDPConn
+	subscription configuration.Subscription
+	done         chan struct{}
+
+	clientMutex sync.Mutex
+	client      *net.UDPAddr // Last sender, receives the feedback
+}
+
+// NewServer creates a server listening on address once started
+func NewServer(address string, configManager *configuration.ConfigManager, executor *actions.Executor, buttons ButtonHandler) *Server {
+	return &Server{
+		log:           logging.Module("OSC"),
+		address:       address,
+		configManager: configManager,
+		executor:      executor,
+		buttons:       buttons,
+	}
+}
+
+// Start listens for messages until Stop
+func (s *Server) Start() error {
+	udpAddr, err := net.ResolveUDPAddr("udp", s.address)
+	if err != nil {
+		return fmt.Errorf("invalid OSC address %s: %w", s.address, err)
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return fmt.Errorf("cannot listen for OSC on %s: %w", s.address, err)
+	}
+	s.conn = conn
+	s.done = make(chan struct{})
+	s.subscription = s.configManager.Subscribe("control.value.updated", s.sendFeedback)
+	supervise.Go("osc.receive", func() {
+		s.receive()
+		close(s.done)
+	})
+	s.log.Info().Str("address", conn.LocalAddr().String()).Msg("Listening for OSC messages")
+	return nil
+}
+
+// Stop closes the socket and waits for the receiving to end
+func (s *Server) Stop() {
+	if s.conn == nil {
+		return
+	}
+	s.configManager.Unsubscribe(s.subscription)
+	s.conn.Close()
+	<-s.done
+}
+
+func (s *Server) receive() {
+	buffer := make([]byte, maxPacketSize)
+	for {
+		n, sender, err := s.conn.ReadFromUDP(buffer)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.log.Error().Err(err).Msg("Failed to receive OSC message")
+			}
+			return
+		}
+		messages, err := Parse(buffer[:n])
+		if err != nil {
+			s.log.Debug().Err(err).Str("client", sender.String()).Msg("Ignoring malformed OSC packet")
+			continue
+		}
+		s.clientMutex.Lock()
+		s.client = sender
+		s.clientMutex.Unlock()
+		for _, message := range messages {
+			s.handle(activity.OSC(sender.String()), message)
+		}
+	}
+}
+
+// handle applies a message to the control its address stands for
+func (s *Server) handle(origin activity.Origin, message Message) {
+	config := s.configManager.GetConfigSnapshot()
+	target, ok := resolve(&config, message.Address)
+	if !ok {
+		s.log.Debug().Str("address", message.Address).Msg("No control for OSC address")
+		return
+	}
+
+	if target.controlType == "button" {
+		pressed := true
+		if len(message.Arguments) > 0 {
+			value, ok := number(message.Arguments[0])
+			if !ok {
+				s.log.Debug().Str("address", message.Address).Msg("OSC button argument is not a number")
+				return
+			}
+			pressed = value != 0
+		}
+		s.buttons(origin, config.Controls.Buttons[target.id], pressed)
+		return
+	}
+
+	if len(message.Arguments) == 0 {
+		s.log.Debug().Str("address", message.Address).Msg("OSC control message without a value")
+		return
+	}
+	value, ok := controlValue(message.Arguments[0])
+	if !ok {
+		s.log.Debug().Str("address", message.Address).Interface("argument", message.Arguments[0]).Msg("OSC control value is not a number")
+		return
+	}
+	s.executor.ApplyControlValue(origin, target.controlType, target.id, value)
+}
+
+// number returns a numeric or boolean argument as a float
+func number(argument interface{}) (float64, bool) {
+	switch value := argument.(type) {
+	case float32:
+		return float64(value), true
+	case float64:
+		return value, true
+	case int32:
+		return float64(value), true
+	case bool:
+		if value {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// controlValue returns the value (0-100) of an argument, a float from 0 to 1
+// or an int from 0 to 100
+func controlValue(argument interface{}) (int, bool) {
+	var value float64
+	switch argument := argument.(type) {
+	case float32:
+		value = float64(argument) * 100
+	case float64:
+		value = argument * 100
+	case int32:
+		value = float64(argument)
+	default:
+		return 0, false
+	}
+	if value != value { // NaN
+		return 0, false
+	}
+	return int(min(max(value, 0), 100) + 0.5), true
+}
+
+// sendFeedback sends a changed slider or knob value to the last client,
+// unless the change came from it
+func (s *Server) sendFeedback(data interface{}) {
+	update, ok := data.(map[string]interface{})
+	if !ok {
+		return
+	}
+	controlType, _ := update["type"].(string)
+	controlId, _ := update["id"].(string)
+	value, ok := update["value"].(int)
+	if !ok {
+		return
+	}
+
+	s.clientMutex.Lock()
+	client := s.client
+	s.clientMutex.Unlock()
+	if client == nil {
+		return
+	}
+	if origin, _ := update["origin"].(activity.Origin); origin.Kind == activity.OriginOSC && origin.Client == client.String() {
+		return
+	}
+
+	config := s.configManager.GetConfigSnapshot()
+	message := Message{
+		Address:   Address(&config, controlType, controlId),
+		Arguments: []interface{}{float32(value) / 100},
+	}
+	packet, err := message.Marshal()
+	if err != nil {
+		s.log.Error().Err(err).Msg("Failed to encode OSC feedback")
+		return
+	}
+	if _, err := s.conn.WriteToUDP(packet, client); err != nil {
+		s.log.Debug().Err(err).Str("client", client.String()).Msg("Failed to send OSC feedback")
+	}
+}
+
+// Address returns the OSC address of a slider, knob or button
+func Address(config *configuration.Config, controlType string, controlId string) string {
+	if address, ok := config.OSC.Addresses[controlId]; ok {
+		return address
+	}
+	if controlType == "button" {
+		if button, ok := config.Controls.Buttons[controlId]; ok && button.Path != "" {
+			return Prefix + "/button/" + strings.ToLower(button.Path)
+		}
+		return Prefix + "/button/" + controlId
+	}
+	// slider3 is /pulsekontrol/slider/3, other ids are used as they are
+	number := strings.TrimPrefix(controlId, controlType)
+	if number == controlId || number == "" || strings.Trim(number, "0123456789") != "" {
+		number = controlId
+	}
+	return Prefix + "/" + controlType + "/" + number
+}
+
+// resolve returns the control an OSC address stands for
+func resolve(config *configuration.Config, address string) (control, bool) {
+	for id := range config.Controls.Sliders {
+		if Address(config, "slider", id) == address {
+			return control{controlType: "slider", id: id}, true
+		}
+	}
+	for id := range config.Controls.Knobs {
+		if Address(config, "knob", id) == address {
+			return control{controlType: "knob", id: id}, true
+		}
+	}
+	for id := range config.Controls.Buttons {
+		if Address(config, "button", id) == address {
+			return control{controlType: "button", id: id}, true
+		}
+	}
+	return control{}, false
+}
@@ -0,0 +1,264 @@
+package osc
+
+import (
+	"math"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/actions"
+	"github.com/0h41/pulsekontrol/src/activity"
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/0h41/pulsekontrol/src/internal/testutil"
+)
+
+// testConfig returns the default configuration with a few extra controls
+func testConfig() configuration.Config {
+	config := configuration.GetDefaultConfig()
+	config.Controls.Sliders["master"] = configuration.SliderConfig{}
+	config.Controls.Knobs["knob2b"] = configuration.KnobConfig{}
+	config.Controls.Buttons["custom"] = configuration.ButtonConfig{}
+	config.OSC.Addresses = map[string]string{"slider8": "/1/fader8", "play": "/transport/play"}
+	return config
+}
+
+func TestAddress(t *testing.T) {
+	config := testConfig()
+	for _, test := range []struct {
+		controlType string
+		id          string
+		address     string
+	}{
+		{"slider", "slider3", "/pulsekontrol/slider/3"},
+		{"slider", "master", "/pulsekontrol/slider/master"},
+		{"knob", "knob1", "/pulsekontrol/knob/1"},
+		{"knob", "knob2b", "/pulsekontrol/knob/knob2b"},
+		{"button", "mute2", "/pulsekontrol/button/group2/mute"},
+		{"button", "custom", "/pulsekontrol/button/custom"},
+		{"slider", "slider8", "/1/fader8"},
+		{"button", "play", "/transport/play"},
+	} {
+		address := Address(&config, test.controlType, test.id)
+		if address != test.address {
+			t.Errorf("%s has address %s, want %s", test.id, address, test.address)
+		}
+		if target, ok := resolve(&config, address); !ok || target != (control{test.controlType, test.id}) {
+			t.Errorf("%s resolves to %+v", address, target)
+		}
+	}
+
+	// Replaced addresses are gone
+	for _, address := range []string{"/pulsekontrol/slider/8", "/pulsekontrol/button/transport/play", "/pulsekontrol/slider/99", "/other"} {
+		if target, ok := resolve(&config, address); ok {
+			t.Errorf("%s resolves to %+v", address, target)
+		}
+	}
+}
+
+func TestControlValue(t *testing.T) {
+	for _, test := range []struct {
+		argument interface{}
+		value    int
+		ok       bool
+	}{
+		{float32(0.5), 50, true},
+		{float32(0.004), 0, true},
+		{float32(0.996), 100, true},
+		{float64(1.5), 100, true},
+		{float32(-1), 0, true},
+		{int32(70), 70, true},
+		{int32(200), 100, true},
+		{float32(math.NaN()), 0, false},
+		{"50", 0, false},
+		{true, 0, false},
+	} {
+		value, ok := controlValue(test.argument)
+		if value != test.value || ok != test.ok {
+			t.Errorf("%#v is value %d, %v, want %d, %v", test.argument, value, ok, test.value, test.ok)
+		}
+	}
+}
+
+// buttonPress is a button handled by the server
+type buttonPress struct {
+	origin  activity.Origin
+	button  configuration.ButtonConfig
+	pressed bool
+}
+
+// startServer starts a server on a free local port with Firefox assigned to
+// slider1, and returns a client connected to it
+func startServer(t *testing.T) (*net.UDPConn, *configuration.ConfigManager, *testutil.FakeBackend, chan buttonPress) {
+	t.Helper()
+	config := testConfig()
+	slider := config.Controls.Sliders["slider1"]
+	slider.Sources = []configuration.Source{{Type: configuration.PlaybackStream, Name: "Firefox"}}
+	config.Controls.Sliders["slider1"] = slider
+	cm := configuration.NewConfigManager(config, filepath.Join(t.TempDir(), "config.yaml"))
+	cm.SetReadOnly(true)
+	backend := testutil.NewFakeBackend(testutil.FakeStream{Type: configuration.PlaybackStream, Name: "Firefox"})
+	executor := actions.NewExecutor(backend, cm, activity.NewLog(10))
+	t.Cleanup(executor.Close)
+
+	presses := make(chan buttonPress, 4)
+	server := NewServer("127.0.0.1:0", cm, executor, func(origin activity.Origin, button configuration.ButtonConfig, pressed bool) {
+		presses <- buttonPress{origin, button, pressed}
+	})
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Stop)
+
+	client, err := net.DialUDP("udp", nil, server.conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, cm, backend, presses
+}
+
+// send sends a message to the server
+func send(t *testing.T, client *net.UDPConn, address string, arguments ...interface{}) {
+	t.Helper()
+	if _, err := client.Write(marshal(t, Message{Address: address, Arguments: arguments})); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitValue waits until a slider has a value
+func waitValue(t *testing.T, cm *configuration.ConfigManager, id string, value int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for cm.GetConfigSnapshot().Controls.Sliders[id].Value != value {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s is %d, want %d", id, cm.GetConfigSnapshot().Controls.Sliders[id].Value, value)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// receive returns the next message the server sends to the client
+func receive(t *testing.T, client *net.UDPConn) Message {
+	t.Helper()
+	buffer := make([]byte, maxPacketSize)
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := client.Read(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages, err := Parse(buffer[:n])
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("received %q: %v", buffer[:n], err)
+	}
+	return messages[0]
+}
+
+func TestServerAppliesMessages(t *testing.T) {
+	client, cm, backend, presses := startServer(t)
+
+	send(t, client, "/pulsekontrol/slider/1", float32(0.4))
+	waitValue(t, cm, "slider1", 40)
+	if firefox, _ := backend.Stream(configuration.PlaybackStream, "Firefox"); firefox.Volume < 0.395 || firefox.Volume > 0.405 {
+		t.Errorf("Firefox at volume %.2f, want 0.4", firefox.Volume)
+	}
+	send(t, client, "/1/fader8", int32(65))
+	waitValue(t, cm, "slider8", 65)
+
+	// Malformed packets and unknown addresses are skipped
+	client.Write([]byte("garbage"))
+	send(t, client, "/pulsekontrol/slider/99", float32(1))
+	send(t, client, "/pulsekontrol/slider/1", "loud")
+	send(t, client, "/pulsekontrol/slider/1")
+	send(t, client, "/pulsekontrol/slider/1", float32(0.5))
+	waitValue(t, cm, "slider1", 50)
+
+	for _, test := range []struct {
+		arguments []interface{}
+		pressed   bool
+	}{
+		{nil, true},
+		{[]interface{}{float32(1)}, true},
+		{[]interface{}{float32(0)}, false},
+		{[]interface{}{false}, false},
+	} {
+		send(t, client, "/pulsekontrol/button/group2/mute", test.arguments...)
+		select {
+		case press := <-presses:
+			if press.button.Path != "Group2/Mute" || press.pressed != test.pressed || press.origin.Kind != activity.OriginOSC || press.origin.Client != client.LocalAddr().String() {
+				t.Errorf("%v handled as %+v", test.arguments, press)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("button %v not handled", test.arguments)
+		}
+	}
+	send(t, client, "/pulsekontrol/button/group2/mute", "on")
+	send(t, client, "/pulsekontrol/slider/1", float32(0.6))
+	waitValue(t, cm, "slider1", 60)
+	select {
+	case press := <-presses:
+		t.Errorf("button with a string argument handled as %+v", press)
+	default:
+	}
+}
+
+func TestServerSendsFeedback(t *testing.T) {
+	client, cm, _, _ := startServer(t)
+
+	// Nothing is sent before a client is known. Subscribers are called in
+	// order, the server has seen the change once a later one did.
+	delivered := make(chan struct{})
+	subscription := cm.Subscribe("control.value.updated", func(data interface{}) {
+		if data.(map[string]interface{})["id"] == "slider2" {
+			close(delivered)
+		}
+	})
+	cm.UpdateControlValue(activity.Midi(), "slider", "slider2", 10)
+	select {
+	case <-delivered:
+	case <-time.After(time.Second):
+		t.Fatal("change not notified")
+	}
+	cm.Unsubscribe(subscription)
+	send(t, client, "/pulsekontrol/slider/1", float32(0.4))
+	waitValue(t, cm, "slider1", 40)
+
+	// Changes from elsewhere are sent back, those of the client aren't
+	cm.UpdateControlValue(activity.Midi(), "slider", "slider8", 30)
+	message := receive(t, client)
+	if message.Address != "/1/fader8" || len(message.Arguments) != 1 || message.Arguments[0] != float32(0.3) {
+		t.Errorf("received %+v, want /1/fader8 at 0.3", message)
+	}
+	cm.UpdateControlValue(activity.Web("browser"), "knob", "knob1", 100)
+	if message := receive(t, client); message.Address != "/pulsekontrol/knob/1" || message.Arguments[0] != float32(1) {
+		t.Errorf("received %+v, want /pulsekontrol/knob/1 at 1", message)
+	}
+
+	client.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if n, err := client.Read(make([]byte, maxPacketSize)); err == nil {
+		t.Errorf("received %d more bytes", n)
+	}
+}
+
+func TestStopClosesSocket(t *testing.T) {
+	cm := configuration.NewConfigManager(testConfig(), filepath.Join(t.TempDir(), "config.yaml"))
+	cm.SetReadOnly(true)
+	server := NewServer("127.0.0.1:0", cm, nil, nil)
+	// Stopping a server that never started does nothing
+	server.Stop()
+
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	address := server.conn.LocalAddr().String()
+	server.Stop()
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		t.Fatalf("port still in use after Stop: %v", err)
+	}
+	conn.Close()
+
+	if err := NewServer("127.0.0.1:99999", cm, nil, nil).Start(); err == nil {
+		t.Error("started on an invalid port")
+	}
+}
@@ -0,0 +1,83 @@
+package pulseaudio
+
+import (
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/configuration"
+)
+
+// Backend is the audio system the rest of pulsekontrol controls. PAClient
+// is the one for PulseAudio; tests use a fake one instead.
+type Backend interface {
+	// Ping fails if the audio system does not respond
+	Ping() error
+	// Refresh reconnects if the connection was lost and reloads the streams
+	Refresh() error
+	SetDryRun(dryRun bool)
+	// SetTimeout sets how long operations may take, see TimeoutError
+	SetTimeout(timeout time.Duration, reconnectAfter int)
+	// SetMeterInterval sets how often meters report levels
+	SetMeterInterval(interval time.Duration)
+	// SetExcludes sets the streams and devices to leave out
+	SetExcludes(rules []configuration.ExcludeRule)
+	DryRun() bool
+
+	// Streams and devices
+	GetAudioSources() []AudioSource
+	GetFocusedWindowPlaybackStreams() ([]Stream, error)
+	SmartMatchStreams(sourceType configuration.PulseAudioTargetType, sourceName string) ([]Stream, *Stream)
+	SelectTargets(selector Selector) ([]configuration.TypedTarget, error)
+	MatchTarget(target *configuration.TypedTarget) []Stream
+	// CachedStreams returns what the last update saw, without a request
+	CachedStreams() []CachedStream
+
+	// Changes
+	ProcessVolumeAction(action configuration.Action, volumePercent float32) error
+	GetTargetVolume(target *configuration.TypedTarget) (float32, bool)
+	SetTargetMute(target *configuration.TypedTarget, muted bool) error
+	// SetTargetBalance sets the balance from -1 (left) to 1 (right), keeping the volume
+	SetTargetBalance(target *configuration.TypedTarget, balance float64) error
+	SetDefaultOutput(action configuration.Action) error
+	SetDefaultInput(action configuration.Action) error
+	// MoveStreams moves the playback streams matching a target to an output device
+	MoveStreams(target *configuration.TypedTarget, sink string) error
+	// SetOutputSuspended suspends the output device with a name or resumes it
+	SetOutputSuspended(name string, suspended bool) error
+	// GetCards returns the sound cards with their profiles
+	GetCards() ([]Card, error)
+	// SetCardProfile switches the card with a name or description to a profile
+	SetCardProfile(card string, profile string) error
+	// LoopbackLoaded returns whether an input device plays on an output device
+	LoopbackLoaded(source string, sink string) (bool, error)
+	LoadLoopback(source string, sink string, latency time.Duration) error
+	UnloadLoopback(source string, sink string) error
+	// EchoCancelLoaded returns whether the echo of an output device is
+	// cancelled on an input device
+	EchoCancelLoaded(source string, sink string) (bool, error)
+	LoadEchoCancel(source string, sink string, setDefault bool) error
+	UnloadEchoCancel(source string, sink string, setDefault bool) error
+	// SyncCombinedSinks creates and removes combined output devices to match
+	// the configured ones
+	SyncCombinedSinks(sinks map[string]configuration.CombinedSinkConfig) error
+	// SyncVirtualSinks creates and removes the virtual output devices of the
+	// controls to match the names, returning the ones it created
+	SyncVirtualSinks(names []string) ([]string, error)
+	ProcessMediaControlAction(action configuration.Action) error
+	IsMediaPlaying() bool
+
+	// Events
+	SetNewStreamCallback(callback StreamEventCallback)
+	SetRemovedStreamCallback(callback StreamEventCallback)
+	SetMediaStatusCallback(callback MediaStatusCallback)
+	SetSourceSeenCallback(callback SourceSeenCallback)
+	SetEventHandler(handler EventHandler)
+	StartStreamMonitoring() error
+	StopStreamMonitoring()
+	// Monitoring returns whether changes of the streams are reported
+	Monitoring() bool
+	StartMediaStatusMonitoring() error
+	// Meter reports the levels of a stream or device until stopped
+	Meter(target *configuration.TypedTarget, callback LevelCallback) (func(), error)
+}
+
+var _ Backend = (*PAClient)(nil)
@@ -0,0 +1,167 @@
+package pulseaudio
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/the-jonsey/pulseaudio"
+)
+
+// Positions of PulseAudio's channel maps on the left and on the right, see
+// pa_channel_position_t. Others like the center and LFE keep their volume.
+var (
+	paLeftPositions  = map[byte]bool{1: true, 5: true, 8: true, 10: true, 45: true, 48: true}
+	paRightPositions = map[byte]bool{2: true, 6: true, 9: true, 11: true, 46: true, 49: true}
+)
+
+// Positions of PipeWire's channel maps on the left and on the right
+var (
+	pipeWireLeftPositions  = map[string]bool{"FL": true, "RL": true, "FLC": true, "SL": true, "TFL": true, "TRL": true}
+	pipeWireRightPositions = map[string]bool{"FR": true, "RR": true, "FRC": true, "SR": true, "TFR": true, "TRR": true}
+)
+
+// channelSide returns -1 for a channel on the left, 1 for one on the right
+// and 0 for the others
+func channelSide(left bool, right bool) int {
+	switch {
+	case left:
+		return -1
+	case right:
+		return 1
+	}
+	return 0
+}
+
+// balanceChannels returns the volumes of the channels of a stream with a
+// balance from -1 (only left) to 1 (only right), like pa_cvolume_set_balance:
+// the louder side gets the volume of the loudest channel, the other side is
+// lowered by the balance. Streams without both sides, like mono ones, have
+// no balance and return false.
+func balanceChannels(volumes []float64, sides []int, balance float64) ([]float64, bool) {
+	loudest := 0.0
+	hasLeft, hasRight := false, false
+	for i, volume := range volumes {
+		loudest = max(loudest, volume)
+		hasLeft = hasLeft || sides[i] < 0
+		hasRight = hasRight || sides[i] > 0
+	}
+	if !hasLeft || !hasRight {
+		return nil, false
+	}
+	balanced := slices.Clone(volumes)
+	balance = min(max(balance, -1), 1)
+	left, right := loudest, loudest
+	if balance > 0 {
+		left = loudest * (1 - balance)
+	} else {
+		right = loudest * (1 + balance)
+	}
+	for i, side := range sides {
+		switch {
+		case side < 0:
+			balanced[i] = left
+		case side > 0:
+			balanced[i] = right
+		}
+	}
+	return balanced, true
+}
+
+// loudestChannel returns the volume of the loudest channel, the volume of a
+// stream whatever its balance
+func loudestChannel(volumes []uint32) float32 {
+	if len(volumes) == 0 {
+		return 0
+	}
+	return float32(math.Round(float64(slices.Max(volumes))/0xffff*100)) / 100
+}
+
+// SetTargetBalance sets the balance of the streams or devices matching a
+// target, from -1 (only left) over 0 (centered) to 1 (only right), keeping
+// their volume. Mono streams are left as they are.
+func (client *PAClient) SetTargetBalance(target *configuration.TypedTarget, balance float64) error {
+	if err := client.currentStreams(); err != nil {
+		return err
+	}
+	streams := client.matchTargetStreams(target)
+	if client.simulated("SetBalance", target.Name, streams, balance) {
+		return nil
+	}
+	for _, stream := range streams {
+		if err := client.callErr("SetBalance", stream.Name, func() error { return client.pa().SetBalance(stream, balance) }); err != nil {
+			return fmt.Errorf("failed to set balance of %s: %w", stream.Name, err)
+		}
+		client.log.Debug().Msgf("Set balance of %s to %.2f", stream.Name, balance)
+	}
+	return nil
+}
+
+func (s *pulseServer) SetBalance(stream Stream, balance float64) error {
+	// The pulseaudio library only sets all channels to the same volume
+	var command, id string
+	var positions []byte
+	var volumes []uint32
+	switch device := stream.paStream.(type) {
+	case pulseaudio.Sink:
+		command, id, positions, volumes = "set-sink-volume", device.Name, device.ChannelMap, device.Cvolume
+	case pulseaudio.Source:
+		command, id, positions, volumes = "set-source-volume", device.Name, device.ChannelMap, device.Cvolume
+	case pulseaudio.SinkInput:
+		command, id, positions, volumes = "set-sink-input-volume", strconv.FormatUint(uint64(device.Index), 10), device.ChannelMap, device.Cvolume
+	case pulseaudio.SourceOutput:
+		command, id, positions, volumes = "set-source-output-volume", strconv.FormatUint(uint64(device.Index), 10), device.ChannelMap, device.Cvolume
+	default:
+		return fmt.Errorf("%s has no channels", stream.Name)
+	}
+	current := make([]float64, len(volumes))
+	sides := make([]int, len(volumes))
+	for i, volume := range volumes {
+		current[i] = float64(volume)
+		if i < len(positions) {
+			sides[i] = channelSide(paLeftPositions[positions[i]], paRightPositions[positions[i]])
+		}
+	}
+	balanced, ok := balanceChannels(current, sides, balance)
+	if !ok {
+		return nil
+	}
+	args := []string{command, id}
+	for _, volume := range balanced {
+		// Raw volumes, 65536 is 100%
+		args = append(args, strconv.FormatUint(uint64(math.Round(volume)), 10))
+	}
+	_, err := runTool(s.timeout(), "pactl", args...)
+	return err
+}
+
+func (s *pipeWireServer) SetBalance(stream Stream, balance float64) error {
+	node, ok := stream.paStream.(*pipeWireNode)
+	if !ok {
+		return fmt.Errorf("%s is not a PipeWire node", stream.Name)
+	}
+	// Balanced on the cubic volumes like PulseAudio's
+	current := make([]float64, len(node.channels))
+	sides := make([]int, len(node.channels))
+	for i, volume := range node.channels {
+		current[i] = math.Cbrt(volume)
+		if i < len(node.positions) {
+			sides[i] = channelSide(pipeWireLeftPositions[node.positions[i]], pipeWireRightPositions[node.positions[i]])
+		}
+	}
+	balanced, ok := balanceChannels(current, sides, balance)
+	if !ok {
+		return nil
+	}
+	channelVolumes := ""
+	for i, volume := range balanced {
+		if i > 0 {
+			channelVolumes += ", "
+		}
+		channelVolumes += strconv.FormatFloat(volume*volume*volume, 'f', 6, 64)
+	}
+	_, err := runTool(s.timeout(), "pw-cli", "set-param", strconv.Itoa(node.id), "Props", fmt.Sprintf("{ channelVolumes: [ %s ] }", channelVolumes))
+	return err
+}
@@ -0,0 +1,159 @@
+package pulseaudio
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// Card is a sound card, a USB interface or a Bluetooth headset, whose
+// profile decides which outputs and inputs it offers
+type Card struct {
+	Name          string        `json:"name"`
+	Description   string        `json:"description"`
+	ActiveProfile string        `json:"activeProfile"`
+	Profiles      []CardProfile `json:"profiles"` // Highest priority first
+	id            int           // Index of PulseAudio, object id of PipeWire
+}
+
+// CardProfile is a profile of a card, like a2dp-sink or
+// headset-head-unit-msbc of a Bluetooth headset
+type CardProfile struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Available   bool   `json:"available"` // Unavailable ones can't be switched to
+	priority    int
+	index       int // Index of PipeWire's profiles
+}
+
+// Matches reports whether a card has a name or description
+func (card Card) Matches(name string) bool {
+	return card.Name == name || card.Description == name
+}
+
+// Profile returns the profile of a card with a name
+func (card Card) Profile(name string) (CardProfile, bool) {
+	index := slices.IndexFunc(card.Profiles, func(profile CardProfile) bool {
+		return profile.Name == name
+	})
+	if index < 0 {
+		return CardProfile{}, false
+	}
+	return card.Profiles[index], true
+}
+
+// sortProfiles orders the profiles of a card by priority
+func sortProfiles(profiles []CardProfile) {
+	slices.SortStableFunc(profiles, func(a, b CardProfile) int {
+		return cmp.Compare(b.priority, a.priority)
+	})
+}
+
+// GetCards returns the sound cards with their profiles
+func (client *PAClient) GetCards() ([]Card, error) {
+	return call(client, "Cards", "", client.pa().Cards)
+}
+
+// SetCardProfile switches the card with a name or description to a profile
+func (client *PAClient) SetCardProfile(name string, profile string) error {
+	cards, err := client.GetCards()
+	if err != nil {
+		return err
+	}
+	index := slices.IndexFunc(cards, func(card Card) bool {
+		return card.Matches(name)
+	})
+	if index < 0 {
+		return fmt.Errorf("no card %s", name)
+	}
+	card := cards[index]
+	target, ok := card.Profile(profile)
+	if !ok {
+		return fmt.Errorf("card %s has no profile %s", card.Description, profile)
+	}
+	if !target.Available {
+		return fmt.Errorf("profile %s of card %s is not available", profile, card.Description)
+	}
+	if client.simulated("SetCardProfile", name, nil, profile) {
+		return nil
+	}
+	if err := client.callErr("SetCardProfile", card.Name, func() error { return client.pa().SetCardProfile(card, target) }); err != nil {
+		return fmt.Errorf("failed to switch %s to %s: %w", card.Description, profile, err)
+	}
+	client.log.Debug().Msgf("Switched %s to profile %s", card.Description, profile)
+	return nil
+}
+
+func (s *pulseServer) Cards() ([]Card, error) {
+	paCards, err := s.client.Cards()
+	if err != nil {
+		return nil, err
+	}
+	cards := make([]Card, 0, len(paCards))
+	for _, paCard := range paCards {
+		card := Card{Name: paCard.Name, Description: paCard.PropList["device.description"], id: int(paCard.Index)}
+		if card.Description == "" {
+			card.Description = card.Name
+		}
+		if paCard.ActiveProfile != nil {
+			card.ActiveProfile = paCard.ActiveProfile.Name
+		}
+		for _, profile := range paCard.Profiles {
+			card.Profiles = append(card.Profiles, CardProfile{
+				Name:        profile.Name,
+				Description: profile.Description,
+				// PulseAudio only tells whether the profile is not unavailable
+				Available: profile.Available != 0,
+				priority:  int(profile.Priority),
+			})
+		}
+		sortProfiles(card.Profiles)
+		cards = append(cards, card)
+	}
+	return cards, nil
+}
+
+func (s *pulseServer) SetCardProfile(card Card, profile CardProfile) error {
+	return s.client.SetCardProfile(uint32(card.id), profile.Name)
+}
+
+func (s *pipeWireServer) Cards() ([]Card, error) {
+	objects, err := s.dump()
+	if err != nil {
+		return nil, err
+	}
+	var cards []Card
+	for _, object := range objects {
+		if object.Type != "PipeWire:Interface:Device" || object.Info == nil || property(object.Info.Props, "media.class") != "Audio/Device" {
+			continue
+		}
+		card := Card{
+			Name:        property(object.Info.Props, "device.name"),
+			Description: property(object.Info.Props, "device.description"),
+			id:          object.ID,
+		}
+		if card.Description == "" {
+			card.Description = card.Name
+		}
+		for _, profile := range object.Info.Params.EnumProfile {
+			card.Profiles = append(card.Profiles, CardProfile{
+				Name:        profile.Name,
+				Description: profile.Description,
+				Available:   profile.Available != "no",
+				priority:    profile.Priority,
+				index:       profile.Index,
+			})
+		}
+		for _, profile := range object.Info.Params.Profile {
+			card.ActiveProfile = profile.Name
+		}
+		sortProfiles(card.Profiles)
+		cards = append(cards, card)
+	}
+	return cards, nil
+}
+
+func (s *pipeWireServer) SetCardProfile(card Card, profile CardProfile) error {
+	return s.wpctl("set-profile", strconv.Itoa(card.id), strconv.Itoa(profile.index))
+}
@@ -0,0 +1,149 @@
+package pulseaudio
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/samber/lo"
+	"github.com/the-jonsey/pulseaudio"
+)
+
+// combinedSink is an output device pulsekontrol loaded that plays on others
+type combinedSink struct {
+	name    string   // Sink name, see configuration.CombinedSinkName
+	outputs []string // Full names of the output devices it plays on
+	id      int      // Module index
+}
+
+// SyncCombinedSinks creates the configured combined output devices that are
+// missing and removes the ones pulsekontrol created that are no longer
+// configured. One whose output devices changed, or came or went, is created
+// again. Missing output devices are left out, a combined output device
+// without any is not created.
+func (client *PAClient) SyncCombinedSinks(sinks map[string]configuration.CombinedSinkConfig) error {
+	if err := client.refreshStreams(); err != nil {
+		return err
+	}
+	cache := client.cache()
+	loaded, err := call(client, "CombinedSinks", "", client.pa().CombinedSinks)
+	if err != nil {
+		return err
+	}
+
+	wanted := make(map[string]combinedSink)
+	descriptions := make(map[string]string)
+	var errs []error
+	for name, sink := range sinks {
+		var outputs []string
+		for _, output := range sink.Outputs {
+			if device, ok := lo.Find(cache.outputs, func(stream Stream) bool { return stream.Name == output }); ok {
+				outputs = append(outputs, device.FullName)
+			}
+		}
+		if len(outputs) == 0 {
+			errs = append(errs, fmt.Errorf("none of the output devices %s of %s is present", strings.Join(sink.Outputs, ", "), name))
+			continue
+		}
+		sinkName := configuration.CombinedSinkName(name)
+		wanted[sinkName] = combinedSink{name: sinkName, outputs: outputs}
+		descriptions[sinkName] = name
+	}
+
+	for _, sink := range loaded {
+		if want, ok := wanted[sink.name]; ok && slices.Equal(want.outputs, sink.outputs) {
+			delete(wanted, sink.name)
+			continue
+		}
+		if client.simulated("UnloadCombinedSink", sink.name, nil, nil) {
+			continue
+		}
+		if err := client.callErr("UnloadCombinedSink", sink.name, func() error { return client.pa().UnloadCombinedSink(sink) }); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove %s: %w", sink.name, err))
+			continue
+		}
+		client.log.Debug().Msgf("Removed combined sink %s", sink.name)
+	}
+
+	for _, sink := range wanted {
+		if client.simulated("LoadCombinedSink", descriptions[sink.name], nil, sink.outputs) {
+			continue
+		}
+		if err := client.callErr("LoadCombinedSink", sink.name, func() error { return client.pa().LoadCombinedSink(sink.name, descriptions[sink.name], sink.outputs) }); err != nil {
+			errs = append(errs, fmt.Errorf("failed to create %s: %w", descriptions[sink.name], err))
+			continue
+		}
+		client.log.Debug().Msgf("Created combined sink %s of %s", descriptions[sink.name], strings.Join(sink.outputs, ", "))
+	}
+	return errors.Join(errs...)
+}
+
+func (s *pulseServer) CombinedSinks() ([]combinedSink, error) {
+	modules, err := s.client.ModuleList()
+	if err != nil {
+		return nil, err
+	}
+	return combinedSinks(modules), nil
+}
+
+// combinedSinks returns the combined output devices pulsekontrol loaded
+// among modules
+func combinedSinks(modules []pulseaudio.Module) []combinedSink {
+	var sinks []combinedSink
+	for _, module := range modules {
+		if module.Name != "module-combine-sink" {
+			continue
+		}
+		arguments := moduleArguments(module.Argument)
+		if !strings.HasPrefix(arguments["sink_name"], configuration.CombinedSinkName("")) {
+			continue
+		}
+		sinks = append(sinks, combinedSink{name: arguments["sink_name"], outputs: strings.Split(arguments["slaves"], ","), id: int(module.Index)})
+	}
+	return sinks
+}
+
+func (s *pulseServer) LoadCombinedSink(name string, description string, outputs []string) error {
+	argument, err := combinedSinkArgument(name, description, outputs)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.LoadModule("module-combine-sink", argument)
+	return err
+}
+
+// combinedSinkArgument returns the argument of module-combine-sink
+func combinedSinkArgument(name string, description string, outputs []string) (string, error) {
+	if err := checkDeviceNames(append([]string{name}, outputs...)...); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sink_name=%s slaves=%s %s", name, strings.Join(outputs, ","), descriptionArgument("sink_properties", description)), nil
+}
+
+func (s *pulseServer) UnloadCombinedSink(sink combinedSink) error {
+	return s.client.UnloadModule(uint32(sink.id))
+}
+
+// PipeWire has no tool for combining sinks that outlives it, the
+// module-combine-sink of pipewire-pulse is loaded with pactl instead
+func (s *pipeWireServer) CombinedSinks() ([]combinedSink, error) {
+	modules, err := s.pulseModules()
+	if err != nil {
+		return nil, err
+	}
+	return combinedSinks(modules), nil
+}
+
+func (s *pipeWireServer) LoadCombinedSink(name string, description string, outputs []string) error {
+	argument, err := combinedSinkArgument(name, description, outputs)
+	if err != nil {
+		return err
+	}
+	return s.loadPulseModule("module-combine-sink", argument)
+}
+
+func (s *pipeWireServer) UnloadCombinedSink(sink combinedSink) error {
+	return s.unloadPulseModule(sink.id)
+}
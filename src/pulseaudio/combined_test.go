@@ -0,0 +1,27 @@
+package pulseaudio
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCombinedSinkArgument(t *testing.T) {
+	argument, err := combinedSinkArgument("pulsekontrol_combined_both", `Both "rooms"' slaves=x`, []string{"alsa_output.a", "alsa_output.b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	arguments := moduleArguments(argument)
+	if arguments["sink_name"] != "pulsekontrol_combined_both" || arguments["slaves"] != "alsa_output.a,alsa_output.b" {
+		t.Errorf("got %v from %s", arguments, argument)
+	}
+	if !strings.Contains(arguments["sink_properties"], `Both \"rooms\"' slaves=x`) {
+		t.Errorf("description not quoted in %q", arguments["sink_properties"])
+	}
+
+	if _, err := combinedSinkArgument("pulsekontrol_combined_x sink_name=y", "x", []string{"alsa_output.a"}); err == nil {
+		t.Error("sink name with spaces accepted")
+	}
+	if _, err := combinedSinkArgument("pulsekontrol_combined_x", "x", []string{"alsa_output.a module=y"}); err == nil {
+		t.Error("output with spaces accepted")
+	}
+}
@@ -0,0 +1,172 @@
+package pulseaudio
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/samber/lo"
+	"github.com/the-jonsey/pulseaudio"
+)
+
+// echoCancelPrefix starts the names of the devices of the echo cancellers
+// pulsekontrol loaded, so they are found again after a restart
+const echoCancelPrefix = "pulsekontrol_echocancel"
+
+// echoCancel is an input device pulsekontrol loaded that records an input
+// device without what an output device plays, along with an output device
+// playing on that one
+type echoCancel struct {
+	input  string // Full name of the input device it records
+	output string // Full name of the output device it plays on
+	id     int    // Module index
+}
+
+// EchoCancelLoaded returns whether pulsekontrol cancels the echo of the
+// output device with a name on the input device with a name
+func (client *PAClient) EchoCancelLoaded(source string, sink string) (bool, error) {
+	input, output, err := client.loopbackDevices(source, sink)
+	if err != nil {
+		return false, err
+	}
+	cancellers, err := client.matchEchoCancels(input, output)
+	return len(cancellers) > 0, err
+}
+
+// LoadEchoCancel records the input device with a name without the echo of
+// the output device with a name, as an input device of its own, unless it
+// already is. With setDefault that input device becomes the default input.
+func (client *PAClient) LoadEchoCancel(source string, sink string, setDefault bool) error {
+	input, output, err := client.loopbackDevices(source, sink)
+	if err != nil {
+		return err
+	}
+	cancellers, err := client.matchEchoCancels(input, output)
+	if err != nil || len(cancellers) > 0 {
+		return err
+	}
+	if client.simulated("LoadEchoCancel", source, []Stream{input, output}, sink) {
+		return nil
+	}
+	name := echoCancelPrefix + "." + input.FullName
+	if err := client.callErr("LoadEchoCancel", source, func() error { return client.pa().LoadEchoCancel(name, input, output) }); err != nil {
+		return fmt.Errorf("failed to cancel the echo of %s on %s: %w", sink, source, err)
+	}
+	client.log.Debug().Msgf("Loaded echo cancellation of %s on %s", sink, source)
+	if setDefault {
+		return client.callErr("SetDefaultSource", source, func() error { return client.pa().SetDefaultSource(name) })
+	}
+	return nil
+}
+
+// UnloadEchoCancel stops cancelling the echo of the output device with a
+// name on the input device with a name. With setDefault the input device
+// becomes the default input again.
+func (client *PAClient) UnloadEchoCancel(source string, sink string, setDefault bool) error {
+	input, output, err := client.loopbackDevices(source, sink)
+	if err != nil {
+		return err
+	}
+	cancellers, err := client.matchEchoCancels(input, output)
+	if err != nil {
+		return err
+	}
+	if len(cancellers) == 0 || client.simulated("UnloadEchoCancel", source, nil, sink) {
+		return nil
+	}
+	for _, canceller := range cancellers {
+		if err := client.callErr("UnloadEchoCancel", source, func() error { return client.pa().UnloadEchoCancel(canceller) }); err != nil {
+			return fmt.Errorf("failed to stop cancelling the echo of %s on %s: %w", sink, source, err)
+		}
+	}
+	client.log.Debug().Msgf("Unloaded echo cancellation of %s on %s", sink, source)
+	if setDefault {
+		return client.callErr("SetDefaultSource", source, func() error { return client.pa().SetDefaultSource(input.FullName) })
+	}
+	return nil
+}
+
+// matchEchoCancels returns the echo cancellers pulsekontrol loaded for an
+// input device and an output device
+func (client *PAClient) matchEchoCancels(input Stream, output Stream) ([]echoCancel, error) {
+	cancellers, err := call(client, "EchoCancels", input.Name, client.pa().EchoCancels)
+	if err != nil {
+		return nil, err
+	}
+	return lo.Filter(cancellers, func(canceller echoCancel, index int) bool {
+		return canceller.input == input.FullName && canceller.output == output.FullName
+	}), nil
+}
+
+func (s *pulseServer) EchoCancels() ([]echoCancel, error) {
+	modules, err := s.client.ModuleList()
+	if err != nil {
+		return nil, err
+	}
+	return echoCancels(modules), nil
+}
+
+// echoCancels returns the echo cancellers pulsekontrol loaded among modules
+func echoCancels(modules []pulseaudio.Module) []echoCancel {
+	var cancellers []echoCancel
+	for _, module := range modules {
+		if module.Name != "module-echo-cancel" {
+			continue
+		}
+		arguments := moduleArguments(module.Argument)
+		if !strings.HasPrefix(arguments["source_name"], echoCancelPrefix) {
+			continue
+		}
+		cancellers = append(cancellers, echoCancel{
+			input:  arguments["source_master"],
+			output: arguments["sink_master"],
+			id:     int(module.Index),
+		})
+	}
+	return cancellers
+}
+
+func (s *pulseServer) LoadEchoCancel(name string, input Stream, output Stream) error {
+	argument, err := echoCancelArgument(name, input, output)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.LoadModule("module-echo-cancel", argument)
+	return err
+}
+
+// echoCancelArgument returns the argument of module-echo-cancel
+func echoCancelArgument(name string, input Stream, output Stream) (string, error) {
+	if err := checkDeviceNames(name, input.FullName, output.FullName); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("source_master=%s sink_master=%s source_name=%s sink_name=%s aec_method=webrtc %s %s",
+		input.FullName, output.FullName, name, name+".sink",
+		descriptionArgument("source_properties", input.Name+" (echo cancelled)"),
+		descriptionArgument("sink_properties", output.Name+" (echo cancelled)")), nil
+}
+
+func (s *pulseServer) UnloadEchoCancel(canceller echoCancel) error {
+	return s.client.UnloadModule(uint32(canceller.id))
+}
+
+// PipeWire's own echo cancellation is a module of its configuration, the
+// module-echo-cancel of pipewire-pulse is loaded with pactl instead
+func (s *pipeWireServer) EchoCancels() ([]echoCancel, error) {
+	modules, err := s.pulseModules()
+	if err != nil {
+		return nil, err
+	}
+	return echoCancels(modules), nil
+}
+
+func (s *pipeWireServer) LoadEchoCancel(name string, input Stream, output Stream) error {
+	argument, err := echoCancelArgument(name, input, output)
+	if err != nil {
+		return err
+	}
+	return s.loadPulseModule("module-echo-cancel", argument)
+}
+
+func (s *pipeWireServer) UnloadEchoCancel(canceller echoCancel) error {
+	return s.unloadPulseModule(canceller.id)
+}
@@ -0,0 +1,31 @@
+package pulseaudio
+
+import "testing"
+
+func TestEchoCancelArgument(t *testing.T) {
+	input := Stream{Name: `Mic "USB"`, FullName: "alsa_input.usb"}
+	output := Stream{Name: "Bob's speakers", FullName: "alsa_output.pci"}
+	argument, err := echoCancelArgument(echoCancelPrefix+".alsa_input.usb", input, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	arguments := moduleArguments(argument)
+	for key, want := range map[string]string{
+		"source_master": "alsa_input.usb",
+		"sink_master":   "alsa_output.pci",
+		"source_name":   echoCancelPrefix + ".alsa_input.usb",
+		"aec_method":    "webrtc",
+	} {
+		if arguments[key] != want {
+			t.Errorf("%s is %q, want %q in %s", key, arguments[key], want, argument)
+		}
+	}
+	if _, value, _ := cutProperty(arguments["sink_properties"]); value != "Bob's speakers (echo cancelled)" {
+		t.Errorf("got sink description %q", value)
+	}
+
+	input.FullName = "alsa_input.usb source_name=x"
+	if _, err := echoCancelArgument(echoCancelPrefix+".x", input, output); err == nil {
+		t.Error("input device with spaces accepted")
+	}
+}
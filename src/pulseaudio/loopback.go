@@ -0,0 +1,274 @@
+package pulseaudio
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+// loopbackApplicationID marks the loopbacks pulsekontrol loaded, so they are
+// found again after a restart of pulsekontrol
+const loopbackApplicationID = "org.pulsekontrol.loopback"
+
+// Loopback plays an input device on an output device, like a microphone on
+// headphones to hear oneself
+type Loopback struct {
+	Source string // Full name of the input device
+	Sink   string // Full name of the output device
+	id     int    // Module index of PulseAudio, process id of PipeWire
+}
+
+// LoopbackLoaded returns whether pulsekontrol plays the input device with a
+// name on the output device with a name
+func (client *PAClient) LoopbackLoaded(source string, sink string) (bool, error) {
+	input, output, err := client.loopbackDevices(source, sink)
+	if err != nil {
+		return false, err
+	}
+	loopbacks, err := client.matchLoopbacks(input, output)
+	return len(loopbacks) > 0, err
+}
+
+// LoadLoopback plays the input device with a name on the output device with
+// a name, unless it already is. A zero latency keeps the server's default.
+func (client *PAClient) LoadLoopback(source string, sink string, latency time.Duration) error {
+	input, output, err := client.loopbackDevices(source, sink)
+	if err != nil {
+		return err
+	}
+	loopbacks, err := client.matchLoopbacks(input, output)
+	if err != nil || len(loopbacks) > 0 {
+		return err
+	}
+	if client.simulated("LoadLoopback", source, []Stream{input, output}, sink) {
+		return nil
+	}
+	if err := client.callErr("LoadLoopback", source, func() error { return client.pa().LoadLoopback(input.FullName, output.FullName, latency) }); err != nil {
+		return fmt.Errorf("failed to play %s on %s: %w", source, sink, err)
+	}
+	client.log.Debug().Msgf("Loaded loopback from %s to %s", source, sink)
+	return nil
+}
+
+// UnloadLoopback stops playing the input device with a name on the output
+// device with a name
+func (client *PAClient) UnloadLoopback(source string, sink string) error {
+	input, output, err := client.loopbackDevices(source, sink)
+	if err != nil {
+		return err
+	}
+	loopbacks, err := client.matchLoopbacks(input, output)
+	if err != nil {
+		return err
+	}
+	if len(loopbacks) == 0 || client.simulated("UnloadLoopback", source, nil, sink) {
+		return nil
+	}
+	for _, loopback := range loopbacks {
+		if err := client.callErr("UnloadLoopback", source, func() error { return client.pa().UnloadLoopback(loopback) }); err != nil {
+			return fmt.Errorf("failed to stop playing %s on %s: %w", source, sink, err)
+		}
+	}
+	client.log.Debug().Msgf("Unloaded loopback from %s to %s", source, sink)
+	return nil
+}
+
+// loopbackDevices returns the input and output device with a name
+func (client *PAClient) loopbackDevices(source string, sink string) (Stream, Stream, error) {
+	if err := client.refreshStreams(); err != nil {
+		return Stream{}, Stream{}, err
+	}
+	cache := client.cache()
+	input, ok := lo.Find(cache.inputs, func(stream Stream) bool {
+		return stream.Name == source
+	})
+	if !ok {
+		return Stream{}, Stream{}, fmt.Errorf("no input device %s", source)
+	}
+	output, ok := lo.Find(cache.outputs, func(stream Stream) bool {
+		return stream.Name == sink
+	})
+	if !ok {
+		return Stream{}, Stream{}, fmt.Errorf("no output device %s", sink)
+	}
+	return input, output, nil
+}
+
+// matchLoopbacks returns the loopbacks pulsekontrol loaded from an input
+// device to an output device
+func (client *PAClient) matchLoopbacks(input Stream, output Stream) ([]Loopback, error) {
+	loopbacks, err := call(client, "Loopbacks", input.Name, client.pa().Loopbacks)
+	if err != nil {
+		return nil, err
+	}
+	return lo.Filter(loopbacks, func(loopback Loopback, index int) bool {
+		return loopback.Source == input.FullName && loopback.Sink == output.FullName
+	}), nil
+}
+
+// moduleArguments parses the argument of a PulseAudio module, like
+// source=foo sink_input_properties="a=b c=d"
+func moduleArguments(argument string) map[string]string {
+	arguments := make(map[string]string)
+	for len(argument) > 0 {
+		argument = strings.TrimLeft(argument, " ")
+		key, rest, found := strings.Cut(argument, "=")
+		if !found {
+			break
+		}
+		var value string
+		if len(rest) > 0 && (rest[0] == '"' || rest[0] == '\'') {
+			value, argument = unquoteArgument(rest)
+		} else {
+			value, argument, _ = strings.Cut(rest, " ")
+		}
+		arguments[key] = value
+	}
+	return arguments
+}
+
+// unquoteArgument returns the value of a quoted module argument, where a
+// backslash escapes the next character, and what follows it
+func unquoteArgument(quoted string) (string, string) {
+	quote := quoted[0]
+	var value strings.Builder
+	for i := 1; i < len(quoted); i++ {
+		switch quoted[i] {
+		case '\\':
+			if i+1 < len(quoted) {
+				i++
+				value.WriteByte(quoted[i])
+			}
+		case quote:
+			return value.String(), quoted[i+1:]
+		default:
+			value.WriteByte(quoted[i])
+		}
+	}
+	return value.String(), ""
+}
+
+// quoteArgument quotes a value of a module argument or property list,
+// escaping the quote and backslashes, so that spaces and quotes in names
+// given by users or devices can't end it early
+func quoteArgument(value string, quote byte) string {
+	var quoted strings.Builder
+	quoted.WriteByte(quote)
+	for i := 0; i < len(value); i++ {
+		if value[i] == quote || value[i] == '\\' {
+			quoted.WriteByte('\\')
+		}
+		quoted.WriteByte(value[i])
+	}
+	quoted.WriteByte(quote)
+	return quoted.String()
+}
+
+// descriptionArgument returns a properties argument of a module setting the
+// description of a device
+func descriptionArgument(key string, description string) string {
+	return key + "=" + quoteArgument("device.description="+quoteArgument(description, '"'), '\'')
+}
+
+// validDeviceName matches the names of devices that are passed to modules
+// unquoted
+var validDeviceName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// checkDeviceNames fails for names that are not made of letters, digits,
+// underscores, dots and dashes only, so that none can add arguments to a module
+func checkDeviceNames(names ...string) error {
+	for _, name := range names {
+		if !validDeviceName.MatchString(name) {
+			return fmt.Errorf("invalid device name %q", name)
+		}
+	}
+	return nil
+}
+
+func (s *pulseServer) Loopbacks() ([]Loopback, error) {
+	modules, err := s.client.ModuleList()
+	if err != nil {
+		return nil, err
+	}
+	var loopbacks []Loopback
+	for _, module := range modules {
+		if module.Name != "module-loopback" || !strings.Contains(module.Argument, loopbackApplicationID) {
+			continue
+		}
+		arguments := moduleArguments(module.Argument)
+		loopbacks = append(loopbacks, Loopback{Source: arguments["source"], Sink: arguments["sink"], id: int(module.Index)})
+	}
+	return loopbacks, nil
+}
+
+func (s *pulseServer) LoadLoopback(source string, sink string, latency time.Duration) error {
+	if err := checkDeviceNames(source, sink); err != nil {
+		return err
+	}
+	argument := fmt.Sprintf("source=%s sink=%s source_dont_move=true sink_dont_move=true sink_input_properties=application.id=%s", source, sink, loopbackApplicationID)
+	if latency > 0 {
+		argument += " latency_msec=" + strconv.FormatInt(latency.Milliseconds(), 10)
+	}
+	_, err := s.client.LoadModule("module-loopback", argument)
+	return err
+}
+
+func (s *pulseServer) UnloadLoopback(loopback Loopback) error {
+	return s.client.UnloadModule(uint32(loopback.id))
+}
+
+// Loopbacks of PipeWire are pw-loopback processes of their own, which keep
+// running when pulsekontrol stops like modules of PulseAudio do. They are
+// found again by the properties of their capture node.
+func (s *pipeWireServer) Loopbacks() ([]Loopback, error) {
+	objects, err := s.dump()
+	if err != nil {
+		return nil, err
+	}
+	var loopbacks []Loopback
+	for _, object := range objects {
+		if object.Type != "PipeWire:Interface:Node" || object.Info == nil ||
+			property(object.Info.Props, "application.id") != loopbackApplicationID ||
+			property(object.Info.Props, "media.class") != pipeWireRecord {
+			continue
+		}
+		pid := parseProcessID(property(object.Info.Props, "application.process.id"))
+		if pid == 0 {
+			continue
+		}
+		loopbacks = append(loopbacks, Loopback{
+			Source: property(object.Info.Props, "pulsekontrol.loopback.source"),
+			Sink:   property(object.Info.Props, "pulsekontrol.loopback.sink"),
+			id:     pid,
+		})
+	}
+	return loopbacks, nil
+}
+
+func (s *pipeWireServer) LoadLoopback(source string, sink string, latency time.Duration) error {
+	args := []string{
+		"--capture=" + source, "--playback=" + sink,
+		fmt.Sprintf("--capture-props={ application.id = %q pulsekontrol.loopback.source = %q pulsekontrol.loopback.sink = %q }", loopbackApplicationID, source, sink),
+	}
+	if latency > 0 {
+		args = append(args, "--latency="+strconv.FormatInt(latency.Milliseconds(), 10))
+	}
+	loopback := exec.Command("pw-loopback", args...)
+	// Its own session, so it outlives pulsekontrol
+	loopback.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := loopback.Start(); err != nil {
+		return fmt.Errorf("pw-loopback failed: %w", err)
+	}
+	go loopback.Wait()
+	return nil
+}
+
+func (s *pipeWireServer) UnloadLoopback(loopback Loopback) error {
+	return syscall.Kill(loopback.id, syscall.SIGTERM)
+}
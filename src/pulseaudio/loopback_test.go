@@ -0,0 +1,76 @@
+package pulseaudio
+
+import (
+	"testing"
+)
+
+func TestModuleArguments(t *testing.T) {
+	arguments := moduleArguments(`source=alsa_input.usb sink_input_properties="application.id=pulsekontrol media.name='a b'" latency_msec=20 empty=''`)
+	want := map[string]string{
+		"source":                "alsa_input.usb",
+		"sink_input_properties": "application.id=pulsekontrol media.name='a b'",
+		"latency_msec":          "20",
+		"empty":                 "",
+	}
+	if len(arguments) != len(want) {
+		t.Errorf("got %v, want %v", arguments, want)
+	}
+	for key, value := range want {
+		if arguments[key] != value {
+			t.Errorf("%s is %q, want %q", key, arguments[key], value)
+		}
+	}
+}
+
+func TestQuoteArgumentRoundTrip(t *testing.T) {
+	for _, value := range []string{
+		"Speakers",
+		"Living room's speakers",
+		`Say "hi"`,
+		`back\slash`,
+		`' sink_name=evil module=x '`,
+		"",
+	} {
+		for _, quote := range []byte{'"', '\''} {
+			quoted := quoteArgument(value, quote)
+			got, rest := unquoteArgument(quoted + " next=1")
+			if got != value || rest != " next=1" {
+				t.Errorf("%s unquotes to %q and %q, want %q", quoted, got, rest, value)
+			}
+		}
+	}
+}
+
+func TestDescriptionArgument(t *testing.T) {
+	description := `Bob's "best" sink' sink_name=other`
+	arguments := moduleArguments("sink_name=pulsekontrol_virtual_x " + descriptionArgument("sink_properties", description))
+	if arguments["sink_name"] != "pulsekontrol_virtual_x" {
+		t.Errorf("sink name changed to %q", arguments["sink_name"])
+	}
+	key, value, _ := cutProperty(arguments["sink_properties"])
+	if key != "device.description" || value != description {
+		t.Errorf("got property %s=%q, want the description %q", key, value, description)
+	}
+}
+
+// cutProperty splits a property list of a single quoted property
+func cutProperty(properties string) (string, string, bool) {
+	for i := 0; i < len(properties); i++ {
+		if properties[i] == '=' {
+			value, _ := unquoteArgument(properties[i+1:])
+			return properties[:i], value, true
+		}
+	}
+	return "", "", false
+}
+
+func TestCheckDeviceNames(t *testing.T) {
+	if err := checkDeviceNames("alsa_output.pci-0000_00_1f.3.analog-stereo", "bluez_output.00_1B_66_AB_CD_EF.1"); err != nil {
+		t.Errorf("valid names rejected: %v", err)
+	}
+	for _, name := range []string{"", "a b", "a=b", "x sink_name=y", "a'b", `a"b`, "a\nb"} {
+		if err := checkDeviceNames(name); err == nil {
+			t.Errorf("%q accepted", name)
+		}
+	}
+}
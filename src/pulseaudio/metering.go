@@ -0,0 +1,208 @@
+package pulseaudio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/0h41/pulsekontrol/src/supervise"
+	"github.com/the-jonsey/pulseaudio"
+)
+
+// DefaultMeterInterval is how often meters report levels when not configured
+const DefaultMeterInterval = 50 * time.Millisecond
+
+// meterRate is the sample rate meters record at, plenty for levels
+const meterRate = 8000
+
+// meterApplicationID marks the recordings of meters, which are left out of
+// the record streams
+const meterApplicationID = "org.pulsekontrol.meter"
+
+// Level is how loud a stream or device was during one interval, as fractions
+// of full scale
+type Level struct {
+	Peak float64 `json:"peak"`
+	RMS  float64 `json:"rms"`
+}
+
+// LevelCallback receives the levels of a meter
+type LevelCallback func(level Level)
+
+// metering records the monitors of devices and streams for their levels,
+// once per target however many consumers there are
+type metering struct {
+	mu          sync.Mutex
+	interval    time.Duration
+	meters      map[configuration.TypedTarget]*meter
+	subscribers int // Subscriptions so far, for their ids
+}
+
+// meter is the recording of one target
+type meter struct {
+	cmd       *exec.Cmd
+	callbacks map[int]LevelCallback
+}
+
+// SetMeterInterval sets how often meters report levels, zero keeps the
+// default. Meters that are running keep their interval.
+func (client *PAClient) SetMeterInterval(interval time.Duration) {
+	client.metering.mu.Lock()
+	defer client.metering.mu.Unlock()
+	client.metering.interval = DefaultMeterInterval
+	if interval > 0 {
+		client.metering.interval = interval
+	}
+}
+
+// Meter calls a callback with the levels of the first stream or device
+// matching a target every meter interval, until the returned function is
+// called. Output devices are metered through their monitor, playback streams
+// through the monitor of their output. The levels stop when the stream goes
+// away.
+func (client *PAClient) Meter(target *configuration.TypedTarget, callback LevelCallback) (func(), error) {
+	m := &client.metering
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.meters == nil {
+		m.meters = make(map[configuration.TypedTarget]*meter)
+	}
+	if m.interval == 0 {
+		m.interval = DefaultMeterInterval
+	}
+	m.subscribers++
+	id := m.subscribers
+
+	running, ok := m.meters[*target]
+	if !ok {
+		if err := client.refreshStreams(); err != nil {
+			return nil, err
+		}
+		streams := client.matchTargetStreams(target)
+		if len(streams) == 0 {
+			return nil, fmt.Errorf("no %s matches %s", target.Type, target.Name)
+		}
+		args, err := client.pa().MeterCommand(streams[0], m.interval)
+		if err != nil {
+			return nil, err
+		}
+		running = &meter{cmd: exec.Command(args[0], args[1:]...), callbacks: make(map[int]LevelCallback)}
+		if err := client.startMeter(*target, running, m.interval); err != nil {
+			return nil, err
+		}
+		m.meters[*target] = running
+	}
+	running.callbacks[id] = callback
+
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.meters[*target] != running {
+			// The recording ended already
+			return
+		}
+		delete(running.callbacks, id)
+		if len(running.callbacks) == 0 {
+			delete(m.meters, *target)
+			running.cmd.Process.Kill()
+		}
+	}, nil
+}
+
+// startMeter starts the recording of a meter and reports the levels of its
+// samples until it ends
+func (client *PAClient) startMeter(target configuration.TypedTarget, running *meter, interval time.Duration) error {
+	output, err := running.cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := running.cmd.Start(); err != nil {
+		return fmt.Errorf("failed to record %s for its levels: %w", target.Name, err)
+	}
+	client.log.Debug().Str("target", target.Name).Strs("command", running.cmd.Args).Msg("Started meter")
+
+	samples := max(int(interval.Seconds()*meterRate), 1)
+	supervise.Go("pulseaudio.meter", func() {
+		reader := bufio.NewReader(output)
+		buffer := make([]int16, samples)
+		for {
+			if err := binary.Read(reader, binary.LittleEndian, buffer); err != nil {
+				if err != io.EOF && err != io.ErrUnexpectedEOF {
+					client.log.Debug().Err(err).Str("target", target.Name).Msg("Meter stopped")
+				}
+				break
+			}
+			level := measureLevel(buffer)
+			client.metering.mu.Lock()
+			callbacks := make([]LevelCallback, 0, len(running.callbacks))
+			for _, callback := range running.callbacks {
+				callbacks = append(callbacks, callback)
+			}
+			client.metering.mu.Unlock()
+			for _, callback := range callbacks {
+				callback(level)
+			}
+		}
+		running.cmd.Wait()
+
+		client.metering.mu.Lock()
+		if client.metering.meters[target] == running {
+			delete(client.metering.meters, target)
+		}
+		client.metering.mu.Unlock()
+		client.log.Debug().Str("target", target.Name).Msg("Meter ended")
+	})
+	return nil
+}
+
+// measureLevel returns the peak and RMS level of signed 16-bit samples
+func measureLevel(samples []int16) Level {
+	var peak, sum float64
+	for _, sample := range samples {
+		value := math.Abs(float64(sample)) / 32768
+		peak = max(peak, value)
+		sum += value * value
+	}
+	return Level{Peak: peak, RMS: math.Sqrt(sum / float64(len(samples)))}
+}
+
+func (s *pulseServer) MeterCommand(stream Stream, interval time.Duration) ([]string, error) {
+	// Mono is enough for levels
+	args := []string{"parec", "--raw", "--format=s16le", "--channels=1", "--rate=" + strconv.Itoa(meterRate),
+		"--latency-msec=" + strconv.FormatInt(interval.Milliseconds(), 10),
+		"--property=application.id=" + meterApplicationID, "--client-name=pulsekontrol meter"}
+	switch device := stream.paStream.(type) {
+	case pulseaudio.Sink:
+		return append(args, "--device="+device.MonitorSourceName), nil
+	case pulseaudio.Source:
+		return append(args, "--device="+device.Name), nil
+	case pulseaudio.SinkInput:
+		return append(args, "--monitor-stream="+strconv.FormatUint(uint64(device.Index), 10)), nil
+	}
+	return nil, fmt.Errorf("%s can't be metered, only devices and playback streams", stream.Name)
+}
+
+func (s *pipeWireServer) MeterCommand(stream Stream, interval time.Duration) ([]string, error) {
+	node, ok := stream.paStream.(*pipeWireNode)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a PipeWire node", stream.Name)
+	}
+	properties := fmt.Sprintf("{ application.id = %q node.latency = \"%d/%d\"", meterApplicationID, max(int(interval.Seconds()*meterRate), 1), meterRate)
+	switch stream.properties["media.class"] {
+	case pipeWireSink:
+		properties += " stream.capture.sink = true"
+	case pipeWireSource, pipeWireVirtualSource, pipeWirePlayback:
+	default:
+		return nil, fmt.Errorf("%s can't be metered, only devices and playback streams", stream.Name)
+	}
+	properties += " }"
+	return []string{"pw-record", "--raw", "--target=" + strconv.Itoa(node.id), "--properties=" + properties,
+		"--format=s16", "--channels=1", "--rate=" + strconv.Itoa(meterRate), "-"}, nil
+}
@@ -0,0 +1,161 @@
+package pulseaudio
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	mprisPrefix          = "org.mpris.MediaPlayer2."
+	mprisPath            = "/org/mpris/MediaPlayer2"
+	mprisPlayerInterface = "org.mpris.MediaPlayer2.Player"
+)
+
+// mprisTimeout bounds D-Bus calls to media players, which may hang
+const mprisTimeout = 2 * time.Second
+
+// mprisPlayer is a media player on the session bus
+type mprisPlayer struct {
+	busName string // org.mpris.MediaPlayer2.<name>, optionally with an instance suffix
+	order   uint64 // Number of the unique bus name, players that connected earlier have lower ones
+	status  string // PlaybackStatus: Playing, Paused or Stopped, empty if unknown
+}
+
+// name returns the bus name without the MPRIS prefix, like spotify
+func (player mprisPlayer) name() string {
+	return strings.TrimPrefix(player.busName, mprisPrefix)
+}
+
+// matches reports whether a player has the given name, with or without its
+// instance suffix, ignoring case
+func (player mprisPlayer) matches(name string) bool {
+	playerName := strings.ToLower(player.name())
+	name = strings.ToLower(name)
+	return playerName == name || strings.HasPrefix(playerName, name+".")
+}
+
+// listPlayers returns the MPRIS players on the bus in the order they connected
+func listPlayers(ctx context.Context, conn *dbus.Conn) ([]mprisPlayer, error) {
+	var names []string
+	if err := conn.BusObject().CallWithContext(ctx, "org.freedesktop.DBus.ListNames", 0).Store(&names); err != nil {
+		return nil, err
+	}
+
+	var players []mprisPlayer
+	for _, name := range names {
+		if !strings.HasPrefix(name, mprisPrefix) {
+			continue
+		}
+		player := mprisPlayer{busName: name, order: math.MaxUint64}
+		var owner string
+		if conn.BusObject().CallWithContext(ctx, "org.freedesktop.DBus.GetNameOwner", 0, name).Store(&owner) == nil {
+			player.order = uniqueNameOrder(owner)
+		}
+		var status dbus.Variant
+		if conn.Object(name, mprisPath).CallWithContext(ctx, "org.freedesktop.DBus.Properties.Get", 0, mprisPlayerInterface, "PlaybackStatus").Store(&status) == nil {
+			player.status, _ = status.Value().(string)
+		}
+		players = append(players, player)
+	}
+	sort.SliceStable(players, func(i, j int) bool {
+		return players[i].order < players[j].order
+	})
+	return players, nil
+}
+
+// uniqueNameOrder returns the connection number of a unique bus name like :1.42
+func uniqueNameOrder(owner string) uint64 {
+	_, number, ok := strings.Cut(owner, ".")
+	if !ok {
+		return math.MaxUint64
+	}
+	order, err := strconv.ParseUint(number, 10, 64)
+	if err != nil {
+		return math.MaxUint64
+	}
+	return order
+}
+
+// choosePlayer returns the player with the given name, or without a name the
+// first one that is playing, falling back to the first one
+func choosePlayer(players []mprisPlayer, name string) (mprisPlayer, bool) {
+	if name != "" {
+		for _, player := range players {
+			if player.matches(name) {
+				return player, true
+			}
+		}
+		return mprisPlayer{}, false
+	}
+	for _, player := range players {
+		if player.status == "Playing" {
+			return player, true
+		}
+	}
+	if len(players) > 0 {
+		return players[0], true
+	}
+	return mprisPlayer{}, false
+}
+
+// mediaPlayPause toggles playback of the named media player, or of the one
+// choosePlayer picks. A missing session bus or player is logged as a warning
+// once rather than on every press, and is not an error.
+func (client *PAClient) mediaPlayPause(playerName string) error {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		client.warnMedia(fmt.Sprintf("No D-Bus session bus, media players can't be controlled: %s", err))
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mprisTimeout)
+	defer cancel()
+
+	players, err := listPlayers(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("failed to list media players: %w", err)
+	}
+	player, ok := choosePlayer(players, playerName)
+	if !ok {
+		if playerName != "" {
+			client.warnMedia(fmt.Sprintf("Media player %s is not running", playerName))
+		} else {
+			client.warnMedia("No media player is running")
+		}
+		return nil
+	}
+
+	if client.simulated("MediaPlayPause", player.name(), nil, nil) {
+		return nil
+	}
+	if call := conn.Object(player.busName, mprisPath).CallWithContext(ctx, mprisPlayerInterface+".PlayPause", 0); call.Err != nil {
+		return fmt.Errorf("failed to play/pause %s: %w", player.name(), call.Err)
+	}
+	client.warnMedia("")
+	client.log.Info().Str("player", player.name()).Str("status", player.status).Msg("Sent play/pause to media player")
+	return nil
+}
+
+// warnMedia logs a warning about media players unless it was the last one,
+// which is only logged at debug level. An empty warning resets that.
+func (client *PAClient) warnMedia(warning string) {
+	client.mediaWarningMutex.Lock()
+	repeated := warning == client.mediaWarning
+	client.mediaWarning = warning
+	client.mediaWarningMutex.Unlock()
+
+	if warning == "" {
+		return
+	}
+	if repeated {
+		client.log.Debug().Msg(warning)
+		return
+	}
+	client.log.Warn().Msg(warning)
+}
@@ -0,0 +1,229 @@
+package pulseaudio
+
+import (
+	"bufio"
+	"bytes"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/godbus/dbus/v5"
+	"github.com/rs/zerolog"
+)
+
+func TestChoosePlayer(t *testing.T) {
+	players := []mprisPlayer{
+		{busName: mprisPrefix + "spotify", status: "Paused"},
+		{busName: mprisPrefix + "vlc.instance1234", status: "Playing"},
+		{busName: mprisPrefix + "mpv", status: "Playing"},
+	}
+	for _, test := range []struct {
+		name   string
+		player string
+	}{
+		{"", "vlc.instance1234"},
+		{"Spotify", "spotify"},
+		{"vlc", "vlc.instance1234"},
+		{"vlc.instance1234", "vlc.instance1234"},
+		{"mpv", "mpv"},
+		{"firefox", ""},
+		{"spot", ""},
+	} {
+		player, ok := choosePlayer(players, test.name)
+		if ok != (test.player != "") || player.name() != test.player {
+			t.Errorf("chose %q for %q, want %q", player.name(), test.name, test.player)
+		}
+	}
+
+	// Without a playing one, the player that connected first
+	players[1].status, players[2].status = "Stopped", ""
+	if player, _ := choosePlayer(players, ""); player.name() != "spotify" {
+		t.Errorf("chose %s without a playing player", player.name())
+	}
+	if _, ok := choosePlayer(nil, ""); ok {
+		t.Error("chose a player without players")
+	}
+}
+
+func TestUniqueNameOrder(t *testing.T) {
+	if uniqueNameOrder(":1.9") >= uniqueNameOrder(":1.10") {
+		t.Error(":1.9 ordered after :1.10")
+	}
+	if order := uniqueNameOrder("org.mpris.MediaPlayer2.mpv"); order != uniqueNameOrder("invalid") || order < uniqueNameOrder(":1.10") {
+		t.Error("well-known name ordered before a unique one")
+	}
+}
+
+// fakePlayer is an MPRIS media player on a test bus
+type fakePlayer struct {
+	mutex   sync.Mutex
+	status  string
+	presses chan struct{}
+}
+
+// PlayPause implements org.mpris.MediaPlayer2.Player.PlayPause
+func (player *fakePlayer) PlayPause() *dbus.Error {
+	player.presses <- struct{}{}
+	return nil
+}
+
+// Get implements org.freedesktop.DBus.Properties.Get
+func (player *fakePlayer) Get(iface string, property string) (dbus.Variant, *dbus.Error) {
+	if iface != mprisPlayerInterface || property != "PlaybackStatus" {
+		return dbus.Variant{}, dbus.MakeFailedError(dbus.ErrMsgUnknownInterface)
+	}
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	return dbus.MakeVariant(player.status), nil
+}
+
+// setStatus changes the PlaybackStatus of the player
+func (player *fakePlayer) setStatus(status string) {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	player.status = status
+}
+
+// startBus runs a private session bus for the test and makes it the session
+// bus of the process. Unlike other packages this one can't use
+// testutil.StartBus, testutil imports it.
+func startBus(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("dbus-daemon"); err != nil {
+		t.Skip("dbus-daemon not installed")
+	}
+	daemon := exec.Command("dbus-daemon", "--session", "--nofork", "--print-address=1")
+	stdout, err := daemon.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := daemon.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		daemon.Process.Kill()
+		daemon.Wait()
+	})
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	address = strings.TrimSpace(address)
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", address)
+	// The shared connection would outlive the bus otherwise
+	t.Cleanup(func() {
+		if conn, err := dbus.SessionBus(); err == nil {
+			conn.Close()
+		}
+	})
+	return address
+}
+
+// addPlayer connects a fake player with the given name to the bus
+func addPlayer(t *testing.T, address string, name string, status string) *fakePlayer {
+	t.Helper()
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	player := &fakePlayer{status: status, presses: make(chan struct{}, 4)}
+	if err := conn.ExportMethodTable(map[string]interface{}{"PlayPause": player.PlayPause}, mprisPath, mprisPlayerInterface); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.ExportMethodTable(map[string]interface{}{"Get": player.Get}, mprisPath, "org.freedesktop.DBus.Properties"); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := conn.RequestName(mprisPrefix+name, dbus.NameFlagDoNotQueue); err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("failed to own the name of %s: %v", name, err)
+	}
+	return player
+}
+
+// pressed fails unless the player received a single play/pause
+func pressed(t *testing.T, name string, player *fakePlayer) {
+	t.Helper()
+	select {
+	case <-player.presses:
+	case <-time.After(time.Second):
+		t.Fatalf("%s not played/paused", name)
+	}
+	select {
+	case <-player.presses:
+		t.Errorf("%s played/paused twice", name)
+	default:
+	}
+}
+
+func TestMediaPlayPause(t *testing.T) {
+	var output bytes.Buffer
+	client := newClient("fake")
+	client.log = zerolog.New(&output)
+	warnings := func() int {
+		defer output.Reset()
+		return strings.Count(output.String(), `"level":"warn"`)
+	}
+	playPause := func(name string) error {
+		action := configuration.Action{Type: configuration.MediaPlayPause}
+		if name != "" {
+			action.Target = &configuration.Target{Name: name}
+		}
+		return client.ProcessMediaControlAction(action)
+	}
+
+	// Without a session bus presses are only warned about once
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", "unix:path="+filepath.Join(t.TempDir(), "bus"))
+	for i := 0; i < 2; i++ {
+		if err := playPause(""); err != nil {
+			t.Errorf("play/pause without a bus returned %v", err)
+		}
+	}
+	if n := warnings(); n != 1 {
+		t.Errorf("missing bus warned about %d times", n)
+	}
+
+	address := startBus(t)
+	for i := 0; i < 2; i++ {
+		if err := playPause(""); err != nil {
+			t.Errorf("play/pause without players returned %v", err)
+		}
+	}
+	if n := warnings(); n != 1 {
+		t.Errorf("missing players warned about %d times", n)
+	}
+
+	spotify := addPlayer(t, address, "spotify", "Paused")
+	mpv := addPlayer(t, address, "mpv", "Playing")
+	vlc := addPlayer(t, address, "vlc.instance42", "Stopped")
+
+	// The playing player, then a named one
+	if err := playPause(""); err != nil {
+		t.Fatal(err)
+	}
+	pressed(t, "mpv", mpv)
+	if err := playPause("vlc"); err != nil {
+		t.Fatal(err)
+	}
+	pressed(t, "vlc", vlc)
+
+	// Then the first one, once none is playing
+	mpv.setStatus("Paused")
+	if err := playPause(""); err != nil {
+		t.Fatal(err)
+	}
+	pressed(t, "spotify", spotify)
+
+	if err := playPause("firefox"); err != nil {
+		t.Errorf("play/pause of a missing player returned %v", err)
+	}
+	if n := warnings(); n != 1 {
+		t.Errorf("missing player warned about %d times", n)
+	}
+	if client.IsMediaPlaying() {
+		t.Error("media playing with every player paused")
+	}
+}
@@ -0,0 +1,122 @@
+package pulseaudio
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/configuration"
+)
+
+// offlineServer stands in for a sound server that could not be reached.
+// Everything fails until Ping reconnects.
+type offlineServer struct {
+	err error // Why connecting failed
+}
+
+// NewOfflineClient returns a client for the sound server of a backend that
+// could not be reached, failing with the error of connecting until Ping or
+// Refresh manage to connect. Stream monitoring started in the meantime begins
+// once connected.
+func NewOfflineClient(backend configuration.AudioBackend, err error) *PAClient {
+	client := newClient(backend)
+	client.server = &offlineServer{err: fmt.Errorf("not connected to %s: %w", backendName(backend), err)}
+	return client
+}
+
+func (s *offlineServer) Connected() bool {
+	return false
+}
+
+func (s *offlineServer) Streams() (streamGroups, error) {
+	return streamGroups{}, s.err
+}
+
+func (s *offlineServer) Defaults() (defaultDevices, error) {
+	return defaultDevices{}, s.err
+}
+
+func (s *offlineServer) SetDefaultSink(name string) error {
+	return s.err
+}
+
+func (s *offlineServer) SetDefaultSource(name string) error {
+	return s.err
+}
+
+func (s *offlineServer) MoveStream(stream Stream, sink string) error {
+	return s.err
+}
+
+func (s *offlineServer) SetBalance(stream Stream, balance float64) error {
+	return s.err
+}
+
+func (s *offlineServer) Cards() ([]Card, error) {
+	return nil, s.err
+}
+
+func (s *offlineServer) SetCardProfile(card Card, profile CardProfile) error {
+	return s.err
+}
+
+func (s *offlineServer) Loopbacks() ([]Loopback, error) {
+	return nil, s.err
+}
+
+func (s *offlineServer) LoadLoopback(source string, sink string, latency time.Duration) error {
+	return s.err
+}
+
+func (s *offlineServer) UnloadLoopback(loopback Loopback) error {
+	return s.err
+}
+
+func (s *offlineServer) SuspendSink(stream Stream, suspended bool) error {
+	return s.err
+}
+
+func (s *offlineServer) CombinedSinks() ([]combinedSink, error) {
+	return nil, s.err
+}
+
+func (s *offlineServer) LoadCombinedSink(name string, description string, outputs []string) error {
+	return s.err
+}
+
+func (s *offlineServer) UnloadCombinedSink(sink combinedSink) error {
+	return s.err
+}
+
+func (s *offlineServer) EchoCancels() ([]echoCancel, error) {
+	return nil, s.err
+}
+
+func (s *offlineServer) LoadEchoCancel(name string, input Stream, output Stream) error {
+	return s.err
+}
+
+func (s *offlineServer) UnloadEchoCancel(canceller echoCancel) error {
+	return s.err
+}
+
+func (s *offlineServer) VirtualSinks() ([]virtualSink, error) {
+	return nil, s.err
+}
+
+func (s *offlineServer) LoadVirtualSink(name string, description string) error {
+	return s.err
+}
+
+func (s *offlineServer) UnloadVirtualSink(sink virtualSink) error {
+	return s.err
+}
+
+func (s *offlineServer) MeterCommand(stream Stream, interval time.Duration) ([]string, error) {
+	return nil, s.err
+}
+
+func (s *offlineServer) Updates() (<-chan struct{}, error) {
+	return nil, s.err
+}
+
+func (s *offlineServer) Close() {}
@@ -1,20 +1,25 @@
 package pulseaudio
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"math"
 	"os"
 	"os/exec"
 	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 	"unicode"
 
 	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/0h41/pulsekontrol/src/logging"
+	"github.com/0h41/pulsekontrol/src/supervise"
 	"github.com/godbus/dbus/v5"
 	"github.com/rs/zerolog"
-	"github.com/rs/zerolog/log"
 	"github.com/samber/lo"
 	"github.com/the-jonsey/pulseaudio"
 )
@@ -25,15 +30,21 @@ type Stream struct {
 	BinaryName string
 	MediaName  string
 	ProcessID  int
-	paStream   interface{}
+	paStream   interface{}       // The pulseaudio.Device the stream is changed through
+	properties map[string]string // What the server reports about it, see ListDetailed
 }
 
 type AudioSource struct {
 	ID         string `json:"id"`
-	Name       string `json:"name"`
+	Name       string `json:"name"`    // Display name, the alias if one is configured
+	RawName    string `json:"rawName"` // Real name, used for matching
 	BinaryName string `json:"binaryName"`
 	Type       string `json:"type"`
 	Volume     int    `json:"volume"`
+	Muted      bool   `json:"muted,omitempty"`
+	Default    bool   `json:"default,omitempty"`   // Default output or input device
+	Suspended  bool   `json:"suspended,omitempty"` // Suspended output device
+	Corked     bool   `json:"corked,omitempty"`    // Stream paused by its application
 }
 
 type focusedWindow struct {
@@ -53,109 +64,195 @@ type StreamEventCallback func(stream Stream, streamType configuration.PulseAudio
 // MediaStatusCallback is called when media playback status changes
 type MediaStatusCallback func(isPlaying bool)
 
+// SourceSeenCallback is called when a configured source matched a stream or device
+type SourceSeenCallback func(target configuration.TypedTarget)
+
+// EventHandler receives the events of the monitored streams and devices,
+// source.added, source.removed, default.output.changed and
+// default.input.changed, in the form of configuration manager notifications
+type EventHandler func(topic string, data interface{})
+
 type PAClient struct {
 	log                   zerolog.Logger
-	context               *pulseaudio.Client
+	backend               configuration.AudioBackend
+	server                server
+	cacheMutex            sync.RWMutex // Held while the streams and default devices below are replaced, see cache
 	outputs               []Stream
 	playbackStreams       []Stream
 	inputs                []Stream
 	recordStreams         []Stream
-	previousPlaybackIDs   map[string]bool
-	previousRecordIDs     map[string]bool
+	excludes              []configuration.ExcludeRule // Streams and devices left out, see SetExcludes
+	previousPlaybackIDs   map[string]Stream           // Streams by id at the last update, to tell which were removed
+	previousRecordIDs     map[string]Stream
+	defaultSink           string // Default devices at the last update, see checkDefaultDevices
+	defaultSource         string
+	sourcesState          string // Streams and devices at the last sources.changed, see checkSourcesChanged
+	eventHandler          EventHandler
 	newStreamCallback     StreamEventCallback
 	removedStreamCallback StreamEventCallback
 	mediaStatusCallback   MediaStatusCallback
+	sourceSeenCallback    SourceSeenCallback
+	monitoringMutex       sync.Mutex // Guards the monitoring state below, held while it changes
+	updateMutex           sync.Mutex // Held while an update is compared with the previous one, see handleStreamUpdate
 	monitoringEnabled     bool
+	monitoringWanted      bool          // Whether monitoring was started, to start it again after reconnecting
+	stopUpdates           chan struct{} // Closed to end following the updates of the current connection
+	dryRun                bool          // Log changes instead of applying them, see simulated
+	mediaWarningMutex     sync.Mutex
+	mediaWarning          string // Last warning about media players, see warnMedia
+
+	contextMutex   sync.RWMutex // Held while the connection is replaced, see pa
+	reconnectMutex sync.Mutex   // Held while reconnecting
+	callsMutex     sync.Mutex   // Guards the operations in flight below, see hold
+	calls          map[server]int
+	replaced       map[server]bool // Connections to close once their operations are done
+	watchdogMutex  sync.Mutex      // Guards the timeout settings and counts below
+	timeout        time.Duration
+	reconnectAfter int
+	timeouts       int            // Operations that timed out since the start
+	timeoutsInRow  int            // Operations that timed out since PulseAudio last answered
+	abandoned      map[server]int // Operations that timed out and still wait, by connection, see maxAbandoned
+	suspect        bool           // Whether PulseAudio stopped answering and the connection is to be replaced
+
+	seenMutex   sync.Mutex                             // Guards the sources seen below, see reportSeen
+	seenPending map[configuration.TypedTarget]struct{} // Sources seen and not yet reported
+	seenWake    chan struct{}                          // Wakes up the reporting of seen sources
+
+	metering metering // Recordings for the levels of streams and devices, see Meter
 }
 
-func NewPAClient() *PAClient {
-	context, err := pulseaudio.NewClient()
+// Connect connects to the PulseAudio server and returns a client for it
+func Connect() (*PAClient, error) {
+	return ConnectBackend(configuration.PulseAudioBackend)
+}
+
+// ConnectBackend connects to the sound server of a backend, PulseAudio or
+// PipeWire, and returns a client for it
+func ConnectBackend(backend configuration.AudioBackend) (*PAClient, error) {
+	client := newClient(backend)
+	server, err := connectServer(backend, client.currentTimeout)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	client := &PAClient{
-		log:                 log.With().Str("module", "PulseAudio").Logger(),
-		context:             context,
+	client.server = server
+	if backend == configuration.PipeWireBackend {
+		client.log.Info().Msg("Controlling PipeWire directly")
+	}
+	return client, nil
+}
+
+// newClient returns a client for the sound server of a backend without a
+// connection
+func newClient(backend configuration.AudioBackend) *PAClient {
+	return &PAClient{
+		log:                 logging.Module("PulseAudio"),
+		backend:             backend,
 		outputs:             []Stream{},
 		playbackStreams:     []Stream{},
 		inputs:              []Stream{},
 		recordStreams:       []Stream{},
-		previousPlaybackIDs: make(map[string]bool),
-		previousRecordIDs:   make(map[string]bool),
+		previousPlaybackIDs: make(map[string]Stream),
+		previousRecordIDs:   make(map[string]Stream),
 		newStreamCallback:   nil,
 		mediaStatusCallback: nil,
 		monitoringEnabled:   false,
+		timeout:             DefaultTimeout,
+		reconnectAfter:      DefaultReconnectAfter,
+		calls:               make(map[server]int),
+		abandoned:           make(map[server]int),
+		replaced:            make(map[server]bool),
 	}
-	return client
 }
 
-// GetAudioSources returns all audio sources in a format suitable for the UI
-func (client *PAClient) GetAudioSources() []AudioSource {
-	client.refreshStreams()
-
-	// Collect all sources
-	sources := []AudioSource{}
-
-	// Add outputs (sinks)
-	lo.ForEach(client.outputs, func(stream Stream, i int) {
-		// Default volume
-		volume := 75
-
-		// We use estimated values since we can't directly access the volume properties
-		// In a real implementation, we would need to query the actual volume
-		// using the pulseaudio library's methods
-
-		sources = append(sources, AudioSource{
-			ID:         stream.FullName,
-			Name:       stream.Name,
-			BinaryName: stream.BinaryName,
-			Type:       "OutputDevice",
-			Volume:     volume,
-		})
-	})
+// Ping checks that the PulseAudio server still responds. A connection that
+// stopped answering or was lost, like when the server restarted, is replaced
+// first.
+func (client *PAClient) Ping() error {
+	if client.isSuspect() || !client.pa().Connected() {
+		if err := client.reconnect(); err != nil {
+			return err
+		}
+	}
+	_, err := call(client, "ServerInfo", "", client.pa().Defaults)
+	return err
+}
 
-	// Add inputs (sources)
-	lo.ForEach(client.inputs, func(stream Stream, i int) {
-		// Default volume
-		volume := 75
+// Refresh reconnects to the PulseAudio server if the connection was lost,
+// like when it restarted while the system was suspended, and reloads the
+// streams and default devices. With monitoring, the streams that came and
+// went since the last update are reported as usual.
+func (client *PAClient) Refresh() error {
+	if err := client.Ping(); err != nil {
+		return err
+	}
+	if client.Monitoring() {
+		client.handleStreamUpdate()
+		return nil
+	}
+	return client.refreshStreams()
+}
 
-		sources = append(sources, AudioSource{
-			ID:         stream.FullName,
-			Name:       stream.Name,
-			BinaryName: stream.BinaryName,
-			Type:       "InputDevice",
-			Volume:     volume,
-		})
-	})
+// SetDryRun makes the client log the volume, mute, default device and media
+// player changes it would make instead of applying them. Reading and matching
+// streams work as usual.
+func (client *PAClient) SetDryRun(dryRun bool) {
+	client.dryRun = dryRun
+}
 
-	// Add playback streams (sink inputs)
-	lo.ForEach(client.playbackStreams, func(stream Stream, i int) {
-		// Default volume
-		volume := 75
+// DryRun returns whether changes are only logged, see SetDryRun
+func (client *PAClient) DryRun() bool {
+	return client.dryRun
+}
 
-		sources = append(sources, AudioSource{
-			ID:         stream.FullName,
-			Name:       stream.Name,
-			BinaryName: stream.BinaryName,
-			Type:       "PlaybackStream",
-			Volume:     volume,
-		})
+// simulated logs a change that would be applied to the streams and returns
+// true in dry-run mode, in which the caller must not apply it
+func (client *PAClient) simulated(operation string, target string, streams []Stream, value interface{}) bool {
+	if !client.dryRun {
+		return false
+	}
+	names := lo.Map(streams, func(stream Stream, index int) string {
+		return stream.FullName
 	})
+	event := client.log.Info().Str("operation", operation).Str("target", target).Strs("streams", names)
+	if value != nil {
+		event = event.Interface("value", value)
+	}
+	event.Msg("Dry run, not applied")
+	return true
+}
 
-	// Add record streams (source outputs)
-	lo.ForEach(client.recordStreams, func(stream Stream, i int) {
-		// Default volume
-		volume := 75
-
-		sources = append(sources, AudioSource{
-			ID:         stream.FullName,
-			Name:       stream.Name,
-			BinaryName: stream.BinaryName,
-			Type:       "RecordStream",
-			Volume:     volume,
-		})
-	})
+// GetAudioSources returns all audio sources in a format suitable for the UI,
+// with the volumes PulseAudio reports for them
+func (client *PAClient) GetAudioSources() []AudioSource {
+	client.refreshStreams()
+	cache := client.cache()
 
+	sources := []AudioSource{}
+	for _, group := range []struct {
+		streamType configuration.PulseAudioTargetType
+		streams    []Stream
+		defaultID  string
+	}{
+		{configuration.OutputDevice, cache.outputs, cache.defaultSink},
+		{configuration.InputDevice, cache.inputs, cache.defaultSource},
+		{configuration.PlaybackStream, cache.playbackStreams, ""},
+		{configuration.RecordStream, cache.recordStreams, ""},
+	} {
+		for _, stream := range group.streams {
+			sources = append(sources, AudioSource{
+				ID:         stream.FullName,
+				Name:       stream.Name,
+				RawName:    stream.Name,
+				BinaryName: stream.BinaryName,
+				Type:       string(group.streamType),
+				Volume:     int(math.Round(float64(stream.Volume()) * 100)),
+				Muted:      stream.Muted(),
+				Default:    group.defaultID != "" && stream.FullName == group.defaultID,
+				Suspended:  stream.Suspended(),
+				Corked:     stream.Corked(),
+			})
+		}
+	}
 	return sources
 }
 
@@ -169,9 +266,10 @@ func (client *PAClient) GetFocusedWindowPlaybackStreams() ([]Stream, error) {
 	if err := client.refreshStreams(); err != nil {
 		return nil, err
 	}
+	cache := client.cache()
 
-	matches := make([]scoredStream, 0, len(client.playbackStreams))
-	for _, stream := range client.playbackStreams {
+	matches := make([]scoredStream, 0, len(cache.playbackStreams))
+	for _, stream := range cache.playbackStreams {
 		score := scoreFocusedWindowPlaybackStream(window, stream)
 		if score < 60 {
 			continue
@@ -225,16 +323,17 @@ func (client *PAClient) GetFocusedWindowPlaybackStreams() ([]Stream, error) {
 
 func (client *PAClient) List() {
 	client.refreshStreams()
+	cache := client.cache()
 	// List sinks
-	lo.ForEach(client.outputs, func(stream Stream, i int) {
+	lo.ForEach(cache.outputs, func(stream Stream, i int) {
 		client.log.Info().Msgf("Found output device:\t%s", stream.Name)
 	})
 	// List sources
-	lo.ForEach(client.inputs, func(stream Stream, i int) {
+	lo.ForEach(cache.inputs, func(stream Stream, i int) {
 		client.log.Info().Msgf("Found input device:\t%s", stream.Name)
 	})
 	// List sinks inputs
-	lo.ForEach(client.playbackStreams, func(stream Stream, i int) {
+	lo.ForEach(cache.playbackStreams, func(stream Stream, i int) {
 		displayName := stream.Name
 		if stream.BinaryName != "" {
 			displayName = fmt.Sprintf("%s (%s)", stream.Name, stream.BinaryName)
@@ -242,7 +341,7 @@ func (client *PAClient) List() {
 		client.log.Info().Msgf("Found playback stream:\t%s", displayName)
 	})
 	// List sources
-	lo.ForEach(client.recordStreams, func(stream Stream, i int) {
+	lo.ForEach(cache.recordStreams, func(stream Stream, i int) {
 		displayName := stream.Name
 		if stream.BinaryName != "" {
 			displayName = fmt.Sprintf("%s (%s)", stream.Name, stream.BinaryName)
@@ -254,145 +353,135 @@ func (client *PAClient) List() {
 // ListDetailed shows detailed information about streams including all properties
 func (client *PAClient) ListDetailed() {
 	client.refreshStreams()
+	cache := client.cache()
 
 	// List detailed playback streams
 	client.log.Info().Msg("=== Detailed Playback Streams ===")
-	lo.ForEach(client.playbackStreams, func(stream Stream, i int) {
-		if sinkInput, ok := stream.paStream.(pulseaudio.SinkInput); ok {
-			client.log.Info().Msgf("Stream %d: %s", i+1, stream.Name)
-			client.log.Info().Msgf("  Full Name: %s", stream.FullName)
-			if stream.BinaryName != "" {
-				client.log.Info().Msgf("  Binary Name: %s", stream.BinaryName)
-			}
-			client.log.Info().Msg("  Properties:")
-			for key, value := range sinkInput.PropList {
-				client.log.Info().Msgf("    %s: %s", key, value)
-			}
-			client.log.Info().Msg("---")
+	lo.ForEach(cache.playbackStreams, func(stream Stream, i int) {
+		client.log.Info().Msgf("Stream %d: %s", i+1, stream.Name)
+		client.log.Info().Msgf("  Full Name: %s", stream.FullName)
+		if stream.BinaryName != "" {
+			client.log.Info().Msgf("  Binary Name: %s", stream.BinaryName)
+		}
+		client.log.Info().Msg("  Properties:")
+		for key, value := range stream.properties {
+			client.log.Info().Msgf("    %s: %s", key, value)
 		}
+		client.log.Info().Msg("---")
 	})
 
 	// Also list other types with basic info for completeness
-	if len(client.outputs) > 0 {
+	if len(cache.outputs) > 0 {
 		client.log.Info().Msg("=== Output Devices ===")
-		lo.ForEach(client.outputs, func(stream Stream, i int) {
+		lo.ForEach(cache.outputs, func(stream Stream, i int) {
 			client.log.Info().Msgf("Device %d: %s (ID: %s)", i+1, stream.Name, stream.FullName)
 		})
 	}
 
-	if len(client.inputs) > 0 {
+	if len(cache.inputs) > 0 {
 		client.log.Info().Msg("=== Input Devices ===")
-		lo.ForEach(client.inputs, func(stream Stream, i int) {
+		lo.ForEach(cache.inputs, func(stream Stream, i int) {
 			client.log.Info().Msgf("Device %d: %s (ID: %s)", i+1, stream.Name, stream.FullName)
 		})
 	}
 
-	if len(client.recordStreams) > 0 {
+	if len(cache.recordStreams) > 0 {
 		client.log.Info().Msg("=== Record Streams ===")
-		lo.ForEach(client.recordStreams, func(stream Stream, i int) {
-			if sourceOutput, ok := stream.paStream.(pulseaudio.SourceOutput); ok {
-				client.log.Info().Msgf("Stream %d: %s", i+1, stream.Name)
-				client.log.Info().Msgf("  Full Name: %s", stream.FullName)
-				client.log.Info().Msg("  Properties:")
-				for key, value := range sourceOutput.PropList {
-					client.log.Info().Msgf("    %s: %s", key, value)
-				}
-				client.log.Info().Msg("---")
+		lo.ForEach(cache.recordStreams, func(stream Stream, i int) {
+			client.log.Info().Msgf("Stream %d: %s", i+1, stream.Name)
+			client.log.Info().Msgf("  Full Name: %s", stream.FullName)
+			client.log.Info().Msg("  Properties:")
+			for key, value := range stream.properties {
+				client.log.Info().Msgf("    %s: %s", key, value)
 			}
+			client.log.Info().Msg("---")
 		})
 	}
 }
 
 func (client *PAClient) refreshStreams() error {
-	// Sinks
-	sinks, err := client.context.Sinks()
+	groups, err := call(client, "Streams", "", client.pa().Streams)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	client.outputs = lo.Map(sinks, func(sink pulseaudio.Sink, i int) Stream {
-		return Stream{
-			Name:     sink.Description,
-			FullName: sink.Name,
-			paStream: sink,
-		}
+	// The recordings of meters and the loopbacks of virtual sinks are no
+	// streams to control
+	groups.recordStreams = lo.Reject(groups.recordStreams, func(stream Stream, index int) bool {
+		return stream.properties["application.id"] == meterApplicationID || stream.properties["application.id"] == virtualSinkApplicationID
 	})
-	// Sources
-	sources, err := client.context.Sources()
-	if err != nil {
-		panic(err)
-	}
-	client.inputs = lo.Map(sources, func(source pulseaudio.Source, i int) Stream {
-		return Stream{
-			Name:     source.Description,
-			FullName: source.Name,
-			paStream: source,
-		}
+	groups.playbackStreams = lo.Reject(groups.playbackStreams, func(stream Stream, index int) bool {
+		return stream.properties["application.id"] == virtualSinkApplicationID
 	})
-	// Sinks inputs
-	sinksInputs, err := client.context.SinkInputs()
-	if err != nil {
-		panic(err)
-	}
-	client.playbackStreams = lo.Map(sinksInputs, func(sinkInput pulseaudio.SinkInput, i int) Stream {
-		var name string
-		name = sinkInput.PropList["application.name"]
-		if len(name) < 1 {
-			name = sinkInput.PropList["media.name"]
-		}
-		binaryName := sinkInput.PropList["application.process.binary"]
-		mediaName := sinkInput.PropList["media.name"]
-		processID := parseProcessID(sinkInput.PropList["application.process.id"])
-
-		// Create unique ID by combining stream restore ID with object ID
-		objectId := sinkInput.PropList["object.id"]
-		uniqueId := sinkInput.PropList["module-stream-restore.id"]
-		if objectId != "" {
-			uniqueId = uniqueId + ":" + objectId
+	client.cacheMutex.Lock()
+	excludes := client.excludes
+	client.cacheMutex.Unlock()
+	if len(excludes) > 0 {
+		exclude := func(streams []Stream, streamType configuration.PulseAudioTargetType) []Stream {
+			return lo.Reject(streams, func(stream Stream, index int) bool {
+				return configuration.Excluded(excludes, streamType, stream.Name, stream.BinaryName, stream.properties)
+			})
 		}
+		groups.outputs = exclude(groups.outputs, configuration.OutputDevice)
+		groups.inputs = exclude(groups.inputs, configuration.InputDevice)
+		groups.playbackStreams = exclude(groups.playbackStreams, configuration.PlaybackStream)
+		groups.recordStreams = exclude(groups.recordStreams, configuration.RecordStream)
+	}
+	client.cacheMutex.Lock()
+	client.outputs, client.inputs = groups.outputs, groups.inputs
+	client.playbackStreams, client.recordStreams = groups.playbackStreams, groups.recordStreams
+	client.cacheMutex.Unlock()
+	return nil
+}
 
-		return Stream{
-			Name:       name,
-			FullName:   uniqueId,
-			BinaryName: binaryName,
-			MediaName:  mediaName,
-			ProcessID:  processID,
-			paStream:   sinkInput,
-		}
-	})
-	// Sources outputs
-	sourcesOutputs, err := client.context.SourceOutputs()
-	if err != nil {
-		panic(err)
+// streamCache is the streams and default devices of an update, see cache
+type streamCache struct {
+	outputs         []Stream
+	inputs          []Stream
+	playbackStreams []Stream
+	recordStreams   []Stream
+	defaultSink     string
+	defaultSource   string
+}
+
+// cache returns the streams and default devices of the last update. Updates
+// replace the slices instead of changing them, so the ones returned can be
+// read without holding cacheMutex while other goroutines refresh.
+func (client *PAClient) cache() streamCache {
+	client.cacheMutex.RLock()
+	defer client.cacheMutex.RUnlock()
+	return streamCache{
+		outputs:         client.outputs,
+		inputs:          client.inputs,
+		playbackStreams: client.playbackStreams,
+		recordStreams:   client.recordStreams,
+		defaultSink:     client.defaultSink,
+		defaultSource:   client.defaultSource,
 	}
-	client.recordStreams = lo.Map(sourcesOutputs, func(sourceOutput pulseaudio.SourceOutput, i int) Stream {
-		var name string
-		name = sourceOutput.PropList["application.name"]
-		if len(name) < 1 {
-			name = sourceOutput.PropList["media.name"]
-		}
-		binaryName := sourceOutput.PropList["application.process.binary"]
-		mediaName := sourceOutput.PropList["media.name"]
-		processID := parseProcessID(sourceOutput.PropList["application.process.id"])
-
-		// Create unique ID by combining stream restore ID with object ID
-		objectId := sourceOutput.PropList["object.id"]
-		uniqueId := sourceOutput.PropList["module-stream-restore.id"]
-		if objectId != "" {
-			uniqueId = uniqueId + ":" + objectId
-		}
+}
 
-		return Stream{
-			Name:       name,
-			FullName:   uniqueId,
-			BinaryName: binaryName,
-			MediaName:  mediaName,
-			ProcessID:  processID,
-			paStream:   sourceOutput,
-		}
-	})
+// streamsOfType returns the streams or devices of a type of an update
+func (cache streamCache) streamsOfType(streamType configuration.PulseAudioTargetType) []Stream {
+	switch streamType {
+	case configuration.PlaybackStream:
+		return cache.playbackStreams
+	case configuration.RecordStream:
+		return cache.recordStreams
+	case configuration.OutputDevice:
+		return cache.outputs
+	case configuration.InputDevice:
+		return cache.inputs
+	}
 	return nil
 }
 
+// SetExcludes sets the rules of streams and devices to leave out, as if they
+// did not exist. They apply from the next refresh of the streams.
+func (client *PAClient) SetExcludes(rules []configuration.ExcludeRule) {
+	client.cacheMutex.Lock()
+	defer client.cacheMutex.Unlock()
+	client.excludes = rules
+}
+
 func getFocusedWindow() (focusedWindow, error) {
 	cmd := exec.Command("niri", "msg", "--json", "focused-window")
 	output, err := cmd.Output()
@@ -625,9 +714,62 @@ func normalizeMatchString(value string) string {
 	return strings.TrimSpace(builder.String())
 }
 
+// MatchesSource reports whether a stream belongs to a source with the given
+// name and binary name. Sources without a binary name match by name alone.
+func (stream Stream) MatchesSource(name string, binaryName string) bool {
+	return stream.Name == name && (binaryName == "" || stream.BinaryName == binaryName)
+}
+
+// MatchesProcess reports whether a stream belongs to the process with an id
+// and to a process in a cgroup, given as its path or one part of it like a
+// systemd scope. Zero and empty match any process.
+func (stream Stream) MatchesProcess(processID int, cgroup string) bool {
+	if processID != 0 && stream.ProcessID != processID {
+		return false
+	}
+	if cgroup == "" {
+		return true
+	}
+	path := processCgroup(stream.ProcessID)
+	if path == "" {
+		return false
+	}
+	if path == cgroup {
+		return true
+	}
+	part := strings.Trim(cgroup, "/")
+	return part != "" && slices.Contains(strings.Split(path, "/"), part)
+}
+
+// processCgroup returns the cgroup path of a process, empty if unknown. That
+// of the unified hierarchy is preferred, with the legacy hierarchies of older
+// systems the one of systemd is used.
+func processCgroup(processID int) string {
+	if processID == 0 {
+		return ""
+	}
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cgroup", processID))
+	if err != nil {
+		return ""
+	}
+	var unified, systemd string
+	for _, line := range strings.Split(string(data), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			unified = path
+		} else if _, path, ok := strings.Cut(line, ":name=systemd:"); ok {
+			systemd = path
+		}
+	}
+	if unified != "" && unified != "/" {
+		return unified
+	}
+	return systemd
+}
+
 // SmartMatchStreams is a public wrapper for smart matching by source type and name
 func (client *PAClient) SmartMatchStreams(sourceType configuration.PulseAudioTargetType, sourceName string) ([]Stream, *Stream) {
 	client.refreshStreams()
+	cache := client.cache()
 
 	target := &configuration.TypedTarget{
 		Type:       sourceType,
@@ -637,9 +779,9 @@ func (client *PAClient) SmartMatchStreams(sourceType configuration.PulseAudioTar
 
 	switch sourceType {
 	case configuration.PlaybackStream:
-		return client.smartMatchStreams(client.playbackStreams, target)
+		return client.smartMatchStreams(cache.playbackStreams, target)
 	case configuration.RecordStream:
-		return client.smartMatchStreams(client.recordStreams, target)
+		return client.smartMatchStreams(cache.recordStreams, target)
 	default:
 		return nil, nil // No migration needed for device types
 	}
@@ -664,9 +806,12 @@ func (client *PAClient) smartMatchStreams(streams []Stream, target *configuratio
 			Str("targetBinaryName", target.BinaryName).
 			Msg("Checking stream for match")
 
+		if !stream.MatchesProcess(target.ProcessID, target.Cgroup) {
+			continue
+		}
 		if target.BinaryName != "" {
 			// Enhanced config: exact match required
-			if stream.Name == target.Name && stream.BinaryName == target.BinaryName {
+			if stream.MatchesSource(target.Name, target.BinaryName) {
 				client.log.Debug().
 					Str("streamName", stream.Name).
 					Str("streamBinaryName", stream.BinaryName).
@@ -675,7 +820,7 @@ func (client *PAClient) smartMatchStreams(streams []Stream, target *configuratio
 			}
 		} else {
 			// Legacy config: name match triggers migration
-			if stream.Name == target.Name {
+			if stream.MatchesSource(target.Name, "") {
 				client.log.Debug().
 					Str("streamName", stream.Name).
 					Str("streamBinaryName", stream.BinaryName).
@@ -695,80 +840,194 @@ func (client *PAClient) smartMatchStreams(streams []Stream, target *configuratio
 	return matchedStreams, migrationStream
 }
 
-func (client *PAClient) ProcessVolumeAction(action configuration.Action, volumePercent float32) error {
+// matchTargetStreams returns the cached streams matching a typed target
+func (client *PAClient) matchTargetStreams(target *configuration.TypedTarget) []Stream {
+	cache := client.cache()
 	var streams []Stream
-	client.refreshStreams()
-	switch target := action.Target.(type) {
-	case *configuration.TypedTarget:
-		if target.Type == configuration.OutputDevice {
-			if target.Name == "Default" {
-				if defaultSink, err := client.context.GetDefaultSink(); err == nil {
-					streams = slices.Concat(streams, lo.Filter(client.outputs, func(stream Stream, i int) bool {
-						return stream.FullName == defaultSink.Name
-					}))
-				}
-			} else {
-				streams = slices.Concat(streams, lo.Filter(client.outputs, func(stream Stream, i int) bool {
-					return stream.Name == target.Name
+	if target.Type == configuration.OutputDevice {
+		if target.Name == "Default" {
+			if defaults, err := client.defaults(cache); err == nil {
+				streams = slices.Concat(streams, lo.Filter(cache.outputs, func(stream Stream, i int) bool {
+					return stream.FullName == defaults.sink
 				}))
 			}
-		} else if target.Type == configuration.InputDevice {
-			if target.Name == "Default" {
-				if defaultSource, err := client.context.GetDefaultSource(); err == nil {
-					streams = slices.Concat(streams, lo.Filter(client.inputs, func(stream Stream, i int) bool {
-						return stream.FullName == defaultSource.Name
-					}))
-				}
-			} else {
-				streams = slices.Concat(streams, lo.Filter(client.inputs, func(stream Stream, i int) bool {
-					return stream.Name == target.Name
+		} else {
+			streams = slices.Concat(streams, lo.Filter(cache.outputs, func(stream Stream, i int) bool {
+				return stream.Name == target.Name
+			}))
+		}
+	} else if target.Type == configuration.InputDevice {
+		if target.Name == "Default" {
+			if defaults, err := client.defaults(cache); err == nil {
+				streams = slices.Concat(streams, lo.Filter(cache.inputs, func(stream Stream, i int) bool {
+					return stream.FullName == defaults.source
 				}))
 			}
-		} else if target.Type == configuration.PlaybackStream {
-			matchedStreams, migrationNeeded := client.smartMatchStreams(client.playbackStreams, target)
-			if migrationNeeded != nil {
-				// TODO: Trigger migration callback here
-				// For now, just log that migration would be needed
-				client.log.Info().
-					Str("targetName", target.Name).
-					Str("streamBinary", migrationNeeded.BinaryName).
-					Msg("Config migration needed: would set binaryName")
-			}
-			streams = slices.Concat(streams, matchedStreams)
-		} else if target.Type == configuration.RecordStream {
-			matchedStreams, migrationNeeded := client.smartMatchStreams(client.recordStreams, target)
-			if migrationNeeded != nil {
-				client.log.Info().
-					Str("targetName", target.Name).
-					Str("streamBinary", migrationNeeded.BinaryName).
-					Msg("Config migration needed: would set binaryName")
-			}
-			streams = slices.Concat(streams, matchedStreams)
+		} else {
+			streams = slices.Concat(streams, lo.Filter(cache.inputs, func(stream Stream, i int) bool {
+				return stream.Name == target.Name
+			}))
+		}
+	} else if target.Type == configuration.PlaybackStream {
+		matchedStreams, migrationNeeded := client.smartMatchStreams(cache.playbackStreams, target)
+		if migrationNeeded != nil {
+			// TODO: Trigger migration callback here
+			// For now, just log that migration would be needed
+			client.log.Info().
+				Str("targetName", target.Name).
+				Str("streamBinary", migrationNeeded.BinaryName).
+				Msg("Config migration needed: would set binaryName")
+		}
+		streams = slices.Concat(streams, matchedStreams)
+	} else if target.Type == configuration.RecordStream {
+		matchedStreams, migrationNeeded := client.smartMatchStreams(cache.recordStreams, target)
+		if migrationNeeded != nil {
+			client.log.Info().
+				Str("targetName", target.Name).
+				Str("streamBinary", migrationNeeded.BinaryName).
+				Msg("Config migration needed: would set binaryName")
+		}
+		streams = slices.Concat(streams, matchedStreams)
+	}
+	if len(streams) > 0 {
+		client.reportSeen(*target)
+	}
+	return streams
+}
+
+// defaults returns the default output and input device. With monitoring,
+// the updates keep track of them and PulseAudio isn't asked.
+func (client *PAClient) defaults(cache streamCache) (defaultDevices, error) {
+	if client.Monitoring() && cache.defaultSink != "" {
+		return defaultDevices{sink: cache.defaultSink, source: cache.defaultSource}, nil
+	}
+	return call(client, "ServerInfo", "", client.pa().Defaults)
+}
+
+// currentStreams makes sure the cached streams are current before changing
+// them. With monitoring, the updates keep them current and they are used as
+// they are, so that a fader sweep doesn't reload them for every value.
+func (client *PAClient) currentStreams() error {
+	if client.Monitoring() {
+		return nil
+	}
+	return client.refreshStreams()
+}
+
+// reportSeen passes a source that matched streams to the source seen
+// callback. The callback takes the configuration lock, so it is called from
+// a goroutine of its own instead of while volumes are being set.
+func (client *PAClient) reportSeen(target configuration.TypedTarget) {
+	client.seenMutex.Lock()
+	defer client.seenMutex.Unlock()
+	if client.seenWake == nil {
+		return
+	}
+	client.seenPending[target] = struct{}{}
+	select {
+	case client.seenWake <- struct{}{}:
+	default:
+		// The pending sources are reported already
+	}
+}
+
+// reportSeenSources calls the source seen callback for the pending sources
+func (client *PAClient) reportSeenSources(wake chan struct{}) {
+	for range wake {
+		client.seenMutex.Lock()
+		pending := client.seenPending
+		client.seenPending = make(map[configuration.TypedTarget]struct{})
+		callback := client.sourceSeenCallback
+		client.seenMutex.Unlock()
+		if callback == nil {
+			continue
 		}
+		for target := range pending {
+			callback(target)
+		}
+	}
+}
+
+// ProcessVolumeAction sets the volume of the streams or devices matching the
+// target of an action. With monitoring, they are looked up among the cached
+// ones, see currentStreams.
+func (client *PAClient) ProcessVolumeAction(action configuration.Action, volumePercent float32) error {
+	var streams []Stream
+	var targetName string
+	if err := client.currentStreams(); err != nil {
+		return err
+	}
+	switch target := action.Target.(type) {
+	case *configuration.TypedTarget:
+		streams = client.matchTargetStreams(target)
+		targetName = target.Name
 	case *configuration.Target:
 	default:
 	}
-	lo.ForEach(streams, func(stream Stream, index int) {
-		switch st := stream.paStream.(type) {
-		case pulseaudio.Sink:
-			st.SetVolume(volumePercent)
-			client.log.Debug().Msgf("Set %s volume to %f", stream.Name, volumePercent)
-		case pulseaudio.SinkInput:
-			st.SetVolume(volumePercent)
-			client.log.Debug().Msgf("Set %s volume to %f", stream.Name, volumePercent)
-		case pulseaudio.Source:
-			st.SetVolume(volumePercent)
-			client.log.Debug().Msgf("Set %s volume to %f", stream.Name, volumePercent)
-		case pulseaudio.SourceOutput:
-			st.SetVolume(volumePercent)
-			client.log.Debug().Msgf("Set %s volume to %f", stream.Name, volumePercent)
+	if client.simulated("SetVolume", targetName, streams, volumePercent) {
+		return nil
+	}
+	for _, stream := range streams {
+		device, ok := stream.paStream.(pulseaudio.Device)
+		if !ok {
+			continue
 		}
-	})
+		if err := client.callErr("SetVolume", stream.Name, func() error { return device.SetVolume(volumePercent) }); err != nil {
+			return fmt.Errorf("failed to set volume of %s: %w", stream.Name, err)
+		}
+		client.log.Debug().Msgf("Set %s volume to %f", stream.Name, volumePercent)
+	}
+	return nil
+}
+
+// GetTargetVolume returns the current volume (0.0-1.0) of the first stream matching a typed target
+func (client *PAClient) GetTargetVolume(target *configuration.TypedTarget) (float32, bool) {
+	if err := client.refreshStreams(); err != nil {
+		return 0, false
+	}
+	for _, stream := range client.matchTargetStreams(target) {
+		if _, ok := stream.paStream.(pulseaudio.Device); ok {
+			return stream.Volume(), true
+		}
+	}
+	return 0, false
+}
+
+// SteppedVolume returns the volume in percent that a step in percent moves
+// a volume (0.0-1.0) to, down for a negative step. It stays between 0 and
+// 100, a volume boosted above 100 is only lowered.
+func SteppedVolume(volume float32, step int) int {
+	current := int(volume*100 + 0.5)
+	return min(max(current+step, 0), max(current, 100))
+}
+
+// SetTargetMute mutes or unmutes all streams matching a typed target
+func (client *PAClient) SetTargetMute(target *configuration.TypedTarget, muted bool) error {
+	if err := client.refreshStreams(); err != nil {
+		return err
+	}
+	streams := client.matchTargetStreams(target)
+	if client.simulated("SetMute", target.Name, streams, muted) {
+		return nil
+	}
+	for _, stream := range streams {
+		device, ok := stream.paStream.(pulseaudio.Device)
+		if !ok {
+			continue
+		}
+		if err := client.callErr("SetMute", stream.Name, func() error { return device.SetMute(muted) }); err != nil {
+			return fmt.Errorf("failed to set mute of %s: %w", stream.Name, err)
+		}
+		client.log.Debug().Msgf("Set %s muted to %t", stream.Name, muted)
+	}
 	return nil
 }
 
 func (client *PAClient) SetDefaultOutput(action configuration.Action) error {
-	client.refreshStreams()
+	if err := client.refreshStreams(); err != nil {
+		return err
+	}
+	cache := client.cache()
 	switch target := action.Target.(type) {
 	case *configuration.Target:
 		if target.Name == "" {
@@ -776,11 +1035,13 @@ func (client *PAClient) SetDefaultOutput(action configuration.Action) error {
 		}
 
 		// Find the output device
-		for _, stream := range client.outputs {
+		for _, stream := range cache.outputs {
 			if stream.Name == target.Name {
+				if client.simulated("SetDefaultOutput", target.Name, []Stream{stream}, nil) {
+					return nil
+				}
 				client.log.Debug().Msgf("Setting %s as default output", stream.Name)
-				// The pulseaudio library expects a name string, not a Sink object
-				return client.context.SetDefaultSink(stream.FullName)
+				return client.callErr("SetDefaultSink", stream.Name, func() error { return client.pa().SetDefaultSink(stream.FullName) })
 			}
 		}
 	default:
@@ -788,6 +1049,35 @@ func (client *PAClient) SetDefaultOutput(action configuration.Action) error {
 	return nil
 }
 
+// MoveStreams moves the playback streams matching a target to the output
+// device with a name
+func (client *PAClient) MoveStreams(target *configuration.TypedTarget, sink string) error {
+	if err := client.refreshStreams(); err != nil {
+		return err
+	}
+	cache := client.cache()
+	device, ok := lo.Find(cache.outputs, func(stream Stream) bool {
+		return stream.Name == sink
+	})
+	if !ok {
+		return fmt.Errorf("no output device %s", sink)
+	}
+	streams := client.matchTargetStreams(target)
+	if len(streams) == 0 {
+		return fmt.Errorf("no playback stream matches %s", target.Name)
+	}
+	if client.simulated("MoveStream", target.Name, streams, sink) {
+		return nil
+	}
+	for _, stream := range streams {
+		if err := client.callErr("MoveStream", stream.Name, func() error { return client.pa().MoveStream(stream, device.FullName) }); err != nil {
+			return fmt.Errorf("failed to move %s to %s: %w", stream.Name, sink, err)
+		}
+		client.log.Debug().Msgf("Moved %s to %s", stream.Name, sink)
+	}
+	return nil
+}
+
 // SetNewStreamCallback sets the callback function that will be called when new streams are detected
 func (client *PAClient) SetNewStreamCallback(callback StreamEventCallback) {
 	client.newStreamCallback = callback
@@ -803,73 +1093,153 @@ func (client *PAClient) SetMediaStatusCallback(callback MediaStatusCallback) {
 	client.mediaStatusCallback = callback
 }
 
+// SetSourceSeenCallback sets the callback function that will be called when a configured source matches,
+// from a goroutine of its own, see reportSeen
+func (client *PAClient) SetSourceSeenCallback(callback SourceSeenCallback) {
+	client.seenMutex.Lock()
+	defer client.seenMutex.Unlock()
+	client.sourceSeenCallback = callback
+	if callback == nil || client.seenWake != nil {
+		return
+	}
+	client.seenPending = make(map[configuration.TypedTarget]struct{})
+	client.seenWake = make(chan struct{}, 1)
+	wake := client.seenWake
+	supervise.Go("pulseaudio.sourceSeen", func() {
+		client.reportSeenSources(wake)
+	})
+}
+
+// SetEventHandler sets the handler of stream and device events, usually the
+// Notify of the configuration manager so that its subscribers get them
+func (client *PAClient) SetEventHandler(handler EventHandler) {
+	client.eventHandler = handler
+}
+
 // StartStreamMonitoring begins monitoring for new audio streams
 func (client *PAClient) StartStreamMonitoring() error {
+	client.monitoringMutex.Lock()
+	defer client.monitoringMutex.Unlock()
+	client.monitoringWanted = true
 	if client.monitoringEnabled {
 		return nil
 	}
+	return client.startMonitoring()
+}
 
-	// Subscribe to sink input and source output events (new streams)
-	subscriptionMask := pulseaudio.SUBSCRIPTION_MASK_SINK_INPUT | pulseaudio.SUBSCRIPTION_MASK_SOURCE_OUTPUT
-	updates, err := client.context.UpdatesByType(pulseaudio.DevType(subscriptionMask))
+// startMonitoring subscribes to the events of the current connection. Must be
+// called with monitoringMutex held.
+func (client *PAClient) startMonitoring() error {
+	// Subscribe to stream, device and default device changes
+	updates, err := call(client, "Subscribe", "", client.pa().Updates)
 	if err != nil {
-		return fmt.Errorf("failed to subscribe to PulseAudio events: %w", err)
+		return fmt.Errorf("failed to subscribe to %s events: %w", backendName(client.backend), err)
 	}
 
 	// Initialize the previous stream IDs by getting current state
+	client.updateMutex.Lock()
 	client.refreshStreams()
-	client.updatePreviousStreamIDs()
+	client.updatePreviousStreamIDs(client.cache())
+	if defaults, err := call(client, "ServerInfo", "", client.pa().Defaults); err == nil {
+		client.cacheMutex.Lock()
+		client.defaultSink, client.defaultSource = defaults.sink, defaults.source
+		client.cacheMutex.Unlock()
+	}
+	client.sourcesState = fmt.Sprint(client.CachedStreams())
+	client.updateMutex.Unlock()
 
 	client.monitoringEnabled = true
 	client.log.Info().Msg("Started monitoring for new audio streams")
 
 	// Start goroutine to handle updates
-	go func() {
-		for range updates {
-			if !client.monitoringEnabled {
-				break
+	stop := make(chan struct{})
+	client.stopUpdates = stop
+	supervise.Go("pulseaudio.streamUpdates", func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case _, ok := <-updates:
+				if !ok {
+					// The updates end when the connection is lost, replace
+					// it right away instead of at the next ping
+					client.log.Warn().Msgf("Updates of %s ended, reconnecting", backendName(client.backend))
+					client.Ping()
+					return
+				}
+				if !client.Monitoring() {
+					return
+				}
+				client.handleStreamUpdate()
 			}
-			client.handleStreamUpdate()
 		}
-	}()
+	})
 
 	return nil
 }
 
+// Monitoring returns whether PulseAudio reports changes of the streams, see
+// StartStreamMonitoring. Without, the streams are only seen when asked for.
+func (client *PAClient) Monitoring() bool {
+	client.monitoringMutex.Lock()
+	defer client.monitoringMutex.Unlock()
+	return client.monitoringEnabled
+}
+
 // StopStreamMonitoring stops monitoring for new audio streams
 func (client *PAClient) StopStreamMonitoring() {
+	client.monitoringMutex.Lock()
+	defer client.monitoringMutex.Unlock()
+	client.monitoringWanted = false
 	if !client.monitoringEnabled {
 		return
 	}
 	client.monitoringEnabled = false
+	client.endUpdates()
 	client.log.Info().Msg("Stopped monitoring for new audio streams")
 }
 
+// endUpdates stops following the updates of the current connection. Must be
+// called with monitoringMutex held.
+func (client *PAClient) endUpdates() {
+	if client.stopUpdates != nil {
+		close(client.stopUpdates)
+		client.stopUpdates = nil
+	}
+}
+
 // updatePreviousStreamIDs updates the tracking maps with current stream IDs
-func (client *PAClient) updatePreviousStreamIDs() {
+func (client *PAClient) updatePreviousStreamIDs(cache streamCache) {
 	// Clear previous IDs
-	client.previousPlaybackIDs = make(map[string]bool)
-	client.previousRecordIDs = make(map[string]bool)
+	client.previousPlaybackIDs = make(map[string]Stream)
+	client.previousRecordIDs = make(map[string]Stream)
 
 	// Add current playback streams
-	for _, stream := range client.playbackStreams {
-		client.previousPlaybackIDs[stream.FullName] = true
+	for _, stream := range cache.playbackStreams {
+		client.previousPlaybackIDs[stream.FullName] = stream
 	}
 
 	// Add current record streams
-	for _, stream := range client.recordStreams {
-		client.previousRecordIDs[stream.FullName] = true
+	for _, stream := range cache.recordStreams {
+		client.previousRecordIDs[stream.FullName] = stream
 	}
 }
 
 // handleStreamUpdate is called when PulseAudio sends an update event
 func (client *PAClient) handleStreamUpdate() {
-	// Refresh to get latest streams
-	client.refreshStreams()
+	// Updates of the monitoring goroutine and Refresh take turns
+	client.updateMutex.Lock()
+	defer client.updateMutex.Unlock()
+
+	// Refresh to get latest streams, a timeout waits for the next update
+	if err := client.refreshStreams(); err != nil {
+		return
+	}
+	cache := client.cache()
 
 	// Check for new playback streams
-	for _, stream := range client.playbackStreams {
-		if !client.previousPlaybackIDs[stream.FullName] {
+	for _, stream := range cache.playbackStreams {
+		if _, ok := client.previousPlaybackIDs[stream.FullName]; !ok {
 			client.log.Info().
 				Str("streamName", stream.Name).
 				Str("binaryName", stream.BinaryName).
@@ -879,12 +1249,13 @@ func (client *PAClient) handleStreamUpdate() {
 			if client.newStreamCallback != nil {
 				client.newStreamCallback(stream, configuration.PlaybackStream)
 			}
+			client.emit("source.added", StreamEvent(stream, configuration.PlaybackStream))
 		}
 	}
 
 	// Check for new record streams
-	for _, stream := range client.recordStreams {
-		if !client.previousRecordIDs[stream.FullName] {
+	for _, stream := range cache.recordStreams {
+		if _, ok := client.previousRecordIDs[stream.FullName]; !ok {
 			client.log.Info().
 				Str("streamName", stream.Name).
 				Str("binaryName", stream.BinaryName).
@@ -894,97 +1265,150 @@ func (client *PAClient) handleStreamUpdate() {
 			if client.newStreamCallback != nil {
 				client.newStreamCallback(stream, configuration.RecordStream)
 			}
+			client.emit("source.added", StreamEvent(stream, configuration.RecordStream))
 		}
 	}
 
 	// Check for removed playback streams
-	if client.removedStreamCallback != nil {
-		currentPlaybackIDs := make(map[string]bool)
-		for _, stream := range client.playbackStreams {
-			currentPlaybackIDs[stream.FullName] = true
-		}
+	currentPlaybackIDs := make(map[string]bool)
+	for _, stream := range cache.playbackStreams {
+		currentPlaybackIDs[stream.FullName] = true
+	}
+	for streamID, removedStream := range client.previousPlaybackIDs {
+		if !currentPlaybackIDs[streamID] {
+			client.log.Info().
+				Str("streamID", streamID).
+				Msg("Playback stream removed")
 
-		for streamID := range client.previousPlaybackIDs {
-			if !currentPlaybackIDs[streamID] {
-				client.log.Info().
-					Str("streamID", streamID).
-					Msg("Playback stream removed")
-
-				// Create a dummy stream object for the callback (we only have the ID)
-				removedStream := Stream{
-					FullName:   streamID,
-					Name:       "Unknown", // We can't recover the name after removal
-					BinaryName: "Unknown",
-				}
+			if client.removedStreamCallback != nil {
 				client.removedStreamCallback(removedStream, configuration.PlaybackStream)
 			}
+			client.emit("source.removed", StreamEvent(removedStream, configuration.PlaybackStream))
 		}
 	}
 
 	// Check for removed record streams
-	if client.removedStreamCallback != nil {
-		currentRecordIDs := make(map[string]bool)
-		for _, stream := range client.recordStreams {
-			currentRecordIDs[stream.FullName] = true
-		}
+	currentRecordIDs := make(map[string]bool)
+	for _, stream := range cache.recordStreams {
+		currentRecordIDs[stream.FullName] = true
+	}
+	for streamID, removedStream := range client.previousRecordIDs {
+		if !currentRecordIDs[streamID] {
+			client.log.Info().
+				Str("streamID", streamID).
+				Msg("Record stream removed")
 
-		for streamID := range client.previousRecordIDs {
-			if !currentRecordIDs[streamID] {
-				client.log.Info().
-					Str("streamID", streamID).
-					Msg("Record stream removed")
-
-				// Create a dummy stream object for the callback (we only have the ID)
-				removedStream := Stream{
-					FullName:   streamID,
-					Name:       "Unknown", // We can't recover the name after removal
-					BinaryName: "Unknown",
-				}
+			if client.removedStreamCallback != nil {
 				client.removedStreamCallback(removedStream, configuration.RecordStream)
 			}
+			client.emit("source.removed", StreamEvent(removedStream, configuration.RecordStream))
 		}
 	}
 
 	// Update previous IDs for next comparison
-	client.updatePreviousStreamIDs()
+	client.updatePreviousStreamIDs(cache)
+
+	client.checkDefaultDevices()
+	client.checkSourcesChanged()
+}
+
+// checkSourcesChanged reports sources.changed when streams or devices came,
+// went or changed their volume, mute, default or paused state since the last
+// update.
+// PulseAudio also sends events for changes nobody sees, like of a stream's
+// position, those are left out.
+func (client *PAClient) checkSourcesChanged() {
+	state := fmt.Sprint(client.CachedStreams())
+	if state == client.sourcesState {
+		return
+	}
+	client.sourcesState = state
+	client.emit("sources.changed", map[string]interface{}{})
+}
+
+// checkDefaultDevices reports changes of the default output and input
+func (client *PAClient) checkDefaultDevices() {
+	if client.eventHandler == nil {
+		return
+	}
+	defaults, err := call(client, "ServerInfo", "", client.pa().Defaults)
+	if err != nil {
+		return
+	}
+	if defaults.sink != client.defaultSink && client.defaultSink != "" {
+		client.log.Info().Str("output", defaults.sink).Msg("Default output changed")
+		client.emit("default.output.changed", map[string]interface{}{
+			"name":     defaults.sink,
+			"previous": client.defaultSink,
+		})
+	}
+	if defaults.source != client.defaultSource && client.defaultSource != "" {
+		client.log.Info().Str("input", defaults.source).Msg("Default input changed")
+		client.emit("default.input.changed", map[string]interface{}{
+			"name":     defaults.source,
+			"previous": client.defaultSource,
+		})
+	}
+	client.cacheMutex.Lock()
+	client.defaultSink, client.defaultSource = defaults.sink, defaults.source
+	client.cacheMutex.Unlock()
+}
+
+// StreamEvent returns the details of a stream event, the data of source.added
+// and source.removed
+func StreamEvent(stream Stream, streamType configuration.PulseAudioTargetType) map[string]interface{} {
+	return map[string]interface{}{
+		"source": configuration.Source{
+			Type:       streamType,
+			Name:       stream.Name,
+			BinaryName: stream.BinaryName,
+		},
+		"streamId":  stream.FullName,
+		"mediaName": stream.MediaName,
+		"processId": stream.ProcessID,
+	}
+}
+
+// emit passes an event to the event handler, if one is set
+func (client *PAClient) emit(topic string, data map[string]interface{}) {
+	if client.eventHandler != nil {
+		client.eventHandler(topic, data)
+	}
 }
 
 // ProcessMediaControlAction handles media control actions like play/pause
 func (client *PAClient) ProcessMediaControlAction(action configuration.Action) error {
 	switch action.Type {
 	case configuration.MediaPlayPause:
-		client.log.Info().Msg("Executing media play/pause command")
-		return client.executeMediaPlayPause()
+		// The target optionally names the player
+		var playerName string
+		if target, ok := action.Target.(*configuration.Target); ok && target != nil {
+			playerName = target.Name
+		}
+		return client.mediaPlayPause(playerName)
 	default:
 		return fmt.Errorf("unsupported media control action: %s", action.Type)
 	}
 }
 
-// executeMediaPlayPause sends a media play/pause command via playerctl
-func (client *PAClient) executeMediaPlayPause() error {
-	cmd := "playerctl play-pause"
-
-	if err := client.executeCommand(cmd); err != nil {
-		client.log.Error().Err(err).Msg("playerctl play-pause failed")
-		return fmt.Errorf("failed to send media play/pause command: %w", err)
-	}
-
-	client.log.Info().Msg("Successfully sent media play/pause command")
-	return nil
-}
-
 // IsMediaPlaying checks if any media player is currently playing
 func (client *PAClient) IsMediaPlaying() bool {
-	cmd := exec.Command("playerctl", "status")
-	output, err := cmd.Output()
+	conn, err := dbus.SessionBus()
 	if err != nil {
-		client.log.Debug().Err(err).Msg("playerctl status failed (no player?)")
+		client.log.Debug().Err(err).Msg("No D-Bus session bus for the media status")
 		return false
 	}
-
-	status := strings.TrimSpace(string(output))
-	isPlaying := status == "Playing"
-	client.log.Debug().Str("status", status).Bool("playing", isPlaying).Msg("Media status check")
+	ctx, cancel := context.WithTimeout(context.Background(), mprisTimeout)
+	defer cancel()
+	players, err := listPlayers(ctx, conn)
+	if err != nil {
+		client.log.Debug().Err(err).Msg("Failed to list media players")
+		return false
+	}
+	isPlaying := slices.ContainsFunc(players, func(player mprisPlayer) bool {
+		return player.status == "Playing"
+	})
+	client.log.Debug().Int("players", len(players)).Bool("playing", isPlaying).Msg("Media status check")
 	return isPlaying
 }
 
@@ -1006,12 +1430,10 @@ func (client *PAClient) StartMediaStatusMonitoring() error {
 	ch := make(chan *dbus.Signal, 10)
 	conn.Signal(ch)
 
-	// Start goroutine to handle signals
-	go func() {
-		defer conn.Close()
-
-		var currentPlayingStatus bool
-
+	// Start goroutine to handle signals. The status outlives a restart, so
+	// a restarted handler doesn't report an unchanged status again.
+	var currentPlayingStatus bool
+	supervise.Go("pulseaudio.mediaStatus", func() {
 		for signal := range ch {
 			if signal.Name == "org.freedesktop.DBus.Properties.PropertiesChanged" &&
 				len(signal.Body) >= 2 {
@@ -1053,18 +1475,8 @@ func (client *PAClient) StartMediaStatusMonitoring() error {
 				}
 			}
 		}
-	}()
+	})
 
 	client.log.Info().Msg("MPRIS media status monitoring started")
 	return nil
 }
-
-// executeCommand executes a shell command
-func (client *PAClient) executeCommand(cmd string) error {
-	// Execute the command using shell
-	execCmd := exec.Command("sh", "-c", cmd)
-	if err := execCmd.Run(); err != nil {
-		return fmt.Errorf("failed to execute command: %w", err)
-	}
-	return nil
-}
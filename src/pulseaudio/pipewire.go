@@ -0,0 +1,506 @@
+package pulseaudio
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/the-jonsey/pulseaudio"
+)
+
+// Media classes of the PipeWire nodes pulsekontrol controls
+const (
+	pipeWireSink          = "Audio/Sink"
+	pipeWireSource        = "Audio/Source"
+	pipeWireVirtualSource = "Audio/Source/Virtual"
+	pipeWirePlayback      = "Stream/Output/Audio"
+	pipeWireRecord        = "Stream/Input/Audio"
+)
+
+// pipeWireServer drives PipeWire with its own tools, pw-dump to read the
+// nodes and wpctl of WirePlumber to change them, so neither pipewire-pulse
+// nor PulseAudio are needed for volumes, mute, default devices and routing.
+// While the changes are followed, the nodes are read from what the monitor
+// reported instead of running pw-dump each time.
+type pipeWireServer struct {
+	timeout   func() time.Duration
+	connected atomic.Bool // Whether the last tool reached PipeWire
+	lost      atomic.Bool // Whether the monitor ended on its own, like when PipeWire restarted
+	mu        sync.Mutex
+	ids       map[string]int                 // Node ids by node name, from the last dump
+	monitor   *exec.Cmd                      // pw-dump following changes, see Updates
+	objects   map[int]map[string]interface{} // Objects as the monitor reported them, nil until its first dump
+}
+
+// pipeWireObject is an object of pw-dump, of the nodes and metadata only the
+// parts pulsekontrol needs
+type pipeWireObject struct {
+	ID   int    `json:"id"`
+	Type string `json:"type"`
+	Info *struct {
+		State  string                 `json:"state"` // Of nodes, like running, idle or suspended
+		Props  map[string]interface{} `json:"props"`
+		Params struct {
+			Props []struct {
+				ChannelVolumes []float64 `json:"channelVolumes"`
+				ChannelMap     []string  `json:"channelMap"`
+				Mute           bool      `json:"mute"`
+			} `json:"Props"`
+			EnumProfile []pipeWireProfile `json:"EnumProfile"`
+			Profile     []pipeWireProfile `json:"Profile"` // The active one
+		} `json:"params"`
+	} `json:"info"`
+	Props    map[string]interface{} `json:"props"`
+	Metadata []struct {
+		Key   string          `json:"key"`
+		Value json.RawMessage `json:"value"`
+	} `json:"metadata"`
+}
+
+// pipeWireProfile is a profile of a device, like the cards of PulseAudio have
+type pipeWireProfile struct {
+	Index       int    `json:"index"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Priority    int    `json:"priority"`
+	Available   string `json:"available"` // yes, no or unknown
+}
+
+// pipeWireNode is a device or stream of PipeWire, changed with wpctl
+type pipeWireNode struct {
+	server    *pipeWireServer
+	id        int
+	volume    float32
+	muted     bool
+	suspended bool
+	idle      bool      // Not running, idle or suspended
+	channels  []float64 // Linear volumes of the channels
+	positions []string  // Positions of the channels, like FL and FR
+}
+
+func connectPipeWire(timeout func() time.Duration) (*pipeWireServer, error) {
+	if _, err := exec.LookPath("wpctl"); err != nil {
+		return nil, fmt.Errorf("wpctl of WirePlumber is needed to control PipeWire: %w", err)
+	}
+	s := &pipeWireServer{timeout: timeout, ids: make(map[string]int)}
+	if _, err := s.dump(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// dump returns the objects of PipeWire and remembers the node ids. They come
+// from the monitor while it runs, or else from running pw-dump.
+func (s *pipeWireServer) dump() ([]pipeWireObject, error) {
+	objects, ok, err := s.monitored()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		output, err := runTool(s.timeout(), "pw-dump", "--no-colors")
+		s.connected.Store(err == nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(output, &objects); err != nil {
+			return nil, fmt.Errorf("failed to parse pw-dump: %w", err)
+		}
+	}
+	ids := make(map[string]int)
+	for _, object := range objects {
+		if object.Info != nil {
+			if name := property(object.Info.Props, "node.name"); name != "" {
+				ids[name] = object.ID
+			}
+		}
+	}
+	s.mu.Lock()
+	s.ids = ids
+	s.mu.Unlock()
+	return objects, nil
+}
+
+// wpctl runs a wpctl command
+func (s *pipeWireServer) wpctl(args ...string) error {
+	_, err := runTool(s.timeout(), "wpctl", args...)
+	return err
+}
+
+// property returns a property of pw-dump as a string, they may be numbers
+func property(props map[string]interface{}, key string) string {
+	switch value := props[key].(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// Connected also fails once the monitor ended on its own, PipeWire may be
+// back already but its changes are no longer followed
+func (s *pipeWireServer) Connected() bool {
+	return s.connected.Load() && !s.lost.Load()
+}
+
+func (s *pipeWireServer) Streams() (streamGroups, error) {
+	var groups streamGroups
+	objects, err := s.dump()
+	if err != nil {
+		return groups, err
+	}
+	for _, object := range objects {
+		if object.Type != "PipeWire:Interface:Node" || object.Info == nil {
+			continue
+		}
+		props := object.Info.Props
+		properties := make(map[string]string, len(props))
+		for key := range props {
+			properties[key] = property(props, key)
+		}
+		node := pipeWireNode{server: s, id: object.ID, suspended: object.Info.State == "suspended",
+			idle: object.Info.State == "idle" || object.Info.State == "suspended"}
+		for _, params := range object.Info.Params.Props {
+			if len(params.ChannelVolumes) > 0 {
+				// Channel volumes are linear, pulsekontrol's like PulseAudio's
+				// cubic. The loudest channel is the volume whatever the balance.
+				node.volume = float32(math.Round(math.Cbrt(slices.Max(params.ChannelVolumes))*100) / 100)
+				node.muted = params.Mute
+				node.channels, node.positions = params.ChannelVolumes, params.ChannelMap
+				break
+			}
+		}
+
+		switch properties["media.class"] {
+		case pipeWireSink:
+			groups.outputs = append(groups.outputs, pipeWireDevice(properties, node))
+		case pipeWireSource, pipeWireVirtualSource:
+			groups.inputs = append(groups.inputs, pipeWireDevice(properties, node))
+		case pipeWirePlayback:
+			groups.playbackStreams = append(groups.playbackStreams, pipeWireStream(properties, node))
+		case pipeWireRecord:
+			groups.recordStreams = append(groups.recordStreams, pipeWireStream(properties, node))
+		}
+	}
+	return groups, nil
+}
+
+// pipeWireDevice returns the stream of a sink or source node, named like
+// PulseAudio names them
+func pipeWireDevice(properties map[string]string, node pipeWireNode) Stream {
+	name := properties["node.description"]
+	if name == "" {
+		name = properties["node.name"]
+	}
+	return Stream{
+		Name:       name,
+		FullName:   properties["node.name"],
+		paStream:   &node,
+		properties: properties,
+	}
+}
+
+// pipeWireStream returns the stream of a playback or record stream node
+func pipeWireStream(properties map[string]string, node pipeWireNode) Stream {
+	name := properties["application.name"]
+	if name == "" {
+		name = properties["media.name"]
+	}
+	return Stream{
+		Name:       name,
+		FullName:   fmt.Sprintf("%s:%d", properties["node.name"], node.id),
+		BinaryName: properties["application.process.binary"],
+		MediaName:  properties["media.name"],
+		ProcessID:  parseProcessID(properties["application.process.id"]),
+		paStream:   &node,
+		properties: properties,
+	}
+}
+
+func (s *pipeWireServer) Defaults() (defaultDevices, error) {
+	var defaults defaultDevices
+	objects, err := s.dump()
+	if err != nil {
+		return defaults, err
+	}
+	for _, object := range objects {
+		if object.Type != "PipeWire:Interface:Metadata" || property(object.Props, "metadata.name") != "default" {
+			continue
+		}
+		for _, entry := range object.Metadata {
+			var value struct {
+				Name string `json:"name"`
+			}
+			if json.Unmarshal(entry.Value, &value) != nil {
+				continue
+			}
+			switch entry.Key {
+			case "default.audio.sink":
+				defaults.sink = value.Name
+			case "default.audio.source":
+				defaults.source = value.Name
+			}
+		}
+	}
+	return defaults, nil
+}
+
+func (s *pipeWireServer) SetDefaultSink(name string) error {
+	return s.setDefault(name)
+}
+
+func (s *pipeWireServer) SetDefaultSource(name string) error {
+	return s.setDefault(name)
+}
+
+// setDefault makes the node with a name the default of its kind
+func (s *pipeWireServer) setDefault(name string) error {
+	s.mu.Lock()
+	id, ok := s.ids[name]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no PipeWire node %s", name)
+	}
+	return s.wpctl("set-default", strconv.Itoa(id))
+}
+
+// MoveStream links a stream to another sink through the metadata WirePlumber
+// routes streams by
+func (s *pipeWireServer) MoveStream(stream Stream, sink string) error {
+	node, ok := stream.paStream.(*pipeWireNode)
+	if !ok {
+		return fmt.Errorf("%s is not a PipeWire node", stream.Name)
+	}
+	_, err := runTool(s.timeout(), "pw-metadata", strconv.Itoa(node.id), "target.object", sink)
+	return err
+}
+
+// monitored returns the objects the monitor reported, if it runs and its
+// first dump came
+func (s *pipeWireServer) monitored() ([]pipeWireObject, bool, error) {
+	s.mu.Lock()
+	if s.objects == nil || s.monitor == nil || s.lost.Load() {
+		s.mu.Unlock()
+		return nil, false, nil
+	}
+	ids := make([]int, 0, len(s.objects))
+	for id := range s.objects {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	raw := make([]map[string]interface{}, 0, len(ids))
+	for _, id := range ids {
+		raw = append(raw, s.objects[id])
+	}
+	// Marshalled while locked, the monitor merges into the same maps
+	data, err := json.Marshal(raw)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, false, err
+	}
+
+	var objects []pipeWireObject
+	if err := json.Unmarshal(data, &objects); err != nil {
+		return nil, false, fmt.Errorf("failed to parse pw-dump: %w", err)
+	}
+	return objects, true, nil
+}
+
+// applyChanges merges the objects of a dump of the monitor into the ones
+// known. pw-dump reports an object again when it changed and with only its
+// id and a null info when it is gone.
+func applyChanges(objects map[int]map[string]interface{}, changes []map[string]interface{}) {
+	for _, change := range changes {
+		id, ok := change["id"].(float64)
+		if !ok {
+			continue
+		}
+		if info, ok := change["info"]; ok && info == nil {
+			delete(objects, int(id))
+			continue
+		}
+		if object, ok := objects[int(id)]; ok {
+			mergeObject(object, change)
+		} else {
+			objects[int(id)] = change
+		}
+	}
+}
+
+// mergeObject sets the fields of change in object, merging nested objects so
+// that a change reporting only some of their fields keeps the others
+func mergeObject(object map[string]interface{}, change map[string]interface{}) {
+	for key, value := range change {
+		nested, isObject := value.(map[string]interface{})
+		previous, wasObject := object[key].(map[string]interface{})
+		if isObject && wasObject {
+			mergeObject(previous, nested)
+			continue
+		}
+		object[key] = value
+	}
+}
+
+// Updates follows the changes pw-dump reports and keeps the objects for
+// dump. The channel holds one value for all changes that came since it was
+// read.
+func (s *pipeWireServer) Updates() (<-chan struct{}, error) {
+	monitor := exec.Command("pw-dump", "--monitor", "--no-colors")
+	output, err := monitor.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := monitor.Start(); err != nil {
+		return nil, fmt.Errorf("pw-dump --monitor failed: %w", err)
+	}
+	s.mu.Lock()
+	previous := s.monitor
+	s.monitor = monitor
+	s.objects = nil
+	s.mu.Unlock()
+	if previous != nil {
+		previous.Process.Kill()
+	}
+
+	updates := make(chan struct{}, 1)
+	go func() {
+		defer close(updates)
+		// The first dump holds all objects, each later one those that changed
+		decoder := json.NewDecoder(bufio.NewReader(output))
+		for {
+			var changes []map[string]interface{}
+			if err := decoder.Decode(&changes); err != nil {
+				break
+			}
+			s.mu.Lock()
+			if s.monitor == monitor {
+				if s.objects == nil {
+					s.objects = make(map[int]map[string]interface{})
+				}
+				applyChanges(s.objects, changes)
+				s.connected.Store(true)
+			}
+			s.mu.Unlock()
+			select {
+			case updates <- struct{}{}:
+			default:
+			}
+		}
+		monitor.Process.Kill()
+		monitor.Wait()
+		s.mu.Lock()
+		if s.monitor == monitor {
+			// Neither replaced nor closed
+			s.lost.Store(true)
+			s.objects = nil
+		}
+		s.mu.Unlock()
+	}()
+	return updates, nil
+}
+
+// Close stops following the changes
+func (s *pipeWireServer) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.monitor != nil {
+		s.monitor.Process.Kill()
+		s.monitor = nil
+	}
+	s.objects = nil
+}
+
+// pipeWireNode is changed like the streams of PulseAudio
+var _ pulseaudio.Device = (*pipeWireNode)(nil)
+
+func (node *pipeWireNode) SetVolume(volume float32) error {
+	return node.server.wpctl("set-volume", strconv.Itoa(node.id), strconv.FormatFloat(float64(volume), 'f', 4, 32))
+}
+
+func (node *pipeWireNode) SetMute(muted bool) error {
+	mute := "0"
+	if muted {
+		mute = "1"
+	}
+	return node.server.wpctl("set-mute", strconv.Itoa(node.id), mute)
+}
+
+func (node *pipeWireNode) ToggleMute() error {
+	return node.server.wpctl("set-mute", strconv.Itoa(node.id), "toggle")
+}
+
+func (node *pipeWireNode) IsMute() bool {
+	return node.muted
+}
+
+func (node *pipeWireNode) GetVolume() float32 {
+	return node.volume
+}
+
+// pulseModules returns the modules of pipewire-pulse, listed with pactl. The
+// features PipeWire has no tool for are modules of pipewire-pulse. Without
+// pactl none can have been loaded.
+func (s *pipeWireServer) pulseModules() ([]pulseaudio.Module, error) {
+	if _, err := exec.LookPath("pactl"); err != nil {
+		return nil, nil
+	}
+	output, err := runTool(s.timeout(), "pactl", "list", "short", "modules")
+	if err != nil {
+		return nil, pulseToolError(err)
+	}
+	return parseShortModules(string(output)), nil
+}
+
+// parseShortModules parses the module list of pactl list short modules, a
+// line of index, name and argument separated by tabs for each module
+func parseShortModules(output string) []pulseaudio.Module {
+	var modules []pulseaudio.Module
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) < 2 {
+			continue
+		}
+		index, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			continue
+		}
+		module := pulseaudio.Module{Index: uint32(index), Name: fields[1]}
+		if len(fields) == 3 {
+			module.Argument = fields[2]
+		}
+		modules = append(modules, module)
+	}
+	return modules
+}
+
+// loadPulseModule loads a module of pipewire-pulse with pactl
+func (s *pipeWireServer) loadPulseModule(name string, argument string) error {
+	_, err := runTool(s.timeout(), "pactl", "load-module", name, argument)
+	return pulseToolError(err)
+}
+
+// unloadPulseModule unloads a module of pipewire-pulse with pactl
+func (s *pipeWireServer) unloadPulseModule(index int) error {
+	_, err := runTool(s.timeout(), "pactl", "unload-module", strconv.Itoa(index))
+	return pulseToolError(err)
+}
+
+// pulseToolError adds what the pipewire backend needs for the features it
+// leaves to pipewire-pulse
+func pulseToolError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%w (with the pipewire backend this needs pactl and pipewire-pulse)", err)
+}
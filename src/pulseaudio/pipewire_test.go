@@ -0,0 +1,122 @@
+package pulseaudio
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/the-jonsey/pulseaudio"
+)
+
+// fakeTool puts a shell script named like a tool first in PATH
+func fakeTool(t *testing.T, name string, script string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestParseShortModules(t *testing.T) {
+	output := "7\tmodule-null-sink\tsink_name=mix sink_properties=device.description=\"Mix\"\n" +
+		"12\tmodule-echo-cancel\t\n" +
+		"not a module\n"
+	modules := parseShortModules(output)
+	want := []pulseaudio.Module{
+		{Index: 7, Name: "module-null-sink", Argument: `sink_name=mix sink_properties=device.description="Mix"`},
+		{Index: 12, Name: "module-echo-cancel"},
+	}
+	if len(modules) != len(want) {
+		t.Fatalf("got %+v, want %+v", modules, want)
+	}
+	for i := range want {
+		if modules[i].Index != want[i].Index || modules[i].Name != want[i].Name || modules[i].Argument != want[i].Argument {
+			t.Errorf("module %d is %+v, want %+v", i, modules[i], want[i])
+		}
+	}
+}
+
+func TestApplyChanges(t *testing.T) {
+	objects := make(map[int]map[string]interface{})
+	applyChanges(objects, []map[string]interface{}{
+		{"id": 40.0, "type": "PipeWire:Interface:Node", "info": map[string]interface{}{
+			"state": "idle",
+			"props": map[string]interface{}{"node.name": "speakers"},
+		}},
+		{"id": 41.0, "type": "PipeWire:Interface:Node", "info": map[string]interface{}{
+			"props": map[string]interface{}{"node.name": "firefox"},
+		}},
+	})
+	// Changes report only what changed, and null info for removed objects
+	applyChanges(objects, []map[string]interface{}{
+		{"id": 40.0, "info": map[string]interface{}{"state": "running"}},
+		{"id": 41.0, "info": nil},
+	})
+
+	if _, ok := objects[41]; ok {
+		t.Error("removed object is kept")
+	}
+	info := objects[40]["info"].(map[string]interface{})
+	if info["state"] != "running" {
+		t.Errorf("state is %v, want running", info["state"])
+	}
+	if name := info["props"].(map[string]interface{})["node.name"]; name != "speakers" {
+		t.Errorf("node.name is %v after a partial change, want speakers", name)
+	}
+	if objects[40]["type"] != "PipeWire:Interface:Node" {
+		t.Errorf("type is %v after a partial change", objects[40]["type"])
+	}
+}
+
+func TestDumpUsesMonitor(t *testing.T) {
+	// The monitor reports the node, then its removal. Running pw-dump on its
+	// own would report no objects.
+	fakeTool(t, "pw-dump", `
+if [ "$1" != "--monitor" ]; then echo '[]'; exit 0; fi
+echo '[{"id": 40, "type": "PipeWire:Interface:Node", "info": {"props": {"node.name": "speakers", "media.class": "Audio/Sink"}}}]'
+while [ ! -e "$PW_DUMP_GO_ON" ]; do sleep 0.01; done
+echo '[{"id": 40, "info": null}]'
+sleep 60
+`)
+	goOn := filepath.Join(t.TempDir(), "go-on")
+	t.Setenv("PW_DUMP_GO_ON", goOn)
+	s := &pipeWireServer{timeout: func() time.Duration { return 5 * time.Second }, ids: make(map[string]int)}
+	updates, err := s.Updates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+
+	select {
+	case <-updates:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no update for the first dump")
+	}
+	groups, err := s.Streams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups.outputs) != 1 {
+		t.Fatalf("got outputs %+v from the monitor, want speakers", groups.outputs)
+	}
+
+	if err := os.WriteFile(goOn, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		groups, err := s.Streams()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(groups.outputs) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("removed node still reported")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
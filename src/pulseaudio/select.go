@@ -0,0 +1,190 @@
+package pulseaudio
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/the-jonsey/pulseaudio"
+)
+
+// MatchMode is how the name of a Selector is compared with stream and device names
+type MatchMode string
+
+const (
+	MatchExact MatchMode = "exact" // Like configured sources, see matchTargetStreams
+	MatchGlob  MatchMode = "glob"  // Shell pattern, like Fire*
+	MatchRegex MatchMode = "regex" // Regular expression, matching anywhere in the name
+)
+
+// Selector picks streams or devices by name, for commands given by hand
+type Selector struct {
+	Type       configuration.PulseAudioTargetType
+	Name       string
+	BinaryName string // Empty matches any binary
+	Mode       MatchMode
+}
+
+// SelectTargets returns the typed targets a selector stands for. An exact
+// selector is a target itself, patterns are resolved to the names of the
+// current streams or devices, each with its binary name.
+func (client *PAClient) SelectTargets(selector Selector) ([]configuration.TypedTarget, error) {
+	if selector.Mode == "" || selector.Mode == MatchExact {
+		return []configuration.TypedTarget{{Type: selector.Type, Name: selector.Name, BinaryName: selector.BinaryName}}, nil
+	}
+
+	var match func(string) bool
+	switch selector.Mode {
+	case MatchGlob:
+		if _, err := filepath.Match(selector.Name, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", selector.Name, err)
+		}
+		match = func(name string) bool {
+			matched, _ := filepath.Match(selector.Name, name)
+			return matched
+		}
+	case MatchRegex:
+		re, err := regexp.Compile(selector.Name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %q: %w", selector.Name, err)
+		}
+		match = re.MatchString
+	default:
+		return nil, fmt.Errorf("unknown match mode %q", selector.Mode)
+	}
+
+	if err := client.refreshStreams(); err != nil {
+		return nil, err
+	}
+	var targets []configuration.TypedTarget
+	for _, stream := range client.cache().streamsOfType(selector.Type) {
+		if !match(stream.Name) || (selector.BinaryName != "" && stream.BinaryName != selector.BinaryName) {
+			continue
+		}
+		target := configuration.TypedTarget{Type: selector.Type, Name: stream.Name, BinaryName: stream.BinaryName}
+		if !slices.Contains(targets, target) {
+			targets = append(targets, target)
+		}
+	}
+	return targets, nil
+}
+
+// MatchTarget returns the current streams or devices matching a typed target
+func (client *PAClient) MatchTarget(target *configuration.TypedTarget) []Stream {
+	client.refreshStreams()
+	return client.matchTargetStreams(target)
+}
+
+// Muted returns whether a stream or device is muted
+func (stream Stream) Muted() bool {
+	device, ok := stream.paStream.(pulseaudio.Device)
+	return ok && device.IsMute()
+}
+
+// Corked returns whether the application of a playback or record stream
+// paused it, like a player on pause. Devices are never corked.
+func (stream Stream) Corked() bool {
+	switch device := stream.paStream.(type) {
+	case pulseaudio.SinkInput:
+		return device.Corked
+	case pulseaudio.SourceOutput:
+		return device.Corked
+	case *pipeWireNode:
+		// PipeWire doesn't run the nodes of paused streams
+		class := stream.properties["media.class"]
+		return device.idle && (class == pipeWirePlayback || class == pipeWireRecord)
+	}
+	return false
+}
+
+// Volume returns the volume (0.0-1.0) of the stream as last seen, of its
+// loudest channel so that the balance doesn't change it
+func (stream Stream) Volume() float32 {
+	switch device := stream.paStream.(type) {
+	case pulseaudio.Sink:
+		return loudestChannel(device.Cvolume)
+	case pulseaudio.Source:
+		return loudestChannel(device.Cvolume)
+	case pulseaudio.SinkInput:
+		return loudestChannel(device.Cvolume)
+	case pulseaudio.SourceOutput:
+		return loudestChannel(device.Cvolume)
+	}
+	if device, ok := stream.paStream.(pulseaudio.Device); ok {
+		return device.GetVolume()
+	}
+	return 0
+}
+
+// SetDefaultInput makes the input device named by the target the default
+func (client *PAClient) SetDefaultInput(action configuration.Action) error {
+	if err := client.refreshStreams(); err != nil {
+		return err
+	}
+	cache := client.cache()
+	target, ok := action.Target.(*configuration.Target)
+	if !ok || target.Name == "" {
+		return nil
+	}
+	for _, stream := range cache.inputs {
+		if stream.Name == target.Name {
+			if client.simulated("SetDefaultInput", target.Name, []Stream{stream}, nil) {
+				return nil
+			}
+			client.log.Debug().Msgf("Setting %s as default input", stream.Name)
+			return client.callErr("SetDefaultSource", stream.Name, func() error { return client.pa().SetDefaultSource(stream.FullName) })
+		}
+	}
+	return nil
+}
+
+// CachedStream is a stream or device as PulseAudio last reported it
+type CachedStream struct {
+	Type       configuration.PulseAudioTargetType
+	ID         string
+	Name       string
+	BinaryName string
+	Volume     float32
+	Muted      bool
+	Suspended  bool // Whether the output device is suspended, see PAClient.SetOutputSuspended
+	Corked     bool // Whether the application paused the stream, see Stream.Corked
+	Default    bool // Whether the device is the default output or input
+}
+
+// InputMuted returns whether the input device with a name, or the default
+// input device for an empty name, is muted among cached streams, and whether
+// it is there at all
+func InputMuted(streams []CachedStream, name string) (bool, bool) {
+	for _, stream := range streams {
+		if stream.Type == configuration.InputDevice && (stream.Name == name || name == "" && stream.Default) {
+			return stream.Muted, true
+		}
+	}
+	return false, false
+}
+
+// CachedStreams returns the streams and devices of the last update without
+// asking PulseAudio, for diagnostics that must not add requests
+func (client *PAClient) CachedStreams() []CachedStream {
+	cache := client.cache()
+	var streams []CachedStream
+	for _, group := range []struct {
+		streamType configuration.PulseAudioTargetType
+		streams    []Stream
+		defaultID  string
+	}{
+		{configuration.OutputDevice, cache.outputs, cache.defaultSink},
+		{configuration.InputDevice, cache.inputs, cache.defaultSource},
+		{configuration.PlaybackStream, cache.playbackStreams, ""},
+		{configuration.RecordStream, cache.recordStreams, ""},
+	} {
+		for _, stream := range group.streams {
+			cached := CachedStream{Type: group.streamType, ID: stream.FullName, Name: stream.Name, BinaryName: stream.BinaryName, Volume: stream.Volume(), Muted: stream.Muted(), Suspended: stream.Suspended(), Corked: stream.Corked()}
+			cached.Default = group.defaultID != "" && stream.FullName == group.defaultID
+			streams = append(streams, cached)
+		}
+	}
+	return streams
+}
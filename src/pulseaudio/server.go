@@ -0,0 +1,245 @@
+package pulseaudio
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/the-jonsey/pulseaudio"
+)
+
+// server is the sound server a PAClient controls: PulseAudio over its native
+// protocol, which pipewire-pulse speaks as well, or PipeWire through its own
+// tools. The streams it returns are changed through their pulseaudio.Device.
+type server interface {
+	// Connected returns whether the server can still be reached
+	Connected() bool
+	// Streams returns the devices and streams, see streamGroups
+	Streams() (streamGroups, error)
+	// Defaults returns the full names of the default output and input
+	Defaults() (defaultDevices, error)
+	SetDefaultSink(name string) error
+	SetDefaultSource(name string) error
+	// MoveStream moves a playback stream to the output device with a full name
+	MoveStream(stream Stream, sink string) error
+	// SetBalance sets the balance of a stream or device, see SetTargetBalance
+	SetBalance(stream Stream, balance float64) error
+	// Cards returns the sound cards with their profiles
+	Cards() ([]Card, error)
+	SetCardProfile(card Card, profile CardProfile) error
+	// Loopbacks returns the loopbacks pulsekontrol loaded, see Loopback
+	Loopbacks() ([]Loopback, error)
+	// LoadLoopback plays the input device with a full name on the output
+	// device with a full name
+	LoadLoopback(source string, sink string, latency time.Duration) error
+	UnloadLoopback(loopback Loopback) error
+	// SuspendSink suspends an output device or resumes it
+	SuspendSink(stream Stream, suspended bool) error
+	// CombinedSinks returns the combined output devices pulsekontrol loaded
+	CombinedSinks() ([]combinedSink, error)
+	// LoadCombinedSink creates an output device with a sink name and a
+	// description that plays on the output devices with full names
+	LoadCombinedSink(name string, description string, outputs []string) error
+	UnloadCombinedSink(sink combinedSink) error
+	// EchoCancels returns the echo cancellers pulsekontrol loaded
+	EchoCancels() ([]echoCancel, error)
+	// LoadEchoCancel creates an input device with a name recording an
+	// input device without the echo of an output device
+	LoadEchoCancel(name string, input Stream, output Stream) error
+	UnloadEchoCancel(canceller echoCancel) error
+	// VirtualSinks returns the virtual output devices pulsekontrol loaded
+	VirtualSinks() ([]virtualSink, error)
+	// LoadVirtualSink creates an output device with a sink name and a
+	// description that plays on the default output device
+	LoadVirtualSink(name string, description string) error
+	UnloadVirtualSink(sink virtualSink) error
+	// MeterCommand returns the command recording a stream or device for its
+	// levels, as mono signed 16-bit samples at meterRate on standard output
+	MeterCommand(stream Stream, interval time.Duration) ([]string, error)
+	// Updates returns a channel receiving a value when streams, devices or
+	// the default devices changed, closed when the connection is lost
+	Updates() (<-chan struct{}, error)
+	// Close lets go of the connection after it was replaced and the
+	// operations on it are done. Operations still waiting on it fail.
+	Close()
+}
+
+type streamGroups struct {
+	outputs         []Stream
+	inputs          []Stream
+	playbackStreams []Stream
+	recordStreams   []Stream
+}
+
+type defaultDevices struct {
+	sink   string
+	source string
+}
+
+// connectServer connects to the sound server of a backend. Tools the server
+// is driven with may run for at most the timeout it returns.
+func connectServer(backend configuration.AudioBackend, timeout func() time.Duration) (server, error) {
+	switch backend {
+	case configuration.PipeWireBackend:
+		server, err := connectPipeWire(timeout)
+		if err != nil {
+			return nil, err
+		}
+		return server, nil
+	case configuration.PulseAudioBackend, "":
+		context, err := pulseaudio.NewClient()
+		if err != nil {
+			return nil, err
+		}
+		return &pulseServer{client: context, timeout: timeout}, nil
+	}
+	return nil, fmt.Errorf("unknown audio backend %q", backend)
+}
+
+// backendName returns the name of the sound server of a backend for messages
+func backendName(backend configuration.AudioBackend) string {
+	if backend == configuration.PipeWireBackend {
+		return "PipeWire"
+	}
+	return "PulseAudio"
+}
+
+// runTool runs a command line tool of the sound server and kills it after the
+// timeout
+func runTool(timeout time.Duration, name string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, name, args...).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("%s %s failed: %w: %s", name, args[0], err, exitErr.Stderr)
+		}
+		return nil, fmt.Errorf("%s %s failed: %w", name, args[0], err)
+	}
+	return output, nil
+}
+
+// pulseServer talks to PulseAudio over its native protocol
+type pulseServer struct {
+	client    *pulseaudio.Client
+	timeout   func() time.Duration
+	closeOnce sync.Once // The client panics when closed twice
+}
+
+func (s *pulseServer) Connected() bool {
+	return s.client.Connected()
+}
+
+func (s *pulseServer) Streams() (streamGroups, error) {
+	var groups streamGroups
+	sinks, err := s.client.Sinks()
+	if err != nil {
+		return groups, err
+	}
+	for _, sink := range sinks {
+		groups.outputs = append(groups.outputs, Stream{
+			Name:       sink.Description,
+			FullName:   sink.Name,
+			paStream:   sink,
+			properties: sink.PropList,
+		})
+	}
+	sources, err := s.client.Sources()
+	if err != nil {
+		return groups, err
+	}
+	for _, source := range sources {
+		groups.inputs = append(groups.inputs, Stream{
+			Name:       source.Description,
+			FullName:   source.Name,
+			paStream:   source,
+			properties: source.PropList,
+		})
+	}
+	sinkInputs, err := s.client.SinkInputs()
+	if err != nil {
+		return groups, err
+	}
+	for _, sinkInput := range sinkInputs {
+		groups.playbackStreams = append(groups.playbackStreams, pulseStream(sinkInput.PropList, sinkInput))
+	}
+	sourceOutputs, err := s.client.SourceOutputs()
+	if err != nil {
+		return groups, err
+	}
+	for _, sourceOutput := range sourceOutputs {
+		groups.recordStreams = append(groups.recordStreams, pulseStream(sourceOutput.PropList, sourceOutput))
+	}
+	return groups, nil
+}
+
+// pulseStream returns the stream of a sink input or source output
+func pulseStream(properties map[string]string, device pulseaudio.Device) Stream {
+	name := properties["application.name"]
+	if len(name) < 1 {
+		name = properties["media.name"]
+	}
+
+	// Create unique ID by combining stream restore ID with object ID
+	uniqueId := properties["module-stream-restore.id"]
+	if objectId := properties["object.id"]; objectId != "" {
+		uniqueId = uniqueId + ":" + objectId
+	}
+
+	return Stream{
+		Name:       name,
+		FullName:   uniqueId,
+		BinaryName: properties["application.process.binary"],
+		MediaName:  properties["media.name"],
+		ProcessID:  parseProcessID(properties["application.process.id"]),
+		paStream:   device,
+		properties: properties,
+	}
+}
+
+func (s *pulseServer) Defaults() (defaultDevices, error) {
+	info, err := s.client.ServerInfo()
+	if err != nil {
+		return defaultDevices{}, err
+	}
+	return defaultDevices{sink: info.DefaultSink, source: info.DefaultSource}, nil
+}
+
+func (s *pulseServer) SetDefaultSink(name string) error {
+	// The pulseaudio library expects a name string, not a Sink object
+	return s.client.SetDefaultSink(name)
+}
+
+func (s *pulseServer) SetDefaultSource(name string) error {
+	// The pulseaudio library has no request for the default source
+	_, err := runTool(s.timeout(), "pactl", "set-default-source", name)
+	return err
+}
+
+func (s *pulseServer) MoveStream(stream Stream, sink string) error {
+	sinkInput, ok := stream.paStream.(pulseaudio.SinkInput)
+	if !ok {
+		return fmt.Errorf("%s is not a playback stream", stream.Name)
+	}
+	// The pulseaudio library has no request for moving streams either
+	_, err := runTool(s.timeout(), "pactl", "move-sink-input", strconv.FormatUint(uint64(sinkInput.Index), 10), sink)
+	return err
+}
+
+func (s *pulseServer) Updates() (<-chan struct{}, error) {
+	// Stream and device events (new streams, volume and mute changes) and
+	// server events (default devices)
+	subscriptionMask := pulseaudio.SUBSCRIPTION_MASK_SINK_INPUT | pulseaudio.SUBSCRIPTION_MASK_SOURCE_OUTPUT |
+		pulseaudio.SUBSCRIPTION_MASK_SINK | pulseaudio.SUBSCRIPTION_MASK_SOURCE | pulseaudio.SUBSCRIPTION_MASK_SERVER
+	return s.client.UpdatesByType(pulseaudio.DevType(subscriptionMask))
+}
+
+// Close closes the connection. Requests still waiting on it fail with an
+// error, those made afterwards fail to be sent.
+func (s *pulseServer) Close() {
+	s.closeOnce.Do(s.client.Close)
+}
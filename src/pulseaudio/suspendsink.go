@@ -0,0 +1,70 @@
+package pulseaudio
+
+import (
+	"fmt"
+
+	"github.com/samber/lo"
+	"github.com/the-jonsey/pulseaudio"
+)
+
+// sinkSuspended is the state of a suspended sink in PulseAudio's sink info
+const sinkSuspended = 2
+
+// Suspended returns whether an output device is suspended, closed so a USB
+// DAC or amplifier can power down. Other streams are never suspended.
+func (stream Stream) Suspended() bool {
+	switch device := stream.paStream.(type) {
+	case pulseaudio.Sink:
+		return device.SinkState == sinkSuspended
+	case *pipeWireNode:
+		return device.suspended && stream.properties["media.class"] == pipeWireSink
+	}
+	return false
+}
+
+// SetOutputSuspended suspends the output device with a name, or resumes it.
+// A suspended output device stays closed until resumed, even for streams
+// playing on it.
+func (client *PAClient) SetOutputSuspended(name string, suspended bool) error {
+	if err := client.refreshStreams(); err != nil {
+		return err
+	}
+	cache := client.cache()
+	output, ok := lo.Find(cache.outputs, func(stream Stream) bool {
+		return stream.Name == name
+	})
+	if !ok {
+		return fmt.Errorf("no output device %s", name)
+	}
+	if client.simulated("SetOutputSuspended", name, []Stream{output}, suspended) {
+		return nil
+	}
+	if err := client.callErr("SuspendSink", name, func() error { return client.pa().SuspendSink(output, suspended) }); err != nil {
+		return fmt.Errorf("failed to suspend or resume %s: %w", name, err)
+	}
+	client.log.Debug().Bool("suspended", suspended).Msgf("Changed suspension of %s", name)
+	return nil
+}
+
+func (s *pulseServer) SuspendSink(stream Stream, suspended bool) error {
+	// The pulseaudio library has no request for suspending
+	_, err := runTool(s.timeout(), "pactl", suspendSinkArgs(stream, suspended)...)
+	return err
+}
+
+// suspendSinkArgs returns the arguments of pactl suspending or resuming an
+// output device
+func suspendSinkArgs(stream Stream, suspended bool) []string {
+	value := "0"
+	if suspended {
+		value = "1"
+	}
+	return []string{"suspend-sink", stream.FullName, value}
+}
+
+// PipeWire suspends idle nodes on its own and wpctl can't ask for it, so
+// pipewire-pulse is asked with pactl
+func (s *pipeWireServer) SuspendSink(stream Stream, suspended bool) error {
+	_, err := runTool(s.timeout(), "pactl", suspendSinkArgs(stream, suspended)...)
+	return pulseToolError(err)
+}
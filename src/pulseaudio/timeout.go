@@ -0,0 +1,313 @@
+package pulseaudio
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Defaults of SetTimeout
+const (
+	DefaultTimeout        = 2 * time.Second
+	DefaultReconnectAfter = 3
+)
+
+// maxAbandoned bounds the operations that timed out and still wait for their
+// connection. Beyond it operations fail right away instead of piling up more
+// goroutines, until some return or the connection is replaced.
+const maxAbandoned = 16
+
+// ErrTimeout matches the errors of operations PulseAudio did not answer in time
+var ErrTimeout = errors.New("PulseAudio did not answer in time")
+
+// TimeoutError is the error of an operation PulseAudio did not answer in
+// time, like while pipewire-pulse restarts or a Bluetooth sink half works
+type TimeoutError struct {
+	Operation string
+	Target    string // Stream or device, empty for operations on the server
+	Timeout   time.Duration
+}
+
+func (err *TimeoutError) Error() string {
+	if err.Target == "" {
+		return fmt.Sprintf("PulseAudio did not answer %s within %s", err.Operation, err.Timeout)
+	}
+	return fmt.Sprintf("PulseAudio did not answer %s of %s within %s", err.Operation, err.Target, err.Timeout)
+}
+
+func (err *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+// SetTimeout sets how long an operation may take before it fails with a
+// TimeoutError, and after how many timeouts in a row the connection is
+// replaced. Zero values keep the defaults.
+func (client *PAClient) SetTimeout(timeout time.Duration, reconnectAfter int) {
+	client.watchdogMutex.Lock()
+	defer client.watchdogMutex.Unlock()
+	client.timeout, client.reconnectAfter = DefaultTimeout, DefaultReconnectAfter
+	if timeout > 0 {
+		client.timeout = timeout
+	}
+	if reconnectAfter > 0 {
+		client.reconnectAfter = reconnectAfter
+	}
+}
+
+// currentTimeout returns how long an operation may take
+func (client *PAClient) currentTimeout() time.Duration {
+	client.watchdogMutex.Lock()
+	defer client.watchdogMutex.Unlock()
+	return client.timeout
+}
+
+// pa returns the connection, which is replaced when reconnecting
+func (client *PAClient) pa() server {
+	client.contextMutex.RLock()
+	defer client.contextMutex.RUnlock()
+	return client.server
+}
+
+// call runs an operation of the connection and waits for it at most the
+// timeout. An operation that times out keeps waiting in the background until
+// its connection answers or is closed once replaced, the caller moves on.
+// While maxAbandoned operations wait on the connection, it fails right away.
+func call[T any](client *PAClient, operation string, target string, run func() (T, error)) (T, error) {
+	if err := client.checkAbandoned(operation, target); err != nil {
+		var zero T
+		return zero, err
+	}
+	return watch(client, operation, target, run)
+}
+
+// watch is call without the bound on abandoned operations
+func watch[T any](client *PAClient, operation string, target string, run func() (T, error)) (T, error) {
+	type result struct {
+		value T
+		err   error
+	}
+	done := make(chan result, 1)
+	server, release := client.hold()
+	state := &callState{server: server}
+	go func() {
+		defer release()
+		defer client.returned(state)
+		defer func() {
+			if value := recover(); value != nil {
+				done <- result{err: fmt.Errorf("%s failed: %v", operation, value)}
+			}
+		}()
+		value, err := run()
+		done <- result{value, err}
+	}()
+
+	timeout := client.currentTimeout()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case result := <-done:
+		client.answered()
+		return result.value, result.err
+	case <-timer.C:
+		client.abandon(state)
+		var zero T
+		return zero, client.timedOut(operation, target, timeout)
+	}
+}
+
+// callState tells whether an operation on a connection returned or was given
+// up on, guarded by watchdogMutex
+type callState struct {
+	server    server
+	returned  bool
+	abandoned bool
+}
+
+// abandon counts an operation that timed out unless it returned meanwhile
+func (client *PAClient) abandon(state *callState) {
+	client.watchdogMutex.Lock()
+	defer client.watchdogMutex.Unlock()
+	if !state.returned {
+		state.abandoned = true
+		client.abandoned[state.server]++
+	}
+}
+
+// returned marks an operation done, no longer counting it if it was abandoned
+func (client *PAClient) returned(state *callState) {
+	client.watchdogMutex.Lock()
+	defer client.watchdogMutex.Unlock()
+	state.returned = true
+	if state.abandoned {
+		client.abandoned[state.server]--
+		if client.abandoned[state.server] == 0 {
+			delete(client.abandoned, state.server)
+		}
+	}
+}
+
+// checkAbandoned fails an operation right away while maxAbandoned operations
+// still wait for the current connection. The connection is suspect then, so
+// it is replaced, and closing it ends them.
+func (client *PAClient) checkAbandoned(operation string, target string) error {
+	current := client.pa()
+	client.watchdogMutex.Lock()
+	abandoned := client.abandoned[current]
+	saturated := abandoned >= maxAbandoned
+	suspect := saturated && !client.suspect
+	if suspect {
+		client.suspect = true
+	}
+	client.watchdogMutex.Unlock()
+	if !saturated {
+		return nil
+	}
+
+	if suspect {
+		client.log.Error().Int("waiting", abandoned).Msg("Too many PulseAudio operations hung, reconnecting")
+		go client.Ping()
+	}
+	return fmt.Errorf("%s not attempted, %d operations still wait for an answer: %w", operation, abandoned, ErrTimeout)
+}
+
+// callErr is call for operations without a result
+func (client *PAClient) callErr(operation string, target string, run func() error) error {
+	_, err := call(client, operation, target, func() (struct{}, error) {
+		return struct{}{}, run()
+	})
+	return err
+}
+
+// answered resets the timeouts in a row after PulseAudio answered
+func (client *PAClient) answered() {
+	client.watchdogMutex.Lock()
+	defer client.watchdogMutex.Unlock()
+	client.timeoutsInRow = 0
+}
+
+// timedOut counts a timeout and reports it. After reconnectAfter in a row
+// the connection is suspect: Ping fails and replaces it.
+func (client *PAClient) timedOut(operation string, target string, timeout time.Duration) error {
+	client.watchdogMutex.Lock()
+	client.timeouts++
+	client.timeoutsInRow++
+	timeouts, inRow := client.timeouts, client.timeoutsInRow
+	suspect := !client.suspect && inRow >= client.reconnectAfter
+	if suspect {
+		client.suspect = true
+	}
+	client.watchdogMutex.Unlock()
+
+	client.log.Warn().Str("operation", operation).Str("target", target).Dur("timeout", timeout).Int("inRow", inRow).Msg("PulseAudio did not answer in time")
+	client.emit("pulseaudio.timeout", map[string]interface{}{
+		"operation": operation,
+		"target":    target,
+		"timeouts":  timeouts,
+	})
+	if suspect {
+		client.log.Error().Int("inRow", inRow).Msg("PulseAudio stopped answering, reconnecting")
+		go client.Ping()
+	}
+	return &TimeoutError{Operation: operation, Target: target, Timeout: timeout}
+}
+
+// isSuspect reports whether the connection stopped answering
+func (client *PAClient) isSuspect() bool {
+	client.watchdogMutex.Lock()
+	defer client.watchdogMutex.Unlock()
+	return client.suspect
+}
+
+// reconnect replaces the connection and subscribes to the events again if
+// the streams are monitored. It notifies pulseaudio.reconnected, after which
+// whatever the server forgot, like the volumes of a restarted one, is applied
+// again.
+func (client *PAClient) reconnect() error {
+	client.reconnectMutex.Lock()
+	defer client.reconnectMutex.Unlock()
+	if !client.isSuspect() && client.pa().Connected() {
+		// Reconnected while waiting for the lock
+		return nil
+	}
+
+	// Connecting doesn't wait on the old connection, however many operations hung on it
+	server, err := watch(client, "Connect", "", func() (server, error) {
+		return connectServer(client.backend, client.currentTimeout)
+	})
+	if err != nil {
+		return fmt.Errorf("cannot reconnect to %s: %w", backendName(client.backend), err)
+	}
+	client.contextMutex.Lock()
+	previous := client.server
+	client.server = server
+	client.contextMutex.Unlock()
+	client.retire(previous)
+	client.watchdogMutex.Lock()
+	client.suspect, client.timeoutsInRow = false, 0
+	client.watchdogMutex.Unlock()
+	client.log.Info().Msgf("Reconnected to %s", backendName(client.backend))
+
+	err = nil
+	client.monitoringMutex.Lock()
+	if client.monitoringWanted {
+		client.monitoringEnabled = false
+		client.endUpdates()
+		err = client.startMonitoring()
+	}
+	client.monitoringMutex.Unlock()
+	client.emit("pulseaudio.reconnected", map[string]interface{}{})
+	return err
+}
+
+// hold marks an operation in flight on the current connection and returns
+// it with the function marking the operation done, see retire
+func (client *PAClient) hold() (server, func()) {
+	// Counted before the connection can be replaced, so that retire sees it
+	client.contextMutex.RLock()
+	server := client.server
+	client.callsMutex.Lock()
+	client.calls[server]++
+	client.callsMutex.Unlock()
+	client.contextMutex.RUnlock()
+
+	return server, func() {
+		client.callsMutex.Lock()
+		client.calls[server]--
+		idle := client.calls[server] == 0
+		if idle {
+			delete(client.calls, server)
+		}
+		closing := idle && client.replaced[server]
+		if closing {
+			delete(client.replaced, server)
+		}
+		client.callsMutex.Unlock()
+		if closing {
+			server.Close()
+		}
+	}
+}
+
+// retire closes a replaced connection once the operations still in flight on
+// it are done. Those that never return were given up on already, so it is
+// closed anyway after the timeout, failing them.
+func (client *PAClient) retire(previous server) {
+	client.callsMutex.Lock()
+	if client.calls[previous] == 0 {
+		client.callsMutex.Unlock()
+		previous.Close()
+		return
+	}
+	client.replaced[previous] = true
+	client.callsMutex.Unlock()
+
+	time.AfterFunc(client.currentTimeout(), func() {
+		client.callsMutex.Lock()
+		closing := client.replaced[previous]
+		delete(client.replaced, previous)
+		client.callsMutex.Unlock()
+		if closing {
+			previous.Close()
+		}
+	})
+}
@@ -0,0 +1,356 @@
+package pulseaudio
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/configuration"
+)
+
+var errClosed = errors.New("connection closed")
+
+// fakeServer is a connected sound server without streams. Its Defaults wait
+// until released, like a server that stopped answering.
+type fakeServer struct {
+	offlineServer
+	release   chan struct{}
+	updates   chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newFakeServer() *fakeServer {
+	return &fakeServer{
+		release: make(chan struct{}),
+		updates: make(chan struct{}, 1),
+		closed:  make(chan struct{}),
+	}
+}
+
+// answering returns a fake server that answers right away
+func answering() *fakeServer {
+	s := newFakeServer()
+	close(s.release)
+	return s
+}
+
+func (s *fakeServer) Connected() bool {
+	return true
+}
+
+func (s *fakeServer) Streams() (streamGroups, error) {
+	return streamGroups{}, nil
+}
+
+func (s *fakeServer) Defaults() (defaultDevices, error) {
+	select {
+	case <-s.release:
+		return defaultDevices{}, nil
+	case <-s.closed:
+		return defaultDevices{}, errClosed
+	}
+}
+
+func (s *fakeServer) Updates() (<-chan struct{}, error) {
+	return s.updates, nil
+}
+
+func (s *fakeServer) Close() {
+	s.closeOnce.Do(func() { close(s.closed) })
+}
+
+func (s *fakeServer) isClosed() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// newFakeClient returns a client of a fake server with a short timeout.
+// Reconnecting fails, the backend is unknown.
+func newFakeClient(s server, timeout time.Duration) *PAClient {
+	client := newClient("fake")
+	client.server = s
+	client.SetTimeout(timeout, 100)
+	return client
+}
+
+// replace puts another connection in place like reconnect
+func (client *PAClient) replace(s server) {
+	client.contextMutex.Lock()
+	previous := client.server
+	client.server = s
+	client.contextMutex.Unlock()
+	client.retire(previous)
+}
+
+// waitFor polls until condition holds or fails the test after a second
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestReplacedConnectionIsClosed(t *testing.T) {
+	previous := answering()
+	client := newFakeClient(previous, time.Second)
+
+	client.replace(answering())
+	if !previous.isClosed() {
+		t.Error("idle connection not closed when replaced")
+	}
+}
+
+func TestReplacedConnectionWaitsForCalls(t *testing.T) {
+	previous := newFakeServer()
+	client := newFakeClient(previous, time.Second)
+
+	answered := make(chan error)
+	go func() {
+		_, err := call(client, "ServerInfo", "", client.pa().Defaults)
+		answered <- err
+	}()
+	waitFor(t, "the call to be in flight", func() bool {
+		client.callsMutex.Lock()
+		defer client.callsMutex.Unlock()
+		return client.calls[previous] == 1
+	})
+
+	client.replace(answering())
+	if previous.isClosed() {
+		t.Fatal("connection closed while a call was in flight")
+	}
+
+	close(previous.release)
+	if err := <-answered; err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	waitFor(t, "the connection to be closed", previous.isClosed)
+}
+
+func TestReplacedConnectionClosedAfterTimeout(t *testing.T) {
+	previous := newFakeServer()
+	client := newFakeClient(previous, 20*time.Millisecond)
+
+	// The call is given up on and never returns by itself
+	if _, err := call(client, "ServerInfo", "", client.pa().Defaults); err == nil {
+		t.Fatal("hung call did not time out")
+	}
+	client.replace(answering())
+	waitFor(t, "the hung connection to be closed", previous.isClosed)
+	waitFor(t, "the abandoned call to return", func() bool {
+		client.callsMutex.Lock()
+		defer client.callsMutex.Unlock()
+		return client.calls[previous] == 0
+	})
+}
+
+func TestMonitoringStateIsGuarded(t *testing.T) {
+	client := newFakeClient(answering(), time.Second)
+
+	// Meant for the race detector: monitoring starts, stops and is read from
+	// several goroutines, like reconnect and the updates goroutine do
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if err := client.StartStreamMonitoring(); err != nil {
+					t.Errorf("start failed: %v", err)
+					return
+				}
+				client.Monitoring()
+				client.Refresh()
+				client.StopStreamMonitoring()
+			}
+		}()
+	}
+	wg.Wait()
+	if client.Monitoring() {
+		t.Error("still monitoring after every start was stopped")
+	}
+}
+
+func TestCachedStreamsAreGuarded(t *testing.T) {
+	client := newFakeClient(answering(), time.Second)
+	target := &configuration.TypedTarget{Type: configuration.PlaybackStream, Name: "Firefox"}
+
+	// Meant for the race detector: the streams are refreshed and matched
+	// from several goroutines, like the workers of queued control values do
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				client.MatchTarget(target)
+				client.GetAudioSources()
+				client.CachedStreams()
+				client.MoveStreams(target, "Speakers")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// countingServer is a fake server playing Firefox that counts how often the
+// streams were loaded
+type countingServer struct {
+	*fakeServer
+	loads atomic.Int32
+}
+
+func (s *countingServer) Streams() (streamGroups, error) {
+	s.loads.Add(1)
+	return streamGroups{playbackStreams: []Stream{{Name: "Firefox", FullName: "1"}}}, nil
+}
+
+func TestVolumeUsesMonitoredStreams(t *testing.T) {
+	s := &countingServer{fakeServer: answering()}
+	client := newFakeClient(s, time.Second)
+	seen := make(chan configuration.TypedTarget, 1)
+	client.SetSourceSeenCallback(func(target configuration.TypedTarget) {
+		select {
+		case seen <- target:
+		default:
+		}
+	})
+	if err := client.StartStreamMonitoring(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.StopStreamMonitoring()
+
+	loads := s.loads.Load()
+	target := &configuration.TypedTarget{Type: configuration.PlaybackStream, Name: "Firefox"}
+	for volume := 0; volume < 10; volume++ {
+		action := configuration.Action{Type: configuration.SetVolume, Target: target}
+		if err := client.ProcessVolumeAction(action, float32(volume)/10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.loads.Load() != loads {
+		t.Errorf("streams loaded %d times while setting volumes, want none with monitoring", s.loads.Load()-loads)
+	}
+
+	// The source is reported as seen, apart from setting the volumes
+	select {
+	case got := <-seen:
+		if got != *target {
+			t.Errorf("got %+v seen, want %+v", got, *target)
+		}
+	case <-time.After(time.Second):
+		t.Error("Firefox not reported as seen")
+	}
+}
+
+func TestCallTimesOut(t *testing.T) {
+	hung := newFakeServer()
+	defer close(hung.release)
+	client := newFakeClient(hung, 20*time.Millisecond)
+
+	start := time.Now()
+	_, err := call(client, "ServerInfo", "", client.pa().Defaults)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("call returned after %v", elapsed)
+	}
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) || !errors.Is(err, ErrTimeout) {
+		t.Fatalf("got %v, want a TimeoutError", err)
+	}
+	if timeoutErr.Operation != "ServerInfo" || timeoutErr.Timeout != 20*time.Millisecond {
+		t.Errorf("got %+v", timeoutErr)
+	}
+}
+
+func TestTimeoutsInRowMakeConnectionSuspect(t *testing.T) {
+	hung := newFakeServer()
+	client := newFakeClient(hung, 5*time.Millisecond)
+	client.SetTimeout(5*time.Millisecond, 2)
+
+	call(client, "ServerInfo", "", client.pa().Defaults)
+	if client.isSuspect() {
+		t.Fatal("suspect after a single timeout")
+	}
+	call(client, "ServerInfo", "", client.pa().Defaults)
+	if !client.isSuspect() {
+		t.Fatal("not suspect after reconnectAfter timeouts in a row")
+	}
+
+	// Reconnecting fails here, the fake backend is unknown
+	if err := client.Ping(); err == nil {
+		t.Error("ping of a suspect connection did not reconnect")
+	}
+	close(hung.release)
+}
+
+func TestAbandonedCallsAreBounded(t *testing.T) {
+	hung := newFakeServer()
+	client := newFakeClient(hung, 5*time.Millisecond)
+
+	for i := 0; i < maxAbandoned; i++ {
+		if _, err := call(client, "ServerInfo", "", client.pa().Defaults); !errors.Is(err, ErrTimeout) {
+			t.Fatalf("call %d: got %v, want a timeout", i, err)
+		}
+	}
+
+	// Further calls fail right away instead of leaving more goroutines behind
+	for i := 0; i < 10; i++ {
+		_, err := call(client, "ServerInfo", "", client.pa().Defaults)
+		if !errors.Is(err, ErrTimeout) {
+			t.Fatalf("got %v, want a timeout", err)
+		}
+		var timeoutErr *TimeoutError
+		if errors.As(err, &timeoutErr) {
+			t.Fatalf("call waited for the connection: %v", err)
+		}
+	}
+	client.callsMutex.Lock()
+	inFlight := client.calls[hung]
+	client.callsMutex.Unlock()
+	if inFlight != maxAbandoned {
+		t.Errorf("got %d calls in flight, want %d", inFlight, maxAbandoned)
+	}
+	if !client.isSuspect() {
+		t.Error("connection with too many hung calls not suspect")
+	}
+
+	// Once the connection answers, the abandoned calls return and it is used again
+	close(hung.release)
+	waitFor(t, "the abandoned calls to return", func() bool {
+		client.watchdogMutex.Lock()
+		defer client.watchdogMutex.Unlock()
+		return client.abandoned[hung] == 0
+	})
+	if _, err := call(client, "ServerInfo", "", client.pa().Defaults); err != nil {
+		t.Errorf("call after recovery failed: %v", err)
+	}
+}
+
+func TestClosingEndsAbandonedCalls(t *testing.T) {
+	hung := newFakeServer()
+	client := newFakeClient(hung, 5*time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		call(client, "ServerInfo", "", client.pa().Defaults)
+	}
+	client.replace(answering())
+	waitFor(t, "the abandoned calls to end", func() bool {
+		client.watchdogMutex.Lock()
+		defer client.watchdogMutex.Unlock()
+		return client.abandoned[hung] == 0
+	})
+	if _, err := call(client, "ServerInfo", "", client.pa().Defaults); err != nil {
+		t.Errorf("call on the new connection failed: %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package pulseaudio
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/0h41/pulsekontrol/src/configuration"
+)
+
+// virtualSinkApplicationID marks the loopbacks of virtual sinks, which are
+// left out of the streams
+const virtualSinkApplicationID = "org.pulsekontrol.virtual"
+
+// virtualSink is an output device pulsekontrol loaded for a control, which
+// plays on the default output device
+type virtualSink struct {
+	name     string // Sink name, see configuration.VirtualSinkName
+	ids      []int  // Module indexes of the sink and its loopback with PulseAudio, process id with PipeWire
+	complete bool   // Whether both are there, a sink or loopback whose other half went away is removed
+}
+
+// SyncVirtualSinks creates the virtual output devices with names that are
+// missing and removes the ones pulsekontrol created that are no longer
+// wanted. It returns the names of the ones it created, which start at full
+// volume.
+func (client *PAClient) SyncVirtualSinks(names []string) ([]string, error) {
+	loaded, err := call(client, "VirtualSinks", "", client.pa().VirtualSinks)
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := make(map[string]string)
+	for _, name := range names {
+		wanted[configuration.VirtualSinkName(name)] = name
+	}
+
+	var errs []error
+	for _, sink := range loaded {
+		if _, ok := wanted[sink.name]; ok && sink.complete {
+			delete(wanted, sink.name)
+			continue
+		}
+		if client.simulated("UnloadVirtualSink", sink.name, nil, nil) {
+			continue
+		}
+		if err := client.callErr("UnloadVirtualSink", sink.name, func() error { return client.pa().UnloadVirtualSink(sink) }); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove %s: %w", sink.name, err))
+			continue
+		}
+		client.log.Debug().Msgf("Removed virtual sink %s", sink.name)
+	}
+
+	var created []string
+	for sinkName, name := range wanted {
+		if client.simulated("LoadVirtualSink", name, nil, nil) {
+			continue
+		}
+		if err := client.callErr("LoadVirtualSink", sinkName, func() error { return client.pa().LoadVirtualSink(sinkName, name) }); err != nil {
+			errs = append(errs, fmt.Errorf("failed to create %s: %w", name, err))
+			continue
+		}
+		client.log.Debug().Msgf("Created virtual sink %s", name)
+		created = append(created, name)
+	}
+	slices.Sort(created)
+	return created, errors.Join(errs...)
+}
+
+func (s *pulseServer) VirtualSinks() ([]virtualSink, error) {
+	modules, err := s.client.ModuleList()
+	if err != nil {
+		return nil, err
+	}
+	var sinks []virtualSink
+	for _, module := range modules {
+		arguments := moduleArguments(module.Argument)
+		if module.Name == "module-null-sink" && strings.HasPrefix(arguments["sink_name"], configuration.VirtualSinkName("")) {
+			sinks = append(sinks, virtualSink{name: arguments["sink_name"], ids: []int{int(module.Index)}})
+		}
+	}
+	// The loopbacks play the monitors of the sinks
+	for _, module := range modules {
+		if module.Name != "module-loopback" || !strings.Contains(module.Argument, virtualSinkApplicationID) {
+			continue
+		}
+		source := moduleArguments(module.Argument)["source"]
+		index := slices.IndexFunc(sinks, func(sink virtualSink) bool {
+			return sink.name+".monitor" == source
+		})
+		if index < 0 {
+			// Its sink went away, it is removed on its own
+			sinks = append(sinks, virtualSink{name: strings.TrimSuffix(source, ".monitor"), ids: []int{int(module.Index)}})
+			continue
+		}
+		sinks[index].ids = append(sinks[index].ids, int(module.Index))
+		sinks[index].complete = true
+	}
+	return sinks, nil
+}
+
+func (s *pulseServer) LoadVirtualSink(name string, description string) error {
+	if err := checkDeviceNames(name); err != nil {
+		return err
+	}
+	sink, err := s.client.LoadModule("module-null-sink", "sink_name="+name+" "+descriptionArgument("sink_properties", description))
+	if err != nil {
+		return err
+	}
+	// Without a sink, the loopback plays on the default output device and
+	// follows it
+	argument := fmt.Sprintf("source=%s.monitor source_dont_move=true sink_input_properties=application.id=%s source_output_properties=application.id=%s",
+		name, virtualSinkApplicationID, virtualSinkApplicationID)
+	if _, err := s.client.LoadModule("module-loopback", argument); err != nil {
+		s.client.UnloadModule(sink)
+		return err
+	}
+	return nil
+}
+
+func (s *pulseServer) UnloadVirtualSink(sink virtualSink) error {
+	var errs []error
+	// The loopback first, the sink is the first id
+	for i := len(sink.ids) - 1; i >= 0; i-- {
+		errs = append(errs, s.client.UnloadModule(uint32(sink.ids[i])))
+	}
+	return errors.Join(errs...)
+}
+
+// Virtual sinks of PipeWire are pw-loopback processes whose capture node is
+// an output device, which keep running when pulsekontrol stops like modules
+// of PulseAudio do
+func (s *pipeWireServer) VirtualSinks() ([]virtualSink, error) {
+	objects, err := s.dump()
+	if err != nil {
+		return nil, err
+	}
+	var sinks []virtualSink
+	for _, object := range objects {
+		if object.Type != "PipeWire:Interface:Node" || object.Info == nil ||
+			property(object.Info.Props, "application.id") != virtualSinkApplicationID ||
+			property(object.Info.Props, "media.class") != pipeWireSink {
+			continue
+		}
+		pid := parseProcessID(property(object.Info.Props, "application.process.id"))
+		if pid == 0 {
+			continue
+		}
+		sinks = append(sinks, virtualSink{name: property(object.Info.Props, "node.name"), ids: []int{pid}, complete: true})
+	}
+	return sinks, nil
+}
+
+func (s *pipeWireServer) LoadVirtualSink(name string, description string) error {
+	// Without a target, the playback node plays on the default output device
+	loopback := exec.Command("pw-loopback",
+		fmt.Sprintf("--capture-props={ media.class = %q node.name = %q node.description = %q application.id = %q }", pipeWireSink, name, description, virtualSinkApplicationID),
+		fmt.Sprintf("--playback-props={ node.name = %q application.id = %q }", name+".loopback", virtualSinkApplicationID),
+	)
+	// Its own session, so it outlives pulsekontrol
+	loopback.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := loopback.Start(); err != nil {
+		return fmt.Errorf("pw-loopback failed: %w", err)
+	}
+	go loopback.Wait()
+	return nil
+}
+
+func (s *pipeWireServer) UnloadVirtualSink(sink virtualSink) error {
+	return syscall.Kill(sink.ids[0], syscall.SIGTERM)
+}
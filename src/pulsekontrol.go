@@ -1,18 +1,35 @@
 package pulsekontrol
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
+	"github.com/0h41/pulsekontrol/src/actions"
+	"github.com/0h41/pulsekontrol/src/activity"
+	"github.com/0h41/pulsekontrol/src/clock"
 	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/0h41/pulsekontrol/src/control"
+	"github.com/0h41/pulsekontrol/src/instance"
+	"github.com/0h41/pulsekontrol/src/logging"
 	"github.com/0h41/pulsekontrol/src/midi"
 	"github.com/0h41/pulsekontrol/src/pulseaudio"
+	"github.com/0h41/pulsekontrol/src/statusbar"
 	"github.com/0h41/pulsekontrol/src/webui"
 	"github.com/DavidGamba/go-getoptions"
-	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
@@ -23,10 +40,26 @@ var (
 )
 
 func Run() {
-	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339})
+	log.Logger = logging.Logger()
 
-	// Create PulseAudio client
-	paClient := pulseaudio.NewPAClient()
+	// One-shot commands work without a configuration or a running instance
+	if len(os.Args) > 1 {
+		if command, ok := audioCommands[os.Args[1]]; ok {
+			os.Exit(runAudioCommand(os.Args[1], command, os.Args[2:]))
+		}
+		switch os.Args[1] {
+		case "assign", "unassign", "list-assignments":
+			os.Exit(assignmentCommand(os.Args[1], os.Args[2:]))
+		case "ctl":
+			os.Exit(ctlCommand(os.Args[2:]))
+		case "remote":
+			os.Exit(remoteCommand(os.Args[2:]))
+		case "monitor":
+			os.Exit(monitorCommand(os.Args[2:]))
+		case "statusbar":
+			os.Exit(statusbarCommand(os.Args[2:]))
+		}
+	}
 
 	// Parse command line
 	opt := getoptions.New()
@@ -38,16 +71,56 @@ func Run() {
 	opt.Bool("list-pulse", false, opt.Alias("p"), opt.Description("List PulseAudio objects"))
 	opt.Bool("list-pulse-detailed", false, opt.Description("List PulseAudio objects with detailed properties"))
 	opt.Bool("version", false, opt.Alias("v"), opt.Description("Show version"))
-	opt.Bool("no-webui", false, opt.Description("Disable web interface"))
-	webAddr := opt.StringOptional("web-addr", "127.0.0.1:6080", opt.Description("Web interface address:port"))
+	configPath := opt.String("config", "", opt.ArgName("file"), opt.Description("Configuration file to use instead of the searched locations"))
+	logLevel := opt.String("log-level", "", opt.ArgName("level"), opt.Description("Log level of all modules: trace, debug, info, warn or error, overriding the configuration"))
+	logFormat := opt.String("log-format", "", opt.ArgName("format"), opt.Description("Log format: console or json, overriding the configuration"))
+	opt.Bool("quiet", false, opt.Alias("q"), opt.Description("Only log warnings and errors, same as --log-level warn"))
+	opt.Bool("webui", false, opt.Description("Enable web interface, overriding the configuration"))
+	opt.Bool("no-webui", false, opt.Description("Disable web interface, overriding the configuration"))
+	opt.Bool("check-config", false, opt.Description("Validate the configuration and exit with a non-zero status on errors"))
+	opt.Bool("dump-config", false, opt.Description("Print the effective configuration after migrations and defaults"))
+	opt.Bool("diff", false, opt.Description("With --dump-config, print only the differences from the file on disk"))
+	opt.Bool("list-backups", false, opt.Description("List configuration backups"))
+	opt.Bool("no-autodetect", false, opt.Description("Only use the configured MIDI ports, don't look for a supported controller when they are missing"))
+	opt.Bool("require-midi", false, opt.Description("Exit with an error when the MIDI device is not found instead of waiting for it"))
+	opt.Bool("takeover", false, opt.Description("Terminate a running pulsekontrol and take over its MIDI device instead of exiting"))
+	opt.Bool("no-lock", false, opt.Description("Run read-only without saving if another instance holds the configuration lock"))
+	opt.Bool("read-only", false, opt.Description("Never write the configuration, changes apply until exit"))
+	opt.Bool("dry-run", false, opt.Description("Log the volume, mute and default device changes instead of applying them, implies --read-only"))
+	opt.Bool("stale-sources", false, opt.Description("List assigned sources that were not seen for longer than staleSources.afterDays"))
+	opt.Bool("remove-stale-sources", false, opt.Description("List and unassign sources that were not seen for longer than staleSources.afterDays"))
+	exportProfile := opt.String("export-profile", "", opt.ArgName("profile"), opt.Description("Print a profile and the aliases of its sources as a YAML snippet"))
+	importProfile := opt.String("import-profile", "", opt.ArgName("file"), opt.Description("Add the profile of a snippet file, - reads standard input"))
+	importAs := opt.String("import-as", "", opt.ArgName("profile"), opt.Description("With --import-profile, name of the new profile"))
+	hostProfile := opt.String("host-profile", "", opt.ArgName("name"), opt.Description("Apply the configuration override with this name instead of the one for the hostname"))
+	restoreBackup := opt.Int("restore-backup", 0, opt.ArgName("number"), opt.Description("Restore a configuration backup, 1 is the most recent"))
+	webAddr := opt.StringOptional("web-addr", configuration.DefaultWebUIAddress, opt.Description("Web interface address:port, overriding the configuration"))
 	opt.Parse(os.Args[1:])
+
+	// Logging is set up before anything that logs
+	flags := logFlags{level: *logLevel, format: *logFormat}
+	if opt.Called("quiet") && flags.level == "" {
+		flags.level = "warn"
+	}
+	if err := flags.check(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	applyLogging(configuration.LoggingConfig{}, flags)
+
+	if opt.Called("config") {
+		configuration.SetPath(*configPath)
+	}
+	if opt.Called("host-profile") {
+		configuration.SetHostProfile(*hostProfile)
+	}
 	if opt.Called("help") {
 		fmt.Fprint(os.Stderr, opt.Help())
 		os.Exit(0)
 	}
 	if opt.Called("list") {
 		midi.List()
-		paClient.List()
+		connectForListing().List()
 		os.Exit(0)
 	}
 	if opt.Called("list-midi") {
@@ -55,11 +128,11 @@ func Run() {
 		os.Exit(0)
 	}
 	if opt.Called("list-pulse") {
-		paClient.List()
+		connectForListing().List()
 		os.Exit(0)
 	}
 	if opt.Called("list-pulse-detailed") {
-		paClient.ListDetailed()
+		connectForListing().ListDetailed()
 		os.Exit(0)
 	}
 	if opt.Called("version") {
@@ -67,498 +140,1810 @@ func Run() {
 		os.Exit(0)
 	}
 
-	// Configuration
-	config, path, err := configuration.Load()
-	if err != nil {
-		log.Error().Msgf("Configuration error %+v", err)
-		os.Exit(1)
+	if opt.Called("check-config") {
+		_, path, err := configuration.Load()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Configuration %s is valid\n", path)
+		os.Exit(0)
 	}
-	log.Info().Msgf("Loaded configuration from %s", path)
 
-	// Create configuration manager
-	configManager := configuration.NewConfigManager(config, path)
-
-	// Start web UI if enabled
-	var webServer *webui.WebUIServer
-	if !opt.Called("no-webui") {
-		webServer = webui.NewWebUIServer(*webAddr, paClient, configManager)
-
-		// Set up configuration update notifications to WebUI
-		configManager.Subscribe("mapping.updated", func(data interface{}) {
-			// Convert to JSON and broadcast to clients
-			// This is a simplified example - in a real implementation,
-			// you would serialize the data and broadcast it
-			webServer.NotifyConfigUpdate(data)
-		})
-
-		// Fast path for control value updates
-		configManager.Subscribe("control.value.updated", func(data interface{}) {
-			log.Debug().Interface("data", data).Msg("Received control.value.updated notification")
-			if updateMap, ok := data.(map[string]interface{}); ok {
-				if controlType, ok := updateMap["type"].(string); ok {
-					if controlId, ok := updateMap["id"].(string); ok {
-						if value, ok := updateMap["value"].(int); ok {
-							log.Debug().Str("controlType", controlType).Str("controlId", controlId).Int("value", value).Msg("Sending fast path UI update")
-							webServer.NotifyControlValueUpdate(controlType, controlId, value)
-						}
-					}
-				}
-			}
-		})
-
-		go func() {
-			if err := webServer.Start(); err != nil {
-				log.Error().Err(err).Msg("Failed to start web server")
-			}
-		}()
-		log.Info().Msgf("Web interface available at http://%s", *webAddr)
+	if opt.Called("dump-config") {
+		result, err := configuration.Inspect()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		var data []byte
+		if opt.Called("diff") {
+			data, err = result.Diff()
+		} else {
+			data, err = result.Dump()
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(data)
+		os.Exit(0)
 	}
 
-	// Convert new config format to legacy format for MIDI client
-	// This is temporary compatibility code until the MIDI client is updated
-	midiDevice := configuration.MidiDevice{
-		Name:        config.Device.Name,
-		Type:        configuration.KorgNanoKontrol2, // Only support KORG nanoKONTROL2
-		MidiInName:  config.Device.InPort,
-		MidiOutName: config.Device.OutPort,
+	if opt.Called("list-backups") {
+		path, err := configuration.Path()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		backups := configuration.ListBackups(path)
+		if len(backups) == 0 {
+			fmt.Printf("No backups of %s\n", path)
+		}
+		for _, backup := range backups {
+			fmt.Printf("%d  %s  %s\n", backup.Number, backup.ModTime.Format("2006-01-02 15:04:05"), backup.Path)
+		}
+		os.Exit(0)
 	}
 
-	// Create rules from control assignments
-	rules := createRulesFromConfig(config, midiDevice)
-
-	// Create MIDI client
-	midiClients := make([]*midi.MidiClient, 0, 1)
-	midiClient := midi.NewMidiClient(paClient, midiDevice, rules, configManager)
-	midiClients = append(midiClients, midiClient)
-
-	// Subscribe to configuration changes to update rules dynamically
-	configManager.Subscribe("source.assigned", func(data interface{}) {
-		// Regenerate rules when sources are assigned
-		log.Info().Msg("Source assigned, updating MIDI rules")
-
-		// Extract assignment details
-		assignData, ok := data.(map[string]interface{})
-		if !ok {
-			log.Error().Msg("Invalid data format from source.assigned event")
-			return
+	if opt.Called("restore-backup") {
+		path, err := configuration.Path()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
-
-		// Immediately set the volume of the newly assigned source to match the control's current value
-		if initialValue, hasValue := assignData["initialValue"].(int); hasValue {
-			source, hasSource := assignData["source"].(configuration.Source)
-			if hasSource {
-				// Convert 0-100 value to 0.0-1.0 range for PulseAudio
-				volumePercent := float32(initialValue) / 100.0
-
-				// Create a temporary action to set the volume
-				action := configuration.Action{
-					Type: configuration.SetVolume,
-					Target: &configuration.TypedTarget{
-						Type:       source.Type,
-						Name:       source.Name,
-						BinaryName: source.BinaryName,
-					},
-				}
-
-				// Process the volume action immediately
-				log.Info().
-					Str("sourceName", source.Name).
-					Str("sourceType", string(source.Type)).
-					Int("value", initialValue).
-					Msg("Setting initial volume for newly assigned source")
-
-				paClient.ProcessVolumeAction(action, volumePercent)
-			}
+		// The current config may be the broken one, fall back to the default backup count
+		count := configuration.DefaultBackupCount
+		if current, _, err := configuration.Load(); err == nil {
+			count = *current.Backups.Count
 		}
-
-		// Recreate rules from current configuration - get the latest config!
-		currentConfig := configManager.GetConfig()
-		newRules := createRulesFromConfig(*currentConfig, midiDevice)
-
-		// Update the MIDI client with the new rules
-		midiClient.UpdateRules(newRules)
-
-		// Update LED indicators
-		if err := midiClient.UpdateLEDIndicators(); err != nil {
-			log.Error().Err(err).Msg("Failed to update LED indicators after source assignment")
+		if err := configuration.RestoreBackup(path, *restoreBackup, count); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
-	})
+		fmt.Printf("Restored backup %d to %s\n", *restoreBackup, path)
+		os.Exit(0)
+	}
 
-	configManager.Subscribe("source.unassigned", func(data interface{}) {
-		// Regenerate rules when sources are unassigned
-		log.Info().Msg("Source unassigned, updating MIDI rules")
+	if opt.Called("stale-sources") || opt.Called("remove-stale-sources") {
+		os.Exit(staleSourcesCommand(opt.Called("remove-stale-sources")))
+	}
 
-		// Recreate rules from current configuration - get the latest config!
-		currentConfig := configManager.GetConfig()
-		newRules := createRulesFromConfig(*currentConfig, midiDevice)
+	if opt.Called("export-profile") {
+		os.Exit(exportProfileCommand(*exportProfile))
+	}
+	if opt.Called("import-profile") {
+		os.Exit(importProfileCommand(*importProfile, *importAs))
+	}
 
-		// Update the MIDI client with the new rules
-		midiClient.UpdateRules(newRules)
+	options := Options{
+		ConfigPath:   *configPath,
+		HostProfile:  *hostProfile,
+		ReadOnly:     opt.Called("read-only"),
+		DryRun:       opt.Called("dry-run"),
+		NoLock:       opt.Called("no-lock"),
+		NoAutodetect: opt.Called("no-autodetect"),
+		RequireMidi:  opt.Called("require-midi"),
+		LogLevel:     flags.level,
+		LogFormat:    flags.format,
+		Systemd:      true,
+	}
+	// Command line flags take precedence over the configuration
+	if opt.Called("webui") || opt.Called("no-webui") {
+		webUIEnabled := !opt.Called("no-webui")
+		options.WebUI = &webUIEnabled
+	}
+	if opt.Called("web-addr") {
+		options.WebAddr = *webAddr
+	}
 
-		// Update LED indicators
-		if err := midiClient.UpdateLEDIndicators(); err != nil {
-			log.Error().Err(err).Msg("Failed to update LED indicators after source unassignment")
-		}
-	})
+	// Two instances would fight over the MIDI ports
+	lock, err := lockInstance(opt.Called("takeover"))
+	if err != nil {
+		log.Error().Err(err).Msg("Cannot start")
+		os.Exit(1)
+	}
 
-	go func() {
-		if err := midiClient.Run(); err != nil {
-			log.Error().Err(err).Msg("MIDI client failed")
-			os.Exit(1)
+	app, err := New(options)
+	if err != nil {
+		var lockErr *configuration.LockError
+		if errors.As(err, &lockErr) {
+			log.Error().Err(err).Msg("Cannot lock configuration, use --no-lock to run read-only")
+		} else {
+			log.Error().Msgf("Configuration error %+v", err)
 		}
-	}()
+		os.Exit(1)
+	}
+	if err := app.Start(context.Background()); err != nil {
+		log.Error().Err(err).Msg("Failed to start")
+		os.Exit(1)
+	}
+	status := waitForShutdown(app)
+	lock.Release()
+	os.Exit(status)
+}
 
-	// Trigger initial volume actions to perform any needed config migrations
-	// and sync initial volumes to control positions
-	triggerStartupVolumeActions(paClient, configManager)
+// takeoverTimeout bounds waiting for a running instance to stop with --takeover
+const takeoverTimeout = 15 * time.Second
+
+// lockInstance takes the runtime lock, terminating the instance holding it
+// with takeover
+func lockInstance(takeover bool) (*instance.Lock, error) {
+	path := instance.Path()
+	var lock *instance.Lock
+	var err error
+	if takeover {
+		lock, err = instance.Takeover(path, takeoverTimeout)
+	} else {
+		lock, err = instance.Acquire(path)
+	}
+	var running *instance.RunningError
+	if errors.As(err, &running) {
+		return nil, fmt.Errorf("%w, stop it or use --takeover", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if lock.StalePID > 0 {
+		log.Info().Int("pid", lock.StalePID).Msg("Reclaimed the lock of an instance that is no longer running")
+	}
+	return lock, nil
+}
 
-	// Set up stream monitoring for automatic volume application and LED updates
-	setupStreamMonitoring(paClient, configManager, midiClient)
+// logFlags are the logging command line flags, which take precedence over
+// the logging section of the configuration
+type logFlags struct {
+	level  string
+	format string
+	output io.Writer // Replaces standard error or the log file if set
+}
 
-	// Set up signal handling for graceful shutdown
-	setupSignalHandling(paClient)
+// check reports invalid flag values
+func (flags logFlags) check() error {
+	options, err := configuration.LoggingConfig{}.Override(flags.level, flags.format).Options()
+	if err != nil {
+		return err
+	}
+	switch options.Format {
+	case "", logging.FormatConsole, logging.FormatJSON:
+		return nil
+	}
+	return fmt.Errorf("unknown log format %q, use console or json", options.Format)
+}
 
-	// Wait for program to exit
-	select {}
+// applyLogging sets the log levels and output of the logging section and the flags
+func applyLogging(config configuration.LoggingConfig, flags logFlags) {
+	options, err := config.Override(flags.level, flags.format).Options()
+	if err == nil {
+		options.Output = flags.output
+		err = logging.Configure(options)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Invalid logging configuration, keeping the current settings")
+	}
 }
 
-func setupSignalHandling(paClient *pulseaudio.PAClient) {
+// waitForShutdown runs the app until SIGINT or SIGTERM or until a part of it
+// fails, then stops it and returns the exit status. SIGHUP applies changes of
+// the logging section, SIGUSR1 writes a dump of the state to a file, see
+// DumpState. A second signal during shutdown exits right away.
+func waitForShutdown(app *App) int {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	usr1Chan := make(chan os.Signal, 1)
+	signal.Notify(usr1Chan, syscall.SIGUSR1)
+
+	status := 0
+wait:
+	for {
+		select {
+		case <-hupChan:
+			if err := app.ReloadLogging(); err != nil {
+				log.Error().Err(err).Msg("Cannot reload logging configuration")
+				continue
+			}
+			log.Info().Msg("Reloaded logging configuration")
+		case <-usr1Chan:
+			go app.writeStateDump()
+		case sig := <-sigChan:
+			log.Info().Msgf("Received signal %s, shutting down...", sig)
+			break wait
+		case err := <-app.Errors():
+			log.Error().Err(err).Msg("Shutting down after a failure")
+			status = 1
+			break wait
+		}
+	}
 
+	signal.Stop(hupChan)
+	signal.Stop(usr1Chan)
+	stopped := make(chan struct{})
 	go func() {
-		sig := <-sigChan
-		log.Info().Msgf("Received signal %s, shutting down...", sig)
-
-		// Stop stream monitoring
-		paClient.StopStreamMonitoring()
-
-		os.Exit(0)
+		select {
+		case sig := <-sigChan:
+			log.Warn().Msgf("Received signal %s during shutdown, exiting immediately", sig)
+			os.Exit(1)
+		case <-stopped:
+		}
 	}()
-}
-
-// Helper to extract a group number from a control path
-func extractGroupNumber(path string) (int, error) {
-	var groupNumber int
 
-	// Try to parse "GroupX/..." format
-	_, err := fmt.Sscanf(path, "Group%d/", &groupNumber)
-	if err != nil {
-		return 0, fmt.Errorf("failed to parse group number from path %s: %w", path, err)
+	if err := app.Stop(); err != nil {
+		fmt.Fprintf(os.Stderr, "pulsekontrol: could not save configuration: %s\n", err)
+		status = 1
 	}
+	signal.Stop(sigChan)
+	close(stopped)
+	return status
+}
 
-	return groupNumber, nil
+// bindingMessage returns the MIDI message matching a control path of a device
+func bindingMessage(deviceName string, path string, binding configuration.ControlBinding, channel uint8) configuration.MidiMessage {
+	message := configuration.MidiMessage{
+		DeviceName:        deviceName,
+		DeviceControlPath: path,
+		Type:              binding.MessageType(),
+		Channel:           channel,
+	}
+	if message.Type == configuration.Note {
+		message.Note = binding.Number
+	} else {
+		message.Controller = binding.Number
+	}
+	return message
 }
 
 // createRulesFromConfig generates MIDI rules from the current configuration
 func createRulesFromConfig(config configuration.Config, midiDevice configuration.MidiDevice) []configuration.Rule {
 	var rules []configuration.Rule
 
+	device, ok := config.DeviceByName(midiDevice.Name)
+	if !ok {
+		device = configuration.DeviceConfig{Name: midiDevice.Name, Type: midiDevice.Type}
+	}
+	controlMap, known := device.Capabilities()
+	if !known {
+		log.Error().Str("device", device.Name).Msgf("Controls of device type %s are unknown", device.Type)
+		return nil
+	}
+
+	// nanoKONTROL2 uses channel 0 in internal mode, channel 15 in external mode
+	channel := configuration.DefaultChannel
+	if device.Channel != nil {
+		channel = *device.Channel
+	}
+
 	// Add slider rules
-	for _, slider := range config.Controls.Sliders {
-		if len(slider.Sources) > 0 {
-			// Parse the slider path to get the group number
-			groupNumber, err := extractGroupNumber(slider.Path)
-			if err != nil {
-				log.Error().Err(err).Str("path", slider.Path).Msg("Failed to parse slider path")
+	for sliderID, slider := range config.Controls.Sliders {
+		if config.ControlDevice(slider.Device) != midiDevice.Name {
+			continue
+		}
+		sources := config.SliderSources(slider)
+		if len(sources) > 0 {
+			binding, ok := controlMap.Binding("slider", slider.Path)
+			if !ok {
+				log.Error().Str("device", device.Name).Str("path", slider.Path).Msg("Device has no slider with this path")
 				continue
 			}
 
-			// For nanoKONTROL2, slider controllers are 0-7 for groups 1-8
-			controller := uint8(groupNumber - 1)
-
+			message := bindingMessage(midiDevice.Name, slider.Path, binding, channel)
+			message.MinValue = slider.MinValue
+			message.MaxValue = slider.MaxValue
 			rule := configuration.Rule{
-				MidiMessage: configuration.MidiMessage{
-					DeviceName:        midiDevice.Name,
-					DeviceControlPath: slider.Path,
-					Type:              configuration.ControlChange,
-					Channel:           15, // nanoKONTROL2 uses channel 0 in internal mode, channel 15 in external mode
-					Controller:        controller,
-				},
-				Actions: []configuration.Action{},
+				MidiMessage: message,
+				Actions:     []configuration.Action{},
+				ControlType: "slider",
+				ControlID:   sliderID,
 			}
 
 			// Add an action for each source
-			for _, source := range slider.Sources {
+			for _, source := range sources {
 				log.Debug().
 					Str("sourceName", source.Name).
 					Str("sourceBinaryName", source.BinaryName).
 					Str("sourceType", string(source.Type)).
 					Msg("Creating action for slider source")
 				action := configuration.Action{
-					Type: configuration.SetVolume,
-					Target: &configuration.TypedTarget{
-						Type:       source.Type,
-						Name:       source.Name,
-						BinaryName: source.BinaryName,
-					},
+					Type:   configuration.SetVolume,
+					Target: source.Target(),
 				}
 				rule.Actions = append(rule.Actions, action)
 			}
 
 			rules = append(rules, rule)
 			log.Debug().
-				Msgf("Added rule for slider path %s with %d sources (controller=%d)",
-					slider.Path, len(slider.Sources), controller)
+				Msgf("Added rule for slider path %s with %d sources (%s %d)",
+					slider.Path, len(sources), message.Type, binding.Number)
 		}
 	}
 
 	// Add knob rules
-	for _, knob := range config.Controls.Knobs {
-		if len(knob.Sources) > 0 {
-			// Parse the knob path to get the group number
-			groupNumber, err := extractGroupNumber(knob.Path)
-			if err != nil {
-				log.Error().Err(err).Str("path", knob.Path).Msg("Failed to parse knob path")
+	for knobID, knob := range config.Controls.Knobs {
+		if config.ControlDevice(knob.Device) != midiDevice.Name {
+			continue
+		}
+		sources := config.KnobSources(knob)
+		if len(sources) > 0 {
+			binding, ok := controlMap.Binding("knob", knob.Path)
+			if !ok {
+				log.Error().Str("device", device.Name).Str("path", knob.Path).Msg("Device has no knob with this path")
 				continue
 			}
 
-			// For nanoKONTROL2, knob controllers are 16-23 for groups 1-8
-			controller := uint8(16 + groupNumber - 1)
-
+			message := bindingMessage(midiDevice.Name, knob.Path, binding, channel)
+			message.MinValue = knob.MinValue
+			message.MaxValue = knob.MaxValue
 			rule := configuration.Rule{
-				MidiMessage: configuration.MidiMessage{
-					DeviceName:        midiDevice.Name,
-					DeviceControlPath: knob.Path,
-					Type:              configuration.ControlChange,
-					Channel:           15, // nanoKONTROL2 uses channel 0 in internal mode, channel 15 in external mode
-					Controller:        controller,
-				},
-				Actions: []configuration.Action{},
+				MidiMessage: message,
+				Actions:     []configuration.Action{},
+				ControlType: "knob",
+				ControlID:   knobID,
 			}
 
 			// Add an action for each source
-			for _, source := range knob.Sources {
+			for _, source := range sources {
 				log.Debug().
 					Str("sourceName", source.Name).
 					Str("sourceBinaryName", source.BinaryName).
 					Str("sourceType", string(source.Type)).
 					Msg("Creating action for knob source")
 				action := configuration.Action{
-					Type: configuration.SetVolume,
-					Target: &configuration.TypedTarget{
-						Type:       source.Type,
-						Name:       source.Name,
-						BinaryName: source.BinaryName,
-					},
+					Type:   configuration.SetVolume,
+					Target: source.Target(),
 				}
 				rule.Actions = append(rule.Actions, action)
 			}
 
 			rules = append(rules, rule)
 			log.Debug().
-				Msgf("Added rule for knob path %s with %d sources (controller=%d)",
-					knob.Path, len(knob.Sources), controller)
-		}
-	}
-
-	// Add group button rules for assigning focused window playback streams
-	for groupNumber := 1; groupNumber <= 8; groupNumber++ {
-		sliderControlID := fmt.Sprintf("slider%d", groupNumber)
-		recordRule := configuration.Rule{
-			MidiMessage: configuration.MidiMessage{
-				DeviceName:        midiDevice.Name,
-				DeviceControlPath: fmt.Sprintf("Group%d/Record", groupNumber),
-				Type:              configuration.ControlChange,
-				Channel:           15,
-				Controller:        uint8(64 + groupNumber - 1),
-			},
-			Actions: []configuration.Action{
-				{
-					Type: configuration.AssignFocusedWindowPlaybackStreams,
-					Target: &configuration.ControlTarget{
-						ControlType: "slider",
-						ControlID:   sliderControlID,
-					},
-				},
-			},
-		}
-		rules = append(rules, recordRule)
-
-		knobControlID := fmt.Sprintf("knob%d", groupNumber)
-		soloRule := configuration.Rule{
-			MidiMessage: configuration.MidiMessage{
-				DeviceName:        midiDevice.Name,
-				DeviceControlPath: fmt.Sprintf("Group%d/Solo", groupNumber),
-				Type:              configuration.ControlChange,
-				Channel:           15,
-				Controller:        uint8(32 + groupNumber - 1),
-			},
-			Actions: []configuration.Action{
-				{
-					Type: configuration.AssignFocusedWindowPlaybackStreams,
-					Target: &configuration.ControlTarget{
-						ControlType: "knob",
-						ControlID:   knobControlID,
-					},
-				},
-			},
-		}
-		rules = append(rules, soloRule)
-	}
-
-	// Add transport button rules (hardcoded for now)
-	// Play button rule - Controller 41, Channel 15 in external mode
-	playRule := configuration.Rule{
-		MidiMessage: configuration.MidiMessage{
-			DeviceName:        midiDevice.Name,
-			DeviceControlPath: "Transport/Play",
-			Type:              configuration.ControlChange,
-			Channel:           15, // nanoKONTROL2 uses channel 0 in internal mode, channel 15 in external mode
-			Controller:        41, // Play button controller number
-		},
-		Actions: []configuration.Action{
-			{
-				Type:   configuration.MediaPlayPause,
-				Target: nil, // No specific target for media control
-			},
-		},
-	}
-	rules = append(rules, playRule)
-	log.Debug().Msg("Added rule for play button (Transport/Play)")
+				Msgf("Added rule for knob path %s with %d sources (%s %d)",
+					knob.Path, len(sources), message.Type, binding.Number)
+		}
+	}
+
+	// Add button rules
+	for buttonID, button := range config.Controls.Buttons {
+		if config.ControlDevice(button.Device) != midiDevice.Name || len(button.Actions) == 0 {
+			continue
+		}
+
+		binding, ok := controlMap.Binding("button", button.Path)
+		if !ok {
+			log.Error().Str("device", device.Name).Str("button", buttonID).Str("path", button.Path).Msg("Device has no button with this path")
+			continue
+		}
+
+		rule := configuration.Rule{
+			MidiMessage: bindingMessage(midiDevice.Name, button.Path, binding, channel),
+			Actions:     button.Actions,
+		}
+		rules = append(rules, rule)
+		log.Debug().
+			Msgf("Added rule for button path %s with %d actions (%s %d)",
+				button.Path, len(button.Actions), rule.MidiMessage.Type, binding.Number)
+	}
 
 	return rules
 }
 
-// setupStreamMonitoring configures automatic volume application for new streams
-func setupStreamMonitoring(paClient *pulseaudio.PAClient, configManager *configuration.ConfigManager, midiClient *midi.MidiClient) {
-	// Set up callback for new streams - re-trigger volume actions and update LEDs
-	paClient.SetNewStreamCallback(func(stream pulseaudio.Stream, streamType configuration.PulseAudioTargetType) {
-		log.Info().
-			Str("streamName", stream.Name).
-			Str("streamBinary", stream.BinaryName).
-			Str("streamType", string(streamType)).
-			Msg("New stream detected, re-applying all volume settings and updating LEDs")
-
-		// Re-trigger the startup volume actions - this uses the exact same code path as startup
-		triggerStartupVolumeActions(paClient, configManager)
+// staleSourcesCommand lists the assigned sources that were not seen for
+// longer than staleSources.afterDays and unassigns them if remove is set
+func staleSourcesCommand(remove bool) int {
+	path, err := configuration.Path()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
 
-		// Update LED indicators to reflect current active streams
-		if err := midiClient.UpdateLEDIndicators(); err != nil {
-			log.Error().Err(err).Msg("Failed to update LED indicators after new stream detected")
+	var config configuration.Config
+	if remove {
+		// Removing saves the configuration, a running instance would overwrite it
+		lock, err := configuration.Lock(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, "Stop it first or remove the stale sources from the web interface")
+			return 1
 		}
-	})
+		defer lock.Unlock()
+		config, path, err = configuration.Load()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	} else {
+		result, err := configuration.Inspect()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		config, path = result.Config, result.Path
+	}
 
-	// Set up callback for removed streams - update LEDs when streams disappear
-	paClient.SetRemovedStreamCallback(func(stream pulseaudio.Stream, streamType configuration.PulseAudioTargetType) {
-		log.Info().
-			Str("streamID", stream.FullName).
-			Str("streamType", string(streamType)).
-			Msg("Stream removed, updating LEDs")
+	configManager := configuration.NewConfigManager(config, path)
+	stale := configManager.StaleSources()
+	if len(stale) == 0 {
+		fmt.Printf("No sources unseen for more than %d days in %s\n", *config.StaleSources.AfterDays, path)
+		return 0
+	}
+	for _, entry := range stale {
+		days := int(time.Since(entry.Source.LastSeen).Hours() / 24)
+		fmt.Printf("%s  %s %s  %s %s  last seen %s (%d days ago)\n", entry.Profile, entry.ControlType, entry.ControlID, entry.Source.Type, entry.Source.Name, entry.Source.LastSeen.Format("2006-01-02"), days)
+	}
+	if !remove {
+		fmt.Printf("%d stale sources, use --remove-stale-sources to unassign them\n", len(stale))
+		return 0
+	}
 
-		// Update LED indicators to reflect current active streams (removed streams won't be found)
-		if err := midiClient.UpdateLEDIndicators(); err != nil {
-			log.Error().Err(err).Msg("Failed to update LED indicators after stream removed")
-		}
-	})
+	configManager.RemoveStaleSources()
+	if err := configManager.Flush(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("Removed %d stale sources from %s\n", len(stale), path)
+	return 0
+}
 
-	// Set up callback for media status changes - update play button LED
-	paClient.SetMediaStatusCallback(func(isPlaying bool) {
-		log.Info().
-			Bool("isPlaying", isPlaying).
-			Msg("Media status changed, updating play button LED")
+// exportProfileCommand prints a profile as a snippet
+func exportProfileCommand(name string) int {
+	result, err := configuration.Inspect()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	configManager := configuration.NewConfigManager(result.Config, result.Path)
+	snippet, err := configManager.ExportProfile(name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	os.Stdout.Write(snippet)
+	return 0
+}
 
-		// Update only the play button LED
-		if err := midiClient.UpdatePlayButtonLED(isPlaying); err != nil {
-			log.Error().Err(err).Msg("Failed to update play button LED after media status change")
+// importProfileCommand adds the profile of a snippet file to the configuration
+func importProfileCommand(file string, name string) int {
+	var snippet []byte
+	var err error
+	if file == "-" {
+		snippet, err = io.ReadAll(os.Stdin)
+	} else {
+		snippet, err = os.ReadFile(file)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	path, err := configuration.Path()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	// Importing saves the configuration, a running instance would overwrite it
+	lock, err := configuration.Lock(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, "Stop it first or import the profile through its API at /api/profile/import")
+		return 1
+	}
+	defer lock.Unlock()
+	config, path, err := configuration.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	configManager := configuration.NewConfigManager(config, path)
+	imported, err := configManager.ImportProfile(snippet, name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := configManager.Flush(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("Imported profile %s into %s\n", imported, path)
+	return 0
+}
+
+// audioCommand is a one-shot command changing PulseAudio directly, with the
+// matching of configured sources
+type audioCommand struct {
+	description string
+	args        string                             // Positional arguments after the name, for the help
+	targetType  configuration.PulseAudioTargetType // Default of --type
+	fixedType   bool                               // Whether --type can be changed
+	run         func(paClient *pulseaudio.PAClient, selector pulseaudio.Selector, args []string) int
+}
+
+var audioCommands = map[string]audioCommand{
+	"set-volume": {
+		description: "Set the volume of matching streams or devices",
+		args:        "<volume 0-100>",
+		targetType:  configuration.PlaybackStream,
+		run:         setVolumeCommand,
+	},
+	"volume-up": {
+		description: "Raise the volume of matching streams or devices by a step, 5% by default",
+		args:        "[<step>]",
+		targetType:  configuration.PlaybackStream,
+		run:         stepVolumeCommand(1),
+	},
+	"volume-down": {
+		description: "Lower the volume of matching streams or devices by a step, 5% by default",
+		args:        "[<step>]",
+		targetType:  configuration.PlaybackStream,
+		run:         stepVolumeCommand(-1),
+	},
+	"mute": {
+		description: "Mute matching streams or devices",
+		targetType:  configuration.PlaybackStream,
+		run:         muteCommand(func(bool) bool { return true }),
+	},
+	"unmute": {
+		description: "Unmute matching streams or devices",
+		targetType:  configuration.PlaybackStream,
+		run:         muteCommand(func(bool) bool { return false }),
+	},
+	"toggle-mute": {
+		description: "Toggle muting of matching streams or devices",
+		targetType:  configuration.PlaybackStream,
+		run:         muteCommand(func(muted bool) bool { return !muted }),
+	},
+	"set-default-sink": {
+		description: "Make the matching output device the default",
+		targetType:  configuration.OutputDevice,
+		fixedType:   true,
+		run:         setDefaultCommand(configuration.SetDefaultOutput),
+	},
+	"set-default-source": {
+		description: "Make the matching input device the default",
+		targetType:  configuration.InputDevice,
+		fixedType:   true,
+		run:         setDefaultCommand(configuration.SetDefaultInput),
+	},
+	"meter": {
+		description: "Print the peak and RMS levels of the matching stream or device as JSON lines until interrupted",
+		targetType:  configuration.OutputDevice,
+		run:         meterCommand,
+	},
+}
+
+// runAudioCommand parses the selector of a one-shot command and runs it
+func runAudioCommand(name string, command audioCommand, args []string) int {
+	opt := getoptions.New()
+	opt.Self("pulsekontrol "+name, command.description)
+	opt.HelpSynopsisArg("<name>", "Name of the stream or device, instead of --name")
+	if command.args != "" {
+		opt.HelpSynopsisArg(command.args, "")
+	}
+	opt.HelpCommand("help", opt.Alias("h"), opt.Description("Show this help"))
+	typeName := opt.String("type", "", opt.ArgName("type"), opt.Description(fmt.Sprintf("PlaybackStream, RecordStream, OutputDevice or InputDevice, defaults to %s", command.targetType)))
+	sourceName := opt.String("name", "", opt.ArgName("name"), opt.Description("Name of the stream or device"))
+	binaryName := opt.String("binary", "", opt.ArgName("binary"), opt.Description("Only match streams of this program binary"))
+	opt.Bool("glob", false, opt.Description("Match the name as a shell pattern, like Fire*"))
+	opt.Bool("regex", false, opt.Description("Match the name as a regular expression"))
+	rest, err := opt.Parse(args)
+	if opt.Called("help") {
+		fmt.Fprint(os.Stderr, opt.Help())
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	selector := pulseaudio.Selector{Type: command.targetType, Name: *sourceName, BinaryName: *binaryName, Mode: pulseaudio.MatchExact}
+	if *typeName != "" {
+		targetType, ok := configuration.ParseTargetType(*typeName)
+		if !ok || (command.fixedType && targetType != command.targetType) {
+			fmt.Fprintf(os.Stderr, "pulsekontrol %s: invalid --type %s\n", name, *typeName)
+			return 1
 		}
-	})
+		selector.Type = targetType
+	}
+	if opt.Called("glob") && opt.Called("regex") {
+		fmt.Fprintf(os.Stderr, "pulsekontrol %s: use either --glob or --regex\n", name)
+		return 1
+	}
+	if opt.Called("glob") {
+		selector.Mode = pulseaudio.MatchGlob
+	} else if opt.Called("regex") {
+		selector.Mode = pulseaudio.MatchRegex
+	}
+	if selector.Name == "" && len(rest) > 0 {
+		selector.Name, rest = rest[0], rest[1:]
+	}
+	if selector.Name == "" {
+		fmt.Fprintf(os.Stderr, "pulsekontrol %s: missing name\n", name)
+		return 1
+	}
 
-	// Start stream monitoring
-	err := paClient.StartStreamMonitoring()
+	// Only problems are of interest on the command line
+	applyLogging(configuration.LoggingConfig{}, logFlags{level: "warn"})
+	paClient, err := pulseaudio.Connect()
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to start stream monitoring")
-		return
+		fmt.Fprintf(os.Stderr, "pulsekontrol %s: cannot connect to PulseAudio: %v\n", name, err)
+		return 1
 	}
+	return command.run(paClient, selector, rest)
+}
 
-	// Start media status monitoring
-	err = paClient.StartMediaStatusMonitoring()
+// connectForListing connects to PulseAudio for the listing flags and exits if
+// that fails
+func connectForListing() *pulseaudio.PAClient {
+	paClient, err := pulseaudio.Connect()
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to start media status monitoring")
-		return
+		fmt.Fprintf(os.Stderr, "Cannot connect to PulseAudio: %v\n", err)
+		os.Exit(1)
 	}
+	return paClient
+}
 
-	log.Info().Msg("Stream monitoring enabled - new applications will automatically have volumes applied and LEDs updated")
+// selectStreams returns the targets of a selector with the streams or
+// devices each one matches, leaving out targets without any. It reports on
+// standard error when nothing matched.
+func selectStreams(paClient *pulseaudio.PAClient, selector pulseaudio.Selector) (map[configuration.TypedTarget][]pulseaudio.Stream, []configuration.TypedTarget) {
+	targets, err := paClient.SelectTargets(selector)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, nil
+	}
+	matches := make(map[configuration.TypedTarget][]pulseaudio.Stream)
+	var matched []configuration.TypedTarget
+	for _, target := range targets {
+		if streams := paClient.MatchTarget(&target); len(streams) > 0 {
+			matches[target] = streams
+			matched = append(matched, target)
+		}
+	}
+	if len(matched) == 0 {
+		fmt.Fprintf(os.Stderr, "No %s matches %q\n", selector.Type, selector.Name)
+	}
+	return matches, matched
 }
 
-// triggerStartupVolumeActions processes all slider/knob assignments at startup
-// This triggers migration logic and syncs volumes to control positions
-func triggerStartupVolumeActions(paClient *pulseaudio.PAClient, configManager *configuration.ConfigManager) {
-	config := *configManager.GetConfig()
-	log.Info().Msg("Processing startup volume actions for migration and sync")
+func setVolumeCommand(paClient *pulseaudio.PAClient, selector pulseaudio.Selector, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "pulsekontrol set-volume: expected a name and a volume")
+		return 1
+	}
+	volume, err := strconv.Atoi(strings.TrimSuffix(args[0], "%"))
+	if err != nil || volume < 0 || volume > 100 {
+		fmt.Fprintf(os.Stderr, "pulsekontrol set-volume: volume %s is not a number from 0 to 100\n", args[0])
+		return 1
+	}
 
-	// Process all sliders
-	for controlID, slider := range config.Controls.Sliders {
-		if len(slider.Sources) > 0 {
-			volumePercent := float32(slider.Value) / 100.0
-			log.Debug().
-				Str("control", controlID).
-				Int("value", slider.Value).
-				Int("sources", len(slider.Sources)).
-				Msg("Processing startup slider")
+	matches, targets := selectStreams(paClient, selector)
+	if len(targets) == 0 {
+		return 1
+	}
+	for _, target := range targets {
+		action := configuration.Action{Type: configuration.SetVolume, Target: &target}
+		if err := paClient.ProcessVolumeAction(action, float32(volume)/100.0); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("Set volume of %s %s to %d%%\n", target.Type, actions.DescribeStreams(matches[target]), volume)
+	}
+	return 0
+}
 
-			for _, source := range slider.Sources {
-				// Check if migration is needed before processing
-				if source.BinaryName == "" {
-					// This is a legacy config - check if we need to migrate
-					matchedStreams, migrationStream := paClient.SmartMatchStreams(source.Type, source.Name)
-					if migrationStream != nil && len(matchedStreams) > 0 {
-						// Perform migration immediately
-						configManager.MigrateSourceBinaryName("slider", controlID, source.Type, source.Name, migrationStream.BinaryName)
-						// Get updated config after migration
-						config = *configManager.GetConfig()
-						log.Info().Str("control", controlID).Str("source", source.Name).Str("binary", migrationStream.BinaryName).Msg("Performed migration during startup")
-						continue // Skip to next source - the migrated source will be processed in the updated config
-					}
-				}
+// stepVolumeCommand returns a command moving the volume of matching streams
+// up (direction 1) or down (direction -1) by a step, following the first
+// stream of each target
+func stepVolumeCommand(direction int) func(*pulseaudio.PAClient, pulseaudio.Selector, []string) int {
+	return func(paClient *pulseaudio.PAClient, selector pulseaudio.Selector, args []string) int {
+		if len(args) > 1 {
+			fmt.Fprintf(os.Stderr, "pulsekontrol: unexpected arguments %v\n", args[1:])
+			return 1
+		}
+		step := configuration.DefaultStepSize
+		if len(args) == 1 {
+			var err error
+			step, err = parseStep(args[0])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "pulsekontrol: %v\n", err)
+				return 1
+			}
+		}
 
-				action := configuration.Action{
-					Type: configuration.SetVolume,
-					Target: &configuration.TypedTarget{
-						Type:       source.Type,
-						Name:       source.Name,
-						BinaryName: source.BinaryName,
-					},
-				}
-				paClient.ProcessVolumeAction(action, volumePercent)
+		matches, targets := selectStreams(paClient, selector)
+		if len(targets) == 0 {
+			return 1
+		}
+		for _, target := range targets {
+			current, ok := paClient.GetTargetVolume(&target)
+			if !ok {
+				continue
+			}
+			volume := pulseaudio.SteppedVolume(current, direction*step)
+			action := configuration.Action{Type: configuration.SetVolume, Target: &target}
+			if err := paClient.ProcessVolumeAction(action, float32(volume)/100.0); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
 			}
+			fmt.Printf("Set volume of %s %s to %d%%\n", target.Type, actions.DescribeStreams(matches[target]), volume)
 		}
+		return 0
 	}
+}
 
-	// Process all knobs
-	for controlID, knob := range config.Controls.Knobs {
-		if len(knob.Sources) > 0 {
-			volumePercent := float32(knob.Value) / 100.0
-			log.Debug().
-				Str("control", controlID).
-				Int("value", knob.Value).
-				Int("sources", len(knob.Sources)).
-				Msg("Processing startup knob")
+// parseStep parses the step of volume-up and volume-down, in percent
+func parseStep(arg string) (int, error) {
+	step, err := strconv.Atoi(strings.TrimSuffix(arg, "%"))
+	if err != nil || step < 1 || step > configuration.MaxStepSize {
+		return 0, fmt.Errorf("step %s is not a number from 1 to %d", arg, configuration.MaxStepSize)
+	}
+	return step, nil
+}
 
-			for _, source := range knob.Sources {
-				// Check if migration is needed before processing
-				if source.BinaryName == "" {
-					// This is a legacy config - check if we need to migrate
-					matchedStreams, migrationStream := paClient.SmartMatchStreams(source.Type, source.Name)
-					if migrationStream != nil && len(matchedStreams) > 0 {
-						// Perform migration immediately
-						configManager.MigrateSourceBinaryName("knob", controlID, source.Type, source.Name, migrationStream.BinaryName)
-						// Get updated config after migration
-						config = *configManager.GetConfig()
-						log.Info().Str("control", controlID).Str("source", source.Name).Str("binary", migrationStream.BinaryName).Msg("Performed migration during startup")
-						continue // Skip to next source - the migrated source will be processed in the updated config
-					}
+// muteCommand returns a command setting the mute state of matching streams
+// to what mute returns for their current state
+func muteCommand(mute func(muted bool) bool) func(*pulseaudio.PAClient, pulseaudio.Selector, []string) int {
+	return func(paClient *pulseaudio.PAClient, selector pulseaudio.Selector, args []string) int {
+		if len(args) != 0 {
+			fmt.Fprintf(os.Stderr, "pulsekontrol: unexpected arguments %v\n", args)
+			return 1
+		}
+		matches, targets := selectStreams(paClient, selector)
+		if len(targets) == 0 {
+			return 1
+		}
+		for _, target := range targets {
+			// Streams of one target are muted together, following the first
+			muted := mute(matches[target][0].Muted())
+			if err := paClient.SetTargetMute(&target, muted); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			verb := "Unmuted"
+			if muted {
+				verb = "Muted"
+			}
+			fmt.Printf("%s %s %s\n", verb, target.Type, actions.DescribeStreams(matches[target]))
+		}
+		return 0
+	}
+}
+
+// setDefaultCommand returns a command making the single matching device the default
+func setDefaultCommand(actionType configuration.PulseAudioActionType) func(*pulseaudio.PAClient, pulseaudio.Selector, []string) int {
+	return func(paClient *pulseaudio.PAClient, selector pulseaudio.Selector, args []string) int {
+		if len(args) != 0 {
+			fmt.Fprintf(os.Stderr, "pulsekontrol: unexpected arguments %v\n", args)
+			return 1
+		}
+		matches, targets := selectStreams(paClient, selector)
+		if len(targets) == 0 {
+			return 1
+		}
+		var devices []pulseaudio.Stream
+		for _, target := range targets {
+			devices = append(devices, matches[target]...)
+		}
+		if len(devices) > 1 {
+			fmt.Fprintf(os.Stderr, "%q matches more than one device: %s\n", selector.Name, actions.DescribeStreams(devices))
+			return 1
+		}
+
+		action := configuration.Action{Type: actionType, Target: &configuration.Target{Name: devices[0].Name}}
+		var err error
+		if actionType == configuration.SetDefaultInput {
+			err = paClient.SetDefaultInput(action)
+		} else {
+			err = paClient.SetDefaultOutput(action)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("Default %s is now %s\n", selector.Type, devices[0].Name)
+		return 0
+	}
+}
+
+func meterCommand(paClient *pulseaudio.PAClient, selector pulseaudio.Selector, args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintf(os.Stderr, "pulsekontrol meter: unexpected arguments %v\n", args)
+		return 1
+	}
+	matches, targets := selectStreams(paClient, selector)
+	if len(targets) == 0 {
+		return 1
+	}
+	if len(targets) > 1 || len(matches[targets[0]]) > 1 {
+		var streams []pulseaudio.Stream
+		for _, target := range targets {
+			streams = append(streams, matches[target]...)
+		}
+		fmt.Fprintf(os.Stderr, "%q matches more than one %s: %s\n", selector.Name, selector.Type, actions.DescribeStreams(streams))
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	encoder := json.NewEncoder(os.Stdout)
+	stopMeter, err := paClient.Meter(&targets[0], func(level pulseaudio.Level) {
+		if encoder.Encode(level) != nil {
+			stop()
+		}
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer stopMeter()
+	<-ctx.Done()
+	return 0
+}
+
+// assignmentCommand lists, assigns or unassigns the sources of sliders and
+// knobs in the configuration file, or through the assignments API of a
+// running instance with --remote
+func assignmentCommand(name string, args []string) int {
+	opt := getoptions.New()
+	switch name {
+	case "assign":
+		opt.Self("pulsekontrol assign", "Assign a source to a slider or knob")
+		opt.HelpSynopsisArg("<control>", "Slider or knob, like slider3")
+		opt.HelpSynopsisArg("<name>", "Name or alias of the source, instead of --name")
+	case "unassign":
+		opt.Self("pulsekontrol unassign", "Remove a source from a slider or knob")
+		opt.HelpSynopsisArg("<control>", "Slider or knob, like slider3")
+		opt.HelpSynopsisArg("<name>", "Name or alias of the source, instead of --name")
+		opt.Bool("all", false, opt.Description("Remove all sources of the control"))
+	default:
+		opt.Self("pulsekontrol list-assignments", "List the sources of the sliders and knobs")
+		opt.HelpSynopsisArg("<control>", "Only list this slider or knob")
+	}
+	opt.HelpCommand("help", opt.Alias("h"), opt.Description("Show this help"))
+	configPath := opt.String("config", "", opt.ArgName("file"), opt.Description("Configuration file to use instead of the searched locations"))
+	remote := opt.StringOptional("remote", "", opt.ArgName("address"), opt.Description("Go through the running instance at address:port, defaults to webUI.address"))
+	opt.Bool("json", false, opt.Description("Print JSON instead of a table"))
+	typeName := opt.String("type", string(configuration.PlaybackStream), opt.ArgName("type"), opt.Description("PlaybackStream, RecordStream, OutputDevice or InputDevice"))
+	sourceName := opt.String("name", "", opt.ArgName("name"), opt.Description("Name or alias of the source"))
+	binaryName := opt.String("binary", "", opt.ArgName("binary"), opt.Description("Program binary of the stream"))
+	rest, err := opt.Parse(args)
+	if opt.Called("help") {
+		fmt.Fprint(os.Stderr, opt.Help())
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if opt.Called("config") {
+		configuration.SetPath(*configPath)
+	}
+	// Only problems are of interest on the command line
+	applyLogging(configuration.LoggingConfig{}, logFlags{level: "warn"})
+
+	request := configuration.AssignmentRequest{Name: *sourceName, BinaryName: *binaryName, All: opt.Called("all")}
+	if len(rest) > 0 {
+		request.Control, rest = rest[0], rest[1:]
+	}
+	if name != "list-assignments" {
+		targetType, ok := configuration.ParseTargetType(*typeName)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "pulsekontrol %s: invalid --type %s\n", name, *typeName)
+			return 1
+		}
+		request.Type = targetType
+		if request.Name == "" && len(rest) > 0 {
+			request.Name, rest = rest[0], rest[1:]
+		}
+		if request.Control == "" || (request.Name == "" && !request.All) {
+			fmt.Fprintf(os.Stderr, "pulsekontrol %s: expected a control and a source name\n", name)
+			return 1
+		}
+	}
+	if len(rest) > 0 {
+		fmt.Fprintf(os.Stderr, "pulsekontrol %s: unexpected arguments %v\n", name, rest)
+		return 1
+	}
+
+	if opt.Called("remote") {
+		return remoteAssignmentCommand(name, *remote, request, opt.Called("json"))
+	}
+	if name == "list-assignments" {
+		result, err := configuration.Inspect()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		controls := result.Config.Assignments()
+		if request.Control != "" {
+			assignment, ok := result.Config.Assignment(request.Control)
+			if !ok {
+				fmt.Fprintf(os.Stderr, "No slider or knob %s in %s\n", request.Control, result.Path)
+				return 1
+			}
+			controls = []configuration.ControlAssignment{assignment}
+		}
+		return printAssignments(controls, opt.Called("json"))
+	}
+
+	// Saving while an instance runs would be overwritten by it
+	path, err := configuration.Path()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	lock, err := configuration.Lock(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		var lockErr *configuration.LockError
+		if errors.As(err, &lockErr) {
+			fmt.Fprintln(os.Stderr, "Use --remote to change it through the running instance")
+		}
+		return 1
+	}
+	defer lock.Unlock()
+	config, path, err := configuration.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if !*config.Persistence.Enabled {
+		fmt.Fprintf(os.Stderr, "Persistence is disabled in %s, the change would not be saved\n", path)
+		return 1
+	}
+
+	configManager := configuration.NewConfigManager(config, path)
+	var change configuration.AssignmentChange
+	if name == "assign" {
+		change, err = configManager.Assign(request)
+	} else {
+		change, err = configManager.Unassign(request)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := configManager.Flush(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return printAssignmentChange(name, request, change, opt.Called("json"))
+}
+
+// remoteAssignmentCommand runs an assignment command through the assignments
+// API of the instance at addr, or at the address of the configuration
+func remoteAssignmentCommand(name string, addr string, request configuration.AssignmentRequest, jsonOutput bool) int {
+	result, err := configuration.Inspect()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if addr == "" {
+		addr = result.Config.WebUI.Address
+	}
+	// A listen address without host is reachable on the loopback interface
+	if strings.HasPrefix(addr, ":") {
+		addr = "127.0.0.1" + addr
+	}
+
+	endpoint := url.URL{Scheme: "http", Host: addr, Path: "/api/assignments"}
+	method := http.MethodGet
+	var body io.Reader
+	switch name {
+	case "assign", "unassign":
+		method = http.MethodPost
+		if name == "unassign" {
+			method = http.MethodDelete
+		}
+		data, err := json.Marshal(request)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		body = bytes.NewReader(data)
+	default:
+		if request.Control != "" {
+			endpoint.RawQuery = url.Values{"control": {request.Control}}.Encode()
+		}
+	}
+	httpRequest, err := http.NewRequest(method, endpoint.String(), body)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if token := result.Config.WebUI.AuthToken; token != "" {
+		httpRequest.Header.Set("Authorization", "Bearer "+token)
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	response, err := client.Do(httpRequest)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot reach pulsekontrol at %s: %s\n", addr, err)
+		return 1
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(response.Body)
+		fmt.Fprintf(os.Stderr, "pulsekontrol at %s: %s\n", addr, strings.TrimSpace(string(message)))
+		return 1
+	}
+
+	if name == "list-assignments" {
+		var list struct {
+			Controls []configuration.ControlAssignment `json:"controls"`
+		}
+		if err := json.NewDecoder(response.Body).Decode(&list); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid response from %s: %s\n", addr, err)
+			return 1
+		}
+		return printAssignments(list.Controls, jsonOutput)
+	}
+	var change configuration.AssignmentChange
+	if err := json.NewDecoder(response.Body).Decode(&change); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid response from %s: %s\n", addr, err)
+		return 1
+	}
+	return printAssignmentChange(name, request, change, jsonOutput)
+}
+
+// printAssignments prints the sources of controls as a table or as JSON
+func printAssignments(controls []configuration.ControlAssignment, jsonOutput bool) int {
+	if controls == nil {
+		controls = []configuration.ControlAssignment{}
+	}
+	if jsonOutput {
+		return printJSON(map[string]interface{}{"controls": controls})
+	}
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "CONTROL\tLABEL\tVALUE\tMUTED\tSOURCES")
+	for _, control := range controls {
+		sources := make([]string, len(control.Sources))
+		for i, source := range control.Sources {
+			sources[i] = actions.DescribeSource(configuration.Source{Type: source.Type, Name: source.Name, BinaryName: source.BinaryName, ProcessID: source.ProcessID, Cgroup: source.Cgroup})
+		}
+		muted := ""
+		if control.Muted {
+			muted = "yes"
+		}
+		fmt.Fprintf(table, "%s\t%s\t%d\t%s\t%s\n", control.ControlID, control.Label, control.Value, muted, strings.Join(sources, ", "))
+	}
+	table.Flush()
+	return 0
+}
+
+// printAssignmentChange prints what assign or unassign did and the resulting
+// control. Unassigning nothing fails.
+func printAssignmentChange(name string, request configuration.AssignmentRequest, change configuration.AssignmentChange, jsonOutput bool) int {
+	if name == "unassign" && len(change.Removed) == 0 {
+		fmt.Fprintf(os.Stderr, "%s has no source %s\n", request.Control, request.Name)
+		return 1
+	}
+	if jsonOutput {
+		return printJSON(change)
+	}
+	if change.Policy == configuration.DuplicateMove {
+		fmt.Printf("Moved %s from %s\n", request.Name, strings.Join(change.Conflicts, ", "))
+	} else if change.Policy != "" {
+		fmt.Printf("%s is also assigned to %s\n", request.Name, strings.Join(change.Conflicts, ", "))
+	}
+	return printAssignments([]configuration.ControlAssignment{change.Control}, false)
+}
+
+func printJSON(value interface{}) int {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// ctlCommand sends a command to the control socket of the running instance
+func ctlCommand(args []string) int {
+	opt := getoptions.New()
+	opt.Self("pulsekontrol ctl", "Control the running pulsekontrol through its control socket")
+	opt.HelpSynopsisArg("<command>", commandUsage)
+	opt.HelpCommand("help", opt.Alias("h"), opt.Description("Show this help"))
+	socketPath := opt.String("socket", control.SocketPath(), opt.ArgName("path"), opt.Description("Control socket of the running instance"))
+	options := addCommandOptions(opt)
+	rest, err := opt.Parse(args)
+	if opt.Called("help") {
+		fmt.Fprint(os.Stderr, opt.Help())
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(rest) == 0 {
+		fmt.Fprint(os.Stderr, opt.Help())
+		return 1
+	}
+	request, err := commandRequest("pulsekontrol ctl", opt, options, rest)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	reply, err := control.Send(*socketPath, request)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return printReply("pulsekontrol ctl", request, reply)
+}
+
+// remoteCommand runs a command of ctl on another pulsekontrol through its
+// web interface
+func remoteCommand(args []string) int {
+	opt := getoptions.New()
+	opt.Self("pulsekontrol remote", "Control a pulsekontrol on another machine through its web interface")
+	opt.HelpSynopsisArg("<command>", commandUsage)
+	opt.HelpCommand("help", opt.Alias("h"), opt.Description("Show this help"))
+	defaultHost := os.Getenv("PULSEKONTROL_HOST")
+	if defaultHost == "" {
+		defaultHost = configuration.DefaultWebUIAddress
+	}
+	host := opt.String("host", defaultHost, opt.ArgName("address"), opt.Description("host:port of the web interface, or its URL behind a proxy, defaults to $PULSEKONTROL_HOST"))
+	token := opt.String("token", os.Getenv("PULSEKONTROL_TOKEN"), opt.ArgName("token"), opt.Description("Auth token of the web interface, defaults to $PULSEKONTROL_TOKEN"))
+	opt.Bool("tls", false, opt.Description("Connect with https, like an https:// address"))
+	opt.Bool("insecure", false, opt.Description("Don't verify the TLS certificate"))
+	caFile := opt.String("ca", "", opt.ArgName("file"), opt.Description("Verify the TLS certificate with the CA certificates in this PEM file"))
+	options := addCommandOptions(opt)
+	rest, err := opt.Parse(args)
+	if opt.Called("help") {
+		fmt.Fprint(os.Stderr, opt.Help())
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(rest) == 0 {
+		fmt.Fprint(os.Stderr, opt.Help())
+		return 1
+	}
+	request, err := commandRequest("pulsekontrol remote", opt, options, rest)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	client, err := webui.Dial(*host, webui.ClientOptions{Token: *token, TLS: opt.Called("tls"), Insecure: opt.Called("insecure"), CAFile: *caFile})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer client.Close()
+	reply, err := client.Send(request)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return printReply("pulsekontrol remote", request, reply)
+}
+
+// commandUsage lists the commands of ctl and remote
+const commandUsage = "set-control <type> <id> <value>, toggle-mute <control or type:name>, switch-profile <name>, recall-scene <name>, get-state, " +
+	"or like the one-shot commands set-volume <name> <volume>, volume-up <name> [<step>], volume-down <name> [<step>], mute <name>, unmute <name>, toggle-mute <name> with --type, --binary, --glob or --regex, set-default-sink <name> and set-default-source <name>"
+
+// commandOptions are the options of the commands of ctl and remote
+type commandOptions struct {
+	rampMs     *int
+	typeName   *string
+	binaryName *string
+}
+
+func addCommandOptions(opt *getoptions.GetOpt) commandOptions {
+	options := commandOptions{
+		rampMs:     opt.Int("ramp", 0, opt.ArgName("ms"), opt.Description("With recall-scene, fade to the scene over this many milliseconds")),
+		typeName:   opt.String("type", "", opt.ArgName("type"), opt.Description("With a stream or device, PlaybackStream, RecordStream, OutputDevice or InputDevice, defaults to PlaybackStream")),
+		binaryName: opt.String("binary", "", opt.ArgName("binary"), opt.Description("With a stream, only match streams of this program binary")),
+	}
+	opt.Bool("glob", false, opt.Description("With a stream or device, match the name as a shell pattern, like Fire*"))
+	opt.Bool("regex", false, opt.Description("With a stream or device, match the name as a regular expression"))
+	return options
+}
+
+// commandRequest returns the request of a command of ctl or remote, the
+// command and its arguments in args
+func commandRequest(name string, opt *getoptions.GetOpt, options commandOptions, args []string) (control.Request, error) {
+	request := control.Request{Command: args[0]}
+	args = args[1:]
+	expected := map[string]int{
+		control.SetControl:       3,
+		control.ToggleMute:       1,
+		control.SwitchProfile:    1,
+		control.RecallScene:      1,
+		control.GetState:         0,
+		control.SetVolume:        2,
+		control.VolumeUp:         1,
+		control.VolumeDown:       1,
+		control.Mute:             1,
+		control.Unmute:           1,
+		control.SetDefaultSink:   1,
+		control.SetDefaultSource: 1,
+	}
+	count, ok := expected[request.Command]
+	if !ok {
+		return request, fmt.Errorf("%s: unknown command %s", name, request.Command)
+	}
+	// The step of volume-up and volume-down is optional
+	stepped := request.Command == control.VolumeUp || request.Command == control.VolumeDown
+	if len(args) != count && !(stepped && len(args) == count+1) {
+		return request, fmt.Errorf("%s %s: expected %d arguments", name, request.Command, count)
+	}
+	if opt.Called("glob") && opt.Called("regex") {
+		return request, fmt.Errorf("%s %s: use either --glob or --regex", name, request.Command)
+	}
+	source := &control.Source{Type: *options.typeName, Binary: *options.binaryName}
+	if len(args) > 0 {
+		source.Name = args[0]
+	}
+	if opt.Called("glob") {
+		source.Match = string(pulseaudio.MatchGlob)
+	} else if opt.Called("regex") {
+		source.Match = string(pulseaudio.MatchRegex)
+	}
+
+	switch request.Command {
+	case control.SetControl:
+		value, err := strconv.Atoi(args[2])
+		if err != nil {
+			return request, fmt.Errorf("%s %s: invalid value %s", name, request.Command, args[2])
+		}
+		request.Type, request.Id, request.Value = args[0], args[1], value
+	case control.ToggleMute:
+		// A control or <type>:<name>, unless the source is described by options
+		if opt.Called("type") || opt.Called("binary") || source.Match != "" {
+			request.Source = source
+		} else {
+			request.Selector = args[0]
+		}
+	case control.SwitchProfile:
+		request.Name = args[0]
+	case control.RecallScene:
+		request.Name, request.RampMs = args[0], *options.rampMs
+	case control.SetVolume:
+		volume, err := strconv.Atoi(strings.TrimSuffix(args[1], "%"))
+		if err != nil {
+			return request, fmt.Errorf("%s %s: volume %s is not a number from 0 to 100", name, request.Command, args[1])
+		}
+		request.Source, request.Value = source, volume
+	case control.VolumeUp, control.VolumeDown:
+		request.Source = source
+		if len(args) == 2 {
+			step, err := parseStep(args[1])
+			if err != nil {
+				return request, fmt.Errorf("%s %s: %w", name, request.Command, err)
+			}
+			request.Value = step
+		}
+	case control.Mute, control.Unmute, control.SetDefaultSink, control.SetDefaultSource:
+		request.Source = source
+	}
+	return request, nil
+}
+
+// printReply prints the reply to a request of ctl or remote, or its error
+func printReply(name string, request control.Request, reply control.Reply) int {
+	for _, change := range reply.Changes {
+		fmt.Println(change)
+	}
+	if !reply.Ok {
+		fmt.Fprintf(os.Stderr, "%s %s: %s\n", name, request.Command, reply.Error)
+		return 1
+	}
+	switch {
+	case reply.State != nil:
+		return printJSON(reply.State)
+	case reply.Muted != nil && *reply.Muted:
+		fmt.Println("muted")
+	case reply.Muted != nil:
+		fmt.Println("unmuted")
+	}
+	return 0
+}
+
+// monitorCommand prints the events of the running instance, one JSON object
+// per line. With --standalone and no instance running, it watches PulseAudio
+// and the MIDI device itself without changing anything.
+func monitorCommand(args []string) int {
+	opt := getoptions.New()
+	opt.Self("pulsekontrol monitor", "Print the events of the running pulsekontrol as JSON lines")
+	opt.HelpCommand("help", opt.Alias("h"), opt.Description("Show this help"))
+	socketPath := opt.String("socket", control.SocketPath(), opt.ArgName("path"), opt.Description("Control socket of the running instance"))
+	filter := opt.String("filter", "", opt.ArgName("topics"), opt.Description("Comma-separated topics to print, * matches any part like in control.*"))
+	opt.Bool("raw", false, opt.Description("Include unparsed payloads, like the bytes of MIDI messages"))
+	opt.Bool("standalone", false, opt.Description("Without a running pulsekontrol, watch PulseAudio and the MIDI device directly and read-only"))
+	configPath := opt.String("config", "", opt.ArgName("file"), opt.Description("With --standalone, configuration file to use instead of the searched locations"))
+	rest, err := opt.Parse(args)
+	if opt.Called("help") {
+		fmt.Fprint(os.Stderr, opt.Help())
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(rest) > 0 {
+		fmt.Fprintf(os.Stderr, "pulsekontrol monitor: unexpected arguments %v\n", rest)
+		return 1
+	}
+	if opt.Called("config") {
+		configuration.SetPath(*configPath)
+	}
+	request := control.Request{Raw: opt.Called("raw")}
+	for _, topic := range strings.Split(*filter, ",") {
+		if topic = strings.TrimSpace(topic); topic != "" {
+			request.Topics = append(request.Topics, topic)
+		}
+	}
+
+	// A closed pipe fails the write instead of killing the process, so
+	// piping into head or less ends cleanly
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	stream, err := control.OpenMonitor(*socketPath, request)
+	if err != nil {
+		notRunning := errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, os.ErrNotExist)
+		if notRunning && opt.Called("standalone") {
+			return monitorStandalone(ctx, request)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		if notRunning {
+			fmt.Fprintln(os.Stderr, "Use --standalone to watch PulseAudio and the MIDI device without it")
+		}
+		return 1
+	}
+	defer stream.Close()
+	go func() {
+		<-ctx.Done()
+		stream.Close()
+	}()
+	for {
+		line, err := stream.Next()
+		if err != nil {
+			if ctx.Err() != nil {
+				return 0
+			}
+			fmt.Fprintln(os.Stderr, "pulsekontrol monitor: the connection to pulsekontrol was closed")
+			return 1
+		}
+		if _, err := os.Stdout.Write(line); err != nil {
+			return monitorWriteFailed(err)
+		}
+	}
+}
+
+// monitorStandalone prints the events of PulseAudio and the MIDI device
+// until ctx is done. Only the MIDI in port is opened and PulseAudio is only
+// read, so nothing changes.
+func monitorStandalone(ctx context.Context, request control.Request) int {
+	applyLogging(configuration.LoggingConfig{}, logFlags{level: "warn"})
+	result, err := configuration.Inspect()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	configManager := configuration.NewConfigManager(result.Config, result.Path)
+	configManager.SetReadOnly(true)
+	watcher, err := control.NewWatcher(configManager, request)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pulsekontrol monitor: %v\n", err)
+		return 1
+	}
+	defer watcher.Stop()
+
+	sources := 0
+	if paClient, err := pulseaudio.ConnectBackend(result.Config.PulseAudio.Backend); err != nil {
+		fmt.Fprintf(os.Stderr, "pulsekontrol monitor: cannot connect to the sound server: %v\n", err)
+	} else if paClient.SetEventHandler(configManager.Notify); paClient.StartStreamMonitoring() != nil {
+		fmt.Fprintln(os.Stderr, "pulsekontrol monitor: cannot watch PulseAudio streams")
+	} else {
+		watchPulseAudio(ctx, paClient, configManager, clock.System)
+		sources++
+	}
+	device := result.Config.PrimaryDevice()
+	midiDone := make(chan error, 1)
+	go func() {
+		midiDone <- midi.Listen(ctx, device, configManager)
+	}()
+	sources++
+
+	encoder := json.NewEncoder(os.Stdout)
+	for {
+		select {
+		case <-ctx.Done():
+			return 0
+		case err := <-midiDone:
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "pulsekontrol monitor: device %s: %v\n", device.Name, err)
+			}
+			midiDone = nil
+			if sources--; sources == 0 {
+				return 1
+			}
+		case event := <-watcher.Events():
+			if err := watcher.Encode(encoder, event); err != nil {
+				return monitorWriteFailed(err)
+			}
+		}
+	}
+}
+
+// monitorWriteFailed returns the exit status after printing failed, which is
+// a success if the reader went away, like head after enough lines
+func monitorWriteFailed(err error) int {
+	if errors.Is(err, syscall.EPIPE) {
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "pulsekontrol monitor: %v\n", err)
+	return 1
+}
+
+// statusbarTopics are the events after which the status bar may change
+var statusbarTopics = []string{
+	"control.value.updated",
+	"control.muted.updated",
+	"control.moved",
+	"default.output.changed",
+	"profile.switched",
+	"config.merged",
+	"history.applied",
+	"alias.updated",
+}
+
+// statusbarRetry is how often the status bar tries to reconnect to pulsekontrol
+const statusbarRetry = 2 * time.Second
+
+// statusbarCommand prints the controls and the default output for a status
+// bar on every change and at the heartbeat interval, until SIGTERM. It keeps
+// running while pulsekontrol is not, and reconnects once it is back.
+func statusbarCommand(args []string) int {
+	opt := getoptions.New()
+	opt.Self("pulsekontrol statusbar", "Print the controls and the default output of the running pulsekontrol for a status bar")
+	opt.HelpCommand("help", opt.Alias("h"), opt.Description("Show this help"))
+	socketPath := opt.String("socket", control.SocketPath(), opt.ArgName("path"), opt.Description("Control socket of the running instance"))
+	controls := opt.String("controls", "", opt.ArgName("ids"), opt.Description("Comma-separated controls to show, like slider1,knob3, all without"))
+	format := opt.String("format", statusbar.Waybar, opt.ArgName("format"), opt.Description("Output format: waybar, i3bar or text for i3blocks"))
+	interval := opt.Int("interval", 10, opt.ArgName("seconds"), opt.Description("Print the status at least this often"))
+	rest, err := opt.Parse(args)
+	if opt.Called("help") {
+		fmt.Fprint(os.Stderr, opt.Help())
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(rest) > 0 {
+		fmt.Fprintf(os.Stderr, "pulsekontrol statusbar: unexpected arguments %v\n", rest)
+		return 1
+	}
+	if *interval <= 0 {
+		fmt.Fprintln(os.Stderr, "pulsekontrol statusbar: the interval must be positive")
+		return 1
+	}
+	writer, err := statusbar.NewWriter(os.Stdout, *format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pulsekontrol statusbar: %v\n", err)
+		return 1
+	}
+	var ids []string
+	for _, id := range strings.Split(*controls, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	heartbeat := time.NewTicker(time.Duration(*interval) * time.Second)
+	defer heartbeat.Stop()
+
+	shownDisconnected := false
+	for {
+		connected, err := statusbarSession(ctx, *socketPath, ids, writer, heartbeat.C)
+		if ctx.Err() != nil {
+			return 0
+		}
+		if err != nil {
+			return statusbarWriteFailed(err)
+		}
+		// pulsekontrol is not running, show it once and look for it again
+		if connected || !shownDisconnected {
+			if err := writer.Write(statusbar.Status{}); err != nil {
+				return statusbarWriteFailed(err)
+			}
+			shownDisconnected = true
+		}
+		select {
+		case <-ctx.Done():
+			return 0
+		case <-time.After(statusbarRetry):
+		}
+	}
+}
+
+// statusbarSession prints the status while connected to pulsekontrol, until
+// the connection is lost. It returns whether it connected and the error of
+// failed printing.
+func statusbarSession(ctx context.Context, socketPath string, ids []string, writer *statusbar.Writer, heartbeat <-chan time.Time) (bool, error) {
+	// Watch before reading the state, so no change is missed in between
+	stream, err := control.OpenMonitor(socketPath, control.Request{Topics: statusbarTopics})
+	if err != nil {
+		return false, nil
+	}
+	defer stream.Close()
+	events := make(chan struct{}, 1)
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, err := stream.Next(); err != nil {
+				return
+			}
+			select {
+			case events <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	for {
+		// Events that arrived until now are covered by the state read next
+		select {
+		case <-events:
+		default:
+		}
+		reply, err := control.Send(socketPath, control.Request{Command: control.GetState})
+		if err != nil || !reply.Ok || reply.State == nil {
+			return true, nil
+		}
+		if err := writer.Write(statusbar.FromState(*reply.State, ids)); err != nil {
+			return true, err
+		}
+		select {
+		case <-ctx.Done():
+			return true, nil
+		case <-closed:
+			return true, nil
+		case <-events:
+		case <-heartbeat:
+		}
+	}
+}
+
+// statusbarWriteFailed returns the exit status after printing failed, a
+// success if the bar went away
+func statusbarWriteFailed(err error) int {
+	if errors.Is(err, syscall.EPIPE) {
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "pulsekontrol statusbar: %v\n", err)
+	return 1
+}
+
+// setupStreamMonitoring applies the volumes of the assigned controls to new
+// streams and keeps the LEDs and the web interface, which may be nil, up to date
+func setupStreamMonitoring(paClient pulseaudio.Backend, configManager *configuration.ConfigManager, midiClient *midi.MidiClient, executor *actions.Executor, webServer *webui.WebUIServer) {
+	// Set up callback for new streams - re-trigger volume actions and update LEDs
+	paClient.SetNewStreamCallback(func(stream pulseaudio.Stream, streamType configuration.PulseAudioTargetType) {
+		log.Info().
+			Str("streamName", stream.Name).
+			Str("streamBinary", stream.BinaryName).
+			Str("streamType", string(streamType)).
+			Msg("New stream detected, re-applying all volume settings and updating LEDs")
+
+		var controls []string
+		if configManager.GetConfigSnapshot().StartupSync == configuration.StartupSyncPush {
+			// Re-trigger the startup volume actions - this uses the exact same code path as startup
+			triggerStartupVolumeActions(paClient, configManager, executor)
+			controls = streamControls(stream, streamType, configManager.GetConfigSnapshot())
+		} else {
+			// Volumes weren't pushed at startup, so only touch the controls of the new stream
+			migrateLegacySources(paClient, configManager)
+			controls = applyNewStreamVolumes(stream, streamType, configManager, executor)
+		}
+
+		if webServer != nil {
+			webServer.NotifySourceAdded(streamType, stream.Name, stream.BinaryName, controls)
+		}
+
+		// Update LED indicators to reflect current active streams
+		if err := midiClient.UpdateLEDIndicators(); err != nil {
+			log.Error().Err(err).Msg("Failed to update LED indicators after new stream detected")
+		}
+	})
+
+	// Set up callback for removed streams - update LEDs when streams disappear
+	paClient.SetRemovedStreamCallback(func(stream pulseaudio.Stream, streamType configuration.PulseAudioTargetType) {
+		log.Info().
+			Str("streamID", stream.FullName).
+			Str("streamType", string(streamType)).
+			Msg("Stream removed, updating LEDs")
+
+		// Update LED indicators to reflect current active streams (removed streams won't be found)
+		if err := midiClient.UpdateLEDIndicators(); err != nil {
+			log.Error().Err(err).Msg("Failed to update LED indicators after stream removed")
+		}
+	})
+
+	// Set up callback for media status changes - update play button LED
+	paClient.SetMediaStatusCallback(func(isPlaying bool) {
+		log.Info().
+			Bool("isPlaying", isPlaying).
+			Msg("Media status changed, updating play button LED")
+
+		// Update only the play button LED
+		if err := midiClient.UpdatePlayButtonLED(isPlaying); err != nil {
+			log.Error().Err(err).Msg("Failed to update play button LED after media status change")
+		}
+	})
+
+	// Without stream events, new streams get their volume when a control moves
+	// and the web interface still finds them when polling
+	if err := paClient.StartStreamMonitoring(); err != nil {
+		log.Warn().Err(err).Msg("Failed to start stream monitoring, new applications get their volume only when a control moves")
+	} else {
+		log.Info().Msg("Stream monitoring enabled - new applications will automatically have volumes applied and LEDs updated")
+	}
+
+	// Start media status monitoring
+	if err := paClient.StartMediaStatusMonitoring(); err != nil {
+		log.Warn().Err(err).Msg("Failed to start media status monitoring")
+	}
+}
+
+// migrateLegacySources adds the binary name to sources assigned before it
+// was recorded, once a matching stream shows what it is
+func migrateLegacySources(paClient pulseaudio.Backend, configManager *configuration.ConfigManager) {
+	config := configManager.GetConfigSnapshot()
+	migrate := func(controlType string, controlID string, sources []configuration.Source) {
+		for _, source := range sources {
+			if source.BinaryName != "" {
+				continue
+			}
+			matchedStreams, migrationStream := paClient.SmartMatchStreams(source.Type, source.Name)
+			if migrationStream != nil && len(matchedStreams) > 0 {
+				configManager.MigrateSourceBinaryName(controlType, controlID, source.Type, source.Name, migrationStream.BinaryName)
+				log.Info().Str("control", controlID).Str("source", source.Name).Str("binary", migrationStream.BinaryName).Msg("Performed migration during startup")
+			}
+		}
+	}
+	for controlID, slider := range config.Controls.Sliders {
+		migrate("slider", controlID, slider.Sources)
+	}
+	for controlID, knob := range config.Controls.Knobs {
+		migrate("knob", controlID, knob.Sources)
+	}
+}
+
+// syncStartupVolumes brings control values and volumes in line when
+// pulsekontrol starts, in the direction chosen by startupSync
+func syncStartupVolumes(paClient pulseaudio.Backend, configManager *configuration.ConfigManager, executor *actions.Executor) {
+	config := configManager.GetConfigSnapshot()
+	switch config.StartupSync {
+	case configuration.StartupSyncAdopt:
+		adoptVolumes(paClient, configManager, executor)
+	case configuration.StartupSyncOff:
+		migrateLegacySources(paClient, configManager)
+		log.Info().Msg("Startup volume sync is off, leaving volumes and control values alone")
+	default:
+		triggerStartupVolumeActions(paClient, configManager, executor)
+	}
+}
+
+// adoptVolumes stores the current volumes of the assigned sources as the
+// values of their controls, the adopt mode of startupSync
+func adoptVolumes(paClient pulseaudio.Backend, configManager *configuration.ConfigManager, executor *actions.Executor) {
+	migrateLegacySources(paClient, configManager)
+	adopted := executor.AdoptVolumes(activity.Startup())
+	log.Info().Int("controls", adopted).Msg("Adopted current volumes as control values")
+}
+
+// streamControls returns the ids of the sliders and knobs with a source the
+// stream belongs to, matched like SmartMatchStreams
+func streamControls(stream pulseaudio.Stream, streamType configuration.PulseAudioTargetType, config configuration.Config) []string {
+	matches := func(sources []configuration.Source) bool {
+		for _, source := range sources {
+			if source.Type == streamType && stream.MatchesSource(source.Name, source.BinaryName) && stream.MatchesProcess(source.ProcessID, source.Cgroup) {
+				return true
+			}
+		}
+		return false
+	}
+	var controls []string
+	for controlID, slider := range config.Controls.Sliders {
+		if matches(config.SliderSources(slider)) {
+			controls = append(controls, controlID)
+		}
+	}
+	for controlID, knob := range config.Controls.Knobs {
+		if matches(config.KnobSources(knob)) {
+			controls = append(controls, controlID)
+		}
+	}
+	slices.Sort(controls)
+	return controls
+}
+
+// applyNewStreamVolumes sets a new stream to the values of the controls it
+// is assigned to and returns their ids
+func applyNewStreamVolumes(stream pulseaudio.Stream, streamType configuration.PulseAudioTargetType, configManager *configuration.ConfigManager, executor *actions.Executor) []string {
+	config := configManager.GetConfigSnapshot()
+	controls := streamControls(stream, streamType, config)
+	for _, controlID := range controls {
+		if slider, ok := config.Controls.Sliders[controlID]; ok {
+			executor.ApplyControlVolumes("slider", controlID, slider.Value)
+		} else {
+			executor.ApplyControlVolumes("knob", controlID, config.Controls.Knobs[controlID].Value)
+		}
+	}
+	return controls
+}
+
+// triggerStartupVolumeActions processes all slider/knob assignments at startup
+// This triggers migration logic and syncs volumes to control positions
+func triggerStartupVolumeActions(paClient pulseaudio.Backend, configManager *configuration.ConfigManager, executor *actions.Executor) {
+	migrateLegacySources(paClient, configManager)
+	log.Info().Msg("Processing startup volume actions for migration and sync")
+	applyControlValues(paClient, configManager, executor)
+}
+
+// applyControlValues sets all assigned sources to the values of their
+// controls and mutes the sources of muted controls
+func applyControlValues(paClient pulseaudio.Backend, configManager *configuration.ConfigManager, executor *actions.Executor) {
+	config := configManager.GetConfigSnapshot()
+
+	// Process all sliders
+	for controlID, slider := range config.Controls.Sliders {
+		sources := config.SliderSources(slider)
+		if len(sources) > 0 {
+			log.Debug().
+				Str("control", controlID).
+				Int("value", slider.Value).
+				Int("sources", len(sources)).
+				Msg("Processing startup slider")
+			executor.Record(activity.Startup(), "SetControlValue", controlID, slider.Value)
+
+			for _, source := range sources {
+				executor.ApplySourceValue("slider", controlID, source, slider.Value)
+
+				// Re-mute what was muted before the restart
+				if slider.Muted {
+					target := source.Target()
+					if err := paClient.SetTargetMute(target, true); err != nil {
+						log.Error().Err(err).Str("control", controlID).Msg("Failed to restore mute")
+					}
 				}
+			}
+		}
+	}
 
-				action := configuration.Action{
-					Type: configuration.SetVolume,
-					Target: &configuration.TypedTarget{
-						Type:       source.Type,
-						Name:       source.Name,
-						BinaryName: source.BinaryName,
-					},
+	// Process all knobs
+	for controlID, knob := range config.Controls.Knobs {
+		sources := config.KnobSources(knob)
+		if len(sources) > 0 {
+			log.Debug().
+				Str("control", controlID).
+				Int("value", knob.Value).
+				Int("sources", len(sources)).
+				Msg("Processing startup knob")
+			executor.Record(activity.Startup(), "SetControlValue", controlID, knob.Value)
+
+			for _, source := range sources {
+				executor.ApplySourceValue("knob", controlID, source, knob.Value)
+
+				// Re-mute what was muted before the restart
+				if knob.Muted {
+					target := source.Target()
+					if err := paClient.SetTargetMute(target, true); err != nil {
+						log.Error().Err(err).Str("control", controlID).Msg("Failed to restore mute")
+					}
 				}
-				paClient.ProcessVolumeAction(action, volumePercent)
 			}
 		}
 	}
@@ -0,0 +1,143 @@
+package pulsekontrol
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/activity"
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/0h41/pulsekontrol/src/control"
+	"github.com/0h41/pulsekontrol/src/internal/testutil"
+	"github.com/0h41/pulsekontrol/src/statusbar"
+)
+
+func TestRulesFollowControlDevice(t *testing.T) {
+	first, second := uint8(0), uint8(3)
+	firefox := configuration.Source{Type: configuration.PlaybackStream, Name: "Firefox"}
+	spotify := configuration.Source{Type: configuration.PlaybackStream, Name: "Spotify"}
+	config, err := configuration.Prepare(configuration.Config{
+		Devices: []configuration.DeviceConfig{
+			{Name: "left", Type: configuration.Generic, InPort: "left", OutPort: "left", Channel: &first,
+				ControlMap: configuration.ControlMap{Sliders: map[string]configuration.ControlBinding{"Fader1": {Number: 7}}}},
+			{Name: "right", Type: configuration.Generic, InPort: "right", OutPort: "right", Channel: &second,
+				ControlMap: configuration.ControlMap{Sliders: map[string]configuration.ControlBinding{"Fader1": {Number: 8}}}},
+		},
+		Controls: configuration.Controls{
+			Sliders: map[string]configuration.SliderConfig{
+				// Without a device the slider belongs to the first one
+				"browser": {Path: "Fader1", Sources: []configuration.Source{firefox}},
+				"music":   {Device: "right", Path: "Fader1", Sources: []configuration.Source{spotify}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		device     string
+		control    string
+		channel    uint8
+		controller uint8
+		source     string
+	}{
+		{device: "left", control: "browser", channel: 0, controller: 7, source: "Firefox"},
+		{device: "right", control: "music", channel: 3, controller: 8, source: "Spotify"},
+	} {
+		var volumeRules []configuration.Rule
+		for _, rule := range createRulesFromConfig(config, configuration.MidiDevice{Name: test.device, Type: configuration.Generic}) {
+			if rule.ControlType == "slider" {
+				volumeRules = append(volumeRules, rule)
+			}
+		}
+		if len(volumeRules) != 1 {
+			t.Errorf("%s: got slider rules %+v, want one for %s", test.device, volumeRules, test.control)
+			continue
+		}
+		rule := volumeRules[0]
+		if rule.ControlID != test.control || rule.MidiMessage.DeviceName != test.device {
+			t.Errorf("%s: rule of %s for device %s", test.device, rule.ControlID, rule.MidiMessage.DeviceName)
+		}
+		if rule.MidiMessage.Channel != test.channel || rule.MidiMessage.Controller != test.controller {
+			t.Errorf("%s: rule listens to channel %d controller %d, want %d and %d", test.device,
+				rule.MidiMessage.Channel, rule.MidiMessage.Controller, test.channel, test.controller)
+		}
+		if target, ok := rule.Actions[0].Target.(*configuration.TypedTarget); !ok || target.Name != test.source {
+			t.Errorf("%s: rule sets the volume of %#v, want %s", test.device, rule.Actions[0].Target, test.source)
+		}
+	}
+}
+
+// awaitLines waits until the output has a number of lines and returns the last
+func awaitLines(t *testing.T, output *syncWriter, count int) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+		if output.String() != "" && len(lines) >= count {
+			if len(lines) > count {
+				t.Errorf("printed\n%s\nwant %d lines", output, count)
+			}
+			return lines[len(lines)-1]
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("printed\n%s\nwant %d lines", output, count)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStatusbarSession(t *testing.T) {
+	backend := testutil.NewFakeBackend(testutil.FakeStream{Type: configuration.OutputDevice, Name: "Headphones"})
+	backend.SetDefaultOutput(configuration.Action{Type: configuration.SetDefaultOutput, Target: &configuration.Target{Name: "Headphones"}})
+	app := startApp(t, testConfig(), backend, testutil.NewFakeDriver(true))
+	output := &syncWriter{w: &strings.Builder{}}
+	writer, err := statusbar.NewWriter(output, statusbar.Text)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without pulsekontrol the session ends at once
+	if connected, err := statusbarSession(context.Background(), control.SocketPath()+".missing", nil, writer, nil); connected || err != nil {
+		t.Errorf("session without pulsekontrol returned %v, %v", connected, err)
+	}
+
+	heartbeat := make(chan time.Time)
+	type result struct {
+		connected bool
+		err       error
+	}
+	ended := make(chan result, 1)
+	go func() {
+		connected, err := statusbarSession(context.Background(), control.SocketPath(), []string{"slider1"}, writer, heartbeat)
+		ended <- result{connected, err}
+	}()
+	if line := awaitLines(t, output, 1); line != "slider1 80% | Headphones" {
+		t.Errorf("printed %q", line)
+	}
+
+	// Changes are printed, and the status at every heartbeat
+	app.ConfigManager().UpdateControlValue(activity.Web("browser"), "slider", "slider1", 30)
+	if line := awaitLines(t, output, 2); line != "slider1 30% | Headphones" {
+		t.Errorf("printed %q after a change", line)
+	}
+	heartbeat <- time.Now()
+	if line := awaitLines(t, output, 3); line != "slider1 30% | Headphones" {
+		t.Errorf("printed %q at the heartbeat", line)
+	}
+
+	// The session ends with pulsekontrol, for the command to reconnect
+	if err := app.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case result := <-ended:
+		if !result.connected || result.err != nil {
+			t.Errorf("session returned %v, %v", result.connected, result.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("session still running after pulsekontrol stopped")
+	}
+}
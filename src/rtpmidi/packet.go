@@ -0,0 +1,320 @@
+package rtpmidi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Session exchange packets start with 0xffff and a two letter command
+const (
+	commandInvitation = "IN"
+	commandAccept     = "OK"
+	commandReject     = "NO"
+	commandBye        = "BY"
+	commandClockSync  = "CK"
+	commandFeedback   = "RS"
+)
+
+const (
+	protocolVersion = 2
+	// rtpVersion is the version bits of the first RTP header byte
+	rtpVersion = 0x80
+	// payloadType is the dynamic RTP payload type AppleMIDI uses
+	payloadType = 0x61
+	// rtpHeaderSize is the RTP header without CSRCs
+	rtpHeaderSize = 12
+	// maxCommandSection is the largest MIDI list the long header can describe
+	maxCommandSection = 0x0fff
+)
+
+var errTruncated = errors.New("truncated packet")
+
+// isExchange reports whether a packet is a session exchange packet rather
+// than RTP
+func isExchange(packet []byte) bool {
+	return len(packet) >= 4 && packet[0] == 0xff && packet[1] == 0xff
+}
+
+// exchangeCommand returns the command of a session exchange packet
+func exchangeCommand(packet []byte) string {
+	return string(packet[2:4])
+}
+
+// exchange is an invitation, its acceptance or rejection, or a goodbye
+type exchange struct {
+	command string
+	token   uint32 // Initiator token, repeated in the answer
+	ssrc    uint32
+	name    string // Empty for goodbyes
+}
+
+func (e exchange) marshal() []byte {
+	packet := make([]byte, 16, 16+len(e.name)+1)
+	packet[0], packet[1] = 0xff, 0xff
+	copy(packet[2:4], e.command)
+	binary.BigEndian.PutUint32(packet[4:], protocolVersion)
+	binary.BigEndian.PutUint32(packet[8:], e.token)
+	binary.BigEndian.PutUint32(packet[12:], e.ssrc)
+	if e.command != commandBye {
+		packet = append(packet, e.name...)
+		packet = append(packet, 0)
+	}
+	return packet
+}
+
+func parseExchange(packet []byte) (exchange, error) {
+	if !isExchange(packet) || len(packet) < 16 {
+		return exchange{}, errTruncated
+	}
+	if version := binary.BigEndian.Uint32(packet[4:]); version != protocolVersion {
+		return exchange{}, fmt.Errorf("unsupported protocol version %d", version)
+	}
+	e := exchange{
+		command: exchangeCommand(packet),
+		token:   binary.BigEndian.Uint32(packet[8:]),
+		ssrc:    binary.BigEndian.Uint32(packet[12:]),
+	}
+	name := packet[16:]
+	if end := bytes.IndexByte(name, 0); end >= 0 {
+		name = name[:end]
+	}
+	e.name = string(name)
+	return e, nil
+}
+
+// clockSync is one of the three packets of a clock synchronization. Count
+// is the number of timestamps that are set, minus one.
+type clockSync struct {
+	ssrc       uint32
+	count      uint8
+	timestamps [3]uint64 // In units of 100 microseconds
+}
+
+func (c clockSync) marshal() []byte {
+	packet := make([]byte, 36)
+	packet[0], packet[1] = 0xff, 0xff
+	copy(packet[2:4], commandClockSync)
+	binary.BigEndian.PutUint32(packet[4:], c.ssrc)
+	packet[8] = c.count
+	for i, timestamp := range c.timestamps {
+		binary.BigEndian.PutUint64(packet[12+8*i:], timestamp)
+	}
+	return packet
+}
+
+func parseClockSync(packet []byte) (clockSync, error) {
+	if !isExchange(packet) || len(packet) < 36 {
+		return clockSync{}, errTruncated
+	}
+	c := clockSync{
+		ssrc:  binary.BigEndian.Uint32(packet[4:]),
+		count: packet[8],
+	}
+	if c.count > 2 {
+		return clockSync{}, fmt.Errorf("invalid clock sync count %d", c.count)
+	}
+	for i := range c.timestamps {
+		c.timestamps[i] = binary.BigEndian.Uint64(packet[12+8*i:])
+	}
+	return c, nil
+}
+
+// feedback tells the sender up to which sequence number packets arrived, so
+// it can trim its recovery journal
+func feedback(ssrc uint32, sequence uint16) []byte {
+	packet := make([]byte, 12)
+	packet[0], packet[1] = 0xff, 0xff
+	copy(packet[2:4], commandFeedback)
+	binary.BigEndian.PutUint32(packet[4:], ssrc)
+	binary.BigEndian.PutUint16(packet[8:], sequence)
+	return packet
+}
+
+// rtpMIDI is the content of an RTP-MIDI packet. The recovery journal is not
+// read, so commands lost on the network are not recovered.
+type rtpMIDI struct {
+	sequence  uint16
+	timestamp uint32
+	ssrc      uint32
+	commands  [][]byte // With running status expanded; SysEx segments as sent
+}
+
+// marshalRTP encodes commands, each a complete MIDI message, into a packet
+// without journal
+func marshalRTP(sequence uint16, timestamp uint32, ssrc uint32, commands [][]byte) ([]byte, error) {
+	var list []byte
+	for i, command := range commands {
+		if len(command) == 0 {
+			return nil, errors.New("empty MIDI command")
+		}
+		if i > 0 {
+			list = append(list, 0) // Delta time
+		}
+		list = append(list, command...)
+	}
+	if len(list) > maxCommandSection {
+		return nil, fmt.Errorf("MIDI commands of %d bytes don't fit into a packet", len(list))
+	}
+
+	packet := make([]byte, rtpHeaderSize, rtpHeaderSize+2+len(list))
+	packet[0] = rtpVersion
+	packet[1] = payloadType
+	binary.BigEndian.PutUint16(packet[2:], sequence)
+	binary.BigEndian.PutUint32(packet[4:], timestamp)
+	binary.BigEndian.PutUint32(packet[8:], ssrc)
+	if len(list) <= 0x0f {
+		packet = append(packet, byte(len(list)))
+	} else {
+		// B flag for the 12 bit length
+		packet = append(packet, 0x80|byte(len(list)>>8), byte(len(list)))
+	}
+	return append(packet, list...), nil
+}
+
+// parseRTP parses an RTP-MIDI packet
+func parseRTP(packet []byte) (rtpMIDI, error) {
+	if len(packet) < rtpHeaderSize+1 {
+		return rtpMIDI{}, errTruncated
+	}
+	if packet[0]&0xc0 != rtpVersion {
+		return rtpMIDI{}, fmt.Errorf("unsupported RTP version %d", packet[0]>>6)
+	}
+	if packet[1]&0x7f != payloadType {
+		return rtpMIDI{}, fmt.Errorf("unexpected payload type %d", packet[1]&0x7f)
+	}
+	message := rtpMIDI{
+		sequence:  binary.BigEndian.Uint16(packet[2:]),
+		timestamp: binary.BigEndian.Uint32(packet[4:]),
+		ssrc:      binary.BigEndian.Uint32(packet[8:]),
+	}
+	// Skip the contributing sources, the command section header follows
+	headerSize := rtpHeaderSize + 4*int(packet[0]&0x0f)
+	if len(packet) < headerSize+1 {
+		return rtpMIDI{}, errTruncated
+	}
+	rest := packet[headerSize:]
+
+	flags := rest[0]
+	length := int(flags & 0x0f)
+	rest = rest[1:]
+	if flags&0x80 != 0 {
+		if len(rest) < 1 {
+			return rtpMIDI{}, errTruncated
+		}
+		length = length<<8 | int(rest[0])
+		rest = rest[1:]
+	}
+	if length > len(rest) {
+		return rtpMIDI{}, errTruncated
+	}
+	// Whatever follows the list is the journal, flagged by J
+	commands, err := parseMIDIList(rest[:length], flags&0x20 != 0)
+	if err != nil {
+		return rtpMIDI{}, err
+	}
+	message.commands = commands
+	return message, nil
+}
+
+// parseMIDIList splits a MIDI list into commands. Every command but the
+// first is preceded by a delta time, the first only if firstDelta (the Z flag).
+func parseMIDIList(list []byte, firstDelta bool) ([][]byte, error) {
+	var commands [][]byte
+	var runningStatus byte
+	for i := 0; len(list) > 0; i++ {
+		if i > 0 || firstDelta {
+			var err error
+			if list, err = skipDeltaTime(list); err != nil {
+				return nil, err
+			}
+			if len(list) == 0 {
+				return nil, errTruncated
+			}
+		}
+
+		status := list[0]
+		if status < 0x80 {
+			if runningStatus == 0 {
+				return nil, fmt.Errorf("data byte 0x%02x without status", status)
+			}
+			status = runningStatus
+		} else {
+			list = list[1:]
+		}
+
+		if status == 0xf0 || status == 0xf7 {
+			// A SysEx message or a segment of one, up to its end marker
+			end := sysExEnd(list)
+			if end < 0 {
+				return nil, errTruncated
+			}
+			commands = append(commands, append([]byte{status}, list[:end+1]...))
+			list = list[end+1:]
+			runningStatus = 0
+			continue
+		}
+
+		size := dataSize(status)
+		if size > len(list) {
+			return nil, errTruncated
+		}
+		command := append([]byte{status}, list[:size]...)
+		list = list[size:]
+		for _, data := range command[1:] {
+			if data >= 0x80 {
+				return nil, fmt.Errorf("status byte 0x%02x in the data of 0x%02x", data, status)
+			}
+		}
+		commands = append(commands, command)
+		switch {
+		case status < 0xf0:
+			runningStatus = status
+		case status < 0xf8:
+			// System common messages cancel running status, real time ones don't
+			runningStatus = 0
+		}
+	}
+	return commands, nil
+}
+
+// sysExEnd returns the index of the byte ending a SysEx segment
+func sysExEnd(data []byte) int {
+	for i, b := range data {
+		if b == 0xf0 || b == 0xf7 || b == 0xf4 {
+			return i
+		}
+	}
+	return -1
+}
+
+// skipDeltaTime skips a delta time of one to four bytes
+func skipDeltaTime(list []byte) ([]byte, error) {
+	for i := 0; i < 4; i++ {
+		if i >= len(list) {
+			return nil, errTruncated
+		}
+		if list[i]&0x80 == 0 {
+			return list[i+1:], nil
+		}
+	}
+	return nil, errors.New("delta time longer than 4 bytes")
+}
+
+// dataSize returns the number of data bytes following a status byte
+func dataSize(status byte) int {
+	switch status & 0xf0 {
+	case 0xc0, 0xd0:
+		return 1
+	case 0xf0:
+		switch status {
+		case 0xf1, 0xf3:
+			return 1
+		case 0xf2:
+			return 2
+		}
+		return 0
+	}
+	return 2
+}
@@ -0,0 +1,224 @@
+package rtpmidi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// wire decodes a packet written as hex, spaces are ignored
+func wire(t *testing.T, packet string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(strings.ReplaceAll(packet, " ", ""))
+	if err != nil {
+		t.Fatalf("invalid packet %q: %v", packet, err)
+	}
+	return data
+}
+
+func TestParseExchange(t *testing.T) {
+	// Invitation of rtpmidid
+	packet := wire(t, "ffff 494e 00000002 12345678 9abcdef0 7274706d6964696400")
+	if !isExchange(packet) {
+		t.Fatal("invitation not recognized as session exchange")
+	}
+	e, err := parseExchange(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := exchange{command: commandInvitation, token: 0x12345678, ssrc: 0x9abcdef0, name: "rtpmidid"}
+	if e != want {
+		t.Errorf("got %+v, want %+v", e, want)
+	}
+	if got := e.marshal(); !bytes.Equal(got, packet) {
+		t.Errorf("marshal gives % x, want % x", got, packet)
+	}
+
+	// Goodbyes carry no name
+	bye := wire(t, "ffff 4259 00000002 12345678 9abcdef0")
+	e, err = parseExchange(bye)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.command != commandBye || e.name != "" {
+		t.Errorf("got %+v, want a goodbye without name", e)
+	}
+	if got := e.marshal(); !bytes.Equal(got, bye) {
+		t.Errorf("marshal gives % x, want % x", got, bye)
+	}
+}
+
+func TestParseExchangeErrors(t *testing.T) {
+	for name, packet := range map[string]string{
+		"truncated":   "ffff 494e 00000002 1234",
+		"version":     "ffff 494e 00000001 12345678 9abcdef0 00",
+		"not session": "8061 0001 00000064 9abcdef0 0390 3c7f",
+	} {
+		if _, err := parseExchange(wire(t, packet)); err == nil {
+			t.Errorf("%s: parsed without error", name)
+		}
+	}
+}
+
+func TestParseClockSync(t *testing.T) {
+	packet := wire(t, "ffff 434b 9abcdef0 01 000000 "+
+		"0000000000000064 00000000000000c8 0000000000000000")
+	c, err := parseClockSync(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := clockSync{ssrc: 0x9abcdef0, count: 1, timestamps: [3]uint64{100, 200, 0}}
+	if c != want {
+		t.Errorf("got %+v, want %+v", c, want)
+	}
+	if got := c.marshal(); !bytes.Equal(got, packet) {
+		t.Errorf("marshal gives % x, want % x", got, packet)
+	}
+
+	invalid := wire(t, "ffff 434b 9abcdef0 03 000000 "+
+		"0000000000000064 00000000000000c8 0000000000000000")
+	if _, err := parseClockSync(invalid); err == nil {
+		t.Error("count 3 parsed without error")
+	}
+	if _, err := parseClockSync(packet[:35]); !errors.Is(err, errTruncated) {
+		t.Errorf("got %v for a truncated clock sync, want errTruncated", err)
+	}
+}
+
+func TestFeedback(t *testing.T) {
+	want := wire(t, "ffff 5253 9abcdef0 0102 0000")
+	if got := feedback(0x9abcdef0, 0x0102); !bytes.Equal(got, want) {
+		t.Errorf("got % x, want % x", got, want)
+	}
+}
+
+func TestParseRTP(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		packet   string
+		commands [][]byte
+	}{
+		{
+			name:     "note on",
+			packet:   "8061 0001 00000064 9abcdef0 03 903c7f",
+			commands: [][]byte{{0x90, 0x3c, 0x7f}},
+		},
+		{
+			name:     "running status",
+			packet:   "8061 0002 00000064 9abcdef0 06 b00740 00 0810",
+			commands: [][]byte{{0xb0, 0x07, 0x40}, {0xb0, 0x08, 0x10}},
+		},
+		{
+			name:     "delta time of the first command",
+			packet:   "8061 0003 00000064 9abcdef0 23 00 c005",
+			commands: [][]byte{{0xc0, 0x05}},
+		},
+		{
+			name:     "multi-byte delta time",
+			packet:   "8061 0004 00000064 9abcdef0 08 903c7f 8100 803c00",
+			commands: [][]byte{{0x90, 0x3c, 0x7f}, {0x80, 0x3c, 0x00}},
+		},
+		{
+			name:     "journal after the commands",
+			packet:   "8061 0005 00000064 9abcdef0 43 b00100 2000070800810000",
+			commands: [][]byte{{0xb0, 0x01, 0x00}},
+		},
+		{
+			name:     "long header",
+			packet:   "8061 0006 00000064 9abcdef0 8006 f07e7f0601f7",
+			commands: [][]byte{{0xf0, 0x7e, 0x7f, 0x06, 0x01, 0xf7}},
+		},
+		{
+			name:     "contributing source",
+			packet:   "8161 0007 00000064 9abcdef0 11111111 03 903c7f",
+			commands: [][]byte{{0x90, 0x3c, 0x7f}},
+		},
+		{
+			name:     "real time message keeps running status",
+			packet:   "8061 0008 00000064 9abcdef0 08 b00740 00 f8 00 0810",
+			commands: [][]byte{{0xb0, 0x07, 0x40}, {0xf8}, {0xb0, 0x08, 0x10}},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			message, err := parseRTP(wire(t, test.packet))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if message.ssrc != 0x9abcdef0 || message.timestamp != 100 {
+				t.Errorf("got ssrc %x and timestamp %d", message.ssrc, message.timestamp)
+			}
+			if len(message.commands) != len(test.commands) {
+				t.Fatalf("got commands % x, want % x", message.commands, test.commands)
+			}
+			for i, command := range test.commands {
+				if !bytes.Equal(message.commands[i], command) {
+					t.Errorf("command %d is % x, want % x", i, message.commands[i], command)
+				}
+			}
+		})
+	}
+}
+
+func TestParseRTPErrors(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		packet    string
+		truncated bool
+	}{
+		{name: "header only", packet: "8061 0001 00000064 9abcdef0", truncated: true},
+		{name: "missing contributing source", packet: "8161 0001 00000064 9abcdef0 03", truncated: true},
+		{name: "all contributing sources missing", packet: "8f61 0001 00000064 9abcdef0 03 903c7f 00000000", truncated: true},
+		{name: "missing long length", packet: "8061 0001 00000064 9abcdef0 80", truncated: true},
+		{name: "list longer than packet", packet: "8061 0001 00000064 9abcdef0 05 903c7f", truncated: true},
+		{name: "incomplete command", packet: "8061 0001 00000064 9abcdef0 02 903c", truncated: true},
+		{name: "unterminated SysEx", packet: "8061 0001 00000064 9abcdef0 03 f07e7f", truncated: true},
+		{name: "version", packet: "4061 0001 00000064 9abcdef0 03 903c7f"},
+		{name: "payload type", packet: "8060 0001 00000064 9abcdef0 03 903c7f"},
+		{name: "data without status", packet: "8061 0001 00000064 9abcdef0 02 3c7f"},
+		{name: "status in data", packet: "8061 0001 00000064 9abcdef0 03 90903c"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseRTP(wire(t, test.packet))
+			if err == nil {
+				t.Fatal("parsed without error")
+			}
+			if test.truncated && !errors.Is(err, errTruncated) {
+				t.Errorf("got %v, want errTruncated", err)
+			}
+		})
+	}
+}
+
+func TestMarshalRTP(t *testing.T) {
+	commands := [][]byte{{0x90, 0x3c, 0x7f}, {0xb0, 0x07, 0x40}}
+	packet, err := marshalRTP(7, 100, 0x9abcdef0, commands)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := wire(t, "8061 0007 00000064 9abcdef0 07 903c7f 00 b00740")
+	if !bytes.Equal(packet, want) {
+		t.Errorf("got % x, want % x", packet, want)
+	}
+	message, err := parseRTP(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.sequence != 7 || len(message.commands) != 2 || !bytes.Equal(message.commands[1], commands[1]) {
+		t.Errorf("round trip gives %+v", message)
+	}
+
+	// Lists longer than 15 bytes need the long header
+	long := bytes.Repeat([]byte{0x90, 0x3c, 0x7f}, 6)
+	packet, err = marshalRTP(8, 100, 0x9abcdef0, [][]byte{long[:3], long[3:6], long[6:9], long[9:12], long[12:15], long[15:]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packet[rtpHeaderSize] != 0x80 || packet[rtpHeaderSize+1] != 23 {
+		t.Errorf("got header % x, want 80 17", packet[rtpHeaderSize:rtpHeaderSize+2])
+	}
+	if _, err := marshalRTP(9, 100, 0x9abcdef0, [][]byte{{}}); err == nil {
+		t.Error("empty command marshalled without error")
+	}
+}
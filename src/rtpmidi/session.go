@@ -0,0 +1,588 @@
+// Package rtpmidi joins a network MIDI session (RTP-MIDI, also known as
+// AppleMIDI), like one of rtpmidid on a Raspberry Pi the controller is
+// plugged into. A Session is a MIDI in and out port of the gomidi drivers, so
+// the MIDI client uses it like a local port.
+//
+// pulsekontrol is the initiator: it invites the session on its control port
+// and the port after it, keeps the clocks in sync and invites again when the
+// session is lost. The recovery journal of received packets is not read.
+package rtpmidi
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/logging"
+	"github.com/0h41/pulsekontrol/src/supervise"
+	"github.com/rs/zerolog"
+	"gitlab.com/gomidi/midi/v2/drivers"
+)
+
+const (
+	// DefaultPort is the control port of rtpmidid and most other sessions
+	DefaultPort = 5004
+	// DefaultName is the name pulsekontrol joins a session with
+	DefaultName = "pulsekontrol"
+
+	// inviteTimeout is how long to wait for the answer to an invitation
+	inviteTimeout = time.Second
+	// inviteAttempts is how often an invitation is sent before giving up
+	inviteAttempts = 5
+	// syncInterval is the time between clock synchronizations, which also
+	// tell that the session is alive
+	syncInterval = 10 * time.Second
+	// lossTimeout is how long the session may not answer a clock
+	// synchronization before it is considered lost
+	lossTimeout = 3*syncInterval + syncInterval/2
+	// reconnectDelay is the first delay before inviting a lost session
+	// again, doubled up to maxReconnectDelay
+	reconnectDelay    = time.Second
+	maxReconnectDelay = 30 * time.Second
+	// maxPacketSize is the largest UDP payload
+	maxPacketSize = 65535
+)
+
+// ErrNotConnected is returned by Send while the session is lost
+var ErrNotConnected = errors.New("not connected to the RTP-MIDI session")
+
+// Session is the connection to a network MIDI session
+type Session struct {
+	log     zerolog.Logger
+	host    string
+	port    int
+	name    string
+	ssrc    uint32
+	start   time.Time
+	answers chan exchange
+
+	// Lost is called when the session stopped answering or said goodbye,
+	// before it is invited again
+	Lost func()
+	// Reconnected is called after a lost session was joined again, for
+	// example to restore LEDs
+	Reconnected func()
+
+	mutex       sync.Mutex
+	open        bool
+	connected   bool
+	control     *net.UDPConn
+	data        *net.UDPConn
+	controlAddr *net.UDPAddr
+	dataAddr    *net.UDPAddr
+	token       uint32
+	remoteSSRC  uint32
+	sequence    uint16
+	received    bool   // Whether a packet arrived since the last feedback
+	lastSeq     uint16 // Sequence number of the last packet received
+	lastSync    time.Time
+	sysex       []byte // SysEx segments received so far
+	onMsg       func(msg []byte, milliseconds int32)
+	listen      drivers.ListenConfig
+	lost        chan struct{}
+	closed      chan struct{}
+	wg          sync.WaitGroup
+}
+
+var (
+	_ drivers.In  = (*Session)(nil)
+	_ drivers.Out = (*Session)(nil)
+)
+
+// NewSession creates a session with the RTP-MIDI session at host and its
+// control port, joined under name once opened
+func NewSession(host string, port int, name string) *Session {
+	if port == 0 {
+		port = DefaultPort
+	}
+	if name == "" {
+		name = DefaultName
+	}
+	return &Session{
+		log:     logging.Module("Midi"),
+		host:    host,
+		port:    port,
+		name:    name,
+		ssrc:    rand.Uint32(),
+		start:   time.Now(),
+		answers: make(chan exchange, 4),
+	}
+}
+
+// Open joins the session. It fails if the session can't be reached or
+// rejects the invitation; once joined, a lost session is joined again
+// until Close.
+func (s *Session) Open() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.open {
+		return nil
+	}
+
+	address := net.JoinHostPort(s.host, strconv.Itoa(s.port))
+	controlAddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return fmt.Errorf("invalid RTP-MIDI session %s: %w", address, err)
+	}
+	control, data, err := listenPair()
+	if err != nil {
+		return err
+	}
+	s.control, s.data = control, data
+	s.controlAddr = controlAddr
+	s.dataAddr = &net.UDPAddr{IP: controlAddr.IP, Port: controlAddr.Port + 1, Zone: controlAddr.Zone}
+	s.lost = make(chan struct{}, 1)
+	s.closed = make(chan struct{})
+	s.wg.Add(2)
+	for _, conn := range []*net.UDPConn{control, data} {
+		supervise.Go("rtpmidi.receive", func() {
+			s.receive(conn)
+			s.wg.Done()
+		})
+	}
+
+	s.mutex.Unlock()
+	err = s.invite()
+	if err != nil {
+		close(s.closed)
+		control.Close()
+		data.Close()
+		s.wg.Wait()
+		s.mutex.Lock()
+		return err
+	}
+	s.mutex.Lock()
+
+	s.open = true
+	s.wg.Add(1)
+	supervise.Go("rtpmidi.maintain", func() {
+		s.maintain()
+		s.wg.Done()
+	})
+	return nil
+}
+
+// listenPair opens the control and data sockets, on consecutive ports if
+// possible since some sessions expect that
+func listenPair() (*net.UDPConn, *net.UDPConn, error) {
+	control, err := net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot open RTP-MIDI control socket: %w", err)
+	}
+	port := control.LocalAddr().(*net.UDPAddr).Port
+	data, err := net.ListenUDP("udp", &net.UDPAddr{Port: port + 1})
+	if err != nil {
+		data, err = net.ListenUDP("udp", &net.UDPAddr{})
+	}
+	if err != nil {
+		control.Close()
+		return nil, nil, fmt.Errorf("cannot open RTP-MIDI data socket: %w", err)
+	}
+	return control, data, nil
+}
+
+// invite invites the session on its control and then its data port and
+// synchronizes the clocks
+func (s *Session) invite() error {
+	s.mutex.Lock()
+	s.token = rand.Uint32()
+	token := s.token
+	control, data := s.control, s.data
+	controlAddr, dataAddr := s.controlAddr, s.dataAddr
+	s.mutex.Unlock()
+
+	// Drop answers to an earlier invitation
+	for len(s.answers) > 0 {
+		<-s.answers
+	}
+	invitation := exchange{command: commandInvitation, token: token, ssrc: s.ssrc, name: s.name}.marshal()
+	var remoteName string
+	for _, port := range []struct {
+		conn *net.UDPConn
+		addr *net.UDPAddr
+	}{{control, controlAddr}, {data, dataAddr}} {
+		answer, err := s.request(port.conn, port.addr, invitation, token)
+		if err != nil {
+			return err
+		}
+		if answer.command == commandReject {
+			return fmt.Errorf("RTP-MIDI session %s rejected the invitation", controlAddr)
+		}
+		remoteName = answer.name
+		s.mutex.Lock()
+		s.remoteSSRC = answer.ssrc
+		s.mutex.Unlock()
+	}
+
+	s.mutex.Lock()
+	s.connected = true
+	s.lastSync = time.Now()
+	s.sysex = nil
+	s.mutex.Unlock()
+	s.log.Info().Str("session", remoteName).Str("address", controlAddr.String()).Msg("Joined RTP-MIDI session")
+	s.synchronize()
+	return nil
+}
+
+// request sends an invitation and waits for its answer, sending it again
+// if none arrives
+func (s *Session) request(conn *net.UDPConn, addr *net.UDPAddr, invitation []byte, token uint32) (exchange, error) {
+	for attempt := 0; attempt < inviteAttempts; attempt++ {
+		if _, err := conn.WriteToUDP(invitation, addr); err != nil {
+			return exchange{}, fmt.Errorf("cannot invite RTP-MIDI session %s: %w", addr, err)
+		}
+		timeout := time.After(inviteTimeout)
+	wait:
+		for {
+			select {
+			case answer := <-s.answers:
+				if answer.token == token {
+					return answer, nil
+				}
+			case <-timeout:
+				break wait
+			case <-s.closed:
+				return exchange{}, drivers.ErrPortClosed
+			}
+		}
+	}
+	return exchange{}, fmt.Errorf("RTP-MIDI session %s does not answer", addr)
+}
+
+// maintain synchronizes the clocks and joins the session again when it is lost
+func (s *Session) maintain() {
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-s.lost:
+		case <-ticker.C:
+			s.mutex.Lock()
+			alive := time.Since(s.lastSync) < lossTimeout
+			s.mutex.Unlock()
+			if alive {
+				s.synchronize()
+				s.sendFeedback()
+				continue
+			}
+		}
+
+		s.mutex.Lock()
+		s.connected = false
+		s.mutex.Unlock()
+		s.log.Warn().Str("address", s.controlAddr.String()).Msg("Lost RTP-MIDI session, reconnecting")
+		if s.Lost != nil {
+			s.Lost()
+		}
+		if !s.reconnect() {
+			return
+		}
+		if s.Reconnected != nil {
+			s.Reconnected()
+		}
+	}
+}
+
+// reconnect invites the session until it accepts, waiting longer after each
+// failure. It returns false if the session was closed meanwhile.
+func (s *Session) reconnect() bool {
+	delay := reconnectDelay
+	for {
+		select {
+		case <-s.closed:
+			return false
+		case <-time.After(delay):
+		}
+		err := s.invite()
+		if err == nil {
+			return true
+		}
+		if errors.Is(err, drivers.ErrPortClosed) {
+			return false
+		}
+		s.log.Debug().Err(err).Dur("delay", delay).Msg("Failed to rejoin RTP-MIDI session")
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+// timestamp returns the time since the session was created in units of 100
+// microseconds, the clock of the session
+func (s *Session) timestamp() uint64 {
+	return uint64(time.Since(s.start) / (100 * time.Microsecond))
+}
+
+// synchronize starts a clock synchronization
+func (s *Session) synchronize() {
+	s.mutex.Lock()
+	data, addr := s.data, s.dataAddr
+	s.mutex.Unlock()
+	clock := clockSync{ssrc: s.ssrc, count: 0, timestamps: [3]uint64{s.timestamp()}}
+	if _, err := data.WriteToUDP(clock.marshal(), addr); err != nil {
+		s.log.Debug().Err(err).Msg("Failed to send RTP-MIDI clock sync")
+	}
+}
+
+// sendFeedback tells the session which packets arrived
+func (s *Session) sendFeedback() {
+	s.mutex.Lock()
+	received, sequence := s.received, s.lastSeq
+	s.received = false
+	control, addr := s.control, s.controlAddr
+	s.mutex.Unlock()
+	if !received {
+		return
+	}
+	if _, err := control.WriteToUDP(feedback(s.ssrc, sequence), addr); err != nil {
+		s.log.Debug().Err(err).Msg("Failed to send RTP-MIDI receiver feedback")
+	}
+}
+
+// receive handles the packets of a socket until it is closed
+func (s *Session) receive(conn *net.UDPConn) {
+	buffer := make([]byte, maxPacketSize)
+	for {
+		n, sender, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.log.Error().Err(err).Msg("Failed to receive RTP-MIDI packet")
+			}
+			return
+		}
+		packet := buffer[:n]
+		if !isExchange(packet) {
+			s.handleRTP(packet)
+			continue
+		}
+		switch exchangeCommand(packet) {
+		case commandAccept, commandReject:
+			answer, err := parseExchange(packet)
+			if err != nil {
+				s.log.Debug().Err(err).Msg("Ignoring malformed RTP-MIDI answer")
+				continue
+			}
+			select {
+			case s.answers <- answer:
+			default:
+			}
+		case commandBye:
+			s.mutex.Lock()
+			connected := s.connected
+			s.mutex.Unlock()
+			if connected {
+				select {
+				case s.lost <- struct{}{}:
+				default:
+				}
+			}
+		case commandClockSync:
+			s.handleClockSync(conn, sender, packet)
+		case commandInvitation:
+			// Only sessions pulsekontrol invited itself are joined
+			if invitation, err := parseExchange(packet); err == nil {
+				reject := exchange{command: commandReject, token: invitation.token, ssrc: s.ssrc, name: s.name}
+				conn.WriteToUDP(reject.marshal(), sender)
+			}
+		}
+	}
+}
+
+// handleClockSync answers the clock synchronizations of either side
+func (s *Session) handleClockSync(conn *net.UDPConn, sender *net.UDPAddr, packet []byte) {
+	clock, err := parseClockSync(packet)
+	if err != nil {
+		s.log.Debug().Err(err).Msg("Ignoring malformed RTP-MIDI clock sync")
+		return
+	}
+	switch clock.count {
+	case 0:
+		// The session synchronizes, answer with our time
+		clock.count = 1
+		clock.timestamps[1] = s.timestamp()
+	case 1:
+		// The answer to our synchronization, which completes it
+		clock.count = 2
+		clock.timestamps[2] = s.timestamp()
+	case 2:
+		s.mutex.Lock()
+		s.lastSync = time.Now()
+		s.mutex.Unlock()
+		return
+	}
+	clock.ssrc = s.ssrc
+	if _, err := conn.WriteToUDP(clock.marshal(), sender); err != nil {
+		s.log.Debug().Err(err).Msg("Failed to answer RTP-MIDI clock sync")
+		return
+	}
+	s.mutex.Lock()
+	s.lastSync = time.Now()
+	s.mutex.Unlock()
+}
+
+// handleRTP passes the MIDI commands of a packet to the listener
+func (s *Session) handleRTP(packet []byte) {
+	message, err := parseRTP(packet)
+	if err != nil {
+		s.log.Debug().Err(err).Msg("Ignoring malformed RTP-MIDI packet")
+		return
+	}
+
+	s.mutex.Lock()
+	if !s.connected || message.ssrc != s.remoteSSRC {
+		s.mutex.Unlock()
+		return
+	}
+	s.received = true
+	s.lastSeq = message.sequence
+	var messages [][]byte
+	for _, command := range message.commands {
+		if command, ok := s.assemble(command); ok && s.wanted(command) {
+			messages = append(messages, command)
+		}
+	}
+	onMsg := s.onMsg
+	s.mutex.Unlock()
+
+	if onMsg == nil {
+		return
+	}
+	milliseconds := int32(time.Since(s.start).Milliseconds())
+	for _, command := range messages {
+		onMsg(command, milliseconds)
+	}
+}
+
+// assemble puts SysEx segments together, returning complete messages. A
+// first segment ends with F0, further ones start with F7, the last one ends
+// with F7 and a cancelled one with F4.
+func (s *Session) assemble(command []byte) ([]byte, bool) {
+	last := command[len(command)-1]
+	switch command[0] {
+	case 0xf0:
+		switch last {
+		case 0xf7:
+			s.sysex = nil
+			return command, true
+		case 0xf0:
+			s.sysex = append([]byte{}, command[:len(command)-1]...)
+		default:
+			s.sysex = nil
+		}
+		return nil, false
+	case 0xf7:
+		if s.sysex == nil {
+			return nil, false
+		}
+		s.sysex = append(s.sysex, command[1:len(command)-1]...)
+		switch last {
+		case 0xf7:
+			complete := append(s.sysex, 0xf7)
+			s.sysex = nil
+			return complete, true
+		case 0xf4:
+			s.sysex = nil
+		}
+		return nil, false
+	}
+	return command, true
+}
+
+// wanted reports whether the listener asked for a message
+func (s *Session) wanted(command []byte) bool {
+	switch command[0] {
+	case 0xf0:
+		return s.listen.SysEx
+	case 0xf1:
+		return s.listen.TimeCode
+	case 0xfe:
+		return s.listen.ActiveSense
+	}
+	return true
+}
+
+// Listen passes the received messages to onMsg until the returned function
+// is called
+func (s *Session) Listen(onMsg func(msg []byte, milliseconds int32), config drivers.ListenConfig) (func(), error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.open {
+		return nil, drivers.ErrPortClosed
+	}
+	s.onMsg = onMsg
+	s.listen = config
+	return func() {
+		s.mutex.Lock()
+		s.onMsg = nil
+		s.mutex.Unlock()
+	}, nil
+}
+
+// Send sends a MIDI message to the session
+func (s *Session) Send(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.open {
+		return drivers.ErrPortClosed
+	}
+	if !s.connected {
+		return ErrNotConnected
+	}
+	packet, err := marshalRTP(s.sequence, uint32(s.timestamp()), s.ssrc, [][]byte{data})
+	if err != nil {
+		return err
+	}
+	s.sequence++
+	_, err = s.data.WriteToUDP(packet, s.dataAddr)
+	return err
+}
+
+// Close leaves the session
+func (s *Session) Close() error {
+	s.mutex.Lock()
+	if !s.open {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.open = false
+	if s.connected {
+		bye := exchange{command: commandBye, token: s.token, ssrc: s.ssrc}
+		s.control.WriteToUDP(bye.marshal(), s.controlAddr)
+		s.connected = false
+	}
+	close(s.closed)
+	s.control.Close()
+	s.data.Close()
+	s.mutex.Unlock()
+	s.wg.Wait()
+	s.log.Info().Str("address", s.controlAddr.String()).Msg("Left RTP-MIDI session")
+	return nil
+}
+
+// IsOpen reports whether the session was joined and not closed since
+func (s *Session) IsOpen() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.open
+}
+
+// Number returns 0, sessions are not numbered like local ports
+func (s *Session) Number() int {
+	return 0
+}
+
+func (s *Session) String() string {
+	return "rtpmidi://" + net.JoinHostPort(s.host, strconv.Itoa(s.port))
+}
+
+// Underlying returns the session itself
+func (s *Session) Underlying() interface{} {
+	return s
+}
@@ -0,0 +1,195 @@
+// Package scheduler runs the schedules of the configuration at their times,
+// like turning the speakers down for the night. Times are wall clock times
+// of the local time zone, which is looked up again on each check, so daylight
+// saving time and a changed time zone move the runs with the clock.
+package scheduler
+
+import (
+	"os"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/clock"
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/0h41/pulsekontrol/src/cron"
+	"github.com/0h41/pulsekontrol/src/logging"
+	"github.com/0h41/pulsekontrol/src/supervise"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// maxWait bounds a wait, so that a changed clock or time zone is
+	// noticed within it
+	maxWait = time.Minute
+	// lateness is how late a run may be, like after a busy moment. Runs
+	// missed for longer, while the machine was suspended, happen only
+	// within their catch-up window.
+	lateness = time.Minute
+)
+
+// Runner runs the scene and actions of a schedule
+type Runner func(schedule configuration.Schedule)
+
+// Scheduler runs the schedules. They are read from the configuration on each
+// check, so changes apply right away.
+type Scheduler struct {
+	log           zerolog.Logger
+	configManager *configuration.ConfigManager
+	clock         clock.Clock
+	location      func() *time.Location
+	run           Runner
+	stop          chan struct{}
+	done          chan struct{}
+	invalid       map[string]bool // Times whose parse error was logged
+}
+
+// New creates a scheduler that runs the schedules once started
+func New(configManager *configuration.ConfigManager, clock clock.Clock, run Runner) *Scheduler {
+	return &Scheduler{
+		log:           logging.Module("Scheduler"),
+		configManager: configManager,
+		clock:         clock,
+		location:      localLocation,
+		run:           run,
+		invalid:       make(map[string]bool),
+	}
+}
+
+// Start runs the schedules missed within their catch-up window, then the
+// others when they are due, until Stop
+func (s *Scheduler) Start() {
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	supervise.Go("scheduler", func() {
+		s.loop()
+		close(s.done)
+	})
+}
+
+// Stop stops the scheduler and waits for a running schedule to finish
+func (s *Scheduler) Stop() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+}
+
+func (s *Scheduler) loop() {
+	s.catchUp()
+	checked := s.now()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-s.clock.After(s.wait(checked)):
+		}
+		now := s.now()
+		for _, schedule := range s.schedules() {
+			spec, ok := s.spec(schedule)
+			if !ok {
+				continue
+			}
+			due, ok := spec.Next(checked.In(now.Location()))
+			if !ok || due.After(now) {
+				continue
+			}
+			if late := now.Sub(due); late > max(lateness, schedule.CatchUp) {
+				s.log.Warn().Str("schedule", schedule.Name).Time("due", due).Dur("late", late).Msg("Skipping schedule that was due while the clock jumped or the machine slept")
+				continue
+			}
+			s.fire(schedule, due, now)
+		}
+		checked = now
+	}
+}
+
+// catchUp runs once each schedule that was due while pulsekontrol was not
+// running, if it has a catch-up window and was due within it
+func (s *Scheduler) catchUp() {
+	now := s.now()
+	for _, schedule := range s.schedules() {
+		spec, ok := s.spec(schedule)
+		if !ok || schedule.CatchUp <= 0 {
+			continue
+		}
+		from := now.Add(-schedule.CatchUp)
+		if schedule.LastRun.After(from) {
+			from = schedule.LastRun
+		}
+		if due, ok := spec.Next(from.In(now.Location())); ok && !due.After(now) {
+			s.log.Info().Str("schedule", schedule.Name).Time("due", due).Msg("Catching up on a schedule missed while not running")
+			s.fire(schedule, due, now)
+		}
+	}
+}
+
+// wait returns how long to wait for the next schedule, at most maxWait
+func (s *Scheduler) wait(checked time.Time) time.Duration {
+	wait := maxWait
+	now := s.now()
+	for _, schedule := range s.schedules() {
+		spec, ok := s.spec(schedule)
+		if !ok {
+			continue
+		}
+		if next, ok := spec.Next(checked.In(now.Location())); ok {
+			wait = min(wait, max(next.Sub(now), 0))
+		}
+	}
+	return wait
+}
+
+func (s *Scheduler) fire(schedule configuration.Schedule, due time.Time, now time.Time) {
+	s.log.Info().Str("schedule", schedule.Name).Time("due", due).Str("scene", schedule.Scene).Int("actions", len(schedule.Actions)).Msg("Running schedule")
+	s.run(schedule)
+	s.configManager.MarkScheduleRun(schedule.Name, now)
+}
+
+// now is the time of the clock in the current time zone
+func (s *Scheduler) now() time.Time {
+	return s.clock.Now().In(s.location())
+}
+
+// schedules returns the enabled schedules
+func (s *Scheduler) schedules() []configuration.Schedule {
+	var enabled []configuration.Schedule
+	for _, schedule := range s.configManager.GetConfigSnapshot().Schedules {
+		if schedule.IsEnabled() {
+			enabled = append(enabled, schedule)
+		}
+	}
+	return enabled
+}
+
+// spec parses the time of a schedule, logging an invalid one once
+func (s *Scheduler) spec(schedule configuration.Schedule) (cron.Spec, bool) {
+	spec, err := cron.Parse(schedule.At)
+	if err != nil {
+		if !s.invalid[schedule.At] {
+			s.invalid[schedule.At] = true
+			s.log.Error().Err(err).Str("schedule", schedule.Name).Msg("Invalid schedule time, the schedule never runs")
+		}
+		return cron.Spec{}, false
+	}
+	return spec, true
+}
+
+// localLocation returns the time zone of the system as it is now: TZ if it
+// is set, /etc/localtime otherwise. time.Local is only read at startup.
+func localLocation() *time.Location {
+	if name, ok := os.LookupEnv("TZ"); ok {
+		if location, err := time.LoadLocation(name); err == nil {
+			return location
+		}
+		return time.Local
+	}
+	data, err := os.ReadFile("/etc/localtime")
+	if err != nil {
+		return time.Local
+	}
+	location, err := time.LoadLocationFromTZData("Local", data)
+	if err != nil {
+		return time.Local
+	}
+	return location
+}
@@ -0,0 +1,249 @@
+package scheduler
+
+import (
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/0h41/pulsekontrol/src/internal/testutil"
+)
+
+// testScheduler runs schedules on a fake clock in a time zone the test can
+// change, recording the runs as the name and local time
+type testScheduler struct {
+	*Scheduler
+	clock    *testutil.FakeClock
+	zone     atomic.Pointer[time.Location]
+	cm       *configuration.ConfigManager
+	mu       sync.Mutex
+	recorded []string
+}
+
+func newTestScheduler(t *testing.T, start time.Time, schedules ...configuration.Schedule) *testScheduler {
+	t.Helper()
+	config := configuration.GetDefaultConfig()
+	config.Schedules = schedules
+	ts := &testScheduler{
+		clock: testutil.NewFakeClock(start),
+		cm:    configuration.NewConfigManager(config, filepath.Join(t.TempDir(), "config.yaml")),
+	}
+	ts.cm.SetReadOnly(true)
+	ts.zone.Store(start.Location())
+	ts.Scheduler = New(ts.cm, ts.clock, func(schedule configuration.Schedule) {
+		ts.mu.Lock()
+		defer ts.mu.Unlock()
+		ts.recorded = append(ts.recorded, schedule.Name+" "+ts.now().Format("2006-01-02 15:04 MST"))
+	})
+	ts.location = func() *time.Location { return ts.zone.Load() }
+	t.Cleanup(ts.Stop)
+	return ts
+}
+
+// waiting waits for the scheduler to wait for the clock, done with a check
+func (ts *testScheduler) waiting(t *testing.T) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for ts.clock.Waiters() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("scheduler not waiting")
+		}
+		time.Sleep(10 * time.Microsecond)
+	}
+}
+
+// advance moves the clock a minute at a time, like it moves while running,
+// until it passed d
+func (ts *testScheduler) advance(t *testing.T, d time.Duration) {
+	t.Helper()
+	for ; d > 0; d -= time.Minute {
+		ts.waiting(t)
+		ts.clock.Advance(min(d, time.Minute))
+	}
+	ts.waiting(t)
+}
+
+// runs returns and forgets the runs so far
+func (ts *testScheduler) runs() []string {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	runs := ts.recorded
+	ts.recorded = nil
+	return runs
+}
+
+// berlin is a time zone with daylight saving time: clocks go forward from
+// 02:00 to 03:00 on 2026-03-29 and back from 03:00 to 02:00 on 2026-10-25
+func berlin(t *testing.T) *time.Location {
+	t.Helper()
+	location, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location
+}
+
+func TestRunsAtWallClockTime(t *testing.T) {
+	disabled := false
+	ts := newTestScheduler(t, time.Date(2026, 10, 16, 22, 50, 0, 0, berlin(t)),
+		configuration.Schedule{Name: "night", At: "23:00"},
+		configuration.Schedule{Name: "weekdays", At: "0 7 * * 1-5"},
+		configuration.Schedule{Name: "disabled", At: "23:00", Enabled: &disabled},
+		configuration.Schedule{Name: "invalid", At: "25:00"},
+	)
+	ts.Start()
+
+	ts.advance(t, 20*time.Minute)
+	if runs := ts.runs(); !slices.Equal(runs, []string{"night 2026-10-16 23:00 CEST"}) {
+		t.Errorf("ran %q", runs)
+	}
+	schedules := ts.cm.GetConfigSnapshot().Schedules
+	if want := time.Date(2026, 10, 16, 23, 0, 0, 0, berlin(t)); !schedules[0].LastRun.Equal(want) {
+		t.Errorf("night last ran %s, want %s", schedules[0].LastRun, want)
+	}
+	if !schedules[1].LastRun.IsZero() {
+		t.Errorf("weekdays last ran %s", schedules[1].LastRun)
+	}
+
+	// 2026-10-17 is a Saturday, the weekdays schedule waits for Monday
+	ts.advance(t, 56*time.Hour)
+	want := []string{"night 2026-10-17 23:00 CEST", "night 2026-10-18 23:00 CEST", "weekdays 2026-10-19 07:00 CEST"}
+	if runs := ts.runs(); !slices.Equal(runs, want) {
+		t.Errorf("ran %q, want %q", runs, want)
+	}
+}
+
+func TestDaylightSavingTime(t *testing.T) {
+	location := berlin(t)
+	for _, test := range []struct {
+		start time.Time
+		runs  []string
+	}{
+		// 02:30 doesn't exist and runs once the clocks moved forward
+		{time.Date(2026, 3, 28, 22, 50, 0, 0, location), []string{
+			"night 2026-03-28 23:00 CET",
+			"early 2026-03-29 03:30 CEST",
+			"night 2026-03-29 23:00 CEST",
+		}},
+		// 02:30 exists twice and runs once, the second time
+		{time.Date(2026, 10, 24, 22, 50, 0, 0, location), []string{
+			"night 2026-10-24 23:00 CEST",
+			"early 2026-10-25 02:30 CET",
+			"night 2026-10-25 23:00 CET",
+		}},
+	} {
+		t.Run(test.start.Format("January"), func(t *testing.T) {
+			ts := newTestScheduler(t, test.start,
+				configuration.Schedule{Name: "night", At: "23:00"},
+				configuration.Schedule{Name: "early", At: "02:30"},
+			)
+			ts.Start()
+			// Up to 23:10 on the next day, whatever the length of the day
+			end := time.Date(test.start.Year(), test.start.Month(), test.start.Day()+1, 23, 10, 0, 0, location)
+			ts.advance(t, end.Sub(test.start))
+			if runs := ts.runs(); !slices.Equal(runs, test.runs) {
+				t.Errorf("ran %q, want %q", runs, test.runs)
+			}
+		})
+	}
+}
+
+func TestTimeZoneChange(t *testing.T) {
+	// 22:30 in Berlin is 20:30 UTC
+	ts := newTestScheduler(t, time.Date(2026, 10, 16, 22, 30, 0, 0, berlin(t)),
+		configuration.Schedule{Name: "night", At: "23:00"},
+	)
+	ts.Start()
+	ts.advance(t, 10*time.Minute)
+
+	// Moving to UTC, 23:00 is now in more than two hours
+	ts.zone.Store(time.UTC)
+	ts.advance(t, 2*time.Hour)
+	if runs := ts.runs(); len(runs) != 0 {
+		t.Errorf("ran %q before 23:00 UTC", runs)
+	}
+	ts.advance(t, 30*time.Minute)
+	if runs := ts.runs(); !slices.Equal(runs, []string{"night 2026-10-16 23:00 UTC"}) {
+		t.Errorf("ran %q, want at 23:00 UTC", runs)
+	}
+}
+
+func TestCatchUp(t *testing.T) {
+	location := berlin(t)
+	start := time.Date(2026, 10, 16, 23, 30, 0, 0, location)
+	ts := newTestScheduler(t, start,
+		configuration.Schedule{Name: "missed", At: "23:00", CatchUp: time.Hour},
+		configuration.Schedule{Name: "too long ago", At: "23:00", CatchUp: 10 * time.Minute},
+		configuration.Schedule{Name: "without catch-up", At: "23:00"},
+		configuration.Schedule{Name: "already ran", At: "23:00", CatchUp: time.Hour, LastRun: time.Date(2026, 10, 16, 23, 0, 5, 0, location)},
+		configuration.Schedule{Name: "missed twice", At: "*/10 * * * *", CatchUp: time.Hour},
+	)
+	ts.Start()
+	ts.waiting(t)
+	// Once each, at startup
+	want := []string{"missed 2026-10-16 23:30 CEST", "missed twice 2026-10-16 23:30 CEST"}
+	if runs := ts.runs(); !slices.Equal(runs, want) {
+		t.Errorf("caught up on %q, want %q", runs, want)
+	}
+	if lastRun := ts.cm.GetConfigSnapshot().Schedules[0].LastRun; !lastRun.Equal(start) {
+		t.Errorf("missed last ran %s, want %s", lastRun, start)
+	}
+
+	// Then on time
+	ts.advance(t, 10*time.Minute)
+	if runs := ts.runs(); !slices.Equal(runs, []string{"missed twice 2026-10-16 23:40 CEST"}) {
+		t.Errorf("ran %q", runs)
+	}
+}
+
+func TestSkipsAfterClockJump(t *testing.T) {
+	ts := newTestScheduler(t, time.Date(2026, 10, 16, 22, 50, 0, 0, berlin(t)),
+		configuration.Schedule{Name: "night", At: "23:00"},
+		configuration.Schedule{Name: "catching up", At: "23:00", CatchUp: 2 * time.Hour},
+	)
+	ts.Start()
+	// Waking up an hour later, like after a suspend
+	ts.waiting(t)
+	ts.clock.Advance(time.Hour)
+	ts.waiting(t)
+	if runs := ts.runs(); !slices.Equal(runs, []string{"catching up 2026-10-16 23:50 CEST"}) {
+		t.Errorf("ran %q, want only the schedule with a catch-up window", runs)
+	}
+
+	// Neither runs again before their next time
+	ts.advance(t, 23*time.Hour+20*time.Minute)
+	want := []string{"night 2026-10-17 23:00 CEST", "catching up 2026-10-17 23:00 CEST"}
+	if runs := ts.runs(); !slices.Equal(runs, want) {
+		t.Errorf("ran %q, want %q", runs, want)
+	}
+}
+
+func TestStop(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Date(2026, 10, 16, 22, 50, 0, 0, time.UTC))
+	s := New(configuration.NewConfigManager(configuration.GetDefaultConfig(), ""), clock, func(configuration.Schedule) {})
+	// Stopping a scheduler that never started does nothing
+	s.Stop()
+
+	s.Start()
+	deadline := time.Now().Add(5 * time.Second)
+	for clock.Waiters() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("scheduler not waiting")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		s.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Stop did not return")
+	}
+}
@@ -0,0 +1,204 @@
+// Package statusbar formats the state of pulsekontrol for status bars like
+// waybar, i3bar and i3blocks: the value of some controls and the default
+// output, printed as a line on every change.
+package statusbar
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/0h41/pulsekontrol/src/actions"
+)
+
+// Formats of Writer
+const (
+	Waybar = "waybar" // A JSON object per line, for a custom module
+	I3bar  = "i3bar"  // The i3bar protocol, an endless JSON array of block lists
+	Text   = "text"   // A plain line, for i3blocks with interval=persist
+)
+
+// Formats lists the supported formats
+var Formats = []string{Waybar, I3bar, Text}
+
+// mutedColor is the color of muted controls in i3bar blocks
+const mutedColor = "#ff5555"
+
+// Status is what the bar shows
+type Status struct {
+	Connected     bool // Whether pulsekontrol is running, nothing else is known without it
+	Controls      []Control
+	DefaultOutput string
+}
+
+// Control is a control shown in the bar
+type Control struct {
+	ID    string
+	Label string
+	Value int
+	Muted bool
+	Found bool // Whether the control exists
+}
+
+// FromState picks the controls with the ids out of the state, in their
+// order, or all of them without ids
+func FromState(state actions.State, ids []string) Status {
+	status := Status{Connected: true, DefaultOutput: state.DefaultOutput}
+	if len(ids) == 0 {
+		for _, control := range state.Controls {
+			ids = append(ids, control.ControlID)
+		}
+	}
+	for _, id := range ids {
+		control := Control{ID: id, Label: id}
+		for _, assignment := range state.Controls {
+			if assignment.ControlID != id {
+				continue
+			}
+			control.Value, control.Muted, control.Found = assignment.Value, assignment.Muted, true
+			if assignment.Label != "" {
+				control.Label = assignment.Label
+			}
+			break
+		}
+		status.Controls = append(status.Controls, control)
+	}
+	return status
+}
+
+// text describes a control like "Music 40%" or "Mic muted"
+func (control Control) text() string {
+	switch {
+	case !control.Found:
+		return control.Label + " ?"
+	case control.Muted:
+		return control.Label + " muted"
+	default:
+		return fmt.Sprintf("%s %d%%", control.Label, control.Value)
+	}
+}
+
+// parts returns the texts of the controls and the default output
+func (status Status) parts() []string {
+	if !status.Connected {
+		return []string{"pulsekontrol not running"}
+	}
+	parts := make([]string, 0, len(status.Controls)+1)
+	for _, control := range status.Controls {
+		parts = append(parts, control.text())
+	}
+	if status.DefaultOutput != "" {
+		parts = append(parts, status.DefaultOutput)
+	}
+	return parts
+}
+
+// Writer prints each status as a line in a format
+type Writer struct {
+	out     io.Writer
+	format  string
+	started bool
+}
+
+// NewWriter creates a writer for one of the Formats
+func NewWriter(out io.Writer, format string) (*Writer, error) {
+	switch format {
+	case Waybar, I3bar, Text:
+		return &Writer{out: out, format: format}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q, expected %s", format, strings.Join(Formats, ", "))
+}
+
+// Write prints a status
+func (w *Writer) Write(status Status) error {
+	var line []byte
+	var err error
+	switch w.format {
+	case Waybar:
+		line, err = FormatWaybar(status)
+	case I3bar:
+		line, err = FormatI3bar(status)
+		if err == nil && !w.started {
+			// The header, then the array that never ends
+			line = append([]byte("{\"version\":1}\n[\n"), line...)
+		} else if err == nil {
+			line = append([]byte{','}, line...)
+		}
+	default:
+		line = []byte(FormatText(status))
+	}
+	if err != nil {
+		return err
+	}
+	w.started = true
+	_, err = w.out.Write(append(line, '\n'))
+	return err
+}
+
+// waybarStatus is the JSON a waybar custom module reads with return-type json
+type waybarStatus struct {
+	Text       string   `json:"text"`
+	Tooltip    string   `json:"tooltip"`
+	Class      []string `json:"class"`
+	Percentage int      `json:"percentage"`
+}
+
+// FormatWaybar returns a status as a waybar JSON object. Its class is muted
+// if a control is muted and disconnected without pulsekontrol, its
+// percentage the value of the first control for format-icons.
+func FormatWaybar(status Status) ([]byte, error) {
+	parts := status.parts()
+	out := waybarStatus{
+		Text:    strings.Join(parts, " | "),
+		Tooltip: strings.Join(parts, "\n"),
+		Class:   []string{},
+	}
+	if !status.Connected {
+		out.Class = append(out.Class, "disconnected")
+	}
+	for _, control := range status.Controls {
+		if control.Muted {
+			out.Class = append(out.Class, "muted")
+			break
+		}
+	}
+	if len(status.Controls) > 0 {
+		out.Percentage = status.Controls[0].Value
+	}
+	return json.Marshal(out)
+}
+
+// i3barBlock is a block of the i3bar protocol
+type i3barBlock struct {
+	FullText string `json:"full_text"`
+	Name     string `json:"name"`
+	Instance string `json:"instance,omitempty"`
+	Color    string `json:"color,omitempty"`
+}
+
+// FormatI3bar returns a status as the list of i3bar blocks of a line: one
+// per control, red while muted, and one for the default output
+func FormatI3bar(status Status) ([]byte, error) {
+	blocks := []i3barBlock{}
+	if !status.Connected {
+		blocks = append(blocks, i3barBlock{FullText: status.parts()[0], Name: "pulsekontrol"})
+		return json.Marshal(blocks)
+	}
+	for _, control := range status.Controls {
+		block := i3barBlock{FullText: control.text(), Name: "pulsekontrol", Instance: control.ID}
+		if control.Muted {
+			block.Color = mutedColor
+		}
+		blocks = append(blocks, block)
+	}
+	if status.DefaultOutput != "" {
+		blocks = append(blocks, i3barBlock{FullText: status.DefaultOutput, Name: "pulsekontrol", Instance: "output"})
+	}
+	return json.Marshal(blocks)
+}
+
+// FormatText returns a status as a plain line
+func FormatText(status Status) string {
+	return strings.Join(status.parts(), " | ")
+}
@@ -0,0 +1,170 @@
+package statusbar
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/0h41/pulsekontrol/src/actions"
+	"github.com/0h41/pulsekontrol/src/configuration"
+)
+
+var (
+	// state has a labelled slider, a muted knob and a default output
+	state = actions.State{
+		Controls: []configuration.ControlAssignment{
+			{ControlType: "slider", ControlID: "slider1", Label: "Music", Value: 40},
+			{ControlType: "slider", ControlID: "slider2", Value: 75},
+			{ControlType: "knob", ControlID: "knob3", Label: "Mic", Value: 60, Muted: true},
+		},
+		DefaultOutput: "Headphones",
+	}
+	// status shows slider1, knob3 and a control that does not exist
+	status = FromState(state, []string{"slider1", "knob3", "slider9"})
+)
+
+func TestFromState(t *testing.T) {
+	want := Status{
+		Connected: true,
+		Controls: []Control{
+			{ID: "slider1", Label: "Music", Value: 40, Found: true},
+			{ID: "knob3", Label: "Mic", Value: 60, Muted: true, Found: true},
+			{ID: "slider9", Label: "slider9"},
+		},
+		DefaultOutput: "Headphones",
+	}
+	if !reflect.DeepEqual(status, want) {
+		t.Errorf("status is %+v, want %+v", status, want)
+	}
+
+	// Without ids every control in order
+	all := FromState(state, nil)
+	if len(all.Controls) != 3 || all.Controls[1].ID != "slider2" || all.Controls[1].Label != "slider2" {
+		t.Errorf("all controls are %+v", all.Controls)
+	}
+}
+
+func TestFormatText(t *testing.T) {
+	for _, test := range []struct {
+		status Status
+		line   string
+	}{
+		{status, "Music 40% | Mic muted | slider9 ? | Headphones"},
+		{Status{Connected: true, Controls: status.Controls[:1]}, "Music 40%"},
+		{Status{Connected: true}, ""},
+		{Status{}, "pulsekontrol not running"},
+	} {
+		if line := FormatText(test.status); line != test.line {
+			t.Errorf("%+v is %q, want %q", test.status, line, test.line)
+		}
+	}
+}
+
+func TestFormatWaybar(t *testing.T) {
+	for _, test := range []struct {
+		status Status
+		json   string
+	}{
+		{status, `{"text":"Music 40% | Mic muted | slider9 ? | Headphones","tooltip":"Music 40%\nMic muted\nslider9 ?\nHeadphones","class":["muted"],"percentage":40}`},
+		{Status{Connected: true, Controls: status.Controls[:1]}, `{"text":"Music 40%","tooltip":"Music 40%","class":[],"percentage":40}`},
+		{Status{}, `{"text":"pulsekontrol not running","tooltip":"pulsekontrol not running","class":["disconnected"],"percentage":0}`},
+	} {
+		line, err := FormatWaybar(test.status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(line) != test.json {
+			t.Errorf("%+v is\n%s\nwant\n%s", test.status, line, test.json)
+		}
+	}
+}
+
+func TestFormatI3bar(t *testing.T) {
+	for _, test := range []struct {
+		status Status
+		json   string
+	}{
+		{status, `[{"full_text":"Music 40%","name":"pulsekontrol","instance":"slider1"},` +
+			`{"full_text":"Mic muted","name":"pulsekontrol","instance":"knob3","color":"#ff5555"},` +
+			`{"full_text":"slider9 ?","name":"pulsekontrol","instance":"slider9"},` +
+			`{"full_text":"Headphones","name":"pulsekontrol","instance":"output"}]`},
+		{Status{Connected: true}, `[]`},
+		{Status{}, `[{"full_text":"pulsekontrol not running","name":"pulsekontrol"}]`},
+	} {
+		line, err := FormatI3bar(test.status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(line) != test.json {
+			t.Errorf("%+v is\n%s\nwant\n%s", test.status, line, test.json)
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	// Waybar and i3blocks read a line per status
+	for format, want := range map[string]string{
+		Waybar: `{"text":"pulsekontrol not running","tooltip":"pulsekontrol not running","class":["disconnected"],"percentage":0}` + "\n" +
+			`{"text":"Headphones","tooltip":"Headphones","class":[],"percentage":0}` + "\n",
+		Text: "pulsekontrol not running\nHeadphones\n",
+	} {
+		var out bytes.Buffer
+		writer, err := NewWriter(&out, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer.Write(Status{})
+		writer.Write(Status{Connected: true, DefaultOutput: "Headphones"})
+		if out.String() != want {
+			t.Errorf("%s printed\n%s\nwant\n%s", format, out.String(), want)
+		}
+	}
+
+	// i3bar reads a header and an endless array
+	var out bytes.Buffer
+	writer, err := NewWriter(&out, I3bar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := writer.Write(status); err != nil {
+			t.Fatal(err)
+		}
+	}
+	header, body, _ := strings.Cut(out.String(), "\n")
+	var version struct{ Version int }
+	if err := json.Unmarshal([]byte(header), &version); err != nil || version.Version != 1 {
+		t.Errorf("header %q", header)
+	}
+	var lines [][]map[string]string
+	if err := json.Unmarshal([]byte(body+"]"), &lines); err != nil {
+		t.Fatalf("printed an invalid array %s: %v", body, err)
+	}
+	if len(lines) != 3 || len(lines[2]) != 4 || lines[2][1]["color"] != mutedColor {
+		t.Errorf("printed %v", lines)
+	}
+	if strings.Count(body, "\n") != 4 {
+		t.Errorf("printed\n%s\nwant a line per status", body)
+	}
+
+	if _, err := NewWriter(&out, "polybar"); err == nil || !strings.Contains(err.Error(), "waybar, i3bar, text") {
+		t.Errorf("unknown format returned %v", err)
+	}
+}
+
+// failingWriter fails every write
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("closed")
+}
+
+func TestWriteFails(t *testing.T) {
+	writer, _ := NewWriter(failingWriter{}, Waybar)
+	if err := writer.Write(status); err == nil {
+		t.Error("failed write returned no error")
+	}
+}
@@ -0,0 +1,347 @@
+// Package streamdeck uses an Elgato Stream Deck as buttons. Its keys run
+// actions like the buttons of the MIDI device, and their images are drawn
+// here: the label of the key and, for keys muting a control, a bar showing
+// whether the control is muted and its volume, redrawn when they change.
+// The Stream Deck may be plugged in and out while running.
+package streamdeck
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/activity"
+	"github.com/0h41/pulsekontrol/src/clock"
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/0h41/pulsekontrol/src/logging"
+	"github.com/0h41/pulsekontrol/src/supervise"
+	"github.com/rs/zerolog"
+)
+
+// DefaultRetryInterval is how often a Stream Deck that is missing or was
+// unplugged is looked for
+const DefaultRetryInterval = 5 * time.Second
+
+// ActionRunner runs the actions of a key for a press or release, like the
+// MIDI client does for its buttons. path identifies the key.
+type ActionRunner func(origin activity.Origin, path string, actions []configuration.Action, pressed bool)
+
+// refreshTopics are the notifications after which key images may differ
+var refreshTopics = []string{
+	"control.muted.updated",
+	"control.value.updated",
+	"config.merged",
+	"profile.switched",
+	"control.moved",
+	"history.applied",
+}
+
+// Deck drives the Stream Deck. The keys are looked up on each press, so
+// changes apply right away.
+type Deck struct {
+	log           zerolog.Logger
+	configManager *configuration.ConfigManager
+	clock         clock.Clock
+	run           ActionRunner
+
+	refresh       chan struct{}
+	subscriptions []configuration.Subscription
+	stop          chan struct{}
+	done          chan struct{}
+
+	mu     sync.Mutex
+	device *hidDevice
+}
+
+// NewDeck creates the Stream Deck, which is looked for once started
+func NewDeck(configManager *configuration.ConfigManager, clock clock.Clock, run ActionRunner) *Deck {
+	return &Deck{
+		log:           logging.Module("StreamDeck"),
+		configManager: configManager,
+		clock:         clock,
+		run:           run,
+		refresh:       make(chan struct{}, 1),
+	}
+}
+
+// Start looks for the Stream Deck and uses it until Stop
+func (d *Deck) Start() {
+	for _, topic := range refreshTopics {
+		d.subscriptions = append(d.subscriptions, d.configManager.Subscribe(topic, func(interface{}) {
+			d.Refresh()
+		}))
+	}
+	d.stop = make(chan struct{})
+	d.done = make(chan struct{})
+	supervise.Go("streamdeck", func() {
+		d.loop()
+		close(d.done)
+	})
+}
+
+// Stop closes the Stream Deck and waits for it to be released
+func (d *Deck) Stop() {
+	if d.stop == nil {
+		return
+	}
+	for _, subscription := range d.subscriptions {
+		d.configManager.Unsubscribe(subscription)
+	}
+	close(d.stop)
+	d.mu.Lock()
+	if d.device != nil {
+		d.device.close()
+	}
+	d.mu.Unlock()
+	<-d.done
+}
+
+// Refresh redraws the keys whose image changed
+func (d *Deck) Refresh() {
+	select {
+	case d.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// loop opens the Stream Deck and uses it until it is unplugged, then looks
+// for it again
+func (d *Deck) loop() {
+	var reported error
+	for {
+		device, model, info, err := d.open()
+		if err == nil {
+			reported = nil
+			d.log.Info().Str("model", model.Name).Str("serial", info.serial).Msg("Stream Deck connected")
+			d.configManager.Notify("streamdeck.connected", map[string]interface{}{"model": model.Name, "serial": info.serial})
+			err = d.serve(device, model)
+			select {
+			case <-d.stop:
+				return
+			default:
+			}
+			d.log.Warn().Err(err).Str("serial", info.serial).Msg("Stream Deck disconnected, looking for it until it is plugged in again")
+			d.configManager.Notify("streamdeck.disconnected", map[string]interface{}{"model": model.Name, "serial": info.serial, "error": err.Error()})
+		} else if reported == nil || reported.Error() != err.Error() {
+			reported = err
+			d.reportOpenError(err)
+		}
+
+		select {
+		case <-d.stop:
+			return
+		case <-d.clock.After(d.retryInterval()):
+		}
+	}
+}
+
+// errNotFound is returned by open while no supported Stream Deck is plugged in
+var errNotFound = errors.New("no Stream Deck found")
+
+// open finds and opens the configured Stream Deck, or the first one
+func (d *Deck) open() (*hidDevice, Model, hidInfo, error) {
+	devices, err := findDevices()
+	if err != nil {
+		return nil, Model{}, hidInfo{}, fmt.Errorf("cannot list the HID devices: %w", err)
+	}
+	serial := d.configManager.GetConfigSnapshot().StreamDeck.Serial
+	for _, info := range devices {
+		if serial != "" && info.serial != serial {
+			continue
+		}
+		model, ok := models[info.product]
+		if !ok {
+			d.log.Debug().Str("device", info.path).Str("product", fmt.Sprintf("%04x", info.product)).Msg("Unsupported Elgato device")
+			continue
+		}
+		device, err := openDevice(info.path)
+		if err != nil {
+			return nil, Model{}, hidInfo{}, fmt.Errorf("cannot open %s: %w", info.path, err)
+		}
+		d.mu.Lock()
+		select {
+		case <-d.stop:
+			d.mu.Unlock()
+			device.close()
+			return nil, Model{}, hidInfo{}, errors.New("stopped")
+		default:
+		}
+		d.device = device
+		d.mu.Unlock()
+		return device, model, info, nil
+	}
+	return nil, Model{}, hidInfo{}, errNotFound
+}
+
+// reportOpenError logs why the Stream Deck can't be used, once until it
+// changes
+func (d *Deck) reportOpenError(err error) {
+	switch {
+	case errors.Is(err, errNotFound):
+		d.log.Warn().Dur("retryInterval", d.retryInterval()).Msg("No supported Stream Deck found, looking for it until it is plugged in")
+	case errors.Is(err, fs.ErrPermission):
+		d.log.Error().Err(err).Msg(`Not allowed to open the Stream Deck, add a udev rule like SUBSYSTEM=="hidraw", ATTRS{idVendor}=="0fd9", TAG+="uaccess" and plug it in again`)
+	default:
+		d.log.Error().Err(err).Msg("Cannot open the Stream Deck")
+	}
+}
+
+func (d *Deck) retryInterval() time.Duration {
+	if interval := d.configManager.GetConfigSnapshot().StreamDeck.RetryInterval; interval > 0 {
+		return interval
+	}
+	return DefaultRetryInterval
+}
+
+// serve draws the keys and runs the actions of presses until the Stream
+// Deck fails or Stop closes it
+func (d *Deck) serve(device *hidDevice, model Model) error {
+	defer func() {
+		d.mu.Lock()
+		d.device = nil
+		d.mu.Unlock()
+		device.close()
+	}()
+
+	config := d.configManager.GetConfigSnapshot().StreamDeck
+	brightness := configuration.DefaultStreamDeckBrightness
+	if config.Brightness != nil {
+		brightness = *config.Brightness
+	}
+	if err := device.sendFeature(resetReport()); err != nil {
+		return fmt.Errorf("reset failed: %w", err)
+	}
+	if err := device.sendFeature(brightnessReport(brightness)); err != nil {
+		return fmt.Errorf("setting the brightness failed: %w", err)
+	}
+	for number := range config.Keys {
+		if number >= model.Keys {
+			d.log.Warn().Int("key", number).Str("model", model.Name).Msgf("Key %d does not exist, the Stream Deck has %d keys", number, model.Keys)
+		}
+	}
+
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- d.read(device, model)
+	}()
+
+	shown := make([]*Face, model.Keys)
+	for {
+		if err := d.draw(device, model, shown); err != nil {
+			device.close()
+			<-readErr
+			return err
+		}
+		select {
+		case err := <-readErr:
+			return err
+		case <-d.refresh:
+		}
+	}
+}
+
+// draw sends the images of the keys whose face differs from the one shown
+func (d *Deck) draw(device *hidDevice, model Model, shown []*Face) error {
+	config := d.configManager.GetConfigSnapshot()
+	for number, face := range Faces(&config, model.Keys) {
+		if shown[number] != nil && *shown[number] == face {
+			continue
+		}
+		image, err := encodeKey(Render(face, model.ImageSize))
+		if err != nil {
+			return err
+		}
+		for _, report := range imageReports(number, image) {
+			if err := device.write(report); err != nil {
+				return fmt.Errorf("sending the image of key %d failed: %w", number, err)
+			}
+		}
+		shown[number] = &face
+	}
+	return nil
+}
+
+// read runs the actions of pressed and released keys until the Stream Deck
+// fails. Keys still down are released then, so that stepping stops.
+func (d *Deck) read(device *hidDevice, model Model) error {
+	down := make([]bool, model.Keys)
+	held := make(map[int]configuration.StreamDeckKey)
+	defer func() {
+		for number, key := range held {
+			d.run(activity.StreamDeck(), keyPath(number), key.Actions, false)
+		}
+	}()
+
+	report := make([]byte, 512)
+	for {
+		n, err := device.read(report)
+		if err != nil {
+			return err
+		}
+		states, err := model.keyStates(report[:n])
+		if err != nil {
+			d.log.Debug().Err(err).Msg("Ignoring input report")
+			continue
+		}
+		for number, pressed := range states {
+			if pressed == down[number] {
+				continue
+			}
+			down[number] = pressed
+			if !pressed {
+				if key, ok := held[number]; ok {
+					delete(held, number)
+					d.run(activity.StreamDeck(), keyPath(number), key.Actions, false)
+				}
+				continue
+			}
+			key, ok := d.configManager.GetConfigSnapshot().StreamDeck.Keys[number]
+			if !ok {
+				continue
+			}
+			d.log.Debug().Int("key", number).Msg("Stream Deck key pressed")
+			held[number] = key
+			d.run(activity.StreamDeck(), keyPath(number), key.Actions, true)
+		}
+	}
+}
+
+// Faces returns what the keys of a Stream Deck with the number of keys show
+// for the configuration. Keys that are not configured stay dark.
+func Faces(config *configuration.Config, keys int) []Face {
+	faces := make([]Face, keys)
+	for number, key := range config.StreamDeck.Keys {
+		if number < 0 || number >= keys {
+			continue
+		}
+		face := Face{Label: key.Label}
+		for _, action := range key.Actions {
+			target, ok := action.MuteControl(config)
+			if !ok {
+				continue
+			}
+			if control, ok := config.Assignment(target.ControlID); ok {
+				face.HasState, face.Muted, face.Level = true, control.Muted, control.Value
+				if face.Label == "" {
+					face.Label = control.Label
+				}
+				if face.Label == "" {
+					face.Label = control.ControlID
+				}
+			}
+			break
+		}
+		if face.Label == "" && len(key.Actions) > 0 {
+			face.Label = string(key.Actions[0].Type)
+		}
+		faces[number] = face
+	}
+	return faces
+}
+
+// keyPath names a key in the action history
+func keyPath(number int) string {
+	return fmt.Sprintf("streamdeck/%d", number)
+}
@@ -0,0 +1,329 @@
+package streamdeck
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/activity"
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/0h41/pulsekontrol/src/internal/testutil"
+)
+
+// testConfig returns the default configuration with keys muting slider1,
+// muting a playback stream and playing media
+func testConfig(t *testing.T) configuration.Config {
+	t.Helper()
+	config, err := configuration.Prepare(configuration.GetDefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	slider := config.Controls.Sliders["slider1"]
+	slider.Label, slider.Value = "Music", 70
+	config.Controls.Sliders["slider1"] = slider
+	config.StreamDeck.Keys = map[int]configuration.StreamDeckKey{
+		0:  {Actions: []configuration.Action{{Type: configuration.ToggleMute, Target: &configuration.Target{Name: "slider1"}}}},
+		1:  {Label: "Knob", Actions: []configuration.Action{{Type: configuration.ToggleMute, Target: &configuration.ControlTarget{ControlType: "knob", ControlID: "knob1"}}}},
+		2:  {Actions: []configuration.Action{{Type: configuration.ToggleMute, Target: &configuration.TypedTarget{Type: configuration.PlaybackStream, Name: "Firefox"}}}},
+		3:  {Label: "Play", Actions: []configuration.Action{{Type: configuration.MediaPlayPause}}},
+		20: {Label: "Missing"},
+	}
+	return config
+}
+
+func TestFaces(t *testing.T) {
+	config := testConfig(t)
+	faces := Faces(&config, 15)
+	if len(faces) != 15 {
+		t.Fatalf("%d faces for 15 keys", len(faces))
+	}
+	for number, want := range map[int]Face{
+		0: {Label: "Music", HasState: true, Level: 70},
+		1: {Label: "Knob", HasState: true, Level: 50},
+		2: {Label: "ToggleMute"},
+		3: {Label: "Play"},
+		4: {},
+	} {
+		if faces[number] != want {
+			t.Errorf("key %d shows %+v, want %+v", number, faces[number], want)
+		}
+	}
+
+	// Without a label the id of the control is shown
+	slider := config.Controls.Sliders["slider1"]
+	slider.Label, slider.Muted = "", true
+	config.Controls.Sliders["slider1"] = slider
+	if face := Faces(&config, 15)[0]; face != (Face{Label: "slider1", HasState: true, Muted: true, Level: 70}) {
+		t.Errorf("muted key shows %+v", face)
+	}
+	// Keys beyond those of the model are left out
+	if faces := Faces(&config, 32); faces[20].Label != "Missing" {
+		t.Errorf("key 20 of an XL shows %+v", faces[20])
+	}
+}
+
+// press is a run of the actions of a key
+type press struct {
+	origin  activity.Origin
+	path    string
+	actions []configuration.Action
+	pressed bool
+}
+
+// newTestDeck returns a deck on the configuration recording the presses
+func newTestDeck(t *testing.T, config configuration.Config) (*Deck, *configuration.ConfigManager, chan press) {
+	t.Helper()
+	cm := configuration.NewConfigManager(config, filepath.Join(t.TempDir(), "config.yaml"))
+	cm.SetReadOnly(true)
+	presses := make(chan press, 10)
+	deck := NewDeck(cm, testutil.NewFakeClock(time.Now()), func(origin activity.Origin, path string, actions []configuration.Action, pressed bool) {
+		presses <- press{origin, path, actions, pressed}
+	})
+	return deck, cm, presses
+}
+
+// nextPress returns the next run of actions
+func nextPress(t *testing.T, presses chan press) press {
+	t.Helper()
+	select {
+	case p := <-presses:
+		return p
+	case <-time.After(time.Second):
+		t.Fatal("no key handled")
+		return press{}
+	}
+}
+
+func TestKeyPresses(t *testing.T) {
+	config := testConfig(t)
+	deck, _, presses := newTestDeck(t, config)
+	model := models[0x0080]
+	// Like hidraw, a read returns a single report
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_SEQPACKET, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	syscall.SetNonblock(fds[0], true)
+	device := &hidDevice{file: os.NewFile(uintptr(fds[0]), "hidraw")}
+	defer device.close()
+	writer := os.NewFile(uintptr(fds[1]), "deck")
+	done := make(chan error, 1)
+	go func() { done <- deck.read(device, model) }()
+
+	send := func(report []byte) {
+		t.Helper()
+		if _, err := writer.Write(report); err != nil {
+			t.Fatal(err)
+		}
+	}
+	send(keyReport(model, 3))
+	if p := nextPress(t, presses); p.path != "streamdeck/3" || !p.pressed || p.origin.Kind != activity.OriginDeck || p.actions[0].Type != configuration.MediaPlayPause {
+		t.Errorf("press handled as %+v", p)
+	}
+	// Unconfigured keys and other reports are ignored, holding a key too
+	send(keyReport(model, 3, 7))
+	send([]byte{0x02, 0, 0})
+	send(keyReport(model, 7))
+	if p := nextPress(t, presses); p.path != "streamdeck/3" || p.pressed {
+		t.Errorf("release handled as %+v", p)
+	}
+	send(keyReport(model, 0, 7))
+	if p := nextPress(t, presses); p.path != "streamdeck/0" || !p.pressed {
+		t.Errorf("press handled as %+v", p)
+	}
+
+	// Keys still down are released once the Stream Deck is gone
+	writer.Close()
+	select {
+	case err := <-done:
+		if err != io.EOF {
+			t.Errorf("reading stopped with %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reading did not stop")
+	}
+	if p := nextPress(t, presses); p.path != "streamdeck/0" || p.pressed {
+		t.Errorf("unplugging handled as %+v", p)
+	}
+	select {
+	case p := <-presses:
+		t.Errorf("also handled %+v", p)
+	default:
+	}
+}
+
+// imageSink reads the image reports sent to a device and collects the keys
+// whose image was sent completely
+type imageSink struct {
+	mu   sync.Mutex
+	keys []int
+}
+
+func (s *imageSink) drain(reader io.Reader) {
+	report := make([]byte, imageReportSize)
+	for {
+		if _, err := io.ReadFull(reader, report); err != nil {
+			return
+		}
+		if report[0] == 0x02 && report[1] == 0x07 && report[3] == 1 {
+			s.mu.Lock()
+			s.keys = append(s.keys, int(report[2]))
+			s.mu.Unlock()
+		}
+	}
+}
+
+// sent returns and forgets the keys sent so far
+func (s *imageSink) sent() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := s.keys
+	s.keys = nil
+	slices.Sort(keys)
+	return keys
+}
+
+func TestDrawSendsChangedKeys(t *testing.T) {
+	deck, cm, _ := newTestDeck(t, testConfig(t))
+	model := models[0x0080]
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	device := &hidDevice{file: writer}
+	sink := &imageSink{}
+	drained := make(chan struct{})
+	go func() {
+		sink.drain(reader)
+		close(drained)
+	}()
+	// settle returns the keys sent once as many as expected were read, and
+	// a little while later none more
+	settle := func(expected int) []int {
+		deadline := time.Now().Add(time.Second)
+		for {
+			sink.mu.Lock()
+			n := len(sink.keys)
+			sink.mu.Unlock()
+			if n >= expected || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		time.Sleep(20 * time.Millisecond)
+		return sink.sent()
+	}
+
+	shown := make([]*Face, model.Keys)
+	if err := deck.draw(device, model, shown); err != nil {
+		t.Fatal(err)
+	}
+	if keys := settle(model.Keys); len(keys) != model.Keys {
+		t.Errorf("first draw sent keys %v, want all %d", keys, model.Keys)
+	}
+	if err := deck.draw(device, model, shown); err != nil {
+		t.Fatal(err)
+	}
+	if keys := settle(0); len(keys) != 0 {
+		t.Errorf("unchanged keys %v sent again", keys)
+	}
+
+	if err := cm.SetControlMuted("slider", "slider1", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := deck.draw(device, model, shown); err != nil {
+		t.Fatal(err)
+	}
+	if keys := settle(1); !slices.Equal(keys, []int{0}) {
+		t.Errorf("muting slider1 sent keys %v, want 0", keys)
+	}
+	if !shown[0].Muted {
+		t.Errorf("key 0 shown as %+v", shown[0])
+	}
+
+	// A failed write is returned
+	writer.Close()
+	<-drained
+	if err := deck.draw(device, model, make([]*Face, model.Keys)); err == nil {
+		t.Error("drawing on a closed device succeeded")
+	}
+}
+
+func TestParseUevent(t *testing.T) {
+	parse := func(content string) (hidInfo, bool) {
+		t.Helper()
+		path := filepath.Join(t.TempDir(), "uevent")
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		return parseUevent(file)
+	}
+
+	info, ok := parse("DRIVER=hid-generic\nHID_ID=0003:00000FD9:00000080\nHID_NAME=Elgato Systems Stream Deck MK.2\nHID_UNIQ=AL12H1A00000\n")
+	if !ok || info.product != 0x0080 || info.serial != "AL12H1A00000" {
+		t.Errorf("Stream Deck parsed as %+v, %v", info, ok)
+	}
+	if model := models[info.product]; model.Keys != 15 {
+		t.Errorf("product %04x is %+v", info.product, model)
+	}
+	for _, content := range []string{
+		"HID_ID=0003:0000046D:0000C52B\nHID_UNIQ=\n",
+		"HID_ID=0003:00000FD9\n",
+		"HID_ID=0003:elgato:0080\n",
+		"HID_UNIQ=AL12H1A00000\n",
+		"",
+	} {
+		if info, ok := parse(content); ok {
+			t.Errorf("%q parsed as %+v", content, info)
+		}
+	}
+}
+
+func TestStopWhileLooking(t *testing.T) {
+	config := testConfig(t)
+	config.StreamDeck.Serial = "none-such"
+	deck, _, _ := newTestDeck(t, config)
+	clock := deck.clock.(*testutil.FakeClock)
+
+	// Stopping a deck that never started does nothing
+	deck.Stop()
+
+	deck.Start()
+	deadline := time.Now().Add(time.Second)
+	for clock.Waiters() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("not waiting to look again")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// Looking again after the retry interval
+	clock.Advance(DefaultRetryInterval)
+	for clock.Waiters() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("not looking again")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		deck.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Stop did not return")
+	}
+}
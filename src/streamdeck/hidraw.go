@@ -0,0 +1,132 @@
+package streamdeck
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// There is no HID library without cgo, so the Stream Deck is used through
+// the Linux hidraw devices directly: reports are read and written on the
+// device file, feature reports go through ioctls.
+
+// elgatoVendor is the USB vendor id of Elgato
+const elgatoVendor = 0x0fd9
+
+// hidrawClass lists the hidraw devices with their HID ids in device/uevent
+const hidrawClass = "/sys/class/hidraw"
+
+// hidInfo describes a hidraw device of an Elgato product
+type hidInfo struct {
+	path    string // Device file, like /dev/hidraw3
+	product uint16
+	serial  string
+}
+
+// findDevices returns the hidraw devices of Elgato products
+func findDevices() ([]hidInfo, error) {
+	entries, err := os.ReadDir(hidrawClass)
+	if err != nil {
+		return nil, err
+	}
+	var devices []hidInfo
+	for _, entry := range entries {
+		file, err := os.Open(filepath.Join(hidrawClass, entry.Name(), "device", "uevent"))
+		if err != nil {
+			continue
+		}
+		info, ok := parseUevent(file)
+		file.Close()
+		if ok {
+			info.path = filepath.Join("/dev", entry.Name())
+			devices = append(devices, info)
+		}
+	}
+	return devices, nil
+}
+
+// parseUevent reads the product and serial of an Elgato device from lines
+// like HID_ID=0003:00000FD9:0000006D and HID_UNIQ=AL12H1A00000
+func parseUevent(file *os.File) (hidInfo, bool) {
+	var info hidInfo
+	elgato := false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), "=")
+		switch key {
+		case "HID_ID":
+			parts := strings.Split(value, ":")
+			if len(parts) != 3 {
+				return hidInfo{}, false
+			}
+			vendor, err := strconv.ParseUint(parts[1], 16, 32)
+			if err != nil {
+				return hidInfo{}, false
+			}
+			product, err := strconv.ParseUint(parts[2], 16, 32)
+			if err != nil {
+				return hidInfo{}, false
+			}
+			elgato = vendor == elgatoVendor
+			info.product = uint16(product)
+		case "HID_UNIQ":
+			info.serial = value
+		}
+	}
+	return info, elgato
+}
+
+// hidDevice is an open hidraw device
+type hidDevice struct {
+	file *os.File
+}
+
+func openDevice(path string) (*hidDevice, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &hidDevice{file: file}, nil
+}
+
+// read reads an input report, starting with its report id
+func (d *hidDevice) read(report []byte) (int, error) {
+	return d.file.Read(report)
+}
+
+// write writes an output report, starting with its report id
+func (d *hidDevice) write(report []byte) error {
+	_, err := d.file.Write(report)
+	return err
+}
+
+// sendFeature sends a feature report, starting with its report id, like
+// the HIDIOCSFEATURE ioctl of linux/hidraw.h
+func (d *hidDevice) sendFeature(report []byte) error {
+	const iocReadWrite = 3
+	request := uintptr(iocReadWrite<<30 | len(report)<<16 | 'H'<<8 | 0x06)
+	// Fd would make reads blocking, which closing no longer interrupts
+	conn, err := d.file.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	err = conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(unsafe.Pointer(&report[0])))
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (d *hidDevice) close() error {
+	return d.file.Close()
+}
@@ -0,0 +1,79 @@
+package streamdeck
+
+import "fmt"
+
+// Model describes a Stream Deck with the protocol of the second generation,
+// JPEG key images sent in pages of 1024 byte reports
+type Model struct {
+	Name      string
+	Keys      int
+	Columns   int
+	ImageSize int // Width and height of the key images in pixels
+}
+
+// models are the supported Stream Decks by USB product id. The Mini and the
+// first Stream Deck use another protocol and are not supported.
+var models = map[uint16]Model{
+	0x006d: {Name: "Stream Deck Original V2", Keys: 15, Columns: 5, ImageSize: 72},
+	0x0080: {Name: "Stream Deck MK.2", Keys: 15, Columns: 5, ImageSize: 72},
+	0x00a5: {Name: "Stream Deck MK.2", Keys: 15, Columns: 5, ImageSize: 72},
+	0x006c: {Name: "Stream Deck XL", Keys: 32, Columns: 8, ImageSize: 96},
+	0x008f: {Name: "Stream Deck XL", Keys: 32, Columns: 8, ImageSize: 96},
+}
+
+const (
+	// imageReportSize is the size of the output reports carrying images
+	imageReportSize = 1024
+	// imageHeaderSize is the part of an image report before the image data
+	imageHeaderSize = 8
+	// featureReportSize is the size of the feature reports
+	featureReportSize = 32
+)
+
+// resetReport shows the logo and clears the key images
+func resetReport() []byte {
+	report := make([]byte, featureReportSize)
+	report[0], report[1] = 0x03, 0x02
+	return report
+}
+
+// brightnessReport sets the backlight in percent
+func brightnessReport(percent int) []byte {
+	report := make([]byte, featureReportSize)
+	report[0], report[1], report[2] = 0x03, 0x08, byte(min(max(percent, 0), 100))
+	return report
+}
+
+// imageReports splits a JPEG image of a key into output reports. Each starts
+// with the key, whether it is the last page, the length of its data and the
+// page number, and is padded to the full size.
+func imageReports(key int, image []byte) [][]byte {
+	const pageSize = imageReportSize - imageHeaderSize
+	var reports [][]byte
+	for page := 0; page == 0 || page*pageSize < len(image); page++ {
+		data := image[page*pageSize:]
+		last := byte(1)
+		if len(data) > pageSize {
+			data, last = data[:pageSize], 0
+		}
+		report := make([]byte, imageReportSize)
+		copy(report, []byte{0x02, 0x07, byte(key), last, byte(len(data)), byte(len(data) >> 8), byte(page), byte(page >> 8)})
+		copy(report[imageHeaderSize:], data)
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+// keyStates returns which keys are down in an input report, which has the
+// report id and three more bytes before a byte per key
+func (m Model) keyStates(report []byte) ([]bool, error) {
+	const offset = 4
+	if len(report) < offset+m.Keys || report[0] != 0x01 {
+		return nil, fmt.Errorf("unexpected input report of %d bytes", len(report))
+	}
+	states := make([]bool, m.Keys)
+	for i := range states {
+		states[i] = report[offset+i] != 0
+	}
+	return states, nil
+}
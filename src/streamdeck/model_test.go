@@ -0,0 +1,90 @@
+package streamdeck
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestImageReports(t *testing.T) {
+	const pageSize = imageReportSize - imageHeaderSize
+	image := make([]byte, 2*pageSize+100)
+	for i := range image {
+		image[i] = byte(i)
+	}
+	reports := imageReports(11, image)
+	if len(reports) != 3 {
+		t.Fatalf("split into %d reports, want 3", len(reports))
+	}
+	var joined []byte
+	for page, report := range reports {
+		if len(report) != imageReportSize {
+			t.Errorf("report %d has %d bytes", page, len(report))
+		}
+		length := int(report[4]) | int(report[5])<<8
+		last := byte(0)
+		if page == 2 {
+			last = 1
+		}
+		header := []byte{0x02, 0x07, 11, last, byte(length), byte(length >> 8), byte(page), 0}
+		if !bytes.Equal(report[:imageHeaderSize], header) {
+			t.Errorf("report %d has header % x, want % x", page, report[:imageHeaderSize], header)
+		}
+		joined = append(joined, report[imageHeaderSize:imageHeaderSize+length]...)
+	}
+	if !bytes.Equal(joined, image) {
+		t.Error("reports don't carry the image")
+	}
+	if length := int(reports[2][4]) | int(reports[2][5])<<8; length != 100 {
+		t.Errorf("last report carries %d bytes, want 100", length)
+	}
+
+	// An image filling a page exactly takes one report, an empty one too
+	if reports := imageReports(0, make([]byte, pageSize)); len(reports) != 1 || reports[0][3] != 1 {
+		t.Errorf("full page split into %d reports", len(reports))
+	}
+	if reports := imageReports(0, nil); len(reports) != 1 || reports[0][3] != 1 || reports[0][4] != 0 {
+		t.Errorf("empty image split into %d reports", len(reports))
+	}
+}
+
+func TestFeatureReports(t *testing.T) {
+	if report := resetReport(); len(report) != featureReportSize || report[0] != 0x03 || report[1] != 0x02 {
+		t.Errorf("reset report % x", report)
+	}
+	for percent, want := range map[int]byte{60: 60, 0: 0, 100: 100, 150: 100, -10: 0} {
+		report := brightnessReport(percent)
+		if len(report) != featureReportSize || report[0] != 0x03 || report[1] != 0x08 || report[2] != want {
+			t.Errorf("brightness %d has report % x", percent, report[:4])
+		}
+	}
+}
+
+// keyReport returns an input report of the model with the given keys down
+func keyReport(model Model, down ...int) []byte {
+	report := make([]byte, 512)
+	report[0] = 0x01
+	for _, key := range down {
+		report[4+key] = 1
+	}
+	return report
+}
+
+func TestKeyStates(t *testing.T) {
+	model := models[0x0080]
+	states, err := model.keyStates(keyReport(model, 0, 14))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 15 || !states[0] || !states[14] || states[1] {
+		t.Errorf("keys down %v, want 0 and 14", states)
+	}
+
+	short := keyReport(model)[:4+model.Keys-1]
+	otherReport := keyReport(model, 3)
+	otherReport[0] = 0x02
+	for _, report := range [][]byte{short, otherReport, nil} {
+		if _, err := model.keyStates(report); err == nil {
+			t.Errorf("report % x accepted", report)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package streamdeck
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"strings"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Face is what a key shows: its label and, for a key muting a control, a bar
+// at the bottom that is red while the control is muted and green otherwise,
+// as long as the volume of the control
+type Face struct {
+	Label    string
+	HasState bool
+	Muted    bool
+	Level    int // Volume of the control in percent
+}
+
+var (
+	background = color.RGBA{A: 0xff}
+	textColor  = color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	barTrack   = color.RGBA{R: 0x30, G: 0x30, B: 0x30, A: 0xff}
+	mutedBar   = color.RGBA{R: 0xd0, G: 0x20, B: 0x20, A: 0xff}
+	unmutedBar = color.RGBA{R: 0x20, G: 0xb0, B: 0x40, A: 0xff}
+)
+
+// Render draws the face of a key as the key shows it, size pixels wide and
+// high. The same face always gives the same image.
+func Render(face Face, size int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(img, img.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+
+	textArea := img.Bounds()
+	if face.HasState {
+		barHeight := size / 8
+		margin := size / 12
+		track := image.Rect(margin, size-margin-barHeight, size-margin, size-margin)
+		draw.Draw(img, track, image.NewUniform(barTrack), image.Point{}, draw.Src)
+		fill := track
+		fill.Max.X = track.Min.X + track.Dx()*min(max(face.Level, 0), 100)/100
+		bar := unmutedBar
+		if face.Muted {
+			bar = mutedBar
+		}
+		draw.Draw(img, fill, image.NewUniform(bar), image.Point{}, draw.Src)
+		textArea.Max.Y = track.Min.Y
+	}
+	drawLabel(img, textArea, face.Label)
+	return img
+}
+
+// drawLabel centers the label in the area, wrapped at spaces. Labels that
+// fit in a line are drawn twice as large.
+func drawLabel(img *image.RGBA, area image.Rectangle, label string) {
+	face := basicfont.Face7x13
+	const charWidth, lineHeight = 7, 13
+	padding := area.Dx() / 16
+
+	scale := 2
+	if len(label)*charWidth*scale > area.Dx()-2*padding {
+		scale = 1
+	}
+	lines := wrap(label, (area.Dx()-2*padding)/(charWidth*scale))
+	if maxLines := area.Dy() / (lineHeight * scale); len(lines) > maxLines {
+		lines = lines[:maxLines]
+	}
+	if len(lines) == 0 {
+		return
+	}
+
+	// Draw at the size of the font, then scale up without smoothing
+	text := image.NewRGBA(image.Rect(0, 0, area.Dx()/scale, len(lines)*lineHeight))
+	drawer := font.Drawer{Dst: text, Src: image.NewUniform(textColor), Face: face}
+	for i, line := range lines {
+		width := drawer.MeasureString(line).Ceil()
+		drawer.Dot = fixed.P((text.Bounds().Dx()-width)/2, i*lineHeight+face.Ascent)
+		drawer.DrawString(line)
+	}
+	height := text.Bounds().Dy() * scale
+	top := area.Min.Y + (area.Dy()-height)/2
+	target := image.Rect(area.Min.X, top, area.Min.X+text.Bounds().Dx()*scale, top+height)
+	draw.NearestNeighbor.Scale(img, target, text, text.Bounds(), draw.Over, nil)
+}
+
+// wrap breaks text at spaces into lines of at most width characters, cutting
+// words that are longer
+func wrap(text string, width int) []string {
+	if width <= 0 {
+		return nil
+	}
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		for len(word) > width {
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			lines = append(lines, word[:width])
+			word = word[width:]
+		}
+		switch {
+		case line == "":
+			line = word
+		case len(line)+1+len(word) <= width:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// encodeKey turns a rendered key into the JPEG the Stream Deck expects,
+// which is upside down
+func encodeKey(img *image.RGBA) ([]byte, error) {
+	bounds := img.Bounds()
+	rotated := image.NewRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			rotated.SetRGBA(bounds.Max.X-1-x+bounds.Min.X, bounds.Max.Y-1-y+bounds.Min.Y, img.RGBAAt(x, y))
+		}
+	}
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, rotated, &jpeg.Options{Quality: 90}); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,167 @@
+package streamdeck
+
+import (
+	"bytes"
+	"flag"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the snapshots in testdata")
+
+func TestRenderSnapshots(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		face Face
+		size int
+	}{
+		{"label", Face{Label: "Mic"}, 72},
+		{"wrapped", Face{Label: "Next output device"}, 72},
+		{"unmuted", Face{Label: "Music", HasState: true, Level: 65}, 72},
+		{"muted", Face{Label: "Music", HasState: true, Muted: true, Level: 30}, 72},
+		{"xl", Face{Label: "Browser", HasState: true, Level: 100}, 96},
+		{"dark", Face{}, 72},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			img := Render(test.face, test.size)
+			if img.Bounds() != image.Rect(0, 0, test.size, test.size) {
+				t.Fatalf("rendered %v", img.Bounds())
+			}
+			snapshot := filepath.Join("testdata", test.name+".png")
+			if *update {
+				var data bytes.Buffer
+				if err := png.Encode(&data, img); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(snapshot, data.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			file, err := os.Open(snapshot)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			want, err := png.Decode(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := differentPixels(img, want); diff > 0 {
+				t.Errorf("%d pixels differ from %s, run with -update and look at it if the change is intended", diff, snapshot)
+			}
+			if again := Render(test.face, test.size); !slices.Equal(again.Pix, img.Pix) {
+				t.Error("rendered differently the second time")
+			}
+		})
+	}
+}
+
+// differentPixels counts the pixels differing between two images of the same size
+func differentPixels(a image.Image, b image.Image) int {
+	if a.Bounds() != b.Bounds() {
+		return a.Bounds().Dx() * a.Bounds().Dy()
+	}
+	diff := 0
+	for y := a.Bounds().Min.Y; y < a.Bounds().Max.Y; y++ {
+		for x := a.Bounds().Min.X; x < a.Bounds().Max.X; x++ {
+			if color.RGBAModel.Convert(a.At(x, y)) != color.RGBAModel.Convert(b.At(x, y)) {
+				diff++
+			}
+		}
+	}
+	return diff
+}
+
+func TestRenderStateBar(t *testing.T) {
+	// The bar fills the width of its track in proportion to the level
+	const size = 72
+	barY := size - size/12 - 1
+	for _, test := range []struct {
+		face   Face
+		filled int
+		color  color.RGBA
+	}{
+		{Face{HasState: true, Level: 50}, 30, unmutedBar},
+		{Face{HasState: true, Muted: true, Level: 50}, 30, mutedBar},
+		{Face{HasState: true, Level: 0}, 0, unmutedBar},
+		{Face{HasState: true, Level: 150}, 60, unmutedBar},
+		{Face{HasState: true, Level: -5}, 0, unmutedBar},
+	} {
+		img := Render(test.face, size)
+		filled := 0
+		for x := 0; x < size; x++ {
+			switch img.RGBAAt(x, barY) {
+			case test.color:
+				filled++
+			case background, barTrack:
+			default:
+				t.Errorf("%+v has color %v in the bar", test.face, img.RGBAAt(x, barY))
+			}
+		}
+		if filled != test.filled {
+			t.Errorf("%+v fills %d pixels of the bar, want %d", test.face, filled, test.filled)
+		}
+	}
+
+	// Without state there is no bar
+	img := Render(Face{Label: "Play"}, size)
+	for x := 0; x < size; x++ {
+		if c := img.RGBAAt(x, barY); c != background {
+			t.Fatalf("key without state has color %v at the bar", c)
+		}
+	}
+}
+
+func TestWrap(t *testing.T) {
+	for _, test := range []struct {
+		text  string
+		width int
+		lines []string
+	}{
+		{"Mic", 8, []string{"Mic"}},
+		{"Next output device", 8, []string{"Next", "output", "device"}},
+		{"Next output device", 11, []string{"Next output", "device"}},
+		{"  Two   spaces  ", 20, []string{"Two spaces"}},
+		{"Headphones", 4, []string{"Head", "phon", "es"}},
+		{"A Headphones", 4, []string{"A", "Head", "phon", "es"}},
+		{"", 8, nil},
+		{"Mic", 0, nil},
+	} {
+		if lines := wrap(test.text, test.width); !slices.Equal(lines, test.lines) {
+			t.Errorf("%q wrapped at %d as %q, want %q", test.text, test.width, lines, test.lines)
+		}
+	}
+}
+
+func TestEncodeKeyRotates(t *testing.T) {
+	// A key with a bar at the bottom arrives with it at the top
+	img := Render(Face{HasState: true, Muted: true, Level: 100}, 72)
+	data, err := encodeKey(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Bounds() != img.Bounds() {
+		t.Fatalf("encoded as %v", decoded.Bounds())
+	}
+	near := func(c color.Color, want color.RGBA) bool {
+		r, g, b, _ := c.RGBA()
+		diff := func(x uint32, y uint8) bool { return int(x>>8)-int(y) < 24 && int(y)-int(x>>8) < 24 }
+		return diff(r, want.R) && diff(g, want.G) && diff(b, want.B)
+	}
+	if c := decoded.At(36, 72/12+2); !near(c, mutedBar) {
+		t.Errorf("top of the encoded key is %v, want the bar", c)
+	}
+	if c := decoded.At(36, 72-72/12-2); !near(c, background) {
+		t.Errorf("bottom of the encoded key is %v, want the background", c)
+	}
+}
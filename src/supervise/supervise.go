@@ -0,0 +1,121 @@
+// Package supervise keeps a panic in a long-lived goroutine from taking down
+// pulsekontrol, or worse, from silently stopping that goroutine while the
+// rest keeps running. Supervised goroutines are restarted after a panic, and
+// one that keeps panicking makes pulsekontrol shut down cleanly.
+package supervise
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// MaxRestarts is how often a goroutine is restarted before giving up
+	MaxRestarts = 5
+	// initialBackoff is the delay before the first restart, doubled for each further one
+	initialBackoff = 100 * time.Millisecond
+	// maxBackoff bounds the delay between restarts
+	maxBackoff = 10 * time.Second
+)
+
+var (
+	// stableAfter is how long a goroutine has to run to have its restarts
+	// forgiven. A variable so that tests don't wait for it.
+	stableAfter = time.Minute
+
+	panics uint64
+
+	failureMutex sync.Mutex
+	failure      func(error)
+)
+
+// SetFailureHandler sets what is called when a goroutine panicked more than
+// MaxRestarts times in a row, usually stopping pulsekontrol. Without one the
+// error is only logged.
+func SetFailureHandler(handler func(error)) {
+	failureMutex.Lock()
+	defer failureMutex.Unlock()
+	failure = handler
+}
+
+// Panics returns the number of recovered panics
+func Panics() uint64 {
+	return atomic.LoadUint64(&panics)
+}
+
+// Go runs body in a goroutine. If it panics, the panic is logged and body is
+// started again after a growing delay; once it returns normally it is done.
+func Go(name string, body func()) {
+	go supervise(name, body, initialBackoff)
+}
+
+func supervise(name string, body func(), backoff time.Duration) {
+	restarts := 0
+	delay := backoff
+	for {
+		started := time.Now()
+		if !run(name, body) {
+			return
+		}
+		if time.Since(started) >= stableAfter {
+			restarts = 0
+			delay = backoff
+		}
+		if restarts == MaxRestarts {
+			fail(fmt.Errorf("%s panicked %d times in a row", name, restarts+1))
+			return
+		}
+		restarts++
+		log.Warn().Str("goroutine", name).Int("restart", restarts).Dur("delay", delay).Msg("Restarting after a panic")
+		time.Sleep(delay)
+		delay = min(delay*2, maxBackoff)
+	}
+}
+
+// run calls body and reports whether it panicked
+func run(name string, body func()) (panicked bool) {
+	defer func() {
+		if value := recover(); value != nil {
+			record(name, value)
+			panicked = true
+		}
+	}()
+	body()
+	return false
+}
+
+// Recover logs a panic instead of crashing when deferred in a callback that
+// runs on a goroutine of other code, like a MIDI driver. The next call of the
+// callback runs as usual.
+func Recover(name string) {
+	if value := recover(); value != nil {
+		record(name, value)
+	}
+}
+
+// record counts and logs a panic with the stack of the goroutine that panicked
+func record(name string, value interface{}) {
+	count := atomic.AddUint64(&panics, 1)
+	log.Error().
+		Str("goroutine", name).
+		Interface("panic", value).
+		Uint64("panics", count).
+		Str("stack", string(debug.Stack())).
+		Msg("Recovered from a panic")
+}
+
+func fail(err error) {
+	failureMutex.Lock()
+	handler := failure
+	failureMutex.Unlock()
+	if handler == nil {
+		log.Error().Err(err).Msg("Giving up on a goroutine")
+		return
+	}
+	handler(err)
+}
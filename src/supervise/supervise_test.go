@@ -0,0 +1,187 @@
+package supervise
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// syncBuffer is a log output written from several goroutines
+type syncBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.String()
+}
+
+// captureLog sends the log to a buffer for the test
+func captureLog(t *testing.T) *syncBuffer {
+	output := &syncBuffer{}
+	logger := log.Logger
+	log.Logger = zerolog.New(output)
+	t.Cleanup(func() { log.Logger = logger })
+	return output
+}
+
+// failures sets a failure handler for the test and returns what it receives
+func failures(t *testing.T) chan error {
+	failed := make(chan error, 1)
+	SetFailureHandler(func(err error) { failed <- err })
+	t.Cleanup(func() { SetFailureHandler(nil) })
+	return failed
+}
+
+// panicking returns a body panicking the given number of times before it
+// returns, and counts its runs
+func panicking(times int, runs *int) func() {
+	return func() {
+		*runs++
+		if *runs <= times {
+			panic("broken")
+		}
+	}
+}
+
+func TestRestartAfterPanic(t *testing.T) {
+	output := captureLog(t)
+	failed := failures(t)
+	before := Panics()
+
+	var runs int
+	start := time.Now()
+	supervise("test", panicking(3, &runs), 5*time.Millisecond)
+	if runs != 4 {
+		t.Errorf("body ran %d times, want 4", runs)
+	}
+	if panics := Panics() - before; panics != 3 {
+		t.Errorf("counted %d panics, want 3", panics)
+	}
+	// Restarted after 5, 10 and 20ms
+	if elapsed := time.Since(start); elapsed < 35*time.Millisecond {
+		t.Errorf("restarted within %s", elapsed)
+	}
+	select {
+	case err := <-failed:
+		t.Errorf("failed with %v", err)
+	default:
+	}
+
+	logged := output.String()
+	if strings.Count(logged, "Recovered from a panic") != 3 || strings.Count(logged, "Restarting after a panic") != 3 {
+		t.Errorf("logged\n%s", logged)
+	}
+	if !strings.Contains(logged, `"panic":"broken"`) || !strings.Contains(logged, "supervise_test.go") {
+		t.Errorf("panic logged without its value or stack:\n%s", logged)
+	}
+}
+
+func TestGiveUp(t *testing.T) {
+	captureLog(t)
+	failed := failures(t)
+
+	var runs int
+	supervise("test", panicking(100, &runs), time.Millisecond)
+	if runs != MaxRestarts+1 {
+		t.Errorf("body ran %d times, want %d", runs, MaxRestarts+1)
+	}
+	select {
+	case err := <-failed:
+		if !strings.Contains(err.Error(), "test panicked 6 times in a row") {
+			t.Errorf("failed with %v", err)
+		}
+	default:
+		t.Error("failure not handled")
+	}
+
+	// Without a handler the failure is only logged
+	SetFailureHandler(nil)
+	output := captureLog(t)
+	runs = 0
+	supervise("test", panicking(100, &runs), time.Millisecond)
+	if !strings.Contains(output.String(), "Giving up on a goroutine") {
+		t.Errorf("logged\n%s", output)
+	}
+}
+
+func TestStableRunsAreForgiven(t *testing.T) {
+	captureLog(t)
+	failed := failures(t)
+	stable := stableAfter
+	stableAfter = 5 * time.Millisecond
+	t.Cleanup(func() { stableAfter = stable })
+
+	// Panicking after running a while never counts up to MaxRestarts
+	var runs int
+	body := panicking(2*MaxRestarts, &runs)
+	supervise("test", func() {
+		time.Sleep(10 * time.Millisecond)
+		body()
+	}, time.Millisecond)
+	if runs != 2*MaxRestarts+1 {
+		t.Errorf("body ran %d times, want %d", runs, 2*MaxRestarts+1)
+	}
+	select {
+	case err := <-failed:
+		t.Errorf("failed with %v", err)
+	default:
+	}
+}
+
+func TestGo(t *testing.T) {
+	captureLog(t)
+	done := make(chan struct{})
+	var mutex sync.Mutex
+	var runs int
+	Go("test", func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+		runs++
+		if runs == 1 {
+			panic("broken")
+		}
+		close(done)
+	})
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("goroutine not restarted")
+	}
+}
+
+func TestRecover(t *testing.T) {
+	output := captureLog(t)
+	before := Panics()
+	func() {
+		defer Recover("callback")
+		panic("broken")
+	}()
+	if Panics() != before+1 {
+		t.Error("recovered panic not counted")
+	}
+	if !strings.Contains(output.String(), `"goroutine":"callback"`) {
+		t.Errorf("logged\n%s", output)
+	}
+
+	// Without a panic nothing happens
+	func() {
+		defer Recover("callback")
+	}()
+	if Panics() != before+1 {
+		t.Error("panic counted without one")
+	}
+}
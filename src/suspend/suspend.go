@@ -0,0 +1,148 @@
+// Package suspend watches logind for the system waking up from suspend or
+// hibernation. USB audio devices come back renumbered and sound servers may
+// restart, so pulsekontrol syncs its volumes and devices again on resume.
+package suspend
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/clock"
+	"github.com/0h41/pulsekontrol/src/logging"
+	"github.com/godbus/dbus/v5"
+	"github.com/rs/zerolog"
+)
+
+const (
+	loginInterface = "org.freedesktop.login1.Manager"
+	loginPath      = "/org/freedesktop/login1"
+	sleepSignal    = "PrepareForSleep"
+)
+
+const (
+	// SettleDelay is how long after waking up the resync waits, for USB
+	// devices to show up again and the sound server to follow them
+	SettleDelay = 2 * time.Second
+	// Debounce is how long after a resync another resume signal without a
+	// sleep signal in between is taken for a repeat of the same one
+	Debounce = 30 * time.Second
+)
+
+// Watcher calls a function after the system resumed. Each resume calls it
+// once, even when logind sends its signal twice.
+type Watcher struct {
+	log     zerolog.Logger
+	clock   clock.Clock
+	resumed func()
+	signals chan bool
+	conn    *dbus.Conn // Closed by Stop, nil for a watcher given signals by Handle
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// New creates a watcher that calls resumed for the signals passed to Handle
+// once started
+func New(clock clock.Clock, resumed func()) *Watcher {
+	return &Watcher{
+		log:     logging.Module("Suspend"),
+		clock:   clock,
+		resumed: resumed,
+		signals: make(chan bool, 8),
+	}
+}
+
+// Connect creates a watcher for the signals of logind on the system bus and
+// starts it. Without a system bus, like in a container, it fails and there
+// is nothing to watch.
+func Connect(resumed func()) (*Watcher, error) {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to the system bus: %w", err)
+	}
+	err = conn.AddMatchSignal(
+		dbus.WithMatchObjectPath(loginPath),
+		dbus.WithMatchInterface(loginInterface),
+		dbus.WithMatchMember(sleepSignal),
+	)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("cannot listen for suspend signals: %w", err)
+	}
+	w := New(clock.System, resumed)
+	w.conn = conn
+	messages := make(chan *dbus.Signal, 8)
+	conn.Signal(messages)
+	w.Start()
+	go func() {
+		// Closed when the connection is
+		for message := range messages {
+			if message.Name != loginInterface+"."+sleepSignal || len(message.Body) != 1 {
+				continue
+			}
+			if sleeping, ok := message.Body[0].(bool); ok {
+				w.Handle(sleeping)
+			}
+		}
+	}()
+	return w, nil
+}
+
+// Handle takes a PrepareForSleep signal: true before the system sleeps,
+// false after it resumed
+func (w *Watcher) Handle(sleeping bool) {
+	select {
+	case w.signals <- sleeping:
+	default:
+		w.log.Warn().Bool("sleeping", sleeping).Msg("Dropped suspend signal, too many are waiting")
+	}
+}
+
+// Start handles the signals until Stop
+func (w *Watcher) Start() {
+	w.stop = make(chan struct{})
+	w.done = make(chan struct{})
+	go w.loop()
+}
+
+// Stop stops the watcher and waits for a running resync to finish
+func (w *Watcher) Stop() {
+	if w.stop == nil {
+		return
+	}
+	close(w.stop)
+	<-w.done
+	if w.conn != nil {
+		w.conn.Close()
+	}
+}
+
+func (w *Watcher) loop() {
+	defer close(w.done)
+	var settled <-chan time.Time // Set while waiting to resync
+	var synced time.Time         // Time of the last resync
+	asleep := false              // Whether a sleep signal came since then
+	for {
+		select {
+		case <-w.stop:
+			return
+		case sleeping := <-w.signals:
+			switch {
+			case sleeping:
+				if !asleep {
+					w.log.Info().Msg("System is going to sleep")
+				}
+				asleep, settled = true, nil
+			case settled != nil:
+				// Already waiting for this resume
+			case !asleep && !synced.IsZero() && w.clock.Now().Sub(synced) < Debounce:
+				w.log.Debug().Msg("Ignoring repeated resume signal")
+			default:
+				w.log.Info().Dur("delay", SettleDelay).Msg("System resumed, syncing volumes and devices once they settle")
+				settled = w.clock.After(SettleDelay)
+			}
+		case <-settled:
+			settled, asleep, synced = nil, false, w.clock.Now()
+			w.resumed()
+		}
+	}
+}
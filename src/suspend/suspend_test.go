@@ -0,0 +1,229 @@
+package suspend
+
+import (
+	"testing"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/internal/testutil"
+	"github.com/godbus/dbus/v5"
+)
+
+// newTestWatcher starts a watcher on a fake clock whose resyncs are sent to
+// the returned channel
+func newTestWatcher(t *testing.T) (*Watcher, *testutil.FakeClock, chan struct{}) {
+	t.Helper()
+	clock := testutil.NewFakeClock(time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC))
+	resumes := make(chan struct{}, 10)
+	w := New(clock, func() { resumes <- struct{}{} })
+	w.Start()
+	t.Cleanup(w.Stop)
+	return w, clock, resumes
+}
+
+// settling waits for the watcher to wait for the devices to settle
+func settling(t *testing.T, clock *testutil.FakeClock) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for clock.Waiters() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("not waiting to resync")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// handled waits for the watcher to take the signals passed so far, it handles
+// each before looking at the clock again
+func handled(t *testing.T, w *Watcher) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(w.signals) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("signals not handled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// resynced waits for a resync
+func resynced(t *testing.T, resumes chan struct{}) {
+	t.Helper()
+	select {
+	case <-resumes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not synced after resuming")
+	}
+}
+
+// quiet checks that no resync runs or waits for a moment
+func quiet(t *testing.T, clock *testutil.FakeClock, resumes chan struct{}) {
+	t.Helper()
+	select {
+	case <-resumes:
+		t.Error("synced again")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if clock.Waiters() != 0 {
+		t.Error("waiting to sync again")
+	}
+}
+
+func TestResumeSyncsOnceSettled(t *testing.T) {
+	w, clock, resumes := newTestWatcher(t)
+	w.Handle(true)
+	w.Handle(false)
+	settling(t, clock)
+	clock.Advance(SettleDelay - time.Millisecond)
+	select {
+	case <-resumes:
+		t.Fatal("synced before the devices settled")
+	case <-time.After(50 * time.Millisecond):
+	}
+	clock.Advance(time.Millisecond)
+	resynced(t, resumes)
+	quiet(t, clock, resumes)
+}
+
+func TestRepeatedResumeSignal(t *testing.T) {
+	w, clock, resumes := newTestWatcher(t)
+	// Twice while waiting for the devices
+	w.Handle(true)
+	w.Handle(false)
+	w.Handle(false)
+	settling(t, clock)
+	clock.Advance(SettleDelay)
+	resynced(t, resumes)
+	quiet(t, clock, resumes)
+
+	// Again once synced
+	clock.Advance(Debounce / 2)
+	w.Handle(false)
+	handled(t, w)
+	quiet(t, clock, resumes)
+
+	// A sleep in between makes it another resume
+	w.Handle(true)
+	w.Handle(false)
+	settling(t, clock)
+	clock.Advance(SettleDelay)
+	resynced(t, resumes)
+
+	// So does a long time, even when the sleep signal was missed
+	clock.Advance(Debounce)
+	w.Handle(false)
+	settling(t, clock)
+	clock.Advance(SettleDelay)
+	resynced(t, resumes)
+}
+
+func TestSleepWhileSettling(t *testing.T) {
+	w, clock, resumes := newTestWatcher(t)
+	// A resume without a sleep before, like when started while asleep
+	w.Handle(false)
+	settling(t, clock)
+	w.Handle(true)
+	handled(t, w)
+	clock.Advance(SettleDelay)
+	quiet(t, clock, resumes)
+
+	w.Handle(false)
+	settling(t, clock)
+	clock.Advance(SettleDelay)
+	resynced(t, resumes)
+}
+
+func TestStopWaitsForResync(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	running, release := make(chan struct{}), make(chan struct{})
+	w := New(clock, func() {
+		close(running)
+		<-release
+	})
+	// Stopping a watcher that never started does nothing
+	w.Stop()
+
+	w.Start()
+	w.Handle(false)
+	settling(t, clock)
+	clock.Advance(SettleDelay)
+	<-running
+	stopped := make(chan struct{})
+	go func() {
+		w.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("stopped during a resync")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop did not return")
+	}
+}
+
+func TestHandleDoesNotBlock(t *testing.T) {
+	w := New(testutil.NewFakeClock(time.Now()), func() {})
+	// Not started, the signals wait until they are dropped
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 20; i++ {
+			w.Handle(i%2 == 0)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Handle blocked")
+	}
+}
+
+func TestConnect(t *testing.T) {
+	// Without a system bus there is nothing to watch
+	t.Setenv("DBUS_SYSTEM_BUS_ADDRESS", "unix:path="+t.TempDir()+"/none")
+	if w, err := Connect(func() {}); err == nil {
+		w.Stop()
+		t.Fatal("connected without a system bus")
+	}
+
+	address := testutil.StartBus(t)
+	t.Setenv("DBUS_SYSTEM_BUS_ADDRESS", address)
+	resumes := make(chan struct{}, 10)
+	w, err := Connect(func() { resumes <- struct{}{} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	logind, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logind.Close()
+	emit := func(body ...interface{}) {
+		t.Helper()
+		if err := logind.Emit(loginPath, loginInterface+"."+sleepSignal, body...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Signals with another body are ignored
+	emit("false")
+	emit(false, true)
+	emit(true)
+	emit(false)
+	emit(false)
+	select {
+	case <-resumes:
+	case <-time.After(SettleDelay + 5*time.Second):
+		t.Fatal("not synced after resuming")
+	}
+	select {
+	case <-resumes:
+		t.Error("synced twice")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
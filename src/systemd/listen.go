@@ -0,0 +1,111 @@
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// listenFdsStart is the first file descriptor passed by socket activation
+const listenFdsStart = 3
+
+// activation holds the sockets the service manager passed, read once since
+// the variables describing them are removed
+var activation struct {
+	once   sync.Once
+	files  map[string][]*os.File
+	passed int
+	err    error
+}
+
+// Listener returns the listening socket the service manager passed with
+// socket activation: the one named name with FileDescriptorName, or the
+// only one. Without socket activation it returns nil. Each socket is
+// returned once; closing the listener leaves the socket of the service
+// manager open, so connections wait for the next start.
+func Listener(name string) (net.Listener, error) {
+	activation.once.Do(readActivation)
+	if activation.err != nil {
+		return nil, activation.err
+	}
+	if activation.passed == 0 {
+		return nil, nil
+	}
+
+	if _, ok := activation.files[name]; !ok && activation.passed == 1 {
+		for only := range activation.files {
+			name = only
+		}
+	}
+	files := activation.files[name]
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no socket passed by systemd is named %s, set FileDescriptorName=%s", name, name)
+	}
+	file := files[0]
+	activation.files[name] = files[1:]
+
+	// FileListener uses a copy of the descriptor
+	defer file.Close()
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("socket %s passed by systemd: %w", name, err)
+	}
+	return listener, nil
+}
+
+// readActivation takes the sockets described by LISTEN_PID, LISTEN_FDS and
+// LISTEN_FDNAMES and removes the variables, so that commands started by
+// hooks don't take the sockets for theirs
+func readActivation() {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	names, err := parseActivation(os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES"), os.Getpid())
+	if err != nil {
+		activation.err = err
+		return
+	}
+	activation.files = make(map[string][]*os.File)
+	for i, name := range names {
+		fd := listenFdsStart + i
+		// The sockets are not meant for the commands pulsekontrol starts
+		syscall.CloseOnExec(fd)
+		activation.files[name] = append(activation.files[name], os.NewFile(uintptr(fd), name))
+	}
+	activation.passed = len(names)
+}
+
+// parseActivation returns the names of the passed sockets, in the order of
+// their descriptors. Sockets passed to another process, like a parent that
+// did not remove the variables, are none. Unnamed sockets are "unknown",
+// like systemd calls them.
+func parseActivation(pid string, fds string, fdNames string, self int) ([]string, error) {
+	if pid == "" || fds == "" {
+		return nil, nil
+	}
+	if pid != strconv.Itoa(self) {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(fds)
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+	names := make([]string, count)
+	var given []string
+	if fdNames != "" {
+		given = strings.Split(fdNames, ":")
+	}
+	for i := range names {
+		names[i] = "unknown"
+		if i < len(given) && given[i] != "" {
+			names[i] = given[i]
+		}
+	}
+	return names, nil
+}
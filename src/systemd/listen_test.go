@@ -0,0 +1,205 @@
+package systemd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseActivation(t *testing.T) {
+	for _, test := range []struct {
+		pid, fds, fdNames string
+		names             []string
+		fails             bool
+	}{
+		{"", "", "", nil, false},
+		{"42", "2", "metrics:webui", []string{"metrics", "webui"}, false},
+		{"42", "1", "", []string{"unknown"}, false},
+		{"42", "3", "webui::", []string{"webui", "unknown", "unknown"}, false},
+		{"42", "1", "webui:extra", []string{"webui"}, false},
+		{"42", "0", "", []string{}, false},
+		// Sockets of another process
+		{"41", "1", "webui", nil, false},
+		{"", "1", "webui", nil, false},
+		{"42", "two", "", nil, true},
+		{"42", "-1", "", nil, true},
+	} {
+		names, err := parseActivation(test.pid, test.fds, test.fdNames, 42)
+		if (err != nil) != test.fails || !slices.Equal(names, test.names) {
+			t.Errorf("LISTEN_PID=%s LISTEN_FDS=%s LISTEN_FDNAMES=%s gives %q, %v, want %q", test.pid, test.fds, test.fdNames, names, err, test.names)
+		}
+	}
+}
+
+// activationEnv makes TestActivatedProcess take the sockets passed to it
+const activationEnv = "PULSEKONTROL_TEST_ACTIVATED"
+
+// TestActivatedProcess is not a test but a process started by socket
+// activation for the tests. It reports what it finds as lines of key=value
+// and answers a connection on the webui socket.
+func TestActivatedProcess(t *testing.T) {
+	if os.Getenv(activationEnv) == "" {
+		t.Skip("helper process")
+	}
+	// The PID is only known once started
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	report := func(key string, value interface{}) { fmt.Printf("%s=%v\n", key, value) }
+
+	listener, err := Listener("webui")
+	if err != nil {
+		report("error", err)
+		return
+	}
+	if listener == nil {
+		report("listener", "none")
+		return
+	}
+	report("webui", listener.Addr())
+	report("env", os.Getenv("LISTEN_PID")+os.Getenv("LISTEN_FDS")+os.Getenv("LISTEN_FDNAMES"))
+	flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, listenFdsStart, syscall.F_GETFD, 0)
+	report("cloexec", errno == 0 && flags&syscall.FD_CLOEXEC != 0)
+
+	conn, err := listener.Accept()
+	if err == nil {
+		io.WriteString(conn, "served\n")
+		conn.Close()
+	}
+	listener.Close()
+	_, err = Listener("webui")
+	report("again", err != nil)
+	if other, err := Listener("metrics"); err == nil && other != nil {
+		report("metrics", other.Addr())
+		other.Close()
+	}
+}
+
+// activate runs TestActivatedProcess with the listeners passed like systemd
+// does, names in LISTEN_FDNAMES, and returns its reports
+func activate(t *testing.T, names string, listeners ...*net.TCPListener) *bufio.Scanner {
+	t.Helper()
+	process := exec.Command(os.Args[0], "-test.run=^TestActivatedProcess$")
+	process.Env = append(os.Environ(), activationEnv+"=1", "LISTEN_FDS="+strconv.Itoa(len(listeners)), "LISTEN_FDNAMES="+names)
+	for _, listener := range listeners {
+		file, err := listener.File()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		process.ExtraFiles = append(process.ExtraFiles, file)
+	}
+	stdout, err := process.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := process.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		process.Process.Kill()
+		process.Wait()
+	})
+	return bufio.NewScanner(stdout)
+}
+
+// listen returns a TCP listener on a free local port
+func listen(t *testing.T) *net.TCPListener {
+	t.Helper()
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	return listener
+}
+
+// reports reads the reports of an activated process until it exits
+func reports(t *testing.T, scanner *bufio.Scanner) map[string]string {
+	t.Helper()
+	found := make(map[string]string)
+	for scanner.Scan() {
+		if key, value, ok := strings.Cut(scanner.Text(), "="); ok {
+			found[key] = value
+		}
+	}
+	return found
+}
+
+func TestListenerBySocketName(t *testing.T) {
+	metrics, webui := listen(t), listen(t)
+	scanner := activate(t, "metrics:webui", metrics, webui)
+
+	conn, err := net.DialTimeout("tcp", webui.Addr().String(), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if answer, err := bufio.NewReader(conn).ReadString('\n'); answer != "served\n" {
+		t.Errorf("activated process answered %q, %v", answer, err)
+	}
+
+	found := reports(t, scanner)
+	for key, want := range map[string]string{
+		"webui":   webui.Addr().String(),
+		"metrics": metrics.Addr().String(),
+		"env":     "",
+		"cloexec": "true",
+		"again":   "true",
+	} {
+		if found[key] != want {
+			t.Errorf("%s is %q, want %q, reports %v", key, found[key], want, found)
+		}
+	}
+
+	// The socket outlives the process, the next one gets the connections
+	// made in between
+	conn, err = net.DialTimeout("tcp", webui.Addr().String(), 5*time.Second)
+	if err != nil {
+		t.Fatalf("socket closed with the process: %v", err)
+	}
+	conn.Close()
+	webui.SetDeadline(time.Now().Add(5 * time.Second))
+	if waiting, err := webui.Accept(); err != nil {
+		t.Errorf("connection not waiting: %v", err)
+	} else {
+		waiting.Close()
+	}
+}
+
+func TestListenerOnlySocket(t *testing.T) {
+	// A single socket is used whatever its name
+	webui := listen(t)
+	scanner := activate(t, "", webui)
+	conn, err := net.DialTimeout("tcp", webui.Addr().String(), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if found := reports(t, scanner); found["webui"] != webui.Addr().String() {
+		t.Errorf("reports %v, want the only socket", found)
+	}
+}
+
+func TestListenerMissingName(t *testing.T) {
+	scanner := activate(t, "metrics:http", listen(t), listen(t))
+	if found := reports(t, scanner); !strings.Contains(found["error"], "FileDescriptorName=webui") {
+		t.Errorf("reports %v, want an error naming FileDescriptorName", found)
+	}
+}
+
+func TestListenerWithoutActivation(t *testing.T) {
+	// No sockets passed
+	scanner := activate(t, "")
+	if found := reports(t, scanner); found["error"] != "" || found["listener"] != "none" {
+		t.Errorf("reports %v, want no listener", found)
+	}
+}
@@ -0,0 +1,57 @@
+// Package systemd tells the service manager about the state of pulsekontrol
+// through the sd_notify protocol. When not started by systemd everything
+// here does nothing.
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// States sent with Notify
+const (
+	Ready    = "READY=1"    // Startup is complete
+	Stopping = "STOPPING=1" // Shutdown has begun
+	Watchdog = "WATCHDOG=1" // Still alive, see WatchdogInterval
+)
+
+// Notify sends a state to the service manager, through the socket in
+// NOTIFY_SOCKET. Without it, Notify does nothing.
+func Notify(state string) error {
+	socketPath := os.Getenv("NOTIFY_SOCKET")
+	if socketPath == "" {
+		return nil
+	}
+	// A leading @ stands for an abstract socket
+	if strings.HasPrefix(socketPath, "@") {
+		socketPath = "\x00" + socketPath[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// Status sends a line describing what pulsekontrol is doing, shown by systemctl status
+func Status(status string) error {
+	return Notify("STATUS=" + status)
+}
+
+// WatchdogInterval returns how often Watchdog must be sent, half of the
+// service's WatchdogSec, and whether the watchdog is enabled for this process
+func WatchdogInterval() (time.Duration, bool) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0, false
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, false
+	}
+	return time.Duration(usec) * time.Microsecond / 2, true
+}
@@ -0,0 +1,110 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// listenNotify creates a fake notify socket of the service manager, named by
+// NOTIFY_SOCKET for the test, and returns it
+func listenNotify(t *testing.T) *net.UnixConn {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	return conn
+}
+
+// readNotify returns the next message sent to a fake notify socket
+func readNotify(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+	buffer := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buffer[:n])
+}
+
+func TestNotify(t *testing.T) {
+	conn := listenNotify(t)
+	for _, send := range []struct {
+		notify func() error
+		want   string
+	}{
+		{func() error { return Notify(Ready) }, "READY=1"},
+		{func() error { return Status("Waiting for the MIDI device") }, "STATUS=Waiting for the MIDI device"},
+		{func() error { return Notify(Watchdog) }, "WATCHDOG=1"},
+		{func() error { return Notify(Stopping) }, "STOPPING=1"},
+	} {
+		if err := send.notify(); err != nil {
+			t.Fatal(err)
+		}
+		if got := readNotify(t, conn); got != send.want {
+			t.Errorf("sent %q, want %q", got, send.want)
+		}
+	}
+}
+
+func TestNotifyAbstractSocket(t *testing.T) {
+	name := "pulsekontrol-test-" + strconv.Itoa(os.Getpid())
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: "\x00" + name, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("abstract sockets unavailable: %v", err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", "@"+name)
+	if err := Notify(Ready); err != nil {
+		t.Fatal(err)
+	}
+	if got := readNotify(t, conn); got != Ready {
+		t.Errorf("sent %q", got)
+	}
+}
+
+func TestNotifyWithoutSystemd(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := Notify(Ready); err != nil {
+		t.Errorf("notify without a socket returned %v", err)
+	}
+
+	// A socket that went away is reported
+	t.Setenv("NOTIFY_SOCKET", filepath.Join(t.TempDir(), "gone"))
+	if err := Notify(Ready); err == nil {
+		t.Error("notify to a missing socket succeeded")
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	for _, test := range []struct {
+		usec     string
+		pid      string
+		interval time.Duration
+		enabled  bool
+	}{
+		{"", "", 0, false},
+		{"0", "", 0, false},
+		{"invalid", "", 0, false},
+		{"20000000", "", 10 * time.Second, true},
+		{"20000000", pid, 10 * time.Second, true},
+		// Meant for another process, like the parent of pulsekontrol
+		{"20000000", "1", 0, false},
+	} {
+		t.Setenv("WATCHDOG_USEC", test.usec)
+		t.Setenv("WATCHDOG_PID", test.pid)
+		interval, enabled := WatchdogInterval()
+		if interval != test.interval || enabled != test.enabled {
+			t.Errorf("WATCHDOG_USEC=%q WATCHDOG_PID=%q gave %v %v, want %v %v", test.usec, test.pid, interval, enabled, test.interval, test.enabled)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+// Package webhooks posts changes to HTTP endpoints, for home automation that
+// listens on HTTP. The bodies are the messages the web interface receives,
+// so consumers only learn one schema.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/0h41/pulsekontrol/src/logging"
+	"github.com/0h41/pulsekontrol/src/supervise"
+	"github.com/0h41/pulsekontrol/src/webui"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// SignatureHeader carries sha256=<hex HMAC-SHA256 of the body> when a secret is set
+	SignatureHeader = "X-Pulsekontrol-Signature"
+	// EventHeader carries the event name, like profile.switched
+	EventHeader = "X-Pulsekontrol-Event"
+
+	// MaxAttempts is how often an event is posted before it is dropped
+	MaxAttempts = 5
+	// queueSize bounds the events waiting for one endpoint
+	queueSize = 256
+	// requestTimeout bounds one attempt
+	requestTimeout = 10 * time.Second
+	// maxResponseSize is how much of a response is read before the connection is reused
+	maxResponseSize = 64 * 1024
+)
+
+var (
+	// initialBackoff is the delay before the first retry, doubled for each further one
+	initialBackoff = time.Second
+	maxBackoff     = 30 * time.Second
+)
+
+// delivery is an event waiting to be posted
+type delivery struct {
+	event string
+	body  []byte
+}
+
+// endpoint is a configured webhook with its queue
+type endpoint struct {
+	config configuration.WebhookConfig
+	client *http.Client
+	queue  chan delivery
+}
+
+// wants reports whether the endpoint posts an event
+func (e *endpoint) wants(event string) bool {
+	return len(e.config.Events) == 0 || slices.Contains(e.config.Events, event)
+}
+
+// Dispatcher posts the events of the configuration manager to the webhooks.
+// Each endpoint has a worker, so a slow one doesn't hold up the others.
+type Dispatcher struct {
+	log           zerolog.Logger
+	configManager *configuration.ConfigManager
+	endpoints     []*endpoint
+	subscriptions []configuration.Subscription
+	ctx           context.Context
+	cancel        context.CancelFunc
+	wg            sync.WaitGroup
+	dropped       uint64
+}
+
+// Start subscribes to the events of the webhooks and starts their workers.
+// Without webhooks it does nothing and returns nil.
+func Start(configManager *configuration.ConfigManager, webhooks []configuration.WebhookConfig) *Dispatcher {
+	if len(webhooks) == 0 {
+		return nil
+	}
+	d := &Dispatcher{
+		log:           logging.Module("Webhooks"),
+		configManager: configManager,
+	}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+
+	events := make(map[string]bool)
+	for _, webhook := range webhooks {
+		e := &endpoint{config: webhook, client: newClient(webhook.Insecure), queue: make(chan delivery, queueSize)}
+		if webhook.Insecure {
+			d.log.Warn().Str("url", webhook.URL).Msg("Not verifying the TLS certificate of a webhook")
+		}
+		for _, event := range webhook.Events {
+			if !slices.Contains(webui.EventTopics, event) {
+				d.log.Warn().Str("url", webhook.URL).Str("event", event).Msgf("Unknown event %s, use one of %v", event, webui.EventTopics)
+			}
+		}
+		for _, event := range webui.EventTopics {
+			if e.wants(event) {
+				events[event] = true
+			}
+		}
+		d.endpoints = append(d.endpoints, e)
+		d.wg.Add(1)
+		supervise.Go("webhooks.work", func() {
+			d.work(e)
+			d.wg.Done()
+		})
+	}
+
+	for event := range events {
+		d.subscriptions = append(d.subscriptions, configManager.Subscribe(event, func(data interface{}) {
+			d.post(event, data)
+		}))
+	}
+	d.log.Info().Int("webhooks", len(webhooks)).Msg("Posting events to webhooks")
+	return d
+}
+
+// newClient returns the HTTP client of an endpoint. Certificates are
+// verified unless insecure is set explicitly.
+func newClient(insecure bool) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return &http.Client{Transport: transport, Timeout: requestTimeout}
+}
+
+// post queues an event for the endpoints that want it. It runs on the
+// notification goroutine of the configuration manager and never waits.
+func (d *Dispatcher) post(event string, data interface{}) {
+	config := d.configManager.GetConfigSnapshot()
+	message, ok := webui.EventMessage(&config, event, data)
+	if !ok {
+		return
+	}
+	body, err := json.Marshal(message)
+	if err != nil {
+		d.log.Error().Err(err).Str("event", event).Msg("Failed to marshal event")
+		return
+	}
+	for _, e := range d.endpoints {
+		if !e.wants(event) {
+			continue
+		}
+		select {
+		case e.queue <- delivery{event: event, body: body}:
+		default:
+			dropped := atomic.AddUint64(&d.dropped, 1)
+			d.log.Warn().Str("url", e.config.URL).Str("event", event).Uint64("dropped", dropped).Msg("Webhook queue full, dropping event")
+		}
+	}
+}
+
+// work posts the queued events of an endpoint in order, retrying transient
+// failures with a growing delay
+func (d *Dispatcher) work(e *endpoint) {
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case delivery := <-e.queue:
+			d.deliver(e, delivery)
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(e *endpoint, delivery delivery) {
+	backoff := initialBackoff
+	for attempt := 1; ; attempt++ {
+		transient, err := d.send(e, delivery)
+		if err == nil {
+			d.log.Debug().Str("url", e.config.URL).Str("event", delivery.event).Int("attempt", attempt).Msg("Posted event")
+			return
+		}
+		if !transient || attempt == MaxAttempts {
+			dropped := atomic.AddUint64(&d.dropped, 1)
+			d.log.Warn().Err(err).Str("url", e.config.URL).Str("event", delivery.event).Int("attempts", attempt).Uint64("dropped", dropped).Msg("Failed to post event, dropping it")
+			return
+		}
+		d.log.Debug().Err(err).Str("url", e.config.URL).Str("event", delivery.event).Dur("retryIn", backoff).Msg("Failed to post event, retrying")
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// send posts an event once and reports whether a failure may go away on retry
+func (d *Dispatcher) send(e *endpoint, delivery delivery) (transient bool, err error) {
+	request, err := http.NewRequestWithContext(d.ctx, http.MethodPost, e.config.URL, bytes.NewReader(delivery.body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "pulsekontrol")
+	request.Header.Set(EventHeader, delivery.event)
+	for name, value := range e.config.Headers {
+		request.Header.Set(name, value)
+	}
+	if e.config.Secret != "" {
+		request.Header.Set(SignatureHeader, Sign(e.config.Secret, delivery.body))
+	}
+
+	response, err := e.client.Do(request)
+	if err != nil {
+		return d.ctx.Err() == nil, err
+	}
+	io.Copy(io.Discard, io.LimitReader(response.Body, maxResponseSize))
+	response.Body.Close()
+
+	switch {
+	case response.StatusCode >= 200 && response.StatusCode < 300:
+		return false, nil
+	case response.StatusCode == http.StatusRequestTimeout, response.StatusCode == http.StatusTooManyRequests, response.StatusCode >= 500:
+		return true, fmt.Errorf("endpoint answered %s", response.Status)
+	default:
+		return false, fmt.Errorf("endpoint answered %s", response.Status)
+	}
+}
+
+// Sign returns the signature header value of a body: sha256= and the hex
+// HMAC-SHA256 of the body keyed with the secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dropped returns the number of events that were not delivered, because a
+// queue was full or the endpoint kept failing
+func (d *Dispatcher) Dropped() uint64 {
+	return atomic.LoadUint64(&d.dropped)
+}
+
+// Stop stops posting. Events still queued or being retried are dropped.
+func (d *Dispatcher) Stop() {
+	for _, subscription := range d.subscriptions {
+		d.configManager.Unsubscribe(subscription)
+	}
+	d.subscriptions = nil
+	d.cancel()
+	d.wg.Wait()
+}
@@ -0,0 +1,192 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/configuration"
+)
+
+// request is a request the test endpoint received
+type request struct {
+	header http.Header
+	body   []byte
+}
+
+// newEndpoint starts an endpoint that answers with the given statuses in
+// turn, the last one for all further requests
+func newEndpoint(t *testing.T, statuses ...int) (*httptest.Server, chan request, *int32) {
+	t.Helper()
+	requests := make(chan request, 16)
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		attempt := int(atomic.AddInt32(&attempts, 1))
+		requests <- request{header: r.Header, body: body}
+		w.WriteHeader(statuses[min(attempt, len(statuses))-1])
+	}))
+	t.Cleanup(server.Close)
+	return server, requests, &attempts
+}
+
+func newTestManager(t *testing.T) *configuration.ConfigManager {
+	t.Helper()
+	cm := configuration.NewConfigManager(configuration.GetDefaultConfig(), filepath.Join(t.TempDir(), "config.yaml"))
+	cm.SetReadOnly(true)
+	t.Cleanup(cm.Close)
+	return cm
+}
+
+// fastRetries shortens the delays between attempts
+func fastRetries(t *testing.T) {
+	initial, max := initialBackoff, maxBackoff
+	initialBackoff, maxBackoff = time.Millisecond, 4*time.Millisecond
+	t.Cleanup(func() { initialBackoff, maxBackoff = initial, max })
+}
+
+func switchProfile(cm *configuration.ConfigManager) {
+	cm.NotifySync("profile.switched", map[string]interface{}{"name": "gaming", "previous": "default"})
+}
+
+// waitForDropped waits until a dispatcher dropped an event
+func waitForDropped(t *testing.T, d *Dispatcher, want uint64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for d.Dropped() < want && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if dropped := d.Dropped(); dropped != want {
+		t.Fatalf("dropped %d events, want %d", dropped, want)
+	}
+}
+
+func TestPostsEventMessage(t *testing.T) {
+	server, requests, _ := newEndpoint(t, http.StatusNoContent)
+	cm := newTestManager(t)
+	d := Start(cm, []configuration.WebhookConfig{{
+		URL:     server.URL,
+		Events:  []string{"profile.switched"},
+		Headers: map[string]string{"Authorization": "Bearer token"},
+		Secret:  "secret",
+	}})
+	t.Cleanup(d.Stop)
+
+	cm.NotifySync("control.value.updated", map[string]interface{}{"type": "slider", "id": "slider1", "value": 42})
+	switchProfile(cm)
+
+	var received request
+	select {
+	case received = <-requests:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not posted")
+	}
+	var message map[string]string
+	if err := json.Unmarshal(received.body, &message); err != nil {
+		t.Fatalf("body %s: %v", received.body, err)
+	}
+	if message["type"] != "profileSwitched" || message["name"] != "gaming" || message["previous"] != "default" {
+		t.Errorf("posted %s", received.body)
+	}
+	for name, want := range map[string]string{
+		"Content-Type":  "application/json",
+		EventHeader:     "profile.switched",
+		"Authorization": "Bearer token",
+		SignatureHeader: Sign("secret", received.body),
+	} {
+		if value := received.header.Get(name); value != want {
+			t.Errorf("header %s is %q, want %q", name, value, want)
+		}
+	}
+
+	// The value update is not one of the events of the webhook
+	select {
+	case extra := <-requests:
+		t.Errorf("also posted %s", extra.body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSign(t *testing.T) {
+	// echo -n '{}' | openssl dgst -sha256 -hmac secret
+	want := "sha256=77325902caca812dc259733aacd046b73817372c777b8d95b402647474516e13"
+	if signature := Sign("secret", []byte("{}")); signature != want {
+		t.Errorf("signature is %s, want %s", signature, want)
+	}
+}
+
+func TestRetriesTransientFailures(t *testing.T) {
+	fastRetries(t)
+	server, requests, attempts := newEndpoint(t, http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK)
+	cm := newTestManager(t)
+	d := Start(cm, []configuration.WebhookConfig{{URL: server.URL}})
+	t.Cleanup(d.Stop)
+
+	switchProfile(cm)
+	var bodies []string
+	for len(bodies) < 3 {
+		select {
+		case received := <-requests:
+			bodies = append(bodies, string(received.body))
+		case <-time.After(5 * time.Second):
+			t.Fatalf("posted %d times, want 3", len(bodies))
+		}
+	}
+	if bodies[0] != bodies[1] || bodies[1] != bodies[2] {
+		t.Errorf("retries posted different bodies %q", bodies)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(attempts); n != 3 {
+		t.Errorf("posted %d times after success", n)
+	}
+	if dropped := d.Dropped(); dropped != 0 {
+		t.Errorf("dropped %d events", dropped)
+	}
+}
+
+func TestDropsFailedEvents(t *testing.T) {
+	fastRetries(t)
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	for _, test := range []struct {
+		name     string
+		status   int // Answer of the endpoint, none if unreachable
+		attempts int32
+	}{
+		{"client error", http.StatusBadRequest, 1},
+		{"server error", http.StatusInternalServerError, MaxAttempts},
+		{"unreachable", 0, MaxAttempts},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			url, attempts := closed.URL, new(int32)
+			if test.status != 0 {
+				var server *httptest.Server
+				server, _, attempts = newEndpoint(t, test.status)
+				url = server.URL
+			}
+			cm := newTestManager(t)
+			d := Start(cm, []configuration.WebhookConfig{{URL: url}})
+			t.Cleanup(d.Stop)
+
+			switchProfile(cm)
+			waitForDropped(t, d, 1)
+			if test.status != 0 {
+				if n := atomic.LoadInt32(attempts); n != test.attempts {
+					t.Errorf("posted %d times, want %d", n, test.attempts)
+				}
+			}
+		})
+	}
+}
+
+func TestStartWithoutWebhooks(t *testing.T) {
+	if d := Start(newTestManager(t), nil); d != nil {
+		t.Error("started a dispatcher without webhooks")
+	}
+}
@@ -0,0 +1,106 @@
+package webui
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/grandcat/zeroconf"
+)
+
+// Services the web interface is advertised as
+var advertisedServices = []string{"_http._tcp", "_pulsekontrol._tcp"}
+
+// ErrLoopback is returned by Advertise for addresses only reachable from this machine
+var ErrLoopback = errors.New("the web interface only listens on the loopback interface")
+
+// Advertisement is the web interface announced over mDNS/DNS-SD
+type Advertisement struct {
+	servers []*zeroconf.Server
+}
+
+// DefaultInstanceName returns the instance name used when none is configured
+func DefaultInstanceName() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return "pulsekontrol"
+	}
+	return "pulsekontrol on " + hostname
+}
+
+// Advertise announces the web interface listening on address (host:port) on
+// the local network under an instance name. A specific host only announces it
+// on the interface with that address. Withdraw ends the announcement.
+func Advertise(address string, instance string) (*Advertisement, error) {
+	host, portText, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %q: %w", address, err)
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q: %w", portText, err)
+	}
+
+	var ifaces []net.Interface
+	if host != "" {
+		if host == "localhost" {
+			return nil, ErrLoopback
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return nil, fmt.Errorf("cannot advertise host name %q, use an IP address", host)
+		}
+		if ip.IsLoopback() {
+			return nil, ErrLoopback
+		}
+		if !ip.IsUnspecified() {
+			iface, err := interfaceWithIP(ip)
+			if err != nil {
+				return nil, err
+			}
+			ifaces = []net.Interface{iface}
+		}
+	}
+
+	advertisement := &Advertisement{}
+	for _, service := range advertisedServices {
+		server, err := zeroconf.Register(instance, service, "local.", port, []string{"path=/"}, ifaces)
+		if err != nil {
+			advertisement.Withdraw()
+			return nil, fmt.Errorf("cannot advertise %s: %w", service, err)
+		}
+		advertisement.servers = append(advertisement.servers, server)
+	}
+	log.Info().Str("instance", instance).Int("port", port).Msg("Advertising web interface over mDNS")
+	return advertisement, nil
+}
+
+// interfaceWithIP returns the network interface that has an address
+func interfaceWithIP(ip net.IP) (net.Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return net.Interface{}, err
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return iface, nil
+			}
+		}
+	}
+	return net.Interface{}, fmt.Errorf("no network interface has the address %s", ip)
+}
+
+// Withdraw ends the announcement, telling clients the service is gone
+func (a *Advertisement) Withdraw() {
+	for _, server := range a.servers {
+		server.Shutdown()
+	}
+	a.servers = nil
+}
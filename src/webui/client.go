@@ -0,0 +1,191 @@
+package webui
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/0h41/pulsekontrol/src/control"
+	"github.com/gorilla/websocket"
+)
+
+// clientTimeout bounds connecting and waiting for a reply
+const clientTimeout = 10 * time.Second
+
+// ClientOptions are how a Client reaches the web interface
+type ClientOptions struct {
+	Token    string // Auth token of the web interface, if it has one
+	TLS      bool   // Use https even if the address doesn't say so
+	Insecure bool   // Don't verify the certificate
+	CAFile   string // Certificates to verify the certificate with instead of the system ones
+}
+
+// Client runs the commands of the control socket on another pulsekontrol
+// through its web interface, like pulsekontrol remote
+type Client struct {
+	conn    *websocket.Conn
+	address string
+	next    int
+}
+
+// Dial connects to the web interface at address, a host with an optional
+// port (6080 by default) or a URL like https://audio.local/pulsekontrol
+// behind a proxy, and checks that it speaks the same protocol
+func Dial(address string, options ClientOptions) (*Client, error) {
+	endpoint, err := WebSocketURL(address, options.TLS)
+	if err != nil {
+		return nil, err
+	}
+	dialer := websocket.Dialer{Proxy: http.ProxyFromEnvironment, HandshakeTimeout: clientTimeout}
+	if endpoint.Scheme == "wss" {
+		dialer.TLSClientConfig = &tls.Config{InsecureSkipVerify: options.Insecure}
+		if options.CAFile != "" {
+			pem, err := os.ReadFile(options.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates in %s", options.CAFile)
+			}
+			dialer.TLSClientConfig.RootCAs = pool
+		}
+	}
+	header := http.Header{}
+	if options.Token != "" {
+		header.Set("Authorization", "Bearer "+options.Token)
+	}
+
+	conn, response, err := dialer.Dial(endpoint.String(), header)
+	if err != nil {
+		return nil, dialError(address, options, response, err)
+	}
+	client := &Client{conn: conn, address: address}
+	if err := client.checkWelcome(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// WebSocketURL returns the URL of the WebSocket of the web interface at
+// address, see Dial
+func WebSocketURL(address string, useTLS bool) (*url.URL, error) {
+	if !strings.Contains(address, "://") {
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			_, port, _ := net.SplitHostPort(configuration.DefaultWebUIAddress)
+			address = net.JoinHostPort(strings.Trim(address, "[]"), port)
+		}
+		address = "http://" + address
+	}
+	endpoint, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %s: %w", address, err)
+	}
+	switch endpoint.Scheme {
+	case "http", "ws":
+		endpoint.Scheme = "ws"
+		if useTLS {
+			endpoint.Scheme = "wss"
+		}
+	case "https", "wss":
+		endpoint.Scheme = "wss"
+	default:
+		return nil, fmt.Errorf("invalid address %s, expected host:port or an http or https URL", address)
+	}
+	if endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid address %s, the host is missing", address)
+	}
+	endpoint.Path = strings.TrimSuffix(endpoint.Path, "/") + "/ws"
+	endpoint.RawQuery, endpoint.Fragment = "", ""
+	return endpoint, nil
+}
+
+// dialError explains why connecting failed
+func dialError(address string, options ClientOptions, response *http.Response, err error) error {
+	var certErr *tls.CertificateVerificationError
+	switch {
+	case errors.As(err, &certErr):
+		return fmt.Errorf("cannot verify the certificate of %s, pass its CA with --ca or skip the check with --insecure: %w", address, err)
+	case errors.Is(err, websocket.ErrBadHandshake) && response != nil:
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		response.Body.Close()
+		switch response.StatusCode {
+		case http.StatusUnauthorized:
+			if options.Token == "" {
+				return fmt.Errorf("pulsekontrol at %s requires an auth token, pass it with --token", address)
+			}
+			return fmt.Errorf("pulsekontrol at %s rejected the auth token", address)
+		case http.StatusForbidden:
+			return fmt.Errorf("pulsekontrol at %s refused the connection: %s", address, strings.TrimSpace(string(message)))
+		case http.StatusNotFound:
+			return fmt.Errorf("no pulsekontrol web interface at %s", address)
+		default:
+			return fmt.Errorf("pulsekontrol at %s answered %s: %s", address, response.Status, strings.TrimSpace(string(message)))
+		}
+	}
+	return fmt.Errorf("cannot connect to pulsekontrol at %s, is its web interface enabled and reachable? %w", address, err)
+}
+
+// checkWelcome reads the first message and checks the protocol version
+func (c *Client) checkWelcome() error {
+	c.conn.SetReadDeadline(time.Now().Add(clientTimeout))
+	var welcome Welcome
+	if err := c.conn.ReadJSON(&welcome); err != nil {
+		return fmt.Errorf("no welcome from %s, is it a pulsekontrol? %w", c.address, err)
+	}
+	switch {
+	case welcome.Type != "welcome":
+		return fmt.Errorf("no welcome from %s, is it a pulsekontrol? Got a %s message", c.address, welcome.Type)
+	case welcome.Protocol == 0:
+		return fmt.Errorf("pulsekontrol at %s is too old for remote commands, update it", c.address)
+	case welcome.Protocol != ProtocolVersion:
+		return fmt.Errorf("pulsekontrol at %s speaks protocol version %d, this one version %d, update the older one", c.address, welcome.Protocol, ProtocolVersion)
+	}
+	return nil
+}
+
+// Send runs a request and returns its reply. A reply that is not Ok is
+// returned as it is, with the error of the server; errors are about the
+// connection.
+func (c *Client) Send(request control.Request) (control.Reply, error) {
+	c.next++
+	command := Command{Type: "command", RequestId: strconv.Itoa(c.next), Protocol: ProtocolVersion, Request: request}
+	c.conn.SetWriteDeadline(time.Now().Add(clientTimeout))
+	if err := c.conn.WriteJSON(command); err != nil {
+		return control.Reply{}, fmt.Errorf("cannot send request to %s: %w", c.address, err)
+	}
+
+	// Updates for the web interface arrive in between
+	c.conn.SetReadDeadline(time.Now().Add(clientTimeout))
+	for {
+		_, message, err := c.conn.ReadMessage()
+		if err != nil {
+			return control.Reply{}, fmt.Errorf("no reply from %s: %w", c.address, err)
+		}
+		var reply CommandReply
+		if err := json.Unmarshal(message, &reply); err != nil {
+			return control.Reply{}, fmt.Errorf("invalid message from %s: %w", c.address, err)
+		}
+		if reply.Type == "commandReply" && reply.RequestId == command.RequestId {
+			return reply.Reply, nil
+		}
+	}
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	return c.conn.Close()
+}
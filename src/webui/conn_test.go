@@ -0,0 +1,75 @@
+package webui
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialClientConn connects to a WebSocket server whose connection is handed to
+// serve as a clientConn, and returns the client end
+func dialClientConn(t *testing.T, serve func(conn *clientConn)) *websocket.Conn {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgraded, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade failed: %v", err)
+			return
+		}
+		conn := &clientConn{Conn: upgraded}
+		defer conn.Close()
+		serve(conn)
+	}))
+	t.Cleanup(server.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestClientConnConcurrentWrites(t *testing.T) {
+	const writers, messages = 8, 50
+	// Large enough for the writes to overlap without turns
+	padding := strings.Repeat(" ", 16*1024)
+	client := dialClientConn(t, func(conn *clientConn) {
+		// Like replies of the reader goroutine and broadcasts at the same time
+		var wg sync.WaitGroup
+		for writer := 0; writer < writers; writer++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < messages; i++ {
+					if err := conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf(`{"writer":%d,"message":%d}%s`, writer, i, padding))); err != nil {
+						t.Errorf("write failed: %v", err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		// Wait for the client to close the connection
+		conn.ReadMessage()
+	})
+
+	received := make(map[string]bool)
+	for len(received) < writers*messages {
+		_, data, err := client.ReadMessage()
+		if err != nil {
+			t.Fatalf("read failed after %d messages: %v", len(received), err)
+		}
+		var writer, message int
+		if _, err := fmt.Sscanf(string(data), `{"writer":%d,"message":%d}`, &writer, &message); err != nil {
+			t.Fatalf("corrupted message %q", data)
+		}
+		received[string(data)] = true
+	}
+}
@@ -0,0 +1,189 @@
+package webui
+
+import (
+	"slices"
+
+	"github.com/0h41/pulsekontrol/src/activity"
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/0h41/pulsekontrol/src/control"
+)
+
+// Messages the server sends to the web clients about changes. Webhooks post
+// the same messages, so both speak one schema.
+
+// ControlValueUpdate is the new value of a slider or knob
+type ControlValueUpdate struct {
+	Type        string `json:"type"` // controlValueUpdate
+	ControlType string `json:"controlType"`
+	ControlId   string `json:"controlId"`
+	Value       int    `json:"value"`
+	Origin      string `json:"origin,omitempty"` // Kind of origin, like midi or web
+}
+
+// ControlMutedUpdate is the new muted state of a control
+type ControlMutedUpdate struct {
+	Type        string `json:"type"` // controlMutedUpdate
+	ControlType string `json:"controlType"`
+	ControlId   string `json:"controlId"`
+	Muted       bool   `json:"muted"`
+}
+
+// SourceAdded tells that a stream appeared, with the controls whose volume was applied to it
+type SourceAdded struct {
+	Type       string                             `json:"type"` // sourceAdded
+	SourceType configuration.PulseAudioTargetType `json:"sourceType"`
+	SourceName string                             `json:"sourceName"` // The alias if one is configured
+	BinaryName string                             `json:"binaryName"`
+	Controls   []string                           `json:"controls"`
+}
+
+// SourceDuplicate tells that a source was assigned to a control while other controls keep it as well
+type SourceDuplicate struct {
+	Type          string   `json:"type"` // sourceDuplicate
+	ControlType   string   `json:"controlType"`
+	ControlId     string   `json:"controlId"`
+	SourceName    string   `json:"sourceName"`
+	OtherControls []string `json:"otherControls"`
+}
+
+// ConfigSaveStatus tells that saving the configuration failed or works again after failing
+type ConfigSaveStatus struct {
+	Type  string `json:"type"` // configSaveStatus
+	Ok    bool   `json:"ok"`
+	Error string `json:"error"`
+}
+
+// ProfileSwitched tells that another profile is active. Web clients get the
+// new state instead, it is only posted to webhooks.
+type ProfileSwitched struct {
+	Type     string `json:"type"` // profileSwitched
+	Name     string `json:"name"`
+	Previous string `json:"previous"`
+}
+
+// MidiStatus tells whether the MIDI device is connected, and why not
+type MidiStatus struct {
+	Type      string `json:"type"` // midiStatus
+	Device    string `json:"device"`
+	Connected bool   `json:"connected"`
+	Error     string `json:"error,omitempty"` // Like the port that was not found
+}
+
+// sourceDisplayName returns the alias of a source, or its name without one
+func sourceDisplayName(config *configuration.Config, sourceType configuration.PulseAudioTargetType, name string) string {
+	if alias := config.Alias(sourceType, name); alias != "" {
+		return alias
+	}
+	return name
+}
+
+// EventMessage returns the message for a notification of the configuration
+// manager, and false for topics without one
+func EventMessage(config *configuration.Config, topic string, data interface{}) (interface{}, bool) {
+	update, _ := data.(map[string]interface{})
+	switch topic {
+	case "control.value.updated":
+		controlType, _ := update["type"].(string)
+		controlId, _ := update["id"].(string)
+		value, ok := update["value"].(int)
+		if !ok {
+			return nil, false
+		}
+		origin, _ := update["origin"].(activity.Origin)
+		return ControlValueUpdate{Type: "controlValueUpdate", ControlType: controlType, ControlId: controlId, Value: value, Origin: origin.Kind}, true
+	case "control.muted.updated":
+		controlType, _ := update["controlType"].(string)
+		controlId, _ := update["controlId"].(string)
+		muted, _ := update["muted"].(bool)
+		return ControlMutedUpdate{Type: "controlMutedUpdate", ControlType: controlType, ControlId: controlId, Muted: muted}, true
+	case "source.added":
+		source, ok := update["source"].(configuration.Source)
+		if !ok {
+			return nil, false
+		}
+		return SourceAdded{
+			Type:       "sourceAdded",
+			SourceType: source.Type,
+			SourceName: sourceDisplayName(config, source.Type, source.Name),
+			BinaryName: source.BinaryName,
+		}, true
+	case "source.duplicate":
+		controlType, _ := update["controlType"].(string)
+		controlId, _ := update["controlId"].(string)
+		source, _ := update["source"].(configuration.Source)
+		conflicts, _ := update["conflicts"].([]configuration.SourceConflict)
+		return SourceDuplicate{
+			Type:          "sourceDuplicate",
+			ControlType:   controlType,
+			ControlId:     controlId,
+			SourceName:    sourceDisplayName(config, source.Type, source.Name),
+			OtherControls: otherControls(conflicts),
+		}, true
+	case "config.save.failed":
+		message, _ := update["error"].(string)
+		return ConfigSaveStatus{Type: "configSaveStatus", Ok: false, Error: message}, true
+	case "config.save.recovered":
+		return ConfigSaveStatus{Type: "configSaveStatus", Ok: true}, true
+	case "midi.connected", "midi.disconnected":
+		device, _ := update["device"].(string)
+		message, _ := update["error"].(string)
+		return MidiStatus{Type: "midiStatus", Device: device, Connected: topic == "midi.connected", Error: message}, true
+	case "profile.switched":
+		name, _ := update["name"].(string)
+		previous, _ := update["previous"].(string)
+		return ProfileSwitched{Type: "profileSwitched", Name: name, Previous: previous}, true
+	}
+	return nil, false
+}
+
+// EventTopics are the notifications EventMessage has a message for
+var EventTopics = []string{
+	"config.save.failed",
+	"config.save.recovered",
+	"control.muted.updated",
+	"control.value.updated",
+	"midi.connected",
+	"midi.disconnected",
+	"profile.switched",
+	"source.added",
+	"source.duplicate",
+}
+
+// otherControls lists the controls of the conflicts once each
+func otherControls(conflicts []configuration.SourceConflict) []string {
+	var controls []string
+	for _, conflict := range conflicts {
+		if !slices.Contains(controls, conflict.ControlID) {
+			controls = append(controls, conflict.ControlID)
+		}
+	}
+	return controls
+}
+
+// ProtocolVersion is the version of the commands clients like pulsekontrol
+// remote send. It changes when a command or its reply changes incompatibly.
+const ProtocolVersion = 1
+
+// Welcome is the first message of a WebSocket connection
+type Welcome struct {
+	Type     string `json:"type"` // welcome
+	Message  string `json:"message"`
+	Protocol int    `json:"protocol"` // ProtocolVersion, missing before clients could send commands
+}
+
+// Command runs a request of the control socket protocol, answered by a
+// CommandReply with the same request id
+type Command struct {
+	Type      string          `json:"type"` // command
+	RequestId string          `json:"requestId"`
+	Protocol  int             `json:"protocol"` // ProtocolVersion of the client
+	Request   control.Request `json:"request"`
+}
+
+// CommandReply is the reply to a Command
+type CommandReply struct {
+	Type      string        `json:"type"` // commandReply
+	RequestId string        `json:"requestId"`
+	Protocol  int           `json:"protocol"`
+	Reply     control.Reply `json:"reply"`
+}
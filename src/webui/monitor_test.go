@@ -0,0 +1,109 @@
+package webui
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/actions"
+	"github.com/0h41/pulsekontrol/src/activity"
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/0h41/pulsekontrol/src/internal/testutil"
+	"github.com/gorilla/websocket"
+)
+
+// newTestServer returns a server on a fake audio system that polls every
+// 10ms, with its monitor running until the end of the test
+func newTestServer(t *testing.T, backend *testutil.FakeBackend) *WebUIServer {
+	t.Helper()
+	config := configuration.GetDefaultConfig()
+	config.WebUI.PollInterval = 10 * time.Millisecond
+	configManager := configuration.NewConfigManager(config, "")
+	configManager.SetReadOnly(true)
+	executor := actions.NewExecutor(backend, configManager, activity.NewLog(10))
+	t.Cleanup(executor.Close)
+
+	s := NewWebUIServer("127.0.0.1:0", backend, configManager, executor)
+	configManager.Subscribe(configuration.AllTopics, s.stateChanged)
+	go s.handleBroadcasts()
+	go s.monitorAudioSources()
+	t.Cleanup(func() { close(s.stopChan) })
+	return s
+}
+
+func TestIdleMonitorBuildsNoState(t *testing.T) {
+	backend := testutil.NewFakeBackend(testutil.FakeStream{Type: configuration.PlaybackStream, Name: "Firefox", Volume: 0.5})
+	s := newTestServer(t, backend)
+
+	// Changes without clients wake nobody up, many poll intervals long
+	for i := 0; i < 10; i++ {
+		s.configManager.Notify("source.assigned", nil)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if queries := backend.Queries(); queries != 0 {
+		t.Fatalf("audio sources listed %d times without clients", queries)
+	}
+
+	// The first client gets the state at once
+	server := httptest.NewServer(http.HandlerFunc(s.handleWebSocket))
+	t.Cleanup(server.Close)
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, data, err := client.ReadMessage()
+		if err != nil {
+			t.Fatalf("no state after connecting: %v", err)
+		}
+		if strings.Contains(string(data), `"audioSourcesUpdate"`) {
+			break
+		}
+	}
+	if backend.Queries() == 0 {
+		t.Error("state sent without listing the audio sources")
+	}
+
+	// Idle again once the last client left
+	client.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.clientCount() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("client never removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// The monitor may be building a state while the client leaves
+	time.Sleep(20 * time.Millisecond)
+	queries := backend.Queries()
+	for i := 0; i < 10; i++ {
+		s.configManager.Notify("source.assigned", nil)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if backend.Queries() != queries {
+		t.Errorf("audio sources listed %d times after the last client left", backend.Queries()-queries)
+	}
+}
+
+func TestTransientEventsBuildNoState(t *testing.T) {
+	configManager := configuration.NewConfigManager(configuration.GetDefaultConfig(), "")
+	s := NewWebUIServer("127.0.0.1:0", testutil.NewFakeBackend(), configManager, nil)
+
+	for topic := range transientTopics {
+		s.stateChanged(configuration.TopicEvent{Topic: topic})
+		select {
+		case <-s.stateChanges:
+			t.Errorf("%s woke up the monitor", topic)
+		default:
+		}
+	}
+	s.stateChanged(configuration.TopicEvent{Topic: "source.assigned"})
+	select {
+	case <-s.stateChanges:
+	default:
+		t.Error("source.assigned didn't wake up the monitor")
+	}
+}
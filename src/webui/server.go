@@ -1,52 +1,134 @@
 package webui
 
 import (
+	"context"
+	"crypto/subtle"
 	"embed"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/fs"
+	"net"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/0h41/pulsekontrol/src/actions"
+	"github.com/0h41/pulsekontrol/src/activity"
 	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/0h41/pulsekontrol/src/control"
+	"github.com/0h41/pulsekontrol/src/logging"
 	"github.com/0h41/pulsekontrol/src/pulseaudio"
+	"github.com/0h41/pulsekontrol/src/supervise"
 	"github.com/gorilla/websocket"
-	"github.com/rs/zerolog/log"
 )
 
+// log is the logger of the web interface module
+var log = logging.Module("WebUI")
+
+// clientWriteTimeout is how long a message to a WebSocket client may take
+const clientWriteTimeout = 10 * time.Second
+
 //go:embed static
 var staticFiles embed.FS
 
 type WebUIServer struct {
 	Addr           string
 	upgrader       websocket.Upgrader
-	clients        map[*websocket.Conn]bool
+	clients        map[*clientConn]bool
+	clientsMutex   sync.RWMutex
+	clientWake     chan struct{}
+	// stateChanges wakes up the audio source monitor to send the state
+	stateChanges   chan struct{}
+	// historyClients receive recent actions live
+	historyClients map[*clientConn]bool
+	historyCh      chan activity.Entry
 	broadcast      chan []byte
 	configUpdateCh chan interface{}
 	controlUpdateCh chan map[string]interface{}
-	paClient       *pulseaudio.PAClient
+	paClient       pulseaudio.Backend
 	configManager  *configuration.ConfigManager
+	executor       *actions.Executor
 	stopChan       chan struct{}
+	stopOnce       sync.Once
+	server         *http.Server
+	// listener is the socket passed by systemd, nil to listen on Addr
+	listener       net.Listener
+	// identifyHandler flashes the hardware LEDs of a control
+	identifyHandler func(controlType string, controlId string) error
+	// midiStatus is the last status of the MIDI device, nil until it is known
+	midiStatus      *MidiStatus
+	midiStatusMutex sync.Mutex
 }
 
-func NewWebUIServer(addr string, paClient *pulseaudio.PAClient, configManager *configuration.ConfigManager) *WebUIServer {
-	return &WebUIServer{
+func NewWebUIServer(addr string, paClient pulseaudio.Backend, configManager *configuration.ConfigManager, executor *actions.Executor) *WebUIServer {
+	s := &WebUIServer{
 		Addr: addr,
 		upgrader: websocket.Upgrader{
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
-			CheckOrigin: func(r *http.Request) bool {
-				return true // Allow all connections for now
-			},
 		},
-		clients:         make(map[*websocket.Conn]bool),
+		clients:         make(map[*clientConn]bool),
+		clientWake:      make(chan struct{}, 1),
+		stateChanges:    make(chan struct{}, 1),
+		historyClients:  make(map[*clientConn]bool),
+		historyCh:       make(chan activity.Entry, 64),
 		broadcast:       make(chan []byte),
 		configUpdateCh:  make(chan interface{}),
 		controlUpdateCh: make(chan map[string]interface{}),
 		paClient:        paClient,
 		configManager:   configManager,
+		executor:        executor,
 		stopChan:        make(chan struct{}),
+		server: &http.Server{
+			Addr:         addr,
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		},
+	}
+	s.upgrader.CheckOrigin = s.checkOrigin
+	return s
+}
+
+// checkOrigin allows WebSocket connections from the configured origins. The
+// list is read on every connection so changes apply without a restart.
+func (s *WebUIServer) checkOrigin(r *http.Request) bool {
+	allowed := s.configManager.GetConfigSnapshot().WebUI.AllowedOrigins
+	origin := r.Header.Get("Origin")
+	if len(allowed) == 0 || origin == "" {
+		// Nothing configured, or not a browser
+		return true
+	}
+	for _, candidate := range allowed {
+		if candidate == "*" || strings.EqualFold(candidate, origin) {
+			return true
+		}
+	}
+	log.Warn().Str("origin", origin).Msg("Rejected WebSocket connection from disallowed origin")
+	return false
+}
+
+// requireToken rejects requests without the configured auth token, given
+// either as a bearer token or as the token query parameter
+func (s *WebUIServer) requireToken(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		expected := s.configManager.GetConfigSnapshot().WebUI.AuthToken
+		if expected == "" {
+			handler(w, r)
+			return
+		}
+		token := r.URL.Query().Get("token")
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			token = bearer
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
 	}
 }
 
@@ -58,35 +140,81 @@ func (s *WebUIServer) Start() error {
 	}
 
 	// Setup HTTP server and routes
-	http.Handle("/", http.FileServer(http.FS(staticFS)))
-	http.HandleFunc("/ws", s.handleWebSocket)
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.FS(staticFS)))
+	mux.HandleFunc("/ws", s.requireToken(s.handleWebSocket))
+	mux.HandleFunc("/api/history", s.requireToken(s.handleHistory))
+	mux.HandleFunc("/api/config/effective", s.requireToken(s.handleEffectiveConfig))
+	mux.HandleFunc("/api/profile/export", s.requireToken(s.handleProfileExport))
+	mux.HandleFunc("/api/profile/import", s.requireToken(s.handleProfileImport))
+	mux.HandleFunc("/api/assignments", s.requireToken(s.handleAssignments))
+	s.server.Handler = mux
+
+	// Stream recent actions to subscribed clients
+	s.executor.Activity().Subscribe(s.notifyHistoryEntry)
 
 	// Start WebSocket broadcasting
-	go s.handleBroadcasts()
+	supervise.Go("webui.broadcasts", s.handleBroadcasts)
 
-	// Start audio sources monitoring
-	go s.monitorAudioSources()
+	// Start audio sources monitoring, woken up by changes of the sources and
+	// the configuration
+	s.configManager.Subscribe(configuration.AllTopics, s.stateChanged)
+	supervise.Go("webui.audioSources", s.monitorAudioSources)
 
-	// Start HTTP server
+	// Start HTTP server, it returns http.ErrServerClosed after Shutdown
+	if s.listener != nil {
+		log.Info().Msgf("Starting web server on %s, passed by systemd", s.listener.Addr())
+		return s.server.Serve(s.listener)
+	}
 	log.Info().Msgf("Starting web server on %s", s.Addr)
-	server := &http.Server{
-		Addr:         s.Addr,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
+	return s.server.ListenAndServe()
+}
+
+// SetListener serves on a socket that is already listening, like one passed
+// by systemd socket activation, instead of listening on Addr. It must be
+// called before Start.
+func (s *WebUIServer) SetListener(listener net.Listener) {
+	s.listener = listener
+}
+
+// Shutdown stops accepting connections, tells the WebSocket clients that the
+// server is going away and stops the background work. It waits for running
+// HTTP requests until ctx is done.
+func (s *WebUIServer) Shutdown(ctx context.Context) error {
+	s.stopOnce.Do(func() {
+		close(s.stopChan)
+	})
+
+	// WebSocket connections are hijacked, the HTTP server doesn't know about them
+	closeMessage := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	deadline := time.Now().Add(time.Second)
+	for _, client := range s.snapshotClients() {
+		if err := client.WriteControl(websocket.CloseMessage, closeMessage, deadline); err != nil {
+			log.Debug().Err(err).Str("remote", client.RemoteAddr().String()).Msg("Failed to send close frame")
+		}
+		client.Close()
+		s.removeClient(client)
 	}
-	return server.ListenAndServe()
+
+	return s.server.Shutdown(ctx)
 }
 
 // buildUIStateMessage creates a message with current UI state
 func (s *WebUIServer) buildUIStateMessage(includeControlValues bool) ([]byte, error) {
 	// Get audio sources
-	sources := s.paClient.GetAudioSources()
+	sources := s.audioSources()
 	
 	// Get control assignments
-	config := s.configManager.GetConfig()
+	config := s.configManager.GetConfigSnapshot()
+	
+	// When assigned sources were last present (sourceId -> time)
+	lastSeen := make(map[string]time.Time)
 	
 	// Map of slider assignments (controlId -> sourceIds)
 	sliderAssignments := make(map[string][]string)
+	sliderMuted := make(map[string]bool)
+	sliderLabels := make(map[string]string)
+	sliderGroups := make(map[string][]string)
 	var sliderValues map[string]int
 	if includeControlValues {
 		sliderValues = make(map[string]int)
@@ -105,7 +233,7 @@ func (s *WebUIServer) buildUIStateMessage(includeControlValues bool) ([]byte, er
 				
 				// For enhanced configs (with BinaryName), require exact match
 				// For legacy configs (without BinaryName), match any stream with same name/type
-				if audioSourceTypeLower == sourceTypeLower && audioSource.Name == source.Name {
+				if audioSourceTypeLower == sourceTypeLower && audioSource.RawName == source.Name {
 					if source.BinaryName != "" {
 						// Enhanced config: require exact BinaryName match
 						if audioSource.BinaryName == source.BinaryName {
@@ -131,8 +259,18 @@ func (s *WebUIServer) buildUIStateMessage(includeControlValues bool) ([]byte, er
 				}
 				sourceIds = append(sourceIds, virtualId)
 			}
+			if !source.LastSeen.IsZero() {
+				lastSeen[sourceIds[len(sourceIds)-1]] = source.LastSeen
+			}
 		}
 		sliderAssignments[id] = sourceIds
+		sliderMuted[id] = slider.Muted
+		if slider.Label != "" {
+			sliderLabels[id] = slider.Label
+		}
+		if len(slider.Groups) > 0 {
+			sliderGroups[id] = slider.Groups
+		}
 		if includeControlValues {
 			sliderValues[id] = slider.Value
 		}
@@ -140,6 +278,10 @@ func (s *WebUIServer) buildUIStateMessage(includeControlValues bool) ([]byte, er
 	
 	// Map of knob assignments (controlId -> sourceIds)
 	knobAssignments := make(map[string][]string)
+	knobMuted := make(map[string]bool)
+	knobLabels := make(map[string]string)
+	knobGroups := make(map[string][]string)
+	knobBalance := make(map[string]bool)
 	var knobValues map[string]int
 	if includeControlValues {
 		knobValues = make(map[string]int)
@@ -158,7 +300,7 @@ func (s *WebUIServer) buildUIStateMessage(includeControlValues bool) ([]byte, er
 				
 				// For enhanced configs (with BinaryName), require exact match
 				// For legacy configs (without BinaryName), match any stream with same name/type
-				if audioSourceTypeLower == sourceTypeLower && audioSource.Name == source.Name {
+				if audioSourceTypeLower == sourceTypeLower && audioSource.RawName == source.Name {
 					if source.BinaryName != "" {
 						// Enhanced config: require exact BinaryName match
 						if audioSource.BinaryName == source.BinaryName {
@@ -184,8 +326,21 @@ func (s *WebUIServer) buildUIStateMessage(includeControlValues bool) ([]byte, er
 				}
 				sourceIds = append(sourceIds, virtualId)
 			}
+			if !source.LastSeen.IsZero() {
+				lastSeen[sourceIds[len(sourceIds)-1]] = source.LastSeen
+			}
 		}
 		knobAssignments[id] = sourceIds
+		knobMuted[id] = knob.Muted
+		if knob.Label != "" {
+			knobLabels[id] = knob.Label
+		}
+		if len(knob.Groups) > 0 {
+			knobGroups[id] = knob.Groups
+		}
+		if knob.ControlsBalance() {
+			knobBalance[id] = true
+		}
 		if includeControlValues {
 			knobValues[id] = knob.Value
 		}
@@ -197,7 +352,27 @@ func (s *WebUIServer) buildUIStateMessage(includeControlValues bool) ([]byte, er
 		"sources":           sources,
 		"sliderAssignments": sliderAssignments,
 		"knobAssignments":   knobAssignments,
+		"sliderMuted":       sliderMuted,
+		"knobMuted":         knobMuted,
+		"sliderLabels":      sliderLabels,
+		"knobLabels":        knobLabels,
+		"sliderGroups":      sliderGroups,
+		"knobGroups":        knobGroups,
+		"knobBalance":       knobBalance,
+		"scenes":            s.configManager.SceneNames(),
+		"combinedSinks":     s.configManager.CombinedSinkNames(),
+		"profiles":          s.configManager.ProfileNames(),
+		"activeProfile":     s.configManager.ActiveProfile(),
+		"aliases":           config.Aliases,
+		"lastSeen":          lastSeen,
+		"readOnly":          s.configManager.ReadOnly(),
+		"dryRun":            s.paClient.DryRun(),
+	}
+	s.midiStatusMutex.Lock()
+	if s.midiStatus != nil {
+		message["midiStatus"] = *s.midiStatus
 	}
+	s.midiStatusMutex.Unlock()
 	
 	// Only include control values if requested (for initial load)
 	if includeControlValues {
@@ -210,33 +385,40 @@ func (s *WebUIServer) buildUIStateMessage(includeControlValues bool) ([]byte, er
 }
 
 func (s *WebUIServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if maxClients := s.configManager.GetConfigSnapshot().WebUI.MaxClients; maxClients > 0 && s.clientCount() >= maxClients {
+		log.Warn().Int("maxClients", maxClients).Str("remote", r.RemoteAddr).Msg("Rejected WebSocket client, too many clients connected")
+		http.Error(w, "too many clients", http.StatusServiceUnavailable)
+		return
+	}
+
 	// Upgrade HTTP connection to WebSocket
-	conn, err := s.upgrader.Upgrade(w, r, nil)
+	upgraded, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to upgrade to websocket")
 		return
 	}
+	conn := &clientConn{Conn: upgraded}
 	defer conn.Close()
 
-	// Register new client
-	s.clients[conn] = true
-	log.Info().Msgf("New WebSocket client connected: %s", conn.RemoteAddr())
-
-	// Send initial state
-	initialMsg := []byte(`{"type":"welcome","message":"Connected to pulsekontrol"}`)
+	// Send the welcome before any broadcast, clients check its protocol version
+	initialMsg, _ := json.Marshal(Welcome{Type: "welcome", Message: "Connected to pulsekontrol", Protocol: ProtocolVersion})
 	err = conn.WriteMessage(websocket.TextMessage, initialMsg)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to send welcome message")
-		delete(s.clients, conn)
 		return
 	}
 
+	// Register new client
+	s.addClient(conn)
+	log.Info().Msgf("New WebSocket client connected: %s", conn.RemoteAddr())
+	origin := activity.Web(conn.RemoteAddr().String())
+
 	// Handle client messages
 	for {
 		_, message, err := conn.ReadMessage()
 		if err != nil {
 			log.Info().Msgf("WebSocket client disconnected: %s", conn.RemoteAddr())
-			delete(s.clients, conn)
+			s.removeClient(conn)
 			break
 		}
 
@@ -271,7 +453,7 @@ func (s *WebUIServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 			err = conn.WriteMessage(websocket.TextMessage, jsonData)
 			if err != nil {
 				log.Error().Err(err).Msg("Failed to send initial state to client")
-				delete(s.clients, conn)
+				s.removeClient(conn)
 				return
 			}
 		case "setVolume":
@@ -292,7 +474,7 @@ func (s *WebUIServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 			log.Debug().Str("sourceId", sourceId).Int("volume", volume).Msg("Setting volume")
 			
 			// Get the sources and find the one with matching ID
-			sources := s.paClient.GetAudioSources()
+			sources := s.audioSources()
 			var targetSource *pulseaudio.AudioSource
 			
 			for _, source := range sources {
@@ -337,16 +519,39 @@ func (s *WebUIServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 				Type: configuration.SetVolume,
 				Target: &configuration.TypedTarget{
 					Type: targetType,
-					Name: targetSource.Name,
+					Name: targetSource.RawName,
 				},
 			}
 			
 			// Convert 0-100 volume to 0-1 for PulseAudio
 			volumePercent := float32(volume) / 100.0
+			// With a scale, the volume is the position of a fader mapped like
+			// the scale of a control
+			if scale, ok := clientMsg["scale"].(string); ok {
+				if !configuration.VolumeScale(scale).Known() {
+					log.Error().Str("scale", scale).Msg("setVolume has an unknown scale")
+					continue
+				}
+				floorDb, _ := clientMsg["floorDb"].(float64)
+				mapping := configuration.VolumeMapping{Scale: configuration.VolumeScale(scale), FloorDb: int(floorDb)}
+				volumePercent = float32(mapping.Volume(volume) / 100)
+			}
 			
 			// Set volume
+			s.executor.Record(origin, string(configuration.SetVolume), sourceId, volume)
 			if err := s.paClient.ProcessVolumeAction(action, volumePercent); err != nil {
 				log.Error().Err(err).Str("sourceId", sourceId).Msg("Failed to set volume")
+				if err := s.ackFailed(conn, "setVolume", sourceId, err); err != nil {
+					log.Error().Err(err).Msg("Failed to send error reply to client")
+					s.removeClient(conn)
+					return
+				}
+				continue
+			}
+			if err := s.ackSimulated(conn, "setVolume", sourceId); err != nil {
+				log.Error().Err(err).Msg("Failed to send dry run reply to client")
+				s.removeClient(conn)
+				return
 			}
 			
 		case "updateControlValue":
@@ -372,9 +577,225 @@ func (s *WebUIServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 			value := int(valueFloat)
 			log.Debug().Str("controlId", controlId).Str("controlType", controlType).Int("value", value).Msg("Updating control value")
 			
-			// Update configuration
-			s.configManager.UpdateControlValue(controlType, controlId, value)
+			// Queued, the volumes and the configuration follow
+			s.executor.QueueControlValue(origin, controlType, controlId, value)
+			if err := s.ackSimulated(conn, "updateControlValue", controlId); err != nil {
+				log.Error().Err(err).Msg("Failed to send dry run reply to client")
+				s.removeClient(conn)
+				return
+			}
 			
+		case "identifyControl":
+			// Client wants to see which physical control this is
+			controlId, ok := clientMsg["controlId"].(string)
+			if !ok {
+				log.Error().Msg("identifyControl missing controlId")
+				continue
+			}
+
+			controlType, ok := clientMsg["controlType"].(string)
+			if !ok {
+				log.Error().Msg("identifyControl missing controlType")
+				continue
+			}
+
+			reply := map[string]interface{}{
+				"type":        "identifyControlResult",
+				"controlType": controlType,
+				"controlId":   controlId,
+				"ok":          true,
+			}
+			if s.identifyHandler == nil {
+				reply["ok"] = false
+				reply["error"] = "No MIDI device available to identify controls"
+			} else if err := s.identifyHandler(controlType, controlId); err != nil {
+				log.Warn().Err(err).Str("controlId", controlId).Msg("Failed to identify control")
+				reply["ok"] = false
+				reply["error"] = fmt.Sprintf("Cannot identify %s: %s", controlId, err)
+			}
+
+			jsonData, err := json.Marshal(reply)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to marshal identify reply")
+				continue
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, jsonData); err != nil {
+				log.Error().Err(err).Msg("Failed to send identify reply to client")
+				s.removeClient(conn)
+				return
+			}
+
+		case "setMuted":
+			// Client wants to mute or unmute the sources of a control
+			controlId, ok := clientMsg["controlId"].(string)
+			if !ok {
+				log.Error().Msg("setMuted missing controlId")
+				continue
+			}
+
+			controlType, ok := clientMsg["controlType"].(string)
+			if !ok {
+				log.Error().Msg("setMuted missing controlType")
+				continue
+			}
+
+			muted, ok := clientMsg["muted"].(bool)
+			if !ok {
+				log.Error().Msg("setMuted missing muted")
+				continue
+			}
+
+			// The new state reaches all clients through the control.muted.updated notification
+			if err := s.executor.SetControlMuted(origin, controlType, controlId, muted); err != nil {
+				log.Warn().Err(err).Str("controlId", controlId).Msg("Failed to set mute")
+				if err := s.ackFailed(conn, "setMuted", controlId, err); err != nil {
+					log.Error().Err(err).Msg("Failed to send error reply to client")
+					s.removeClient(conn)
+					return
+				}
+				continue
+			}
+			if err := s.ackSimulated(conn, "setMuted", controlId); err != nil {
+				log.Error().Err(err).Msg("Failed to send dry run reply to client")
+				s.removeClient(conn)
+				return
+			}
+
+		case "moveStream":
+			// Client wants to play a playback stream on another output device
+			sourceId, _ := clientMsg["sourceId"].(string)
+			sink, _ := clientMsg["sink"].(string)
+			if sourceId == "" || sink == "" {
+				log.Error().Msg("moveStream missing sourceId or sink")
+				continue
+			}
+
+			sourceType, sourceName, ok := s.resolveSourceId(sourceId)
+			if !ok || sourceType != configuration.PlaybackStream {
+				log.Error().Str("sourceId", sourceId).Msg("moveStream needs a playback stream")
+				continue
+			}
+
+			target := &configuration.TypedTarget{Type: sourceType, Name: sourceName}
+			if err := s.executor.MoveStreams(origin, target, sink); err != nil {
+				log.Warn().Err(err).Str("source", sourceName).Str("sink", sink).Msg("Failed to move stream")
+				if err := s.ackFailed(conn, "moveStream", sourceName, err); err != nil {
+					log.Error().Err(err).Msg("Failed to send error reply to client")
+					s.removeClient(conn)
+					return
+				}
+				continue
+			}
+			if err := s.ackSimulated(conn, "moveStream", sourceName); err != nil {
+				log.Error().Err(err).Msg("Failed to send dry run reply to client")
+				s.removeClient(conn)
+				return
+			}
+
+		case "setSuspended":
+			// Client wants to suspend an output device or resume it
+			sourceId, _ := clientMsg["sourceId"].(string)
+			suspended, ok := clientMsg["suspended"].(bool)
+			if sourceId == "" || !ok {
+				log.Error().Msg("setSuspended missing sourceId or suspended")
+				continue
+			}
+
+			sourceType, sourceName, ok := s.resolveSourceId(sourceId)
+			if !ok || sourceType != configuration.OutputDevice {
+				log.Error().Str("sourceId", sourceId).Msg("setSuspended needs an output device")
+				continue
+			}
+
+			// The new state reaches all clients with the next sources update
+			if err := s.executor.SetOutputSuspended(origin, sourceName, suspended); err != nil {
+				log.Warn().Err(err).Str("output", sourceName).Msg("Failed to suspend or resume output")
+				if err := s.ackFailed(conn, "setSuspended", sourceName, err); err != nil {
+					log.Error().Err(err).Msg("Failed to send error reply to client")
+					s.removeClient(conn)
+					return
+				}
+				continue
+			}
+			if err := s.ackSimulated(conn, "setSuspended", sourceName); err != nil {
+				log.Error().Err(err).Msg("Failed to send dry run reply to client")
+				s.removeClient(conn)
+				return
+			}
+
+		case "moveControl":
+			// Client wants to move the sources of a control to another, or swap them
+			fromType, _ := clientMsg["fromType"].(string)
+			fromId, _ := clientMsg["fromId"].(string)
+			toType, _ := clientMsg["toType"].(string)
+			toId, _ := clientMsg["toId"].(string)
+			if fromId == "" || toId == "" {
+				log.Error().Msg("moveControl missing fromId or toId")
+				continue
+			}
+			swap, _ := clientMsg["swap"].(bool)
+			moveValues, _ := clientMsg["moveValues"].(bool)
+
+			// The new assignments reach all clients through the control.moved notification
+			err := s.configManager.MoveControlSources(fromType, fromId, toType, toId, swap, moveValues)
+			if err == nil {
+				action := "MoveControl"
+				if swap {
+					action = "SwapControls"
+				}
+				s.executor.Record(origin, action, fromId, toId)
+			} else {
+				log.Warn().Err(err).Str("from", fromId).Str("to", toId).Msg("Failed to move control sources")
+			}
+
+			reply := map[string]interface{}{
+				"type":   "moveControlResult",
+				"fromId": fromId,
+				"toId":   toId,
+				"swap":   swap,
+				"ok":     err == nil,
+			}
+			if err != nil {
+				reply["error"] = err.Error()
+			}
+
+			jsonData, err := json.Marshal(reply)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to marshal move reply")
+				continue
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, jsonData); err != nil {
+				log.Error().Err(err).Msg("Failed to send move reply to client")
+				s.removeClient(conn)
+				return
+			}
+
+		case "setAlias":
+			// Client wants to rename an audio source, an empty alias removes it
+			sourceId, ok := clientMsg["sourceId"].(string)
+			if !ok {
+				log.Error().Msg("setAlias missing sourceId")
+				continue
+			}
+
+			alias, ok := clientMsg["alias"].(string)
+			if !ok {
+				log.Error().Msg("setAlias missing alias")
+				continue
+			}
+
+			sourceType, sourceName, ok := s.resolveSourceId(sourceId)
+			if !ok {
+				log.Error().Str("sourceId", sourceId).Msg("Invalid source ID format")
+				continue
+			}
+
+			// The new name reaches all clients through the alias.updated notification
+			s.executor.Record(origin, "SetAlias", configuration.AliasKey(sourceType, sourceName), alias)
+			if err := s.configManager.SetAlias(sourceType, sourceName, alias); err != nil {
+				log.Warn().Err(err).Str("sourceId", sourceId).Msg("Failed to set alias")
+			}
+
 		case "assignControl":
 			// Client wants to assign a source to a control
 			controlId, ok := clientMsg["controlId"].(string)
@@ -400,10 +821,35 @@ func (s *WebUIServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 				Str("controlType", controlType).
 				Str("sourceId", sourceId).
 				Msg("Assigning source to control")
+
+			// Sources of a control the device can't send would never be adjusted
+			snapshot := s.configManager.GetConfigSnapshot()
+			if err := snapshot.CheckControlPath(controlType, controlId); err != nil {
+				log.Warn().Err(err).Str("controlId", controlId).Msg("Refusing to assign source")
+				reply := map[string]interface{}{
+					"type":        "assignControlResult",
+					"controlType": controlType,
+					"controlId":   controlId,
+					"ok":          false,
+					"error":       err.Error(),
+				}
+				jsonData, err := json.Marshal(reply)
+				if err != nil {
+					log.Error().Err(err).Msg("Failed to marshal assign reply")
+					continue
+				}
+				if err := conn.WriteMessage(websocket.TextMessage, jsonData); err != nil {
+					log.Error().Err(err).Msg("Failed to send assign reply to client")
+					s.removeClient(conn)
+					return
+				}
+				continue
+			}
 			
 			// Check if this is a real source or a virtual source
-			sources := s.paClient.GetAudioSources()
+			sources := s.audioSources()
 			var sourceToAssign *pulseaudio.AudioSource
+			var result configuration.AssignResult
 			
 			// First check if it's a real available source
 			for _, source := range sources {
@@ -418,12 +864,13 @@ func (s *WebUIServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 				// Create configuration source
 				configSource := configuration.Source{
 					Type:       configuration.PulseAudioTargetType(sourceToAssign.Type),
-					Name:       sourceToAssign.Name,
+					Name:       sourceToAssign.RawName,
 					BinaryName: sourceToAssign.BinaryName,
 				}
 				
 				// Update configuration
-				s.configManager.AssignSource(controlType, controlId, configSource)
+				s.executor.Record(origin, "AssignSource", controlId, actions.DescribeSource(configSource))
+				result = s.configManager.AssignSource(controlType, controlId, configSource)
 			} else {
 				// It might be a virtual ID for an inactive source
 				parts := strings.SplitN(sourceId, ":", 3)
@@ -457,6 +904,10 @@ func (s *WebUIServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 					default:
 						targetType = configuration.PulseAudioTargetType(sourceType)
 					}
+
+					// Accept the alias of an inactive source as well as its real name
+					config := s.configManager.GetConfigSnapshot()
+					sourceName = config.ResolveAlias(targetType, sourceName)
 					
 					// Create configuration source
 					configSource := configuration.Source{
@@ -466,12 +917,42 @@ func (s *WebUIServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 					}
 					
 					// Update configuration
-					s.configManager.AssignSource(controlType, controlId, configSource)
+					s.executor.Record(origin, "AssignSource", controlId, actions.DescribeSource(configSource))
+					result = s.configManager.AssignSource(controlType, controlId, configSource)
 				} else {
 					log.Error().Str("sourceId", sourceId).Msg("Invalid source ID format")
 					continue
 				}
 			}
+
+			// Tell the client what happened to the other controls that had the source
+			if result.Policy != "" {
+				conflicts := make([]map[string]interface{}, 0, len(result.Conflicts))
+				for _, conflict := range result.Conflicts {
+					conflicts = append(conflicts, map[string]interface{}{
+						"controlType": conflict.ControlType,
+						"controlId":   conflict.ControlID,
+					})
+				}
+				reply := map[string]interface{}{
+					"type":        "assignControlResult",
+					"controlType": controlType,
+					"controlId":   controlId,
+					"ok":          true,
+					"policy":      result.Policy,
+					"conflicts":   conflicts,
+				}
+				jsonData, err := json.Marshal(reply)
+				if err != nil {
+					log.Error().Err(err).Msg("Failed to marshal assign reply")
+					continue
+				}
+				if err := conn.WriteMessage(websocket.TextMessage, jsonData); err != nil {
+					log.Error().Err(err).Msg("Failed to send assign reply to client")
+					s.removeClient(conn)
+					return
+				}
+			}
 			
 		case "unassignControl":
 			// Client wants to remove a source from a control
@@ -500,7 +981,7 @@ func (s *WebUIServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 				Msg("Removing source from control")
 			
 			// Find the audio source in the available sources
-			sources := s.paClient.GetAudioSources()
+			sources := s.audioSources()
 			var sourceToRemove *pulseaudio.AudioSource
 			
 			for _, source := range sources {
@@ -514,9 +995,10 @@ func (s *WebUIServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 				// Source is active, unassign normally
 				sourceToUnassign := configuration.Source{
 					Type:       configuration.PulseAudioTargetType(sourceToRemove.Type),
-					Name:       sourceToRemove.Name,
+					Name:       sourceToRemove.RawName,
 					BinaryName: sourceToRemove.BinaryName,
 				}
+				s.executor.Record(origin, "UnassignSource", controlId, actions.DescribeSource(sourceToUnassign))
 				s.configManager.UnassignSource(
 					controlType,
 					controlId,
@@ -556,12 +1038,17 @@ func (s *WebUIServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 					default:
 						targetType = configuration.PulseAudioTargetType(sourceType)
 					}
+
+					// Accept the alias of an inactive source as well as its real name
+					config := s.configManager.GetConfigSnapshot()
+					sourceName = config.ResolveAlias(targetType, sourceName)
 					
 					virtualSource := configuration.Source{
 						Type:       targetType,
 						Name:       sourceName,
 						BinaryName: sourceBinaryName,
 					}
+					s.executor.Record(origin, "UnassignSource", controlId, actions.DescribeSource(virtualSource))
 					s.configManager.UnassignSource(
 						controlType,
 						controlId,
@@ -573,6 +1060,275 @@ func (s *WebUIServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 				}
 			}
 			
+		case "subscribeHistory":
+			// Client wants recent actions, followed by live updates
+			s.clientsMutex.Lock()
+			s.historyClients[conn] = true
+			s.clientsMutex.Unlock()
+
+			jsonData, err := json.Marshal(map[string]interface{}{
+				"type":    "history",
+				"enabled": s.executor.Activity().Enabled(),
+				"entries": s.executor.Activity().Entries(0),
+			})
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to marshal history")
+				continue
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, jsonData); err != nil {
+				log.Error().Err(err).Msg("Failed to send history to client")
+				s.removeClient(conn)
+				return
+			}
+
+		case "undo", "redo":
+			// Client wants to revert or re-apply the last configuration change
+			var description string
+			var err error
+			historyAction := "Undo"
+			if msgType == "undo" {
+				description, err = s.configManager.Undo()
+			} else {
+				historyAction = "Redo"
+				description, err = s.configManager.Redo()
+			}
+			if err == nil {
+				s.executor.Record(origin, historyAction, description, nil)
+			}
+
+			reply := map[string]interface{}{
+				"type":        "historyResult",
+				"action":      msgType,
+				"ok":          err == nil,
+				"description": description,
+			}
+			if err != nil {
+				reply["error"] = err.Error()
+			}
+
+			jsonData, err := json.Marshal(reply)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to marshal history reply")
+				continue
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, jsonData); err != nil {
+				log.Error().Err(err).Msg("Failed to send history reply to client")
+				s.removeClient(conn)
+				return
+			}
+
+		case "switchProfile", "createProfile", "deleteProfile", "copyProfile":
+			// Client wants to manage configuration profiles
+			name, ok := clientMsg["name"].(string)
+			if !ok || strings.TrimSpace(name) == "" {
+				log.Error().Str("type", msgType).Msg("Profile message missing name")
+				continue
+			}
+			name = strings.TrimSpace(name)
+
+			var err error
+			switch msgType {
+			case "switchProfile":
+				s.executor.Record(origin, "SwitchProfile", name, nil)
+				err = s.configManager.SwitchProfile(name)
+			case "createProfile":
+				s.executor.Record(origin, "CreateProfile", name, nil)
+				err = s.configManager.CreateProfile(name)
+			case "deleteProfile":
+				s.executor.Record(origin, "DeleteProfile", name, nil)
+				err = s.configManager.DeleteProfile(name)
+			case "copyProfile":
+				source, _ := clientMsg["source"].(string)
+				s.executor.Record(origin, "CopyProfile", name, source)
+				err = s.configManager.CopyProfile(source, name)
+			}
+
+			reply := map[string]interface{}{
+				"type":          "profileResult",
+				"action":        msgType,
+				"name":          name,
+				"ok":            err == nil,
+				"profiles":      s.configManager.ProfileNames(),
+				"activeProfile": s.configManager.ActiveProfile(),
+			}
+			if err != nil {
+				log.Warn().Err(err).Str("profile", name).Msg("Profile operation failed")
+				reply["error"] = err.Error()
+			}
+
+			jsonData, err := json.Marshal(reply)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to marshal profile reply")
+				continue
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, jsonData); err != nil {
+				log.Error().Err(err).Msg("Failed to send profile reply to client")
+				s.removeClient(conn)
+				return
+			}
+
+		case "saveScene", "recallScene", "deleteScene":
+			// Client wants to manage a volume scene
+			name, ok := clientMsg["name"].(string)
+			if !ok || strings.TrimSpace(name) == "" {
+				log.Error().Str("type", msgType).Msg("Scene message missing name")
+				continue
+			}
+			name = strings.TrimSpace(name)
+
+			reply := map[string]interface{}{
+				"type":   "sceneResult",
+				"action": msgType,
+				"name":   name,
+				"ok":     true,
+			}
+			switch msgType {
+			case "saveScene":
+				includeVolumes, _ := clientMsg["includeVolumes"].(bool)
+				s.executor.SaveScene(origin, name, includeVolumes)
+			case "recallScene":
+				rampMs, _ := clientMsg["rampMs"].(float64)
+				if err := s.executor.RecallScene(origin, name, time.Duration(rampMs)*time.Millisecond); err != nil {
+					log.Warn().Err(err).Str("scene", name).Msg("Failed to recall scene")
+					reply["ok"] = false
+					reply["error"] = err.Error()
+				}
+			case "deleteScene":
+				if s.configManager.DeleteScene(name) {
+					s.executor.Record(origin, "DeleteScene", name, nil)
+				} else {
+					reply["ok"] = false
+					reply["error"] = fmt.Sprintf("scene %s not found", name)
+				}
+			}
+			reply["scenes"] = s.configManager.SceneNames()
+
+			jsonData, err := json.Marshal(reply)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to marshal scene reply")
+				continue
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, jsonData); err != nil {
+				log.Error().Err(err).Msg("Failed to send scene reply to client")
+				s.removeClient(conn)
+				return
+			}
+
+		case "createCombinedSink", "deleteCombinedSink":
+			// Client wants to create or remove an output device playing on others
+			name, ok := clientMsg["name"].(string)
+			if !ok || strings.TrimSpace(name) == "" {
+				log.Error().Str("type", msgType).Msg("Combined sink message missing name")
+				continue
+			}
+			name = strings.TrimSpace(name)
+
+			reply := map[string]interface{}{
+				"type":   "combinedSinkResult",
+				"action": msgType,
+				"name":   name,
+				"ok":     true,
+			}
+			if msgType == "createCombinedSink" {
+				rawOutputs, _ := clientMsg["outputs"].([]interface{})
+				var outputs []string
+				for _, output := range rawOutputs {
+					if output, ok := output.(string); ok {
+						outputs = append(outputs, output)
+					}
+				}
+				if err := s.configManager.SetCombinedSink(name, outputs); err != nil {
+					log.Warn().Err(err).Str("sink", name).Msg("Failed to create combined sink")
+					reply["ok"] = false
+					reply["error"] = err.Error()
+				} else {
+					s.executor.Record(origin, "CreateCombinedSink", name, outputs)
+				}
+			} else if s.configManager.DeleteCombinedSink(name) {
+				s.executor.Record(origin, "DeleteCombinedSink", name, nil)
+			} else {
+				reply["ok"] = false
+				reply["error"] = fmt.Sprintf("combined sink %s not found", name)
+			}
+			reply["combinedSinks"] = s.configManager.CombinedSinkNames()
+
+			jsonData, err := json.Marshal(reply)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to marshal combined sink reply")
+				continue
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, jsonData); err != nil {
+				log.Error().Err(err).Msg("Failed to send combined sink reply to client")
+				s.removeClient(conn)
+				return
+			}
+
+		case "getStaleSources", "removeStaleSources":
+			// Client wants to list or clean up sources that haven't been seen for a long time
+			var stale []configuration.StaleSource
+			if msgType == "removeStaleSources" {
+				stale = s.configManager.RemoveStaleSources()
+				s.executor.Record(origin, "RemoveStaleSources", "", len(stale))
+			} else {
+				stale = s.configManager.StaleSources()
+			}
+			sources := make([]map[string]interface{}, 0, len(stale))
+			for _, entry := range stale {
+				sources = append(sources, map[string]interface{}{
+					"profile":     entry.Profile,
+					"controlType": entry.ControlType,
+					"controlId":   entry.ControlID,
+					"sourceType":  entry.Source.Type,
+					"sourceName":  entry.Source.Name,
+					"binaryName":  entry.Source.BinaryName,
+					"lastSeen":    entry.Source.LastSeen,
+				})
+			}
+
+			jsonData, err := json.Marshal(map[string]interface{}{
+				"type":    "staleSourcesResult",
+				"action":  msgType,
+				"sources": sources,
+			})
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to marshal stale sources reply")
+				continue
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, jsonData); err != nil {
+				log.Error().Err(err).Msg("Failed to send stale sources reply to client")
+				s.removeClient(conn)
+				return
+			}
+			if msgType == "removeStaleSources" && len(stale) > 0 {
+				s.BroadcastState()
+			}
+
+		case "command":
+			// A command of pulsekontrol remote, answered with its request id
+			var command Command
+			reply := CommandReply{Type: "commandReply", Protocol: ProtocolVersion}
+			if err := json.Unmarshal(message, &command); err != nil {
+				reply.Reply = control.Reply{Error: fmt.Sprintf("invalid command: %v", err)}
+			} else if reply.RequestId = command.RequestId; command.Protocol != ProtocolVersion {
+				reply.Reply = control.Reply{Error: fmt.Sprintf("protocol version %d is not supported, this pulsekontrol speaks version %d", command.Protocol, ProtocolVersion)}
+			} else {
+				reply.Reply = control.Handle(s.executor, origin, command.Request)
+			}
+			if !reply.Reply.Ok {
+				log.Debug().Str("error", reply.Reply.Error).Str("command", command.Request.Command).Msg("Remote command failed")
+			}
+
+			jsonData, err := json.Marshal(reply)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to marshal command reply")
+				continue
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, jsonData); err != nil {
+				log.Error().Err(err).Msg("Failed to send command reply to client")
+				s.removeClient(conn)
+				return
+			}
+
 		default:
 			log.Debug().Str("type", msgType).Msg("Unknown message type")
 		}
@@ -584,13 +1340,14 @@ func (s *WebUIServer) handleBroadcasts() {
 		select {
 		case message := <-s.broadcast:
 			// Send to all connected clients
-			log.Debug().Int("clientCount", len(s.clients)).Str("message", string(message)).Msg("Broadcasting message to WebSocket clients")
-			for client := range s.clients {
+			clients := s.snapshotClients()
+			log.Debug().Int("clientCount", len(clients)).Str("message", string(message)).Msg("Broadcasting message to WebSocket clients")
+			for _, client := range clients {
 				err := client.WriteMessage(websocket.TextMessage, message)
 				if err != nil {
 					log.Error().Err(err).Msg("Failed to send message to client")
 					client.Close()
-					delete(s.clients, client)
+					s.removeClient(client)
 				} else {
 					log.Debug().Msg("Successfully sent message to WebSocket client")
 				}
@@ -598,23 +1355,46 @@ func (s *WebUIServer) handleBroadcasts() {
 		case controlUpdate := <-s.controlUpdateCh:
 			// Fast path for control value updates - send directly to clients
 			log.Debug().Interface("controlUpdate", controlUpdate).Msg("Processing fast path control update")
+			exclude, _ := controlUpdate["exclude"].(string)
+			delete(controlUpdate, "exclude")
 			jsonData, err := json.Marshal(controlUpdate)
 			if err != nil {
 				log.Error().Err(err).Msg("Failed to marshal control value update")
 				continue
 			}
-			log.Debug().Int("clientCount", len(s.clients)).Str("json", string(jsonData)).Msg("Sending fast path JSON directly to WebSocket clients")
+			clients := s.snapshotClients()
+			log.Debug().Int("clientCount", len(clients)).Str("json", string(jsonData)).Msg("Sending fast path JSON directly to WebSocket clients")
 			// Send directly to clients (avoid broadcast channel deadlock)
-			for client := range s.clients {
+			for _, client := range clients {
+				if exclude != "" && client.RemoteAddr().String() == exclude {
+					continue
+				}
 				err := client.WriteMessage(websocket.TextMessage, jsonData)
 				if err != nil {
 					log.Error().Err(err).Msg("Failed to send fast path message to client")
 					client.Close()
-					delete(s.clients, client)
+					s.removeClient(client)
 				} else {
 					log.Debug().Msg("Successfully sent fast path message to WebSocket client")
 				}
 			}
+		case entry := <-s.historyCh:
+			// Stream a recent action to clients subscribed to the history
+			jsonData, err := json.Marshal(map[string]interface{}{
+				"type":  "historyEntry",
+				"entry": entry,
+			})
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to marshal history entry")
+				continue
+			}
+			for _, client := range s.snapshotHistoryClients() {
+				if err := client.WriteMessage(websocket.TextMessage, jsonData); err != nil {
+					log.Error().Err(err).Msg("Failed to send history entry to client")
+					client.Close()
+					s.removeClient(client)
+				}
+			}
 		case update := <-s.configUpdateCh:
 			// Handle config updates
 			log.Debug().Interface("update", update).Msg("Config updated, notifying clients")
@@ -646,62 +1426,582 @@ func (s *WebUIServer) handleBroadcasts() {
 	}
 }
 
-// monitorAudioSources periodically fetches audio sources and broadcasts them to clients
+// monitorAudioSources fetches audio sources and broadcasts them to clients when
+// PulseAudio or the configuration report a change, or periodically if stream
+// events are unavailable. It is suspended while no clients are connected and
+// resumes with an immediate refresh as soon as the first client connects.
 func (s *WebUIServer) monitorAudioSources() {
-	ticker := time.NewTicker(2 * time.Second) // Poll every 2s for structural changes (new/removed audio sources)
+	pollInterval := s.pollInterval()
+
+	ticker := time.NewTicker(pollInterval) // Poll for structural changes (new/removed audio sources)
 	defer ticker.Stop()
 
 	// Store previous state as a hash of the JSON message
 	var prevStateHash string
 
 	for {
+		if s.clientCount() == 0 {
+			// Nobody is watching - stop polling PulseAudio until a client shows up
+			ticker.Stop()
+			log.Debug().Msg("No WebSocket clients connected, suspending audio source polling")
+
+			select {
+			case <-s.clientWake:
+			case <-s.stopChan:
+				return
+			}
+
+			log.Debug().Dur("interval", pollInterval).Msg("WebSocket client connected, resuming audio source polling")
+			prevStateHash = "" // Always send a fresh state on resume
+			s.pollAudioSources(&prevStateHash)
+			pollInterval = s.pollInterval()
+			ticker.Reset(pollInterval)
+			continue
+		}
+
 		select {
-		case <-ticker.C:
-			// Get current UI state message (exclude control values - fast path handles those)
-			jsonData, err := s.buildUIStateMessage(false) // Only structural changes
-			if err != nil {
-				log.Error().Err(err).Msg("Failed to marshal audio sources and assignments")
+		case <-s.stateChanges:
+			if s.clientCount() == 0 {
 				continue
 			}
-
-			// Calculate hash of the current state
-			currentStateHash := fmt.Sprintf("%x", jsonData)
-			
-			// Check if anything has changed
-			if prevStateHash == currentStateHash {
-				// Nothing changed, skip the update
+			s.pollAudioSources(&prevStateHash)
+		case <-ticker.C:
+			// With stream events, changes wake up the monitor instead
+			if s.clientCount() == 0 || s.paClient.Monitoring() {
 				continue
 			}
-			
-			// Update previous state hash
-			prevStateHash = currentStateHash
-			
-			// Broadcast to clients
-			log.Debug().Msg("State changed, sending update to clients")
-			s.broadcast <- jsonData
+			s.pollAudioSources(&prevStateHash)
+			// Pick up a changed poll interval
+			if interval := s.pollInterval(); interval != pollInterval {
+				log.Debug().Dur("interval", interval).Msg("Audio source poll interval changed")
+				pollInterval = interval
+				ticker.Reset(pollInterval)
+			}
+		case <-s.clientWake:
+			// Already polling, nothing to do
 		case <-s.stopChan:
 			return
 		}
 	}
 }
 
+// transientTopics are high-rate notifications that leave the sources and
+// assignments alone. Control values have their own messages, buttons and MIDI
+// messages aren't part of the state.
+var transientTopics = map[string]bool{
+	"control.value.updated": true,
+	"control.unmapped":      true,
+	"button.state.updated":  true,
+	"midi.message":          true,
+}
+
+// stateChanged wakes up the audio source monitor for an event that may change
+// the sources or assignments the clients show
+func (s *WebUIServer) stateChanged(data interface{}) {
+	if event, ok := data.(configuration.TopicEvent); ok && transientTopics[event.Topic] {
+		return
+	}
+	// Non-blocking, changes coming in while the state is built are picked up
+	// by the next build
+	select {
+	case s.stateChanges <- struct{}{}:
+	default:
+	}
+}
+
+// audioSources returns the current audio sources with their aliases applied
+func (s *WebUIServer) audioSources() []pulseaudio.AudioSource {
+	sources := s.paClient.GetAudioSources()
+	config := s.configManager.GetConfigSnapshot()
+	for i, source := range sources {
+		if alias := config.Alias(configuration.PulseAudioTargetType(source.Type), source.RawName); alias != "" {
+			sources[i].Name = alias
+		}
+	}
+	return sources
+}
+
+// resolveSourceId returns the type and real name of an active audio source
+// or of a virtual "type:name[:binaryName]" ID
+func (s *WebUIServer) resolveSourceId(sourceId string) (configuration.PulseAudioTargetType, string, bool) {
+	for _, source := range s.paClient.GetAudioSources() {
+		if source.ID == sourceId {
+			return configuration.PulseAudioTargetType(source.Type), source.RawName, true
+		}
+	}
+	parts := strings.SplitN(sourceId, ":", 3)
+	if len(parts) < 2 {
+		return "", "", false
+	}
+	sourceType := configuration.PulseAudioTargetType(parts[0])
+	config := s.configManager.GetConfigSnapshot()
+	return sourceType, config.ResolveAlias(sourceType, parts[1]), true
+}
+
+// pollInterval returns the configured audio source poll interval
+func (s *WebUIServer) pollInterval() time.Duration {
+	if interval := s.configManager.GetConfigSnapshot().WebUI.PollInterval; interval > 0 {
+		return interval
+	}
+	return configuration.DefaultPollInterval
+}
+
+// pollAudioSources builds the current UI state and broadcasts it if it changed
+func (s *WebUIServer) pollAudioSources(prevStateHash *string) {
+	// Get current UI state message (exclude control values - fast path handles those)
+	jsonData, err := s.buildUIStateMessage(false) // Only structural changes
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal audio sources and assignments")
+		return
+	}
+
+	// Calculate hash of the current state
+	currentStateHash := fmt.Sprintf("%x", jsonData)
+
+	// Check if anything has changed
+	if *prevStateHash == currentStateHash {
+		// Nothing changed, skip the update
+		return
+	}
+
+	// Update previous state hash
+	*prevStateHash = currentStateHash
+
+	// Broadcast to clients
+	log.Debug().Msg("State changed, sending update to clients")
+	s.BroadcastMessage(jsonData)
+}
+
+// clientConn is the connection of a WebSocket client. Replies of the reader
+// goroutine and broadcasts are written from different goroutines, which the
+// connection doesn't support, so writes take turns.
+type clientConn struct {
+	*websocket.Conn
+	writeMutex sync.Mutex
+}
+
+// WriteMessage writes a message once no other write is in progress. A client
+// that doesn't read within clientWriteTimeout fails the write instead of
+// holding up the others.
+func (c *clientConn) WriteMessage(messageType int, data []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	c.Conn.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+	return c.Conn.WriteMessage(messageType, data)
+}
+
+// addClient registers a WebSocket client and wakes up the audio source monitor
+func (s *WebUIServer) addClient(conn *clientConn) {
+	s.clientsMutex.Lock()
+	s.clients[conn] = true
+	s.clientsMutex.Unlock()
+
+	// Non-blocking wake-up, the monitor only needs to know that someone is there
+	select {
+	case s.clientWake <- struct{}{}:
+	default:
+	}
+}
+
+// removeClient unregisters a WebSocket client
+func (s *WebUIServer) removeClient(conn *clientConn) {
+	s.clientsMutex.Lock()
+	delete(s.clients, conn)
+	delete(s.historyClients, conn)
+	s.clientsMutex.Unlock()
+}
+
+// clientCount returns the number of connected WebSocket clients
+func (s *WebUIServer) clientCount() int {
+	s.clientsMutex.RLock()
+	defer s.clientsMutex.RUnlock()
+	return len(s.clients)
+}
+
+// ClientInfo describes a connected WebSocket client
+type ClientInfo struct {
+	Remote  string // Address of the client
+	History bool   // Whether it receives recent actions live
+}
+
+// Clients returns the connected WebSocket clients
+func (s *WebUIServer) Clients() []ClientInfo {
+	s.clientsMutex.RLock()
+	defer s.clientsMutex.RUnlock()
+	clients := make([]ClientInfo, 0, len(s.clients))
+	for client := range s.clients {
+		clients = append(clients, ClientInfo{Remote: client.RemoteAddr().String(), History: s.historyClients[client]})
+	}
+	return clients
+}
+
+// snapshotClients returns the currently connected WebSocket clients
+func (s *WebUIServer) snapshotClients() []*clientConn {
+	s.clientsMutex.RLock()
+	defer s.clientsMutex.RUnlock()
+	clients := make([]*clientConn, 0, len(s.clients))
+	for client := range s.clients {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// snapshotHistoryClients returns a copy of the clients subscribed to the action history
+func (s *WebUIServer) snapshotHistoryClients() []*clientConn {
+	s.clientsMutex.RLock()
+	defer s.clientsMutex.RUnlock()
+	clients := make([]*clientConn, 0, len(s.historyClients))
+	for client := range s.historyClients {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// notifyHistoryEntry queues a recent action for subscribed clients
+func (s *WebUIServer) notifyHistoryEntry(entry activity.Entry) {
+	// Non-blocking send so recording an action never waits on the web UI
+	select {
+	case s.historyCh <- entry:
+	default:
+	}
+}
+
+// handleHistory returns the most recent actions as JSON, optionally limited by ?limit=N
+func (s *WebUIServer) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := 0
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		parsed, err := strconv.Atoi(limitParam)
+		if err != nil || parsed < 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled": s.executor.Activity().Enabled(),
+		"entries": s.executor.Activity().Entries(limit),
+	}); err != nil {
+		log.Error().Err(err).Msg("Failed to write history response")
+	}
+}
+
+// handleEffectiveConfig returns the running configuration as YAML, or with
+// ?diff=1 only its differences from the file on disk
+func (s *WebUIServer) handleEffectiveConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := s.configManager.Path()
+	result := configuration.LoadResult{
+		Config:     s.configManager.GetConfigSnapshot(),
+		Path:       path,
+		SourcePath: path,
+	}
+	result.FromVersion = result.Config.Version
+	if content, err := os.ReadFile(path); err == nil {
+		result.Content = content
+	}
+
+	var data []byte
+	var err error
+	if diff, _ := strconv.ParseBool(r.URL.Query().Get("diff")); diff {
+		data, err = result.Diff()
+	} else {
+		data, err = result.Dump()
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal effective configuration")
+		http.Error(w, "failed to marshal configuration", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/yaml")
+	if _, err := w.Write(data); err != nil {
+		log.Error().Err(err).Msg("Failed to write effective configuration response")
+	}
+}
+
+// maxSnippetSize limits the body of a profile import
+const maxSnippetSize = 1 << 20
+
+// handleProfileExport returns the profile given by ?name= as a YAML snippet
+func (s *WebUIServer) handleProfileExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := s.configManager.ExportProfile(r.URL.Query().Get("name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/yaml")
+	if _, err := w.Write(data); err != nil {
+		log.Error().Err(err).Msg("Failed to write profile export response")
+	}
+}
+
+// handleProfileImport adds the profile of the YAML snippet in the request
+// body, named ?name= if given, and returns the name it was added as
+func (s *WebUIServer) handleProfileImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSnippetSize))
+	if err != nil {
+		http.Error(w, "failed to read snippet", http.StatusBadRequest)
+		return
+	}
+	name, err := s.configManager.ImportProfile(data, r.URL.Query().Get("name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Clients list the profiles from the state
+	s.BroadcastState()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"name": name,
+	}); err != nil {
+		log.Error().Err(err).Msg("Failed to write profile import response")
+	}
+}
+
+// handleAssignments lists the sources of the sliders and knobs, or of the one
+// given by ?control=, on GET. POST assigns and DELETE unassigns the source of
+// the configuration.AssignmentRequest in the body, returning the change.
+func (s *WebUIServer) handleAssignments(w http.ResponseWriter, r *http.Request) {
+	var response interface{}
+	switch r.Method {
+	case http.MethodGet:
+		config := s.configManager.GetConfigSnapshot()
+		controls := config.Assignments()
+		if controlId := r.URL.Query().Get("control"); controlId != "" {
+			assignment, ok := config.Assignment(controlId)
+			if !ok {
+				http.Error(w, fmt.Sprintf("no slider or knob %s", controlId), http.StatusNotFound)
+				return
+			}
+			controls = []configuration.ControlAssignment{assignment}
+		}
+		response = map[string]interface{}{
+			"controls": controls,
+		}
+
+	case http.MethodPost, http.MethodDelete:
+		var request configuration.AssignmentRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSnippetSize)).Decode(&request); err != nil {
+			http.Error(w, "invalid assignment request", http.StatusBadRequest)
+			return
+		}
+		origin := activity.API(r.RemoteAddr)
+		var change configuration.AssignmentChange
+		var err error
+		if r.Method == http.MethodPost {
+			change, err = s.configManager.Assign(request)
+			if err == nil {
+				source := configuration.Source{Type: request.Type, Name: request.Name, BinaryName: request.BinaryName}
+				s.executor.Record(origin, "AssignSource", request.Control, actions.DescribeSource(source))
+			}
+		} else {
+			change, err = s.configManager.Unassign(request)
+			for _, removed := range change.Removed {
+				source := configuration.Source{Type: removed.Type, Name: removed.Name, BinaryName: removed.BinaryName}
+				s.executor.Record(origin, "UnassignSource", request.Control, actions.DescribeSource(source))
+			}
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Clients show the assignments from the state
+		s.BroadcastState()
+		response = change
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Error().Err(err).Msg("Failed to write assignments response")
+	}
+}
+
+// SetIdentifyHandler sets the function used to flash the hardware LEDs of a control
+func (s *WebUIServer) SetIdentifyHandler(handler func(controlType string, controlId string) error) {
+	s.identifyHandler = handler
+}
+
+// BroadcastState sends the full UI state including control values to all connected clients
+func (s *WebUIServer) BroadcastState() {
+	jsonData, err := s.buildUIStateMessage(true)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal UI state")
+		return
+	}
+	s.BroadcastMessage(jsonData)
+}
+
 // BroadcastMessage sends a message to all connected clients
 func (s *WebUIServer) BroadcastMessage(message []byte) {
-	s.broadcast <- message
+	// Nobody receives anymore after Shutdown
+	select {
+	case s.broadcast <- message:
+	case <-s.stopChan:
+	}
 }
 
 // NotifyConfigUpdate sends a config update to all connected clients
 func (s *WebUIServer) NotifyConfigUpdate(update interface{}) {
-	s.configUpdateCh <- update
+	select {
+	case s.configUpdateCh <- update:
+	case <-s.stopChan:
+	}
+}
+
+// NotifySourceDuplicate tells all connected clients that a source was
+// assigned to a control while other controls keep it as well
+func (s *WebUIServer) NotifySourceDuplicate(controlType, controlId string, source configuration.Source, otherControls []string) {
+	config := s.configManager.GetConfigSnapshot()
+	jsonData, err := json.Marshal(SourceDuplicate{
+		Type:          "sourceDuplicate",
+		ControlType:   controlType,
+		ControlId:     controlId,
+		SourceName:    sourceDisplayName(&config, source.Type, source.Name),
+		OtherControls: otherControls,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal duplicate source warning")
+		return
+	}
+	s.BroadcastMessage(jsonData)
+}
+
+// NotifySourceAdded tells all connected clients that a stream appeared,
+// with the controls whose volume was applied to it, and sends the new state
+func (s *WebUIServer) NotifySourceAdded(sourceType configuration.PulseAudioTargetType, name string, binaryName string, controls []string) {
+	config := s.configManager.GetConfigSnapshot()
+	jsonData, err := json.Marshal(SourceAdded{
+		Type:       "sourceAdded",
+		SourceType: sourceType,
+		SourceName: sourceDisplayName(&config, sourceType, name),
+		BinaryName: binaryName,
+		Controls:   controls,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal added source")
+		return
+	}
+	s.BroadcastMessage(jsonData)
+	s.BroadcastState()
+}
+
+// ackSimulated tells a client in dry-run mode that the PulseAudio changes of
+// its request were only logged. Outside of dry-run mode it sends nothing.
+func (s *WebUIServer) ackSimulated(conn *clientConn, request string, id string) error {
+	if !s.paClient.DryRun() {
+		return nil
+	}
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"type":      "ack",
+		"request":   request,
+		"id":        id,
+		"simulated": true,
+	})
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(websocket.TextMessage, jsonData)
+}
+
+// ackFailed tells a client that the PulseAudio changes of its request
+// failed, like when PulseAudio did not answer in time
+func (s *WebUIServer) ackFailed(conn *clientConn, request string, id string, failure error) error {
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"type":    "ack",
+		"request": request,
+		"id":      id,
+		"error":   failure.Error(),
+	})
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(websocket.TextMessage, jsonData)
+}
+
+// NotifySaveStatus tells all connected clients that saving the configuration
+// failed or works again after failing
+func (s *WebUIServer) NotifySaveStatus(ok bool, message string) {
+	jsonData, err := json.Marshal(ConfigSaveStatus{
+		Type:  "configSaveStatus",
+		Ok:    ok,
+		Error: message,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal save status")
+		return
+	}
+	s.BroadcastMessage(jsonData)
+}
+
+// NotifyMidiStatus tells all connected clients, and those connecting later,
+// whether the MIDI device is connected
+func (s *WebUIServer) NotifyMidiStatus(status MidiStatus) {
+	s.midiStatusMutex.Lock()
+	s.midiStatus = &status
+	s.midiStatusMutex.Unlock()
+	jsonData, err := json.Marshal(status)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal MIDI status")
+		return
+	}
+	s.BroadcastMessage(jsonData)
+}
+
+// NotifyControlMutedUpdate sends the muted state of a control to all connected clients
+func (s *WebUIServer) NotifyControlMutedUpdate(controlType, controlId string, muted bool) {
+	jsonData, err := json.Marshal(ControlMutedUpdate{
+		Type:        "controlMutedUpdate",
+		ControlType: controlType,
+		ControlId:   controlId,
+		Muted:       muted,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal mute update")
+		return
+	}
+	s.BroadcastMessage(jsonData)
 }
 
 // NotifyControlValueUpdate sends a fast control value update to all connected clients
-func (s *WebUIServer) NotifyControlValueUpdate(controlType, controlId string, value int) {
+func (s *WebUIServer) NotifyControlValueUpdate(controlType, controlId string, value int, origin activity.Origin) {
 	update := map[string]interface{}{
 		"type":        "controlValueUpdate",
 		"controlType": controlType,
 		"controlId":   controlId,
 		"value":       value,
+		"origin":      origin.Kind,
+	}
+	if origin.Kind == activity.OriginWeb {
+		// The client that changed the value already shows it
+		update["exclude"] = origin.Client
 	}
 	
 	// Non-blocking send to avoid slowing down MIDI processing
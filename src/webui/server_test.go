@@ -0,0 +1,59 @@
+package webui
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0h41/pulsekontrol/src/actions"
+	"github.com/0h41/pulsekontrol/src/activity"
+	"github.com/0h41/pulsekontrol/src/configuration"
+	"github.com/0h41/pulsekontrol/src/internal/testutil"
+)
+
+func TestServeOnListener(t *testing.T) {
+	configManager := configuration.NewConfigManager(configuration.GetDefaultConfig(), "")
+	configManager.SetReadOnly(true)
+	backend := testutil.NewFakeBackend()
+	executor := actions.NewExecutor(backend, configManager, activity.NewLog(10))
+	t.Cleanup(executor.Close)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The address is not listened on with a listener
+	s := NewWebUIServer("127.0.0.1:1", backend, configManager, executor)
+	s.SetListener(listener)
+	served := make(chan error, 1)
+	go func() { served <- s.Start() }()
+
+	response, err := (&http.Client{Timeout: 5 * time.Second}).Get("http://" + listener.Addr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(response.Body)
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK || !strings.Contains(string(page), "<html") {
+		t.Errorf("served %s:\n%.200s", response.Status, page)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-served:
+		if !errors.Is(err, http.ErrServerClosed) {
+			t.Errorf("serving ended with %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("still serving after shutdown")
+	}
+}
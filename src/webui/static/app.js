@@ -5,6 +5,15 @@ const knobsContainer = document.getElementById('knobs-container');
 const sourcesContainer = document.getElementById('sources-container');
 const serverUrl = document.getElementById('server-url');
 const statusMessage = document.getElementById('status-message');
+const scenesList = document.getElementById('scenes-list');
+const sceneNameInput = document.getElementById('scene-name');
+const sceneSaveButton = document.getElementById('scene-save');
+const sceneVolumesCheckbox = document.getElementById('scene-volumes');
+const profileSelect = document.getElementById('profile-select');
+const readOnlyStatus = document.getElementById('read-only-status');
+const dryRunStatus = document.getElementById('dry-run-status');
+const defaultOutputStatus = document.getElementById('default-output-status');
+const midiStatus = document.getElementById('midi-status');
 
 // WebSocket Connection
 let socket = null;
@@ -16,7 +25,9 @@ const RECONNECT_INTERVAL = 3000; // 3 seconds
 function connectWebSocket() {
     // Determine WebSocket URL (same host, different protocol)
     const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
-    const wsUrl = `${protocol}//${window.location.host}/ws`;
+    // Pass on the auth token from the page URL, e.g. http://host:6080/?token=secret
+    const token = new URLSearchParams(window.location.search).get('token');
+    const wsUrl = `${protocol}//${window.location.host}/ws` + (token ? `?token=${encodeURIComponent(token)}` : '');
     
     connectionStatus.textContent = 'Connecting...';
     connectionStatus.className = 'connecting';
@@ -29,7 +40,7 @@ function connectWebSocket() {
     socket.addEventListener('open', (event) => {
         connectionStatus.textContent = 'Connected';
         connectionStatus.className = 'connected';
-        serverUrl.textContent = wsUrl;
+        serverUrl.textContent = wsUrl.split('?')[0];
         statusMessage.textContent = 'Connected to server';
         reconnectAttempts = 0;
         console.log('Connected to WebSocket server');
@@ -123,14 +134,172 @@ function handleServerMessage(data) {
                         }
                         
                         if (valueLabel) {
-                            valueLabel.textContent = value;
+                            valueLabel.textContent = valueText(knob, value);
                         }
                     }
                 }
             }
             break;
             
+        case 'controlMutedUpdate':
+            // Muted state of a control changed
+            const controls = data.controlType === 'slider' ? appState.sliderControls : appState.knobControls;
+            const mutedControl = controls.find(c => c.id === data.controlId);
+            if (mutedControl) {
+                mutedControl.muted = data.muted;
+                const controlDiv = document.getElementById(data.controlId);
+                if (controlDiv) {
+                    setMutedState(controlDiv, data.muted);
+                }
+            }
+            break;
+            
+        case 'assignControlResult':
+            // Sent when an assignment was refused or other controls had the source
+            if (!data.ok) {
+                statusMessage.textContent = data.error || `Cannot assign to ${data.controlId}`;
+            } else if (data.conflicts && data.conflicts.length > 0) {
+                const others = [...new Set(data.conflicts.map(c => c.controlId))].join(', ');
+                if (data.policy === 'move') {
+                    statusMessage.textContent = `Moved source from ${others} to ${data.controlId}`;
+                } else {
+                    statusMessage.textContent = `Source is also assigned to ${others}, both set its volume`;
+                }
+            }
+            break;
+            
+        case 'midiStatus':
+            // The MIDI device was not found or disconnected, or is back
+            updateMidiStatus(data);
+            if (data.connected) {
+                statusMessage.textContent = `MIDI device ${data.device} connected`;
+            } else {
+                statusMessage.textContent = `MIDI device ${data.device} is not connected, waiting for it`;
+            }
+            break;
+            
+        case 'configSaveStatus':
+            // Saving the configuration failed and is being retried, or works again
+            if (data.ok) {
+                statusMessage.textContent = 'Configuration saved again';
+            } else {
+                statusMessage.textContent = `Cannot save configuration, retrying: ${data.error}`;
+            }
+            break;
+            
+        case 'sourceDuplicate':
+            // A source was assigned to a control while others keep it too
+            statusMessage.textContent = `${data.sourceName} is assigned to ${data.controlId} and ${(data.otherControls || []).join(', ')}`;
+            break;
+            
+        case 'ack':
+            // In dry-run mode, changes to PulseAudio are only logged
+            if (data.error) {
+                statusMessage.textContent = `${data.request} of ${data.id} failed: ${data.error}`;
+            } else if (data.simulated) {
+                statusMessage.textContent = `Dry run: ${data.request} of ${data.id} was not applied`;
+            }
+            break;
+            
+        case 'sourceAdded':
+            // A program started playing or recording, assigned controls set its volume
+            if (data.controls && data.controls.length > 0) {
+                statusMessage.textContent = `${data.sourceName} appeared, volume set by ${data.controls.join(', ')}`;
+            }
+            break;
+            
+        case 'identifyControlResult':
+            // Reply to an identify request
+            if (data.ok) {
+                statusMessage.textContent = `Flashing LEDs for ${data.controlId}`;
+            } else {
+                statusMessage.textContent = data.error || `Cannot identify ${data.controlId}`;
+            }
+            break;
+            
+        case 'sceneResult':
+            // Reply to a save/recall/delete scene request
+            if (data.ok) {
+                const verbs = { saveScene: 'saved', recallScene: 'recalled', deleteScene: 'deleted' };
+                statusMessage.textContent = `Scene "${data.name}" ${verbs[data.action]}`;
+            } else {
+                statusMessage.textContent = data.error || `Scene "${data.name}" failed`;
+            }
+            if (data.scenes) {
+                renderScenes(data.scenes);
+            }
+            break;
+            
+        case 'combinedSinkResult':
+            // Reply to creating or removing a combined output device
+            if (data.ok) {
+                statusMessage.textContent = `Combined output "${data.name}" ${data.action === 'createCombinedSink' ? 'created' : 'removed'}`;
+            } else {
+                statusMessage.textContent = data.error || `Combined output "${data.name}" failed`;
+            }
+            appState.combinedSinks = data.combinedSinks || [];
+            break;
+            
+        case 'profileResult':
+            // Reply to a profile request
+            if (data.ok) {
+                const verbs = { switchProfile: 'activated', createProfile: 'created', deleteProfile: 'deleted', copyProfile: 'created' };
+                statusMessage.textContent = `Profile "${data.name}" ${verbs[data.action]}`;
+            } else {
+                statusMessage.textContent = data.error || `Profile "${data.name}" failed`;
+            }
+            renderProfiles(data.profiles, data.activeProfile);
+            break;
+            
+        case 'moveControlResult':
+            // Reply to moving or swapping the sources of two controls
+            if (data.ok) {
+                statusMessage.textContent = data.swap ? `Swapped ${data.fromId} and ${data.toId}` : `Moved ${data.fromId} to ${data.toId}`;
+            } else {
+                statusMessage.textContent = data.error;
+            }
+            break;
+            
+        case 'historyResult':
+            // Reply to an undo/redo request
+            if (data.ok) {
+                statusMessage.textContent = `${data.action === 'undo' ? 'Undid' : 'Redid'}: ${data.description}`;
+            } else {
+                statusMessage.textContent = data.error;
+            }
+            break;
+            
+        case 'staleSourcesResult':
+            // Reply to a stale source listing or cleanup
+            if (data.action === 'removeStaleSources') {
+                statusMessage.textContent = `Removed ${data.sources.length} stale sources`;
+            } else if (data.sources.length === 0) {
+                statusMessage.textContent = 'No stale sources';
+            } else {
+                const list = data.sources.map(s => `${s.controlId} (${s.profile}): ${s.sourceName}, last seen ${daysAgo(s.lastSeen)}`);
+                if (confirm(`Unassign these sources?\n\n${list.join('\n')}`)) {
+                    sendMessage({ type: 'removeStaleSources' });
+                }
+            }
+            break;
+            
         case 'audioSourcesUpdate':
+            // Changes of read-only instances are lost on restart
+            readOnlyStatus.hidden = !data.readOnly;
+            dryRunStatus.hidden = !data.dryRun;
+            if (data.midiStatus) {
+                updateMidiStatus(data.midiStatus);
+            }
+            if (data.scenes) {
+                renderScenes(data.scenes);
+            }
+            if (data.combinedSinks) {
+                appState.combinedSinks = data.combinedSinks;
+            }
+            if (data.profiles) {
+                renderProfiles(data.profiles, data.activeProfile);
+            }
+            
             // Update both sources and assignments if provided
             if (data.sliderAssignments && data.knobAssignments) {
                 appState.sliderAssignments = data.sliderAssignments;
@@ -156,6 +325,37 @@ function handleServerMessage(data) {
                 });
             }
             
+            appState.aliases = data.aliases || {};
+            appState.lastSeen = data.lastSeen || {};
+            
+            // Update muted states
+            if (data.sliderMuted) {
+                appState.sliderControls.forEach(slider => {
+                    slider.muted = !!data.sliderMuted[slider.id];
+                });
+            }
+            if (data.knobMuted) {
+                appState.knobControls.forEach(knob => {
+                    knob.muted = !!data.knobMuted[knob.id];
+                });
+            }
+            
+            // Display names of the controls
+            const sliderLabels = data.sliderLabels || {};
+            const sliderGroups = data.sliderGroups || {};
+            appState.sliderControls.forEach(slider => {
+                slider.label = sliderLabels[slider.id] || '';
+                slider.groups = sliderGroups[slider.id] || [];
+            });
+            const knobLabels = data.knobLabels || {};
+            const knobGroups = data.knobGroups || {};
+            const knobBalance = data.knobBalance || {};
+            appState.knobControls.forEach(knob => {
+                knob.label = knobLabels[knob.id] || '';
+                knob.groups = knobGroups[knob.id] || [];
+                knob.balance = !!knobBalance[knob.id];
+            });
+            
             updateAudioSources(data.sources);
             break;
             
@@ -169,8 +369,11 @@ function handleServerMessage(data) {
 // Application state
 const appState = {
     audioSources: [],
+    aliases: {},           // "type:name" -> display name
+    lastSeen: {},          // Source ID -> when the source was last present
     sliderAssignments: {}, // Control ID -> Array of Source IDs
     knobAssignments: {},   // Control ID -> Array of Source IDs
+    combinedSinks: [],     // Names of the combined output devices
     sliderControls: [
         { id: "slider1", value: 50 },
         { id: "slider2", value: 50 },
@@ -202,6 +405,7 @@ function updateAudioSources(sources) {
     
     // Update state
     appState.audioSources = sources;
+    updateDefaultOutput(sources);
     
     // Clear all containers before updating
     slidersContainer.innerHTML = '';
@@ -286,7 +490,7 @@ function updateAudioSources(sources) {
     } else {
         unassignedSources.forEach(source => {
             const sourceDiv = document.createElement('div');
-            sourceDiv.className = 'mixer-channel source';
+            sourceDiv.className = sourceClassName('mixer-channel source', source);
             sourceDiv.id = `source-${source.id}`;
             sourceDiv.setAttribute('data-source-id', source.id);
             sourceDiv.setAttribute('draggable', 'true');
@@ -303,8 +507,15 @@ function updateAudioSources(sources) {
                 ? `${source.name} (${source.binaryName})` 
                 : source.name;
             label.textContent = displayName;
-            label.title = displayName; // For tooltip on hover
+            label.title = sourceTooltip(source, displayName);
+            label.addEventListener('dblclick', () => renameSource(source.id, source.name, source.rawName));
+            label.addEventListener('contextmenu', e => moveStream(e, source));
+            label.addEventListener('contextmenu', e => removeCombinedSink(e, source));
             sourceDiv.appendChild(label);
+            const sourceToggle = suspendToggle(source);
+            if (sourceToggle) {
+                sourceDiv.appendChild(sourceToggle);
+            }
             
             // Add drag event handlers
             sourceDiv.addEventListener('dragstart', handleDragStart);
@@ -318,6 +529,219 @@ function updateAudioSources(sources) {
     setupDropZones();
 }
 
+// Tooltip of a control number, showing its label and the source groups it
+// controls besides its sources
+function controlTitle(control) {
+    const lines = control.label ? [control.label] : [];
+    if ((control.groups || []).length > 0) {
+        lines.push(`Groups: ${control.groups.join(', ')}`);
+    }
+    return lines.join('\n');
+}
+
+// Classes of a source element, marking muted sources and streams their
+// application paused
+function sourceClassName(base, source) {
+    const classes = [base];
+    if (source.muted) {
+        classes.push('muted-source');
+    }
+    if (source.corked) {
+        classes.push('corked-source');
+    }
+    return classes.join(' ');
+}
+
+// Tooltip of a source label, showing the real name behind an alias and the
+// volume PulseAudio reports
+function sourceTooltip(source, displayName) {
+    const lines = [displayName];
+    if (source.rawName && source.rawName !== source.name) {
+        lines.push(source.rawName);
+    }
+    // Unavailable sources have no volume
+    if (source.volume !== undefined) {
+        lines.push(source.muted ? `Volume ${source.volume}% (muted)` : `Volume ${source.volume}%`);
+    }
+    if (source.default) {
+        lines.push(source.type === 'OutputDevice' ? 'Default output' : 'Default input');
+    }
+    if (source.suspended) {
+        lines.push('Suspended');
+    }
+    if (source.corked) {
+        lines.push('Paused by the application');
+    }
+    lines.push('Double-click to rename');
+    if (source.type === 'PlaybackStream') {
+        lines.push('Right-click to play on another output');
+    }
+    if (isCombinedSink(source)) {
+        lines.push('Right-click to remove this combined output');
+    }
+    return lines.join('\n');
+}
+
+// Show which output device is the default, it changes with SetDefaultOutput
+// and CycleDefaultOutput buttons
+function updateDefaultOutput(sources) {
+    const output = sources.find(s => s.type === 'OutputDevice' && s.default);
+    defaultOutputStatus.hidden = !output;
+    defaultOutputStatus.textContent = output ? `Output: ${output.name}` : '';
+}
+
+// Show that the MIDI device is missing, the web interface is the only mixer then
+function updateMidiStatus(status) {
+    midiStatus.hidden = status.connected;
+    midiStatus.title = status.error || '';
+}
+
+// Describe how long ago a timestamp was, like "43 days ago"
+function daysAgo(timestamp) {
+    const days = Math.floor((Date.now() - new Date(timestamp).getTime()) / 86400000);
+    if (days <= 0) {
+        return 'today';
+    }
+    return days === 1 ? 'yesterday' : `${days} days ago`;
+}
+
+// Ask for a new display name of a source, an empty name removes the alias
+function renameSource(sourceId, currentName, rawName) {
+    const alias = prompt(`Display name for ${rawName} (empty to reset):`, currentName === rawName ? '' : currentName);
+    if (alias === null) {
+        return;
+    }
+    sendMessage({
+        type: 'setAlias',
+        sourceId: sourceId,
+        alias: alias.trim()
+    });
+}
+
+// Ask on which output device a playback stream should play and move it there
+function moveStream(event, source) {
+    if (source.type !== 'PlaybackStream') {
+        return;
+    }
+    event.preventDefault();
+    const outputs = appState.audioSources.filter(s => s.type === 'OutputDevice');
+    if (outputs.length === 0) {
+        return;
+    }
+    const choices = outputs.map((output, i) => `${i + 1}. ${output.name}`).join('\n');
+    const answer = prompt(`Play ${source.name} on which output?\n${choices}`);
+    const output = outputs[parseInt(answer, 10) - 1];
+    if (!output) {
+        return;
+    }
+    sendMessage({
+        type: 'moveStream',
+        sourceId: source.id,
+        sink: output.rawName
+    });
+}
+
+// Button suspending an output device, so a USB DAC or amplifier can power
+// down, or resuming it. Other sources have none.
+function suspendToggle(source) {
+    if (source.type !== 'OutputDevice') {
+        return null;
+    }
+    const button = document.createElement('button');
+    button.className = source.suspended ? 'suspend-toggle suspended' : 'suspend-toggle';
+    button.textContent = '⏻';
+    button.title = source.suspended ? 'Suspended, click to resume' : 'Click to suspend';
+    button.addEventListener('click', () => sendMessage({
+        type: 'setSuspended',
+        sourceId: source.id,
+        suspended: !source.suspended
+    }));
+    return button;
+}
+
+// Whether a source is an output device pulsekontrol combined from others
+function isCombinedSink(source) {
+    return source.type === 'OutputDevice' && appState.combinedSinks.includes(source.rawName);
+}
+
+// Ask which output devices to combine into a new one, and its name
+function combineOutputs() {
+    const outputs = appState.audioSources.filter(s => s.type === 'OutputDevice' && !isCombinedSink(s));
+    if (outputs.length < 2) {
+        statusMessage.textContent = 'Combining needs at least two output devices';
+        return;
+    }
+    const choices = outputs.map((output, i) => `${i + 1}. ${output.name}`).join('\n');
+    const answer = prompt(`Numbers of the outputs to play on, like 1 3\n${choices}`);
+    if (!answer) {
+        return;
+    }
+    const selected = answer.split(/[\s,]+/).map(n => outputs[parseInt(n, 10) - 1]).filter(o => o);
+    if (selected.length === 0) {
+        return;
+    }
+    const name = prompt('Name of the combined output', selected.map(o => o.name).join(' + '));
+    if (!name) {
+        return;
+    }
+    sendMessage({
+        type: 'createCombinedSink',
+        name: name,
+        outputs: selected.map(o => o.rawName)
+    });
+}
+
+// Ask whether to remove a combined output device
+function removeCombinedSink(event, source) {
+    if (!isCombinedSink(source)) {
+        return;
+    }
+    event.preventDefault();
+    if (confirm(`Remove the combined output ${source.rawName}?`)) {
+        sendMessage({ type: 'deleteCombinedSink', name: source.rawName });
+    }
+}
+
+// Dragging the number of a control onto the number of another swaps their
+// sources, holding Shift while dropping moves them instead
+function makeControlMovable(controlNumber, controlId, controlType) {
+    controlNumber.draggable = true;
+    controlNumber.addEventListener('dragstart', e => {
+        e.stopPropagation();
+        e.dataTransfer.effectAllowed = 'move';
+        e.dataTransfer.setData('control-move', `${controlType}:${controlId}`);
+    });
+    controlNumber.addEventListener('dragover', e => {
+        if (e.dataTransfer.types.includes('control-move')) {
+            e.preventDefault();
+        }
+    });
+    controlNumber.addEventListener('drop', e => {
+        const moved = e.dataTransfer.getData('control-move');
+        if (!moved) {
+            return;
+        }
+        e.preventDefault();
+        e.stopPropagation();
+        document.querySelectorAll('.drop-target').forEach(item => {
+            item.classList.remove('drop-target');
+        });
+        const [fromType, fromId] = moved.split(':');
+        if (fromType === controlType && fromId === controlId) {
+            return;
+        }
+        sendMessage({
+            type: 'moveControl',
+            fromType: fromType,
+            fromId: fromId,
+            toType: controlType,
+            toId: controlId,
+            swap: !e.shiftKey,
+            moveValues: false
+        });
+    });
+}
+
 function renderControlWithSources(controlDiv, control, assignedSourceIds, availableSources) {
     // Add control visualization based on type
     if (controlDiv.getAttribute('data-control-type') === 'slider') {
@@ -331,7 +755,14 @@ function renderControlWithSources(controlDiv, control, assignedSourceIds, availa
     const controlNumber = document.createElement('div');
     controlNumber.textContent = control.id.replace('slider', '').replace('knob', '');
     controlNumber.className = 'control-number';
+    if (control.label || (control.groups || []).length > 0) {
+        controlNumber.title = controlTitle(control);
+    }
+    makeControlMovable(controlNumber, control.id, controlDiv.getAttribute('data-control-type'));
     controlVisual.insertBefore(controlNumber, controlVisual.firstChild);
+    controlVisual.appendChild(createMuteButton(control, controlDiv.getAttribute('data-control-type')));
+    controlVisual.appendChild(createIdentifyButton(control.id, controlDiv.getAttribute('data-control-type')));
+    showSourcesVolume(controlDiv, control, availableSources);
     
     // Add sources list - also a drop zone
     const sourcesList = document.createElement('div');
@@ -348,7 +779,7 @@ function renderControlWithSources(controlDiv, control, assignedSourceIds, availa
     // Render available sources first (those that exist in current audio sources)
     availableSources.forEach(source => {
         const sourceItem = document.createElement('div');
-        sourceItem.className = 'source-item';
+        sourceItem.className = sourceClassName('source-item', source);
         sourceItem.setAttribute('draggable', 'true');
         sourceItem.setAttribute('data-source-id', source.id);
         sourceItem.setAttribute('data-parent-control', control.id);
@@ -370,8 +801,15 @@ function renderControlWithSources(controlDiv, control, assignedSourceIds, availa
             ? `${source.name} (${source.binaryName})` 
             : source.name;
         sourceName.textContent = displayName;
-        sourceName.title = displayName; // For tooltip on hover
+        sourceName.title = sourceTooltip(source, displayName);
+        sourceName.addEventListener('dblclick', () => renameSource(source.id, source.name, source.rawName));
+        sourceName.addEventListener('contextmenu', e => moveStream(e, source));
+        sourceName.addEventListener('contextmenu', e => removeCombinedSink(e, source));
         sourceItem.appendChild(sourceName);
+        const sourceToggle = suspendToggle(source);
+        if (sourceToggle) {
+            sourceItem.appendChild(sourceToggle);
+        }
         
         sourcesList.appendChild(sourceItem);
     });
@@ -421,17 +859,23 @@ function renderControlWithSources(controlDiv, control, assignedSourceIds, availa
         
         // Add source name with enhanced display if binary name exists
         const sourceNameElement = document.createElement('span');
+        const rawName = sourceName;
+        const shownName = appState.aliases[`${sourceType}:${rawName}`] || rawName;
         const displayName = sourceBinaryName && sourceBinaryName !== '' 
-            ? `${sourceName} (${sourceBinaryName})` 
-            : sourceName;
+            ? `${shownName} (${sourceBinaryName})` 
+            : shownName;
         sourceNameElement.textContent = displayName;
-        sourceNameElement.title = displayName; // For tooltip on hover
+        sourceNameElement.title = sourceTooltip({ name: shownName, rawName: rawName }, displayName);
+        sourceNameElement.addEventListener('dblclick', () => renameSource(sourceId, shownName, rawName));
         sourceItem.appendChild(sourceNameElement);
         
         // Add missing indicator
         const missingIndicator = document.createElement('span');
         missingIndicator.className = 'missing-indicator';
         missingIndicator.textContent = ' X';
+        if (appState.lastSeen[sourceId]) {
+            missingIndicator.title = `Last seen ${daysAgo(appState.lastSeen[sourceId])}`;
+        }
         sourceItem.appendChild(missingIndicator);
         
         sourcesList.appendChild(sourceItem);
@@ -440,6 +884,42 @@ function renderControlWithSources(controlDiv, control, assignedSourceIds, availa
     controlDiv.appendChild(sourcesList);
 }
 
+// Text of the value of a control, balance knobs show the side like L40
+function valueText(control, value) {
+    if (!control.balance) {
+        return value;
+    }
+    if (value === 50) {
+        return 'C';
+    }
+    return value < 50 ? `L${(50 - value) * 2}` : `R${(value - 50) * 2}`;
+}
+
+// Shows the volume the server reports for the sources of a control instead
+// of the stored value of the control, so changes made elsewhere, like in
+// pavucontrol, show up too. With several sources the loudest one is shown.
+function showSourcesVolume(controlDiv, control, availableSources) {
+    if (control.balance) {
+        return;
+    }
+    // Unavailable sources have no volume
+    const volumes = availableSources
+        .map(source => source.volume)
+        .filter(volume => volume !== undefined);
+    if (volumes.length === 0) {
+        return;
+    }
+    const volume = Math.min(100, Math.max(...volumes));
+    const progressFill = controlDiv.querySelector('.progress-fill');
+    const valueLabel = controlDiv.querySelector('.value-label');
+    if (progressFill) {
+        progressFill.style.width = `${volume}%`;
+    }
+    if (valueLabel) {
+        valueLabel.textContent = volume;
+    }
+}
+
 function renderSliderVisualization(controlDiv, control) {
     const controlVisual = document.createElement('div');
     controlVisual.className = 'control-visual';
@@ -456,7 +936,7 @@ function renderSliderVisualization(controlDiv, control) {
     // Create value label
     const valueLabel = document.createElement('span');
     valueLabel.className = 'value-label';
-    valueLabel.textContent = control.value;
+    valueLabel.textContent = valueText(control, control.value);
     
     // NOTE: The sliders are read-only and show the levels set by the MIDI device
     // We don't create range inputs since they should not be adjustable from the web GUI
@@ -467,6 +947,7 @@ function renderSliderVisualization(controlDiv, control) {
     controlVisual.appendChild(valueLabel);
     
     controlDiv.appendChild(controlVisual);
+    setMutedState(controlDiv, control.muted);
 }
 
 // NOTE: We've removed the input handlers for the slider controls since they should be read-only 
@@ -491,7 +972,13 @@ function renderEmptyControl(controlDiv, control) {
     const controlNumber = document.createElement('div');
     controlNumber.textContent = control.id.replace('slider', '').replace('knob', '');
     controlNumber.className = 'control-number';
+    if (control.label || (control.groups || []).length > 0) {
+        controlNumber.title = controlTitle(control);
+    }
+    makeControlMovable(controlNumber, control.id, controlDiv.getAttribute('data-control-type'));
     controlVisual.insertBefore(controlNumber, controlVisual.firstChild);
+    controlVisual.appendChild(createMuteButton(control, controlDiv.getAttribute('data-control-type')));
+    controlVisual.appendChild(createIdentifyButton(control.id, controlDiv.getAttribute('data-control-type')));
     
     // Create content for empty control
     const placeholder = document.createElement('div');
@@ -500,6 +987,171 @@ function renderEmptyControl(controlDiv, control) {
     controlDiv.appendChild(placeholder);
 }
 
+// Create a button that mutes or unmutes the sources of a control
+function createMuteButton(control, controlType) {
+    const button = document.createElement('button');
+    button.className = 'mute-button';
+    button.textContent = 'M';
+    button.title = 'Mute or unmute the sources of this control';
+    button.classList.toggle('active', !!control.muted);
+    button.addEventListener('click', () => {
+        sendMessage({
+            type: 'setMuted',
+            controlId: control.id,
+            controlType: controlType,
+            muted: !control.muted
+        });
+    });
+    return button;
+}
+
+// Show a control as muted or unmuted
+function setMutedState(controlDiv, muted) {
+    controlDiv.classList.toggle('muted', !!muted);
+    const button = controlDiv.querySelector('.mute-button');
+    if (button) {
+        button.classList.toggle('active', !!muted);
+    }
+}
+
+// Create a button that flashes the hardware LEDs of a control
+function createIdentifyButton(controlId, controlType) {
+    const button = document.createElement('button');
+    button.className = 'identify-button';
+    button.textContent = '◉';
+    button.title = 'Flash the LEDs of this control on the device';
+    button.addEventListener('click', () => {
+        sendMessage({
+            type: 'identifyControl',
+            controlId: controlId,
+            controlType: controlType
+        });
+    });
+    return button;
+}
+
+// Render the saved scenes with recall and delete buttons
+function renderScenes(scenes) {
+    scenesList.innerHTML = '';
+    if (scenes.length === 0) {
+        const placeholder = document.createElement('span');
+        placeholder.className = 'scenes-empty';
+        placeholder.textContent = 'No saved scenes';
+        scenesList.appendChild(placeholder);
+        return;
+    }
+    
+    scenes.forEach(name => {
+        const scene = document.createElement('div');
+        scene.className = 'scene-item';
+        
+        const recallButton = document.createElement('button');
+        recallButton.className = 'scene-recall';
+        recallButton.textContent = name;
+        recallButton.title = 'Recall this scene (shift-click to ramp over 2 seconds)';
+        recallButton.addEventListener('click', (e) => {
+            sendMessage({
+                type: 'recallScene',
+                name: name,
+                rampMs: e.shiftKey ? 2000 : 0
+            });
+        });
+        scene.appendChild(recallButton);
+        
+        const deleteButton = document.createElement('button');
+        deleteButton.className = 'scene-delete';
+        deleteButton.textContent = '✕';
+        deleteButton.title = 'Delete this scene';
+        deleteButton.addEventListener('click', () => {
+            if (confirm(`Delete scene "${name}"?`)) {
+                sendMessage({ type: 'deleteScene', name: name });
+            }
+        });
+        scene.appendChild(deleteButton);
+        
+        scenesList.appendChild(scene);
+    });
+}
+
+function saveScene() {
+    const name = sceneNameInput.value.trim();
+    if (name === '') {
+        statusMessage.textContent = 'Enter a scene name first';
+        return;
+    }
+    sendMessage({
+        type: 'saveScene',
+        name: name,
+        includeVolumes: sceneVolumesCheckbox.checked
+    });
+    sceneNameInput.value = '';
+}
+
+sceneSaveButton.addEventListener('click', saveScene);
+sceneNameInput.addEventListener('keydown', (e) => {
+    if (e.key === 'Enter') {
+        saveScene();
+    }
+});
+
+// Render the profile selector
+function renderProfiles(profiles, activeProfile) {
+    profileSelect.innerHTML = '';
+    profiles.forEach(name => {
+        const option = document.createElement('option');
+        option.value = name;
+        option.textContent = name;
+        option.selected = name === activeProfile;
+        profileSelect.appendChild(option);
+    });
+}
+
+profileSelect.addEventListener('change', () => {
+    sendMessage({ type: 'switchProfile', name: profileSelect.value });
+});
+
+document.getElementById('profile-new').addEventListener('click', () => {
+    const name = prompt('Name of the new profile');
+    if (name) {
+        sendMessage({ type: 'createProfile', name: name });
+    }
+});
+
+document.getElementById('profile-copy').addEventListener('click', () => {
+    const name = prompt(`Copy profile "${profileSelect.value}" as`);
+    if (name) {
+        sendMessage({ type: 'copyProfile', source: profileSelect.value, name: name });
+    }
+});
+
+document.getElementById('profile-delete').addEventListener('click', () => {
+    const name = prompt('Name of the profile to delete (the active profile cannot be deleted)');
+    if (name) {
+        sendMessage({ type: 'deleteProfile', name: name });
+    }
+});
+
+document.getElementById('stale-cleanup').addEventListener('click', () => {
+    sendMessage({ type: 'getStaleSources' });
+});
+
+document.getElementById('combine-outputs').addEventListener('click', combineOutputs);
+
+// Ctrl-Z undoes the last change, Ctrl-Shift-Z or Ctrl-Y redoes it
+document.addEventListener('keydown', (e) => {
+    if (!(e.ctrlKey || e.metaKey) || e.target.tagName === 'INPUT') {
+        return;
+    }
+    const key = e.key.toLowerCase();
+    if (key === 'z' && !e.shiftKey) {
+        e.preventDefault();
+        sendMessage({ type: 'undo' });
+    } else if ((key === 'z' && e.shiftKey) || key === 'y') {
+        e.preventDefault();
+        sendMessage({ type: 'redo' });
+    }
+});
+
 // Drag and drop functionality
 let draggedItem = null;
 